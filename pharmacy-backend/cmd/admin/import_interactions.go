@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"pharmacy-backend/config"
+	"pharmacy-backend/services"
+
+	"github.com/joho/godotenv"
+)
+
+// RunImportInteractions استيراد قاعدة بيانات التداخلات الدوائية من ملف CSV أو JSON
+func RunImportInteractions(path, format string, replace bool) error {
+	if path == "" {
+		return fmt.Errorf("يجب تحديد الملف عبر -file")
+	}
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var records []services.InteractionRecord
+	switch format {
+	case "csv":
+		records, err = services.ParseInteractionsCSV(file)
+	case "json":
+		records, err = services.ParseInteractionsJSON(file)
+	default:
+		return fmt.Errorf("صيغة غير مدعومة %q (csv أو json)", format)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := godotenv.Load(); err != nil {
+		log.Println("⚠️ Warning: Could not load .env file")
+	}
+	config.ConnectDatabase()
+
+	result, err := services.ImportInteractions(config.DB, records, replace)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✅ تم استيراد %d تداخل دوائي", result.Imported)
+	if replace {
+		fmt.Printf(" (حُذف %d سجل سابق)", result.Removed)
+	}
+	fmt.Println()
+	return nil
+}
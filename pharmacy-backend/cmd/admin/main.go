@@ -11,6 +11,12 @@ func main() {
 	// تعريف الأوامر المتاحة
 	createCmd := flag.NewFlagSet("create", flag.ExitOnError)
 	checkCmd := flag.NewFlagSet("check", flag.ExitOnError)
+	importInteractionsCmd := flag.NewFlagSet("import-interactions", flag.ExitOnError)
+	interactionsFile := importInteractionsCmd.String("file", "", "ملف التداخلات (CSV أو JSON)")
+	interactionsFormat := importInteractionsCmd.String("format", "", "csv أو json (الافتراضي حسب امتداد الملف)")
+	interactionsReplace := importInteractionsCmd.Bool("replace", false, "حذف التداخلات الحالية قبل الاستيراد")
+	reconcileStockCmd := flag.NewFlagSet("reconcile-stock", flag.ExitOnError)
+	reconcileFix := reconcileStockCmd.Bool("fix", false, "تسجيل حركات تسوية تلغي الفروق")
 
 	// التحقق من وجود أمر
 	if len(os.Args) < 2 {
@@ -39,6 +45,26 @@ func main() {
 			log.Fatalf("❌ فشل في فحص المشرف: %v", err)
 		}
 
+	case "import-interactions":
+		err := importInteractionsCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Fatalf("❌ فشل في معالجة الأمر import-interactions: %v", err)
+		}
+		err = RunImportInteractions(*interactionsFile, *interactionsFormat, *interactionsReplace)
+		if err != nil {
+			log.Fatalf("❌ فشل في استيراد التداخلات الدوائية: %v", err)
+		}
+
+	case "reconcile-stock":
+		err := reconcileStockCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Fatalf("❌ فشل في معالجة الأمر reconcile-stock: %v", err)
+		}
+		err = RunReconcileStock(*reconcileFix)
+		if err != nil {
+			log.Fatalf("❌ فشل في مطابقة المخزون: %v", err)
+		}
+
 	default:
 		printUsage()
 		os.Exit(1)
@@ -49,4 +75,8 @@ func printUsage() {
 	fmt.Println("طريقة الاستخدام:")
 	fmt.Println("  create    - لإنشاء حساب مشرف جديد")
 	fmt.Println("  check     - للتحقق من وجود المشرف")
+	fmt.Println("  import-interactions -file <path> [-format csv|json] [-replace]")
+	fmt.Println("            - لاستيراد قاعدة بيانات التداخلات الدوائية")
+	fmt.Println("  reconcile-stock [-fix]")
+	fmt.Println("            - لمطابقة دفتر حركات المخزون مع أرصدة المنتجات وتصحيح الفروق")
 }
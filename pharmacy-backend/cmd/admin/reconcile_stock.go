@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"pharmacy-backend/config"
+	"pharmacy-backend/services"
+
+	"github.com/joho/godotenv"
+)
+
+// RunReconcileStock مقارنة مجموع دفتر حركات المخزون برصيد كل منتج
+// يعرض المنتجات المختلفة فقط، ومع fix يسجل حركة تسوية تلغي كل فرق.
+func RunReconcileStock(fix bool) error {
+	if err := godotenv.Load(); err != nil {
+		log.Println("⚠️ Warning: Could not load .env file")
+	}
+	config.ConnectDatabase()
+
+	result, err := services.ReconcileStock(config.DB, time.Now(), fix, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, row := range result.Drifted {
+		status := ""
+		if row.Fixed {
+			status = " ✅ صُحح"
+		}
+		fmt.Printf("%-20s الدفتر=%d الرصيد=%d المنتهي=%d الفرق=%+d  %s%s\n",
+			row.SKU, row.LedgerQuantity, row.StockQuantity, row.ExpiredQuantity, row.Drift, row.Name, status)
+	}
+	fmt.Printf("✅ فُحص %d منتج، %d منها مختلف عن الدفتر", result.Checked, len(result.Drifted))
+	if fix {
+		fmt.Printf(" وصُحح %d", result.Fixed)
+	} else if len(result.Drifted) > 0 {
+		fmt.Print(" (استخدم -fix لتصحيحها)")
+	}
+	fmt.Println()
+	if fix && result.Fixed < len(result.Drifted) {
+		return fmt.Errorf("تعذر تصحيح %d منتج", len(result.Drifted)-result.Fixed)
+	}
+	return nil
+}
@@ -185,14 +185,19 @@ func AutoMigrate() error {
             id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
             user_id UUID NOT NULL,
             order_number TEXT NOT NULL UNIQUE,
-            status VARCHAR(20) NOT NULL DEFAULT 'pending',
+            status VARCHAR(40) NOT NULL DEFAULT 'pending',
             subtotal DOUBLE PRECISION NOT NULL,
             total_amount DOUBLE PRECISION NOT NULL,
             shipping_cost DOUBLE PRECISION NOT NULL DEFAULT 0,
             tax_amount DOUBLE PRECISION NOT NULL DEFAULT 0,
             discount_amount DOUBLE PRECISION NOT NULL DEFAULT 0,
+            coupon_id UUID,
+            coupon_code TEXT,
+            tracking_number TEXT,
+            shipping_carrier TEXT,
             payment_method TEXT,
             payment_status VARCHAR(20) NOT NULL DEFAULT 'pending',
+            refunded_amount DOUBLE PRECISION NOT NULL DEFAULT 0,
             shipping_address JSONB,
             billing_address JSONB,
             notes TEXT,
@@ -228,7 +233,7 @@ func AutoMigrate() error {
             sku TEXT NOT NULL UNIQUE,
             category_id UUID NOT NULL,
             brand TEXT,
-            stock_quantity INTEGER NOT NULL DEFAULT 0,
+            stock_quantity INTEGER NOT NULL DEFAULT 0 CONSTRAINT chk_products_stock_non_negative CHECK (stock_quantity >= 0),
             min_stock_level INTEGER NOT NULL DEFAULT 5,
             image_url TEXT,
             images JSONB,
@@ -243,6 +248,7 @@ func AutoMigrate() error {
             batch_number TEXT,
             manufacturer TEXT,
             requires_prescription BOOLEAN NOT NULL DEFAULT FALSE,
+            non_returnable BOOLEAN NOT NULL DEFAULT FALSE,
             active_ingredient TEXT,
             dosage_form TEXT,
             strength TEXT,
@@ -342,6 +348,9 @@ func AutoMigrate() error {
             id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
             order_id UUID NOT NULL,
             status TEXT NOT NULL,
+            from_status TEXT,
+            actor_id UUID,
+            shipment_id UUID,
             description TEXT,
             location TEXT,
             timestamp TIMESTAMPTZ NOT NULL,
@@ -400,6 +409,7 @@ func AutoMigrate() error {
             min_order_amount DOUBLE PRECISION NOT NULL DEFAULT 0,
             max_discount_amount DOUBLE PRECISION,
             usage_limit INTEGER,
+            per_user_limit INTEGER,
             used_count INTEGER NOT NULL DEFAULT 0,
             is_active BOOLEAN NOT NULL DEFAULT TRUE,
             valid_from TIMESTAMPTZ NOT NULL,
@@ -497,6 +507,70 @@ func AutoMigrate() error {
         wholesaleUpgradeRequestsTableExists = true
         log.Println("✅ wholesale_upgrade_requests table created successfully")
     }
+    // تعديلات على جداول موجودة مسبقاً (AutoMigrate يتخطى هذه الجداول): أعمدة جديدة وقيود
+    schemaChangesSQL := []string{
+        `ALTER TABLE orders ADD COLUMN IF NOT EXISTS coupon_id UUID;`,
+        `ALTER TABLE orders ADD COLUMN IF NOT EXISTS coupon_code TEXT;`,
+        `ALTER TABLE coupons ADD COLUMN IF NOT EXISTS per_user_limit INTEGER;`,
+        `ALTER TABLE orders ADD COLUMN IF NOT EXISTS tracking_number TEXT;`,
+        `ALTER TABLE orders ADD COLUMN IF NOT EXISTS shipping_carrier TEXT;`,
+        `ALTER TABLE order_tracking ADD COLUMN IF NOT EXISTS from_status TEXT;`,
+        `ALTER TABLE order_tracking ADD COLUMN IF NOT EXISTS actor_id UUID;`,
+        `ALTER TABLE orders ADD COLUMN IF NOT EXISTS refunded_amount DOUBLE PRECISION NOT NULL DEFAULT 0;`,
+        `ALTER TABLE products ADD COLUMN IF NOT EXISTS non_returnable BOOLEAN NOT NULL DEFAULT FALSE;`,
+        // تخفيض قرب الانتهاء الذي يطبقه مجدول الصلاحية
+        `ALTER TABLE products ADD COLUMN IF NOT EXISTS expiry_markdown_percent DOUBLE PRECISION;`,
+        `ALTER TABLE products ADD COLUMN IF NOT EXISTS discount_before_markdown DOUBLE PRECISION;`,
+        `ALTER TABLE order_tracking ADD COLUMN IF NOT EXISTS shipment_id UUID;`,
+        `CREATE INDEX IF NOT EXISTS idx_order_tracking_shipment_id ON order_tracking(shipment_id);`,
+        // awaiting_prescription_review أطول من 20 حرفاً
+        `ALTER TABLE orders ALTER COLUMN status TYPE VARCHAR(40);`,
+        // الفرع الذي جُهز منه الطلب
+        `ALTER TABLE orders ADD COLUMN IF NOT EXISTS location_id UUID;`,
+        `CREATE INDEX IF NOT EXISTS idx_orders_location_id ON orders(location_id);`,
+        `ALTER TABLE order_items ADD COLUMN IF NOT EXISTS prescription_id UUID;`,
+        `ALTER TABLE order_items ADD COLUMN IF NOT EXISTS prescription_status VARCHAR(20);`,
+        `CREATE INDEX IF NOT EXISTS idx_order_items_prescription_id ON order_items(prescription_id);`,
+        // جدول المستخدمين يُنشأ عبر AutoMigrate لاحقاً في قاعدة جديدة
+        `ALTER TABLE IF EXISTS users ADD COLUMN IF NOT EXISTS vat_number TEXT;`,
+        // سلاسل ترقيم الفواتير أصبحت جزءاً من خدمة الترقيم العامة لكل المستندات
+        `DO $$ BEGIN
+            IF to_regclass('invoice_sequences') IS NOT NULL AND to_regclass('number_sequences') IS NULL THEN
+                ALTER TABLE invoice_sequences RENAME TO number_sequences;
+                UPDATE number_sequences SET series = 'invoice:' || substring(series FROM 5) WHERE series LIKE 'INV-%';
+                UPDATE number_sequences SET series = 'credit_note:' || substring(series FROM 5) WHERE series LIKE 'CRN-%';
+            END IF;
+        END $$;`,
+        // جدول فواتير الشراء القديم بمعرفات رقمية لم يُستخدم؛ يُحتفظ به باسم آخر لإنشاء الجدول الجديد
+        `DO $$ BEGIN
+            IF EXISTS (SELECT 1 FROM information_schema.columns
+                WHERE table_schema = 'public' AND table_name = 'purchase_invoices' AND column_name = 'id' AND data_type <> 'uuid') THEN
+                ALTER TABLE purchase_invoices RENAME TO legacy_purchase_invoices;
+                ALTER TABLE IF EXISTS invoice_items RENAME TO legacy_purchase_invoice_items;
+            END IF;
+        END $$;`,
+        `ALTER TABLE suppliers ADD COLUMN IF NOT EXISTS notes TEXT;`,
+        `ALTER TABLE suppliers ADD COLUMN IF NOT EXISTS is_active BOOLEAN NOT NULL DEFAULT TRUE;`,
+        `ALTER TABLE suppliers ADD COLUMN IF NOT EXISTS lead_time_days INTEGER NOT NULL DEFAULT 7;`,
+        `ALTER TABLE inventory_transactions ADD COLUMN IF NOT EXISTS reason_code VARCHAR(30);`,
+        `ALTER TABLE products ADD COLUMN IF NOT EXISTS barcode TEXT;`,
+        `CREATE INDEX IF NOT EXISTS idx_products_barcode ON products(barcode);`,
+        `ALTER TABLE order_items ADD COLUMN IF NOT EXISTS cost_of_goods DECIMAL(15,2) NOT NULL DEFAULT 0;`,
+        `ALTER TABLE order_items ADD COLUMN IF NOT EXISTS costed_quantity INTEGER NOT NULL DEFAULT 0;`,
+        // المخزون لا يكون سالباً؛ NOT VALID حتى لا يفشل التشغيل بسبب صفوف قديمة سالبة
+        `DO $$ BEGIN
+            IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'chk_products_stock_non_negative') THEN
+                ALTER TABLE products ADD CONSTRAINT chk_products_stock_non_negative CHECK (stock_quantity >= 0) NOT VALID;
+            END IF;
+        END $$;`,
+    }
+    for _, stmt := range schemaChangesSQL {
+        if err := migDB.Exec(stmt).Error; err != nil {
+            log.Printf("❌ Failed to apply schema change %q: %v\n", stmt, err)
+            return fmt.Errorf("failed to apply schema change: %w", err)
+        }
+    }
+
 	if usersTableExists {
 		// حذف الـ default من عمود id لتجنب مشاكل التوليد التلقائي مع GORM
         if err := migDB.Exec("ALTER TABLE users ALTER COLUMN id DROP DEFAULT;").Error; err != nil {
@@ -522,6 +596,39 @@ func AutoMigrate() error {
 		&models.Supplier{},
 		&models.InventoryTransaction{},
 		&models.WholesaleUpgradeRequest{},
+		&models.CouponRedemption{},
+		&models.IdempotencyKey{},
+		&models.ReturnRequest{},
+		&models.ReturnItem{},
+		&models.ReturnItemBatch{},
+		&models.Shipment{},
+		&models.ShipmentItem{},
+		&models.Invoice{},
+		&models.InvoiceLine{},
+		&models.NumberSequence{},
+		&models.Prescription{},
+		&models.PrescriptionReview{},
+		&models.DrugInteraction{},
+		&models.QuantityLimitRule{},
+		&models.QuantityLimitViolation{},
+		&models.Subscription{},
+		&models.SubscriptionItem{},
+		&models.SubscriptionRun{},
+		&models.ProductBatch{},
+		&models.OrderItemBatch{},
+		&models.PurchaseInvoice{},
+		&models.PurchaseInvoiceLine{},
+		&models.SupplierLedgerEntry{},
+		&models.Stocktake{},
+		&models.StocktakeLine{},
+		&models.InventoryCostEntry{},
+		&models.PurchaseOrder{},
+		&models.PurchaseOrderLine{},
+		&models.Location{},
+		&models.StockTransfer{},
+		&models.StockTransferLine{},
+		&models.StockTransferBatch{},
+		&models.ExpiryAlert{},
 	}
 	
 	for _, model := range modelsToMigrate {
@@ -589,7 +696,103 @@ func AutoMigrate() error {
 		}
 		log.Printf("✅ Successfully migrated model: %s\n", modelName)
 	}
-	
+
+	// المخزون الموجود قبل تتبع الدفعات يصبح دفعة افتتاحية برقم الدفعة وتاريخ الانتهاء المسجلين في المنتج
+	// وتكلفتها آخر سعر شراء معروف
+	openingBatchesSQL := `
+	INSERT INTO product_batches (id, product_id, batch_number, expiry_date, quantity, received_quantity, unit_cost, supplier_id, received_at, notes, created_at, updated_at)
+	SELECT gen_random_uuid(), p.id, COALESCE(NULLIF(p.batch_number, ''), 'OPENING'), p.expiry_date, p.stock_quantity, p.stock_quantity,
+		COALESCE((SELECT it.unit_price FROM inventory_transactions it
+			WHERE it.product_id = p.id AND it.transaction_type = 'purchase' ORDER BY it.created_at DESC LIMIT 1), 0),
+		p.supplier_id, NOW(), 'مخزون افتتاحي', NOW(), NOW()
+	FROM products p
+	WHERE p.stock_quantity > 0 AND NOT EXISTS (SELECT 1 FROM product_batches b WHERE b.product_id = p.id);`
+	if err := migDB.Exec(openingBatchesSQL).Error; err != nil {
+		log.Printf("❌ Failed to create opening product batches: %v\n", err)
+		return fmt.Errorf("failed to create opening product batches: %w", err)
+	}
+
+	// أرصدة الموردين السابقة لدفتر الحساب تصبح قيداً افتتاحياً حتى يبقى الرصيد مجموع القيود
+	openingSupplierEntriesSQL := `
+	INSERT INTO supplier_ledger_entries (id, supplier_id, entry_type, entry_date, amount, description, created_at)
+	SELECT gen_random_uuid(), s.id, 'opening', CURRENT_DATE, s.balance, 'رصيد افتتاحي', NOW()
+	FROM suppliers s
+	WHERE s.balance <> 0 AND NOT EXISTS (SELECT 1 FROM supplier_ledger_entries e WHERE e.supplier_id = s.id);`
+	if err := migDB.Exec(openingSupplierEntriesSQL).Error; err != nil {
+		log.Printf("❌ Failed to create opening supplier ledger entries: %v\n", err)
+		return fmt.Errorf("failed to create opening supplier ledger entries: %w", err)
+	}
+
+	// عند تفعيل دفتر التكلفة: الدفعات الحالية رصيد افتتاحي بتكلفتها، وعناصر الطلبات السابقة
+	// تُعد مكلفة لأن مخزونها خُصم من الدفعات قبل الرصيد الافتتاحي
+	openingCostEntriesSQL := `
+	DO $$ BEGIN
+		IF NOT EXISTS (SELECT 1 FROM inventory_cost_entries) THEN
+			INSERT INTO inventory_cost_entries (id, product_id, batch_id, source, quantity, unit_cost, value, reference_number, occurred_at, created_at)
+			SELECT gen_random_uuid(), b.product_id, b.id, 'opening', b.quantity, b.unit_cost, ROUND(b.quantity * b.unit_cost, 2), b.batch_number, NOW(), NOW()
+			FROM product_batches b
+			WHERE b.quantity > 0;
+			UPDATE order_items SET costed_quantity = quantity WHERE costed_quantity = 0;
+		END IF;
+	END $$;`
+	if err := migDB.Exec(openingCostEntriesSQL).Error; err != nil {
+		log.Printf("❌ Failed to create opening inventory cost entries: %v\n", err)
+		return fmt.Errorf("failed to create opening inventory cost entries: %w", err)
+	}
+
+	// المخزون السابق للفروع كان في مكان واحد: يصبح الفرع الرئيسي الافتراضي وتُنسب إليه الدفعات
+	defaultLocationSQL := `
+	DO $$ BEGIN
+		IF NOT EXISTS (SELECT 1 FROM locations) THEN
+			INSERT INTO locations (id, code, name, city, priority, is_default, is_active, created_at, updated_at)
+			VALUES (gen_random_uuid(), 'MAIN', 'الفرع الرئيسي', 'الرياض', 100, TRUE, TRUE, NOW(), NOW());
+		END IF;
+		UPDATE product_batches SET location_id = (
+			SELECT id FROM locations WHERE is_active ORDER BY is_default DESC, priority ASC, created_at ASC LIMIT 1
+		) WHERE location_id IS NULL;
+	END $$;`
+	if err := migDB.Exec(defaultLocationSQL).Error; err != nil {
+		log.Printf("❌ Failed to create default location: %v\n", err)
+		return fmt.Errorf("failed to create default location: %w", err)
+	}
+
+	// قبل دفتر الحركات الموحد لم يُسجل البيع والإلغاء والتحويل، فيُسجل لكل منتج رصيد افتتاحي
+	// بفرق مخزونه الفعلي (دفعاته أو عداده) عن مجموع حركاته. يعمل مرة واحدة فقط ويُحفظ ذلك في
+	// data_migrations، لأن إعادة تشغيله تخفي فرقاً لاحقاً يجب أن يظهر في reconcile-stock.
+	// قاعدة سجلت حركات الدفتر الموحد قبل هذا السجل تُعلَّم دون رصيد جديد.
+	openingStockMovementsSQL := `
+	CREATE TABLE IF NOT EXISTS data_migrations (
+		name VARCHAR(100) PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	DO $$ BEGIN
+		IF EXISTS (SELECT 1 FROM data_migrations WHERE name = 'opening_stock_movements') THEN
+			RETURN;
+		END IF;
+		IF NOT EXISTS (SELECT 1 FROM inventory_transactions WHERE transaction_type IN ('opening', 'sale', 'cancel', 'transfer')) THEN
+			INSERT INTO inventory_transactions (id, product_id, supplier_id, quantity, unit_price, transaction_type, reference_number, notes, created_at)
+			SELECT gen_random_uuid(), o.id, o.supplier_id, o.on_hand - o.ledger,
+				COALESCE((SELECT it.unit_price FROM inventory_transactions it
+					WHERE it.product_id = o.id AND it.transaction_type = 'purchase' AND it.quantity > 0 ORDER BY it.created_at DESC LIMIT 1), 0),
+				'opening', 'OPENING', 'رصيد افتتاحي لدفتر الحركات', NOW()
+			FROM (
+				SELECT p.id, p.supplier_id,
+					CASE WHEN EXISTS (SELECT 1 FROM product_batches b WHERE b.product_id = p.id)
+						THEN (SELECT SUM(b.quantity) FROM product_batches b WHERE b.product_id = p.id)
+						ELSE p.stock_quantity END AS on_hand,
+					COALESCE((SELECT SUM(t.quantity) FROM inventory_transactions t
+						WHERE t.product_id = p.id AND t.transaction_type <> 'write_off'), 0) AS ledger
+				FROM products p
+			) o
+			WHERE o.on_hand <> o.ledger;
+		END IF;
+		INSERT INTO data_migrations (name) VALUES ('opening_stock_movements') ON CONFLICT (name) DO NOTHING;
+	END $$;`
+	if err := migDB.Exec(openingStockMovementsSQL).Error; err != nil {
+		log.Printf("❌ Failed to create opening stock movements: %v\n", err)
+		return fmt.Errorf("failed to create opening stock movements: %w", err)
+	}
+
 	// التحقق من وجود الجداول
 	var tables []string
 	err := migDB.Raw("SELECT table_name FROM information_schema.tables WHERE table_schema = 'public'").Scan(&tables).Error
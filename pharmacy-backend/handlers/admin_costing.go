@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"pharmacy-backend/config"
+	"pharmacy-backend/models"
+	"pharmacy-backend/services"
+	"pharmacy-backend/utils"
+)
+
+// OrderItemMargin إيراد عنصر الطلب وتكلفة ما شُحن منه
+type OrderItemMargin struct {
+	OrderItemID    uuid.UUID `json:"order_item_id"`
+	ProductID      uuid.UUID `json:"product_id"`
+	Name           string    `json:"name"`
+	Quantity       int       `json:"quantity"`
+	CostedQuantity int       `json:"costed_quantity"`
+	Revenue        float64   `json:"revenue"`
+	CostOfGoods    float64   `json:"cost_of_goods"`
+	GrossMargin    float64   `json:"gross_margin"`
+	MarginPercent  float64   `json:"margin_percent"`
+}
+
+// GetInventoryValuation قيمة المخزون في تاريخ محدد مجمعة بالمنتج أو التصنيف أو المورد (Admin)
+func GetInventoryValuation(c *gin.Context) {
+	asOf, err := time.Parse("2006-01-02", c.DefaultQuery("as_of", time.Now().Format("2006-01-02")))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid as_of date format. Use YYYY-MM-DD", err.Error())
+		return
+	}
+
+	report, err := services.ValueInventory(config.DB, asOf, services.ReportGrouping(c.Query("group_by")))
+	if err != nil {
+		respondCostReportError(c, "Failed to value inventory", err)
+		return
+	}
+	utils.SuccessResponse(c, "Inventory valuation generated successfully", report)
+}
+
+// GetGrossMarginReport الهامش الإجمالي للمبيعات المشحونة في فترة (Admin)
+func GetGrossMarginReport(c *gin.Context) {
+	from, err := time.Parse("2006-01-02", c.DefaultQuery("from", time.Now().AddDate(0, -1, 0).Format("2006-01-02")))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid from date format. Use YYYY-MM-DD", err.Error())
+		return
+	}
+	to, err := time.Parse("2006-01-02", c.DefaultQuery("to", time.Now().Format("2006-01-02")))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid to date format. Use YYYY-MM-DD", err.Error())
+		return
+	}
+	if to.Before(from) {
+		utils.BadRequestResponse(c, "Invalid period", "to must not be before from")
+		return
+	}
+
+	report, err := services.BuildGrossMarginReport(config.DB, from, to, services.ReportGrouping(c.Query("group_by")))
+	if err != nil {
+		respondCostReportError(c, "Failed to build gross margin report", err)
+		return
+	}
+	utils.SuccessResponse(c, "Gross margin report generated successfully", report)
+}
+
+// GetOrderMargin تكلفة البضاعة المباعة وهامش كل عنصر في الطلب (Admin)
+func GetOrderMargin(c *gin.Context) {
+	orderUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid order ID", err.Error())
+		return
+	}
+
+	var order models.Order
+	if err := config.DB.Preload("OrderItems").First(&order, "id = ?", orderUUID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.NotFoundResponse(c, "Order not found")
+		} else {
+			utils.InternalServerErrorResponse(c, "Failed to fetch order", err.Error())
+		}
+		return
+	}
+
+	items := make([]OrderItemMargin, 0, len(order.OrderItems))
+	var revenue, cost float64
+	for _, item := range order.OrderItems {
+		// الإيراد يخص الكمية التي كُلفت فقط حتى يقارن بتكلفتها
+		itemRevenue := services.ProratedLineValue(&order, item.UnitPrice, item.CostedQuantity)
+		items = append(items, OrderItemMargin{
+			OrderItemID:    item.ID,
+			ProductID:      item.ProductID,
+			Name:           item.Name,
+			Quantity:       item.Quantity,
+			CostedQuantity: item.CostedQuantity,
+			Revenue:        itemRevenue,
+			CostOfGoods:    item.CostOfGoods,
+			GrossMargin:    services.RoundMoney(itemRevenue - item.CostOfGoods),
+			MarginPercent:  services.MarginPercent(itemRevenue, item.CostOfGoods),
+		})
+		revenue += itemRevenue
+		cost += item.CostOfGoods
+	}
+
+	utils.SuccessResponse(c, "Order margin retrieved successfully", gin.H{
+		"order_id":       order.ID,
+		"order_number":   order.OrderNumber,
+		"items":          items,
+		"revenue":        services.RoundMoney(revenue),
+		"cost_of_goods":  services.RoundMoney(cost),
+		"gross_margin":   services.RoundMoney(revenue - cost),
+		"margin_percent": services.MarginPercent(revenue, cost),
+	})
+}
+
+// respondCostReportError تحويل أخطاء تقارير التكلفة إلى استجابة مناسبة
+func respondCostReportError(c *gin.Context, message string, err error) {
+	if errors.Is(err, services.ErrInvalidReportGrouping) {
+		utils.BadRequestResponse(c, message, err.Error())
+		return
+	}
+	utils.InternalServerErrorResponse(c, message, err.Error())
+}
@@ -1,10 +1,12 @@
 package handlers
 
 import (
+	"errors"
 	"strconv"
 	"time"
 	"pharmacy-backend/config"
 	"pharmacy-backend/models"
+	"pharmacy-backend/services"
 	"pharmacy-backend/utils"
 	
 	"github.com/gin-gonic/gin"
@@ -20,6 +22,7 @@ type CreateCouponRequest struct {
 	MinOrderAmount    float64            `json:"min_order_amount"`
 	MaxDiscountAmount *float64           `json:"max_discount_amount,omitempty"`
 	UsageLimit        *int               `json:"usage_limit,omitempty"`
+	PerUserLimit      *int               `json:"per_user_limit,omitempty" binding:"omitempty,gt=0"`
 	IsActive          bool               `json:"is_active"`
 	ValidFrom         time.Time          `json:"valid_from" binding:"required"`
 	ValidUntil        time.Time          `json:"valid_until" binding:"required"`
@@ -33,6 +36,7 @@ type UpdateCouponRequest struct {
 	MinOrderAmount    *float64           `json:"min_order_amount,omitempty"`
 	MaxDiscountAmount *float64           `json:"max_discount_amount,omitempty"`
 	UsageLimit        *int               `json:"usage_limit,omitempty"`
+	PerUserLimit      *int               `json:"per_user_limit,omitempty" binding:"omitempty,gt=0"`
 	IsActive          *bool              `json:"is_active,omitempty"`
 	ValidFrom         *time.Time         `json:"valid_from,omitempty"`
 	ValidUntil        *time.Time         `json:"valid_until,omitempty"`
@@ -60,6 +64,7 @@ func CreateCoupon(c *gin.Context) {
 		MinOrderAmount:    req.MinOrderAmount,
 		MaxDiscountAmount: req.MaxDiscountAmount,
 		UsageLimit:        req.UsageLimit,
+		PerUserLimit:      req.PerUserLimit,
 		IsActive:          req.IsActive,
 		ValidFrom:         req.ValidFrom,
 		ValidUntil:        req.ValidUntil,
@@ -125,6 +130,9 @@ func UpdateCoupon(c *gin.Context) {
 	if req.UsageLimit != nil {
 		coupon.UsageLimit = req.UsageLimit
 	}
+	if req.PerUserLimit != nil {
+		coupon.PerUserLimit = req.PerUserLimit
+	}
 	if req.IsActive != nil {
 		coupon.IsActive = *req.IsActive
 	}
@@ -222,49 +230,71 @@ func GetCouponByID(c *gin.Context) {
 	utils.SuccessResponse(c, "Coupon retrieved successfully", coupon)
 }
 
-// ValidateCoupon التحقق من صحة الكوبون (للمستخدمين)
+// ValidateCoupon التحقق من صحة الكوبون ومعاينة الخصم (للمستخدمين)
+// للمستخدم المسجل يُحسب الخصم على السلة الحالية، وإلا يُستخدم المبلغ المرسل في amount.
 func ValidateCoupon(c *gin.Context) {
 	code := c.Query("code")
-	amountStr := c.Query("amount")
-	
-	if code == "" || amountStr == "" {
-		utils.BadRequestResponse(c, "Coupon code and amount are required", "")
+	if code == "" {
+		utils.BadRequestResponse(c, "Coupon code is required", "")
 		return
 	}
-	
-	amount, err := strconv.ParseFloat(amountStr, 64)
-	if err != nil {
-		utils.BadRequestResponse(c, "Invalid amount format", err.Error())
-		return
-	}
-	
-	var coupon models.Coupon
-	if err := config.DB.Where("code = ?", code).First(&coupon).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			utils.NotFoundResponse(c, "Coupon not found or invalid")
-		} else {
-			utils.InternalServerErrorResponse(c, "Failed to fetch coupon", err.Error())
+
+	settings := services.LoadPricingSettings()
+	var quote *services.OrderQuote
+	userID := uuid.Nil
+
+	if u, exists := c.Get("user"); exists {
+		if user, ok := u.(*models.User); ok && user != nil {
+			userID = user.ID
+			q, err := services.QuoteCart(config.DB, user, settings)
+			if err != nil && !errors.Is(err, services.ErrEmptyOrder) {
+				if isPricingError(err) {
+					utils.BadRequestResponse(c, "Cart contains invalid items", err.Error())
+				} else {
+					utils.InternalServerErrorResponse(c, "Failed to price cart", err.Error())
+				}
+				return
+			}
+			quote = q
 		}
-		return
 	}
-	
-	if !coupon.IsValid() {
-		utils.BadRequestResponse(c, "Coupon is not valid", "")
-		return
+
+	if quote == nil {
+		amountStr := c.Query("amount")
+		if amountStr == "" {
+			utils.BadRequestResponse(c, "Cart is empty; amount is required", "")
+			return
+		}
+		amount, err := strconv.ParseFloat(amountStr, 64)
+		if err != nil || amount < 0 {
+			utils.BadRequestResponse(c, "Invalid amount format", "")
+			return
+		}
+		quote = &services.OrderQuote{
+			Lines:    []services.QuotedLine{{Quantity: 1, UnitPrice: amount, TotalPrice: services.RoundMoney(amount)}},
+			Settings: settings,
+		}
+		quote.Recalculate()
 	}
-	
-	if !coupon.CanBeUsedForOrder(amount) {
-		utils.BadRequestResponse(c, "Coupon cannot be applied to this order amount", "")
+
+	coupon, err := services.ApplyCoupon(config.DB, quote, userID, code)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrCouponNotFound):
+			utils.NotFoundResponse(c, "Coupon not found or invalid")
+		case services.IsCouponError(err):
+			utils.BadRequestResponse(c, "Coupon cannot be applied", err.Error())
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to validate coupon", err.Error())
+		}
 		return
 	}
-	
-	discount := coupon.CalculateDiscount(amount)
-	
+
 	response := gin.H{
 		"coupon":   coupon,
-		"discount": discount,
+		"discount": quote.DiscountAmount,
+		"quote":    quote,
 	}
-	
+
 	utils.SuccessResponse(c, "Coupon is valid and applied", response)
 }
-
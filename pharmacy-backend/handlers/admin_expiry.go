@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"pharmacy-backend/config"
+	"pharmacy-backend/models"
+	"pharmacy-backend/services"
+	"pharmacy-backend/utils"
+)
+
+// RunExpiryJob تشغيل مجدول الصلاحية فوراً دون انتظار موعده (Admin)
+// POST /admin/expiry/run
+func RunExpiryJob(c *gin.Context) {
+	result, err := services.RunExpiryJob(config.DB, time.Now(), services.LoadExpirySettings())
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to run expiry job", err.Error())
+		return
+	}
+	utils.SuccessResponse(c, "Expiry job completed successfully", result)
+}
+
+// GetExpiryDigest آخر ملخص صلاحية يومي أُرسل للمسؤول، أو ملخص محسوب الآن مع live=true (Admin)
+// GET /admin/notifications/expiry-digest
+func GetExpiryDigest(c *gin.Context) {
+	if c.Query("live") != "true" {
+		adminID := currentAdminID(c)
+		if adminID == nil {
+			utils.UnauthorizedResponse(c, "المستخدم غير مصرح له")
+			return
+		}
+		var notification models.Notification
+		err := config.DB.Where("user_id = ? AND type = ?", *adminID, models.NotificationTypeAdminExpiryDigest).
+			Order("created_at DESC").
+			First(&notification).Error
+		if err == nil {
+			var digest services.ExpiryDigest
+			if err := json.Unmarshal([]byte(notification.Data), &digest); err != nil {
+				utils.InternalServerErrorResponse(c, "Failed to read expiry digest", err.Error())
+				return
+			}
+			utils.SuccessResponse(c, "Expiry digest retrieved successfully", gin.H{
+				"notification_id": notification.ID,
+				"created_at":      notification.CreatedAt,
+				"is_read":         notification.IsRead,
+				"digest":          digest,
+			})
+			return
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.InternalServerErrorResponse(c, "Failed to fetch expiry digest", err.Error())
+			return
+		}
+	}
+
+	// لم يُرسل ملخص بعد: يُحسب الآن دون إرساله
+	digest, err := services.BuildExpiryDigest(config.DB, time.Now(), services.LoadExpirySettings().AlertDays)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to build expiry digest", err.Error())
+		return
+	}
+	utils.SuccessResponse(c, "Expiry digest generated successfully", gin.H{"digest": digest})
+}
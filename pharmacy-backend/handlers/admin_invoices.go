@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"pharmacy-backend/config"
+	"pharmacy-backend/models"
+	"pharmacy-backend/services"
+	"pharmacy-backend/utils"
+)
+
+// GetAllInvoices الحصول على جميع الفواتير والإشعارات الدائنة مع التصفية (Admin)
+func GetAllInvoices(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	documentType := c.Query("document_type")
+	invoiceType := c.Query("invoice_type")
+	search := c.Query("search")
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	var invoices []models.Invoice
+	var total int64
+
+	query := config.DB.Model(&models.Invoice{})
+	if documentType != "" {
+		query = query.Where("document_type = ?", documentType)
+	}
+	if invoiceType != "" {
+		query = query.Where("invoice_type = ?", invoiceType)
+	}
+	if orderID, err := uuid.Parse(c.Query("order_id")); err == nil {
+		query = query.Where("order_id = ?", orderID)
+	}
+	if search != "" {
+		query = query.Where("invoice_number ILIKE ? OR order_number ILIKE ? OR buyer_name ILIKE ?", "%"+search+"%", "%"+search+"%", "%"+search+"%")
+	}
+	query.Count(&total)
+
+	if err := query.
+		Order("issued_at DESC").
+		Limit(limit).
+		Offset((page - 1) * limit).
+		Find(&invoices).Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch invoices", err.Error())
+		return
+	}
+
+	pagination := utils.CalculatePagination(page, limit, total)
+	utils.PaginatedSuccessResponse(c, "Invoices retrieved successfully", invoices, pagination)
+}
+
+// GetInvoiceByID الحصول على فاتورة أو إشعار دائن بالمعرف مع الأسطر (Admin)
+func GetInvoiceByID(c *gin.Context) {
+	if invoice, ok := loadInvoice(c, anyInvoice); ok {
+		utils.SuccessResponse(c, "Invoice retrieved successfully", invoice)
+	}
+}
+
+// AdminDownloadInvoicePDF تنزيل أي فاتورة بصيغة PDF (Admin)
+func AdminDownloadInvoicePDF(c *gin.Context) {
+	if invoice, ok := loadInvoice(c, anyInvoice); ok {
+		sendInvoicePDF(c, invoice)
+	}
+}
+
+// AdminDownloadInvoiceXML تنزيل أي فاتورة بصيغة UBL XML (Admin)
+func AdminDownloadInvoiceXML(c *gin.Context) {
+	if invoice, ok := loadInvoice(c, anyInvoice); ok {
+		sendInvoiceXML(c, invoice)
+	}
+}
+
+// IssueOrderInvoice إصدار فاتورة لطلب مدفوع لم يُسلَّم بعد (Admin)
+// تُصدر الفاتورة تلقائياً عند التسليم؛ هذا المسار لمن دفع مسبقاً.
+func IssueOrderInvoice(c *gin.Context) {
+	orderUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid order ID", err.Error())
+		return
+	}
+
+	order := models.Order{ID: orderUUID}
+	tx := config.DB.Begin()
+	invoice, err := services.IssueOrderInvoice(tx, &order)
+	if err != nil {
+		tx.Rollback()
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			utils.NotFoundResponse(c, "Order not found")
+		case services.IsInvoiceError(err):
+			utils.ErrorResponse(c, http.StatusUnprocessableEntity, "Invoice cannot be issued", err.Error())
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to issue invoice", err.Error())
+		}
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to issue invoice", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Invoice issued successfully", invoice)
+}
+
+func anyInvoice(db *gorm.DB) *gorm.DB {
+	return db
+}
@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"pharmacy-backend/config"
+	"pharmacy-backend/models"
+	"pharmacy-backend/services"
+	"pharmacy-backend/utils"
+)
+
+// LocationRequest بنية طلب إنشاء أو تعديل فرع
+type LocationRequest struct {
+	Code         string `json:"code" binding:"required,max=30"`
+	Name         string `json:"name" binding:"required"`
+	City         string `json:"city" binding:"required"`
+	Address      string `json:"address"`
+	Phone        string `json:"phone"`
+	ServedCities string `json:"served_cities"`
+	Priority     *int   `json:"priority" binding:"omitempty,min=0"`
+	IsDefault    bool   `json:"is_default"`
+	IsActive     *bool  `json:"is_active"`
+}
+
+// apply نسخ قيم الطلب إلى الفرع
+func (req *LocationRequest) apply(location *models.Location) {
+	location.Code = req.Code
+	location.Name = req.Name
+	location.City = req.City
+	location.Address = req.Address
+	location.Phone = req.Phone
+	location.ServedCities = req.ServedCities
+	location.IsDefault = req.IsDefault
+	if req.Priority != nil {
+		location.Priority = *req.Priority
+	}
+	if req.IsActive != nil {
+		location.IsActive = *req.IsActive
+	}
+}
+
+// GetLocations الحصول على الفروع بترتيب الأولوية (Admin)
+func GetLocations(c *gin.Context) {
+	query := config.DB.Model(&models.Location{})
+	if active := c.Query("is_active"); active != "" {
+		query = query.Where("is_active = ?", active == "true")
+	}
+	if city := c.Query("city"); city != "" {
+		query = query.Where("city ILIKE ?", "%"+city+"%")
+	}
+
+	var locations []models.Location
+	if err := query.Order("is_default DESC, priority ASC, name ASC").Find(&locations).Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch locations", err.Error())
+		return
+	}
+	utils.SuccessResponse(c, "Locations retrieved successfully", locations)
+}
+
+// GetLocation الحصول على فرع (Admin)
+func GetLocation(c *gin.Context) {
+	location, ok := loadLocation(c)
+	if !ok {
+		return
+	}
+	utils.SuccessResponse(c, "Location retrieved successfully", location)
+}
+
+// CreateLocation إنشاء فرع جديد (Admin)
+func CreateLocation(c *gin.Context) {
+	var req LocationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+	location := models.Location{Priority: 100, IsActive: true}
+	req.apply(&location)
+
+	tx := config.DB.Begin()
+	if err := services.SaveLocation(tx, &location); err != nil {
+		tx.Rollback()
+		respondLocationError(c, "Failed to create location", err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to create location", err.Error())
+		return
+	}
+
+	utils.CreatedResponse(c, "Location created successfully", location)
+}
+
+// UpdateLocation تعديل بيانات فرع؛ تُستبدل بالقيم المرسلة (Admin)
+func UpdateLocation(c *gin.Context) {
+	location, ok := loadLocation(c)
+	if !ok {
+		return
+	}
+
+	var req LocationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+	req.apply(location)
+
+	tx := config.DB.Begin()
+	if err := services.SaveLocation(tx, location); err != nil {
+		tx.Rollback()
+		respondLocationError(c, "Failed to update location", err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to update location", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Location updated successfully", location)
+}
+
+// DeleteLocation حذف فرع لم يُستخدم؛ غير ذلك يُعطّل بـ is_active (Admin)
+func DeleteLocation(c *gin.Context) {
+	locationUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid location ID", err.Error())
+		return
+	}
+
+	tx := config.DB.Begin()
+	if err := services.DeleteLocation(tx, locationUUID); err != nil {
+		tx.Rollback()
+		respondLocationError(c, "Failed to delete location", err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to delete location", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Location deleted successfully", nil)
+}
+
+// GetLocationStock مخزون كل منتج في الفرع (Admin)
+func GetLocationStock(c *gin.Context) {
+	location, ok := loadLocation(c)
+	if !ok {
+		return
+	}
+
+	rows, err := services.LocationStock(config.DB, location.ID, time.Now())
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch location stock", err.Error())
+		return
+	}
+	utils.SuccessResponse(c, "Location stock retrieved successfully", gin.H{
+		"location": location,
+		"stock":    rows,
+	})
+}
+
+// GetLocationLowStockReport المنتجات التي بلغت حدها الأدنى في كل فرع أو في فرع محدد (Admin)
+// GET /admin/reports/location-low-stock?location_id=
+func GetLocationLowStockReport(c *gin.Context) {
+	locationID, ok := optionalLocationID(c)
+	if !ok {
+		return
+	}
+
+	rows, err := services.LocationLowStock(config.DB, locationID, time.Now())
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to build location low stock report", err.Error())
+		return
+	}
+	utils.SuccessResponse(c, "Location low stock report generated successfully", rows)
+}
+
+// GetLocationExpiryReport الدفعات المنتهية أو التي تنتهي خلال عدد أيام في كل فرع (Admin)
+// GET /admin/reports/location-expiry?location_id=&days=30
+func GetLocationExpiryReport(c *gin.Context) {
+	locationID, ok := optionalLocationID(c)
+	if !ok {
+		return
+	}
+	days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if err != nil || days < 0 || days > 730 {
+		utils.BadRequestResponse(c, "Invalid days", "days must be between 0 and 730")
+		return
+	}
+
+	rows, err := services.LocationExpiry(config.DB, locationID, days, time.Now())
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to build location expiry report", err.Error())
+		return
+	}
+	utils.SuccessResponse(c, "Location expiry report generated successfully", gin.H{
+		"days":    days,
+		"batches": rows,
+	})
+}
+
+// optionalLocationID قراءة معامل location_id الاختياري
+func optionalLocationID(c *gin.Context) (*uuid.UUID, bool) {
+	value := c.Query("location_id")
+	if value == "" {
+		return nil, true
+	}
+	id, err := uuid.Parse(value)
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid location ID", err.Error())
+		return nil, false
+	}
+	return &id, true
+}
+
+// loadLocation تحميل الفرع من معامل المسار أو إرسال الاستجابة المناسبة
+func loadLocation(c *gin.Context) (*models.Location, bool) {
+	locationUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid location ID", err.Error())
+		return nil, false
+	}
+
+	var location models.Location
+	if err := config.DB.First(&location, "id = ?", locationUUID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.NotFoundResponse(c, "Location not found")
+		} else {
+			utils.InternalServerErrorResponse(c, "Failed to fetch location", err.Error())
+		}
+		return nil, false
+	}
+	return &location, true
+}
+
+// respondLocationError تحويل أخطاء الفروع والتحويلات إلى استجابة مناسبة
+func respondLocationError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, services.ErrLocationNotFound):
+		utils.NotFoundResponse(c, "Location not found")
+	case errors.Is(err, services.ErrStockTransferNotFound):
+		utils.NotFoundResponse(c, "Stock transfer not found")
+	case errors.Is(err, services.ErrLocationInUse), errors.Is(err, services.ErrStockTransferState), errors.Is(err, services.ErrInsufficientStock):
+		utils.ErrorResponse(c, http.StatusConflict, message, err.Error())
+	case services.IsLocationError(err), services.IsStockTransferError(err), errors.Is(err, services.ErrProductNotFound):
+		utils.BadRequestResponse(c, message, err.Error())
+	default:
+		utils.InternalServerErrorResponse(c, message, err.Error())
+	}
+}
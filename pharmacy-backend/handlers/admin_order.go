@@ -1,7 +1,9 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
 	"pharmacy-backend/config"
 	"pharmacy-backend/models"
 	"pharmacy-backend/services"
@@ -84,6 +86,7 @@ func GetAllOrders(c *gin.Context) {
 	if err := config.DB.Model(&models.Order{}).
 		Preload("User").
 		Preload("OrderItems.Product").
+		Preload("OrderItems.Batches").
 		Preload("OrderTracking").
 		Where("orders.id IN ?", orderIDs).
 		Order("orders.created_at DESC").
@@ -98,7 +101,11 @@ func GetAllOrders(c *gin.Context) {
 
 // UpdateOrderStatusRequest بنية طلب تحديث حالة الطلب
 type UpdateOrderStatusRequest struct {
-	Status models.OrderStatus `json:"status" binding:"required,oneof=pending confirmed processing shipped delivered cancelled"`
+	Status         models.OrderStatus `json:"status" binding:"required,oneof=pending confirmed processing shipped delivered cancelled"`
+	TrackingNumber string             `json:"tracking_number,omitempty"` // مطلوب عند الشحن
+	Carrier        string             `json:"carrier,omitempty"`
+	Note           string             `json:"note,omitempty"`
+	Location       *string            `json:"location,omitempty"`
 }
 
 // UpdateOrderStatus تحديث حالة الطلب (Admin)
@@ -126,24 +133,47 @@ func UpdateOrderStatus(c *gin.Context) {
 		return
 	}
 	
-	// تحديث الحالة
-	order.Status = req.Status
-	if err := config.DB.Save(&order).Error; err != nil {
-		utils.InternalServerErrorResponse(c, "Failed to update order status", err.Error())
-		return
+	actorID := currentAdminID(c)
+	actorRole := models.RoleAdmin
+	if role, ok := c.Get("user_role"); ok {
+		if r, ok := role.(models.UserRole); ok {
+			actorRole = r
+		}
 	}
-	
-	// إضافة سجل تتبع جديد
-	tracking := models.OrderTracking{
-		OrderID:     order.ID,
-		Status:      string(req.Status),
-		Description: fmt.Sprintf("تم تحديث حالة الطلب إلى: %s", req.Status),
-		Timestamp:   time.Now(),
+
+	oldStatus := order.Status
+	tx := config.DB.Begin()
+
+	// تطبيق الانتقال وفق مخطط الحالات (الإلغاء يعيد المخزون والكوبون في نفس المعاملة)
+	tracking, err := services.TransitionOrderStatus(tx, &order, services.StatusChange{
+		To:             req.Status,
+		ActorID:        actorID,
+		ActorRole:      actorRole,
+		TrackingNumber: req.TrackingNumber,
+		Carrier:        req.Carrier,
+		Description:    req.Note,
+		Location:       req.Location,
+	})
+	if err != nil {
+		tx.Rollback()
+		switch {
+		case errors.Is(err, services.ErrTransitionForbidden):
+			utils.ErrorResponse(c, http.StatusForbidden, "You are not allowed to make this status change", err.Error())
+		case services.IsTransitionError(err):
+			utils.ErrorResponse(c, http.StatusUnprocessableEntity, "Invalid order status transition", err.Error())
+		case errors.Is(err, services.ErrOrderStatusChanged):
+			utils.ErrorResponse(c, http.StatusConflict, "Order status has changed, please refresh", err.Error())
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to update order status", err.Error())
+		}
+		return
 	}
-	if err := config.DB.Create(&tracking).Error; err != nil {
-		utils.InternalServerErrorResponse(c, "Failed to add order tracking", err.Error())
+
+	if err := tx.Commit().Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to update order status", err.Error())
 		return
 	}
+	order.OrderTracking = append(order.OrderTracking, *tracking)
 
 	// تحديد نوع الطلب (تجزئة أم جملة) بناءً على المنتجات
 	var orderItems []models.OrderItem
@@ -164,17 +194,17 @@ func UpdateOrderStatus(c *gin.Context) {
 		if isWholesaleOrder {
 			notificationType = models.NotificationTypeAdminWholesaleOrder
 			title = "تحديث طلب جملة"
-			message = fmt.Sprintf("تم تحديث طلب الجملة رقم %s إلى حالة: %s", order.ID.String()[:8], req.Status)
+			message = fmt.Sprintf("تم تحديث طلب الجملة رقم %s إلى حالة: %s", order.OrderNumber, req.Status)
 		} else {
 			notificationType = models.NotificationTypeAdminOrderUpdated
 			title = "تحديث طلب تجزئة"
-			message = fmt.Sprintf("تم تحديث طلب التجزئة رقم %s إلى حالة: %s", order.ID.String()[:8], req.Status)
+			message = fmt.Sprintf("تم تحديث طلب التجزئة رقم %s إلى حالة: %s", order.OrderNumber, req.Status)
 		}
 		
 		adminMetadata := map[string]interface{}{
 			"order_id":     order.ID.String(),
 			"user_id":      order.UserID.String(),
-			"old_status":   order.Status, // الحالة السابقة
+			"old_status":   oldStatus, // الحالة السابقة
 			"new_status":   req.Status,
 			"order_type":   map[bool]string{true: "wholesale", false: "retail"}[isWholesaleOrder],
 			"updated_at":   time.Now(),
@@ -238,10 +268,12 @@ func AddOrderTracking(c *gin.Context) {
 	tracking := models.OrderTracking{
 		OrderID:     order.ID,
 		Status:      req.Status,
+		FromStatus:  string(order.Status),
 		Description: req.Description,
 		Location:    req.Location,
 		Timestamp:   time.Now(),
 	}
+	tracking.ActorID = currentAdminID(c)
 	
 	if err := config.DB.Create(&tracking).Error; err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to add order tracking", err.Error())
@@ -251,3 +283,134 @@ func AddOrderTracking(c *gin.Context) {
 	utils.CreatedResponse(c, "Order tracking added successfully", tracking)
 }
 
+
+// AddOrderItemRequest بنية طلب إضافة منتج إلى طلب قائم
+type AddOrderItemRequest struct {
+	ProductID uuid.UUID `json:"product_id" binding:"required"`
+	Quantity  int       `json:"quantity" binding:"required,min=1"`
+	Note      string    `json:"note,omitempty"`
+}
+
+// UpdateOrderItemRequest بنية طلب تغيير كمية عنصر في الطلب (0 يحذف العنصر)
+type UpdateOrderItemRequest struct {
+	Quantity *int   `json:"quantity" binding:"required,min=0"`
+	Note     string `json:"note,omitempty"`
+}
+
+// AddOrderItem إضافة منتج إلى طلب معلق أو مؤكد (Admin)
+func AddOrderItem(c *gin.Context) {
+	var req AddOrderItemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+	editOrderItems(c, services.OrderEdit{
+		Action:    services.OrderEditAdd,
+		ProductID: req.ProductID,
+		Quantity:  req.Quantity,
+		Note:      req.Note,
+	})
+}
+
+// UpdateOrderItem تغيير كمية عنصر في طلب معلق أو مؤكد (Admin)
+func UpdateOrderItem(c *gin.Context) {
+	itemUUID, err := uuid.Parse(c.Param("itemId"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid order item ID", err.Error())
+		return
+	}
+	var req UpdateOrderItemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+	editOrderItems(c, services.OrderEdit{
+		Action:      services.OrderEditUpdate,
+		OrderItemID: itemUUID,
+		Quantity:    *req.Quantity,
+		Note:        req.Note,
+	})
+}
+
+// RemoveOrderItem حذف عنصر من طلب معلق أو مؤكد (Admin)
+func RemoveOrderItem(c *gin.Context) {
+	itemUUID, err := uuid.Parse(c.Param("itemId"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid order item ID", err.Error())
+		return
+	}
+	editOrderItems(c, services.OrderEdit{
+		Action:      services.OrderEditRemove,
+		OrderItemID: itemUUID,
+		Note:        c.Query("note"),
+	})
+}
+
+// editOrderItems تنفيذ تعديل عناصر الطلب ثم إشعار العميل
+func editOrderItems(c *gin.Context, edit services.OrderEdit) {
+	orderUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid order ID", err.Error())
+		return
+	}
+	edit.ActorID = currentAdminID(c)
+
+	tx := config.DB.Begin()
+	result, err := services.EditOrderItems(tx, orderUUID, edit)
+	if err != nil {
+		tx.Rollback()
+		var stockErr *services.InsufficientStockError
+		var limitErr *services.QuantityLimitError
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			utils.NotFoundResponse(c, "Order not found")
+		case errors.As(err, &limitErr):
+			// المعاملة أُلغيت فتُسجل المحاولة خارجها باسم صاحب الطلب
+			var order models.Order
+			if config.DB.Select("id", "user_id").First(&order, "id = ?", orderUUID).Error == nil {
+				services.RecordQuantityLimitViolation(config.DB, order.UserID, services.QuantityLimitSourceOrderEdit, err)
+			}
+			respondQuantityLimit(c, limitErr)
+		case errors.As(err, &stockErr):
+			utils.BadRequestResponse(c, "Insufficient quantity for product", fmt.Sprintf("%s - Requested: %d, Available: %d", stockErr.Name, stockErr.Requested, stockErr.Available))
+		case services.IsOrderEditError(err):
+			utils.ErrorResponse(c, http.StatusUnprocessableEntity, "Order cannot be edited", err.Error())
+		case isPricingError(err):
+			utils.BadRequestResponse(c, "Invalid order items", err.Error())
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to edit order", err.Error())
+		}
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to edit order", err.Error())
+		return
+	}
+
+	order := result.Order
+	notificationService := services.NewNotificationService()
+	if _, err := notificationService.CreateNotification(
+		order.UserID,
+		models.NotificationTypeOrderEdited,
+		"تم تعديل طلبك",
+		fmt.Sprintf("%s. الإجمالي الجديد للطلب %s هو %.2f ريال.", result.Tracking.Description, order.OrderNumber, order.TotalAmount),
+		map[string]interface{}{
+			"order_id":       order.ID.String(),
+			"change":         result.Change,
+			"previous_total": result.PreviousTotal,
+			"total_amount":   order.TotalAmount,
+		},
+		&order.ID,
+	); err != nil {
+		fmt.Printf("⚠️ فشل في إنشاء إشعار تعديل الطلب: %v", err)
+	}
+	Notifier.BroadcastToUser(order.UserID, "order_edited", gin.H{
+		"order_id":       order.ID.String(),
+		"change":         result.Change,
+		"previous_total": result.PreviousTotal,
+		"total_amount":   order.TotalAmount,
+		"updated_at":     order.UpdatedAt,
+	})
+
+	utils.SuccessResponse(c, "Order updated successfully", result)
+}
@@ -14,6 +14,7 @@ import (
 
 	"pharmacy-backend/config"
 	"pharmacy-backend/models"
+	"pharmacy-backend/services"
 	"pharmacy-backend/utils"
 
 	"github.com/cloudinary/cloudinary-go/v2/api/uploader"
@@ -30,6 +31,7 @@ type CreateProductRequest struct {
 	Price               float64   `json:"price" binding:"required,gt=0"`
 	DiscountPrice       *float64  `json:"discount_price,omitempty"`
 	SKU                 string    `json:"sku" binding:"required"`
+	Barcode             *string   `json:"barcode,omitempty"`
 	CategoryID          string    `json:"category_id" binding:"required"`
 	Brand               string    `json:"brand"`
 	StockQuantity       int       `json:"stock_quantity" binding:"required,min=0"`
@@ -47,6 +49,7 @@ type CreateProductRequest struct {
 	BatchNumber         *string    `json:"batch_number,omitempty"`
 	Manufacturer        *string    `json:"manufacturer,omitempty"`
 	RequiresPrescription bool      `json:"requires_prescription"`
+	NonReturnable       bool       `json:"non_returnable"`
 	ActiveIngredient    *string    `json:"active_ingredient,omitempty"`
 	DosageForm          *string    `json:"dosage_form,omitempty"`
 	Strength            *string    `json:"strength,omitempty"`
@@ -62,6 +65,7 @@ type UpdateProductRequest struct {
 	Price               *float64   `json:"price,omitempty" binding:"omitempty,gt=0"`
 	DiscountPrice       *float64   `json:"discount_price,omitempty"`
 	SKU                 *string    `json:"sku,omitempty"`
+	Barcode             *string    `json:"barcode,omitempty"`
 	CategoryID          *uuid.UUID `json:"category_id,omitempty"`
 	Brand               *string    `json:"brand,omitempty"`
 	StockQuantity       *int       `json:"stock_quantity,omitempty" binding:"omitempty,min=0"`
@@ -79,6 +83,7 @@ type UpdateProductRequest struct {
 	BatchNumber         *string    `json:"batch_number,omitempty"`
 	Manufacturer        *string    `json:"manufacturer,omitempty"`
 	RequiresPrescription *bool     `json:"requires_prescription,omitempty"`
+	NonReturnable       *bool      `json:"non_returnable,omitempty"`
 	ActiveIngredient    *string    `json:"active_ingredient,omitempty"`
 	DosageForm          *string    `json:"dosage_form,omitempty"`
 	Strength            *string    `json:"strength,omitempty"`
@@ -133,6 +138,7 @@ func CreateProduct(c *gin.Context) {
 		Price:               req.Price,
 		DiscountPrice:       req.DiscountPrice,
 		SKU:                 req.SKU,
+		Barcode:             req.Barcode,
 		CategoryID:          categoryUUID,
 		Brand:               req.Brand,
 		StockQuantity:       req.StockQuantity,
@@ -148,6 +154,7 @@ func CreateProduct(c *gin.Context) {
 		BatchNumber:         req.BatchNumber,
 		Manufacturer:        req.Manufacturer,
 		RequiresPrescription: req.RequiresPrescription,
+		NonReturnable:       req.NonReturnable,
 		ActiveIngredient:    req.ActiveIngredient,
 		DosageForm:          req.DosageForm,
 		Strength:            req.Strength,
@@ -160,12 +167,47 @@ func CreateProduct(c *gin.Context) {
 		product.IsActive = *req.IsActive
 	}
 	
+	// المخزون الأولي يُستلم كدفعة برقم الدفعة وتاريخ الانتهاء المرسلين
+	product.StockQuantity = 0
+	
 	log.Printf("[DEBUG] About to create product: %+v", product)
-	if err := config.DB.Create(&product).Error; err != nil {
+	tx := config.DB.Begin()
+	if err := tx.Create(&product).Error; err != nil {
+		tx.Rollback()
 		log.Printf("[ERROR] Failed to create product in database: %v", err)
 		utils.InternalServerErrorResponse(c, "Failed to create product", err.Error())
 		return
 	}
+	if req.StockQuantity > 0 {
+		receipt := services.BatchReceipt{
+			ProductID:   product.ID,
+			BatchNumber: "OPENING",
+			ExpiryDate:  req.ExpiryDate,
+			Quantity:    req.StockQuantity,
+			SupplierID:  product.SupplierID,
+			ActorID:     currentAdminID(c),
+		}
+		if req.BatchNumber != nil && strings.TrimSpace(*req.BatchNumber) != "" {
+			receipt.BatchNumber = *req.BatchNumber
+		}
+		if _, err := services.ReceiveBatch(tx, receipt); err != nil {
+			tx.Rollback()
+			if services.IsBatchError(err) {
+				utils.BadRequestResponse(c, "Invalid initial stock batch", err.Error())
+			} else {
+				utils.InternalServerErrorResponse(c, "Failed to create product", err.Error())
+			}
+			return
+		}
+	}
+	if err := tx.Commit().Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to create product", err.Error())
+		return
+	}
+	if err := config.DB.First(&product, "id = ?", product.ID).Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch created product", err.Error())
+		return
+	}
 	
 	utils.CreatedResponse(c, "Product created successfully", product)
 }
@@ -237,6 +279,7 @@ func UpdateProduct(c *gin.Context) {
         "price":                 true,
         "discount_price":        true,
         "sku":                   true,
+        "barcode":               true,
         "category_id":           true,
         "brand":                 true,
         "stock_quantity":        true,
@@ -252,6 +295,7 @@ func UpdateProduct(c *gin.Context) {
         "contraindications":     true,
         "is_active":             true,
         "batch_number":          true,
+        "non_returnable":        true,
     }
     
     // تحديث الحقول المسموح بها فقط
@@ -280,6 +324,14 @@ func UpdateProduct(c *gin.Context) {
         }
         updates["sku"] = *req.SKU
     }
+    if req.Barcode != nil && allowedFields["barcode"] {
+        barcode := strings.TrimSpace(*req.Barcode)
+        if barcode == "" {
+            updates["barcode"] = nil
+        } else {
+            updates["barcode"] = barcode
+        }
+    }
     if req.CategoryID != nil && allowedFields["category_id"] {
         // التحقق من وجود الفئة
         var category models.Category
@@ -296,8 +348,21 @@ func UpdateProduct(c *gin.Context) {
     if req.Brand != nil && allowedFields["brand"] {
         updates["brand"] = *req.Brand
     }
-    if req.StockQuantity != nil && allowedFields["stock_quantity"] {
-        updates["stock_quantity"] = *req.StockQuantity
+    // تغيير الكمية يُسجل كحركة تسوية (تصحيح عد) بدلاً من الكتابة فوق الرصيد دون أثر
+    stockDelta := 0
+    if req.StockQuantity != nil && allowedFields["stock_quantity"] && *req.StockQuantity != product.StockQuantity {
+        // مخزون المنتج المتتبع بالدفعات هو مجموع دفعاته فيُعدل من خلالها
+        var batches int64
+        if err := config.DB.Model(&models.ProductBatch{}).Where("product_id = ?", product.ID).Count(&batches).Error; err != nil {
+            utils.InternalServerErrorResponse(c, "فشل في التحقق من دفعات المنتج", err.Error())
+            return
+        }
+        if batches > 0 {
+            utils.BadRequestResponse(c, "مخزون هذا المنتج يُدار بالدفعات",
+                "استخدم /admin/products/:id/batches لاستلام دفعة أو /admin/product-batches/:id لتسوية كميتها")
+            return
+        }
+        stockDelta = *req.StockQuantity - product.StockQuantity
     }
     if req.IsActive != nil && allowedFields["is_active"] {
         updates["is_active"] = *req.IsActive
@@ -329,6 +394,9 @@ func UpdateProduct(c *gin.Context) {
     if req.Contraindications != nil && allowedFields["contraindications"] {
         updates["contraindications"] = req.Contraindications
     }
+    if req.NonReturnable != nil && allowedFields["non_returnable"] {
+        updates["non_returnable"] = *req.NonReturnable
+    }
     if req.ImageURL != nil && allowedFields["image_url"] {
         updates["image_url"] = *req.ImageURL
     }
@@ -337,9 +405,28 @@ func UpdateProduct(c *gin.Context) {
     }
     
     // تحديث الحقول المعدلة فقط
-    if len(updates) > 0 {
+    if len(updates) > 0 || stockDelta != 0 {
+        tx := config.DB.Begin()
+        if stockDelta != 0 {
+            if _, err := services.AdjustStock(tx, services.StockAdjustment{
+                ProductID: product.ID,
+                Quantity:  stockDelta,
+                Reason:    models.AdjustmentReasonCountCorrection,
+                Notes:     "تعديل الكمية من شاشة المنتج",
+                ActorID:   currentAdminID(c),
+            }); err != nil {
+                tx.Rollback()
+                respondStocktakeError(c, "فشل في تعديل المخزون", err)
+                return
+            }
+        }
         updates["updated_at"] = time.Now()
-        if err := config.DB.Model(&product).Updates(updates).Error; err != nil {
+        if err := tx.Model(&product).Updates(updates).Error; err != nil {
+            tx.Rollback()
+            utils.InternalServerErrorResponse(c, "فشل في تحديث المنتج", err.Error())
+            return
+        }
+        if err := tx.Commit().Error; err != nil {
             utils.InternalServerErrorResponse(c, "فشل في تحديث المنتج", err.Error())
             return
         }
@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"pharmacy-backend/config"
+	"pharmacy-backend/models"
+	"pharmacy-backend/services"
+	"pharmacy-backend/utils"
+)
+
+// ReceiveBatchRequest بنية طلب استلام دفعة جديدة لمنتج
+type ReceiveBatchRequest struct {
+	BatchNumber     string     `json:"batch_number" binding:"required"`
+	ExpiryDate      *time.Time `json:"expiry_date"`
+	Quantity        int        `json:"quantity" binding:"required,min=1"`
+	UnitCost        float64    `json:"unit_cost" binding:"min=0"`
+	SupplierID      *uuid.UUID `json:"supplier_id"`
+	LocationID      *uuid.UUID `json:"location_id"`
+	ReferenceNumber string     `json:"reference_number"`
+	Notes           string     `json:"notes"`
+}
+
+// AdjustBatchRequest بنية طلب تسوية الكمية المتبقية في دفعة
+// سبب التسوية افتراضياً تصحيح عدّ (count_correction).
+type AdjustBatchRequest struct {
+	Quantity   *int                    `json:"quantity" binding:"required,min=0"`
+	ReasonCode models.AdjustmentReason `json:"reason_code"`
+	Reason     string                  `json:"reason" binding:"required"`
+}
+
+// GetProductBatches الحصول على دفعات منتج بترتيب الصرف (Admin)
+// الدفعات الفارغة تُستبعد إلا مع include_empty=true.
+func GetProductBatches(c *gin.Context) {
+	productUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid product ID", err.Error())
+		return
+	}
+
+	var product models.Product
+	if err := config.DB.Select("id", "name", "stock_quantity").First(&product, "id = ?", productUUID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.NotFoundResponse(c, "Product not found")
+		} else {
+			utils.InternalServerErrorResponse(c, "Failed to fetch product", err.Error())
+		}
+		return
+	}
+
+	query := config.DB.Preload("Supplier").Preload("Location").Where("product_id = ?", product.ID)
+	if locationID := c.Query("location_id"); locationID != "" {
+		query = query.Where("location_id = ?", locationID)
+	}
+	if c.Query("include_empty") != "true" {
+		query = query.Where("quantity > 0")
+	}
+	var batches []models.ProductBatch
+	if err := query.Order("expiry_date ASC NULLS LAST, received_at ASC").Find(&batches).Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch batches", err.Error())
+		return
+	}
+
+	now := time.Now()
+	sellable, expired := 0, 0
+	for i := range batches {
+		if batches[i].IsExpired(now) {
+			expired += batches[i].Quantity
+		} else {
+			sellable += batches[i].Quantity
+		}
+	}
+
+	utils.SuccessResponse(c, "Product batches retrieved successfully", gin.H{
+		"product_id":        product.ID,
+		"product_name":      product.Name,
+		"stock_quantity":    product.StockQuantity,
+		"sellable_quantity": sellable,
+		"expired_quantity":  expired,
+		"batches":           batches,
+	})
+}
+
+// ReceiveProductBatch استلام دفعة جديدة لمنتج وإضافتها للمخزون (Admin)
+func ReceiveProductBatch(c *gin.Context) {
+	productUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid product ID", err.Error())
+		return
+	}
+
+	var req ReceiveBatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+	if req.SupplierID != nil {
+		var supplier models.Supplier
+		if err := config.DB.Select("id").First(&supplier, "id = ?", *req.SupplierID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				utils.BadRequestResponse(c, "Supplier not found", "")
+			} else {
+				utils.InternalServerErrorResponse(c, "Failed to fetch supplier", err.Error())
+			}
+			return
+		}
+	}
+
+	tx := config.DB.Begin()
+	batch, err := services.ReceiveBatch(tx, services.BatchReceipt{
+		ProductID:       productUUID,
+		BatchNumber:     req.BatchNumber,
+		ExpiryDate:      req.ExpiryDate,
+		Quantity:        req.Quantity,
+		UnitCost:        req.UnitCost,
+		SupplierID:      req.SupplierID,
+		LocationID:      req.LocationID,
+		ReferenceNumber: req.ReferenceNumber,
+		Notes:           req.Notes,
+		ActorID:         currentAdminID(c),
+	})
+	if err != nil {
+		tx.Rollback()
+		respondBatchError(c, "Failed to receive batch", err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to receive batch", err.Error())
+		return
+	}
+
+	utils.CreatedResponse(c, "Batch received successfully", batch)
+}
+
+// AdjustProductBatch تسوية الكمية المتبقية في دفعة بعد جرد أو تلف مع ذكر السبب (Admin)
+func AdjustProductBatch(c *gin.Context) {
+	batchUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid batch ID", err.Error())
+		return
+	}
+
+	var req AdjustBatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	tx := config.DB.Begin()
+	batch, err := services.AdjustBatchQuantity(tx, batchUUID, *req.Quantity, req.ReasonCode, req.Reason, currentAdminID(c))
+	if err != nil {
+		tx.Rollback()
+		respondBatchError(c, "Failed to adjust batch", err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to adjust batch", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Batch adjusted successfully", batch)
+}
+
+// GetBatchOrderItems عناصر الطلبات التي صُرفت من دفعة، لتتبع العملاء عند استدعاء الدفعة (Admin)
+func GetBatchOrderItems(c *gin.Context) {
+	batchUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid batch ID", err.Error())
+		return
+	}
+
+	var batch models.ProductBatch
+	if err := config.DB.First(&batch, "id = ?", batchUUID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.NotFoundResponse(c, "Batch not found")
+		} else {
+			utils.InternalServerErrorResponse(c, "Failed to fetch batch", err.Error())
+		}
+		return
+	}
+
+	var shipped []struct {
+		OrderID     uuid.UUID          `json:"order_id"`
+		OrderNumber string             `json:"order_number"`
+		OrderStatus models.OrderStatus `json:"order_status"`
+		UserID      uuid.UUID          `json:"user_id"`
+		OrderItemID uuid.UUID          `json:"order_item_id"`
+		Quantity    int                `json:"quantity"`
+		OrderedAt   time.Time          `json:"ordered_at"`
+	}
+	if err := config.DB.Table("order_item_batches").
+		Select("orders.id AS order_id, orders.order_number, orders.status AS order_status, orders.user_id, order_item_batches.order_item_id, order_item_batches.quantity, orders.created_at AS ordered_at").
+		Joins("JOIN order_items ON order_items.id = order_item_batches.order_item_id").
+		Joins("JOIN orders ON orders.id = order_items.order_id").
+		Where("order_item_batches.batch_id = ?", batch.ID).
+		Order("orders.created_at DESC").
+		Scan(&shipped).Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch batch orders", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Batch orders retrieved successfully", gin.H{
+		"batch":  batch,
+		"orders": shipped,
+	})
+}
+
+// respondBatchError تحويل أخطاء خدمة الدفعات إلى استجابة مناسبة
+func respondBatchError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, services.ErrProductNotFound):
+		utils.NotFoundResponse(c, "Product not found")
+	case errors.Is(err, services.ErrBatchNotFound):
+		utils.NotFoundResponse(c, "Batch not found")
+	case services.IsBatchError(err), services.IsAdjustmentError(err), services.IsLocationError(err), errors.Is(err, services.ErrInsufficientStock):
+		utils.BadRequestResponse(c, message, err.Error())
+	default:
+		utils.InternalServerErrorResponse(c, message, err.Error())
+	}
+}
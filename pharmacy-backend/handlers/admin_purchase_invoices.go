@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"pharmacy-backend/config"
+	"pharmacy-backend/models"
+	"pharmacy-backend/services"
+	"pharmacy-backend/utils"
+)
+
+// PurchaseInvoiceRequest بنية طلب إنشاء أو تعديل مسودة فاتورة شراء
+type PurchaseInvoiceRequest struct {
+	SupplierID            uuid.UUID                    `json:"supplier_id" binding:"required"`
+	SupplierInvoiceNumber string                       `json:"supplier_invoice_number" binding:"required"`
+	InvoiceDate           time.Time                    `json:"invoice_date" binding:"required"`
+	DueDate               *time.Time                   `json:"due_date"`
+	LocationID            *uuid.UUID                   `json:"location_id"`
+	TaxAmount             float64                      `json:"tax_amount" binding:"min=0"`
+	Notes                 string                       `json:"notes"`
+	Lines                 []PurchaseInvoiceLineRequest `json:"lines" binding:"required,min=1,dive"`
+}
+
+// PurchaseInvoiceLineRequest سطر في طلب فاتورة الشراء
+type PurchaseInvoiceLineRequest struct {
+	ProductID   uuid.UUID  `json:"product_id" binding:"required"`
+	BatchNumber string     `json:"batch_number" binding:"required"`
+	ExpiryDate  *time.Time `json:"expiry_date"`
+	Quantity    int        `json:"quantity" binding:"required,min=1"`
+	UnitCost    float64    `json:"unit_cost" binding:"min=0"`
+}
+
+// VoidPurchaseInvoiceRequest بنية طلب إلغاء فاتورة شراء مرحّلة
+type VoidPurchaseInvoiceRequest struct {
+	Reason string `json:"reason" binding:"required"`
+}
+
+// toInput تحويل الطلب إلى مدخلات خدمة فواتير الشراء
+func (req *PurchaseInvoiceRequest) toInput() services.PurchaseInvoiceInput {
+	input := services.PurchaseInvoiceInput{
+		SupplierID:            req.SupplierID,
+		SupplierInvoiceNumber: req.SupplierInvoiceNumber,
+		InvoiceDate:           req.InvoiceDate,
+		DueDate:               req.DueDate,
+		LocationID:            req.LocationID,
+		TaxAmount:             req.TaxAmount,
+		Notes:                 req.Notes,
+	}
+	for _, line := range req.Lines {
+		input.Lines = append(input.Lines, services.PurchaseLineInput{
+			ProductID:   line.ProductID,
+			BatchNumber: line.BatchNumber,
+			ExpiryDate:  line.ExpiryDate,
+			Quantity:    line.Quantity,
+			UnitCost:    line.UnitCost,
+		})
+	}
+	return input
+}
+
+// GetPurchaseInvoices الحصول على فواتير الشراء مع التصفية والترقيم (Admin)
+func GetPurchaseInvoices(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	query := config.DB.Model(&models.PurchaseInvoice{})
+	for _, filter := range []string{"supplier_id", "status"} {
+		if value := c.Query(filter); value != "" {
+			query = query.Where(filter+" = ?", value)
+		}
+	}
+	if search := c.Query("search"); search != "" {
+		like := "%" + search + "%"
+		query = query.Where("invoice_number ILIKE ? OR supplier_invoice_number ILIKE ?", like, like)
+	}
+	for param, condition := range map[string]string{"from": "invoice_date >= ?", "to": "invoice_date <= ?"} {
+		if value := c.Query(param); value != "" {
+			date, err := time.Parse("2006-01-02", value)
+			if err != nil {
+				utils.BadRequestResponse(c, "Invalid "+param+" date", err.Error())
+				return
+			}
+			query = query.Where(condition, date)
+		}
+	}
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to count purchase invoices", err.Error())
+		return
+	}
+
+	var invoices []models.PurchaseInvoice
+	if err := query.Preload("Supplier").
+		Order("invoice_date DESC, created_at DESC").
+		Limit(limit).
+		Offset((page - 1) * limit).
+		Find(&invoices).Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch purchase invoices", err.Error())
+		return
+	}
+
+	utils.PaginatedSuccessResponse(c, "Purchase invoices retrieved successfully", invoices, utils.CalculatePagination(page, limit, total))
+}
+
+// GetPurchaseInvoice الحصول على فاتورة شراء بأسطرها (Admin)
+func GetPurchaseInvoice(c *gin.Context) {
+	invoiceUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid purchase invoice ID", err.Error())
+		return
+	}
+
+	var invoice models.PurchaseInvoice
+	if err := config.DB.Preload("Supplier").
+		Preload("Lines", func(db *gorm.DB) *gorm.DB { return db.Order("sort_order ASC") }).
+		Preload("Lines.Product").
+		First(&invoice, "id = ?", invoiceUUID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.NotFoundResponse(c, "Purchase invoice not found")
+		} else {
+			utils.InternalServerErrorResponse(c, "Failed to fetch purchase invoice", err.Error())
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Purchase invoice retrieved successfully", invoice)
+}
+
+// CreatePurchaseInvoice إنشاء مسودة فاتورة شراء (Admin)
+func CreatePurchaseInvoice(c *gin.Context) {
+	var req PurchaseInvoiceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	tx := config.DB.Begin()
+	invoice, err := services.CreatePurchaseInvoice(tx, req.toInput(), currentAdminID(c))
+	if err != nil {
+		tx.Rollback()
+		respondPurchaseInvoiceError(c, "Failed to create purchase invoice", err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to create purchase invoice", err.Error())
+		return
+	}
+
+	utils.CreatedResponse(c, "Purchase invoice created successfully", invoice)
+}
+
+// UpdatePurchaseInvoice تعديل مسودة فاتورة شراء؛ الأسطر تُستبدل بالكامل (Admin)
+func UpdatePurchaseInvoice(c *gin.Context) {
+	invoiceUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid purchase invoice ID", err.Error())
+		return
+	}
+
+	var req PurchaseInvoiceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	tx := config.DB.Begin()
+	invoice, err := services.UpdatePurchaseInvoice(tx, invoiceUUID, req.toInput())
+	if err != nil {
+		tx.Rollback()
+		respondPurchaseInvoiceError(c, "Failed to update purchase invoice", err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to update purchase invoice", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Purchase invoice updated successfully", invoice)
+}
+
+// DeletePurchaseInvoice حذف مسودة فاتورة شراء (Admin)
+func DeletePurchaseInvoice(c *gin.Context) {
+	invoiceUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid purchase invoice ID", err.Error())
+		return
+	}
+
+	tx := config.DB.Begin()
+	if err := services.DeletePurchaseInvoice(tx, invoiceUUID); err != nil {
+		tx.Rollback()
+		respondPurchaseInvoiceError(c, "Failed to delete purchase invoice", err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to delete purchase invoice", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Purchase invoice deleted successfully", nil)
+}
+
+// PostPurchaseInvoice ترحيل فاتورة الشراء إلى المخزون ورصيد المورد (Admin)
+func PostPurchaseInvoice(c *gin.Context) {
+	invoiceUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid purchase invoice ID", err.Error())
+		return
+	}
+
+	tx := config.DB.Begin()
+	invoice, err := services.PostPurchaseInvoice(tx, invoiceUUID, currentAdminID(c))
+	if err != nil {
+		tx.Rollback()
+		respondPurchaseInvoiceError(c, "Failed to post purchase invoice", err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to post purchase invoice", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Purchase invoice posted successfully", invoice)
+}
+
+// VoidPurchaseInvoice إلغاء فاتورة شراء مرحّلة وعكس آثارها (Admin)
+func VoidPurchaseInvoice(c *gin.Context) {
+	invoiceUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid purchase invoice ID", err.Error())
+		return
+	}
+
+	var req VoidPurchaseInvoiceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	tx := config.DB.Begin()
+	invoice, err := services.VoidPurchaseInvoice(tx, invoiceUUID, req.Reason, currentAdminID(c))
+	if err != nil {
+		tx.Rollback()
+		respondPurchaseInvoiceError(c, "Failed to void purchase invoice", err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to void purchase invoice", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Purchase invoice voided successfully", invoice)
+}
+
+// respondPurchaseInvoiceError تحويل أخطاء خدمة فواتير الشراء إلى استجابة مناسبة
+func respondPurchaseInvoiceError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, services.ErrPurchaseInvoiceNotFound):
+		utils.NotFoundResponse(c, "Purchase invoice not found")
+	case errors.Is(err, services.ErrPurchaseInvoiceState),
+		errors.Is(err, services.ErrPurchaseInvoiceDuplicate),
+		errors.Is(err, services.ErrPurchaseStockConsumed):
+		utils.ErrorResponse(c, http.StatusConflict, message, err.Error())
+	case services.IsPurchaseInvoiceError(err), services.IsBatchError(err), services.IsLocationError(err), errors.Is(err, services.ErrProductNotFound):
+		utils.BadRequestResponse(c, message, err.Error())
+	default:
+		utils.InternalServerErrorResponse(c, message, err.Error())
+	}
+}
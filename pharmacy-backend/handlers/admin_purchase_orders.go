@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"pharmacy-backend/config"
+	"pharmacy-backend/models"
+	"pharmacy-backend/services"
+	"pharmacy-backend/utils"
+)
+
+// PurchaseOrderLineRequest سطر في طلب أمر الشراء
+type PurchaseOrderLineRequest struct {
+	ProductID uuid.UUID `json:"product_id" binding:"required"`
+	Quantity  int       `json:"quantity" binding:"required,min=1"`
+}
+
+// UpdatePurchaseOrderRequest بنية طلب تعديل مسودة أمر شراء
+type UpdatePurchaseOrderRequest struct {
+	Notes string                     `json:"notes"`
+	Lines []PurchaseOrderLineRequest `json:"lines" binding:"required,min=1,dive"`
+}
+
+// ReceivePurchaseOrderRequest بنية طلب إغلاق أمر شراء وصلت بضاعته
+type ReceivePurchaseOrderRequest struct {
+	PurchaseInvoiceID *uuid.UUID `json:"purchase_invoice_id"`
+}
+
+// CancelPurchaseOrderRequest بنية طلب إلغاء أمر شراء
+type CancelPurchaseOrderRequest struct {
+	Reason string `json:"reason"`
+}
+
+// GetPurchaseOrders الحصول على أوامر الشراء مع التصفية والترقيم (Admin)
+func GetPurchaseOrders(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	query := config.DB.Model(&models.PurchaseOrder{})
+	for _, filter := range []string{"supplier_id", "status"} {
+		if value := c.Query(filter); value != "" {
+			query = query.Where(filter+" = ?", value)
+		}
+	}
+	if search := c.Query("search"); search != "" {
+		query = query.Where("order_number ILIKE ?", "%"+search+"%")
+	}
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to count purchase orders", err.Error())
+		return
+	}
+
+	var orders []models.PurchaseOrder
+	if err := query.Preload("Supplier").
+		Order("created_at DESC").
+		Limit(limit).
+		Offset((page - 1) * limit).
+		Find(&orders).Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch purchase orders", err.Error())
+		return
+	}
+
+	utils.PaginatedSuccessResponse(c, "Purchase orders retrieved successfully", orders, utils.CalculatePagination(page, limit, total))
+}
+
+// GetPurchaseOrder الحصول على أمر شراء بأسطره (Admin)
+func GetPurchaseOrder(c *gin.Context) {
+	orderUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid purchase order ID", err.Error())
+		return
+	}
+
+	order, err := services.LoadPurchaseOrder(config.DB, orderUUID)
+	if err != nil {
+		respondPurchaseOrderError(c, "Failed to fetch purchase order", err)
+		return
+	}
+	utils.SuccessResponse(c, "Purchase order retrieved successfully", order)
+}
+
+// ExportPurchaseOrder تنزيل أمر الشراء بصيغة CSV أو PDF لإرساله للمورد (Admin)
+// GET /admin/purchase-orders/:id/export?format=pdf
+func ExportPurchaseOrder(c *gin.Context) {
+	orderUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid purchase order ID", err.Error())
+		return
+	}
+
+	order, err := services.LoadPurchaseOrder(config.DB, orderUUID)
+	if err != nil {
+		respondPurchaseOrderError(c, "Failed to fetch purchase order", err)
+		return
+	}
+
+	var buf bytes.Buffer
+	switch c.DefaultQuery("format", "pdf") {
+	case "csv":
+		if err := services.RenderPurchaseOrderCSV(order, &buf); err != nil {
+			utils.InternalServerErrorResponse(c, "Failed to render purchase order", err.Error())
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, order.OrderNumber))
+		c.Data(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+	case "pdf":
+		if err := services.RenderPurchaseOrderPDF(order, &buf); err != nil {
+			utils.InternalServerErrorResponse(c, "Failed to render purchase order", err.Error())
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.pdf"`, order.OrderNumber))
+		c.Data(http.StatusOK, "application/pdf", buf.Bytes())
+	default:
+		utils.BadRequestResponse(c, "Invalid format", "format must be csv or pdf")
+	}
+}
+
+// UpdatePurchaseOrder تعديل مسودة أمر شراء؛ الأسطر تُستبدل بالكامل (Admin)
+func UpdatePurchaseOrder(c *gin.Context) {
+	orderUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid purchase order ID", err.Error())
+		return
+	}
+
+	var req UpdatePurchaseOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+	lines := make([]services.PurchaseOrderLineInput, 0, len(req.Lines))
+	for _, line := range req.Lines {
+		lines = append(lines, services.PurchaseOrderLineInput{ProductID: line.ProductID, Quantity: line.Quantity})
+	}
+
+	tx := config.DB.Begin()
+	order, err := services.UpdatePurchaseOrder(tx, orderUUID, lines, req.Notes)
+	if err != nil {
+		tx.Rollback()
+		respondPurchaseOrderError(c, "Failed to update purchase order", err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to update purchase order", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Purchase order updated successfully", order)
+}
+
+// SendPurchaseOrder تعليم المسودة كمرسلة للمورد (Admin)
+func SendPurchaseOrder(c *gin.Context) {
+	orderUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid purchase order ID", err.Error())
+		return
+	}
+
+	tx := config.DB.Begin()
+	order, err := services.SendPurchaseOrder(tx, orderUUID, currentAdminID(c))
+	if err != nil {
+		tx.Rollback()
+		respondPurchaseOrderError(c, "Failed to send purchase order", err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to send purchase order", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Purchase order marked as sent", order)
+}
+
+// ReceivePurchaseOrder إغلاق أمر شراء وصلت بضاعته (Admin)
+func ReceivePurchaseOrder(c *gin.Context) {
+	orderUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid purchase order ID", err.Error())
+		return
+	}
+
+	var req ReceivePurchaseOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	tx := config.DB.Begin()
+	order, err := services.ReceivePurchaseOrder(tx, orderUUID, req.PurchaseInvoiceID)
+	if err != nil {
+		tx.Rollback()
+		respondPurchaseOrderError(c, "Failed to receive purchase order", err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to receive purchase order", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Purchase order received successfully", order)
+}
+
+// CancelPurchaseOrder إلغاء مسودة أو أمر شراء مرسل (Admin)
+func CancelPurchaseOrder(c *gin.Context) {
+	orderUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid purchase order ID", err.Error())
+		return
+	}
+
+	var req CancelPurchaseOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	tx := config.DB.Begin()
+	order, err := services.CancelPurchaseOrder(tx, orderUUID, req.Reason)
+	if err != nil {
+		tx.Rollback()
+		respondPurchaseOrderError(c, "Failed to cancel purchase order", err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to cancel purchase order", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Purchase order cancelled successfully", order)
+}
+
+// respondPurchaseOrderError تحويل أخطاء خدمة أوامر الشراء إلى استجابة مناسبة
+func respondPurchaseOrderError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, services.ErrPurchaseOrderNotFound):
+		utils.NotFoundResponse(c, "Purchase order not found")
+	case errors.Is(err, services.ErrPurchaseOrderState):
+		utils.ErrorResponse(c, http.StatusConflict, message, err.Error())
+	case services.IsPurchaseOrderError(err), errors.Is(err, services.ErrPurchaseInvoiceNotFound):
+		utils.BadRequestResponse(c, message, err.Error())
+	default:
+		utils.InternalServerErrorResponse(c, message, err.Error())
+	}
+}
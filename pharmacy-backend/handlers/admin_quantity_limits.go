@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"pharmacy-backend/config"
+	"pharmacy-backend/models"
+	"pharmacy-backend/services"
+	"pharmacy-backend/utils"
+)
+
+// QuantityLimitRuleRequest بنية طلب إنشاء أو تعديل قاعدة حد الكمية
+// عند التعديل تُستبدل القاعدة بالكامل بالقيم المرسلة.
+type QuantityLimitRuleRequest struct {
+	ProductID   *uuid.UUID `json:"product_id"`
+	CategoryID  *uuid.UUID `json:"category_id"`
+	MaxQuantity int        `json:"max_quantity" binding:"required,min=1"`
+	PeriodDays  int        `json:"period_days" binding:"required,min=1,max=3650"`
+	RetailOnly  bool       `json:"retail_only"`
+	IsActive    *bool      `json:"is_active"`
+	Reason      string     `json:"reason"`
+}
+
+// apply نسخ قيم الطلب إلى القاعدة
+func (req *QuantityLimitRuleRequest) apply(rule *models.QuantityLimitRule) {
+	rule.ProductID = req.ProductID
+	rule.CategoryID = req.CategoryID
+	rule.MaxQuantity = req.MaxQuantity
+	rule.PeriodDays = req.PeriodDays
+	rule.RetailOnly = req.RetailOnly
+	rule.Reason = strings.TrimSpace(req.Reason)
+	if req.IsActive != nil {
+		rule.IsActive = *req.IsActive
+	}
+}
+
+// GetQuantityLimitRules الحصول على قواعد حدود الكمية (Admin)
+func GetQuantityLimitRules(c *gin.Context) {
+	query := config.DB.Preload("Product").Preload("Category")
+	if productID := c.Query("product_id"); productID != "" {
+		query = query.Where("product_id = ?", productID)
+	}
+	if categoryID := c.Query("category_id"); categoryID != "" {
+		query = query.Where("category_id = ?", categoryID)
+	}
+	if active := c.Query("is_active"); active != "" {
+		query = query.Where("is_active = ?", active == "true")
+	}
+
+	var rules []models.QuantityLimitRule
+	if err := query.Order("created_at DESC").Find(&rules).Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch quantity limit rules", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Quantity limit rules retrieved successfully", rules)
+}
+
+// CreateQuantityLimitRule إنشاء قاعدة حد كمية لمنتج أو تصنيف (Admin)
+func CreateQuantityLimitRule(c *gin.Context) {
+	var req QuantityLimitRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	rule := models.QuantityLimitRule{IsActive: true, CreatedBy: currentAdminID(c)}
+	req.apply(&rule)
+	if !validateQuantityLimitRule(c, &rule) {
+		return
+	}
+
+	if err := config.DB.Create(&rule).Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to create quantity limit rule", err.Error())
+		return
+	}
+
+	utils.CreatedResponse(c, "Quantity limit rule created successfully", rule)
+}
+
+// UpdateQuantityLimitRule تعديل قاعدة حد كمية (Admin)
+func UpdateQuantityLimitRule(c *gin.Context) {
+	rule, ok := loadQuantityLimitRule(c)
+	if !ok {
+		return
+	}
+
+	var req QuantityLimitRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+	req.apply(rule)
+	if !validateQuantityLimitRule(c, rule) {
+		return
+	}
+
+	if err := config.DB.Model(rule).Select("product_id", "category_id", "max_quantity", "period_days", "retail_only", "is_active", "reason", "updated_at").
+		Updates(rule).Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to update quantity limit rule", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Quantity limit rule updated successfully", rule)
+}
+
+// DeleteQuantityLimitRule حذف قاعدة حد كمية؛ سجل المحاولات المرفوضة يبقى للتقرير (Admin)
+func DeleteQuantityLimitRule(c *gin.Context) {
+	rule, ok := loadQuantityLimitRule(c)
+	if !ok {
+		return
+	}
+
+	if err := config.DB.Delete(rule).Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to delete quantity limit rule", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Quantity limit rule deleted successfully", nil)
+}
+
+// GetQuantityLimitViolations تقرير محاولات الشراء المرفوضة مع ملخص لكل منتج (Admin)
+func GetQuantityLimitViolations(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	query := config.DB.Model(&models.QuantityLimitViolation{})
+	for _, filter := range []string{"product_id", "user_id", "rule_id", "source", "type"} {
+		if value := c.Query(filter); value != "" {
+			query = query.Where(filter+" = ?", value)
+		}
+	}
+	for param, condition := range map[string]string{"from": "created_at >= ?", "to": "created_at < ?"} {
+		if value := c.Query(param); value != "" {
+			date, err := time.Parse("2006-01-02", value)
+			if err != nil {
+				utils.BadRequestResponse(c, "Invalid "+param+" date", err.Error())
+				return
+			}
+			if param == "to" {
+				date = date.AddDate(0, 0, 1)
+			}
+			query = query.Where(condition, date)
+		}
+	}
+
+	var summary []struct {
+		ProductID   uuid.UUID `json:"product_id"`
+		ProductName string    `json:"product_name"`
+		Attempts    int64     `json:"attempts"`
+		Customers   int64     `json:"customers"`
+		LastAttempt time.Time `json:"last_attempt"`
+	}
+	if err := query.Session(&gorm.Session{}).
+		Select("product_id, MAX(product_name) AS product_name, COUNT(*) AS attempts, COUNT(DISTINCT user_id) AS customers, MAX(created_at) AS last_attempt").
+		Group("product_id").
+		Order("attempts DESC").
+		Scan(&summary).Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to summarize blocked attempts", err.Error())
+		return
+	}
+
+	var total int64
+	query.Session(&gorm.Session{}).Count(&total)
+
+	var violations []models.QuantityLimitViolation
+	if err := query.Preload("User").
+		Order("created_at DESC").
+		Limit(limit).
+		Offset((page - 1) * limit).
+		Find(&violations).Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch blocked attempts", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Blocked attempts retrieved successfully", gin.H{
+		"violations": violations,
+		"summary":    summary,
+		"pagination": utils.CalculatePagination(page, limit, total),
+	})
+}
+
+// validateQuantityLimitRule التحقق من القاعدة ومن وجود المنتج أو التصنيف المستهدف
+func validateQuantityLimitRule(c *gin.Context, rule *models.QuantityLimitRule) bool {
+	if err := services.ValidateQuantityLimitRule(rule); err != nil {
+		utils.BadRequestResponse(c, "Invalid quantity limit rule", err.Error())
+		return false
+	}
+
+	var target interface{} = &models.Product{}
+	targetID := rule.ProductID
+	if rule.CategoryID != nil {
+		target, targetID = &models.Category{}, rule.CategoryID
+	}
+	if err := config.DB.Select("id").First(target, "id = ?", *targetID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.BadRequestResponse(c, "Invalid quantity limit rule", "product or category not found")
+		} else {
+			utils.InternalServerErrorResponse(c, "Failed to validate quantity limit rule", err.Error())
+		}
+		return false
+	}
+	return true
+}
+
+func loadQuantityLimitRule(c *gin.Context) (*models.QuantityLimitRule, bool) {
+	ruleUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid rule ID", err.Error())
+		return nil, false
+	}
+
+	var rule models.QuantityLimitRule
+	if err := config.DB.First(&rule, "id = ?", ruleUUID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.NotFoundResponse(c, "Quantity limit rule not found")
+		} else {
+			utils.InternalServerErrorResponse(c, "Failed to fetch quantity limit rule", err.Error())
+		}
+		return nil, false
+	}
+	return &rule, true
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"pharmacy-backend/config"
+	"pharmacy-backend/services"
+	"pharmacy-backend/utils"
+)
+
+// ReplenishmentOrderRequest بنية طلب تحويل الاقتراحات إلى مسودات أوامر شراء
+// بدون أسطر تُستخدم الاقتراحات الحالية كما هي، مضيقة بالمورد أو التصنيف إن أُرسلا.
+type ReplenishmentOrderRequest struct {
+	SupplierID *uuid.UUID                 `json:"supplier_id"`
+	CategoryID *uuid.UUID                 `json:"category_id"`
+	Notes      string                     `json:"notes"`
+	Lines      []PurchaseOrderLineRequest `json:"lines" binding:"omitempty,dive"`
+}
+
+// parseReplenishmentFilter قراءة تصفية الاقتراحات من الاستعلام
+func parseReplenishmentFilter(c *gin.Context) (services.ReplenishmentFilter, bool) {
+	filter := services.ReplenishmentFilter{IncludeAll: c.Query("include_all") == "true"}
+	for param, target := range map[string]**uuid.UUID{"supplier_id": &filter.SupplierID, "category_id": &filter.CategoryID} {
+		if value := c.Query(param); value != "" {
+			id, err := uuid.Parse(value)
+			if err != nil {
+				utils.BadRequestResponse(c, "Invalid "+param, err.Error())
+				return filter, false
+			}
+			*target = &id
+		}
+	}
+	return filter, true
+}
+
+// GetReplenishmentSuggestions اقتراح كميات إعادة الطلب من سرعة البيع ومدة التوريد ومخزون الأمان (Admin)
+func GetReplenishmentSuggestions(c *gin.Context) {
+	filter, ok := parseReplenishmentFilter(c)
+	if !ok {
+		return
+	}
+
+	report, err := services.BuildReplenishmentReport(config.DB, time.Now(), filter)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to build replenishment suggestions", err.Error())
+		return
+	}
+	utils.SuccessResponse(c, "Replenishment suggestions generated successfully", report)
+}
+
+// CreateReplenishmentPurchaseOrders تحويل الاقتراحات إلى مسودة أمر شراء لكل مورد (Admin)
+// المنتجات المقترحة التي لا مورد لها تُعاد في skipped ولا تمنع إنشاء بقية الأوامر.
+func CreateReplenishmentPurchaseOrders(c *gin.Context) {
+	var req ReplenishmentOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	lines := make([]services.PurchaseOrderLineInput, 0, len(req.Lines))
+	for _, line := range req.Lines {
+		lines = append(lines, services.PurchaseOrderLineInput{ProductID: line.ProductID, Quantity: line.Quantity})
+	}
+	skipped := []services.ReplenishmentSuggestion{}
+	if len(lines) == 0 {
+		report, err := services.BuildReplenishmentReport(config.DB, time.Now(), services.ReplenishmentFilter{
+			SupplierID: req.SupplierID,
+			CategoryID: req.CategoryID,
+		})
+		if err != nil {
+			utils.InternalServerErrorResponse(c, "Failed to build replenishment suggestions", err.Error())
+			return
+		}
+		for _, suggestion := range report.Suggestions {
+			if suggestion.SupplierID == nil {
+				skipped = append(skipped, suggestion)
+				continue
+			}
+			lines = append(lines, services.PurchaseOrderLineInput{ProductID: suggestion.ProductID, Quantity: suggestion.SuggestedQuantity})
+		}
+		if len(lines) == 0 {
+			utils.BadRequestResponse(c, "No products need reordering", "")
+			return
+		}
+	}
+
+	tx := config.DB.Begin()
+	orders, err := services.CreateDraftPurchaseOrders(tx, lines, req.Notes, currentAdminID(c))
+	if err != nil {
+		tx.Rollback()
+		respondPurchaseOrderError(c, "Failed to create purchase orders", err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to create purchase orders", err.Error())
+		return
+	}
+
+	utils.CreatedResponse(c, "Purchase orders created successfully", gin.H{
+		"purchase_orders": orders,
+		"skipped":         skipped,
+	})
+}
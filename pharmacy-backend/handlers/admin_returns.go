@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"pharmacy-backend/config"
+	"pharmacy-backend/models"
+	"pharmacy-backend/services"
+	"pharmacy-backend/utils"
+)
+
+// ReviewReturnRequest بنية طلب اعتماد أو رفض طلب إرجاع
+type ReviewReturnRequest struct {
+	AdminNotes string `json:"admin_notes"`
+}
+
+// RefundReturnRequest بنية طلب الاسترداد؛ المبلغ اختياري (الافتراضي الحد الأقصى المسموح)
+type RefundReturnRequest struct {
+	Amount *float64 `json:"amount,omitempty" binding:"omitempty,gt=0"`
+}
+
+// GetAllReturns الحصول على جميع طلبات الإرجاع (Admin)
+func GetAllReturns(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	status := c.Query("status")
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	var returns []models.ReturnRequest
+	var total int64
+
+	query := config.DB.Model(&models.ReturnRequest{})
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	query.Count(&total)
+
+	if err := query.
+		Preload("Items").
+		Preload("User").
+		Order("created_at DESC").
+		Limit(limit).
+		Offset((page - 1) * limit).
+		Find(&returns).Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch return requests", err.Error())
+		return
+	}
+
+	pagination := utils.CalculatePagination(page, limit, total)
+	utils.PaginatedSuccessResponse(c, "Return requests retrieved successfully", returns, pagination)
+}
+
+// GetReturnByID الحصول على طلب إرجاع بالمعرف مع الحد الأقصى للاسترداد (Admin)
+func GetReturnByID(c *gin.Context) {
+	request, ok := loadReturnRequest(c)
+	if !ok {
+		return
+	}
+
+	var order models.Order
+	if err := config.DB.First(&order, "id = ?", request.OrderID).Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch order", err.Error())
+		return
+	}
+	maxRefund, err := services.MaxRefund(config.DB, request, &order)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to calculate refund", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Return request retrieved successfully", gin.H{
+		"return":     request,
+		"order":      order,
+		"max_refund": maxRefund,
+	})
+}
+
+// ApproveReturn اعتماد طلب إرجاع وإعادة الأصناف للمخزون أو إتلافها (Admin)
+func ApproveReturn(c *gin.Context) {
+	reviewReturn(c, true)
+}
+
+// RejectReturn رفض طلب إرجاع (Admin)
+func RejectReturn(c *gin.Context) {
+	reviewReturn(c, false)
+}
+
+func reviewReturn(c *gin.Context, approve bool) {
+	var req ReviewReturnRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+	if !approve && req.AdminNotes == "" {
+		utils.BadRequestResponse(c, "Rejection reason is required", "admin_notes is required")
+		return
+	}
+
+	request, ok := loadReturnRequest(c)
+	if !ok {
+		return
+	}
+	adminID := currentAdminID(c)
+
+	tx := config.DB.Begin()
+	var err error
+	if approve {
+		err = services.ApproveReturn(tx, request, adminID, req.AdminNotes)
+	} else {
+		err = services.RejectReturn(tx, request, adminID, req.AdminNotes)
+	}
+	if err != nil {
+		tx.Rollback()
+		if services.IsReturnError(err) {
+			utils.BadRequestResponse(c, "Return request cannot be updated", err.Error())
+		} else {
+			utils.InternalServerErrorResponse(c, "Failed to update return request", err.Error())
+		}
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to update return request", err.Error())
+		return
+	}
+
+	if approve {
+		notifyReturnUpdate(request, "تم اعتماد طلب الإرجاع",
+			fmt.Sprintf("تم اعتماد طلب الإرجاع %s وسيتم استرداد المبلغ قريبًا.", request.ReturnNumber))
+		utils.SuccessResponse(c, "Return request approved", request)
+		return
+	}
+	notifyReturnUpdate(request, "تم رفض طلب الإرجاع",
+		fmt.Sprintf("تم رفض طلب الإرجاع %s: %s", request.ReturnNumber, req.AdminNotes))
+	utils.SuccessResponse(c, "Return request rejected", request)
+}
+
+// RefundReturn تسجيل استرداد كامل أو جزئي لطلب إرجاع معتمد (Admin)
+func RefundReturn(c *gin.Context) {
+	var req RefundReturnRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	request, ok := loadReturnRequest(c)
+	if !ok {
+		return
+	}
+
+	tx := config.DB.Begin()
+	refund, err := services.RefundReturn(tx, request, currentAdminID(c), req.Amount)
+	if err != nil {
+		tx.Rollback()
+		if services.IsReturnError(err) {
+			utils.BadRequestResponse(c, "Refund is not valid", err.Error())
+		} else {
+			utils.InternalServerErrorResponse(c, "Failed to refund return request", err.Error())
+		}
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to refund return request", err.Error())
+		return
+	}
+
+	notifyReturnUpdate(request, "تم استرداد المبلغ",
+		fmt.Sprintf("تم استرداد %.2f ر.س لطلب الإرجاع %s.", refund, request.ReturnNumber))
+	utils.SuccessResponse(c, "Refund recorded successfully", request)
+}
+
+// loadReturnRequest تحميل طلب الإرجاع من معرف المسار مع عناصره
+func loadReturnRequest(c *gin.Context) (*models.ReturnRequest, bool) {
+	returnUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid return ID", err.Error())
+		return nil, false
+	}
+
+	var request models.ReturnRequest
+	if err := config.DB.Preload("Items").First(&request, "id = ?", returnUUID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			utils.NotFoundResponse(c, "Return request not found")
+		} else {
+			utils.InternalServerErrorResponse(c, "Failed to fetch return request", err.Error())
+		}
+		return nil, false
+	}
+	return &request, true
+}
+
+// currentAdminID معرف المشرف الحالي من سياق المصادقة
+func currentAdminID(c *gin.Context) *uuid.UUID {
+	if adminID, ok := c.Get("user_id"); ok {
+		if uid, ok := adminID.(uuid.UUID); ok {
+			return &uid
+		}
+	}
+	return nil
+}
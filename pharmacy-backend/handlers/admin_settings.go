@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"pharmacy-backend/services"
 	"pharmacy-backend/utils"
 	"strconv"
 	"strings"
@@ -61,6 +62,13 @@ func setEnv(key string, value interface{}) {
 	os.Setenv(key, toString(value))
 }
 
+func valueOr(value *string, fallback string) string {
+	if value != nil && *value != "" {
+		return *value
+	}
+	return fallback
+}
+
 func toString(value interface{}) string {
 	switch v := value.(type) {
 	case string:
@@ -85,6 +93,37 @@ type Settings struct {
 	StoreAddress       string   `json:"store_address"`
 	StoreLogoURL       string   `json:"store_logo_url"`
 	StoreDescription   string   `json:"store_description"`
+	VATNumber          string   `json:"vat_number"`          // الرقم الضريبي المطبوع على الفواتير
+	CommercialRegister string   `json:"commercial_register"`
+	
+	// Document Numbering ({PREFIX}, {YYYY}, {YY}, {SEQ})
+	NumberFormat         string `json:"number_format"`
+	NumberDigits         int    `json:"number_digits"`
+	RetailOrderPrefix    string `json:"retail_order_prefix"`
+	WholesaleOrderPrefix string `json:"wholesale_order_prefix"`
+	POSOrderPrefix       string `json:"pos_order_prefix"`
+	InvoicePrefix        string `json:"invoice_prefix"`
+	CreditNotePrefix     string `json:"credit_note_prefix"`
+	PurchasePrefix       string `json:"purchase_invoice_prefix"`
+	StocktakePrefix      string `json:"stocktake_prefix"`
+	PurchaseOrderPrefix  string `json:"purchase_order_prefix"`
+	StockTransferPrefix  string `json:"stock_transfer_prefix"`
+	GaplessOrderNumbers  bool   `json:"gapless_order_numbers"`
+
+	// Inventory Costing (weighted_average, fifo)
+	CostingMethod        string `json:"costing_method"`
+
+	// Replenishment (days)
+	ReplenishmentSalesWindow int `json:"replenishment_sales_window_days"`
+	SafetyStockDays          int `json:"safety_stock_days"`
+	ReorderCoverDays         int `json:"reorder_cover_days"`
+	DefaultLeadTimeDays      int `json:"default_lead_time_days"`
+
+	// Expiry management (alert days "90,60,30", markdown rules "days:percent")
+	ExpiryAlertDays       string `json:"expiry_alert_days"`
+	ExpiryAutoUnpublish   bool   `json:"expiry_auto_unpublish"`
+	ExpiryMarkdownEnabled bool   `json:"expiry_markdown_enabled"`
+	ExpiryMarkdownRules   string `json:"expiry_markdown_rules"`
 	
 	// Currency and Pricing
 	Currency           string   `json:"currency"`
@@ -143,6 +182,35 @@ func GetSettings(c *gin.Context) {
 		StoreAddress:       getEnv("STORE_ADDRESS", "الرياض، المملكة العربية السعودية"),
 		StoreLogoURL:       getEnv("STORE_LOGO_URL", "/images/logo.png"),
 		StoreDescription:   getEnv("STORE_DESCRIPTION", "متجر صيدلية المعتمد - كل ما تحتاجه من أدوية ومستحضرات طبية"),
+		VATNumber:          getEnv("VAT_NUMBER", ""),
+		CommercialRegister: getEnv("COMMERCIAL_REGISTER", ""),
+		
+		// Document Numbering
+		NumberFormat:         getEnv("NUMBER_FORMAT", "{PREFIX}-{YYYY}-{SEQ}"),
+		NumberDigits:         getEnvInt("NUMBER_SEQUENCE_DIGITS", 6),
+		RetailOrderPrefix:    getEnv("ORDER_PREFIX_RETAIL", "ORD"),
+		WholesaleOrderPrefix: getEnv("ORDER_PREFIX_WHOLESALE", "WHS"),
+		POSOrderPrefix:       getEnv("ORDER_PREFIX_POS", "POS"),
+		InvoicePrefix:        getEnv("INVOICE_PREFIX", "INV"),
+		CreditNotePrefix:     getEnv("CREDIT_NOTE_PREFIX", "CRN"),
+		PurchasePrefix:       getEnv("PURCHASE_INVOICE_PREFIX", "PUR"),
+		StocktakePrefix:      getEnv("STOCKTAKE_PREFIX", "STK"),
+		PurchaseOrderPrefix:  getEnv("PURCHASE_ORDER_PREFIX", "PO"),
+		StockTransferPrefix:  getEnv("STOCK_TRANSFER_PREFIX", "TRF"),
+		GaplessOrderNumbers:  getEnvBool("ORDER_NUMBERS_GAPLESS", false),
+		CostingMethod:        string(services.CurrentCostingMethod()),
+
+		// Replenishment
+		ReplenishmentSalesWindow: getEnvInt("REPLENISHMENT_SALES_WINDOW_DAYS", 90),
+		SafetyStockDays:          getEnvInt("SAFETY_STOCK_DAYS", 7),
+		ReorderCoverDays:         getEnvInt("REORDER_COVER_DAYS", 30),
+		DefaultLeadTimeDays:      getEnvInt("DEFAULT_LEAD_TIME_DAYS", 7),
+
+		// Expiry management
+		ExpiryAlertDays:       getEnv("EXPIRY_ALERT_DAYS", "90,60,30"),
+		ExpiryAutoUnpublish:   getEnvBool("EXPIRY_AUTO_UNPUBLISH", true),
+		ExpiryMarkdownEnabled: getEnvBool("EXPIRY_MARKDOWN_ENABLED", false),
+		ExpiryMarkdownRules:   getEnv("EXPIRY_MARKDOWN_RULES", "60:10,30:25"),
 		
 		// Currency and Pricing
 		Currency:           getEnv("CURRENCY", "SAR"),
@@ -193,6 +261,37 @@ type UpdateSettingsRequest struct {
 	StoreAddress     *string   `json:"store_address,omitempty"`
 	StoreLogoURL     *string   `json:"store_logo_url,omitempty"`
 	StoreDescription *string   `json:"store_description,omitempty"`
+	VATNumber        *string   `json:"vat_number,omitempty"`
+	CommercialRegister *string `json:"commercial_register,omitempty"`
+
+	// Document Numbering
+	NumberFormat         *string `json:"number_format,omitempty"`
+	NumberDigits         *int    `json:"number_digits,omitempty"`
+	RetailOrderPrefix    *string `json:"retail_order_prefix,omitempty"`
+	WholesaleOrderPrefix *string `json:"wholesale_order_prefix,omitempty"`
+	POSOrderPrefix       *string `json:"pos_order_prefix,omitempty"`
+	InvoicePrefix        *string `json:"invoice_prefix,omitempty"`
+	CreditNotePrefix     *string `json:"credit_note_prefix,omitempty"`
+	PurchasePrefix       *string `json:"purchase_invoice_prefix,omitempty"`
+	StocktakePrefix      *string `json:"stocktake_prefix,omitempty"`
+	PurchaseOrderPrefix  *string `json:"purchase_order_prefix,omitempty"`
+	StockTransferPrefix  *string `json:"stock_transfer_prefix,omitempty"`
+	GaplessOrderNumbers  *bool   `json:"gapless_order_numbers,omitempty"`
+
+	// Inventory Costing
+	CostingMethod        *string `json:"costing_method,omitempty"`
+
+	// Replenishment
+	ReplenishmentSalesWindow *int `json:"replenishment_sales_window_days,omitempty"`
+	SafetyStockDays          *int `json:"safety_stock_days,omitempty"`
+	ReorderCoverDays         *int `json:"reorder_cover_days,omitempty"`
+	DefaultLeadTimeDays      *int `json:"default_lead_time_days,omitempty"`
+
+	// Expiry management
+	ExpiryAlertDays       *string `json:"expiry_alert_days,omitempty"`
+	ExpiryAutoUnpublish   *bool   `json:"expiry_auto_unpublish,omitempty"`
+	ExpiryMarkdownEnabled *bool   `json:"expiry_markdown_enabled,omitempty"`
+	ExpiryMarkdownRules   *string `json:"expiry_markdown_rules,omitempty"`
 
 	// Currency and Pricing
 	Currency         *string   `json:"currency,omitempty"`
@@ -257,6 +356,61 @@ func UpdateSettings(c *gin.Context) {
 		return
 	}
 
+	if req.VATNumber != nil && *req.VATNumber != "" && !services.ValidVATNumber(*req.VATNumber) {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid VAT number", "VAT number must be 15 digits starting and ending with 3")
+		return
+	}
+
+	if req.NumberFormat != nil && services.ValidateNumberFormat(*req.NumberFormat) != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid number format", services.ErrInvalidNumberFormat.Error())
+		return
+	}
+
+	if req.NumberDigits != nil && (*req.NumberDigits < 1 || *req.NumberDigits > 12) {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid number digits", "Number digits must be between 1 and 12")
+		return
+	}
+
+	// بادئات قنوات الطلبات يجب أن تختلف لأن لكل قناة عداداً مستقلاً
+	orderPrefixes := []string{
+		valueOr(req.RetailOrderPrefix, getEnv("ORDER_PREFIX_RETAIL", "ORD")),
+		valueOr(req.WholesaleOrderPrefix, getEnv("ORDER_PREFIX_WHOLESALE", "WHS")),
+		valueOr(req.POSOrderPrefix, getEnv("ORDER_PREFIX_POS", "POS")),
+	}
+	if orderPrefixes[0] == orderPrefixes[1] || orderPrefixes[0] == orderPrefixes[2] || orderPrefixes[1] == orderPrefixes[2] {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid order prefixes", "Retail, wholesale and POS order prefixes must be different")
+		return
+	}
+
+	if req.CostingMethod != nil && !services.CostingMethod(*req.CostingMethod).IsValid() {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid costing method", "Costing method must be weighted_average or fifo")
+		return
+	}
+
+	if req.ReplenishmentSalesWindow != nil && (*req.ReplenishmentSalesWindow < 1 || *req.ReplenishmentSalesWindow > 730) {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid sales window", "Replenishment sales window must be between 1 and 730 days")
+		return
+	}
+	for _, days := range []*int{req.SafetyStockDays, req.ReorderCoverDays, req.DefaultLeadTimeDays} {
+		if days != nil && (*days < 0 || *days > 365) {
+			utils.ErrorResponse(c, http.StatusBadRequest, "Invalid replenishment days", "Safety stock, cover and lead time days must be between 0 and 365")
+			return
+		}
+	}
+
+	if req.ExpiryAlertDays != nil {
+		if _, err := services.ParseExpiryAlertDays(*req.ExpiryAlertDays); err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, "Invalid expiry alert days", err.Error())
+			return
+		}
+	}
+	if req.ExpiryMarkdownRules != nil {
+		if _, err := services.ParseExpiryMarkdownRules(*req.ExpiryMarkdownRules); err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, "Invalid expiry markdown rules", err.Error())
+			return
+		}
+	}
+
 	if req.ItemsPerPage != nil && *req.ItemsPerPage <= 0 {
 		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid items per page", "Items per page must be greater than 0")
 		return
@@ -270,8 +424,18 @@ func UpdateSettings(c *gin.Context) {
 				if v != nil {
 					setEnv(key, *v)
 				}
-			case *bool, *int, *float64:
-				setEnv(key, v)
+			case *bool:
+				if v != nil {
+					setEnv(key, *v)
+				}
+			case *int:
+				if v != nil {
+					setEnv(key, *v)
+				}
+			case *float64:
+				if v != nil {
+					setEnv(key, *v)
+				}
 			case *[]string:
 				if v != nil {
 					setEnv(key, *v)
@@ -287,6 +451,35 @@ func UpdateSettings(c *gin.Context) {
 	updateEnvIfSet("STORE_ADDRESS", req.StoreAddress)
 	updateEnvIfSet("STORE_LOGO_URL", req.StoreLogoURL)
 	updateEnvIfSet("STORE_DESCRIPTION", req.StoreDescription)
+	updateEnvIfSet("VAT_NUMBER", req.VATNumber)
+	updateEnvIfSet("COMMERCIAL_REGISTER", req.CommercialRegister)
+
+	// Document Numbering
+	updateEnvIfSet("NUMBER_FORMAT", req.NumberFormat)
+	updateEnvIfSet("NUMBER_SEQUENCE_DIGITS", req.NumberDigits)
+	updateEnvIfSet("ORDER_PREFIX_RETAIL", req.RetailOrderPrefix)
+	updateEnvIfSet("ORDER_PREFIX_WHOLESALE", req.WholesaleOrderPrefix)
+	updateEnvIfSet("ORDER_PREFIX_POS", req.POSOrderPrefix)
+	updateEnvIfSet("INVOICE_PREFIX", req.InvoicePrefix)
+	updateEnvIfSet("CREDIT_NOTE_PREFIX", req.CreditNotePrefix)
+	updateEnvIfSet("PURCHASE_INVOICE_PREFIX", req.PurchasePrefix)
+	updateEnvIfSet("STOCKTAKE_PREFIX", req.StocktakePrefix)
+	updateEnvIfSet("PURCHASE_ORDER_PREFIX", req.PurchaseOrderPrefix)
+	updateEnvIfSet("STOCK_TRANSFER_PREFIX", req.StockTransferPrefix)
+	updateEnvIfSet("ORDER_NUMBERS_GAPLESS", req.GaplessOrderNumbers)
+	updateEnvIfSet("COSTING_METHOD", req.CostingMethod)
+
+	// Replenishment
+	updateEnvIfSet("REPLENISHMENT_SALES_WINDOW_DAYS", req.ReplenishmentSalesWindow)
+	updateEnvIfSet("SAFETY_STOCK_DAYS", req.SafetyStockDays)
+	updateEnvIfSet("REORDER_COVER_DAYS", req.ReorderCoverDays)
+	updateEnvIfSet("DEFAULT_LEAD_TIME_DAYS", req.DefaultLeadTimeDays)
+
+	// Expiry management
+	updateEnvIfSet("EXPIRY_ALERT_DAYS", req.ExpiryAlertDays)
+	updateEnvIfSet("EXPIRY_AUTO_UNPUBLISH", req.ExpiryAutoUnpublish)
+	updateEnvIfSet("EXPIRY_MARKDOWN_ENABLED", req.ExpiryMarkdownEnabled)
+	updateEnvIfSet("EXPIRY_MARKDOWN_RULES", req.ExpiryMarkdownRules)
 
 	// Currency and Pricing
 	updateEnvIfSet("CURRENCY", req.Currency)
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"pharmacy-backend/config"
+	"pharmacy-backend/models"
+	"pharmacy-backend/services"
+	"pharmacy-backend/utils"
+)
+
+// CreateShipmentRequest بنية طلب إنشاء شحنة لجزء من الطلب
+type CreateShipmentRequest struct {
+	Carrier        string                       `json:"carrier" binding:"required"`
+	TrackingNumber string                       `json:"tracking_number" binding:"required"`
+	WeightKg       *float64                     `json:"weight_kg,omitempty" binding:"omitempty,gt=0"`
+	Items          []services.ShipmentLineInput `json:"items" binding:"required,min=1,dive"`
+}
+
+// UpdateShipmentStatusRequest بنية طلب تحديث حالة الشحنة
+type UpdateShipmentStatusRequest struct {
+	Status      models.ShipmentStatus `json:"status" binding:"required,oneof=shipped in_transit delivered"`
+	Description string                `json:"description"`
+	Location    *string               `json:"location,omitempty"`
+}
+
+// CreateShipment إنشاء شحنة جديدة للطلب (Admin)
+func CreateShipment(c *gin.Context) {
+	orderUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid order ID", err.Error())
+		return
+	}
+
+	var req CreateShipmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	order := models.Order{ID: orderUUID}
+	tx := config.DB.Begin()
+	shipment, err := services.CreateShipment(tx, &order, services.ShipmentInput{
+		Carrier:        req.Carrier,
+		TrackingNumber: req.TrackingNumber,
+		WeightKg:       req.WeightKg,
+		Lines:          req.Items,
+	}, currentAdminID(c))
+	if err != nil {
+		tx.Rollback()
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			utils.NotFoundResponse(c, "Order not found")
+		case services.IsShipmentError(err):
+			utils.BadRequestResponse(c, "Shipment is not valid", err.Error())
+		case errors.Is(err, services.ErrOrderStatusChanged):
+			utils.ErrorResponse(c, http.StatusConflict, "Order status has changed, please refresh", err.Error())
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to create shipment", err.Error())
+		}
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to create shipment", err.Error())
+		return
+	}
+
+	utils.CreatedResponse(c, "Shipment created successfully", shipment)
+}
+
+// GetOrderShipments الحصول على شحنات الطلب مع الجدول الزمني لكل شحنة (Admin)
+func GetOrderShipments(c *gin.Context) {
+	orderUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid order ID", err.Error())
+		return
+	}
+
+	var shipments []models.Shipment
+	if err := config.DB.
+		Preload("Items").
+		Preload("Timeline", func(db *gorm.DB) *gorm.DB { return db.Order("timestamp ASC") }).
+		Where("order_id = ?", orderUUID).
+		Order("created_at ASC").
+		Find(&shipments).Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch shipments", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Shipments retrieved successfully", shipments)
+}
+
+// UpdateShipmentStatus تحديث حالة شحنة واشتقاق حالة الطلب منها (Admin)
+func UpdateShipmentStatus(c *gin.Context) {
+	shipmentUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid shipment ID", err.Error())
+		return
+	}
+
+	var req UpdateShipmentStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	var shipment models.Shipment
+	if err := config.DB.First(&shipment, "id = ?", shipmentUUID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			utils.NotFoundResponse(c, "Shipment not found")
+		} else {
+			utils.InternalServerErrorResponse(c, "Failed to fetch shipment", err.Error())
+		}
+		return
+	}
+
+	tx := config.DB.Begin()
+	if err := services.UpdateShipmentStatus(tx, &shipment, req.Status, currentAdminID(c), req.Description, req.Location); err != nil {
+		tx.Rollback()
+		switch {
+		case services.IsShipmentError(err), services.IsTransitionError(err):
+			utils.BadRequestResponse(c, "Shipment status cannot be updated", err.Error())
+		case errors.Is(err, services.ErrOrderStatusChanged):
+			utils.ErrorResponse(c, http.StatusConflict, "Order status has changed, please refresh", err.Error())
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to update shipment status", err.Error())
+		}
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to update shipment status", err.Error())
+		return
+	}
+
+	var order models.Order
+	if err := config.DB.Select("id", "user_id", "order_number", "status").First(&order, "id = ?", shipment.OrderID).Error; err == nil {
+		data := map[string]interface{}{
+			"order_id":        order.ID.String(),
+			"order_status":    order.Status,
+			"shipment_id":     shipment.ID.String(),
+			"shipment_status": shipment.Status,
+			"carrier":         shipment.Carrier,
+			"tracking_number": shipment.TrackingNumber,
+		}
+		if _, err := services.NewNotificationService().CreateNotification(
+			order.UserID,
+			models.NotificationTypeOrderUpdated,
+			"تحديث حالة الشحنة",
+			fmt.Sprintf("الشحنة %s من طلبك %s أصبحت: %s", shipment.ShipmentNumber, order.OrderNumber, shipment.Status),
+			data,
+			&order.ID,
+		); err != nil {
+			fmt.Printf("⚠️ فشل في إنشاء إشعار المستخدم لتحديث الشحنة: %v", err)
+		}
+		data["updated_at"] = time.Now()
+		Notifier.BroadcastToUser(order.UserID, "shipment_updated", gin.H(data))
+	}
+
+	utils.SuccessResponse(c, "Shipment status updated successfully", shipment)
+}
@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"pharmacy-backend/config"
+	"pharmacy-backend/models"
+	"pharmacy-backend/services"
+	"pharmacy-backend/utils"
+)
+
+// StockTransferLineRequest سطر في طلب التحويل
+type StockTransferLineRequest struct {
+	ProductID uuid.UUID `json:"product_id" binding:"required"`
+	Quantity  int       `json:"quantity" binding:"required,min=1"`
+}
+
+// CreateStockTransferRequest بنية طلب تحويل مخزون بين فرعين
+type CreateStockTransferRequest struct {
+	FromLocationID uuid.UUID                  `json:"from_location_id" binding:"required"`
+	ToLocationID   uuid.UUID                  `json:"to_location_id" binding:"required"`
+	Notes          string                     `json:"notes"`
+	Lines          []StockTransferLineRequest `json:"lines" binding:"required,min=1,dive"`
+}
+
+// ReceiveStockTransferRequest الكميات المستلمة فعلاً؛ الأسطر غير المذكورة تُستلم كاملة
+type ReceiveStockTransferRequest struct {
+	Lines []struct {
+		LineID   uuid.UUID `json:"line_id" binding:"required"`
+		Quantity int       `json:"quantity" binding:"min=0"`
+	} `json:"lines" binding:"dive"`
+}
+
+// GetStockTransfers الحصول على تحويلات المخزون مع التصفية والترقيم (Admin)
+func GetStockTransfers(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	query := config.DB.Model(&models.StockTransfer{})
+	for _, filter := range []string{"from_location_id", "to_location_id", "status"} {
+		if value := c.Query(filter); value != "" {
+			query = query.Where(filter+" = ?", value)
+		}
+	}
+	if locationID := c.Query("location_id"); locationID != "" {
+		query = query.Where("from_location_id = ? OR to_location_id = ?", locationID, locationID)
+	}
+	if search := c.Query("search"); search != "" {
+		query = query.Where("transfer_number ILIKE ?", "%"+search+"%")
+	}
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to count stock transfers", err.Error())
+		return
+	}
+
+	var transfers []models.StockTransfer
+	if err := query.Preload("FromLocation").Preload("ToLocation").
+		Order("created_at DESC").
+		Limit(limit).
+		Offset((page - 1) * limit).
+		Find(&transfers).Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch stock transfers", err.Error())
+		return
+	}
+
+	utils.PaginatedSuccessResponse(c, "Stock transfers retrieved successfully", transfers, utils.CalculatePagination(page, limit, total))
+}
+
+// GetStockTransfer الحصول على تحويل بأسطره ودفعاته (Admin)
+func GetStockTransfer(c *gin.Context) {
+	transferUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid stock transfer ID", err.Error())
+		return
+	}
+
+	transfer, err := services.LoadStockTransfer(config.DB, transferUUID)
+	if err != nil {
+		respondLocationError(c, "Failed to fetch stock transfer", err)
+		return
+	}
+	utils.SuccessResponse(c, "Stock transfer retrieved successfully", transfer)
+}
+
+// CreateStockTransfer طلب تحويل مخزون من فرع إلى آخر (Admin)
+func CreateStockTransfer(c *gin.Context) {
+	var req CreateStockTransferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+	input := services.StockTransferInput{
+		FromLocationID: req.FromLocationID,
+		ToLocationID:   req.ToLocationID,
+		Notes:          req.Notes,
+	}
+	for _, line := range req.Lines {
+		input.Lines = append(input.Lines, services.StockTransferLineInput{ProductID: line.ProductID, Quantity: line.Quantity})
+	}
+
+	tx := config.DB.Begin()
+	transfer, err := services.CreateStockTransfer(tx, input, currentAdminID(c))
+	if err != nil {
+		tx.Rollback()
+		respondLocationError(c, "Failed to create stock transfer", err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to create stock transfer", err.Error())
+		return
+	}
+
+	utils.CreatedResponse(c, "Stock transfer created successfully", transfer)
+}
+
+// DispatchStockTransfer إخراج بضاعة التحويل من الفرع المرسل (Admin)
+func DispatchStockTransfer(c *gin.Context) {
+	transferUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid stock transfer ID", err.Error())
+		return
+	}
+
+	tx := config.DB.Begin()
+	transfer, err := services.DispatchStockTransfer(tx, transferUUID, currentAdminID(c))
+	if err != nil {
+		tx.Rollback()
+		respondLocationError(c, "Failed to dispatch stock transfer", err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to dispatch stock transfer", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Stock transfer dispatched successfully", transfer)
+}
+
+// ReceiveStockTransfer استلام بضاعة التحويل في الفرع المستلم (Admin)
+func ReceiveStockTransfer(c *gin.Context) {
+	transferUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid stock transfer ID", err.Error())
+		return
+	}
+
+	var req ReceiveStockTransferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+	receipts := make([]services.TransferReceipt, 0, len(req.Lines))
+	for _, line := range req.Lines {
+		receipts = append(receipts, services.TransferReceipt{LineID: line.LineID, Quantity: line.Quantity})
+	}
+
+	tx := config.DB.Begin()
+	transfer, err := services.ReceiveStockTransfer(tx, transferUUID, receipts, currentAdminID(c))
+	if err != nil {
+		tx.Rollback()
+		respondLocationError(c, "Failed to receive stock transfer", err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to receive stock transfer", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Stock transfer received successfully", transfer)
+}
+
+// CancelStockTransfer إلغاء طلب تحويل لم يُرسل بعد (Admin)
+func CancelStockTransfer(c *gin.Context) {
+	transferUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid stock transfer ID", err.Error())
+		return
+	}
+
+	tx := config.DB.Begin()
+	transfer, err := services.CancelStockTransfer(tx, transferUUID)
+	if err != nil {
+		tx.Rollback()
+		respondLocationError(c, "Failed to cancel stock transfer", err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to cancel stock transfer", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Stock transfer cancelled successfully", transfer)
+}
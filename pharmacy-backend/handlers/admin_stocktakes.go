@@ -0,0 +1,415 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"pharmacy-backend/config"
+	"pharmacy-backend/models"
+	"pharmacy-backend/services"
+	"pharmacy-backend/utils"
+)
+
+// CreateStocktakeRequest بنية طلب فتح جلسة جرد
+// بدون تصنيف يشمل الجرد كل المنتجات.
+type CreateStocktakeRequest struct {
+	CategoryID *uuid.UUID `json:"category_id"`
+	Notes      string     `json:"notes"`
+}
+
+// StocktakeCountsRequest بنية طلب تسجيل كميات معدودة
+type StocktakeCountsRequest struct {
+	Counts []StocktakeCountRequest `json:"counts" binding:"required,min=1,dive"`
+}
+
+// StocktakeCountRequest كمية معدودة لسطر أو لباركود ممسوح
+// مع add=true تُضاف الكمية إلى ما عُد سابقاً (مسح عبوة بعد أخرى).
+type StocktakeCountRequest struct {
+	LineID      *uuid.UUID              `json:"line_id"`
+	Barcode     string                  `json:"barcode"`
+	BatchNumber string                  `json:"batch_number"`
+	Quantity    *int                    `json:"quantity" binding:"required,min=0"`
+	Add         bool                    `json:"add"`
+	ReasonCode  models.AdjustmentReason `json:"reason_code"`
+}
+
+// SubmitStocktakeRequest بنية طلب إنهاء العد
+type SubmitStocktakeRequest struct {
+	ZeroUncounted bool `json:"zero_uncounted"`
+}
+
+// StockAdjustmentRequest بنية طلب تسوية مخزون مباشرة
+// الكمية فرق موجب أو سالب؛ الدفعة مطلوبة للمنتجات المتتبعة بالدفعات.
+type StockAdjustmentRequest struct {
+	ProductID  uuid.UUID               `json:"product_id" binding:"required"`
+	BatchID    *uuid.UUID              `json:"batch_id"`
+	Quantity   int                     `json:"quantity" binding:"required"`
+	ReasonCode models.AdjustmentReason `json:"reason_code" binding:"required"`
+	Notes      string                  `json:"notes"`
+}
+
+// GetStocktakes الحصول على جلسات الجرد مع الترقيم (Admin)
+func GetStocktakes(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	query := config.DB.Model(&models.Stocktake{})
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if categoryID := c.Query("category_id"); categoryID != "" {
+		query = query.Where("category_id = ?", categoryID)
+	}
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to count stocktakes", err.Error())
+		return
+	}
+
+	var stocktakes []models.Stocktake
+	if err := query.Preload("Category").
+		Order("created_at DESC").
+		Limit(limit).
+		Offset((page - 1) * limit).
+		Find(&stocktakes).Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch stocktakes", err.Error())
+		return
+	}
+
+	utils.PaginatedSuccessResponse(c, "Stocktakes retrieved successfully", stocktakes, utils.CalculatePagination(page, limit, total))
+}
+
+// GetStocktake الحصول على جلسة جرد بأسطرها وملخص فروقاتها (Admin)
+// only_variances=true يعرض الأسطر المعدودة التي تختلف عن المتوقع فقط، لمراجعتها قبل الاعتماد.
+func GetStocktake(c *gin.Context) {
+	stocktakeUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid stocktake ID", err.Error())
+		return
+	}
+
+	var stocktake models.Stocktake
+	if err := config.DB.Preload("Category").First(&stocktake, "id = ?", stocktakeUUID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.NotFoundResponse(c, "Stocktake not found")
+		} else {
+			utils.InternalServerErrorResponse(c, "Failed to fetch stocktake", err.Error())
+		}
+		return
+	}
+
+	var lines []models.StocktakeLine
+	if err := config.DB.Preload("Product").
+		Joins("JOIN products ON products.id = stocktake_lines.product_id").
+		Where("stocktake_lines.stocktake_id = ?", stocktake.ID).
+		Order("products.name ASC, stocktake_lines.expiry_date ASC NULLS LAST").
+		Find(&lines).Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch stocktake lines", err.Error())
+		return
+	}
+	summary := services.SummarizeStocktake(lines)
+
+	if c.Query("only_variances") == "true" {
+		filtered := lines[:0]
+		for _, line := range lines {
+			if line.CountedQuantity != nil && line.Variance != 0 {
+				filtered = append(filtered, line)
+			}
+		}
+		lines = filtered
+	}
+	stocktake.Lines = lines
+
+	utils.SuccessResponse(c, "Stocktake retrieved successfully", gin.H{
+		"stocktake": stocktake,
+		"summary":   summary,
+	})
+}
+
+// CreateStocktake فتح جلسة جرد لكل المنتجات أو لتصنيف (Admin)
+func CreateStocktake(c *gin.Context) {
+	var req CreateStocktakeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+	if req.CategoryID != nil {
+		var category models.Category
+		if err := config.DB.Select("id").First(&category, "id = ?", *req.CategoryID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				utils.BadRequestResponse(c, "Category not found", "")
+			} else {
+				utils.InternalServerErrorResponse(c, "Failed to fetch category", err.Error())
+			}
+			return
+		}
+	}
+
+	tx := config.DB.Begin()
+	stocktake, err := services.CreateStocktake(tx, req.CategoryID, req.Notes, currentAdminID(c))
+	if err != nil {
+		tx.Rollback()
+		respondStocktakeError(c, "Failed to create stocktake", err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to create stocktake", err.Error())
+		return
+	}
+
+	utils.CreatedResponse(c, "Stocktake created successfully", gin.H{
+		"stocktake": stocktake,
+		"summary":   services.SummarizeStocktake(stocktake.Lines),
+	})
+}
+
+// RecordStocktakeCounts تسجيل كميات معدودة يدوياً أو بمسح الباركود (Admin)
+func RecordStocktakeCounts(c *gin.Context) {
+	stocktakeUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid stocktake ID", err.Error())
+		return
+	}
+
+	var req StocktakeCountsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+	counts := make([]services.StocktakeCount, 0, len(req.Counts))
+	for _, count := range req.Counts {
+		counts = append(counts, services.StocktakeCount{
+			LineID:      count.LineID,
+			Barcode:     count.Barcode,
+			BatchNumber: count.BatchNumber,
+			Quantity:    *count.Quantity,
+			Add:         count.Add,
+			ReasonCode:  count.ReasonCode,
+		})
+	}
+
+	tx := config.DB.Begin()
+	stocktake, err := services.RecordStocktakeCounts(tx, stocktakeUUID, counts, currentAdminID(c))
+	if err != nil {
+		tx.Rollback()
+		respondStocktakeError(c, "Failed to record counts", err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to record counts", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Counts recorded successfully", gin.H{
+		"stocktake_id": stocktake.ID,
+		"summary":      services.SummarizeStocktake(stocktake.Lines),
+	})
+}
+
+// SubmitStocktake إنهاء العد وإرسال الفروقات للاعتماد (Admin)
+func SubmitStocktake(c *gin.Context) {
+	stocktakeUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid stocktake ID", err.Error())
+		return
+	}
+
+	var req SubmitStocktakeRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.BadRequestResponse(c, "Invalid request data", err.Error())
+			return
+		}
+	}
+
+	tx := config.DB.Begin()
+	stocktake, err := services.SubmitStocktake(tx, stocktakeUUID, req.ZeroUncounted, currentAdminID(c))
+	if err != nil {
+		tx.Rollback()
+		respondStocktakeError(c, "Failed to submit stocktake", err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to submit stocktake", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Stocktake submitted for approval", gin.H{
+		"stocktake_id": stocktake.ID,
+		"status":       stocktake.Status,
+		"summary":      services.SummarizeStocktake(stocktake.Lines),
+	})
+}
+
+// ApproveStocktake اعتماد فروقات الجرد وتسجيلها كحركات تسوية (Admin)
+func ApproveStocktake(c *gin.Context) {
+	stocktakeUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid stocktake ID", err.Error())
+		return
+	}
+
+	tx := config.DB.Begin()
+	stocktake, err := services.ApproveStocktake(tx, stocktakeUUID, currentAdminID(c))
+	if err != nil {
+		tx.Rollback()
+		respondStocktakeError(c, "Failed to approve stocktake", err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to approve stocktake", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Stocktake approved successfully", gin.H{
+		"stocktake_id": stocktake.ID,
+		"status":       stocktake.Status,
+		"summary":      services.SummarizeStocktake(stocktake.Lines),
+	})
+}
+
+// ReopenStocktake إعادة جلسة مرسلة للعد (Admin)
+func ReopenStocktake(c *gin.Context) {
+	changeStocktakeStatus(c, "Failed to reopen stocktake", "Stocktake reopened", services.ReopenStocktake)
+}
+
+// CancelStocktake إلغاء جلسة جرد لم تُعتمد (Admin)
+func CancelStocktake(c *gin.Context) {
+	changeStocktakeStatus(c, "Failed to cancel stocktake", "Stocktake cancelled", services.CancelStocktake)
+}
+
+// changeStocktakeStatus تنفيذ انتقال حالة لا يغير المخزون
+func changeStocktakeStatus(c *gin.Context, failure, success string, transition func(*gorm.DB, uuid.UUID) (*models.Stocktake, error)) {
+	stocktakeUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid stocktake ID", err.Error())
+		return
+	}
+
+	tx := config.DB.Begin()
+	stocktake, err := transition(tx, stocktakeUUID)
+	if err != nil {
+		tx.Rollback()
+		respondStocktakeError(c, failure, err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		utils.InternalServerErrorResponse(c, failure, err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, success, gin.H{
+		"stocktake_id": stocktake.ID,
+		"status":       stocktake.Status,
+	})
+}
+
+// CreateStockAdjustment تسوية مخزون منتج أو دفعة بسبب تلف أو سرقة أو انتهاء صلاحية أو تصحيح عد (Admin)
+func CreateStockAdjustment(c *gin.Context) {
+	var req StockAdjustmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	tx := config.DB.Begin()
+	movement, err := services.AdjustStock(tx, services.StockAdjustment{
+		ProductID: req.ProductID,
+		BatchID:   req.BatchID,
+		Quantity:  req.Quantity,
+		Reason:    req.ReasonCode,
+		Notes:     req.Notes,
+		ActorID:   currentAdminID(c),
+	})
+	if err != nil {
+		tx.Rollback()
+		respondStocktakeError(c, "Failed to adjust stock", err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to adjust stock", err.Error())
+		return
+	}
+
+	utils.CreatedResponse(c, "Stock adjusted successfully", movement)
+}
+
+// GetStockAdjustments سجل حركات التسوية مع أسبابها (Admin)
+func GetStockAdjustments(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 50
+	}
+
+	query := config.DB.Model(&models.InventoryTransaction{}).
+		Where("transaction_type = ?", models.TransactionTypeAdjustment)
+	if productID := c.Query("product_id"); productID != "" {
+		query = query.Where("product_id = ?", productID)
+	}
+	if reason := c.Query("reason_code"); reason != "" {
+		query = query.Where("reason_code = ?", reason)
+	}
+	if from := c.Query("from"); from != "" {
+		if t, err := time.Parse("2006-01-02", from); err == nil {
+			query = query.Where("created_at >= ?", t)
+		}
+	}
+	if to := c.Query("to"); to != "" {
+		if t, err := time.Parse("2006-01-02", to); err == nil {
+			query = query.Where("created_at < ?", t.AddDate(0, 0, 1))
+		}
+	}
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to count adjustments", err.Error())
+		return
+	}
+
+	var movements []models.InventoryTransaction
+	if err := query.Preload("Product").
+		Order("created_at DESC").
+		Limit(limit).
+		Offset((page - 1) * limit).
+		Find(&movements).Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch adjustments", err.Error())
+		return
+	}
+
+	utils.PaginatedSuccessResponse(c, "Stock adjustments retrieved successfully", movements, utils.CalculatePagination(page, limit, total))
+}
+
+// respondStocktakeError تحويل أخطاء الجرد والتسوية إلى استجابة مناسبة
+func respondStocktakeError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, services.ErrStocktakeNotFound):
+		utils.NotFoundResponse(c, "Stocktake not found")
+	case errors.Is(err, services.ErrProductNotFound):
+		utils.NotFoundResponse(c, "Product not found")
+	case errors.Is(err, services.ErrBatchNotFound):
+		utils.NotFoundResponse(c, "Batch not found")
+	case errors.Is(err, services.ErrStocktakeState), errors.Is(err, services.ErrStocktakeOverlap):
+		utils.ErrorResponse(c, http.StatusConflict, message, err.Error())
+	case services.IsStocktakeError(err), services.IsAdjustmentError(err), errors.Is(err, services.ErrInsufficientStock):
+		utils.BadRequestResponse(c, message, err.Error())
+	default:
+		utils.InternalServerErrorResponse(c, message, err.Error())
+	}
+}
@@ -0,0 +1,443 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"pharmacy-backend/config"
+	"pharmacy-backend/models"
+	"pharmacy-backend/services"
+	"pharmacy-backend/utils"
+)
+
+// SupplierRequest بنية طلب إنشاء أو تعديل مورد
+// الرصيد لا يُعدل من هنا؛ يتغير فقط بقيود الدفتر.
+type SupplierRequest struct {
+	Name          string `json:"name" binding:"required"`
+	ContactPerson string `json:"contact_person"`
+	Phone         string `json:"phone"`
+	Email         string `json:"email" binding:"omitempty,email"`
+	Address       string `json:"address"`
+	TaxNumber     string `json:"tax_number"`
+	LeadTimeDays  *int   `json:"lead_time_days" binding:"omitempty,min=0,max=365"`
+	Notes         string `json:"notes"`
+	IsActive      *bool  `json:"is_active"`
+}
+
+// SupplierPaymentRequest بنية طلب تسجيل دفعة للمورد
+type SupplierPaymentRequest struct {
+	Amount          float64   `json:"amount" binding:"required,gt=0"`
+	PaymentDate     time.Time `json:"payment_date" binding:"required"`
+	Method          string    `json:"method"`
+	ReferenceNumber string    `json:"reference_number"`
+	Notes           string    `json:"notes"`
+}
+
+// SupplierReturnRequest بنية طلب مرتجع بضاعة للمورد
+type SupplierReturnRequest struct {
+	ReturnDate      time.Time                   `json:"return_date" binding:"required"`
+	ReferenceNumber string                      `json:"reference_number" binding:"required"`
+	TaxAmount       float64                     `json:"tax_amount" binding:"min=0"`
+	Reason          string                      `json:"reason"`
+	Lines           []SupplierReturnLineRequest `json:"lines" binding:"required,min=1,dive"`
+}
+
+// SupplierReturnLineRequest سطر في طلب المرتجع
+type SupplierReturnLineRequest struct {
+	BatchID  uuid.UUID `json:"batch_id" binding:"required"`
+	Quantity int       `json:"quantity" binding:"required,min=1"`
+}
+
+// apply نسخ قيم الطلب إلى المورد
+func (req *SupplierRequest) apply(supplier *models.Supplier) {
+	supplier.Name = strings.TrimSpace(req.Name)
+	supplier.ContactPerson = strings.TrimSpace(req.ContactPerson)
+	supplier.Phone = strings.TrimSpace(req.Phone)
+	supplier.Email = strings.TrimSpace(req.Email)
+	supplier.Address = strings.TrimSpace(req.Address)
+	supplier.TaxNumber = strings.TrimSpace(req.TaxNumber)
+	supplier.Notes = strings.TrimSpace(req.Notes)
+	if req.LeadTimeDays != nil {
+		supplier.LeadTimeDays = *req.LeadTimeDays
+	}
+	if req.IsActive != nil {
+		supplier.IsActive = *req.IsActive
+	}
+}
+
+// GetSuppliers الحصول على الموردين مع البحث والترقيم (Admin)
+func GetSuppliers(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 50
+	}
+
+	query := config.DB.Model(&models.Supplier{})
+	if search := c.Query("search"); search != "" {
+		like := "%" + search + "%"
+		query = query.Where("name ILIKE ? OR contact_person ILIKE ? OR phone ILIKE ? OR tax_number ILIKE ?", like, like, like, like)
+	}
+	if active := c.Query("is_active"); active != "" {
+		query = query.Where("is_active = ?", active == "true")
+	}
+	if c.Query("with_balance") == "true" {
+		query = query.Where("balance <> 0")
+	}
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to count suppliers", err.Error())
+		return
+	}
+
+	var suppliers []models.Supplier
+	if err := query.Order("name ASC").
+		Limit(limit).
+		Offset((page - 1) * limit).
+		Find(&suppliers).Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch suppliers", err.Error())
+		return
+	}
+
+	utils.PaginatedSuccessResponse(c, "Suppliers retrieved successfully", suppliers, utils.CalculatePagination(page, limit, total))
+}
+
+// GetSupplier الحصول على مورد مع أعمار رصيده الحالي (Admin)
+func GetSupplier(c *gin.Context) {
+	supplier, ok := loadSupplier(c)
+	if !ok {
+		return
+	}
+
+	var entries []models.SupplierLedgerEntry
+	if err := config.DB.Where("supplier_id = ?", supplier.ID).Order("entry_date ASC, created_at ASC").Find(&entries).Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch supplier ledger", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Supplier retrieved successfully", gin.H{
+		"supplier": supplier,
+		"aging":    services.AgeSupplierEntries(entries, time.Now()),
+	})
+}
+
+// CreateSupplier إنشاء مورد جديد برصيد صفري (Admin)
+func CreateSupplier(c *gin.Context) {
+	var req SupplierRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	supplier := models.Supplier{IsActive: true}
+	req.apply(&supplier)
+	if supplier.Name == "" {
+		utils.BadRequestResponse(c, "Supplier name is required", "")
+		return
+	}
+
+	if err := config.DB.Create(&supplier).Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to create supplier", err.Error())
+		return
+	}
+
+	utils.CreatedResponse(c, "Supplier created successfully", supplier)
+}
+
+// UpdateSupplier تعديل بيانات مورد؛ تُستبدل بالقيم المرسلة (Admin)
+func UpdateSupplier(c *gin.Context) {
+	supplier, ok := loadSupplier(c)
+	if !ok {
+		return
+	}
+
+	var req SupplierRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+	req.apply(supplier)
+	if supplier.Name == "" {
+		utils.BadRequestResponse(c, "Supplier name is required", "")
+		return
+	}
+	supplier.UpdatedAt = time.Now()
+
+	if err := config.DB.Model(supplier).
+		Select("name", "contact_person", "phone", "email", "address", "tax_number", "lead_time_days", "notes", "is_active", "updated_at").
+		Updates(supplier).Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to update supplier", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Supplier updated successfully", supplier)
+}
+
+// DeleteSupplier حذف مورد لا تعاملات له؛ غير ذلك يُعطّل بـ is_active (Admin)
+func DeleteSupplier(c *gin.Context) {
+	supplierUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid supplier ID", err.Error())
+		return
+	}
+
+	tx := config.DB.Begin()
+	if err := services.DeleteSupplier(tx, supplierUUID); err != nil {
+		tx.Rollback()
+		respondSupplierError(c, "Failed to delete supplier", err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to delete supplier", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Supplier deleted successfully", nil)
+}
+
+// GetSupplierTransactions قيود دفتر المورد مع التصفية بالنوع والتاريخ (Admin)
+func GetSupplierTransactions(c *gin.Context) {
+	supplier, ok := loadSupplier(c)
+	if !ok {
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 200 {
+		limit = 50
+	}
+
+	query := config.DB.Model(&models.SupplierLedgerEntry{}).Where("supplier_id = ?", supplier.ID)
+	if entryType := c.Query("type"); entryType != "" {
+		query = query.Where("entry_type = ?", entryType)
+	}
+	for param, condition := range map[string]string{"from": "entry_date >= ?", "to": "entry_date <= ?"} {
+		if value := c.Query(param); value != "" {
+			date, err := time.Parse("2006-01-02", value)
+			if err != nil {
+				utils.BadRequestResponse(c, "Invalid "+param+" date", err.Error())
+				return
+			}
+			query = query.Where(condition, date)
+		}
+	}
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to count supplier transactions", err.Error())
+		return
+	}
+
+	var entries []models.SupplierLedgerEntry
+	if err := query.Order("entry_date DESC, created_at DESC").
+		Limit(limit).
+		Offset((page - 1) * limit).
+		Find(&entries).Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch supplier transactions", err.Error())
+		return
+	}
+
+	utils.PaginatedSuccessResponse(c, "Supplier transactions retrieved successfully", entries, utils.CalculatePagination(page, limit, total))
+}
+
+// RecordSupplierPayment تسجيل دفعة للمورد (Admin)
+func RecordSupplierPayment(c *gin.Context) {
+	supplierUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid supplier ID", err.Error())
+		return
+	}
+
+	var req SupplierPaymentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	tx := config.DB.Begin()
+	entry, err := services.RecordSupplierPayment(tx, services.SupplierPaymentInput{
+		SupplierID:      supplierUUID,
+		Amount:          req.Amount,
+		PaymentDate:     req.PaymentDate,
+		Method:          req.Method,
+		ReferenceNumber: req.ReferenceNumber,
+		Notes:           req.Notes,
+	}, currentAdminID(c))
+	if err != nil {
+		tx.Rollback()
+		respondSupplierError(c, "Failed to record supplier payment", err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to record supplier payment", err.Error())
+		return
+	}
+
+	utils.CreatedResponse(c, "Supplier payment recorded successfully", entry)
+}
+
+// CreateSupplierReturn إرجاع كميات من دفعات المورد وإنقاص المستحق له (Admin)
+func CreateSupplierReturn(c *gin.Context) {
+	supplierUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid supplier ID", err.Error())
+		return
+	}
+
+	var req SupplierReturnRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	input := services.SupplierReturnInput{
+		SupplierID:      supplierUUID,
+		ReturnDate:      req.ReturnDate,
+		ReferenceNumber: req.ReferenceNumber,
+		TaxAmount:       req.TaxAmount,
+		Reason:          req.Reason,
+	}
+	for _, line := range req.Lines {
+		input.Lines = append(input.Lines, services.SupplierReturnLine{BatchID: line.BatchID, Quantity: line.Quantity})
+	}
+
+	tx := config.DB.Begin()
+	entry, err := services.ReturnToSupplier(tx, input, currentAdminID(c))
+	if err != nil {
+		tx.Rollback()
+		respondSupplierError(c, "Failed to record supplier return", err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to record supplier return", err.Error())
+		return
+	}
+
+	utils.CreatedResponse(c, "Supplier return recorded successfully", entry)
+}
+
+// GetSupplierStatement كشف حساب المورد لفترة بصيغة JSON أو CSV أو PDF (Admin)
+// GET /admin/suppliers/:id/statement?from=YYYY-MM-DD&to=YYYY-MM-DD&format=csv
+func GetSupplierStatement(c *gin.Context) {
+	supplierUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid supplier ID", err.Error())
+		return
+	}
+
+	to, err := time.Parse("2006-01-02", c.DefaultQuery("to", time.Now().Format("2006-01-02")))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid to date", err.Error())
+		return
+	}
+	from, err := time.Parse("2006-01-02", c.DefaultQuery("from", to.AddDate(0, -1, 0).Format("2006-01-02")))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid from date", err.Error())
+		return
+	}
+	if from.After(to) {
+		utils.BadRequestResponse(c, "Invalid period", "from must not be after to")
+		return
+	}
+
+	statement, err := services.LoadSupplierStatement(config.DB, supplierUUID, from, to)
+	if err != nil {
+		respondSupplierError(c, "Failed to build supplier statement", err)
+		return
+	}
+
+	filename := fmt.Sprintf("supplier-statement-%s-%s", from.Format("20060102"), to.Format("20060102"))
+	var buf bytes.Buffer
+	switch c.DefaultQuery("format", "json") {
+	case "csv":
+		if err := services.RenderSupplierStatementCSV(statement, &buf); err != nil {
+			utils.InternalServerErrorResponse(c, "Failed to render supplier statement", err.Error())
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, filename))
+		c.Data(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+	case "pdf":
+		if err := services.RenderSupplierStatementPDF(statement, &buf); err != nil {
+			utils.InternalServerErrorResponse(c, "Failed to render supplier statement", err.Error())
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.pdf"`, filename))
+		c.Data(http.StatusOK, "application/pdf", buf.Bytes())
+	case "json":
+		utils.SuccessResponse(c, "Supplier statement generated successfully", statement)
+	default:
+		utils.BadRequestResponse(c, "Invalid format", "format must be json, csv or pdf")
+	}
+}
+
+// GetSupplierAgingReport تقرير أعمار أرصدة الموردين 0-30/31-60/61-90/90+ يوماً (Admin)
+func GetSupplierAgingReport(c *gin.Context) {
+	asOf, err := time.Parse("2006-01-02", c.DefaultQuery("as_of", time.Now().Format("2006-01-02")))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid as_of date", err.Error())
+		return
+	}
+
+	rows, totals, err := services.SupplierAgingReport(config.DB, asOf)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to generate supplier aging report", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Supplier aging report generated successfully", gin.H{
+		"as_of":     asOf.Format("2006-01-02"),
+		"suppliers": rows,
+		"totals":    totals,
+	})
+}
+
+// loadSupplier تحميل المورد من معرف المسار
+func loadSupplier(c *gin.Context) (*models.Supplier, bool) {
+	supplierUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid supplier ID", err.Error())
+		return nil, false
+	}
+
+	var supplier models.Supplier
+	if err := config.DB.First(&supplier, "id = ?", supplierUUID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.NotFoundResponse(c, "Supplier not found")
+		} else {
+			utils.InternalServerErrorResponse(c, "Failed to fetch supplier", err.Error())
+		}
+		return nil, false
+	}
+	return &supplier, true
+}
+
+// respondSupplierError تحويل أخطاء خدمة حساب المورد إلى استجابة مناسبة
+func respondSupplierError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, services.ErrSupplierNotFound):
+		utils.NotFoundResponse(c, "Supplier not found")
+	case errors.Is(err, services.ErrSupplierInUse), errors.Is(err, services.ErrInsufficientStock):
+		utils.ErrorResponse(c, http.StatusConflict, message, err.Error())
+	case services.IsSupplierLedgerError(err), services.IsBatchError(err):
+		utils.BadRequestResponse(c, message, err.Error())
+	default:
+		utils.InternalServerErrorResponse(c, message, err.Error())
+	}
+}
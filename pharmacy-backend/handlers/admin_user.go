@@ -112,7 +112,7 @@ type UpdateUserRequest struct {
 	FullName  *string           `json:"full_name,omitempty"`
 	Phone     *string           `json:"phone,omitempty"`
 	Email     *string           `json:"email,omitempty" binding:"omitempty,email"`
-	Role      *models.UserRole  `json:"role,omitempty" binding:"omitempty,oneof=customer admin super_admin wholesale"`
+	Role      *models.UserRole  `json:"role,omitempty" binding:"omitempty,oneof=customer admin super_admin wholesale pharmacist"`
 	IsActive  *bool             `json:"is_active,omitempty"`
 	// Wholesale specific fields
 	CompanyName        *string `json:"company_name,omitempty"`
@@ -9,6 +9,7 @@ import (
 
 	"pharmacy-backend/config"
 	"pharmacy-backend/models"
+	"pharmacy-backend/services"
 	"pharmacy-backend/utils"
 
 	"github.com/gin-gonic/gin"
@@ -232,6 +233,7 @@ type UpdateProfileRequest struct {
 	FullName    string     `json:"full_name"`
 	Phone       string     `json:"phone"`
 	DateOfBirth *time.Time `json:"date_of_birth,omitempty"`
+	VATNumber   *string    `json:"vat_number,omitempty"` // الرقم الضريبي للمنشآت لإصدار فاتورة ضريبية
 }
 
 // UpdateProfile تحديث ملف المستخدم
@@ -250,6 +252,15 @@ func UpdateProfile(c *gin.Context) {
 		return
 	}
 
+	if req.VATNumber != nil {
+		vat := strings.TrimSpace(*req.VATNumber)
+		if vat != "" && !services.ValidVATNumber(vat) {
+			utils.BadRequestResponse(c, "Invalid VAT number", "VAT number must be 15 digits starting and ending with 3")
+			return
+		}
+		userObj.VATNumber = vat
+	}
+
 	userObj.FullName = req.FullName
 	userObj.Phone = req.Phone
 	userObj.DateOfBirth = req.DateOfBirth
@@ -1,8 +1,12 @@
 package handlers
 
 import (
+	"errors"
+	"strconv"
+
 	"pharmacy-backend/config"
 	"pharmacy-backend/models"
+	"pharmacy-backend/services"
 	"pharmacy-backend/utils"
 	
 	"github.com/gin-gonic/gin"
@@ -91,9 +95,17 @@ func AddToCart(c *gin.Context) {
 	var existingItem models.CartItem
 	err := config.DB.Where("user_id = ? AND product_id = ?", userID, req.ProductID).First(&existingItem).Error
 	
+	// التحقق من حدود الكمية النظامية مقابل سجل مشتريات العميل
+	newQuantity := req.Quantity
+	if err == nil {
+		newQuantity += existingItem.Quantity
+	}
+	if !checkCartQuantityLimits(c, &product, newQuantity) {
+		return
+	}
+	
 	if err == nil {
 		// تحديث الكمية إذا كان المنتج موجود
-		newQuantity := existingItem.Quantity + req.Quantity
 		if product.StockQuantity < newQuantity {
 			utils.BadRequestResponse(c, "Insufficient stock", "Not enough quantity available")
 			return
@@ -172,6 +184,9 @@ func UpdateCartItem(c *gin.Context) {
 		utils.BadRequestResponse(c, "Insufficient stock", "Not enough quantity available")
 		return
 	}
+	if !checkCartQuantityLimits(c, &cartItem.Product, req.Quantity) {
+		return
+	}
 	
 	// تحديث الكمية
 	cartItem.Quantity = req.Quantity
@@ -232,3 +247,53 @@ func ClearCart(c *gin.Context) {
 	utils.SuccessResponse(c, "Cart cleared successfully", nil)
 }
 
+// ValidateCart تسعير السلة على الخادم مع تحذيرات التداخل الدوائي وتكرار العلاج
+// include_history=true يضيف أدوية الطلبات السابقة خلال DRUG_INTERACTION_HISTORY_DAYS يوماً.
+func ValidateCart(c *gin.Context) {
+	user, ok := subscriptionUser(c)
+	if !ok {
+		return
+	}
+	includeHistory, _ := strconv.ParseBool(c.DefaultQuery("include_history", "true"))
+
+	quote, err := services.QuoteCart(config.DB, user, services.LoadPricingSettings())
+	if err != nil {
+		if isPricingError(err) {
+			utils.BadRequestResponse(c, "Cart contains invalid items", err.Error())
+			return
+		}
+		utils.InternalServerErrorResponse(c, "Failed to validate cart", err.Error())
+		return
+	}
+
+	warnings, err := services.CheckQuoteInteractions(config.DB, user.ID, quote.Lines, includeHistory)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to check drug interactions", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Cart validated successfully", gin.H{
+		"quote":    quote,
+		"warnings": warnings,
+	})
+}
+
+// checkCartQuantityLimits رفض تعديل السلة إذا تجاوز حدود الكمية وتسجيل المحاولة
+func checkCartQuantityLimits(c *gin.Context, product *models.Product, quantity int) bool {
+	user, ok := subscriptionUser(c)
+	if !ok {
+		return false
+	}
+	err := services.CheckCartQuantityLimits(config.DB, user, product, quantity)
+	if err == nil {
+		return true
+	}
+	var limitErr *services.QuantityLimitError
+	if errors.As(err, &limitErr) {
+		services.RecordQuantityLimitViolation(config.DB, user.ID, services.QuantityLimitSourceCart, err)
+		respondQuantityLimit(c, limitErr)
+		return false
+	}
+	utils.InternalServerErrorResponse(c, "Failed to check quantity limits", err.Error())
+	return false
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"pharmacy-backend/config"
+	"pharmacy-backend/models"
+	"pharmacy-backend/services"
+	"pharmacy-backend/utils"
+)
+
+// GetOrderInvoices الحصول على فاتورة الطلب وإشعاراته الدائنة للمستخدم الحالي
+func GetOrderInvoices(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	orderUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid order ID", err.Error())
+		return
+	}
+
+	var order models.Order
+	if err := config.DB.Select("id").Where("id = ? AND user_id = ?", orderUUID, userID).First(&order).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			utils.NotFoundResponse(c, "Order not found")
+		} else {
+			utils.InternalServerErrorResponse(c, "Failed to fetch order", err.Error())
+		}
+		return
+	}
+
+	var invoices []models.Invoice
+	if err := config.DB.
+		Preload("Lines", func(db *gorm.DB) *gorm.DB { return db.Order("sort_order ASC") }).
+		Where("order_id = ?", order.ID).
+		Order("issued_at ASC").
+		Find(&invoices).Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch invoices", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Invoices retrieved successfully", invoices)
+}
+
+// DownloadInvoicePDF تنزيل فاتورة أو إشعار دائن للمستخدم الحالي بصيغة PDF
+func DownloadInvoicePDF(c *gin.Context) {
+	if invoice, ok := loadUserInvoice(c); ok {
+		sendInvoicePDF(c, invoice)
+	}
+}
+
+// DownloadInvoiceXML تنزيل فاتورة أو إشعار دائن للمستخدم الحالي بصيغة UBL XML
+func DownloadInvoiceXML(c *gin.Context) {
+	if invoice, ok := loadUserInvoice(c); ok {
+		sendInvoiceXML(c, invoice)
+	}
+}
+
+// loadUserInvoice تحميل مستند من معرف المسار بشرط أن يخص المستخدم الحالي
+func loadUserInvoice(c *gin.Context) (*models.Invoice, bool) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return nil, false
+	}
+	return loadInvoice(c, func(db *gorm.DB) *gorm.DB { return db.Where("user_id = ?", userID) })
+}
+
+// loadInvoice تحميل مستند من معرف المسار مع أسطره
+func loadInvoice(c *gin.Context, scope func(*gorm.DB) *gorm.DB) (*models.Invoice, bool) {
+	invoiceUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid invoice ID", err.Error())
+		return nil, false
+	}
+
+	var invoice models.Invoice
+	if err := config.DB.Scopes(scope).
+		Preload("Lines", func(db *gorm.DB) *gorm.DB { return db.Order("sort_order ASC") }).
+		First(&invoice, "id = ?", invoiceUUID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			utils.NotFoundResponse(c, "Invoice not found")
+		} else {
+			utils.InternalServerErrorResponse(c, "Failed to fetch invoice", err.Error())
+		}
+		return nil, false
+	}
+	return &invoice, true
+}
+
+func sendInvoicePDF(c *gin.Context, invoice *models.Invoice) {
+	var buf bytes.Buffer
+	if err := services.RenderInvoicePDF(invoice, &buf); err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to render invoice", err.Error())
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.pdf"`, invoice.InvoiceNumber))
+	c.Data(http.StatusOK, "application/pdf", buf.Bytes())
+}
+
+func sendInvoiceXML(c *gin.Context, invoice *models.Invoice) {
+	body, err := services.RenderInvoiceXML(invoice)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to render invoice", err.Error())
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.xml"`, invoice.InvoiceNumber))
+	c.Data(http.StatusOK, "application/xml; charset=utf-8", body)
+}
@@ -2,11 +2,12 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -25,6 +26,7 @@ type OrderItemRequest struct {
 	Name          string          `json:"name"`
 	Quantity      int             `json:"quantity"`
 	Price         float64         `json:"price"`
+	PrescriptionID *uuid.UUID     `json:"prescription_id"` // الوصفة المرفوعة لهذا المنتج إذا كان يتطلب وصفة
 }
 
 // OrderRequest هيكل الطلب الوارد من الواجهة الأمامية
@@ -38,6 +40,7 @@ type OrderRequest struct {
 	PaymentMethod   string             `json:"payment_method"`
 	ShippingAddress models.Address     `json:"shipping_address"`
 	BillingAddress  *models.Address    `json:"billing_address"`
+	PrescriptionID  *uuid.UUID         `json:"prescription_id"` // وصفة افتراضية لمنتجات الوصفة التي لم تحدد وصفتها
 }
 
 // CreateOrder إنشاء طلب جديد
@@ -111,44 +114,9 @@ func CreateOrder(c *gin.Context) {
 
 	log.Printf("Order request received: Payment Method: %s, Notes: %s, Coupon: %s\n", req.PaymentMethod, req.Notes, req.CouponCode)
 
-	// إنشاء الطلب مع البيانات الواردة من الطلب
-	order := models.Order{
-		UserID:          userUUID,
-		Status:          models.OrderStatusPending,
-		Subtotal:        req.Subtotal,
-		ShippingCost:    req.Shipping,
-		TaxAmount:       (req.Subtotal * 0.15), // 15% ضريبة
-		TotalAmount:     req.Total,
-		PaymentMethod:   req.PaymentMethod,
-		PaymentStatus:   models.PaymentStatusPending,
-		ShippingAddress: req.ShippingAddress,
-		Notes:           req.Notes,
-	}
-
-	// إضافة عنوان الفاتورة إذا تم توفيره
-	if req.BillingAddress != nil {
-		order.BillingAddress = req.BillingAddress
-	}
-
-	// بدء معاملة قاعدة البيانات
-	tx := config.DB.Begin()
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
-		}
-	}()
-
-	// حفظ الطلب في قاعدة البيانات
-	if err := tx.Create(&order).Error; err != nil {
-		tx.Rollback()
-		log.Printf("❌ Order creation failed for user %s. Error: %v\n", userUUID, err)
-		utils.InternalServerErrorResponse(c, "Failed to create order", err.Error())
-		return
-	}
-
-	// إضافة عناصر الطلب
+	// تحويل العناصر إلى مدخلات التسعير
+	lines := make([]services.OrderLineInput, 0, len(req.Items))
 	for idx, item := range req.Items {
-		// التحقق من صحة معرف المنتج
 		pid := strings.TrimSpace(item.ProductID)
 		// Fallback: إذا كان pid سلسلة JSON لكائن، نحاول استخراج id/product_id
 		if strings.HasPrefix(pid, "{") && strings.HasSuffix(pid, "}") {
@@ -165,173 +133,156 @@ func CreateOrder(c *gin.Context) {
 		}
 		productID, err := uuid.Parse(pid)
 		if err != nil {
-			tx.Rollback()
 			log.Printf("❌ Invalid product ID format at item index %d: '%s'\n", idx, pid)
 			utils.BadRequestResponse(c, "Invalid product ID format", err.Error())
 			return
 		}
-
-		// التحقق من وجود المنتج
-		var product models.Product
-		if err := tx.First(&product, "id = ?", productID).Error; err != nil {
-			tx.Rollback()
-			log.Printf("❌ Product not found: %s\n", item.ProductID)
-			utils.BadRequestResponse(c, "Product not found", err.Error())
-			return
+		prescriptionID := item.PrescriptionID
+		if prescriptionID == nil {
+			prescriptionID = req.PrescriptionID
 		}
-
-		// التحقق من الكمية المتاحة
-		if product.StockQuantity < item.Quantity {
-			tx.Rollback()
-			log.Printf("❌ Insufficient quantity for product: %s (Requested: %d, Available: %d)\n", product.Name, item.Quantity, product.StockQuantity)
-			utils.BadRequestResponse(c, "Insufficient quantity for product", fmt.Sprintf("Requested: %d, Available: %d", item.Quantity, product.StockQuantity))
-			return
-		}
-
-		// إنشاء عنصر الطلب
-		orderItem := models.OrderItem{
-			OrderID:    order.ID,
-			ProductID:  productID,
-			Name:       item.Name,
-			Quantity:   item.Quantity,
-			UnitPrice:  item.Price,
-			TotalPrice: item.Price * float64(item.Quantity),
+		lines = append(lines, services.OrderLineInput{
+			ProductID:      productID,
+			Quantity:       item.Quantity,
+			ClientPrice:    item.Price,
+			PrescriptionID: prescriptionID,
+		})
+	}
+
+	// المستخدم الحالي (يحدده AuthMiddleware) لتحديد صلاحية الجملة
+	var user models.User
+	if u, ok := c.Get("user"); ok {
+		if userPtr, ok := u.(*models.User); ok && userPtr != nil {
+			user = *userPtr
 		}
-
-		if err := tx.Create(&orderItem).Error; err != nil {
-			tx.Rollback()
-			log.Printf("❌ Failed to create order item: %v\n", err)
-			utils.InternalServerErrorResponse(c, "Failed to create order items", err.Error())
+	}
+	if user.ID == uuid.Nil {
+		if err := config.DB.First(&user, "id = ?", userUUID).Error; err != nil {
+			utils.UnauthorizedResponse(c, "User not found")
 			return
 		}
+	}
 
-		// تحديث كمية المنتج المتاحة
-		if err := tx.Model(&product).Update("stock_quantity", gorm.Expr("stock_quantity - ?", item.Quantity)).Error; err != nil {
+	// بدء معاملة قاعدة البيانات
+	tx := config.DB.Begin()
+	defer func() {
+		if r := recover(); r != nil {
 			tx.Rollback()
-			log.Printf("❌ Failed to update product quantity: %v\n", err)
-			utils.InternalServerErrorResponse(c, "Failed to update product quantity", err.Error())
-			return
 		}
-	}
+	}()
 
-	// مسح سلة التسوق (إذا كانت هناك عناصر في السلة)
-	var cartItemCount int64
-	if err := tx.Model(&models.CartItem{}).Where("user_id = ?", userUUID).Count(&cartItemCount).Error; err == nil && cartItemCount > 0 {
-		if err := tx.Where("user_id = ?", userUUID).Delete(&models.CartItem{}).Error; err != nil {
-			tx.Rollback()
-			log.Printf("❌ Failed to clear cart: %v\n", err)
-			// لا نوقف العملية إذا فشل حذف السلة، فقط نسجل الخطأ
-			log.Printf("⚠️ Warning: Failed to clear cart, but continuing with order creation")
+	// التسعير والكوبون وحجز المخزون وإنشاء العناصر على الخادم
+	order, quote, err := services.PlaceOrder(tx, &user, services.PlaceOrderInput{
+		Lines:           lines,
+		CouponCode:      req.CouponCode,
+		PaymentMethod:   req.PaymentMethod,
+		ShippingAddress: req.ShippingAddress,
+		BillingAddress:  req.BillingAddress,
+		Notes:           req.Notes,
+		ClearCart:       true,
+		ClientSubtotal:  req.Subtotal,
+		ClientShipping:  req.Shipping,
+		ClientTotal:     req.Total,
+	})
+	if err != nil {
+		tx.Rollback()
+		log.Printf("❌ Order creation failed for user %s: %v\n", userUUID, err)
+		var priceErr *services.PriceChangedError
+		var stockErr *services.InsufficientStockError
+		var limitErr *services.QuantityLimitError
+		switch {
+		case errors.As(err, &priceErr):
+			// رفض الطلب إذا اختلفت الأسعار المعروضة للعميل عن الأسعار الحالية
+			c.JSON(http.StatusConflict, utils.APIResponse{
+				Success: false,
+				Message: "تغيرت أسعار بعض المنتجات، يرجى مراجعة الطلب",
+				Error:   "price_changed",
+				Data: gin.H{
+					"quote":      priceErr.Quote,
+					"mismatches": priceErr.Mismatches,
+				},
+			})
+		case errors.As(err, &limitErr):
+			services.RecordQuantityLimitViolation(config.DB, user.ID, services.QuantityLimitSourceOrder, err)
+			respondQuantityLimit(c, limitErr)
+		case isPricingError(err):
+			utils.BadRequestResponse(c, "Invalid order items", err.Error())
+		case services.IsCouponError(err):
+			utils.BadRequestResponse(c, "Invalid coupon", err.Error())
+		case services.IsPrescriptionError(err):
+			utils.BadRequestResponse(c, "Valid prescription required", err.Error())
+		case errors.As(err, &stockErr):
+			utils.BadRequestResponse(c, "Insufficient quantity for product", fmt.Sprintf("%s - Requested: %d, Available: %d", stockErr.Name, stockErr.Requested, stockErr.Available))
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to create order", err.Error())
 		}
+		return
 	}
 
 	// تأكيد المعاملة
 	if err := tx.Commit().Error; err != nil {
-		tx.Rollback()
 		log.Printf("❌ Failed to commit transaction: %v\n", err)
 		utils.InternalServerErrorResponse(c, "Failed to complete order", err.Error())
 		return
 	}
 
-	// تسجيل نجاح إنشاء الطلب
 	log.Printf("✅ Order created successfully. ID: %s, Total: %.2f\n", order.ID, order.TotalAmount)
 
-	// تحديد نوع الطلب (تجزئة أم جملة) بناءً على المنتجات
-	var orderItems []models.OrderItem
-	if err := config.DB.Preload("Product").Where("order_id = ?", order.ID).Find(&orderItems).Error; err != nil {
-		log.Printf("⚠️ Failed to load order items for notification: %v", err)
-	}
-	
-	isWholesaleOrder := false
-	for _, item := range orderItems {
-		if item.Product.Type == models.ProductTypeWholesale {
-			isWholesaleOrder = true
-			break
-		}
-	}
-
-	// إنشاء إشعار للإدارة عن الطلب الجديد
-	notificationService := services.NewNotificationService()
-	var notificationType models.NotificationType
-	var title, message string
-	
-	if isWholesaleOrder {
-		notificationType = models.NotificationTypeAdminWholesaleOrder
-		title = "طلب جملة جديد تم إنشاؤه"
-		message = fmt.Sprintf("تم إنشاء طلب جملة جديد برقم %s بقيمة %.2f ريال", order.ID.String()[:8], order.TotalAmount)
-	} else {
-		notificationType = models.NotificationTypeAdminOrderCreated
-		title = "طلب تجزئة جديد تم إنشاؤه"
-		message = fmt.Sprintf("تم إنشاء طلب تجزئة جديد برقم %s بقيمة %.2f ريال", order.ID.String()[:8], order.TotalAmount)
-	}
-	
-	adminMetadata := map[string]interface{}{
-		"order_id":     order.ID.String(),
-		"user_id":      userUUID.String(),
-		"total_amount": order.TotalAmount,
-		"status":       order.Status,
-		"order_type":   map[bool]string{true: "wholesale", false: "retail"}[isWholesaleOrder],
-		"created_at":   order.CreatedAt,
-	}
-	
-	log.Printf("🔔 محاولة إنشاء إشعار إداري - النوع: %s، العنوان: %s", notificationType, title)
-	err = notificationService.CreateAdminNotification(
-		notificationType,
-		title,
-		message,
-		adminMetadata,
-		&order.ID,
-	)
-	if err != nil {
-		// لا نوقف العملية إذا فشل إنشاء الإشعار
-		log.Printf("⚠️ فشل في إنشاء إشعار الإدارة: %v", err)
-	} else {
-		log.Printf("✅ تم إنشاء إشعار الإدارة للطلب بنجاح: %s - النوع: %s", order.ID.String()[:8], notificationType)
-	}
-
-	// إنشاء إشعار للمستخدم صاحب الطلب
-	userTitle := "تم استلام طلبك بنجاح"
-	userMessage := fmt.Sprintf("تم استلام طلبك رقم %s بنجاح وسيتم معالجته قريبًا.", order.ID.String()[:8])
-	userMetadata := map[string]interface{}{
-		"order_id": order.ID.String(),
-		"status":   order.Status,
-	}
-
-	log.Printf("🔔 محاولة إنشاء إشعار للمستخدم - العنوان: %s", userTitle)
-	_, err = notificationService.CreateNotification(
-		userUUID,
-		models.NotificationTypeOrderCreated,
-		userTitle,
-		userMessage,
-		userMetadata,
-		&order.ID,
-	)
-	if err != nil {
-		log.Printf("⚠️ فشل في إنشاء إشعار المستخدم: %v", err)
-	} else {
-		log.Printf("✅ تم إنشاء إشعار المستخدم للطلب بنجاح: %s", order.ID.String()[:8])
-	}
-
-	// بث إشعار إنشاء الطلب للمستخدم عبر SSE
+	// إشعارات الإدارة والعميل ثم البث عبر SSE
+	services.NotifyOrderCreated(order, quote.IsWholesale)
 	Notifier.BroadcastToUser(userUUID, "order_created", gin.H{
 		"order_id":     order.ID.String(),
+		"order_number": order.OrderNumber,
 		"status":       order.Status,
 		"total_amount": order.TotalAmount,
 		"created_at":   order.CreatedAt,
 	})
 
 	// إرجاع استجابة ناجحة
-	log.Printf("✅ تم إنشاء الطلب بنجاح: ID=%s, Amount=%.2f", order.ID.String()[:8], order.TotalAmount)
+	log.Printf("✅ تم إنشاء الطلب بنجاح: Number=%s, Amount=%.2f", order.OrderNumber, order.TotalAmount)
 	utils.SuccessResponse(c, "تم إنشاء الطلب بنجاح", gin.H{
 		"id":           order.ID,
-		"order_number": order.ID.String(),
+		"order_number": order.OrderNumber,
 		"status":       order.Status,
 		"total_amount": order.TotalAmount,
 		"created_at":   order.CreatedAt,
 		"items_count":  len(req.Items),
+		"subtotal":        order.Subtotal,
+		"shipping_cost":   order.ShippingCost,
+		"tax_amount":      order.TaxAmount,
+		"discount_amount": order.DiscountAmount,
+	})
+}
+
+// respondQuantityLimit رفض الشراء بسبب حد الكمية مع تفاصيل الحد والمتبقي للواجهة
+func respondQuantityLimit(c *gin.Context, limitErr *services.QuantityLimitError) {
+	c.JSON(http.StatusUnprocessableEntity, utils.APIResponse{
+		Success: false,
+		Message: "تجاوزت الكمية المسموح بشرائها لهذا المنتج",
+		Error:   limitErr.Error(),
+		Data: gin.H{
+			"type":         limitErr.Type,
+			"product_id":   limitErr.ProductID,
+			"product_name": limitErr.ProductName,
+			"max_quantity": limitErr.Rule.MaxQuantity,
+			"period_days":  limitErr.Rule.PeriodDays,
+			"purchased":    limitErr.Purchased,
+			"requested":    limitErr.Requested,
+			"remaining":    limitErr.Remaining(),
+			"reason":       limitErr.Rule.Reason,
+		},
 	})
 }
 
+// isPricingError التحقق مما إذا كان خطأ التسعير ناتجاً عن بيانات العميل
+func isPricingError(err error) bool {
+	return errors.Is(err, services.ErrEmptyOrder) ||
+		errors.Is(err, services.ErrInvalidQuantity) ||
+		errors.Is(err, services.ErrProductNotFound) ||
+		errors.Is(err, services.ErrProductUnavailable) ||
+		errors.Is(err, services.ErrWholesaleNotAllowed)
+}
+
 // GetUserOrders الحصول على طلبات المستخدم
 func GetUserOrders(c *gin.Context) {
 	userID, exists := c.Get("user_id")
@@ -392,7 +343,9 @@ func GetOrder(c *gin.Context) {
 	var order models.Order
 	err = config.DB.
 		Preload("OrderItems.Product").
+		Preload("OrderItems.Batches").
 		Preload("OrderTracking").
+		Preload("Shipments.Items").
 		Where("id = ? AND user_id = ?", orderUUID, userID).First(&order).Error
 	
 	if err != nil {
@@ -407,6 +360,52 @@ func GetOrder(c *gin.Context) {
 	utils.SuccessResponse(c, "Order retrieved successfully", order)
 }
 
+// ReorderOrder نسخ عناصر طلب سابق إلى السلة بالأسعار الحالية مع تقرير بما أضيف أو عُدل أو استُبعد
+func ReorderOrder(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+	userObj := user.(*models.User)
+
+	orderUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid order ID", err.Error())
+		return
+	}
+
+	var order models.Order
+	if err := config.DB.Select("id", "user_id", "order_number").
+		Where("id = ? AND user_id = ?", orderUUID, userObj.ID).
+		First(&order).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			utils.NotFoundResponse(c, "Order not found")
+		} else {
+			utils.InternalServerErrorResponse(c, "Failed to fetch order", err.Error())
+		}
+		return
+	}
+
+	tx := config.DB.Begin()
+	report, err := services.ReorderToCart(tx, userObj, &order)
+	if err != nil {
+		tx.Rollback()
+		utils.InternalServerErrorResponse(c, "Failed to copy order to cart", err.Error())
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to copy order to cart", err.Error())
+		return
+	}
+
+	message := "Order items added to cart"
+	if len(report.Added) == 0 && len(report.Adjusted) == 0 {
+		message = "No items from this order are currently available"
+	}
+	utils.SuccessResponse(c, message, report)
+}
+
 // TrackOrder تتبع الطلب
 func TrackOrder(c *gin.Context) {
 	orderID := c.Param("id")
@@ -418,7 +417,10 @@ func TrackOrder(c *gin.Context) {
 	
 	var order models.Order
 	err = config.DB.
-		Preload("OrderTracking").
+		Preload("OrderTracking", func(db *gorm.DB) *gorm.DB { return db.Order("timestamp ASC") }).
+		Preload("Shipments", func(db *gorm.DB) *gorm.DB { return db.Order("created_at ASC") }).
+		Preload("Shipments.Items").
+		Preload("Shipments.Timeline", func(db *gorm.DB) *gorm.DB { return db.Order("timestamp ASC") }).
 		Where("id = ?", orderUUID).First(&order).Error
 	
 	if err != nil {
@@ -429,8 +431,27 @@ func TrackOrder(c *gin.Context) {
 		}
 		return
 	}
+
+	// أحداث الطلب العامة فقط؛ أحداث كل شحنة تظهر ضمن جدولها الزمني
+	timeline := make([]models.OrderTracking, 0, len(order.OrderTracking))
+	for _, t := range order.OrderTracking {
+		if t.ShipmentID == nil {
+			timeline = append(timeline, t)
+		}
+	}
 	
-	utils.SuccessResponse(c, "Order tracking retrieved successfully", order.OrderTracking)
+	utils.SuccessResponse(c, "Order tracking retrieved successfully", gin.H{
+		"id":                 order.ID,
+		"order_number":       order.OrderNumber,
+		"status":             order.Status,
+		"created_at":         order.CreatedAt,
+		"estimated_delivery": order.EstimatedDelivery,
+		"delivered_at":       order.ActualDelivery,
+		"tracking_number":    order.TrackingNumber,
+		"shipping_carrier":   order.ShippingCarrier,
+		"timeline":           timeline,
+		"shipments":          order.Shipments,
+	})
 }
 
 // CancelOrder إلغاء الطلب
@@ -465,22 +486,35 @@ func CancelOrder(c *gin.Context) {
 		return
 	}
 	
-	// تحديث حالة الطلب إلى ملغى
-	order.Status = models.OrderStatusCancelled
-	if err := config.DB.Save(&order).Error; err != nil {
-		utils.InternalServerErrorResponse(c, "Failed to cancel order", err.Error())
-		return
+	var actorID *uuid.UUID
+	if uid, ok := userID.(uuid.UUID); ok {
+		actorID = &uid
 	}
-	
-	// إضافة سجل تتبع للإلغاء
-	tracking := models.OrderTracking{
-		OrderID:     order.ID,
-		Status:      string(models.OrderStatusCancelled),
+
+	tx := config.DB.Begin()
+
+	// الإلغاء من صاحب الطلب يتم بدور العميل ويعيد المخزون والكوبون في نفس المعاملة
+	_, err = services.TransitionOrderStatus(tx, &order, services.StatusChange{
+		To:          models.OrderStatusCancelled,
+		ActorID:     actorID,
+		ActorRole:   models.RoleCustomer,
 		Description: "تم إلغاء الطلب بواسطة المستخدم",
-		Timestamp:   time.Now(),
+	})
+	if err != nil {
+		tx.Rollback()
+		switch {
+		case services.IsTransitionError(err):
+			utils.BadRequestResponse(c, "Order cannot be cancelled", err.Error())
+		case errors.Is(err, services.ErrOrderStatusChanged):
+			utils.ErrorResponse(c, http.StatusConflict, "Order status has changed, please refresh", err.Error())
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to cancel order", err.Error())
+		}
+		return
 	}
-	if err := config.DB.Create(&tracking).Error; err != nil {
-		utils.InternalServerErrorResponse(c, "Failed to add cancellation tracking", err.Error())
+
+	if err := tx.Commit().Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to cancel order", err.Error())
 		return
 	}
 	
@@ -0,0 +1,436 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"pharmacy-backend/config"
+	"pharmacy-backend/models"
+	"pharmacy-backend/services"
+	"pharmacy-backend/utils"
+)
+
+// prescriptionStorageDir مجلد ملفات الوصفات؛ خارج ./uploads لأنه يُخدم للعامة
+func prescriptionStorageDir() string {
+	return getEnv("PRESCRIPTION_STORAGE_DIR", "./private/prescriptions")
+}
+
+// UploadPrescriptionRequest بيانات الوصفة المرفقة مع الملف
+type UploadPrescriptionRequest struct {
+	DoctorName     string `form:"doctor_name"`
+	PatientName    string `form:"patient_name"`
+	Notes          string `form:"notes"`
+	IssuedAt       string `form:"issued_at"`   // YYYY-MM-DD
+	ValidUntil     string `form:"valid_until"` // YYYY-MM-DD؛ الافتراضي PRESCRIPTION_DEFAULT_VALIDITY_DAYS من اليوم
+	RefillsAllowed int    `form:"refills_allowed" binding:"min=0,max=12"`
+}
+
+// ReviewPrescriptionItemRequest قرار الصيدلي على عنصر طلب
+type ReviewPrescriptionItemRequest struct {
+	Decision               models.PrescriptionDecision `json:"decision" binding:"required,oneof=approve reject"`
+	Notes                  string                      `json:"notes"`
+	ValidUntil             *time.Time                  `json:"valid_until"`
+	RefillsAllowed         *int                        `json:"refills_allowed" binding:"omitempty,min=0,max=12"`
+	InvalidatePrescription bool                        `json:"invalidate_prescription"`
+}
+
+// parsePrescriptionDate قراءة تاريخ بصيغة YYYY-MM-DD أو RFC3339
+func parsePrescriptionDate(value string) (*time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		// الوصفة صالحة حتى نهاية اليوم المكتوب عليها
+		end := t.Add(24*time.Hour - time.Second)
+		return &end, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// UploadPrescription رفع وصفة طبية (صورة أو PDF) لاستخدامها عند الطلب
+func UploadPrescription(c *gin.Context) {
+	user, ok := subscriptionUser(c)
+	if !ok {
+		return
+	}
+
+	var req UploadPrescriptionRequest
+	if err := c.ShouldBind(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		utils.BadRequestResponse(c, "Prescription file is required", err.Error())
+		return
+	}
+	if err := validateUploadedDocument(fileHeader); err != nil {
+		utils.BadRequestResponse(c, "Invalid prescription file", err.Error())
+		return
+	}
+
+	issuedAt, err := parsePrescriptionDate(req.IssuedAt)
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid issued_at date", err.Error())
+		return
+	}
+	validUntil, err := parsePrescriptionDate(req.ValidUntil)
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid valid_until date", err.Error())
+		return
+	}
+	now := time.Now()
+	if validUntil == nil {
+		defaultValidity := now.AddDate(0, 0, getEnvInt("PRESCRIPTION_DEFAULT_VALIDITY_DAYS", 180))
+		validUntil = &defaultValidity
+	}
+	if !validUntil.After(now) {
+		utils.BadRequestResponse(c, "Prescription has expired", services.ErrPrescriptionValidity.Error())
+		return
+	}
+
+	// اسم الملف المخزن لا يعتمد على اسم الملف المرسل
+	ext := strings.ToLower(filepath.Ext(fileHeader.Filename))
+	dir := filepath.Join(prescriptionStorageDir(), user.ID.String())
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to store prescription", err.Error())
+		return
+	}
+	filePath := filepath.Join(dir, uuid.New().String()+ext)
+	if err := saveFile(fileHeader, filePath); err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to store prescription", err.Error())
+		return
+	}
+
+	prescription := models.Prescription{
+		UserID:         user.ID,
+		FilePath:       filePath,
+		FileName:       filepath.Base(fileHeader.Filename),
+		ContentType:    fileHeader.Header.Get("Content-Type"),
+		DoctorName:     strings.TrimSpace(req.DoctorName),
+		PatientName:    strings.TrimSpace(req.PatientName),
+		Notes:          strings.TrimSpace(req.Notes),
+		IssuedAt:       issuedAt,
+		ValidUntil:     *validUntil,
+		RefillsAllowed: req.RefillsAllowed,
+		Status:         models.PrescriptionStatusPendingReview,
+	}
+	if err := config.DB.Create(&prescription).Error; err != nil {
+		os.Remove(filePath)
+		utils.InternalServerErrorResponse(c, "Failed to save prescription", err.Error())
+		return
+	}
+
+	utils.CreatedResponse(c, "Prescription uploaded successfully", prescription)
+}
+
+// GetUserPrescriptions الحصول على وصفات المستخدم مع عدد مرات الصرف المتبقية
+func GetUserPrescriptions(c *gin.Context) {
+	user, ok := subscriptionUser(c)
+	if !ok {
+		return
+	}
+
+	var prescriptions []models.Prescription
+	if err := config.DB.Where("user_id = ?", user.ID).Order("created_at DESC").Find(&prescriptions).Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch prescriptions", err.Error())
+		return
+	}
+
+	now := time.Now()
+	result := make([]gin.H, 0, len(prescriptions))
+	for i := range prescriptions {
+		result = append(result, prescriptionView(&prescriptions[i], now))
+	}
+	utils.SuccessResponse(c, "Prescriptions retrieved successfully", result)
+}
+
+// GetUserPrescription الحصول على وصفة المستخدم مع قرارات الصيدلي عليها
+func GetUserPrescription(c *gin.Context) {
+	user, ok := subscriptionUser(c)
+	if !ok {
+		return
+	}
+	prescription, ok := loadPrescription(c, &user.ID)
+	if !ok {
+		return
+	}
+
+	var reviews []models.PrescriptionReview
+	if err := config.DB.Where("prescription_id = ?", prescription.ID).Order("created_at DESC").Find(&reviews).Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch prescription reviews", err.Error())
+		return
+	}
+
+	view := prescriptionView(prescription, time.Now())
+	view["reviews"] = reviews
+	utils.SuccessResponse(c, "Prescription retrieved successfully", view)
+}
+
+// DownloadUserPrescriptionFile تنزيل ملف الوصفة لصاحبها
+func DownloadUserPrescriptionFile(c *gin.Context) {
+	user, ok := subscriptionUser(c)
+	if !ok {
+		return
+	}
+	if prescription, ok := loadPrescription(c, &user.ID); ok {
+		servePrescriptionFile(c, prescription)
+	}
+}
+
+// GetPrescriptionReviewQueue الطلبات التي تنتظر مراجعة الصيدلي، الأقدم أولاً (Pharmacist)
+func GetPrescriptionReviewQueue(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	var orders []models.Order
+	var total int64
+	query := config.DB.Model(&models.Order{}).Where("status = ?", models.OrderStatusAwaitingPrescriptionReview)
+	query.Count(&total)
+
+	if err := query.
+		Preload("OrderItems").
+		Preload("User").
+		Order("created_at ASC").
+		Limit(limit).
+		Offset((page - 1) * limit).
+		Find(&orders).Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch orders awaiting review", err.Error())
+		return
+	}
+
+	pagination := utils.CalculatePagination(page, limit, total)
+	utils.PaginatedSuccessResponse(c, "Orders awaiting prescription review retrieved successfully", orders, pagination)
+}
+
+// GetPrescriptionReviewOrder الحصول على طلب مع الوصفات المرفقة بعناصره وسجل مراجعتها وتحذيرات التداخل الدوائي (Pharmacist)
+func GetPrescriptionReviewOrder(c *gin.Context) {
+	orderUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid order ID", err.Error())
+		return
+	}
+
+	var order models.Order
+	if err := config.DB.Preload("OrderItems").Preload("User").First(&order, "id = ?", orderUUID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.NotFoundResponse(c, "Order not found")
+			return
+		}
+		utils.InternalServerErrorResponse(c, "Failed to fetch order", err.Error())
+		return
+	}
+
+	var ids []uuid.UUID
+	for _, item := range order.OrderItems {
+		if item.PrescriptionID != nil {
+			ids = append(ids, *item.PrescriptionID)
+		}
+	}
+	prescriptions := []models.Prescription{}
+	if len(ids) > 0 {
+		if err := config.DB.Where("id IN ?", ids).Find(&prescriptions).Error; err != nil {
+			utils.InternalServerErrorResponse(c, "Failed to fetch prescriptions", err.Error())
+			return
+		}
+	}
+	var reviews []models.PrescriptionReview
+	if err := config.DB.Where("order_id = ?", order.ID).Order("created_at ASC").Find(&reviews).Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch prescription reviews", err.Error())
+		return
+	}
+
+	interactions, err := services.CheckOrderInteractions(config.DB, &order)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to check drug interactions", err.Error())
+		return
+	}
+
+	now := time.Now()
+	views := make([]gin.H, 0, len(prescriptions))
+	for i := range prescriptions {
+		views = append(views, prescriptionView(&prescriptions[i], now))
+	}
+	utils.SuccessResponse(c, "Order retrieved successfully", gin.H{
+		"order":         order,
+		"prescriptions": views,
+		"reviews":       reviews,
+		"interactions":  interactions,
+	})
+}
+
+// DownloadPrescriptionFileForReview تنزيل ملف أي وصفة للمراجعة (Pharmacist)
+func DownloadPrescriptionFileForReview(c *gin.Context) {
+	if prescription, ok := loadPrescription(c, nil); ok {
+		servePrescriptionFile(c, prescription)
+	}
+}
+
+// ReviewPrescriptionItem اعتماد أو رفض عنصر طلب يتطلب وصفة (Pharmacist)
+func ReviewPrescriptionItem(c *gin.Context) {
+	orderUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid order ID", err.Error())
+		return
+	}
+	itemUUID, err := uuid.Parse(c.Param("itemId"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid order item ID", err.Error())
+		return
+	}
+
+	var req ReviewPrescriptionItemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+	if req.Decision == models.PrescriptionDecisionReject && strings.TrimSpace(req.Notes) == "" {
+		utils.BadRequestResponse(c, "Rejection notes are required", "notes must explain why the item was rejected")
+		return
+	}
+
+	input := services.PrescriptionReviewInput{
+		Decision:               req.Decision,
+		Notes:                  req.Notes,
+		ReviewerID:             currentAdminID(c),
+		ValidUntil:             req.ValidUntil,
+		RefillsAllowed:         req.RefillsAllowed,
+		InvalidatePrescription: req.InvalidatePrescription,
+	}
+	if u, ok := c.Get("user"); ok {
+		if reviewer, ok := u.(*models.User); ok {
+			input.ReviewerRole = reviewer.Role
+		}
+	}
+
+	tx := config.DB.Begin()
+	result, err := services.ReviewPrescriptionItem(tx, orderUUID, itemUUID, input)
+	if err != nil {
+		tx.Rollback()
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			utils.NotFoundResponse(c, "Order not found")
+		case errors.Is(err, services.ErrOrderItemNotFound):
+			utils.NotFoundResponse(c, "Order item not found")
+		case services.IsPrescriptionError(err), services.IsOrderEditError(err):
+			utils.ErrorResponse(c, http.StatusUnprocessableEntity, "Prescription cannot be reviewed", err.Error())
+		case services.IsTransitionError(err), errors.Is(err, services.ErrOrderStatusChanged):
+			utils.ErrorResponse(c, http.StatusConflict, "Order status changed", err.Error())
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to review prescription", err.Error())
+		}
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to review prescription", err.Error())
+		return
+	}
+
+	notifyPrescriptionReviewed(result)
+	utils.SuccessResponse(c, "Prescription reviewed successfully", result)
+}
+
+// notifyPrescriptionReviewed إشعار العميل بقرار الصيدلي
+func notifyPrescriptionReviewed(result *services.PrescriptionReviewResult) {
+	order := result.Order
+	review := result.Review
+
+	title := "تمت الموافقة على وصفتك"
+	message := fmt.Sprintf("وافق الصيدلي على صرف %s في الطلب %s.", review.ProductName, order.OrderNumber)
+	if review.Decision == models.PrescriptionDecisionReject {
+		title = "تعذر صرف أحد أدوية طلبك"
+		message = fmt.Sprintf("رفض الصيدلي صرف %s في الطلب %s: %s", review.ProductName, order.OrderNumber, review.Notes)
+	}
+	switch {
+	case result.Cancelled:
+		message += " تم إلغاء الطلب لعدم وجود عناصر أخرى."
+	case result.Completed:
+		message += fmt.Sprintf(" اكتملت مراجعة الطلب وسيتم تجهيزه، الإجمالي %.2f ريال.", order.TotalAmount)
+	}
+
+	data := map[string]interface{}{
+		"order_id":     order.ID.String(),
+		"review":       review,
+		"status":       order.Status,
+		"total_amount": order.TotalAmount,
+		"completed":    result.Completed,
+		"cancelled":    result.Cancelled,
+	}
+	if _, err := services.NewNotificationService().CreateNotification(
+		order.UserID, models.NotificationTypePrescriptionReviewed, title, message, data, &order.ID,
+	); err != nil {
+		log.Printf("⚠️ فشل في إنشاء إشعار مراجعة الوصفة: %v", err)
+	}
+	Notifier.BroadcastToUser(order.UserID, "prescription_reviewed", gin.H{
+		"order_id":     order.ID.String(),
+		"decision":     review.Decision,
+		"product_id":   review.ProductID.String(),
+		"status":       order.Status,
+		"total_amount": order.TotalAmount,
+	})
+}
+
+// loadPrescription قراءة الوصفة من المسار، مقيدة بصاحبها إذا مُرر ownerID
+func loadPrescription(c *gin.Context, ownerID *uuid.UUID) (*models.Prescription, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid prescription ID", err.Error())
+		return nil, false
+	}
+	query := config.DB.Where("id = ?", id)
+	if ownerID != nil {
+		query = query.Where("user_id = ?", *ownerID)
+	}
+	var prescription models.Prescription
+	if err := query.First(&prescription).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.NotFoundResponse(c, "Prescription not found")
+			return nil, false
+		}
+		utils.InternalServerErrorResponse(c, "Failed to fetch prescription", err.Error())
+		return nil, false
+	}
+	return &prescription, true
+}
+
+// servePrescriptionFile إرسال ملف الوصفة دون تخزين مؤقت
+func servePrescriptionFile(c *gin.Context, prescription *models.Prescription) {
+	if _, err := os.Stat(prescription.FilePath); err != nil {
+		utils.NotFoundResponse(c, "Prescription file not found")
+		return
+	}
+	c.Header("Cache-Control", "private, no-store")
+	c.FileAttachment(prescription.FilePath, prescription.FileName)
+}
+
+// prescriptionView الوصفة مع حالتها المحسوبة عند now
+func prescriptionView(p *models.Prescription, now time.Time) gin.H {
+	return gin.H{
+		"prescription":    p,
+		"remaining_fills": p.RemainingFills(),
+		"expired":         p.IsExpired(now),
+		"usable":          services.CheckPrescriptionUsable(p, now) == nil,
+	}
+}
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"os"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"pharmacy-backend/config"
+	"pharmacy-backend/models"
+	"pharmacy-backend/services"
+	"pharmacy-backend/utils"
+)
+
+// الحد الأقصى لعدد صور طلب الإرجاع
+const maxReturnPhotos = 5
+
+// CreateReturnRequestForm بنية نموذج طلب الإرجاع (multipart)
+// items عبارة عن JSON: [{"order_item_id": "...", "quantity": 1, "reason": "..."}]
+type CreateReturnRequestForm struct {
+	Reason string                  `form:"reason" binding:"required"`
+	Notes  string                  `form:"notes"`
+	Items  string                  `form:"items" binding:"required"`
+	Photos []*multipart.FileHeader `form:"photos"`
+}
+
+// CreateReturnRequest تقديم طلب إرجاع لعناصر من طلب مُسلَّم
+func CreateReturnRequest(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+	userUUID := userID.(uuid.UUID)
+
+	orderUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid order ID", err.Error())
+		return
+	}
+
+	var form CreateReturnRequestForm
+	if err := c.ShouldBind(&form); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	var lines []services.ReturnLineInput
+	if err := json.Unmarshal([]byte(form.Items), &lines); err != nil {
+		utils.BadRequestResponse(c, "Invalid items format", err.Error())
+		return
+	}
+	if len(form.Photos) > maxReturnPhotos {
+		utils.BadRequestResponse(c, "Too many photos", fmt.Sprintf("maximum %d photos", maxReturnPhotos))
+		return
+	}
+
+	var order models.Order
+	if err := config.DB.Where("id = ? AND user_id = ?", orderUUID, userUUID).First(&order).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			utils.NotFoundResponse(c, "Order not found")
+		} else {
+			utils.InternalServerErrorResponse(c, "Failed to fetch order", err.Error())
+		}
+		return
+	}
+
+	photos := make([]string, 0, len(form.Photos))
+	removePhotos := func() {
+		for _, p := range photos {
+			os.Remove("." + p)
+		}
+	}
+	for _, fh := range form.Photos {
+		path, err := saveUploadedFileTo(fh, "returns")
+		if err != nil {
+			removePhotos()
+			utils.BadRequestResponse(c, "خطأ في صورة المرتجع", err.Error())
+			return
+		}
+		photos = append(photos, path)
+	}
+
+	tx := config.DB.Begin()
+	request, err := services.CreateReturnRequest(tx, &order, form.Reason, form.Notes, photos, lines)
+	if err != nil {
+		tx.Rollback()
+		removePhotos()
+		if services.IsReturnError(err) {
+			utils.BadRequestResponse(c, "Return request is not valid", err.Error())
+		} else {
+			utils.InternalServerErrorResponse(c, "Failed to create return request", err.Error())
+		}
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		removePhotos()
+		utils.InternalServerErrorResponse(c, "Failed to create return request", err.Error())
+		return
+	}
+
+	notificationService := services.NewNotificationService()
+	if err := notificationService.CreateAdminNotification(
+		models.NotificationTypeAdminReturnRequested,
+		"طلب إرجاع جديد",
+		fmt.Sprintf("تم تقديم طلب الإرجاع %s للطلب %s", request.ReturnNumber, order.OrderNumber),
+		map[string]interface{}{
+			"return_id":     request.ID.String(),
+			"return_number": request.ReturnNumber,
+			"order_id":      order.ID.String(),
+			"user_id":       userUUID.String(),
+		},
+		&order.ID,
+	); err != nil {
+		log.Printf("⚠️ فشل في إنشاء إشعار الإدارة لطلب الإرجاع: %v", err)
+	}
+	notifyReturnUpdate(request, "تم استلام طلب الإرجاع",
+		fmt.Sprintf("تم استلام طلب الإرجاع %s وسيتم مراجعته قريبًا.", request.ReturnNumber))
+
+	utils.CreatedResponse(c, "Return request created successfully", request)
+}
+
+// GetUserReturns الحصول على طلبات الإرجاع الخاصة بالمستخدم
+func GetUserReturns(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 10
+	}
+
+	var returns []models.ReturnRequest
+	var total int64
+
+	query := config.DB.Model(&models.ReturnRequest{}).Where("user_id = ?", userID)
+	query.Count(&total)
+
+	if err := query.
+		Preload("Items").
+		Order("created_at DESC").
+		Limit(limit).
+		Offset((page - 1) * limit).
+		Find(&returns).Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch return requests", err.Error())
+		return
+	}
+
+	pagination := utils.CalculatePagination(page, limit, total)
+	utils.PaginatedSuccessResponse(c, "Return requests retrieved successfully", returns, pagination)
+}
+
+// GetUserReturn الحصول على طلب إرجاع محدد للمستخدم
+func GetUserReturn(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	returnUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid return ID", err.Error())
+		return
+	}
+
+	var request models.ReturnRequest
+	if err := config.DB.Preload("Items").
+		Where("id = ? AND user_id = ?", returnUUID, userID).
+		First(&request).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			utils.NotFoundResponse(c, "Return request not found")
+		} else {
+			utils.InternalServerErrorResponse(c, "Failed to fetch return request", err.Error())
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Return request retrieved successfully", request)
+}
+
+// notifyReturnUpdate إشعار العميل بتغير حالة طلب الإرجاع (إشعار محفوظ + SSE)
+func notifyReturnUpdate(request *models.ReturnRequest, title, message string) {
+	data := map[string]interface{}{
+		"return_id":     request.ID.String(),
+		"return_number": request.ReturnNumber,
+		"order_id":      request.OrderID.String(),
+		"status":        request.Status,
+		"refund_amount": request.RefundAmount,
+	}
+
+	if _, err := services.NewNotificationService().CreateNotification(
+		request.UserID,
+		models.NotificationTypeReturnUpdated,
+		title,
+		message,
+		data,
+		&request.OrderID,
+	); err != nil {
+		log.Printf("⚠️ فشل في إنشاء إشعار المستخدم لطلب الإرجاع: %v", err)
+	}
+
+	Notifier.BroadcastToUser(request.UserID, "return_updated", gin.H(data))
+}
@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"pharmacy-backend/config"
+	"pharmacy-backend/models"
+	"pharmacy-backend/services"
+	"pharmacy-backend/utils"
+)
+
+// CreateSubscriptionRequest بنية طلب إنشاء اشتراك إعادة صرف دوري
+type CreateSubscriptionRequest struct {
+	Items            []services.SubscriptionLineInput `json:"items" binding:"required,min=1,dive"`
+	IntervalDays     int                              `json:"interval_days" binding:"required"`
+	NextRunAt        *time.Time                       `json:"next_run_at"`
+	NotifyDaysBefore *int                             `json:"notify_days_before"`
+	PaymentMethod    string                           `json:"payment_method"`
+	ShippingAddress  models.Address                   `json:"shipping_address" binding:"required"`
+	Notes            string                           `json:"notes"`
+	PrescriptionID   *uuid.UUID                       `json:"prescription_id"` // وصفة مرفوعة مسبقاً عبر /prescriptions
+}
+
+// CreateSubscription إنشاء اشتراك دوري لمجموعة منتجات
+func CreateSubscription(c *gin.Context) {
+	user, ok := subscriptionUser(c)
+	if !ok {
+		return
+	}
+
+	var req CreateSubscriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	tx := config.DB.Begin()
+	sub, err := services.CreateSubscription(tx, user, services.SubscriptionInput{
+		Items:            req.Items,
+		IntervalDays:     &req.IntervalDays,
+		NextRunAt:        req.NextRunAt,
+		NotifyDaysBefore: req.NotifyDaysBefore,
+		PaymentMethod:    &req.PaymentMethod,
+		ShippingAddress:  &req.ShippingAddress,
+		Notes:            &req.Notes,
+		PrescriptionID:   req.PrescriptionID,
+	}, time.Now())
+	if err != nil {
+		tx.Rollback()
+		respondSubscriptionError(c, "Failed to create subscription", err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to create subscription", err.Error())
+		return
+	}
+
+	utils.CreatedResponse(c, "Subscription created successfully", sub)
+}
+
+// GetUserSubscriptions الحصول على اشتراكات المستخدم
+func GetUserSubscriptions(c *gin.Context) {
+	user, ok := subscriptionUser(c)
+	if !ok {
+		return
+	}
+
+	query := config.DB.Preload("Items.Product").Where("user_id = ?", user.ID)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var subs []models.Subscription
+	if err := query.Order("created_at DESC").Find(&subs).Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch subscriptions", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Subscriptions retrieved successfully", subs)
+}
+
+// GetUserSubscription الحصول على اشتراك مع سجل دوراته ومشاكل الدورة القادمة
+func GetUserSubscription(c *gin.Context) {
+	user, ok := subscriptionUser(c)
+	if !ok {
+		return
+	}
+	sub, ok := loadUserSubscription(c, user)
+	if !ok {
+		return
+	}
+	if err := config.DB.Where("subscription_id = ?", sub.ID).Order("scheduled_for DESC").Limit(24).Find(&sub.Runs).Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch subscription runs", err.Error())
+		return
+	}
+
+	issues := []services.SubscriptionIssue{}
+	if sub.IsActive() {
+		var err error
+		if issues, err = services.CheckSubscription(config.DB, user, sub); err != nil {
+			utils.InternalServerErrorResponse(c, "Failed to check subscription", err.Error())
+			return
+		}
+	}
+
+	utils.SuccessResponse(c, "Subscription retrieved successfully", gin.H{
+		"subscription": sub,
+		"issues":       issues,
+	})
+}
+
+// UpdateSubscription تعديل منتجات الاشتراك أو موعده أو عنوانه أو وصفته
+func UpdateSubscription(c *gin.Context) {
+	user, ok := subscriptionUser(c)
+	if !ok {
+		return
+	}
+
+	var input services.SubscriptionInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	sub, ok := loadUserSubscription(c, user)
+	if !ok {
+		return
+	}
+
+	tx := config.DB.Begin()
+	if err := services.UpdateSubscription(tx, user, sub, input, time.Now()); err != nil {
+		tx.Rollback()
+		respondSubscriptionError(c, "Failed to update subscription", err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to update subscription", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Subscription updated successfully", sub)
+}
+
+// SkipSubscription تخطي الدورة القادمة من الاشتراك
+func SkipSubscription(c *gin.Context) {
+	changeSubscription(c, "Next delivery skipped", func(tx *gorm.DB, sub *models.Subscription) error {
+		return services.SkipSubscriptionRun(tx, sub, time.Now())
+	})
+}
+
+// PauseSubscription إيقاف الاشتراك مؤقتاً
+func PauseSubscription(c *gin.Context) {
+	changeSubscription(c, "Subscription paused", func(tx *gorm.DB, sub *models.Subscription) error {
+		return services.SetSubscriptionStatus(tx, sub, models.SubscriptionStatusPaused, time.Now())
+	})
+}
+
+// ResumeSubscription استئناف اشتراك موقوف
+func ResumeSubscription(c *gin.Context) {
+	changeSubscription(c, "Subscription resumed", func(tx *gorm.DB, sub *models.Subscription) error {
+		return services.SetSubscriptionStatus(tx, sub, models.SubscriptionStatusActive, time.Now())
+	})
+}
+
+// CancelSubscription إلغاء الاشتراك نهائياً مع الإبقاء على سجله
+func CancelSubscription(c *gin.Context) {
+	changeSubscription(c, "Subscription cancelled", func(tx *gorm.DB, sub *models.Subscription) error {
+		return services.SetSubscriptionStatus(tx, sub, models.SubscriptionStatusCancelled, time.Now())
+	})
+}
+
+// changeSubscription تنفيذ إجراء على اشتراك المستخدم داخل معاملة
+func changeSubscription(c *gin.Context, message string, action func(tx *gorm.DB, sub *models.Subscription) error) {
+	user, ok := subscriptionUser(c)
+	if !ok {
+		return
+	}
+	sub, ok := loadUserSubscription(c, user)
+	if !ok {
+		return
+	}
+
+	tx := config.DB.Begin()
+	if err := action(tx, sub); err != nil {
+		tx.Rollback()
+		respondSubscriptionError(c, "Failed to update subscription", err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to update subscription", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, message, sub)
+}
+
+func subscriptionUser(c *gin.Context) (*models.User, bool) {
+	user, exists := c.Get("user")
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return nil, false
+	}
+	return user.(*models.User), true
+}
+
+func loadUserSubscription(c *gin.Context, user *models.User) (*models.Subscription, bool) {
+	subUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid subscription ID", err.Error())
+		return nil, false
+	}
+
+	var sub models.Subscription
+	if err := config.DB.Preload("Items.Product").
+		Where("id = ? AND user_id = ?", subUUID, user.ID).
+		First(&sub).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			utils.NotFoundResponse(c, "Subscription not found")
+		} else {
+			utils.InternalServerErrorResponse(c, "Failed to fetch subscription", err.Error())
+		}
+		return nil, false
+	}
+	return &sub, true
+}
+
+func respondSubscriptionError(c *gin.Context, message string, err error) {
+	switch {
+	case services.IsSubscriptionError(err):
+		utils.BadRequestResponse(c, "Subscription is not valid", err.Error())
+	case isPricingError(err):
+		utils.BadRequestResponse(c, "Invalid subscription items", err.Error())
+	default:
+		utils.InternalServerErrorResponse(c, message, err.Error())
+	}
+}
@@ -35,7 +35,7 @@ func getStatusArabicText(status models.WholesaleRequestStatus) string {
 	}
 }
 
-const uploadPath = "./uploads/wholesale"
+const uploadRoot = "./uploads"
 
 // UpgradeToWholesaleRequest request to upgrade to wholesale
 type UpgradeToWholesaleRequest struct {
@@ -48,23 +48,37 @@ type UpgradeToWholesaleRequest struct {
 
 // saveUploadedFile saves an uploaded file and returns its path
 func saveUploadedFile(fileHeader *multipart.FileHeader) (string, error) {
+	return saveUploadedFileTo(fileHeader, "wholesale")
+}
+
+// validateUploadedDocument checks the size and type of an uploaded image or PDF document
+func validateUploadedDocument(fileHeader *multipart.FileHeader) error {
 	// Validate file size (max 5MB)
 	if fileHeader.Size > 5<<20 {
-		return "", fmt.Errorf("حجم الملف يجب أن لا يتجاوز 5 ميجابايت")
+		return fmt.Errorf("حجم الملف يجب أن لا يتجاوز 5 ميجابايت")
 	}
 
 	// Validate file extension
 	ext := strings.ToLower(filepath.Ext(fileHeader.Filename))
 	if ext != ".jpg" && ext != ".jpeg" && ext != ".png" && ext != ".pdf" {
-		return "", fmt.Errorf("نوع الملف غير مدعوم. يرجى تحميل ملف بصيغة JPG أو PNG أو PDF")
+		return fmt.Errorf("نوع الملف غير مدعوم. يرجى تحميل ملف بصيغة JPG أو PNG أو PDF")
+	}
+	return nil
+}
+
+// saveUploadedFileTo saves an uploaded file under ./uploads/<folder> and returns its public path
+func saveUploadedFileTo(fileHeader *multipart.FileHeader, folder string) (string, error) {
+	if err := validateUploadedDocument(fileHeader); err != nil {
+		return "", err
 	}
 
 	// Create a unique filename
 	fileName := fmt.Sprintf("%d_%s", time.Now().UnixNano(), fileHeader.Filename)
-	filePath := filepath.Join(uploadPath, fileName)
+	dir := filepath.Join(uploadRoot, folder)
+	filePath := filepath.Join(dir, fileName)
 
 	// Create the uploads directory if it doesn't exist
-	if err := os.MkdirAll(uploadPath, 0755); err != nil {
+	if err := os.MkdirAll(dir, 0755); err != nil {
 		return "", fmt.Errorf("فشل في إنشاء مجلد التحميلات: %v", err)
 	}
 
@@ -74,7 +88,7 @@ func saveUploadedFile(fileHeader *multipart.FileHeader) (string, error) {
 	}
 
 	// Return the relative path
-	return "/uploads/wholesale/" + fileName, nil
+	return "/uploads/" + folder + "/" + fileName, nil
 }
 
 // saveFile saves the uploaded file to the specified path
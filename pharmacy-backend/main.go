@@ -61,6 +61,13 @@ func main() {
 	services.SetAdminNotifier(handlers.AdminNotifier)
 	log.Println("✅ تم ربط AdminNotifier مع notification service")
 
+	// مجدول الاشتراكات الدورية (تذكيرات وإنشاء الطلبات المستحقة)
+	services.StartSubscriptionScheduler(config.DB)
+	// استبعاد الدفعات المنتهية من المخزون القابل للبيع
+	services.StartStockSyncScheduler(config.DB)
+	// تنبيهات الصلاحية، إيقاف بيع المنتهي، تخفيضات قرب الانتهاء والملخص اليومي
+	services.StartExpiryScheduler(config.DB)
+
 	// Create uploads directory if it doesn't exist
 	if err := os.MkdirAll("uploads", 0755); err != nil {
 		log.Fatalf("❌ Failed to create uploads directory: %v", err)
@@ -107,8 +114,8 @@ func main() {
 			return isAllowedOrigin(origin)
 		},
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "Content-Length", "X-Requested-With", "X-CSRF-Token"},
-		ExposeHeaders:    []string{"Content-Length", "Set-Cookie"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "Content-Length", "X-Requested-With", "X-CSRF-Token", "Idempotency-Key"},
+		ExposeHeaders:    []string{"Content-Length", "Set-Cookie", "Idempotent-Replayed"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}
@@ -133,8 +140,8 @@ func main() {
 		
 		c.Header("Vary", "Origin") // Important for caching
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization, Content-Length, X-Requested-With, X-CSRF-Token")
-		c.Header("Access-Control-Expose-Headers", "Content-Length, Set-Cookie")
+		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization, Content-Length, X-Requested-With, X-CSRF-Token, Idempotency-Key")
+		c.Header("Access-Control-Expose-Headers", "Content-Length, Set-Cookie, Idempotent-Replayed")
 		c.Header("Access-Control-Allow-Credentials", "true")
 		c.Header("Access-Control-Max-Age", "43200") // 12 hours
 		
@@ -221,12 +228,17 @@ func main() {
 			categories.GET("/:id/products", handlers.GetCategoryWithProducts)
 		}
 
+		// الكوبونات - معاينة الخصم على السلة الحالية
+		// GET /api/v1/coupons/validate?code=XXX[&amount=...]
+		api.GET("/coupons/validate", middleware.OptionalAuthMiddleware(), handlers.ValidateCoupon)
+
 		// سلة التسوق
 		cart := api.Group("/cart")
 		cart.Use(middleware.AuthMiddleware())
 		{
 			cart.GET("/", handlers.GetCart)
 			cart.GET("", handlers.GetCart)
+			cart.GET("/validate", handlers.ValidateCart)
 			cart.POST("/items", handlers.AddToCart)
 			cart.PUT("/items/:id", handlers.UpdateCartItem)
 			cart.DELETE("/items/:id", handlers.RemoveFromCart)
@@ -249,12 +261,66 @@ func main() {
 		orders := api.Group("/orders")
 		orders.Use(middleware.AuthMiddleware())
 		{
-			orders.POST("/", handlers.CreateOrder)
-			orders.POST("", handlers.CreateOrder)
+			// Idempotency-Key يمنع تكرار الطلب عند إعادة المحاولة من الشبكات الضعيفة
+			orders.POST("/", middleware.Idempotency(), handlers.CreateOrder)
+			orders.POST("", middleware.Idempotency(), handlers.CreateOrder)
 			orders.GET("/", handlers.GetUserOrders)
 			orders.GET("", handlers.GetUserOrders)
 			orders.GET("/:id", handlers.GetOrder)
-			orders.POST("/:id/cancel", handlers.CancelOrder)
+			orders.POST("/:id/cancel", middleware.Idempotency(), handlers.CancelOrder)
+			orders.POST("/:id/returns", middleware.Idempotency(), handlers.CreateReturnRequest)
+			orders.GET("/:id/invoices", handlers.GetOrderInvoices)
+			orders.POST("/:id/reorder", handlers.ReorderOrder)
+		}
+
+		// الفواتير والإشعارات الدائنة الخاصة بالمستخدم
+		invoices := api.Group("/invoices")
+		invoices.Use(middleware.AuthMiddleware())
+		{
+			invoices.GET("/:id/pdf", handlers.DownloadInvoicePDF)
+			invoices.GET("/:id/xml", handlers.DownloadInvoiceXML)
+		}
+
+		// طلبات الإرجاع الخاصة بالمستخدم
+		returns := api.Group("/returns")
+		returns.Use(middleware.AuthMiddleware())
+		{
+			returns.GET("", handlers.GetUserReturns)
+			returns.GET("/:id", handlers.GetUserReturn)
+		}
+
+		// اشتراكات إعادة الصرف الدورية للأدوية المزمنة
+		subscriptions := api.Group("/subscriptions")
+		subscriptions.Use(middleware.AuthMiddleware())
+		{
+			subscriptions.POST("", handlers.CreateSubscription)
+			subscriptions.GET("", handlers.GetUserSubscriptions)
+			subscriptions.GET("/:id", handlers.GetUserSubscription)
+			subscriptions.PUT("/:id", handlers.UpdateSubscription)
+			subscriptions.POST("/:id/skip", handlers.SkipSubscription)
+			subscriptions.POST("/:id/pause", handlers.PauseSubscription)
+			subscriptions.POST("/:id/resume", handlers.ResumeSubscription)
+			subscriptions.DELETE("/:id", handlers.CancelSubscription)
+		}
+
+		// الوصفات الطبية للعميل
+		prescriptions := api.Group("/prescriptions")
+		prescriptions.Use(middleware.AuthMiddleware())
+		{
+			prescriptions.POST("", handlers.UploadPrescription)
+			prescriptions.GET("", handlers.GetUserPrescriptions)
+			prescriptions.GET("/:id", handlers.GetUserPrescription)
+			prescriptions.GET("/:id/file", handlers.DownloadUserPrescriptionFile)
+		}
+
+		// مراجعة الوصفات من الصيدلي
+		pharmacist := api.Group("/pharmacist")
+		pharmacist.Use(middleware.AuthMiddleware(), middleware.PharmacistMiddleware())
+		{
+			pharmacist.GET("/orders", handlers.GetPrescriptionReviewQueue)
+			pharmacist.GET("/orders/:id", handlers.GetPrescriptionReviewOrder)
+			pharmacist.PUT("/orders/:id/items/:itemId/prescription", handlers.ReviewPrescriptionItem)
+			pharmacist.GET("/prescriptions/:id/file", handlers.DownloadPrescriptionFileForReview)
 		}
 
 		// تتبع الطلب
@@ -290,6 +356,7 @@ func main() {
 				adminNotifications.PUT("/:id/read", handlers.MarkNotificationAsRead)
 				adminNotifications.PUT("/read-all", handlers.MarkAllNotificationsAsRead)
 				adminNotifications.POST("/test", handlers.CreateTestAdminNotifications)
+				adminNotifications.GET("/expiry-digest", handlers.GetExpiryDigest)
 			}
 			
 			// Apply middleware to other admin routes
@@ -313,7 +380,13 @@ func main() {
 				// Other product-related endpoints
 				adminProducts.GET("/low-stock", handlers.GetLowStockProducts)
 				adminProducts.GET("/expiring", handlers.GetExpiringProducts)
+
+				// Batch/lot inventory
+				adminProducts.GET("/:id/batches", handlers.GetProductBatches)
+				adminProducts.POST("/:id/batches", handlers.ReceiveProductBatch)
 			}
+			adminGroup.PATCH("/product-batches/:id", handlers.AdjustProductBatch)
+			adminGroup.GET("/product-batches/:id/orders", handlers.GetBatchOrderItems)
 
 			adminGroup.POST("/categories", handlers.CreateCategory)
 			adminGroup.PUT("/categories/:id", handlers.UpdateCategory)
@@ -322,6 +395,29 @@ func main() {
 			adminGroup.GET("/orders", handlers.GetAllOrders)
 			adminGroup.PUT("/orders/:id/status", handlers.UpdateOrderStatus)
 			adminGroup.POST("/orders/:id/tracking", handlers.AddOrderTracking)
+			adminGroup.POST("/orders/:id/items", handlers.AddOrderItem)
+			adminGroup.GET("/orders/:id/margin", handlers.GetOrderMargin)
+			adminGroup.PUT("/orders/:id/items/:itemId", handlers.UpdateOrderItem)
+			adminGroup.DELETE("/orders/:id/items/:itemId", handlers.RemoveOrderItem)
+
+			// Shipments (split parcels)
+			adminGroup.POST("/orders/:id/shipments", handlers.CreateShipment)
+			adminGroup.GET("/orders/:id/shipments", handlers.GetOrderShipments)
+			adminGroup.PUT("/shipments/:id/status", handlers.UpdateShipmentStatus)
+
+			// Returns & refunds
+			adminGroup.GET("/returns", handlers.GetAllReturns)
+			adminGroup.GET("/returns/:id", handlers.GetReturnByID)
+			adminGroup.PUT("/returns/:id/approve", handlers.ApproveReturn)
+			adminGroup.PUT("/returns/:id/reject", handlers.RejectReturn)
+			adminGroup.POST("/returns/:id/refund", handlers.RefundReturn)
+
+			// VAT invoices & credit notes
+			adminGroup.POST("/orders/:id/invoice", handlers.IssueOrderInvoice)
+			adminGroup.GET("/invoices", handlers.GetAllInvoices)
+			adminGroup.GET("/invoices/:id", handlers.GetInvoiceByID)
+			adminGroup.GET("/invoices/:id/pdf", handlers.AdminDownloadInvoicePDF)
+			adminGroup.GET("/invoices/:id/xml", handlers.AdminDownloadInvoiceXML)
 
 			// Dashboard and Activities routes (already defined below in the file)
 			// Users routes
@@ -341,6 +437,10 @@ func main() {
 				reports.GET("/sales", handlers.GetSalesReport)
 				reports.GET("/products", handlers.GetProductPerformanceReport)
 				reports.GET("/inventory", handlers.GetInventoryReport)
+				reports.GET("/inventory-valuation", handlers.GetInventoryValuation)
+				reports.GET("/gross-margin", handlers.GetGrossMarginReport)
+				reports.GET("/location-low-stock", handlers.GetLocationLowStockReport)
+				reports.GET("/location-expiry", handlers.GetLocationExpiryReport)
 			}
 
 			// Dashboard
@@ -369,6 +469,74 @@ func main() {
 			adminGroup.GET("/coupons", handlers.GetCoupons)
 			adminGroup.GET("/coupons/:id", handlers.GetCouponByID)
 
+			// Purchase invoices (draft → posted → voided)
+			adminGroup.GET("/purchase-invoices", handlers.GetPurchaseInvoices)
+			adminGroup.POST("/purchase-invoices", handlers.CreatePurchaseInvoice)
+			adminGroup.GET("/purchase-invoices/:id", handlers.GetPurchaseInvoice)
+			adminGroup.PUT("/purchase-invoices/:id", handlers.UpdatePurchaseInvoice)
+			adminGroup.DELETE("/purchase-invoices/:id", handlers.DeletePurchaseInvoice)
+			adminGroup.POST("/purchase-invoices/:id/post", handlers.PostPurchaseInvoice)
+			adminGroup.POST("/purchase-invoices/:id/void", handlers.VoidPurchaseInvoice)
+
+			// Suppliers and accounts payable ledger
+			adminGroup.GET("/suppliers", handlers.GetSuppliers)
+			adminGroup.POST("/suppliers", handlers.CreateSupplier)
+			adminGroup.GET("/suppliers/aging", handlers.GetSupplierAgingReport)
+			adminGroup.GET("/suppliers/:id", handlers.GetSupplier)
+			adminGroup.PUT("/suppliers/:id", handlers.UpdateSupplier)
+			adminGroup.DELETE("/suppliers/:id", handlers.DeleteSupplier)
+			adminGroup.GET("/suppliers/:id/transactions", handlers.GetSupplierTransactions)
+			adminGroup.POST("/suppliers/:id/payments", handlers.RecordSupplierPayment)
+			adminGroup.POST("/suppliers/:id/returns", handlers.CreateSupplierReturn)
+			adminGroup.GET("/suppliers/:id/statement", handlers.GetSupplierStatement)
+
+			// Stocktakes (open → submitted → approved) and reason-coded stock adjustments
+			adminGroup.GET("/stocktakes", handlers.GetStocktakes)
+			adminGroup.POST("/stocktakes", handlers.CreateStocktake)
+			adminGroup.GET("/stocktakes/:id", handlers.GetStocktake)
+			adminGroup.POST("/stocktakes/:id/counts", handlers.RecordStocktakeCounts)
+			adminGroup.POST("/stocktakes/:id/submit", handlers.SubmitStocktake)
+			adminGroup.POST("/stocktakes/:id/approve", handlers.ApproveStocktake)
+			adminGroup.POST("/stocktakes/:id/reopen", handlers.ReopenStocktake)
+			adminGroup.POST("/stocktakes/:id/cancel", handlers.CancelStocktake)
+			adminGroup.GET("/inventory/adjustments", handlers.GetStockAdjustments)
+			adminGroup.POST("/inventory/adjustments", handlers.CreateStockAdjustment)
+
+			// Replenishment suggestions and purchase orders (draft → sent → received)
+			adminGroup.GET("/replenishment/suggestions", handlers.GetReplenishmentSuggestions)
+			adminGroup.POST("/replenishment/purchase-orders", handlers.CreateReplenishmentPurchaseOrders)
+			adminGroup.GET("/purchase-orders", handlers.GetPurchaseOrders)
+			adminGroup.GET("/purchase-orders/:id", handlers.GetPurchaseOrder)
+			adminGroup.GET("/purchase-orders/:id/export", handlers.ExportPurchaseOrder)
+			adminGroup.PUT("/purchase-orders/:id", handlers.UpdatePurchaseOrder)
+			adminGroup.POST("/purchase-orders/:id/send", handlers.SendPurchaseOrder)
+			adminGroup.POST("/purchase-orders/:id/receive", handlers.ReceivePurchaseOrder)
+			adminGroup.POST("/purchase-orders/:id/cancel", handlers.CancelPurchaseOrder)
+
+			// Branch locations and inter-branch stock transfers (requested → dispatched → received)
+			adminGroup.GET("/locations", handlers.GetLocations)
+			adminGroup.GET("/locations/:id", handlers.GetLocation)
+			adminGroup.GET("/locations/:id/stock", handlers.GetLocationStock)
+			adminGroup.POST("/locations", handlers.CreateLocation)
+			adminGroup.PUT("/locations/:id", handlers.UpdateLocation)
+			adminGroup.DELETE("/locations/:id", handlers.DeleteLocation)
+			adminGroup.GET("/stock-transfers", handlers.GetStockTransfers)
+			adminGroup.GET("/stock-transfers/:id", handlers.GetStockTransfer)
+			adminGroup.POST("/stock-transfers", handlers.CreateStockTransfer)
+			adminGroup.POST("/stock-transfers/:id/dispatch", handlers.DispatchStockTransfer)
+			adminGroup.POST("/stock-transfers/:id/receive", handlers.ReceiveStockTransfer)
+			adminGroup.POST("/stock-transfers/:id/cancel", handlers.CancelStockTransfer)
+
+			// Expiry management job (alerts, auto-unpublish, near-expiry markdowns, daily digest)
+			adminGroup.POST("/expiry/run", handlers.RunExpiryJob)
+
+			// Quantity limits for controlled and restricted products
+			adminGroup.GET("/quantity-limits", handlers.GetQuantityLimitRules)
+			adminGroup.POST("/quantity-limits", handlers.CreateQuantityLimitRule)
+			adminGroup.PUT("/quantity-limits/:id", handlers.UpdateQuantityLimitRule)
+			adminGroup.DELETE("/quantity-limits/:id", handlers.DeleteQuantityLimitRule)
+			adminGroup.GET("/quantity-limits/violations", handlers.GetQuantityLimitViolations)
+
 			// Wholesale Admin Routes - تأكد من تطبيق middleware بشكل صحيح
 			wholesaleAdmin := adminGroup.Group("/wholesale-requests")
 			{
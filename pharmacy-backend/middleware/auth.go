@@ -202,6 +202,31 @@ func SuperAdminMiddleware() gin.HandlerFunc {
 	}
 }
 
+// PharmacistMiddleware middleware للتحقق من صلاحية مراجعة الوصفات الطبية
+func PharmacistMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, exists := c.Get("user")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "User not authenticated",
+			})
+			c.Abort()
+			return
+		}
+
+		userObj := user.(*models.User)
+		if !userObj.CanReviewPrescriptions() {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "Pharmacist access required",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
 // OptionalAuthMiddleware middleware اختياري للمصادقة (يدعم HttpOnly cookies و Authorization headers)
 func OptionalAuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
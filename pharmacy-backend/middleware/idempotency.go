@@ -0,0 +1,200 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"pharmacy-backend/config"
+	"pharmacy-backend/models"
+	"pharmacy-backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// IdempotencyHeader اسم الترويسة التي يرسل فيها العميل المفتاح
+	IdempotencyHeader = "Idempotency-Key"
+
+	idempotencyMaxKeyLength = 255
+	// idempotencyTTL مدة الاحتفاظ بالاستجابة لإعادة إرسالها
+	idempotencyTTL = 24 * time.Hour
+	// idempotencyStaleAfter مدة بعدها يعتبر الطلب العالق في المعالجة متروكاً
+	idempotencyStaleAfter = 2 * time.Minute
+)
+
+// idempotencyWriter يلتقط جسم الاستجابة لحفظه مع المفتاح
+type idempotencyWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *idempotencyWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *idempotencyWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Idempotency يمنع تنفيذ الطلب المعدِّل أكثر من مرة عند إعادة المحاولة بنفس Idempotency-Key
+// - أول طلب يُنفذ وتُحفظ استجابته (ما لم تكن خطأ خادم 5xx فيُسمح بإعادة المحاولة)
+// - إعادة نفس الطلب بنفس المفتاح تعيد الاستجابة الأصلية دون تنفيذ
+// - إعادة استخدام المفتاح بجسم مختلف أو على مورد آخر من المسار نفسه تعيد 409
+// الترويسة اختيارية؛ الطلبات بدونها تمر كما هي. يجب وضعه بعد AuthMiddleware إن وجد.
+func Idempotency() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := strings.TrimSpace(c.GetHeader(IdempotencyHeader))
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > idempotencyMaxKeyLength {
+			utils.BadRequestResponse(c, "Invalid Idempotency-Key", fmt.Sprintf("key must be at most %d characters", idempotencyMaxKeyLength))
+			c.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			utils.BadRequestResponse(c, "Failed to read request body", err.Error())
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		scope := idempotencyScope(c, idempotencyRoute(c))
+		requestHash := idempotencyRequestHash(c, body)
+
+		record, acquired, err := acquireIdempotencyKey(scope, key, requestHash)
+		if err != nil {
+			utils.InternalServerErrorResponse(c, "Failed to process Idempotency-Key", err.Error())
+			c.Abort()
+			return
+		}
+
+		if !acquired {
+			switch {
+			case record.RequestHash != requestHash:
+				utils.ErrorResponse(c, http.StatusConflict, "Idempotency-Key was already used with a different request", "idempotency_key_mismatch")
+			case record.Status == models.IdempotencyStatusProcessing:
+				utils.ErrorResponse(c, http.StatusConflict, "A request with this Idempotency-Key is still being processed", "idempotency_key_in_progress")
+			default:
+				c.Header("Idempotent-Replayed", "true")
+				c.Data(record.ResponseStatus, record.ContentType, record.ResponseBody)
+			}
+			c.Abort()
+			return
+		}
+
+		writer := &idempotencyWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+
+		status := writer.Status()
+		if status >= http.StatusInternalServerError {
+			// أخطاء الخادم تُلغى معاملاتها، فنحرر المفتاح ليُعاد الطلب لاحقاً
+			if err := config.DB.Delete(&models.IdempotencyKey{}, "id = ?", record.ID).Error; err != nil {
+				log.Printf("⚠️ Failed to release idempotency key %s: %v\n", key, err)
+			}
+			return
+		}
+
+		if err := config.DB.Model(&models.IdempotencyKey{}).Where("id = ?", record.ID).Updates(map[string]interface{}{
+			"status":          models.IdempotencyStatusCompleted,
+			"response_status": status,
+			"response_body":   writer.body.Bytes(),
+			"content_type":    writer.Header().Get("Content-Type"),
+			"updated_at":      time.Now(),
+		}).Error; err != nil {
+			log.Printf("⚠️ Failed to store idempotent response for key %s: %v\n", key, err)
+		}
+	}
+}
+
+// idempotencyRoute الطريقة والمسار المسجل (بدون الشرطة الأخيرة حتى يتطابق "/orders" و "/orders/")
+func idempotencyRoute(c *gin.Context) string {
+	return c.Request.Method + " " + strings.TrimSuffix(c.FullPath(), "/")
+}
+
+// idempotencyRequestHash بصمة الطلب: الطريقة والمسار الفعلي (بمعرفاته) والجسم
+// المسار الفعلي لا المسجل، حتى يُرفض استخدام المفتاح نفسه لطلب آخر مثل /orders/B/cancel بعد /orders/A/cancel.
+func idempotencyRequestHash(c *gin.Context, body []byte) string {
+	target := c.Request.Method + " " + strings.TrimSuffix(c.Request.URL.Path, "/") + "\n"
+	hash := sha256.Sum256(append([]byte(target), body...))
+	return hex.EncodeToString(hash[:])
+}
+
+// idempotencyScope نطاق المفتاح: المستخدم الحالي (إن وجد) والمسار
+func idempotencyScope(c *gin.Context, route string) string {
+	owner := "anonymous"
+	if userID, exists := c.Get("user_id"); exists {
+		owner = fmt.Sprint(userID)
+	}
+	return owner + ":" + route
+}
+
+// acquireIdempotencyKey حجز المفتاح للطلب الحالي أو إعادة السجل الموجود
+// يعيد acquired=true إذا كان الطلب الحالي هو من سينفذ العملية.
+func acquireIdempotencyKey(scope, key, requestHash string) (*models.IdempotencyKey, bool, error) {
+	record := models.IdempotencyKey{
+		Scope:       scope,
+		Key:         key,
+		RequestHash: requestHash,
+		Status:      models.IdempotencyStatusProcessing,
+	}
+	result := config.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&record)
+	if result.Error != nil {
+		return nil, false, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return &record, true, nil
+	}
+
+	var existing models.IdempotencyKey
+	if err := config.DB.Where("scope = ? AND key = ?", scope, key).First(&existing).Error; err != nil {
+		return nil, false, err
+	}
+
+	now := time.Now()
+	expired := existing.CreatedAt.Before(now.Add(-idempotencyTTL))
+	stale := existing.Status == models.IdempotencyStatusProcessing &&
+		existing.RequestHash == requestHash &&
+		existing.UpdatedAt.Before(now.Add(-idempotencyStaleAfter))
+	if expired || stale {
+		// الاستيلاء المشروط يضمن أن طلباً واحداً فقط يعيد استخدام السجل
+		takeover := config.DB.Model(&models.IdempotencyKey{}).
+			Where("id = ? AND updated_at = ?", existing.ID, existing.UpdatedAt).
+			Updates(map[string]interface{}{
+				"request_hash":    requestHash,
+				"status":          models.IdempotencyStatusProcessing,
+				"response_status": 0,
+				"response_body":   nil,
+				"content_type":    "",
+				"created_at":      now,
+				"updated_at":      now,
+			})
+		if takeover.Error != nil {
+			return nil, false, takeover.Error
+		}
+		if takeover.RowsAffected == 1 {
+			existing.RequestHash = requestHash
+			existing.Status = models.IdempotencyStatusProcessing
+			return &existing, true, nil
+		}
+		if err := config.DB.First(&existing, "id = ?", existing.ID).Error; err != nil {
+			return nil, false, err
+		}
+	}
+
+	return &existing, false, nil
+}
@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"pharmacy-backend/config"
+	"pharmacy-backend/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// setupIdempotencyRouter مسار إلغاء يعد مرات تنفيذ كل طلب
+func setupIdempotencyRouter(calls map[string]int) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	setUser := func(c *gin.Context) { c.Set("user_id", "user-1") }
+	r.POST("/orders/:id/cancel", setUser, Idempotency(), func(c *gin.Context) {
+		calls[c.Param("id")]++
+		c.JSON(http.StatusOK, gin.H{"cancelled": c.Param("id")})
+	})
+	return r
+}
+
+func TestIdempotencyRequestHashIncludesPathParams(t *testing.T) {
+	type fingerprint struct{ scope, hash string }
+	var got []fingerprint
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/orders/:id/cancel", func(c *gin.Context) {
+		c.Set("user_id", "user-1")
+		got = append(got, fingerprint{idempotencyScope(c, idempotencyRoute(c)), idempotencyRequestHash(c, []byte("{}"))})
+	})
+
+	for _, path := range []string{"/orders/A/cancel", "/orders/A/cancel", "/orders/B/cancel"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, path, strings.NewReader("{}")))
+	}
+	require.Len(t, got, 3)
+
+	assert.Equal(t, got[0], got[1], "a retry of the same order matches its first attempt")
+	assert.Equal(t, got[0].scope, got[2].scope, "one key covers the route, whatever the order id")
+	assert.NotEqual(t, got[0].hash, got[2].hash, "a different order id is a different request")
+}
+
+func TestIdempotencyKeyReusedOnAnotherOrder(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set; skipping idempotency tests")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+		Logger:                                   logger.Default.LogMode(logger.Silent),
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+	require.NoError(t, err)
+	config.DB = db
+	require.NoError(t, config.AutoMigrate())
+
+	key := "test-" + uuid.New().String()
+	t.Cleanup(func() {
+		db.Delete(&models.IdempotencyKey{}, "key = ?", key)
+	})
+
+	calls := map[string]int{}
+	r := setupIdempotencyRouter(calls)
+	send := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader("{}"))
+		req.Header.Set(IdempotencyHeader, key)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	first := send("/orders/A/cancel")
+	assert.Equal(t, http.StatusOK, first.Code)
+
+	replay := send("/orders/A/cancel")
+	assert.Equal(t, http.StatusOK, replay.Code)
+	assert.Equal(t, "true", replay.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, first.Body.String(), replay.Body.String())
+
+	other := send("/orders/B/cancel")
+	assert.Equal(t, http.StatusConflict, other.Code)
+	assert.Contains(t, other.Body.String(), "idempotency_key_mismatch")
+
+	assert.Equal(t, map[string]int{"A": 1}, calls, "B must not run under A's key")
+}
@@ -22,6 +22,7 @@ type Coupon struct {
 	MinOrderAmount    float64    `json:"min_order_amount" gorm:"default:0"`
 	MaxDiscountAmount *float64   `json:"max_discount_amount,omitempty"`
 	UsageLimit        *int       `json:"usage_limit,omitempty"`
+	PerUserLimit      *int       `json:"per_user_limit,omitempty"` // عدد مرات الاستخدام المسموح بها لكل مستخدم
 	UsedCount         int        `json:"used_count" gorm:"default:0"`
 	IsActive          bool       `json:"is_active" gorm:"default:true"`
 	ValidFrom         time.Time  `json:"valid_from" gorm:"not null"`
@@ -62,7 +63,12 @@ func (c *Coupon) CalculateDiscount(orderAmount float64) float64 {
 	if !c.CanBeUsedForOrder(orderAmount) {
 		return 0
 	}
-	
+	return c.DiscountFor(orderAmount)
+}
+
+// DiscountFor قيمة الخصم على المبلغ دون التحقق من صلاحية الكوبون
+// تُستخدم لإعادة تسعير طلب استُخدم فيه الكوبون مسبقاً فلا يُحتسب استخدامه عليه مرة أخرى.
+func (c *Coupon) DiscountFor(orderAmount float64) float64 {
 	var discount float64
 	
 	if c.Type == CouponTypePercentage {
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type CouponRedemptionStatus string
+
+const (
+	CouponRedemptionActive   CouponRedemptionStatus = "active"
+	CouponRedemptionReleased CouponRedemptionStatus = "released"
+)
+
+// CouponRedemption سجل استخدام كوبون في طلب محدد
+// السجلات النشطة فقط تُحتسب ضمن حد الاستخدام لكل مستخدم.
+type CouponRedemption struct {
+	ID             uuid.UUID              `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	CouponID       uuid.UUID              `json:"coupon_id" gorm:"type:uuid;not null;index"`
+	UserID         uuid.UUID              `json:"user_id" gorm:"type:uuid;not null;index"`
+	OrderID        uuid.UUID              `json:"order_id" gorm:"type:uuid;not null;uniqueIndex"`
+	DiscountAmount float64                `json:"discount_amount" gorm:"not null;default:0"`
+	Status         CouponRedemptionStatus `json:"status" gorm:"type:varchar(20);not null;default:'active'"`
+	ReleasedAt     *time.Time             `json:"released_at,omitempty"`
+	CreatedAt      time.Time              `json:"created_at"`
+	UpdatedAt      time.Time              `json:"updated_at"`
+
+	// العلاقات
+	Coupon Coupon `json:"coupon,omitempty" gorm:"foreignKey:CouponID"`
+}
+
+// BeforeCreate hook لإنشاء UUID قبل الحفظ
+func (cr *CouponRedemption) BeforeCreate(tx *gorm.DB) error {
+	if cr.ID == uuid.Nil {
+		cr.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName تحديد اسم الجدول
+func (CouponRedemption) TableName() string {
+	return "coupon_redemptions"
+}
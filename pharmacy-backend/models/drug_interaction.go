@@ -0,0 +1,100 @@
+package models
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// InteractionSeverity درجة خطورة التداخل الدوائي
+type InteractionSeverity string
+
+const (
+	InteractionSeverityMinor           InteractionSeverity = "minor"
+	InteractionSeverityModerate        InteractionSeverity = "moderate"
+	InteractionSeverityMajor           InteractionSeverity = "major"
+	InteractionSeverityContraindicated InteractionSeverity = "contraindicated"
+)
+
+// Rank ترتيب الخطورة للمقارنة والفرز (0 لقيمة غير معروفة)
+func (s InteractionSeverity) Rank() int {
+	switch s {
+	case InteractionSeverityMinor:
+		return 1
+	case InteractionSeverityModerate:
+		return 2
+	case InteractionSeverityMajor:
+		return 3
+	case InteractionSeverityContraindicated:
+		return 4
+	}
+	return 0
+}
+
+// IsValid التحقق من أن الخطورة من القيم المعروفة
+func (s InteractionSeverity) IsValid() bool {
+	return s.Rank() > 0
+}
+
+// DrugInteraction تداخل معروف بين مادتين فعالتين من قاعدة بيانات التداخلات المحلية
+// المادتان مخزنتان بعد التطبيع وبترتيب أبجدي (IngredientA < IngredientB) فلكل زوج صف واحد.
+type DrugInteraction struct {
+	ID             uuid.UUID           `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	IngredientA    string              `json:"ingredient_a" gorm:"type:varchar(150);not null;uniqueIndex:idx_drug_interactions_pair"`
+	IngredientB    string              `json:"ingredient_b" gorm:"type:varchar(150);not null;uniqueIndex:idx_drug_interactions_pair;index"`
+	Severity       InteractionSeverity `json:"severity" gorm:"type:varchar(20);not null"`
+	Description    string              `json:"description" gorm:"type:text"`
+	Recommendation string              `json:"recommendation,omitempty" gorm:"type:text"`
+	Source         string              `json:"source,omitempty"`
+	CreatedAt      time.Time           `json:"created_at"`
+	UpdatedAt      time.Time           `json:"updated_at"`
+}
+
+// BeforeCreate hook لإنشاء UUID قبل الحفظ
+func (d *DrugInteraction) BeforeCreate(tx *gorm.DB) error {
+	if d.ID == uuid.Nil {
+		d.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName تحديد اسم الجدول
+func (DrugInteraction) TableName() string {
+	return "drug_interactions"
+}
+
+var (
+	ingredientSeparators = regexp.MustCompile(`\s*(?:\+|/|,|;|&|\band\b|\sو\s)\s*`)
+	ingredientSpaces     = regexp.MustCompile(`\s+`)
+)
+
+// NormalizeIngredient توحيد كتابة اسم المادة الفعالة للمقارنة
+func NormalizeIngredient(name string) string {
+	return ingredientSpaces.ReplaceAllString(strings.ToLower(strings.TrimSpace(name)), " ")
+}
+
+// SplitActiveIngredients تقسيم المادة الفعالة للمنتجات المركبة (مثل "Paracetamol + Codeine")
+func SplitActiveIngredients(value string) []string {
+	seen := map[string]bool{}
+	var ingredients []string
+	for _, part := range ingredientSeparators.Split(strings.ToLower(value), -1) {
+		name := NormalizeIngredient(part)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		ingredients = append(ingredients, name)
+	}
+	return ingredients
+}
+
+// Ingredients المواد الفعالة للمنتج بعد التطبيع
+func (p *Product) Ingredients() []string {
+	if p.ActiveIngredient == nil {
+		return nil
+	}
+	return SplitActiveIngredients(*p.ActiveIngredient)
+}
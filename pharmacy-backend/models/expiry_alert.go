@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ExpiryAlert تنبيه أُرسل للإدارة عن دفعة بلغت إحدى عتبات قرب الانتهاء
+// يمنع تكرار التنبيه لنفس الدفعة والعتبة في كل تشغيل للمجدول.
+type ExpiryAlert struct {
+	ID            uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	BatchID       uuid.UUID `json:"batch_id" gorm:"type:uuid;not null;uniqueIndex:idx_expiry_alert_batch_threshold"`
+	ThresholdDays int       `json:"threshold_days" gorm:"not null;uniqueIndex:idx_expiry_alert_batch_threshold"`
+	ProductID     uuid.UUID `json:"product_id" gorm:"type:uuid;not null;index"`
+	ExpiryDate    time.Time `json:"expiry_date" gorm:"not null"`
+	Quantity      int       `json:"quantity" gorm:"not null"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// BeforeCreate hook لإنشاء UUID قبل الحفظ
+func (a *ExpiryAlert) BeforeCreate(tx *gorm.DB) error {
+	if a.ID == uuid.Nil {
+		a.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName تحديد اسم الجدول
+func (ExpiryAlert) TableName() string {
+	return "expiry_alerts"
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type IdempotencyStatus string
+
+const (
+	IdempotencyStatusProcessing IdempotencyStatus = "processing"
+	IdempotencyStatusCompleted  IdempotencyStatus = "completed"
+)
+
+// IdempotencyKey سجل مفتاح Idempotency-Key مع بصمة الطلب والاستجابة المحفوظة
+// النطاق (Scope) يربط المفتاح بالمستخدم والمسار حتى لا تتصادم مفاتيح عملاء مختلفين.
+type IdempotencyKey struct {
+	ID             uuid.UUID         `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Scope          string            `json:"scope" gorm:"not null;uniqueIndex:idx_idempotency_scope_key"`
+	Key            string            `json:"key" gorm:"size:255;not null;uniqueIndex:idx_idempotency_scope_key"`
+	RequestHash    string            `json:"request_hash" gorm:"size:64;not null"`
+	Status         IdempotencyStatus `json:"status" gorm:"type:varchar(20);not null;default:'processing'"`
+	ResponseStatus int               `json:"response_status"`
+	ResponseBody   []byte            `json:"-" gorm:"type:bytea"`
+	ContentType    string            `json:"content_type"`
+	CreatedAt      time.Time         `json:"created_at"`
+	UpdatedAt      time.Time         `json:"updated_at"`
+}
+
+// BeforeCreate hook لإنشاء UUID قبل الحفظ
+func (k *IdempotencyKey) BeforeCreate(tx *gorm.DB) error {
+	if k.ID == uuid.Nil {
+		k.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName تحديد اسم الجدول
+func (IdempotencyKey) TableName() string {
+	return "idempotency_keys"
+}
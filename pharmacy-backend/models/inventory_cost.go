@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type CostEntrySource string
+
+const (
+	CostSourceOpening        CostEntrySource = "opening"         // رصيد افتتاحي عند تفعيل التكلفة
+	CostSourcePurchase       CostEntrySource = "purchase"        // استلام دفعة
+	CostSourcePurchaseVoid   CostEntrySource = "purchase_void"   // إلغاء فاتورة شراء
+	CostSourceSale           CostEntrySource = "sale"            // شحن عنصر طلب (تكلفة البضاعة المباعة)
+	CostSourceCustomerReturn CostEntrySource = "customer_return" // مرتجع عميل أُعيد للمخزون
+	CostSourceSupplierReturn CostEntrySource = "supplier_return"
+	CostSourceAdjustment     CostEntrySource = "adjustment"   // تسوية أو جرد
+	CostSourceTransferOut    CostEntrySource = "transfer_out" // خروج دفعة من فرع في تحويل
+	CostSourceTransferIn     CostEntrySource = "transfer_in"  // استلامها في الفرع الآخر
+)
+
+// InventoryCostEntry قيد في دفتر تكلفة المخزون
+// الكمية والقيمة موجبتان للوارد وسالبتان للصادر، فقيمة المخزون في أي تاريخ
+// هي مجموع القيم حتى ذلك التاريخ. البضاعة المحجوزة لطلب تبقى في الدفتر حتى تُشحن.
+type InventoryCostEntry struct {
+	ID              uuid.UUID       `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	ProductID       uuid.UUID       `json:"product_id" gorm:"type:uuid;not null;index"`
+	BatchID         *uuid.UUID      `json:"batch_id,omitempty" gorm:"type:uuid;index"` // فارغ للمنتج غير المتتبع بالدفعات
+	Source          CostEntrySource `json:"source" gorm:"type:varchar(30);not null;index"`
+	Quantity        int             `json:"quantity" gorm:"not null"`
+	UnitCost        float64         `json:"unit_cost" gorm:"type:decimal(15,4);not null;default:0"`
+	Value           float64         `json:"value" gorm:"type:decimal(15,2);not null;default:0"`
+	OrderItemID     *uuid.UUID      `json:"order_item_id,omitempty" gorm:"type:uuid;index"`
+	ShipmentID      *uuid.UUID      `json:"shipment_id,omitempty" gorm:"type:uuid"`
+	ReferenceNumber string          `json:"reference_number,omitempty" gorm:"size:100"`
+	OccurredAt      time.Time       `json:"occurred_at" gorm:"not null;index"`
+	CreatedAt       time.Time       `json:"created_at"`
+}
+
+// BeforeCreate hook لإنشاء UUID قبل الحفظ
+func (e *InventoryCostEntry) BeforeCreate(tx *gorm.DB) error {
+	if e.ID == uuid.Nil {
+		e.ID = uuid.New()
+	}
+	if e.OccurredAt.IsZero() {
+		e.OccurredAt = time.Now()
+	}
+	return nil
+}
+
+// TableName تحديد اسم الجدول
+func (InventoryCostEntry) TableName() string {
+	return "inventory_cost_entries"
+}
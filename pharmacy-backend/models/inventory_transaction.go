@@ -7,14 +7,50 @@ import (
 	"gorm.io/gorm"
 )
 
+// TransactionType نوع حركة المخزون في دفتر الحركات
+// الكمية موجبة للداخل وسالبة للخارج، ومجموعها هو رصيد المنتج الفعلي.
 type TransactionType string
 
 const (
+    TransactionTypeSale       TransactionType = "sale"
+    TransactionTypeCancel     TransactionType = "cancel"
     TransactionTypePurchase  TransactionType = "purchase"
     TransactionTypeReturn    TransactionType = "return"
     TransactionTypeAdjustment TransactionType = "adjustment"
+    TransactionTypeTransfer   TransactionType = "transfer"
+    TransactionTypeOpening    TransactionType = "opening"   // رصيد افتتاحي يطابق الدفتر مع المخزون السابق له
+    TransactionTypeWriteOff   TransactionType = "write_off" // توثيق مرتجع لم يدخل المخزون فلا يُحسب في رصيده
 )
 
+// IsValid التحقق من أن النوع أحد الأنواع المعروفة
+func (t TransactionType) IsValid() bool {
+    switch t {
+    case TransactionTypeSale, TransactionTypeCancel, TransactionTypePurchase, TransactionTypeReturn,
+        TransactionTypeAdjustment, TransactionTypeTransfer, TransactionTypeOpening, TransactionTypeWriteOff:
+        return true
+    }
+    return false
+}
+
+// AdjustmentReason سبب تسوية المخزون في حركات التسوية
+type AdjustmentReason string
+
+const (
+    AdjustmentReasonDamage          AdjustmentReason = "damage"
+    AdjustmentReasonTheft           AdjustmentReason = "theft"
+    AdjustmentReasonExpiryWriteOff  AdjustmentReason = "expiry_write_off"
+    AdjustmentReasonCountCorrection AdjustmentReason = "count_correction"
+)
+
+// IsValid التحقق من أن السبب أحد الأسباب المعروفة
+func (r AdjustmentReason) IsValid() bool {
+    switch r {
+    case AdjustmentReasonDamage, AdjustmentReasonTheft, AdjustmentReasonExpiryWriteOff, AdjustmentReasonCountCorrection:
+        return true
+    }
+    return false
+}
+
 type InventoryTransaction struct {
     ID              uuid.UUID       `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
     ProductID       uuid.UUID       `gorm:"type:uuid;not null" json:"product_id"`
@@ -22,6 +58,7 @@ type InventoryTransaction struct {
     Quantity        int             `gorm:"not null" json:"quantity"`
     UnitPrice       float64         `gorm:"type:decimal(15,2);not null" json:"unit_price"`
     TransactionType TransactionType `gorm:"type:varchar(50);not null" json:"transaction_type"`
+    ReasonCode      AdjustmentReason `gorm:"type:varchar(30)" json:"reason_code,omitempty"`
     ReferenceNumber string          `gorm:"size:100" json:"reference_number,omitempty"`
     Notes           string          `gorm:"type:text" json:"notes,omitempty"`
     CreatedBy       *uuid.UUID      `gorm:"type:uuid" json:"created_by,omitempty"`
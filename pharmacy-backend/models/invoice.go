@@ -0,0 +1,106 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type InvoiceDocumentType string
+type InvoiceType string
+
+const (
+	InvoiceDocumentInvoice    InvoiceDocumentType = "invoice"     // فاتورة
+	InvoiceDocumentCreditNote InvoiceDocumentType = "credit_note" // إشعار دائن للاسترداد
+)
+
+const (
+	InvoiceTypeTax        InvoiceType = "tax"        // فاتورة ضريبية لمنشأة (B2B)
+	InvoiceTypeSimplified InvoiceType = "simplified" // فاتورة ضريبية مبسطة للمستهلك (B2C)
+)
+
+// Invoice فاتورة ضريبية أو إشعار دائن صادر لطلب
+// تُحفظ بيانات البائع والمشتري والمبالغ وقت الإصدار ولا تتغير بعدها.
+type Invoice struct {
+	ID                uuid.UUID           `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	InvoiceNumber     string              `json:"invoice_number" gorm:"uniqueIndex;not null"`
+	DocumentType      InvoiceDocumentType `json:"document_type" gorm:"type:varchar(20);not null;uniqueIndex:idx_invoices_order_invoice,where:document_type = 'invoice'"`
+	InvoiceType       InvoiceType         `json:"invoice_type" gorm:"type:varchar(20);not null"`
+	OrderID           uuid.UUID           `json:"order_id" gorm:"type:uuid;not null;index;uniqueIndex:idx_invoices_order_invoice,where:document_type = 'invoice'"`
+	OrderNumber       string              `json:"order_number"`
+	UserID            uuid.UUID           `json:"user_id" gorm:"type:uuid;not null;index"`
+	OriginalInvoiceID *uuid.UUID          `json:"original_invoice_id,omitempty" gorm:"type:uuid;index"` // الفاتورة الأصلية للإشعار الدائن
+	OriginalNumber    string              `json:"original_invoice_number,omitempty"`
+	ReturnRequestID   *uuid.UUID          `json:"return_request_id,omitempty" gorm:"type:uuid;index"`
+	Reason            string              `json:"reason,omitempty"` // سبب إصدار الإشعار الدائن
+
+	// بيانات البائع والمشتري وقت الإصدار
+	SellerName      string `json:"seller_name" gorm:"not null"`
+	SellerVATNumber string `json:"seller_vat_number" gorm:"not null"`
+	SellerAddress   string `json:"seller_address,omitempty"`
+	BuyerName       string `json:"buyer_name"`
+	BuyerVATNumber  string `json:"buyer_vat_number,omitempty"`
+	BuyerAddress    string `json:"buyer_address,omitempty"`
+
+	Currency       string    `json:"currency" gorm:"type:varchar(3);not null;default:'SAR'"`
+	TaxRate        float64   `json:"tax_rate" gorm:"not null"`
+	NetAmount      float64   `json:"net_amount" gorm:"not null"`       // مجموع الأسطر دون الضريبة بعد الخصم (يشمل الشحن)
+	DiscountAmount float64   `json:"discount_amount" gorm:"default:0"` // للعرض فقط؛ الخصم موزع على الأسطر
+	ShippingAmount float64   `json:"shipping_amount" gorm:"default:0"`
+	TaxAmount      float64   `json:"tax_amount" gorm:"not null"`
+	TotalAmount    float64   `json:"total_amount" gorm:"not null"`      // الإجمالي شامل الضريبة
+	QRCode         string    `json:"qr_code" gorm:"type:text;not null"` // حمولة TLV بترميز base64
+	IssuedAt       time.Time `json:"issued_at" gorm:"not null"`
+	CreatedAt      time.Time `json:"created_at"`
+
+	// العلاقات
+	Lines []InvoiceLine `json:"lines,omitempty" gorm:"foreignKey:InvoiceID"`
+}
+
+// InvoiceLine سطر في الفاتورة أو الإشعار الدائن
+type InvoiceLine struct {
+	ID          uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	InvoiceID   uuid.UUID  `json:"invoice_id" gorm:"type:uuid;not null;index"`
+	OrderItemID *uuid.UUID `json:"order_item_id,omitempty" gorm:"type:uuid"` // فارغ لسطر الشحن
+	ProductID   *uuid.UUID `json:"product_id,omitempty" gorm:"type:uuid"`
+	Description string     `json:"description" gorm:"not null"`
+	Quantity    int        `json:"quantity" gorm:"not null"`
+	UnitPrice   float64    `json:"unit_price" gorm:"not null"` // دون الضريبة
+	NetAmount   float64    `json:"net_amount" gorm:"not null"` // دون الضريبة
+	TaxRate     float64    `json:"tax_rate" gorm:"not null"`
+	TaxAmount   float64    `json:"tax_amount" gorm:"not null"`
+	TotalAmount float64    `json:"total_amount" gorm:"not null"` // شامل الضريبة
+	SortOrder   int        `json:"sort_order" gorm:"default:0"`
+}
+
+// BeforeCreate hook لإنشاء UUID قبل الحفظ
+func (i *Invoice) BeforeCreate(tx *gorm.DB) error {
+	if i.ID == uuid.Nil {
+		i.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate hook لإنشاء UUID قبل الحفظ
+func (l *InvoiceLine) BeforeCreate(tx *gorm.DB) error {
+	if l.ID == uuid.Nil {
+		l.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName تحديد اسم الجدول
+func (Invoice) TableName() string {
+	return "invoices"
+}
+
+// TableName تحديد اسم الجدول
+func (InvoiceLine) TableName() string {
+	return "invoice_lines"
+}
+
+// IsCreditNote التحقق مما إذا كان المستند إشعاراً دائناً
+func (i *Invoice) IsCreditNote() bool {
+	return i.DocumentType == InvoiceDocumentCreditNote
+}
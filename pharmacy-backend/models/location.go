@@ -0,0 +1,161 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Location فرع أو مستودع يحتفظ بمخزون
+// مخزون المنتج في الفرع هو مجموع دفعاته القابلة للبيع الموجودة فيه.
+type Location struct {
+	ID           uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Code         string    `json:"code" gorm:"size:30;uniqueIndex;not null"`
+	Name         string    `json:"name" gorm:"not null"`
+	City         string    `json:"city" gorm:"size:100;not null;index"`
+	Address      string    `json:"address,omitempty" gorm:"type:text"`
+	Phone        string    `json:"phone,omitempty" gorm:"size:50"`
+	ServedCities string    `json:"served_cities,omitempty" gorm:"type:text"` // مدن أخرى يخدمها الفرع مفصولة بفواصل
+	Priority     int       `json:"priority" gorm:"not null;default:100"`     // الأصغر يُفضل عند عدم وجود فرع في مدينة العميل
+	IsDefault    bool      `json:"is_default" gorm:"default:false"`          // يستقبل المخزون الذي لم يُحدد فرعه
+	IsActive     bool      `json:"is_active" gorm:"default:true"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// NormalizeCity توحيد اسم المدينة للمقارنة
+func NormalizeCity(city string) string {
+	return strings.ToLower(strings.Join(strings.Fields(city), " "))
+}
+
+// Serves التحقق مما إذا كان الفرع في المدينة أو يخدمها
+func (l *Location) Serves(city string) bool {
+	city = NormalizeCity(city)
+	if city == "" {
+		return false
+	}
+	if NormalizeCity(l.City) == city {
+		return true
+	}
+	for _, served := range strings.Split(l.ServedCities, ",") {
+		if NormalizeCity(served) == city {
+			return true
+		}
+	}
+	return false
+}
+
+// BeforeCreate hook لإنشاء UUID قبل الحفظ
+func (l *Location) BeforeCreate(tx *gorm.DB) error {
+	if l.ID == uuid.Nil {
+		l.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName تحديد اسم الجدول
+func (Location) TableName() string {
+	return "locations"
+}
+
+type StockTransferStatus string
+
+const (
+	StockTransferRequested  StockTransferStatus = "requested"  // طلبه الفرع المستلم ولم يخرج من المرسل
+	StockTransferDispatched StockTransferStatus = "dispatched" // خرج من الفرع المرسل وهو في الطريق
+	StockTransferReceived   StockTransferStatus = "received"   // استلمه الفرع المستلم
+	StockTransferCancelled  StockTransferStatus = "cancelled"
+)
+
+// StockTransfer مستند تحويل مخزون بين فرعين
+// البضاعة في الطريق لا تُحسب في مخزون أي من الفرعين.
+type StockTransfer struct {
+	ID             uuid.UUID           `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	TransferNumber string              `json:"transfer_number" gorm:"uniqueIndex;not null"`
+	FromLocationID uuid.UUID           `json:"from_location_id" gorm:"type:uuid;not null;index"`
+	ToLocationID   uuid.UUID           `json:"to_location_id" gorm:"type:uuid;not null;index"`
+	Status         StockTransferStatus `json:"status" gorm:"type:varchar(20);not null;default:'requested';index"`
+	Notes          string              `json:"notes,omitempty" gorm:"type:text"`
+	RequestedBy    *uuid.UUID          `json:"requested_by,omitempty" gorm:"type:uuid"`
+	DispatchedBy   *uuid.UUID          `json:"dispatched_by,omitempty" gorm:"type:uuid"`
+	DispatchedAt   *time.Time          `json:"dispatched_at,omitempty"`
+	ReceivedBy     *uuid.UUID          `json:"received_by,omitempty" gorm:"type:uuid"`
+	ReceivedAt     *time.Time          `json:"received_at,omitempty"`
+	CancelledAt    *time.Time          `json:"cancelled_at,omitempty"`
+	CreatedAt      time.Time           `json:"created_at"`
+	UpdatedAt      time.Time           `json:"updated_at"`
+
+	// العلاقات
+	FromLocation *Location           `json:"from_location,omitempty" gorm:"foreignKey:FromLocationID"`
+	ToLocation   *Location           `json:"to_location,omitempty" gorm:"foreignKey:ToLocationID"`
+	Lines        []StockTransferLine `json:"lines,omitempty" gorm:"foreignKey:StockTransferID"`
+}
+
+// StockTransferLine منتج في مستند التحويل بكمياته المطلوبة والمرسلة والمستلمة
+type StockTransferLine struct {
+	ID                 uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	StockTransferID    uuid.UUID `json:"stock_transfer_id" gorm:"type:uuid;not null;index"`
+	ProductID          uuid.UUID `json:"product_id" gorm:"type:uuid;not null;index"`
+	RequestedQuantity  int       `json:"requested_quantity" gorm:"not null"`
+	DispatchedQuantity int       `json:"dispatched_quantity" gorm:"not null;default:0"`
+	ReceivedQuantity   int       `json:"received_quantity" gorm:"not null;default:0"` // الفرق عن المرسل نقص في الطريق
+	SortOrder          int       `json:"sort_order" gorm:"default:0"`
+
+	// العلاقات
+	Product *Product             `json:"product,omitempty" gorm:"foreignKey:ProductID"`
+	Batches []StockTransferBatch `json:"batches,omitempty" gorm:"foreignKey:StockTransferLineID"`
+}
+
+// StockTransferBatch الكمية المرسلة من كل دفعة في الفرع المرسل والدفعة التي استلمتها
+type StockTransferBatch struct {
+	ID                  uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	StockTransferLineID uuid.UUID  `json:"stock_transfer_line_id" gorm:"type:uuid;not null;index"`
+	SourceBatchID       uuid.UUID  `json:"source_batch_id" gorm:"type:uuid;not null"`
+	BatchNumber         string     `json:"batch_number" gorm:"size:100;not null"`
+	ExpiryDate          *time.Time `json:"expiry_date,omitempty"`
+	Quantity            int        `json:"quantity" gorm:"not null"`
+	ReceivedQuantity    int        `json:"received_quantity" gorm:"not null;default:0"`
+	Value               float64    `json:"value" gorm:"type:decimal(15,2);not null;default:0"` // تكلفة الكمية المرسلة
+	DestinationBatchID  *uuid.UUID `json:"destination_batch_id,omitempty" gorm:"type:uuid"`
+}
+
+// BeforeCreate hook لإنشاء UUID قبل الحفظ
+func (t *StockTransfer) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName تحديد اسم الجدول
+func (StockTransfer) TableName() string {
+	return "stock_transfers"
+}
+
+// BeforeCreate hook لإنشاء UUID قبل الحفظ
+func (l *StockTransferLine) BeforeCreate(tx *gorm.DB) error {
+	if l.ID == uuid.Nil {
+		l.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName تحديد اسم الجدول
+func (StockTransferLine) TableName() string {
+	return "stock_transfer_lines"
+}
+
+// BeforeCreate hook لإنشاء UUID قبل الحفظ
+func (b *StockTransferBatch) BeforeCreate(tx *gorm.DB) error {
+	if b.ID == uuid.Nil {
+		b.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName تحديد اسم الجدول
+func (StockTransferBatch) TableName() string {
+	return "stock_transfer_batches"
+}
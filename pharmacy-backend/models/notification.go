@@ -15,13 +15,33 @@ const (
 	NotificationTypeWholesaleSubmitted  NotificationType = "wholesale_submitted"
 	NotificationTypeOrderCreated        NotificationType = "order_created"
 	NotificationTypeOrderUpdated        NotificationType = "order_status_updated"
+	NotificationTypeOrderEdited         NotificationType = "order_edited"
 	NotificationTypeAdminOrderCreated   NotificationType = "admin_order_created"
 	NotificationTypeAdminOrderUpdated   NotificationType = "admin_order_updated"
 	NotificationTypeAdminWholesaleOrder NotificationType = "admin_wholesale_order"
 	NotificationTypeAdminWholesaleSubmitted NotificationType = "admin_wholesale_submitted"
+	NotificationTypeReturnUpdated       NotificationType = "return_updated"
+	NotificationTypeAdminReturnRequested NotificationType = "admin_return_requested"
+	NotificationTypeSubscriptionReminder NotificationType = "subscription_reminder"
+	NotificationTypeSubscriptionOrdered  NotificationType = "subscription_ordered"
+	NotificationTypeSubscriptionFailed   NotificationType = "subscription_failed"
+	NotificationTypePrescriptionReviewed NotificationType = "prescription_reviewed"
+	NotificationTypeAdminExpiryAlert     NotificationType = "admin_expiry_alert"
+	NotificationTypeAdminExpiryDigest    NotificationType = "admin_expiry_digest"
 	NotificationTypeGeneral             NotificationType = "general"
 )
 
+// AdminNotificationTypes أنواع الإشعارات التي تظهر في لوحة الإدارة
+var AdminNotificationTypes = []NotificationType{
+	NotificationTypeAdminWholesaleSubmitted,
+	NotificationTypeAdminOrderCreated,
+	NotificationTypeAdminOrderUpdated,
+	NotificationTypeAdminWholesaleOrder,
+	NotificationTypeAdminReturnRequested,
+	NotificationTypeAdminExpiryAlert,
+	NotificationTypeAdminExpiryDigest,
+}
+
 type Notification struct {
 	ID        uuid.UUID        `json:"id" gorm:"type:uuid;primaryKey"`
 	UserID    uuid.UUID        `json:"user_id" gorm:"type:uuid;not null;index"`
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// SalesChannel قناة البيع التي لها سلسلة ترقيم طلبات مستقلة
+type SalesChannel string
+
+const (
+	SalesChannelRetail    SalesChannel = "retail"
+	SalesChannelWholesale SalesChannel = "wholesale"
+	SalesChannelPOS       SalesChannel = "pos"
+)
+
+// NumberSequence آخر رقم صادر في سلسلة ترقيم (مستند + قناة + سنة)
+// مفتاح السلسلة لا يتضمن البادئة، فتغيير البادئة من الإعدادات لا يعيد العداد إلى الصفر.
+type NumberSequence struct {
+	Series    string    `json:"series" gorm:"primaryKey"`
+	LastValue int64     `json:"last_value" gorm:"not null;default:0"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TableName تحديد اسم الجدول
+func (NumberSequence) TableName() string {
+	return "number_sequences"
+}
@@ -11,9 +11,11 @@ type OrderStatus string
 type PaymentStatus string
 
 const (
+	OrderStatusAwaitingPrescriptionReview OrderStatus = "awaiting_prescription_review" // بانتظار مراجعة الصيدلي للوصفات
 	OrderStatusPending    OrderStatus = "pending"
 	OrderStatusConfirmed  OrderStatus = "confirmed"
 	OrderStatusProcessing OrderStatus = "processing"
+	OrderStatusPartiallyShipped OrderStatus = "partially_shipped" // بعض الطرود شُحنت
 	OrderStatusShipped    OrderStatus = "shipped"
 	OrderStatusDelivered  OrderStatus = "delivered"
 	OrderStatusCancelled  OrderStatus = "cancelled"
@@ -24,23 +26,30 @@ const (
 	PaymentStatusPaid     PaymentStatus = "paid"
 	PaymentStatusFailed   PaymentStatus = "failed"
 	PaymentStatusRefunded PaymentStatus = "refunded"
+	PaymentStatusPartiallyRefunded PaymentStatus = "partially_refunded"
 )
 
 type Order struct {
 	ID                uuid.UUID     `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
 	UserID            uuid.UUID     `json:"user_id" gorm:"type:uuid;not null"`
 	OrderNumber       string        `json:"order_number" gorm:"uniqueIndex;not null"`
-	Status            OrderStatus   `json:"status" gorm:"type:varchar(20);default:'pending'"`
+	Status            OrderStatus   `json:"status" gorm:"type:varchar(40);default:'pending'"`
 	Subtotal          float64       `json:"subtotal" gorm:"not null"` // إجمالي سعر المنتجات قبل الخصم والضريبة والشحن
 	TotalAmount       float64       `json:"total_amount" gorm:"not null"` // الإجمالي النهائي بعد الخصم والضريبة والشحن
 	ShippingCost      float64       `json:"shipping_cost" gorm:"default:0"`
 	TaxAmount         float64       `json:"tax_amount" gorm:"default:0"`
 	DiscountAmount    float64       `json:"discount_amount" gorm:"default:0"`
+	CouponID          *uuid.UUID    `json:"coupon_id,omitempty" gorm:"type:uuid"`
+	CouponCode        string        `json:"coupon_code,omitempty"`
+	TrackingNumber    string        `json:"tracking_number,omitempty"`
+	ShippingCarrier   string        `json:"shipping_carrier,omitempty"`
 	PaymentMethod     string        `json:"payment_method"`
 	PaymentStatus     PaymentStatus `json:"payment_status" gorm:"type:varchar(20);default:'pending'"`
+	RefundedAmount    float64       `json:"refunded_amount" gorm:"default:0"`
 	ShippingAddress   Address       `json:"shipping_address" gorm:"type:jsonb;serializer:json"`
 	BillingAddress    *Address      `json:"billing_address,omitempty" gorm:"type:jsonb;serializer:json"` // يمكن أن يكون فارغاً
 	Notes             string        `json:"notes,omitempty" gorm:"type:text"`
+	LocationID        *uuid.UUID    `json:"location_id,omitempty" gorm:"type:uuid;index"` // الفرع الذي يُجهز منه الطلب؛ فارغ إذا وُزع على أكثر من فرع
 	EstimatedDelivery *time.Time    `json:"estimated_delivery,omitempty"`
 	ActualDelivery    *time.Time    `json:"actual_delivery,omitempty"`
 	CreatedAt         time.Time     `json:"created_at"`
@@ -50,6 +59,7 @@ type Order struct {
 	User         User           `json:"user,omitempty" gorm:"foreignKey:UserID"`
 	OrderItems   []OrderItem    `json:"order_items,omitempty" gorm:"foreignKey:OrderID"`
 	OrderTracking []OrderTracking `json:"order_tracking,omitempty" gorm:"foreignKey:OrderID"`
+	Shipments    []Shipment     `json:"shipments,omitempty" gorm:"foreignKey:OrderID"`
 }
 
 // BeforeCreate hook لإنشاء UUID ورقم الطلب قبل الحفظ
@@ -68,7 +78,8 @@ func (Order) TableName() string {
 	return "orders"
 }
 
-// generateOrderNumber إنشاء رقم طلب فريد
+// generateOrderNumber رقم احتياطي للطلبات المنشأة خارج services.PlaceOrder
+// الطلبات العادية تأخذ رقماً متسلسلاً من خدمة الترقيم قبل الحفظ.
 func generateOrderNumber() string {
 	return "ORD-" + time.Now().Format("2006") + "-" + uuid.New().String()[:8]
 }
@@ -80,7 +91,8 @@ func (o *Order) GetSubtotal() float64 {
 
 // CanBeCancelled التحقق من إمكانية إلغاء الطلب
 func (o *Order) CanBeCancelled() bool {
-	return o.Status == OrderStatusPending || o.Status == OrderStatusConfirmed
+	t, ok := FindOrderTransition(o.Status, OrderStatusCancelled)
+	return ok && t.AllowsRole(RoleCustomer)
 }
 
 // IsDelivered التحقق من تسليم الطلب
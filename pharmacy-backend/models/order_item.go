@@ -16,11 +16,16 @@ type OrderItem struct {
 	Quantity   int       `json:"quantity" gorm:"not null"`
 	UnitPrice  float64   `json:"unit_price" gorm:"not null"`
 	TotalPrice float64   `json:"total_price" gorm:"not null"`
+	PrescriptionID     *uuid.UUID `json:"prescription_id,omitempty" gorm:"type:uuid;index"`     // الوصفة المرفقة لمنتج يتطلب وصفة
+	PrescriptionStatus string     `json:"prescription_status,omitempty" gorm:"type:varchar(20)"` // pending_review أو approved
+	CostOfGoods        float64    `json:"-" gorm:"type:decimal(15,2);not null;default:0"` // تكلفة الكمية المشحونة؛ لا تظهر للعميل
+	CostedQuantity     int        `json:"-" gorm:"not null;default:0"`
 	CreatedAt  time.Time `json:"created_at"`
 	
 	// العلاقات
 	Order   Order   `json:"order,omitempty" gorm:"foreignKey:OrderID"`
 	Product Product `json:"product,omitempty" gorm:"foreignKey:ProductID"`
+	Batches []OrderItemBatch `json:"batches,omitempty" gorm:"foreignKey:OrderItemID"` // الدفعات المصروفة
 }
 
 // BeforeCreate hook لإنشاء UUID قبل الحفظ
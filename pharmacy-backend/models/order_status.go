@@ -0,0 +1,61 @@
+package models
+
+// OrderTransition انتقال مسموح بين حالتين للطلب
+type OrderTransition struct {
+	From                   OrderStatus
+	To                     OrderStatus
+	Roles                  []UserRole // الأدوار المسموح لها بتنفيذ الانتقال
+	RequiresTrackingNumber bool       // يتطلب رقم تتبع الشحنة
+}
+
+var staffRoles = []UserRole{RoleAdmin, RoleSuperAdmin}
+
+// reviewerRoles الأدوار التي تراجع الوصفات الطبية
+var reviewerRoles = []UserRole{RolePharmacist, RoleAdmin, RoleSuperAdmin}
+
+// OrderTransitions مخطط انتقالات حالة الطلب
+// أي انتقال غير مذكور هنا مرفوض؛ الحالتان delivered و cancelled نهائيتان.
+var OrderTransitions = []OrderTransition{
+	{From: OrderStatusAwaitingPrescriptionReview, To: OrderStatusPending, Roles: reviewerRoles},
+	{From: OrderStatusAwaitingPrescriptionReview, To: OrderStatusCancelled, Roles: []UserRole{RoleCustomer, RoleWholesale, RolePharmacist, RoleAdmin, RoleSuperAdmin}},
+	{From: OrderStatusPending, To: OrderStatusConfirmed, Roles: staffRoles},
+	{From: OrderStatusPending, To: OrderStatusCancelled, Roles: []UserRole{RoleCustomer, RoleWholesale, RoleAdmin, RoleSuperAdmin}},
+	{From: OrderStatusConfirmed, To: OrderStatusProcessing, Roles: staffRoles},
+	{From: OrderStatusConfirmed, To: OrderStatusCancelled, Roles: []UserRole{RoleCustomer, RoleWholesale, RoleAdmin, RoleSuperAdmin}},
+	{From: OrderStatusProcessing, To: OrderStatusShipped, Roles: staffRoles, RequiresTrackingNumber: true},
+	{From: OrderStatusProcessing, To: OrderStatusPartiallyShipped, Roles: staffRoles, RequiresTrackingNumber: true},
+	{From: OrderStatusPartiallyShipped, To: OrderStatusShipped, Roles: staffRoles, RequiresTrackingNumber: true},
+	{From: OrderStatusProcessing, To: OrderStatusCancelled, Roles: staffRoles},
+	{From: OrderStatusShipped, To: OrderStatusDelivered, Roles: staffRoles},
+}
+
+// FindOrderTransition البحث عن انتقال مسموح بين حالتين
+func FindOrderTransition(from, to OrderStatus) (OrderTransition, bool) {
+	for _, t := range OrderTransitions {
+		if t.From == from && t.To == to {
+			return t, true
+		}
+	}
+	return OrderTransition{}, false
+}
+
+// NextOrderStatuses الحالات التي يمكن الانتقال إليها من الحالة الحالية
+func NextOrderStatuses(from OrderStatus) []OrderStatus {
+	var next []OrderStatus
+	for _, t := range OrderTransitions {
+		if t.From == from {
+			next = append(next, t.To)
+		}
+	}
+	return next
+}
+
+// AllowsRole التحقق من أن الدور مسموح له بتنفيذ الانتقال
+func (t OrderTransition) AllowsRole(role UserRole) bool {
+	for _, r := range t.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
@@ -7,15 +7,21 @@ import (
 	"gorm.io/gorm"
 )
 
+// TrackingOrderEdited حدث تعديل عناصر الطلب من الإدارة (يُسجل في order_tracking)
+const TrackingOrderEdited = "order_edited"
+
 type OrderTracking struct {
-	ID          uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
-	OrderID     uuid.UUID `json:"order_id" gorm:"type:uuid;not null"`
-	Status      string    `json:"status" gorm:"not null"`
-	Description string    `json:"description" gorm:"type:text"`
-	Location    *string   `json:"location,omitempty"`
-	Timestamp   time.Time `json:"timestamp" gorm:"not null"`
-	CreatedAt   time.Time `json:"created_at"`
-	
+	ID          uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	OrderID     uuid.UUID  `json:"order_id" gorm:"type:uuid;not null"`
+	Status      string     `json:"status" gorm:"not null"`
+	FromStatus  string     `json:"from_status,omitempty"`                        // الحالة قبل الانتقال
+	ActorID     *uuid.UUID `json:"actor_id,omitempty" gorm:"type:uuid"`          // المستخدم أو المشرف الذي نفذ التغيير
+	ShipmentID  *uuid.UUID `json:"shipment_id,omitempty" gorm:"type:uuid;index"` // الشحنة المرتبطة بالحدث (إن وجدت)
+	Description string     `json:"description" gorm:"type:text"`
+	Location    *string    `json:"location,omitempty"`
+	Timestamp   time.Time  `json:"timestamp" gorm:"not null"`
+	CreatedAt   time.Time  `json:"created_at"`
+
 	// العلاقات
 	Order Order `json:"order,omitempty" gorm:"foreignKey:OrderID"`
 }
@@ -35,4 +41,3 @@ func (ot *OrderTracking) BeforeCreate(tx *gorm.DB) error {
 func (OrderTracking) TableName() string {
 	return "order_tracking"
 }
-
@@ -0,0 +1,103 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type PrescriptionStatus string
+type PrescriptionDecision string
+
+const (
+	PrescriptionStatusPendingReview PrescriptionStatus = "pending_review"
+	PrescriptionStatusApproved      PrescriptionStatus = "approved"
+	PrescriptionStatusRejected      PrescriptionStatus = "rejected" // مستند غير صالح؛ لا يُقبل في طلبات لاحقة
+)
+
+const (
+	PrescriptionDecisionApprove PrescriptionDecision = "approve"
+	PrescriptionDecisionReject  PrescriptionDecision = "reject"
+)
+
+// حالة مراجعة عنصر الطلب الذي يتطلب وصفة
+const (
+	OrderItemPrescriptionPending  = "pending_review"
+	OrderItemPrescriptionApproved = "approved"
+)
+
+// Prescription وصفة طبية رفعها العميل (صورة أو PDF) مع صلاحيتها وعدد مرات الصرف
+// تُصرف الوصفة مرة واحدة + RefillsAllowed مرة، وكل طلب يستخدمها يستهلك صرفة واحدة.
+type Prescription struct {
+	ID             uuid.UUID          `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID         uuid.UUID          `json:"user_id" gorm:"type:uuid;not null;index"`
+	FilePath       string             `json:"-" gorm:"not null"` // خارج مجلد uploads العام
+	FileName       string             `json:"file_name"`
+	ContentType    string             `json:"content_type"`
+	DoctorName     string             `json:"doctor_name,omitempty"`
+	PatientName    string             `json:"patient_name,omitempty"`
+	Notes          string             `json:"notes,omitempty" gorm:"type:text"`
+	IssuedAt       *time.Time         `json:"issued_at,omitempty"`
+	ValidUntil     time.Time          `json:"valid_until" gorm:"not null"`
+	RefillsAllowed int                `json:"refills_allowed" gorm:"not null;default:0"`
+	FillsUsed      int                `json:"fills_used" gorm:"not null;default:0"`
+	Status         PrescriptionStatus `json:"status" gorm:"type:varchar(20);not null;default:'pending_review'"`
+	ReviewNotes    string             `json:"review_notes,omitempty" gorm:"type:text"`
+	ReviewedBy     *uuid.UUID         `json:"reviewed_by,omitempty" gorm:"type:uuid"`
+	ReviewedAt     *time.Time         `json:"reviewed_at,omitempty"`
+	CreatedAt      time.Time          `json:"created_at"`
+	UpdatedAt      time.Time          `json:"updated_at"`
+}
+
+// PrescriptionReview قرار الصيدلي على عنصر طلب مرتبط بوصفة
+// يبقى السجل حتى لو حُذف العنصر من الطلب بعد الرفض.
+type PrescriptionReview struct {
+	ID             uuid.UUID            `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	PrescriptionID uuid.UUID            `json:"prescription_id" gorm:"type:uuid;not null;index"`
+	OrderID        uuid.UUID            `json:"order_id" gorm:"type:uuid;not null;index"`
+	OrderItemID    uuid.UUID            `json:"order_item_id" gorm:"type:uuid;not null"`
+	ProductID      uuid.UUID            `json:"product_id" gorm:"type:uuid;not null"`
+	ProductName    string               `json:"product_name"`
+	Quantity       int                  `json:"quantity"`
+	Decision       PrescriptionDecision `json:"decision" gorm:"type:varchar(20);not null"`
+	Notes          string               `json:"notes,omitempty" gorm:"type:text"`
+	ReviewerID     *uuid.UUID           `json:"reviewer_id,omitempty" gorm:"type:uuid"`
+	CreatedAt      time.Time            `json:"created_at"`
+}
+
+// RemainingFills عدد مرات الصرف المتبقية
+func (p *Prescription) RemainingFills() int {
+	return p.RefillsAllowed + 1 - p.FillsUsed
+}
+
+// IsExpired التحقق من انتهاء صلاحية الوصفة عند وقت معين
+func (p *Prescription) IsExpired(at time.Time) bool {
+	return !p.ValidUntil.After(at)
+}
+
+// BeforeCreate hook لإنشاء UUID قبل الحفظ
+func (p *Prescription) BeforeCreate(tx *gorm.DB) error {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName تحديد اسم الجدول
+func (Prescription) TableName() string {
+	return "prescriptions"
+}
+
+// BeforeCreate hook لإنشاء UUID قبل الحفظ
+func (r *PrescriptionReview) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName تحديد اسم الجدول
+func (PrescriptionReview) TableName() string {
+	return "prescription_reviews"
+}
@@ -71,7 +71,11 @@ type Product struct {
 	Description         string       `json:"description" gorm:"type:text"`
 	Price               float64      `json:"price" gorm:"not null"`
 	DiscountPrice       *float64     `json:"discount_price,omitempty"`
+	// تخفيض قرب الانتهاء الذي طبقه مجدول الصلاحية، والخصم الذي كان قبله ليُستعاد عند زواله
+	ExpiryMarkdownPercent  *float64 `json:"expiry_markdown_percent,omitempty"`
+	DiscountBeforeMarkdown *float64 `json:"-"`
 	SKU                 string       `json:"sku" gorm:"uniqueIndex;not null"`
+	Barcode             *string      `json:"barcode,omitempty" gorm:"index"` // الباركود المطبوع على العبوة (GTIN)
 	CategoryID          uuid.UUID    `json:"category_id" gorm:"type:uuid;not null"`
 	Brand               string       `json:"brand"`
 	StockQuantity       int          `json:"stock_quantity" gorm:"default:0"`
@@ -91,6 +95,7 @@ type Product struct {
 	BatchNumber         *string      `json:"batch_number,omitempty"`
 	Manufacturer        *string      `json:"manufacturer,omitempty"`
 	RequiresPrescription bool        `json:"requires_prescription" gorm:"default:false"`
+	NonReturnable       bool         `json:"non_returnable" gorm:"default:false"` // لا يُعاد للمخزون عند الإرجاع (تبريد، مفتوح...)
 	ActiveIngredient    *string      `json:"active_ingredient,omitempty"`
 	DosageForm          *string      `json:"dosage_form,omitempty"` // أقراص، شراب، كريم، إلخ
 	Strength            *string      `json:"strength,omitempty"`    // 500mg, 10ml
@@ -124,6 +129,11 @@ func (Product) TableName() string {
 	return "products"
 }
 
+// IsReturnable التحقق من إمكانية إعادة المنتج المرتجع إلى المخزون القابل للبيع
+func (p *Product) IsReturnable() bool {
+	return !p.NonReturnable
+}
+
 // IsInStock التحقق من توفر المنتج في المخزون
 func (p *Product) IsInStock() bool {
 	return p.StockQuantity > 0
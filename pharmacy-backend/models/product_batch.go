@@ -0,0 +1,83 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ProductBatch دفعة (تشغيلة) من منتج بكميتها المتبقية وتاريخ انتهائها وتكلفتها ومورّدها
+// مخزون المنتج القابل للبيع هو مجموع كميات دفعاته غير المنتهية.
+type ProductBatch struct {
+	ID               uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	ProductID        uuid.UUID  `json:"product_id" gorm:"type:uuid;not null;index"`
+	BatchNumber      string     `json:"batch_number" gorm:"size:100;not null"`
+	ExpiryDate       *time.Time `json:"expiry_date,omitempty" gorm:"index"`
+	Quantity         int        `json:"quantity" gorm:"not null;default:0;check:chk_product_batches_quantity_non_negative,quantity >= 0"` // المتبقي في المخزون
+	ReceivedQuantity int        `json:"received_quantity" gorm:"not null;default:0"`
+	UnitCost         float64    `json:"unit_cost" gorm:"type:decimal(15,2);not null;default:0"`
+	SupplierID       *uuid.UUID `json:"supplier_id,omitempty" gorm:"type:uuid;index"`
+	LocationID       *uuid.UUID `json:"location_id,omitempty" gorm:"type:uuid;index"` // الفرع الذي توجد فيه الدفعة
+	ReceivedAt       time.Time  `json:"received_at"`
+	Notes            string     `json:"notes,omitempty" gorm:"type:text"`
+	CreatedBy        *uuid.UUID `json:"created_by,omitempty" gorm:"type:uuid"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+
+	// العلاقات
+	Product  *Product  `json:"product,omitempty" gorm:"foreignKey:ProductID"`
+	Supplier *Supplier `json:"supplier,omitempty" gorm:"foreignKey:SupplierID"`
+	Location *Location `json:"location,omitempty" gorm:"foreignKey:LocationID"`
+}
+
+// IsExpired التحقق من انتهاء صلاحية الدفعة في وقت محدد
+func (b *ProductBatch) IsExpired(at time.Time) bool {
+	return b.ExpiryDate != nil && !b.ExpiryDate.After(at)
+}
+
+// IsSellable الدفعة فيها كمية ولم تنتهِ صلاحيتها
+func (b *ProductBatch) IsSellable(at time.Time) bool {
+	return b.Quantity > 0 && !b.IsExpired(at)
+}
+
+// BeforeCreate hook لإنشاء UUID قبل الحفظ
+func (b *ProductBatch) BeforeCreate(tx *gorm.DB) error {
+	if b.ID == uuid.Nil {
+		b.ID = uuid.New()
+	}
+	if b.ReceivedAt.IsZero() {
+		b.ReceivedAt = time.Now()
+	}
+	return nil
+}
+
+// TableName تحديد اسم الجدول
+func (ProductBatch) TableName() string {
+	return "product_batches"
+}
+
+// OrderItemBatch الكمية المصروفة من كل دفعة لعنصر طلب
+// رقم الدفعة وتاريخ انتهائها منسوخان وقت الصرف لتتبع الاستدعاءات.
+type OrderItemBatch struct {
+	ID          uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	OrderItemID uuid.UUID  `json:"order_item_id" gorm:"type:uuid;not null;index"`
+	BatchID     uuid.UUID  `json:"batch_id" gorm:"type:uuid;not null;index"`
+	BatchNumber string     `json:"batch_number" gorm:"size:100"`
+	ExpiryDate  *time.Time `json:"expiry_date,omitempty"`
+	Quantity    int        `json:"quantity" gorm:"not null"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// BeforeCreate hook لإنشاء UUID قبل الحفظ
+func (ob *OrderItemBatch) BeforeCreate(tx *gorm.DB) error {
+	if ob.ID == uuid.Nil {
+		ob.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName تحديد اسم الجدول
+func (OrderItemBatch) TableName() string {
+	return "order_item_batches"
+}
@@ -0,0 +1,88 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type PurchaseInvoiceStatus string
+
+const (
+	PurchaseInvoiceDraft  PurchaseInvoiceStatus = "draft"  // قابلة للتعديل ولم تؤثر على المخزون
+	PurchaseInvoicePosted PurchaseInvoiceStatus = "posted" // أُضيفت دفعاتها للمخزون وقيمتها لرصيد المورد
+	PurchaseInvoiceVoided PurchaseInvoiceStatus = "voided" // أُلغيت بعد الترحيل وعُكست آثارها
+)
+
+// PurchaseInvoice فاتورة شراء من مورد
+// رقم فاتورة المورد فريد لكل مورد ما لم تُلغَ الفاتورة، فيمكن إعادة إدخالها مصححة.
+type PurchaseInvoice struct {
+	ID                    uuid.UUID             `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	InvoiceNumber         string                `json:"invoice_number" gorm:"uniqueIndex;not null"` // الرقم الداخلي
+	SupplierID            uuid.UUID             `json:"supplier_id" gorm:"type:uuid;not null;index;uniqueIndex:idx_purchase_invoices_supplier_number,where:status <> 'voided'"`
+	SupplierInvoiceNumber string                `json:"supplier_invoice_number" gorm:"size:100;not null;uniqueIndex:idx_purchase_invoices_supplier_number,where:status <> 'voided'"`
+	InvoiceDate           time.Time             `json:"invoice_date" gorm:"type:date;not null"`
+	DueDate               *time.Time            `json:"due_date,omitempty" gorm:"type:date"`
+	LocationID            *uuid.UUID            `json:"location_id,omitempty" gorm:"type:uuid"` // الفرع المستلم؛ الفرع الافتراضي إذا كان فارغاً
+	Status                PurchaseInvoiceStatus `json:"status" gorm:"type:varchar(20);not null;default:'draft';index"`
+	Subtotal              float64               `json:"subtotal" gorm:"type:decimal(15,2);not null;default:0"`
+	TaxAmount             float64               `json:"tax_amount" gorm:"type:decimal(15,2);not null;default:0"`
+	TotalAmount           float64               `json:"total_amount" gorm:"type:decimal(15,2);not null;default:0"`
+	Notes                 string                `json:"notes,omitempty" gorm:"type:text"`
+	CreatedBy             *uuid.UUID            `json:"created_by,omitempty" gorm:"type:uuid"`
+	PostedBy              *uuid.UUID            `json:"posted_by,omitempty" gorm:"type:uuid"`
+	PostedAt              *time.Time            `json:"posted_at,omitempty"`
+	VoidedBy              *uuid.UUID            `json:"voided_by,omitempty" gorm:"type:uuid"`
+	VoidedAt              *time.Time            `json:"voided_at,omitempty"`
+	VoidReason            string                `json:"void_reason,omitempty" gorm:"type:text"`
+	CreatedAt             time.Time             `json:"created_at"`
+	UpdatedAt             time.Time             `json:"updated_at"`
+
+	// العلاقات
+	Supplier *Supplier             `json:"supplier,omitempty" gorm:"foreignKey:SupplierID"`
+	Lines    []PurchaseInvoiceLine `json:"lines,omitempty" gorm:"foreignKey:PurchaseInvoiceID"`
+}
+
+// PurchaseInvoiceLine سطر فاتورة شراء: دفعة واحدة من منتج
+type PurchaseInvoiceLine struct {
+	ID                uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	PurchaseInvoiceID uuid.UUID  `json:"purchase_invoice_id" gorm:"type:uuid;not null;index"`
+	ProductID         uuid.UUID  `json:"product_id" gorm:"type:uuid;not null;index"`
+	BatchNumber       string     `json:"batch_number" gorm:"size:100;not null"`
+	ExpiryDate        *time.Time `json:"expiry_date,omitempty"`
+	Quantity          int        `json:"quantity" gorm:"not null"`
+	UnitCost          float64    `json:"unit_cost" gorm:"type:decimal(15,2);not null"` // دون الضريبة
+	LineTotal         float64    `json:"line_total" gorm:"type:decimal(15,2);not null"`
+	BatchID           *uuid.UUID `json:"batch_id,omitempty" gorm:"type:uuid"` // الدفعة التي أُنشئت عند الترحيل
+	SortOrder         int        `json:"sort_order" gorm:"default:0"`
+
+	// العلاقات
+	Product *Product `json:"product,omitempty" gorm:"foreignKey:ProductID"`
+}
+
+// BeforeCreate hook لإنشاء UUID قبل الحفظ
+func (pi *PurchaseInvoice) BeforeCreate(tx *gorm.DB) error {
+	if pi.ID == uuid.Nil {
+		pi.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName تحديد اسم الجدول
+func (PurchaseInvoice) TableName() string {
+	return "purchase_invoices"
+}
+
+// BeforeCreate hook لإنشاء UUID قبل الحفظ
+func (l *PurchaseInvoiceLine) BeforeCreate(tx *gorm.DB) error {
+	if l.ID == uuid.Nil {
+		l.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName تحديد اسم الجدول
+func (PurchaseInvoiceLine) TableName() string {
+	return "purchase_invoice_lines"
+}
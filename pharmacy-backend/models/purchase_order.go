@@ -0,0 +1,82 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type PurchaseOrderStatus string
+
+const (
+	PurchaseOrderDraft     PurchaseOrderStatus = "draft"    // مقترح قابل للتعديل ولم يُرسل للمورد
+	PurchaseOrderSent      PurchaseOrderStatus = "sent"     // أُرسل للمورد وينتظر التوريد
+	PurchaseOrderReceived  PurchaseOrderStatus = "received" // وصلت البضاعة وسُجلت بفاتورة شراء
+	PurchaseOrderCancelled PurchaseOrderStatus = "cancelled"
+)
+
+// PurchaseOrder أمر شراء لمورد واحد
+// الكميات في المسودة والمرسل تُحتسب "قيد الطلب" عند اقتراح إعادة التزويد.
+type PurchaseOrder struct {
+	ID                uuid.UUID           `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	OrderNumber       string              `json:"order_number" gorm:"uniqueIndex;not null"`
+	SupplierID        uuid.UUID           `json:"supplier_id" gorm:"type:uuid;not null;index"`
+	Status            PurchaseOrderStatus `json:"status" gorm:"type:varchar(20);not null;default:'draft';index"`
+	ExpectedDate      *time.Time          `json:"expected_date,omitempty" gorm:"type:date"`              // تاريخ الإنشاء أو الإرسال مضافاً إليه أيام التوريد
+	Subtotal          float64             `json:"subtotal" gorm:"type:decimal(15,2);not null;default:0"` // تقديري بآخر سعر شراء
+	Notes             string              `json:"notes,omitempty" gorm:"type:text"`
+	PurchaseInvoiceID *uuid.UUID          `json:"purchase_invoice_id,omitempty" gorm:"type:uuid"`
+	CreatedBy         *uuid.UUID          `json:"created_by,omitempty" gorm:"type:uuid"`
+	SentBy            *uuid.UUID          `json:"sent_by,omitempty" gorm:"type:uuid"`
+	SentAt            *time.Time          `json:"sent_at,omitempty"`
+	ReceivedAt        *time.Time          `json:"received_at,omitempty"`
+	CancelledAt       *time.Time          `json:"cancelled_at,omitempty"`
+	CancelReason      string              `json:"cancel_reason,omitempty" gorm:"type:text"`
+	CreatedAt         time.Time           `json:"created_at"`
+	UpdatedAt         time.Time           `json:"updated_at"`
+
+	// العلاقات
+	Supplier *Supplier           `json:"supplier,omitempty" gorm:"foreignKey:SupplierID"`
+	Lines    []PurchaseOrderLine `json:"lines,omitempty" gorm:"foreignKey:PurchaseOrderID"`
+}
+
+// PurchaseOrderLine سطر أمر شراء
+// الرمز والاسم نسخة وقت الإنشاء حتى يطابق الملف المرسل للمورد.
+type PurchaseOrderLine struct {
+	ID              uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	PurchaseOrderID uuid.UUID `json:"purchase_order_id" gorm:"type:uuid;not null;index"`
+	ProductID       uuid.UUID `json:"product_id" gorm:"type:uuid;not null;index"`
+	SKU             string    `json:"sku" gorm:"size:100"`
+	Name            string    `json:"name" gorm:"not null"`
+	Quantity        int       `json:"quantity" gorm:"not null"`
+	UnitCost        float64   `json:"unit_cost" gorm:"type:decimal(15,2);not null;default:0"`
+	LineTotal       float64   `json:"line_total" gorm:"type:decimal(15,2);not null;default:0"`
+	SortOrder       int       `json:"sort_order" gorm:"default:0"`
+}
+
+// BeforeCreate hook لإنشاء UUID قبل الحفظ
+func (po *PurchaseOrder) BeforeCreate(tx *gorm.DB) error {
+	if po.ID == uuid.Nil {
+		po.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName تحديد اسم الجدول
+func (PurchaseOrder) TableName() string {
+	return "purchase_orders"
+}
+
+// BeforeCreate hook لإنشاء UUID قبل الحفظ
+func (l *PurchaseOrderLine) BeforeCreate(tx *gorm.DB) error {
+	if l.ID == uuid.Nil {
+		l.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName تحديد اسم الجدول
+func (PurchaseOrderLine) TableName() string {
+	return "purchase_order_lines"
+}
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// QuantityLimitRule حد كمية البيع لمنتج أو لتصنيف كامل لكل عميل خلال فترة
+// مثال: بحد أقصى عبوتين كل 30 يوماً، وللأفراد فقط (RetailOnly يمنع حسابات الجملة).
+// قاعدة التصنيف تجمع كميات كل منتجات التصنيف معاً.
+type QuantityLimitRule struct {
+	ID          uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	ProductID   *uuid.UUID `json:"product_id,omitempty" gorm:"type:uuid;index"`
+	CategoryID  *uuid.UUID `json:"category_id,omitempty" gorm:"type:uuid;index"`
+	MaxQuantity int        `json:"max_quantity" gorm:"not null"`
+	PeriodDays  int        `json:"period_days" gorm:"not null"`
+	RetailOnly  bool       `json:"retail_only" gorm:"not null;default:false"`
+	IsActive    bool       `json:"is_active" gorm:"not null;default:true"`
+	Reason      string     `json:"reason,omitempty" gorm:"type:text"` // السبب النظامي الذي يظهر للعميل
+	CreatedBy   *uuid.UUID `json:"created_by,omitempty" gorm:"type:uuid"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+
+	// العلاقات
+	Product  *Product  `json:"product,omitempty" gorm:"foreignKey:ProductID"`
+	Category *Category `json:"category,omitempty" gorm:"foreignKey:CategoryID"`
+}
+
+// QuantityLimitViolationType سبب رفض محاولة الشراء
+type QuantityLimitViolationType string
+
+const (
+	QuantityLimitExceeded   QuantityLimitViolationType = "quantity_exceeded"
+	QuantityLimitRetailOnly QuantityLimitViolationType = "retail_only"
+)
+
+// QuantityLimitViolation محاولة شراء مرفوضة بسبب حد الكمية (لتقرير الإدارة)
+type QuantityLimitViolation struct {
+	ID                uuid.UUID                  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	RuleID            uuid.UUID                  `json:"rule_id" gorm:"type:uuid;not null;index"`
+	UserID            uuid.UUID                  `json:"user_id" gorm:"type:uuid;not null;index"`
+	ProductID         uuid.UUID                  `json:"product_id" gorm:"type:uuid;not null;index"`
+	ProductName       string                     `json:"product_name"`
+	Type              QuantityLimitViolationType `json:"type" gorm:"type:varchar(30);not null"`
+	Source            string                     `json:"source" gorm:"type:varchar(20);not null"` // cart أو order أو subscription أو order_edit
+	RequestedQuantity int                        `json:"requested_quantity"`
+	PurchasedQuantity int                        `json:"purchased_quantity"` // المشترى خلال الفترة قبل المحاولة
+	MaxQuantity       int                        `json:"max_quantity"`
+	PeriodDays        int                        `json:"period_days"`
+	CreatedAt         time.Time                  `json:"created_at" gorm:"index"`
+
+	// العلاقات
+	User *User `json:"user,omitempty" gorm:"foreignKey:UserID"`
+}
+
+// AppliesTo التحقق من انطباق القاعدة على المنتج
+func (r *QuantityLimitRule) AppliesTo(product *Product) bool {
+	if r.ProductID != nil {
+		return *r.ProductID == product.ID
+	}
+	return r.CategoryID != nil && *r.CategoryID == product.CategoryID
+}
+
+// BeforeCreate hook لإنشاء UUID قبل الحفظ
+func (r *QuantityLimitRule) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName تحديد اسم الجدول
+func (QuantityLimitRule) TableName() string {
+	return "quantity_limit_rules"
+}
+
+// BeforeCreate hook لإنشاء UUID قبل الحفظ
+func (v *QuantityLimitViolation) BeforeCreate(tx *gorm.DB) error {
+	if v.ID == uuid.Nil {
+		v.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName تحديد اسم الجدول
+func (QuantityLimitViolation) TableName() string {
+	return "quantity_limit_violations"
+}
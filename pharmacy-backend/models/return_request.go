@@ -0,0 +1,126 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type ReturnStatus string
+type ReturnDisposition string
+
+const (
+	ReturnStatusRequested ReturnStatus = "requested"
+	ReturnStatusApproved  ReturnStatus = "approved"
+	ReturnStatusRejected  ReturnStatus = "rejected"
+	ReturnStatusRefunded  ReturnStatus = "refunded"
+)
+
+// أحداث تتبع الطلب الخاصة بالإرجاع (تُسجل في order_tracking)
+const (
+	TrackingReturnRequested = "return_requested"
+	TrackingReturnApproved  = "return_approved"
+	TrackingReturnRejected  = "return_rejected"
+	TrackingRefunded        = "refunded"
+)
+
+const (
+	ReturnDispositionRestock  ReturnDisposition = "restock"   // يعاد إلى المخزون القابل للبيع
+	ReturnDispositionWriteOff ReturnDisposition = "write_off" // يُتلف ولا يعاد للمخزون
+)
+
+// ReturnRequest طلب إرجاع مقدم من العميل لعناصر من طلب مُسلَّم
+type ReturnRequest struct {
+	ID           uuid.UUID    `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	ReturnNumber string       `json:"return_number" gorm:"uniqueIndex;not null"`
+	OrderID      uuid.UUID    `json:"order_id" gorm:"type:uuid;not null;index"`
+	UserID       uuid.UUID    `json:"user_id" gorm:"type:uuid;not null;index"`
+	Status       ReturnStatus `json:"status" gorm:"type:varchar(20);not null;default:'requested'"`
+	Reason       string       `json:"reason" gorm:"not null"`
+	Notes        string       `json:"notes,omitempty" gorm:"type:text"`
+	Photos       StringArray  `json:"photos" gorm:"type:jsonb"`
+	AdminNotes   string       `json:"admin_notes,omitempty" gorm:"type:text"`
+	ReviewedBy   *uuid.UUID   `json:"reviewed_by,omitempty" gorm:"type:uuid"`
+	ReviewedAt   *time.Time   `json:"reviewed_at,omitempty"`
+	RefundAmount float64      `json:"refund_amount" gorm:"default:0"` // المبلغ المسترد فعلياً
+	RefundedBy   *uuid.UUID   `json:"refunded_by,omitempty" gorm:"type:uuid"`
+	RefundedAt   *time.Time   `json:"refunded_at,omitempty"`
+	CreatedAt    time.Time    `json:"created_at"`
+	UpdatedAt    time.Time    `json:"updated_at"`
+
+	// العلاقات
+	Order Order        `json:"order,omitempty" gorm:"foreignKey:OrderID"`
+	User  User         `json:"user,omitempty" gorm:"foreignKey:UserID"`
+	Items []ReturnItem `json:"items,omitempty" gorm:"foreignKey:ReturnRequestID"`
+}
+
+// ReturnItem عنصر مرتجع مرتبط بعنصر الطلب الأصلي
+type ReturnItem struct {
+	ID               uuid.UUID         `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	ReturnRequestID  uuid.UUID         `json:"return_request_id" gorm:"type:uuid;not null;index"`
+	OrderItemID      uuid.UUID         `json:"order_item_id" gorm:"type:uuid;not null;index"`
+	ProductID        uuid.UUID         `json:"product_id" gorm:"type:uuid;not null"`
+	Name             string            `json:"name"`
+	Quantity         int               `json:"quantity" gorm:"not null"`
+	UnitPrice        float64           `json:"unit_price" gorm:"not null"`
+	RefundableAmount float64           `json:"refundable_amount" gorm:"not null"` // بعد توزيع خصم الكوبون
+	Reason           string            `json:"reason,omitempty"`
+	Disposition      ReturnDisposition `json:"disposition,omitempty" gorm:"type:varchar(20)"`
+	CreatedAt        time.Time         `json:"created_at"`
+}
+
+// BeforeCreate hook لإنشاء UUID ورقم الإرجاع قبل الحفظ
+func (r *ReturnRequest) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	if r.ReturnNumber == "" {
+		r.ReturnNumber = "RMA-" + time.Now().Format("2006") + "-" + uuid.New().String()[:8]
+	}
+	return nil
+}
+
+// TableName تحديد اسم الجدول
+func (ReturnRequest) TableName() string {
+	return "return_requests"
+}
+
+// BeforeCreate hook لإنشاء UUID قبل الحفظ
+func (ri *ReturnItem) BeforeCreate(tx *gorm.DB) error {
+	if ri.ID == uuid.Nil {
+		ri.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName تحديد اسم الجدول
+func (ReturnItem) TableName() string {
+	return "return_items"
+}
+
+// ReturnItemBatch الكمية المعادة إلى كل دفعة من مرتجع عميل
+// سجلات صرف الدفعات للطلب تبقى كما هي لتتبع الاستدعاءات، وهذا السجل يحدد ما عاد من كل دفعة.
+type ReturnItemBatch struct {
+	ID           uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	ReturnItemID uuid.UUID  `json:"return_item_id" gorm:"type:uuid;not null;index"`
+	OrderItemID  uuid.UUID  `json:"order_item_id" gorm:"type:uuid;not null;index"`
+	BatchID      uuid.UUID  `json:"batch_id" gorm:"type:uuid;not null;index"`
+	BatchNumber  string     `json:"batch_number" gorm:"size:100"`
+	ExpiryDate   *time.Time `json:"expiry_date,omitempty"`
+	Quantity     int        `json:"quantity" gorm:"not null"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// BeforeCreate hook لإنشاء UUID قبل الحفظ
+func (rb *ReturnItemBatch) BeforeCreate(tx *gorm.DB) error {
+	if rb.ID == uuid.Nil {
+		rb.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName تحديد اسم الجدول
+func (ReturnItemBatch) TableName() string {
+	return "return_item_batches"
+}
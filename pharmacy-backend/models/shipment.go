@@ -0,0 +1,96 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type ShipmentStatus string
+
+const (
+	ShipmentStatusPending   ShipmentStatus = "pending"    // تم التجهيز ولم تُسلَّم للناقل
+	ShipmentStatusShipped   ShipmentStatus = "shipped"    // سُلِّمت للناقل
+	ShipmentStatusInTransit ShipmentStatus = "in_transit" // في الطريق
+	ShipmentStatusDelivered ShipmentStatus = "delivered"
+)
+
+// shipmentStatusRank ترتيب حالات الشحنة؛ الانتقال مسموح للأمام فقط
+var shipmentStatusRank = map[ShipmentStatus]int{
+	ShipmentStatusPending:   0,
+	ShipmentStatusShipped:   1,
+	ShipmentStatusInTransit: 2,
+	ShipmentStatusDelivered: 3,
+}
+
+// Shipment طرد من طرود الطلب لدى ناقل برقم تتبع مستقل
+type Shipment struct {
+	ID             uuid.UUID      `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	OrderID        uuid.UUID      `json:"order_id" gorm:"type:uuid;not null;index"`
+	ShipmentNumber string         `json:"shipment_number" gorm:"uniqueIndex;not null"`
+	Carrier        string         `json:"carrier" gorm:"not null"`
+	TrackingNumber string         `json:"tracking_number" gorm:"not null"`
+	WeightKg       *float64       `json:"weight_kg,omitempty"`
+	Status         ShipmentStatus `json:"status" gorm:"type:varchar(20);not null;default:'pending'"`
+	ShippedAt      *time.Time     `json:"shipped_at,omitempty"`
+	DeliveredAt    *time.Time     `json:"delivered_at,omitempty"`
+	CreatedBy      *uuid.UUID     `json:"created_by,omitempty" gorm:"type:uuid"`
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+
+	// العلاقات
+	Items    []ShipmentItem  `json:"items,omitempty" gorm:"foreignKey:ShipmentID"`
+	Timeline []OrderTracking `json:"timeline,omitempty" gorm:"foreignKey:ShipmentID"`
+}
+
+// ShipmentItem كمية من عنصر الطلب داخل الشحنة
+type ShipmentItem struct {
+	ID          uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	ShipmentID  uuid.UUID `json:"shipment_id" gorm:"type:uuid;not null;index"`
+	OrderItemID uuid.UUID `json:"order_item_id" gorm:"type:uuid;not null;index"`
+	ProductID   uuid.UUID `json:"product_id" gorm:"type:uuid;not null"`
+	Name        string    `json:"name"`
+	Quantity    int       `json:"quantity" gorm:"not null"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// BeforeCreate hook لإنشاء UUID ورقم الشحنة قبل الحفظ
+func (s *Shipment) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	if s.ShipmentNumber == "" {
+		s.ShipmentNumber = "SHP-" + time.Now().Format("2006") + "-" + uuid.New().String()[:8]
+	}
+	return nil
+}
+
+// TableName تحديد اسم الجدول
+func (Shipment) TableName() string {
+	return "shipments"
+}
+
+// CanMoveTo التحقق من أن حالة الشحنة الجديدة تالية للحالة الحالية
+func (s *Shipment) CanMoveTo(status ShipmentStatus) bool {
+	next, ok := shipmentStatusRank[status]
+	return ok && next > shipmentStatusRank[s.Status]
+}
+
+// HasLeftWarehouse التحقق من أن الشحنة سُلِّمت للناقل
+func (s *Shipment) HasLeftWarehouse() bool {
+	return shipmentStatusRank[s.Status] >= shipmentStatusRank[ShipmentStatusShipped]
+}
+
+// BeforeCreate hook لإنشاء UUID قبل الحفظ
+func (si *ShipmentItem) BeforeCreate(tx *gorm.DB) error {
+	if si.ID == uuid.Nil {
+		si.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName تحديد اسم الجدول
+func (ShipmentItem) TableName() string {
+	return "shipment_items"
+}
@@ -0,0 +1,86 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type StocktakeStatus string
+
+const (
+	StocktakeOpen      StocktakeStatus = "open"      // العد جارٍ
+	StocktakeSubmitted StocktakeStatus = "submitted" // العد انتهى وينتظر اعتماد الفروقات
+	StocktakeApproved  StocktakeStatus = "approved"  // سُجلت الفروقات كحركات تسوية
+	StocktakeCancelled StocktakeStatus = "cancelled"
+)
+
+// Stocktake جلسة جرد لكل المنتجات أو لتصنيف واحد
+// الكميات المتوقعة تُلتقط عند فتح الجلسة، والفرق يُطبق عند الاعتماد على المخزون الحالي
+// فلا تضيع المبيعات التي تمت أثناء العد.
+type Stocktake struct {
+	ID          uuid.UUID       `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Number      string          `json:"number" gorm:"uniqueIndex;not null"`
+	CategoryID  *uuid.UUID      `json:"category_id,omitempty" gorm:"type:uuid;index"` // فارغ = كل المنتجات
+	Status      StocktakeStatus `json:"status" gorm:"type:varchar(20);not null;default:'open';index"`
+	Notes       string          `json:"notes,omitempty" gorm:"type:text"`
+	CreatedBy   *uuid.UUID      `json:"created_by,omitempty" gorm:"type:uuid"`
+	SubmittedBy *uuid.UUID      `json:"submitted_by,omitempty" gorm:"type:uuid"`
+	SubmittedAt *time.Time      `json:"submitted_at,omitempty"`
+	ApprovedBy  *uuid.UUID      `json:"approved_by,omitempty" gorm:"type:uuid"`
+	ApprovedAt  *time.Time      `json:"approved_at,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+
+	// العلاقات
+	Category *Category       `json:"category,omitempty" gorm:"foreignKey:CategoryID"`
+	Lines    []StocktakeLine `json:"lines,omitempty" gorm:"foreignKey:StocktakeID"`
+}
+
+// StocktakeLine سطر جرد: دفعة واحدة، أو المنتج كله إذا لم يكن متتبعاً بالدفعات
+type StocktakeLine struct {
+	ID               uuid.UUID        `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	StocktakeID      uuid.UUID        `json:"stocktake_id" gorm:"type:uuid;not null;index"`
+	ProductID        uuid.UUID        `json:"product_id" gorm:"type:uuid;not null;index"`
+	BatchID          *uuid.UUID       `json:"batch_id,omitempty" gorm:"type:uuid"`
+	BatchNumber      string           `json:"batch_number,omitempty" gorm:"size:100"`
+	ExpiryDate       *time.Time       `json:"expiry_date,omitempty"`
+	ExpectedQuantity int              `json:"expected_quantity" gorm:"not null"`
+	CountedQuantity  *int             `json:"counted_quantity,omitempty"` // فارغ = لم يُعد
+	Variance         int              `json:"variance" gorm:"not null;default:0"`
+	UnitCost         float64          `json:"unit_cost" gorm:"type:decimal(15,2);not null;default:0"`
+	ReasonCode       AdjustmentReason `json:"reason_code,omitempty" gorm:"type:varchar(30)"`
+	AdjustedQuantity int              `json:"adjusted_quantity" gorm:"not null;default:0"` // ما طُبق فعلاً عند الاعتماد
+	CountedBy        *uuid.UUID       `json:"counted_by,omitempty" gorm:"type:uuid"`
+	CountedAt        *time.Time       `json:"counted_at,omitempty"`
+
+	// العلاقات
+	Product *Product `json:"product,omitempty" gorm:"foreignKey:ProductID"`
+}
+
+// BeforeCreate hook لإنشاء UUID قبل الحفظ
+func (s *Stocktake) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName تحديد اسم الجدول
+func (Stocktake) TableName() string {
+	return "stocktakes"
+}
+
+// BeforeCreate hook لإنشاء UUID قبل الحفظ
+func (l *StocktakeLine) BeforeCreate(tx *gorm.DB) error {
+	if l.ID == uuid.Nil {
+		l.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName تحديد اسم الجدول
+func (StocktakeLine) TableName() string {
+	return "stocktake_lines"
+}
@@ -0,0 +1,115 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type SubscriptionStatus string
+type SubscriptionRunStatus string
+
+const (
+	SubscriptionStatusActive    SubscriptionStatus = "active"
+	SubscriptionStatusPaused    SubscriptionStatus = "paused"
+	SubscriptionStatusCancelled SubscriptionStatus = "cancelled"
+)
+
+const (
+	SubscriptionRunOrdered SubscriptionRunStatus = "ordered" // أُنشئ الطلب
+	SubscriptionRunSkipped SubscriptionRunStatus = "skipped" // تخطاها العميل
+	SubscriptionRunFailed  SubscriptionRunStatus = "failed"  // تعذر إنشاء الطلب (مخزون، وصفة، ...)
+)
+
+// Subscription اشتراك إعادة صرف دوري لأدوية الأمراض المزمنة
+type Subscription struct {
+	ID               uuid.UUID          `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID           uuid.UUID          `json:"user_id" gorm:"type:uuid;not null;index"`
+	Status           SubscriptionStatus `json:"status" gorm:"type:varchar(20);not null;default:'active';index"`
+	IntervalDays     int                `json:"interval_days" gorm:"not null"`
+	NextRunAt        time.Time          `json:"next_run_at" gorm:"not null;index"`
+	NotifyDaysBefore int                `json:"notify_days_before" gorm:"not null;default:3"`
+	ReminderSentFor  *time.Time         `json:"reminder_sent_for,omitempty"` // موعد الدورة التي أُرسل تذكيرها
+	PaymentMethod    string             `json:"payment_method" gorm:"type:varchar(50);not null"`
+	ShippingAddress  Address            `json:"shipping_address" gorm:"type:jsonb;serializer:json"`
+	Notes            string             `json:"notes,omitempty" gorm:"type:text"`
+	PrescriptionID   *uuid.UUID         `json:"prescription_id,omitempty" gorm:"type:uuid"` // الوصفة المستخدمة لمنتجات الوصفة في كل دورة
+	LastOrderID      *uuid.UUID         `json:"last_order_id,omitempty" gorm:"type:uuid"`
+	LastRunAt        *time.Time         `json:"last_run_at,omitempty"`
+	LastError        string             `json:"last_error,omitempty" gorm:"type:text"`
+	CreatedAt        time.Time          `json:"created_at"`
+	UpdatedAt        time.Time          `json:"updated_at"`
+
+	// العلاقات
+	User  User               `json:"-" gorm:"foreignKey:UserID"`
+	Items []SubscriptionItem `json:"items,omitempty" gorm:"foreignKey:SubscriptionID"`
+	Runs  []SubscriptionRun  `json:"runs,omitempty" gorm:"foreignKey:SubscriptionID"`
+}
+
+// SubscriptionItem منتج وكمية ضمن الاشتراك
+type SubscriptionItem struct {
+	ID             uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	SubscriptionID uuid.UUID `json:"subscription_id" gorm:"type:uuid;not null;index"`
+	ProductID      uuid.UUID `json:"product_id" gorm:"type:uuid;not null"`
+	Quantity       int       `json:"quantity" gorm:"not null"`
+	CreatedAt      time.Time `json:"created_at"`
+
+	Product Product `json:"product,omitempty" gorm:"foreignKey:ProductID"`
+}
+
+// SubscriptionRun سجل تنفيذ دورة واحدة من الاشتراك
+// الفهرس الفريد على (subscription_id, scheduled_for) يمنع إنشاء طلبين لنفس الدورة.
+type SubscriptionRun struct {
+	ID             uuid.UUID             `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	SubscriptionID uuid.UUID             `json:"subscription_id" gorm:"type:uuid;not null;uniqueIndex:idx_subscription_runs_cycle"`
+	ScheduledFor   time.Time             `json:"scheduled_for" gorm:"not null;uniqueIndex:idx_subscription_runs_cycle"`
+	Status         SubscriptionRunStatus `json:"status" gorm:"type:varchar(20);not null"`
+	OrderID        *uuid.UUID            `json:"order_id,omitempty" gorm:"type:uuid"`
+	Message        string                `json:"message,omitempty" gorm:"type:text"`
+	CreatedAt      time.Time             `json:"created_at"`
+}
+
+// IsActive التحقق مما إذا كان الاشتراك فعالاً
+func (s *Subscription) IsActive() bool {
+	return s.Status == SubscriptionStatusActive
+}
+
+// BeforeCreate hook لإنشاء UUID قبل الحفظ
+func (s *Subscription) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName تحديد اسم الجدول
+func (Subscription) TableName() string {
+	return "subscriptions"
+}
+
+// BeforeCreate hook لإنشاء UUID قبل الحفظ
+func (si *SubscriptionItem) BeforeCreate(tx *gorm.DB) error {
+	if si.ID == uuid.Nil {
+		si.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName تحديد اسم الجدول
+func (SubscriptionItem) TableName() string {
+	return "subscription_items"
+}
+
+// BeforeCreate hook لإنشاء UUID قبل الحفظ
+func (sr *SubscriptionRun) BeforeCreate(tx *gorm.DB) error {
+	if sr.ID == uuid.Nil {
+		sr.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName تحديد اسم الجدول
+func (SubscriptionRun) TableName() string {
+	return "subscription_runs"
+}
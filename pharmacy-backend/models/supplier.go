@@ -15,7 +15,10 @@ type Supplier struct {
     Email         string    `gorm:"size:255" json:"email,omitempty"`
     Address       string    `gorm:"type:text" json:"address,omitempty"`
     TaxNumber     string    `gorm:"size:100" json:"tax_number,omitempty"`
-    Balance       float64   `gorm:"type:decimal(15,2);default:0" json:"balance"`
+    Balance       float64   `gorm:"type:decimal(15,2);default:0" json:"balance"` // المستحق للمورد: مجموع قيود دفتره
+    LeadTimeDays  int       `gorm:"default:7" json:"lead_time_days"` // أيام التوريد من إرسال أمر الشراء حتى الاستلام
+    Notes         string    `gorm:"type:text" json:"notes,omitempty"`
+    IsActive      bool      `gorm:"default:true" json:"is_active"`
     CreatedAt     time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
     UpdatedAt     time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
 
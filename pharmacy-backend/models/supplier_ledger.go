@@ -0,0 +1,49 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type SupplierLedgerEntryType string
+
+const (
+	SupplierEntryOpening     SupplierLedgerEntryType = "opening"      // رصيد سابق لبدء الدفتر
+	SupplierEntryInvoice     SupplierLedgerEntryType = "invoice"      // فاتورة شراء مرحّلة
+	SupplierEntryInvoiceVoid SupplierLedgerEntryType = "invoice_void" // إلغاء فاتورة شراء مرحّلة
+	SupplierEntryPayment     SupplierLedgerEntryType = "payment"      // دفعة للمورد
+	SupplierEntryReturn      SupplierLedgerEntryType = "return"       // مرتجع بضاعة للمورد
+)
+
+// SupplierLedgerEntry قيد في دفتر حساب المورد
+// المبلغ موجب إذا زاد المستحق للمورد (فاتورة) وسالب إذا أنقصه (دفعة، مرتجع، إلغاء).
+// القيود لا تُعدل ولا تُحذف؛ التصحيح يكون بقيد عكسي.
+type SupplierLedgerEntry struct {
+	ID                uuid.UUID               `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	SupplierID        uuid.UUID               `json:"supplier_id" gorm:"type:uuid;not null;index:idx_supplier_ledger_supplier_date,priority:1"`
+	EntryType         SupplierLedgerEntryType `json:"type" gorm:"type:varchar(20);not null"`
+	EntryDate         time.Time               `json:"date" gorm:"type:date;not null;index:idx_supplier_ledger_supplier_date,priority:2"`
+	DueDate           *time.Time              `json:"due_date,omitempty" gorm:"type:date"`
+	Amount            float64                 `json:"amount" gorm:"type:decimal(15,2);not null"`
+	ReferenceNumber   string                  `json:"reference_number,omitempty" gorm:"size:100"`
+	Description       string                  `json:"description,omitempty" gorm:"type:text"`
+	PaymentMethod     string                  `json:"payment_method,omitempty" gorm:"size:50"`
+	PurchaseInvoiceID *uuid.UUID              `json:"purchase_invoice_id,omitempty" gorm:"type:uuid;index"`
+	CreatedBy         *uuid.UUID              `json:"created_by,omitempty" gorm:"type:uuid"`
+	CreatedAt         time.Time               `json:"created_at"`
+}
+
+// BeforeCreate hook لإنشاء UUID قبل الحفظ
+func (e *SupplierLedgerEntry) BeforeCreate(tx *gorm.DB) error {
+	if e.ID == uuid.Nil {
+		e.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName تحديد اسم الجدول
+func (SupplierLedgerEntry) TableName() string {
+	return "supplier_ledger_entries"
+}
@@ -17,6 +17,7 @@ const (
 	RoleAdmin      UserRole = "admin"
 	RoleSuperAdmin UserRole = "super_admin"
 	RoleWholesale  UserRole = "wholesale"
+	RolePharmacist UserRole = "pharmacist"
 )
 
 const (
@@ -44,6 +45,7 @@ type User struct {
 	WholesaleAccess     bool   `json:"wholesale_access" gorm:"default:false"` // صلاحية الوصول للجملة
 	CompanyName         string `json:"company_name,omitempty"`
 	CommercialRegister  string `json:"commercial_register,omitempty"`
+	VATNumber           string `json:"vat_number,omitempty"` // الرقم الضريبي للمنشأة لإصدار فاتورة ضريبية
 	IDDocumentURL       string `json:"id_document_url,omitempty"`
 	CommercialDocumentURL string `json:"commercial_document_url,omitempty"`
 	
@@ -84,6 +86,11 @@ func (u *User) IsAdmin() bool {
 	return u.Role == RoleAdmin || u.Role == RoleSuperAdmin
 }
 
+// CanReviewPrescriptions التحقق من صلاحية مراجعة الوصفات الطبية (صيدلي أو إداري)
+func (u *User) CanReviewPrescriptions() bool {
+	return u.Role == RolePharmacist || u.IsAdmin()
+}
+
 // IsSuperAdmin التحقق من كون المستخدم إداري عام
 func (u *User) IsSuperAdmin() bool {
 	return u.Role == RoleSuperAdmin
@@ -5,6 +5,7 @@ import (
 	"os"
 	"pharmacy-backend/config"
 	"pharmacy-backend/models"
+	"pharmacy-backend/services"
 	"time"
 
 	"github.com/google/uuid"
@@ -84,7 +85,21 @@ func seed() {
 				CreatedAt:      time.Now(),
 				UpdatedAt:      time.Now(),
 			}
-			if err := db.Create(&product).Error; err != nil {
+			err := db.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Create(&product).Error; err != nil {
+					return err
+				}
+				// الرصيد الافتتاحي يُسجل في دفتر الحركات حتى يطابقه أمر reconcile-stock
+				_, err := services.RecordStockMovement(tx, services.StockMovement{
+					ProductID:       product.ID,
+					Type:            models.TransactionTypeOpening,
+					Quantity:        product.StockQuantity,
+					ReferenceNumber: "SEED",
+					Notes:           "رصيد افتتاحي من بيانات التجربة",
+				})
+				return err
+			})
+			if err != nil {
 				log.Fatal("❌ Failed to create product:", err)
 			}
 			log.Println("✅ Created new product:", product.Name)
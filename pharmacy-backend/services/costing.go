@@ -0,0 +1,365 @@
+package services
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"pharmacy-backend/models"
+)
+
+// CostingMethod طريقة تقييم الصادر من المخزون
+type CostingMethod string
+
+const (
+	CostingWeightedAverage CostingMethod = "weighted_average" // متوسط تكلفة المنتج كله
+	CostingFIFO            CostingMethod = "fifo"             // الوارد أولاً يصرف أولاً داخل كل دفعة
+)
+
+// IsValid التحقق من أن الطريقة مدعومة
+func (m CostingMethod) IsValid() bool {
+	return m == CostingWeightedAverage || m == CostingFIFO
+}
+
+// CurrentCostingMethod طريقة التكلفة من COSTING_METHOD (المتوسط المرجح افتراضياً)
+// تغيير الطريقة يؤثر على الصادر بعده فقط؛ القيود السابقة تحتفظ بقيمها.
+func CurrentCostingMethod() CostingMethod {
+	method := CostingMethod(envString("COSTING_METHOD", string(CostingWeightedAverage)))
+	if !method.IsValid() {
+		return CostingWeightedAverage
+	}
+	return method
+}
+
+// costLayer طبقة وارد بكميتها وتكلفة وحدتها
+type costLayer struct {
+	Quantity int
+	UnitCost float64
+}
+
+// fifoIssueValue قيمة صرف كمية من طبقات الوارد بترتيبها بعد تخطي ما صُرف منها سابقاً
+// ما يتجاوز الطبقات يُقيم بتكلفة آخر طبقة.
+func fifoIssueValue(layers []costLayer, consumed, quantity int) float64 {
+	value := 0.0
+	for _, layer := range layers {
+		if quantity == 0 {
+			break
+		}
+		available := layer.Quantity
+		if consumed >= available {
+			consumed -= available
+			continue
+		}
+		available -= consumed
+		consumed = 0
+		take := available
+		if take > quantity {
+			take = quantity
+		}
+		value += float64(take) * layer.UnitCost
+		quantity -= take
+	}
+	if quantity > 0 && len(layers) > 0 {
+		value += float64(quantity) * layers[len(layers)-1].UnitCost
+	}
+	return value
+}
+
+// weightedAverage متوسط تكلفة الوحدة من رصيد الكمية والقيمة
+func weightedAverage(quantity int, value float64) (float64, bool) {
+	if quantity <= 0 {
+		return 0, false
+	}
+	return value / float64(quantity), true
+}
+
+// roundUnitCost تقريب تكلفة الوحدة لأربع خانات حتى لا يضيع فرق المتوسط
+func roundUnitCost(v float64) float64 {
+	return math.Round(v*10000) / 10000
+}
+
+// currentUnitCost تكلفة الوحدة الحالية لمنتج أو لدفعة منه من دفتر التكلفة
+// المتوسط المرجح يحسب على المنتج كله، وFIFO على الدفعة. إذا نفد الرصيد
+// تُستخدم تكلفة الدفعة أو آخر سعر شراء.
+func currentUnitCost(tx *gorm.DB, productID uuid.UUID, batchID *uuid.UUID) (float64, error) {
+	var totals struct {
+		Quantity int
+		Value    float64
+	}
+	query := tx.Model(&models.InventoryCostEntry{}).
+		Select("COALESCE(SUM(quantity), 0) AS quantity, COALESCE(SUM(value), 0) AS value").
+		Where("product_id = ?", productID)
+	if batchID != nil && CurrentCostingMethod() == CostingFIFO {
+		query = query.Where("batch_id = ?", *batchID)
+	}
+	if err := query.Scan(&totals).Error; err != nil {
+		return 0, err
+	}
+	if cost, ok := weightedAverage(totals.Quantity, totals.Value); ok {
+		return cost, nil
+	}
+
+	if batchID != nil {
+		var batch models.ProductBatch
+		err := tx.Select("id", "unit_cost").First(&batch, "id = ?", *batchID).Error
+		if err == nil {
+			return batch.UnitCost, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, err
+		}
+	}
+	return lastPurchaseCost(tx, productID)
+}
+
+// issueUnitCost تكلفة الوحدة لصرف كمية حسب طريقة التكلفة الحالية
+func issueUnitCost(tx *gorm.DB, productID uuid.UUID, batchID *uuid.UUID, quantity int) (float64, error) {
+	if CurrentCostingMethod() == CostingFIFO {
+		scope := tx.Model(&models.InventoryCostEntry{}).Where("product_id = ?", productID)
+		if batchID != nil {
+			scope = scope.Where("batch_id = ?", *batchID)
+		} else {
+			scope = scope.Where("batch_id IS NULL")
+		}
+		var layers []costLayer
+		if err := scope.Session(&gorm.Session{}).
+			Select("quantity, unit_cost").
+			Where("quantity > 0").
+			Order("occurred_at ASC, created_at ASC").
+			Scan(&layers).Error; err != nil {
+			return 0, err
+		}
+		var consumed int
+		if err := scope.Session(&gorm.Session{}).
+			Select("COALESCE(-SUM(quantity), 0)").
+			Where("quantity < 0").
+			Scan(&consumed).Error; err != nil {
+			return 0, err
+		}
+		if len(layers) > 0 {
+			return fifoIssueValue(layers, consumed, quantity) / float64(quantity), nil
+		}
+	}
+	return currentUnitCost(tx, productID, batchID)
+}
+
+// recordCostEntry تسجيل قيد تكلفة بقيمة الكمية × تكلفة الوحدة
+func recordCostEntry(tx *gorm.DB, entry *models.InventoryCostEntry) error {
+	entry.UnitCost = roundUnitCost(entry.UnitCost)
+	entry.Value = RoundMoney(float64(entry.Quantity) * entry.UnitCost)
+	return tx.Create(entry).Error
+}
+
+// recordCostIssue تسجيل صادر كمية موجبة بتكلفة الطريقة الحالية وإعادة قيمته
+func recordCostIssue(tx *gorm.DB, entry models.InventoryCostEntry, quantity int) (float64, error) {
+	unitCost, err := issueUnitCost(tx, entry.ProductID, entry.BatchID, quantity)
+	if err != nil {
+		return 0, err
+	}
+	entry.Quantity = -quantity
+	entry.UnitCost = unitCost
+	if err := recordCostEntry(tx, &entry); err != nil {
+		return 0, err
+	}
+	return -entry.Value, nil
+}
+
+// adoptUntrackedCost نقل قيود المنتج غير المتتبع إلى دفعته الافتتاحية وإعادة متوسط تكلفتها
+// إذا اختلف رصيد الدفتر عن عداد المنتج يُسجل الفرق كرصيد افتتاحي حتى يتطابقا.
+func adoptUntrackedCost(tx *gorm.DB, product *models.Product) (float64, error) {
+	var totals struct {
+		Quantity int
+		Value    float64
+	}
+	if err := tx.Model(&models.InventoryCostEntry{}).
+		Select("COALESCE(SUM(quantity), 0) AS quantity, COALESCE(SUM(value), 0) AS value").
+		Where("product_id = ? AND batch_id IS NULL", product.ID).
+		Scan(&totals).Error; err != nil {
+		return 0, err
+	}
+	cost, ok := weightedAverage(totals.Quantity, totals.Value)
+	if !ok {
+		var err error
+		if cost, err = lastPurchaseCost(tx, product.ID); err != nil {
+			return 0, err
+		}
+	}
+	if missing := product.StockQuantity - totals.Quantity; missing != 0 {
+		if err := recordCostEntry(tx, &models.InventoryCostEntry{
+			ProductID: product.ID,
+			Source:    models.CostSourceOpening,
+			Quantity:  missing,
+			UnitCost:  cost,
+		}); err != nil {
+			return 0, err
+		}
+	}
+	return roundUnitCost(cost), nil
+}
+
+// assignUntrackedCost ربط قيود المنتج غير المتتبع بالدفعة الافتتاحية التي أصبحت تمثل مخزونه
+func assignUntrackedCost(tx *gorm.DB, productID, batchID uuid.UUID) error {
+	return tx.Model(&models.InventoryCostEntry{}).
+		Where("product_id = ? AND batch_id IS NULL", productID).
+		Update("batch_id", batchID).Error
+}
+
+// splitCostedBatches نسبة كمية مشحونة إلى دفعات عنصر الطلب بترتيب صرفها
+// بعد تخطي ما كُلف سابقاً؛ تُعاد الكمية التي لا دفعة لها (منتج غير متتبع).
+func splitCostedBatches(records []models.OrderItemBatch, costed, quantity int) ([]BatchAllocation, int) {
+	var parts []BatchAllocation
+	for _, record := range records {
+		if quantity == 0 {
+			break
+		}
+		available := record.Quantity
+		if costed >= available {
+			costed -= available
+			continue
+		}
+		available -= costed
+		costed = 0
+		take := available
+		if take > quantity {
+			take = quantity
+		}
+		parts = append(parts, BatchAllocation{BatchID: record.BatchID, BatchNumber: record.BatchNumber, ExpiryDate: record.ExpiryDate, Quantity: take})
+		quantity -= take
+	}
+	return parts, quantity
+}
+
+// CostOrderItem تسجيل تكلفة البضاعة المباعة لكمية مشحونة من عنصر طلب
+// لا تُكلف الكمية أكثر من مرة؛ ما يتجاوز المتبقي من العنصر يُهمل.
+func CostOrderItem(tx *gorm.DB, item *models.OrderItem, quantity int, shipmentID *uuid.UUID, reference string, at time.Time) error {
+	if left := item.Quantity - item.CostedQuantity; quantity > left {
+		quantity = left
+	}
+	if quantity <= 0 {
+		return nil
+	}
+
+	var records []models.OrderItemBatch
+	if err := tx.Where("order_item_id = ?", item.ID).
+		Order("expiry_date ASC NULLS LAST, created_at ASC").
+		Find(&records).Error; err != nil {
+		return err
+	}
+	parts, unattributed := splitCostedBatches(records, item.CostedQuantity, quantity)
+
+	cost := 0.0
+	issue := func(batchID *uuid.UUID, qty int) error {
+		value, err := recordCostIssue(tx, models.InventoryCostEntry{
+			ProductID:       item.ProductID,
+			BatchID:         batchID,
+			Source:          models.CostSourceSale,
+			OrderItemID:     &item.ID,
+			ShipmentID:      shipmentID,
+			ReferenceNumber: reference,
+			OccurredAt:      at,
+		}, qty)
+		cost += value
+		return err
+	}
+	for i := range parts {
+		if err := issue(&parts[i].BatchID, parts[i].Quantity); err != nil {
+			return err
+		}
+	}
+	if unattributed > 0 {
+		if err := issue(nil, unattributed); err != nil {
+			return err
+		}
+	}
+
+	cost = RoundMoney(cost)
+	item.CostOfGoods = RoundMoney(item.CostOfGoods + cost)
+	item.CostedQuantity += quantity
+	return tx.Model(&models.OrderItem{}).Where("id = ?", item.ID).Updates(map[string]interface{}{
+		"cost_of_goods":   gorm.Expr("cost_of_goods + ?", cost),
+		"costed_quantity": gorm.Expr("costed_quantity + ?", quantity),
+	}).Error
+}
+
+// CostShipment تسجيل تكلفة عناصر شحنة عند مغادرتها المستودع
+func CostShipment(tx *gorm.DB, shipment *models.Shipment) error {
+	items := shipment.Items
+	if len(items) == 0 {
+		if err := tx.Where("shipment_id = ?", shipment.ID).Find(&items).Error; err != nil {
+			return err
+		}
+	}
+	at := time.Now()
+	if shipment.ShippedAt != nil {
+		at = *shipment.ShippedAt
+	}
+	for _, shipped := range items {
+		var item models.OrderItem
+		if err := tx.First(&item, "id = ?", shipped.OrderItemID).Error; err != nil {
+			return err
+		}
+		if err := CostOrderItem(tx, &item, shipped.Quantity, &shipment.ID, shipment.ShipmentNumber, at); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CostUncostedOrderItems تكليف ما تبقى من عناصر طلب شُحن أو سُلّم دون شحنات مسجلة
+func CostUncostedOrderItems(tx *gorm.DB, order *models.Order, at time.Time) error {
+	var items []models.OrderItem
+	if err := tx.Where("order_id = ? AND costed_quantity < quantity", order.ID).Find(&items).Error; err != nil {
+		return err
+	}
+	for i := range items {
+		item := &items[i]
+		if err := CostOrderItem(tx, item, item.Quantity-item.CostedQuantity, nil, order.OrderNumber, at); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordCustomerReturnCost إعادة قيمة مرتجع العميل إلى دفتر التكلفة بتكلفة بيعه
+// العنصر الذي لم تُعرف تكلفته (سابق لتفعيل التكلفة) يعود بالتكلفة الحالية.
+func recordCustomerReturnCost(tx *gorm.DB, orderItemID, productID uuid.UUID, restored []BatchAllocation, quantity int, reference string) error {
+	var item models.OrderItem
+	if err := tx.Select("id", "cost_of_goods", "costed_quantity").First(&item, "id = ?", orderItemID).Error; err != nil {
+		return err
+	}
+	unitCost, known := 0.0, false
+	if item.CostOfGoods > 0 {
+		unitCost, known = weightedAverage(item.CostedQuantity, item.CostOfGoods)
+	}
+
+	record := func(batchID *uuid.UUID, qty int) error {
+		cost := unitCost
+		if !known {
+			var err error
+			if cost, err = currentUnitCost(tx, productID, batchID); err != nil {
+				return err
+			}
+		}
+		return recordCostEntry(tx, &models.InventoryCostEntry{
+			ProductID:       productID,
+			BatchID:         batchID,
+			Source:          models.CostSourceCustomerReturn,
+			Quantity:        qty,
+			UnitCost:        cost,
+			OrderItemID:     &item.ID,
+			ReferenceNumber: reference,
+		})
+	}
+	for i := range restored {
+		if err := record(&restored[i].BatchID, restored[i].Quantity); err != nil {
+			return err
+		}
+		quantity -= restored[i].Quantity
+	}
+	if quantity > 0 {
+		return record(nil, quantity)
+	}
+	return nil
+}
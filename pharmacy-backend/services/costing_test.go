@@ -0,0 +1,80 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"pharmacy-backend/models"
+)
+
+func TestFIFOIssueValue(t *testing.T) {
+	layers := []costLayer{{Quantity: 10, UnitCost: 2}, {Quantity: 5, UnitCost: 3}, {Quantity: 5, UnitCost: 4}}
+
+	// بعد صرف 8 سابقاً: 2 من الطبقة الأولى ثم 5 من الثانية ثم 1 من الثالثة
+	assert.InDelta(t, 2*2+5*3+1*4, fifoIssueValue(layers, 8, 8), 1e-9)
+	assert.InDelta(t, 3*2, fifoIssueValue(layers, 0, 3), 1e-9)
+	// ما يتجاوز الطبقات يُقيم بآخر تكلفة
+	assert.InDelta(t, 5*4+2*4, fifoIssueValue(layers, 15, 7), 1e-9)
+	assert.Equal(t, 0.0, fifoIssueValue(nil, 0, 4))
+}
+
+func TestWeightedAverage(t *testing.T) {
+	cost, ok := weightedAverage(20, 55)
+	assert.True(t, ok)
+	assert.Equal(t, 2.75, cost)
+
+	_, ok = weightedAverage(0, 10)
+	assert.False(t, ok)
+	assert.Equal(t, 0.3333, roundUnitCost(1.0/3))
+}
+
+func TestCostingMethodFromEnv(t *testing.T) {
+	t.Setenv("COSTING_METHOD", "fifo")
+	assert.Equal(t, CostingFIFO, CurrentCostingMethod())
+	t.Setenv("COSTING_METHOD", "lifo")
+	assert.Equal(t, CostingWeightedAverage, CurrentCostingMethod())
+}
+
+func TestSplitCostedBatches(t *testing.T) {
+	first, second := uuid.New(), uuid.New()
+	records := []models.OrderItemBatch{
+		{BatchID: first, BatchNumber: "A", Quantity: 3},
+		{BatchID: second, BatchNumber: "B", Quantity: 4},
+	}
+
+	parts, rest := splitCostedBatches(records, 0, 5)
+	assert.Equal(t, 0, rest)
+	if assert.Len(t, parts, 2) {
+		assert.Equal(t, first, parts[0].BatchID)
+		assert.Equal(t, 3, parts[0].Quantity)
+		assert.Equal(t, 2, parts[1].Quantity)
+	}
+
+	// الشحنة الثانية تبدأ بعد ما كُلف، والزيادة بلا دفعة تُعاد منفصلة
+	parts, rest = splitCostedBatches(records, 5, 4)
+	assert.Equal(t, 2, rest)
+	if assert.Len(t, parts, 1) {
+		assert.Equal(t, second, parts[0].BatchID)
+		assert.Equal(t, 2, parts[0].Quantity)
+	}
+}
+
+func TestFinalizeMarginRows(t *testing.T) {
+	rows := []MarginRow{
+		{Name: "A", Units: 10, Revenue: 100.004, COGS: 60},
+		{Name: "B", Units: 2, Revenue: 50, COGS: 55},
+	}
+
+	totals := finalizeMarginRows(rows)
+	assert.Equal(t, 40.0, rows[0].GrossMargin)
+	assert.Equal(t, 40.0, rows[0].MarginPercent)
+	assert.Equal(t, -5.0, rows[1].GrossMargin)
+	assert.Equal(t, -10.0, rows[1].MarginPercent)
+	assert.Equal(t, 12, totals.Units)
+	assert.Equal(t, 150.0, totals.Revenue)
+	assert.Equal(t, 35.0, totals.GrossMargin)
+	assert.Equal(t, 23.33, totals.MarginPercent)
+	assert.Equal(t, 0.0, MarginPercent(0, 10))
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"pharmacy-backend/models"
+)
+
+// أخطاء الكوبونات التي تُعاد للعميل كطلب غير صالح
+var (
+	ErrCouponNotFound  = errors.New("coupon not found")
+	ErrCouponInvalid   = errors.New("coupon is not valid")
+	ErrCouponMinAmount = errors.New("order amount is below the coupon minimum")
+	ErrCouponUserLimit = errors.New("coupon usage limit per user reached")
+	ErrCouponExhausted = errors.New("coupon usage limit reached")
+)
+
+// IsCouponError التحقق مما إذا كان الخطأ ناتجاً عن كوبون غير قابل للتطبيق
+func IsCouponError(err error) bool {
+	return errors.Is(err, ErrCouponNotFound) ||
+		errors.Is(err, ErrCouponInvalid) ||
+		errors.Is(err, ErrCouponMinAmount) ||
+		errors.Is(err, ErrCouponUserLimit) ||
+		errors.Is(err, ErrCouponExhausted)
+}
+
+// FindCouponByCode البحث عن كوبون بالكود
+func FindCouponByCode(db *gorm.DB, code string) (*models.Coupon, error) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return nil, ErrCouponNotFound
+	}
+
+	var coupon models.Coupon
+	if err := db.Where("code = ?", code).First(&coupon).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCouponNotFound
+		}
+		return nil, err
+	}
+	return &coupon, nil
+}
+
+// CountUserRedemptions عدد مرات استخدام المستخدم للكوبون في طلبات غير ملغاة
+func CountUserRedemptions(db *gorm.DB, couponID, userID uuid.UUID) (int64, error) {
+	var count int64
+	err := db.Model(&models.CouponRedemption{}).
+		Where("coupon_id = ? AND user_id = ? AND status = ?", couponID, userID, models.CouponRedemptionActive).
+		Count(&count).Error
+	return count, err
+}
+
+// CheckCouponMinimum التحقق من أن مجموع الطلب يبلغ الحد الأدنى للكوبون
+// يُستخدم عند الدفع وعند إعادة تسعير الطلب بعد تعديله.
+func CheckCouponMinimum(coupon *models.Coupon, subtotal float64) error {
+	if subtotal < coupon.MinOrderAmount {
+		return fmt.Errorf("%s (minimum %.2f): %w", coupon.Code, coupon.MinOrderAmount, ErrCouponMinAmount)
+	}
+	return nil
+}
+
+// ApplyCoupon التحقق من الكوبون وتطبيق خصمه على تسعير الطلب
+// لا يغير هذا الاستدعاء عداد الاستخدام؛ يتم ذلك في RedeemCoupon داخل معاملة الطلب.
+func ApplyCoupon(db *gorm.DB, quote *OrderQuote, userID uuid.UUID, code string) (*models.Coupon, error) {
+	coupon, err := FindCouponByCode(db, code)
+	if err != nil {
+		return nil, err
+	}
+
+	if !coupon.IsValid() {
+		return nil, fmt.Errorf("%s: %w", coupon.Code, ErrCouponInvalid)
+	}
+	if err := CheckCouponMinimum(coupon, quote.Subtotal); err != nil {
+		return nil, err
+	}
+
+	if coupon.PerUserLimit != nil && userID != uuid.Nil {
+		used, err := CountUserRedemptions(db, coupon.ID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if used >= int64(*coupon.PerUserLimit) {
+			return nil, fmt.Errorf("%s: %w", coupon.Code, ErrCouponUserLimit)
+		}
+	}
+
+	quote.DiscountAmount = coupon.CalculateDiscount(quote.Subtotal)
+	quote.Recalculate()
+	return coupon, nil
+}
+
+// RedeemCoupon تسجيل استخدام الكوبون للطلب وزيادة عداده بشكل ذري
+// يجب استدعاؤها داخل معاملة الطلب حتى يُلغى الاستخدام إذا فشل الطلب.
+func RedeemCoupon(tx *gorm.DB, coupon *models.Coupon, userID, orderID uuid.UUID, discount float64) error {
+	// الزيادة المشروطة تقفل صف الكوبون حتى نهاية المعاملة فتتسلسل عمليات الاستخدام المتزامنة
+	result := tx.Model(&models.Coupon{}).
+		Where("id = ? AND is_active = ? AND (usage_limit IS NULL OR used_count < usage_limit)", coupon.ID, true).
+		Update("used_count", gorm.Expr("used_count + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%s: %w", coupon.Code, ErrCouponExhausted)
+	}
+
+	// إعادة فحص حد المستخدم بعد قفل صف الكوبون
+	if coupon.PerUserLimit != nil {
+		used, err := CountUserRedemptions(tx, coupon.ID, userID)
+		if err != nil {
+			return err
+		}
+		if used >= int64(*coupon.PerUserLimit) {
+			return fmt.Errorf("%s: %w", coupon.Code, ErrCouponUserLimit)
+		}
+	}
+
+	redemption := models.CouponRedemption{
+		CouponID:       coupon.ID,
+		UserID:         userID,
+		OrderID:        orderID,
+		DiscountAmount: discount,
+		Status:         models.CouponRedemptionActive,
+	}
+	return tx.Create(&redemption).Error
+}
+
+// ReleaseCouponRedemption إعادة استخدام الكوبون عند إلغاء الطلب
+// لا تفعل شيئاً إذا لم يستخدم الطلب كوبوناً أو أُعيد مسبقاً.
+func ReleaseCouponRedemption(tx *gorm.DB, orderID uuid.UUID) error {
+	var redemption models.CouponRedemption
+	err := tx.Where("order_id = ? AND status = ?", orderID, models.CouponRedemptionActive).First(&redemption).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	now := time.Now()
+	if err := tx.Model(&redemption).Updates(map[string]interface{}{
+		"status":      models.CouponRedemptionReleased,
+		"released_at": now,
+	}).Error; err != nil {
+		return err
+	}
+
+	return tx.Model(&models.Coupon{}).
+		Where("id = ?", redemption.CouponID).
+		Update("used_count", gorm.Expr("GREATEST(used_count - 1, 0)")).Error
+}
@@ -0,0 +1,384 @@
+package services
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"pharmacy-backend/models"
+)
+
+// ErrInvalidInteraction سجل تداخل غير صالح في ملف الاستيراد
+var ErrInvalidInteraction = errors.New("invalid drug interaction record")
+
+// InteractionWarningType نوع تحذير الفحص الدوائي
+type InteractionWarningType string
+
+const (
+	InteractionWarningInteraction      InteractionWarningType = "interaction"
+	InteractionWarningDuplicateTherapy InteractionWarningType = "duplicate_therapy"
+)
+
+// مصدر الدواء في الفحص: الطلب أو السلة الحالية، أو طلب سابق للعميل
+const (
+	MedicationSourceCurrent = "current"
+	MedicationSourceHistory = "order_history"
+)
+
+// MedicationRef دواء داخل الفحص مع مواده الفعالة ومصدره
+type MedicationRef struct {
+	ProductID   uuid.UUID  `json:"product_id"`
+	Name        string     `json:"name"`
+	Ingredients []string   `json:"-"`
+	Source      string     `json:"source"`
+	OrderID     *uuid.UUID `json:"order_id,omitempty"`
+	OrderNumber string     `json:"order_number,omitempty"`
+	OrderedAt   *time.Time `json:"ordered_at,omitempty"`
+}
+
+// InteractionWarning تحذير تداخل أو تكرار علاج بين دواءين
+type InteractionWarning struct {
+	Type           InteractionWarningType     `json:"type"`
+	Severity       models.InteractionSeverity `json:"severity"`
+	IngredientA    string                     `json:"ingredient_a"`
+	IngredientB    string                     `json:"ingredient_b"`
+	Medications    []MedicationRef            `json:"medications"`
+	Description    string                     `json:"description"`
+	Recommendation string                     `json:"recommendation,omitempty"`
+}
+
+// InteractionHistoryDays عدد أيام الطلبات السابقة التي تدخل في الفحص (0 يعطلها)
+func InteractionHistoryDays() int {
+	return int(envFloat("DRUG_INTERACTION_HISTORY_DAYS", 90))
+}
+
+// interactionKey مفتاح زوج المواد بترتيب ثابت كما يُخزن في الجدول
+func interactionKey(a, b string) [2]string {
+	if a > b {
+		a, b = b, a
+	}
+	return [2]string{a, b}
+}
+
+// medicationFromProduct بناء مرجع الدواء من المنتج؛ المنتج بلا مادة فعالة لا يدخل الفحص
+func medicationFromProduct(product *models.Product, source string) (MedicationRef, bool) {
+	ingredients := product.Ingredients()
+	if len(ingredients) == 0 {
+		return MedicationRef{}, false
+	}
+	return MedicationRef{ProductID: product.ID, Name: product.Name, Ingredients: ingredients, Source: source}, true
+}
+
+// findInteractionWarnings مقارنة الأدوية الحالية ببعضها وبأدوية الطلبات السابقة
+// تكرار نفس المنتج بين السلة وطلب سابق إعادة صرف وليس تكرار علاج.
+func findInteractionWarnings(current, history []MedicationRef, known map[[2]string]models.DrugInteraction) []InteractionWarning {
+	warnings := []InteractionWarning{}
+	seen := map[string]bool{}
+
+	check := func(a, b MedicationRef) {
+		if a.ProductID == b.ProductID {
+			return
+		}
+		for _, ia := range a.Ingredients {
+			for _, ib := range b.Ingredients {
+				var warning InteractionWarning
+				if ia == ib {
+					warning = InteractionWarning{
+						Type:           InteractionWarningDuplicateTherapy,
+						Severity:       models.InteractionSeverityModerate,
+						IngredientA:    ia,
+						IngredientB:    ib,
+						Description:    fmt.Sprintf("المادة الفعالة %s موجودة في أكثر من منتج", ia),
+						Recommendation: "تأكد من عدم تجاوز الجرعة اليومية القصوى",
+					}
+				} else if interaction, ok := known[interactionKey(ia, ib)]; ok {
+					warning = InteractionWarning{
+						Type:           InteractionWarningInteraction,
+						Severity:       interaction.Severity,
+						IngredientA:    interaction.IngredientA,
+						IngredientB:    interaction.IngredientB,
+						Description:    interaction.Description,
+						Recommendation: interaction.Recommendation,
+					}
+				} else {
+					continue
+				}
+
+				// تحذير واحد لكل زوج منتجات وزوج مواد
+				pa, pb := a.ProductID.String(), b.ProductID.String()
+				if pa > pb {
+					pa, pb = pb, pa
+				}
+				key := strings.Join([]string{string(warning.Type), warning.IngredientA, warning.IngredientB, pa, pb}, "|")
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				warning.Medications = []MedicationRef{a, b}
+				warnings = append(warnings, warning)
+			}
+		}
+	}
+
+	for i := range current {
+		for j := i + 1; j < len(current); j++ {
+			check(current[i], current[j])
+		}
+		for _, past := range history {
+			check(current[i], past)
+		}
+	}
+
+	sort.SliceStable(warnings, func(i, j int) bool {
+		return warnings[i].Severity.Rank() > warnings[j].Severity.Rank()
+	})
+	return warnings
+}
+
+// CheckInteractions فحص التداخلات وتكرار العلاج مقابل قاعدة بيانات التداخلات
+func CheckInteractions(db *gorm.DB, current, history []MedicationRef) ([]InteractionWarning, error) {
+	ingredientSet := map[string]bool{}
+	for _, group := range [][]MedicationRef{current, history} {
+		for _, med := range group {
+			for _, ingredient := range med.Ingredients {
+				ingredientSet[ingredient] = true
+			}
+		}
+	}
+	known := map[[2]string]models.DrugInteraction{}
+	if len(ingredientSet) > 1 {
+		ingredients := make([]string, 0, len(ingredientSet))
+		for ingredient := range ingredientSet {
+			ingredients = append(ingredients, ingredient)
+		}
+		var rows []models.DrugInteraction
+		if err := db.Where("ingredient_a IN ? AND ingredient_b IN ?", ingredients, ingredients).Find(&rows).Error; err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			known[interactionKey(row.IngredientA, row.IngredientB)] = row
+		}
+	}
+	return findInteractionWarnings(current, history, known), nil
+}
+
+// RecentMedications أدوية العميل في طلباته غير الملغاة منذ since
+func RecentMedications(db *gorm.DB, userID uuid.UUID, since time.Time, excludeOrderID *uuid.UUID) ([]MedicationRef, error) {
+	query := db.Where("user_id = ? AND created_at >= ? AND status <> ?", userID, since, models.OrderStatusCancelled)
+	if excludeOrderID != nil {
+		query = query.Where("id <> ?", *excludeOrderID)
+	}
+	var orders []models.Order
+	if err := query.Preload("OrderItems.Product").Order("created_at DESC").Find(&orders).Error; err != nil {
+		return nil, err
+	}
+
+	meds := []MedicationRef{}
+	seen := map[uuid.UUID]bool{}
+	for _, order := range orders {
+		for _, item := range order.OrderItems {
+			// أحدث طلب للمنتج يكفي
+			if seen[item.ProductID] {
+				continue
+			}
+			med, ok := medicationFromProduct(&item.Product, MedicationSourceHistory)
+			if !ok {
+				continue
+			}
+			seen[item.ProductID] = true
+			orderID, orderedAt := order.ID, order.CreatedAt
+			med.OrderID, med.OrderNumber, med.OrderedAt = &orderID, order.OrderNumber, &orderedAt
+			meds = append(meds, med)
+		}
+	}
+	return meds, nil
+}
+
+// CheckQuoteInteractions فحص عناصر سلة أو طلب مسعّر، مع الطلبات السابقة عند includeHistory
+func CheckQuoteInteractions(db *gorm.DB, userID uuid.UUID, lines []QuotedLine, includeHistory bool) ([]InteractionWarning, error) {
+	current := []MedicationRef{}
+	for i := range lines {
+		if med, ok := medicationFromProduct(&lines[i].Product, MedicationSourceCurrent); ok {
+			current = append(current, med)
+		}
+	}
+	var history []MedicationRef
+	if days := InteractionHistoryDays(); includeHistory && days > 0 && len(current) > 0 {
+		var err error
+		if history, err = RecentMedications(db, userID, time.Now().AddDate(0, 0, -days), nil); err != nil {
+			return nil, err
+		}
+	}
+	return CheckInteractions(db, current, history)
+}
+
+// CheckOrderInteractions فحص عناصر طلب مقابل بعضها وطلبات العميل السابقة (شاشة الصيدلي)
+func CheckOrderInteractions(db *gorm.DB, order *models.Order) ([]InteractionWarning, error) {
+	var items []models.OrderItem
+	if err := db.Preload("Product").Where("order_id = ?", order.ID).Find(&items).Error; err != nil {
+		return nil, err
+	}
+	current := []MedicationRef{}
+	for i := range items {
+		if med, ok := medicationFromProduct(&items[i].Product, MedicationSourceCurrent); ok {
+			current = append(current, med)
+		}
+	}
+	var history []MedicationRef
+	if days := InteractionHistoryDays(); days > 0 && len(current) > 0 {
+		var err error
+		since := order.CreatedAt.AddDate(0, 0, -days)
+		if history, err = RecentMedications(db, order.UserID, since, &order.ID); err != nil {
+			return nil, err
+		}
+	}
+	return CheckInteractions(db, current, history)
+}
+
+// InteractionRecord سجل تداخل في ملف الاستيراد (CSV أو JSON)
+type InteractionRecord struct {
+	IngredientA    string `json:"ingredient_a"`
+	IngredientB    string `json:"ingredient_b"`
+	Severity       string `json:"severity"`
+	Description    string `json:"description"`
+	Recommendation string `json:"recommendation"`
+	Source         string `json:"source"`
+}
+
+// toModel تطبيع السجل والتحقق منه
+func (r InteractionRecord) toModel() (models.DrugInteraction, error) {
+	a, b := models.NormalizeIngredient(r.IngredientA), models.NormalizeIngredient(r.IngredientB)
+	severity := models.InteractionSeverity(strings.ToLower(strings.TrimSpace(r.Severity)))
+	switch {
+	case a == "" || b == "":
+		return models.DrugInteraction{}, fmt.Errorf("%w: both ingredients are required", ErrInvalidInteraction)
+	case a == b:
+		return models.DrugInteraction{}, fmt.Errorf("%w: %s interacts with itself", ErrInvalidInteraction, a)
+	case !severity.IsValid():
+		return models.DrugInteraction{}, fmt.Errorf("%w: unknown severity %q", ErrInvalidInteraction, r.Severity)
+	}
+	key := interactionKey(a, b)
+	return models.DrugInteraction{
+		IngredientA:    key[0],
+		IngredientB:    key[1],
+		Severity:       severity,
+		Description:    strings.TrimSpace(r.Description),
+		Recommendation: strings.TrimSpace(r.Recommendation),
+		Source:         strings.TrimSpace(r.Source),
+	}, nil
+}
+
+// ParseInteractionsCSV قراءة ملف CSV بعناوين أعمدة: ingredient_a, ingredient_b, severity, description, recommendation, source
+func ParseInteractionsCSV(r io.Reader) ([]InteractionRecord, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read csv header: %w", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	for _, required := range []string{"ingredient_a", "ingredient_b", "severity"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("%w: missing csv column %s", ErrInvalidInteraction, required)
+		}
+	}
+	field := func(row []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+
+	var records []InteractionRecord
+	for line := 2; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("csv line %d: %w", line, err)
+		}
+		records = append(records, InteractionRecord{
+			IngredientA:    field(row, "ingredient_a"),
+			IngredientB:    field(row, "ingredient_b"),
+			Severity:       field(row, "severity"),
+			Description:    field(row, "description"),
+			Recommendation: field(row, "recommendation"),
+			Source:         field(row, "source"),
+		})
+	}
+	return records, nil
+}
+
+// ParseInteractionsJSON قراءة مصفوفة JSON من سجلات التداخل
+func ParseInteractionsJSON(r io.Reader) ([]InteractionRecord, error) {
+	var records []InteractionRecord
+	if err := json.NewDecoder(r).Decode(&records); err != nil {
+		return nil, fmt.Errorf("decode json: %w", err)
+	}
+	return records, nil
+}
+
+// InteractionImportResult ملخص الاستيراد
+type InteractionImportResult struct {
+	Imported int `json:"imported"`
+	Removed  int `json:"removed"`
+}
+
+// ImportInteractions حفظ السجلات في قاعدة بيانات التداخلات (تحديث الزوج إن وُجد)
+// replace يحذف البيانات الحالية أولاً؛ كل الاستيراد في معاملة واحدة فالسجل الخاطئ يلغيه كاملاً.
+func ImportInteractions(db *gorm.DB, records []InteractionRecord, replace bool) (*InteractionImportResult, error) {
+	rows := make([]models.DrugInteraction, 0, len(records))
+	index := map[[2]string]int{}
+	for i, record := range records {
+		row, err := record.toModel()
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %w", i+1, err)
+		}
+		// السجل الأخير للزوج نفسه داخل الملف هو المعتمد
+		key := interactionKey(row.IngredientA, row.IngredientB)
+		if existing, ok := index[key]; ok {
+			rows[existing] = row
+			continue
+		}
+		index[key] = len(rows)
+		rows = append(rows, row)
+	}
+
+	result := &InteractionImportResult{}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if replace {
+			deleted := tx.Where("1 = 1").Delete(&models.DrugInteraction{})
+			if deleted.Error != nil {
+				return deleted.Error
+			}
+			result.Removed = int(deleted.RowsAffected)
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "ingredient_a"}, {Name: "ingredient_b"}},
+			DoUpdates: clause.AssignmentColumns([]string{"severity", "description", "recommendation", "source", "updated_at"}),
+		}).CreateInBatches(&rows, 500).Error; err != nil {
+			return err
+		}
+		result.Imported = len(rows)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"pharmacy-backend/models"
+)
+
+func TestSplitActiveIngredients(t *testing.T) {
+	assert.Equal(t, []string{"paracetamol", "codeine phosphate"}, models.SplitActiveIngredients(" Paracetamol +  Codeine  Phosphate"))
+	assert.Equal(t, []string{"amlodipine", "valsartan"}, models.SplitActiveIngredients("Amlodipine/Valsartan, amlodipine"))
+	assert.Empty(t, models.SplitActiveIngredients("  "))
+}
+
+func TestFindInteractionWarnings(t *testing.T) {
+	warfarin := MedicationRef{ProductID: uuid.New(), Name: "Warfarin 5mg", Ingredients: []string{"warfarin"}, Source: MedicationSourceCurrent}
+	panadol := MedicationRef{ProductID: uuid.New(), Name: "Panadol", Ingredients: []string{"paracetamol"}, Source: MedicationSourceCurrent}
+	coldFlu := MedicationRef{ProductID: uuid.New(), Name: "Cold & Flu", Ingredients: []string{"paracetamol", "pseudoephedrine"}, Source: MedicationSourceCurrent}
+	pastAspirin := MedicationRef{ProductID: uuid.New(), Name: "Aspirin 81", Ingredients: []string{"aspirin"}, Source: MedicationSourceHistory}
+
+	known := map[[2]string]models.DrugInteraction{
+		interactionKey("warfarin", "aspirin"):     {IngredientA: "aspirin", IngredientB: "warfarin", Severity: models.InteractionSeverityMajor},
+		interactionKey("paracetamol", "warfarin"): {IngredientA: "paracetamol", IngredientB: "warfarin", Severity: models.InteractionSeverityMinor},
+	}
+
+	warnings := findInteractionWarnings([]MedicationRef{warfarin, panadol, coldFlu}, []MedicationRef{pastAspirin}, known)
+	require.Len(t, warnings, 4)
+	// الأخطر أولاً
+	assert.Equal(t, models.InteractionSeverityMajor, warnings[0].Severity)
+	assert.Equal(t, MedicationSourceHistory, warnings[0].Medications[1].Source)
+
+	duplicates := 0
+	for _, w := range warnings {
+		if w.Type == InteractionWarningDuplicateTherapy {
+			duplicates++
+			assert.Equal(t, "paracetamol", w.IngredientA)
+		}
+	}
+	assert.Equal(t, 1, duplicates)
+
+	// نفس المنتج في السلة وطلب سابق إعادة صرف وليس تكراراً
+	refill := panadol
+	refill.Source = MedicationSourceHistory
+	assert.Empty(t, findInteractionWarnings([]MedicationRef{panadol}, []MedicationRef{refill}, nil))
+}
+
+func TestParseInteractionsCSV(t *testing.T) {
+	records, err := ParseInteractionsCSV(strings.NewReader("ingredient_a,ingredient_b,severity,description\nWarfarin,Aspirin,MAJOR,Bleeding risk\n"))
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+
+	row, err := records[0].toModel()
+	require.NoError(t, err)
+	assert.Equal(t, "aspirin", row.IngredientA)
+	assert.Equal(t, "warfarin", row.IngredientB)
+	assert.Equal(t, models.InteractionSeverityMajor, row.Severity)
+
+	_, err = InteractionRecord{IngredientA: "a", IngredientB: "b", Severity: "severe"}.toModel()
+	assert.ErrorIs(t, err, ErrInvalidInteraction)
+	_, err = ParseInteractionsCSV(strings.NewReader("drug1,drug2\nx,y\n"))
+	assert.ErrorIs(t, err, ErrInvalidInteraction)
+}
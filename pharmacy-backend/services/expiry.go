@@ -0,0 +1,494 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"pharmacy-backend/models"
+)
+
+// ErrInvalidExpirySettings عتبات تنبيه أو قواعد تخفيض غير صالحة
+var ErrInvalidExpirySettings = errors.New("expiry settings must be comma separated days (alerts) or days:percent pairs (markdowns)")
+
+// ExpiryMarkdownRule نسبة تخفيض تُطبق عندما يبقى على انتهاء الدفعة التالية في الصرف هذا العدد من الأيام أو أقل
+type ExpiryMarkdownRule struct {
+	Days    int     `json:"days"`
+	Percent float64 `json:"percent"`
+}
+
+// ExpirySettings إعدادات مجدول الصلاحية
+// تُقرأ من نفس متغيرات البيئة التي تعرضها إعدادات المتجر في لوحة التحكم.
+type ExpirySettings struct {
+	AlertDays       []int // عتبات تنبيه الإدارة بالأيام، تصاعدياً
+	AutoUnpublish   bool  // تعطيل المنتج الذي لم يبقَ من مخزونه إلا منتهٍ
+	MarkdownEnabled bool
+	Markdowns       []ExpiryMarkdownRule // تصاعدياً بالأيام
+}
+
+// LoadExpirySettings قراءة إعدادات الصلاحية الحالية؛ القيم غير الصالحة تعود للافتراضي
+func LoadExpirySettings() ExpirySettings {
+	alertDays, err := ParseExpiryAlertDays(envString("EXPIRY_ALERT_DAYS", "90,60,30"))
+	if err != nil {
+		alertDays = []int{30, 60, 90}
+	}
+	markdowns, err := ParseExpiryMarkdownRules(envString("EXPIRY_MARKDOWN_RULES", "60:10,30:25"))
+	if err != nil {
+		markdowns = []ExpiryMarkdownRule{{Days: 30, Percent: 25}, {Days: 60, Percent: 10}}
+	}
+	return ExpirySettings{
+		AlertDays:       alertDays,
+		AutoUnpublish:   envBool("EXPIRY_AUTO_UNPUBLISH", true),
+		MarkdownEnabled: envBool("EXPIRY_MARKDOWN_ENABLED", false),
+		Markdowns:       markdowns,
+	}
+}
+
+// ParseExpiryAlertDays قراءة عتبات التنبيه مثل "90,60,30" مرتبة تصاعدياً بلا تكرار
+func ParseExpiryAlertDays(value string) ([]int, error) {
+	seen := map[int]bool{}
+	var days []int
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		d, err := strconv.Atoi(part)
+		if err != nil || d < 1 || d > 730 {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidExpirySettings, part)
+		}
+		if !seen[d] {
+			seen[d] = true
+			days = append(days, d)
+		}
+	}
+	sort.Ints(days)
+	return days, nil
+}
+
+// ParseExpiryMarkdownRules قراءة قواعد التخفيض مثل "60:10,30:25" مرتبة تصاعدياً بالأيام
+// النسبة يجب أن تزيد كلما اقترب الانتهاء.
+func ParseExpiryMarkdownRules(value string) ([]ExpiryMarkdownRule, error) {
+	var rules []ExpiryMarkdownRule
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		pair := strings.SplitN(part, ":", 2)
+		if len(pair) != 2 {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidExpirySettings, part)
+		}
+		days, err := strconv.Atoi(strings.TrimSpace(pair[0]))
+		if err != nil || days < 1 || days > 730 {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidExpirySettings, part)
+		}
+		percent, err := strconv.ParseFloat(strings.TrimSpace(pair[1]), 64)
+		if err != nil || percent <= 0 || percent >= 100 {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidExpirySettings, part)
+		}
+		rules = append(rules, ExpiryMarkdownRule{Days: days, Percent: percent})
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Days < rules[j].Days })
+	for i := 1; i < len(rules); i++ {
+		if rules[i].Days == rules[i-1].Days || rules[i].Percent >= rules[i-1].Percent {
+			return nil, fmt.Errorf("%w: markdown must grow as expiry gets closer", ErrInvalidExpirySettings)
+		}
+	}
+	return rules, nil
+}
+
+// alertThreshold أصغر عتبة بلغتها الدفعة، أو صفر إذا لم تبلغ أياً منها
+// الدفعة التي تظهر لأول مرة قريبة جداً تُنبه بعتبتها الحالية فقط.
+func alertThreshold(thresholds []int, daysLeft int) int {
+	for _, days := range thresholds {
+		if daysLeft <= days {
+			return days
+		}
+	}
+	return 0
+}
+
+// markdownPercent نسبة التخفيض للأيام المتبقية؛ صفر للمنتهي أو البعيد
+func markdownPercent(rules []ExpiryMarkdownRule, daysLeft int) float64 {
+	if daysLeft < 0 {
+		return 0
+	}
+	for _, rule := range rules {
+		if daysLeft <= rule.Days {
+			return rule.Percent
+		}
+	}
+	return 0
+}
+
+// markdownState أسعار الخصم للمنتج قبل أو بعد تطبيق التخفيض
+type markdownState struct {
+	DiscountPrice          *float64
+	ExpiryMarkdownPercent  *float64
+	DiscountBeforeMarkdown *float64
+}
+
+// planMarkdown حالة الخصم الجديدة للمنتج بنسبة التخفيض المستحقة
+// الخصم اليدوي الأفضل من التخفيض يبقى كما هو، وإذا غيّر المسؤول سعر الخصم أثناء التخفيض
+// يُعتبر سعره يدوياً. يُعاد false إذا لم يتغير شيء.
+func planMarkdown(price float64, current markdownState, percent float64) (markdownState, bool) {
+	base := current.DiscountPrice
+	if current.ExpiryMarkdownPercent != nil {
+		applied := RoundMoney(price * (1 - *current.ExpiryMarkdownPercent/100))
+		if current.DiscountPrice != nil && *current.DiscountPrice == applied {
+			if percent == *current.ExpiryMarkdownPercent {
+				return current, false
+			}
+			base = current.DiscountBeforeMarkdown
+		}
+	}
+
+	next := markdownState{DiscountPrice: base}
+	if percent > 0 {
+		marked := RoundMoney(price * (1 - percent/100))
+		if base == nil || *base <= 0 || *base > marked {
+			p := percent
+			next = markdownState{DiscountPrice: &marked, ExpiryMarkdownPercent: &p, DiscountBeforeMarkdown: base}
+		}
+	}
+	changed := !sameMoney(next.DiscountPrice, current.DiscountPrice) ||
+		!sameMoney(next.ExpiryMarkdownPercent, current.ExpiryMarkdownPercent) ||
+		!sameMoney(next.DiscountBeforeMarkdown, current.DiscountBeforeMarkdown)
+	return next, changed
+}
+
+// sameMoney مقارنة قيمتين اختياريتين
+func sameMoney(a, b *float64) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+// ExpiryProductRef منتج أثر فيه مجدول الصلاحية
+type ExpiryProductRef struct {
+	ID   uuid.UUID `json:"id"`
+	SKU  string    `json:"sku"`
+	Name string    `json:"name"`
+}
+
+// UnpublishExpiredProducts إيقاف المنتجات التي لم يبقَ من مخزونها إلا دفعات منتهية
+// أو التي انتهت صلاحيتها ولا تُتتبع بالدفعات، بتعطيلها حتى يرفضها الكتالوج والطلب معاً.
+// حالة النشر لا تتغير، ويعيد المسؤول تفعيل المنتج بعد استلام مخزون صالح.
+func UnpublishExpiredProducts(db *gorm.DB, now time.Time) ([]ExpiryProductRef, error) {
+	products := []ExpiryProductRef{}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Product{}).
+			Select("id", "sku", "name").
+			Where("is_active = ?", true).
+			Where(`((stock_quantity = 0 AND EXISTS (SELECT 1 FROM product_batches b
+					WHERE b.product_id = products.id AND b.quantity > 0 AND b.expiry_date <= ?))
+				OR (expiry_date <= ? AND NOT EXISTS (SELECT 1 FROM product_batches b WHERE b.product_id = products.id)))`, now, now).
+			Scan(&products).Error; err != nil {
+			return err
+		}
+		if len(products) == 0 {
+			return nil
+		}
+		ids := make([]uuid.UUID, 0, len(products))
+		for _, product := range products {
+			ids = append(ids, product.ID)
+		}
+		return tx.Model(&models.Product{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+			"is_active":  false,
+			"updated_at": now,
+		}).Error
+	})
+	return products, err
+}
+
+// SendExpiryAlerts تنبيه الإدارة بالدفعات التي بلغت عتبة جديدة من عتبات قرب الانتهاء
+// إشعار واحد لكل عتبة يضم دفعاتها، ويُعاد عدد الدفعات المنبه عنها.
+func SendExpiryAlerts(db *gorm.DB, now time.Time, thresholds []int) (int, error) {
+	if len(thresholds) == 0 {
+		return 0, nil
+	}
+	rows, err := LocationExpiry(db, nil, thresholds[len(thresholds)-1], now)
+	if err != nil {
+		return 0, err
+	}
+	batchIDs := make([]uuid.UUID, 0, len(rows))
+	for _, row := range rows {
+		batchIDs = append(batchIDs, row.BatchID)
+	}
+	var sent []models.ExpiryAlert
+	if len(batchIDs) > 0 {
+		if err := db.Select("batch_id", "threshold_days").Where("batch_id IN ?", batchIDs).Find(&sent).Error; err != nil {
+			return 0, err
+		}
+	}
+	type alertKey struct {
+		batch     uuid.UUID
+		threshold int
+	}
+	already := make(map[alertKey]bool, len(sent))
+	for _, alert := range sent {
+		already[alertKey{alert.BatchID, alert.ThresholdDays}] = true
+	}
+
+	due := map[int][]LocationExpiryRow{}
+	for _, row := range rows {
+		if row.DaysLeft < 0 {
+			continue
+		}
+		threshold := alertThreshold(thresholds, row.DaysLeft)
+		if threshold == 0 || already[alertKey{row.BatchID, threshold}] {
+			continue
+		}
+		due[threshold] = append(due[threshold], row)
+	}
+
+	alerted := 0
+	for _, threshold := range thresholds {
+		batches := due[threshold]
+		if len(batches) == 0 {
+			continue
+		}
+		alerts := make([]models.ExpiryAlert, 0, len(batches))
+		products := map[uuid.UUID]bool{}
+		for _, row := range batches {
+			products[row.ProductID] = true
+			alerts = append(alerts, models.ExpiryAlert{
+				BatchID:       row.BatchID,
+				ThresholdDays: threshold,
+				ProductID:     row.ProductID,
+				ExpiryDate:    row.ExpiryDate,
+				Quantity:      row.Quantity,
+			})
+		}
+		if err := db.Create(&alerts).Error; err != nil {
+			return alerted, fmt.Errorf("record expiry alerts: %w", err)
+		}
+		title := fmt.Sprintf("دفعات تنتهي خلال %d يوماً", threshold)
+		message := fmt.Sprintf("%d دفعة من %d منتج تنتهي صلاحيتها خلال %d يوماً", len(batches), len(products), threshold)
+		if err := NewNotificationService().CreateAdminNotification(models.NotificationTypeAdminExpiryAlert, title, message, map[string]interface{}{
+			"threshold_days": threshold,
+			"batches":        batches,
+		}, nil); err != nil {
+			log.Printf("⚠️ فشل في إرسال تنبيه الصلاحية: %v", err)
+		}
+		alerted += len(batches)
+	}
+	return alerted, nil
+}
+
+// ApplyExpiryMarkdowns تطبيق تخفيض قرب الانتهاء على سعر الخصم حسب الدفعة التالية في الصرف
+// بدون قواعد تُزال التخفيضات المطبقة سابقاً. يُعاد عدد المنتجات التي طُبق عليها وعدد التي أزيل عنها.
+func ApplyExpiryMarkdowns(db *gorm.DB, now time.Time, rules []ExpiryMarkdownRule) (int, int, error) {
+	var products []models.Product
+	if err := db.Select("id", "price", "discount_price", "expiry_markdown_percent", "discount_before_markdown", "expiry_date", "stock_quantity", "is_active").
+		Where("expiry_markdown_percent IS NOT NULL OR (is_active = ? AND stock_quantity > 0 AND expiry_date IS NOT NULL)", true).
+		Find(&products).Error; err != nil {
+		return 0, 0, err
+	}
+
+	applied, removed := 0, 0
+	for _, product := range products {
+		percent := 0.0
+		if product.IsActive && product.StockQuantity > 0 && product.ExpiryDate != nil {
+			percent = markdownPercent(rules, daysUntil(*product.ExpiryDate, now))
+		}
+		next, changed := planMarkdown(product.Price, markdownState{
+			DiscountPrice:          product.DiscountPrice,
+			ExpiryMarkdownPercent:  product.ExpiryMarkdownPercent,
+			DiscountBeforeMarkdown: product.DiscountBeforeMarkdown,
+		}, percent)
+		if !changed {
+			continue
+		}
+		if err := db.Model(&models.Product{}).Where("id = ?", product.ID).Updates(map[string]interface{}{
+			"discount_price":           next.DiscountPrice,
+			"expiry_markdown_percent":  next.ExpiryMarkdownPercent,
+			"discount_before_markdown": next.DiscountBeforeMarkdown,
+			"updated_at":               now,
+		}).Error; err != nil {
+			return applied, removed, err
+		}
+		if next.ExpiryMarkdownPercent != nil {
+			applied++
+		} else if product.ExpiryMarkdownPercent != nil {
+			removed++
+		}
+	}
+	return applied, removed, nil
+}
+
+// daysUntil عدد الأيام الكاملة المتبقية حتى التاريخ؛ سالب بعد مروره
+func daysUntil(date, now time.Time) int {
+	return int(math.Floor(date.Sub(now).Hours() / 24))
+}
+
+// ExpiryBucket مجموع الدفعات في فترة من فترات الملخص
+type ExpiryBucket struct {
+	WithinDays int     `json:"within_days"` // صفر للمنتهية
+	Batches    int     `json:"batches"`
+	Quantity   int     `json:"quantity"`
+	Value      float64 `json:"value"`
+}
+
+// ExpiryDigest الملخص اليومي للصلاحية
+type ExpiryDigest struct {
+	Date             string              `json:"date"`
+	Expired          ExpiryBucket        `json:"expired"`
+	Expiring         []ExpiryBucket      `json:"expiring"` // تراكمية: خلال 30 تشمل خلال 60 ما هو أقرب
+	Unpublished      []ExpiryProductRef  `json:"unpublished"`
+	MarkdownsApplied int                 `json:"markdowns_applied"`
+	MarkdownsRemoved int                 `json:"markdowns_removed"`
+	Batches          []LocationExpiryRow `json:"batches"` // الأقرب انتهاءً أولاً
+}
+
+// maxDigestBatches عدد الدفعات المعروضة في الملخص حتى لا يكبر الإشعار
+const maxDigestBatches = 50
+
+// summarizeExpiry تجميع دفعات التقرير في المنتهية وفترات العتبات
+func summarizeExpiry(rows []LocationExpiryRow, thresholds []int) (ExpiryBucket, []ExpiryBucket) {
+	expired := ExpiryBucket{}
+	buckets := make([]ExpiryBucket, len(thresholds))
+	for i, days := range thresholds {
+		buckets[i].WithinDays = days
+	}
+	add := func(bucket *ExpiryBucket, row LocationExpiryRow) {
+		bucket.Batches++
+		bucket.Quantity += row.Quantity
+		bucket.Value = RoundMoney(bucket.Value + row.Value)
+	}
+	for _, row := range rows {
+		if row.DaysLeft < 0 {
+			add(&expired, row)
+			continue
+		}
+		for i := range buckets {
+			if row.DaysLeft <= buckets[i].WithinDays {
+				add(&buckets[i], row)
+			}
+		}
+	}
+	return expired, buckets
+}
+
+// BuildExpiryDigest بناء ملخص الصلاحية من دفعات جميع الفروع
+func BuildExpiryDigest(db *gorm.DB, now time.Time, thresholds []int) (*ExpiryDigest, error) {
+	horizon := 0
+	if len(thresholds) > 0 {
+		horizon = thresholds[len(thresholds)-1]
+	}
+	rows, err := LocationExpiry(db, nil, horizon, now)
+	if err != nil {
+		return nil, err
+	}
+	digest := &ExpiryDigest{Date: now.Format("2006-01-02"), Unpublished: []ExpiryProductRef{}}
+	digest.Expired, digest.Expiring = summarizeExpiry(rows, thresholds)
+	if len(rows) > maxDigestBatches {
+		rows = rows[:maxDigestBatches]
+	}
+	digest.Batches = rows
+	return digest, nil
+}
+
+// expiryDigestSent التحقق من إرسال ملخص اليوم
+func expiryDigestSent(db *gorm.DB, now time.Time) (bool, error) {
+	var count int64
+	startOfDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	err := db.Model(&models.Notification{}).
+		Where("type = ? AND created_at >= ?", models.NotificationTypeAdminExpiryDigest, startOfDay).
+		Limit(1).Count(&count).Error
+	return count > 0, err
+}
+
+// ExpiryRunResult نتيجة تشغيل واحد لمجدول الصلاحية
+type ExpiryRunResult struct {
+	SyncedProducts   int                `json:"synced_products"`
+	Unpublished      []ExpiryProductRef `json:"unpublished"`
+	AlertedBatches   int                `json:"alerted_batches"`
+	MarkdownsApplied int                `json:"markdowns_applied"`
+	MarkdownsRemoved int                `json:"markdowns_removed"`
+	Digest           *ExpiryDigest      `json:"digest,omitempty"` // عند إرسال ملخص اليوم في هذا التشغيل
+}
+
+// RunExpiryJob تشغيل مهام الصلاحية: مزامنة المخزون، إيقاف بيع المنتهي، التنبيهات، التخفيضات والملخص اليومي
+// الملخص يُرسل مرة في اليوم ويتضمن ما أوقف بيعه وما تغير تخفيضه في نفس التشغيل.
+func RunExpiryJob(db *gorm.DB, now time.Time, settings ExpirySettings) (*ExpiryRunResult, error) {
+	result := &ExpiryRunResult{Unpublished: []ExpiryProductRef{}}
+	var err error
+	if result.SyncedProducts, err = SyncExpiredBatchStock(db, now); err != nil {
+		return nil, err
+	}
+	if settings.AutoUnpublish {
+		if result.Unpublished, err = UnpublishExpiredProducts(db, now); err != nil {
+			return nil, err
+		}
+	}
+	if result.AlertedBatches, err = SendExpiryAlerts(db, now, settings.AlertDays); err != nil {
+		return nil, err
+	}
+	rules := settings.Markdowns
+	if !settings.MarkdownEnabled {
+		rules = nil
+	}
+	if result.MarkdownsApplied, result.MarkdownsRemoved, err = ApplyExpiryMarkdowns(db, now, rules); err != nil {
+		return nil, err
+	}
+
+	sent, err := expiryDigestSent(db, now)
+	if err != nil || sent {
+		return result, err
+	}
+	digest, err := BuildExpiryDigest(db, now, settings.AlertDays)
+	if err != nil {
+		return nil, err
+	}
+	digest.Unpublished = result.Unpublished
+	digest.MarkdownsApplied = result.MarkdownsApplied
+	digest.MarkdownsRemoved = result.MarkdownsRemoved
+	title := fmt.Sprintf("ملخص الصلاحية %s", digest.Date)
+	message := fmt.Sprintf("%d دفعة منتهية بقيمة %.2f، وأُوقف بيع %d منتج", digest.Expired.Batches, digest.Expired.Value, len(digest.Unpublished))
+	if len(digest.Expiring) > 0 {
+		nearest := digest.Expiring[0]
+		message += fmt.Sprintf("، و%d دفعة تنتهي خلال %d يوماً", nearest.Batches, nearest.WithinDays)
+	}
+	if err := NewNotificationService().CreateAdminNotification(models.NotificationTypeAdminExpiryDigest, title, message, digest, nil); err != nil {
+		return nil, err
+	}
+	result.Digest = digest
+	return result, nil
+}
+
+// StartExpiryScheduler تشغيل مجدول الصلاحية في الخلفية
+// يعمل كل EXPIRY_JOB_INTERVAL_MINUTES دقيقة (60 افتراضياً) ويُعطل بـ EXPIRY_JOB_ENABLED=false.
+func StartExpiryScheduler(db *gorm.DB) {
+	if !envBool("EXPIRY_JOB_ENABLED", true) {
+		log.Println("⏸️ مجدول الصلاحية معطل")
+		return
+	}
+	interval := time.Duration(envFloat("EXPIRY_JOB_INTERVAL_MINUTES", 60)) * time.Minute
+	if interval <= 0 {
+		interval = time.Hour
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			result, err := RunExpiryJob(db, time.Now(), LoadExpirySettings())
+			if err != nil {
+				log.Printf("❌ فشل تشغيل مجدول الصلاحية: %v", err)
+			} else if len(result.Unpublished) > 0 || result.AlertedBatches > 0 || result.MarkdownsApplied > 0 || result.MarkdownsRemoved > 0 {
+				log.Printf("⏳ الصلاحية: أوقف بيع %d منتج، %d تنبيه دفعة، %d تخفيض مطبق و%d مزال",
+					len(result.Unpublished), result.AlertedBatches, result.MarkdownsApplied, result.MarkdownsRemoved)
+			}
+			<-ticker.C
+		}
+	}()
+	log.Printf("✅ تم تشغيل مجدول الصلاحية كل %s", interval)
+}
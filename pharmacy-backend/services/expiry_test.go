@@ -0,0 +1,188 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"pharmacy-backend/models"
+)
+
+func TestParseExpirySettings(t *testing.T) {
+	days, err := ParseExpiryAlertDays(" 90,30, 60,30 ")
+	assert.NoError(t, err)
+	assert.Equal(t, []int{30, 60, 90}, days)
+	_, err = ParseExpiryAlertDays("90,abc")
+	assert.True(t, errors.Is(err, ErrInvalidExpirySettings))
+
+	rules, err := ParseExpiryMarkdownRules("60:10, 30:25")
+	assert.NoError(t, err)
+	assert.Equal(t, []ExpiryMarkdownRule{{30, 25}, {60, 10}}, rules)
+	// التخفيض الأقرب انتهاءً يجب أن يكون أكبر
+	_, err = ParseExpiryMarkdownRules("60:30,30:10")
+	assert.True(t, errors.Is(err, ErrInvalidExpirySettings))
+	_, err = ParseExpiryMarkdownRules("30:100")
+	assert.True(t, errors.Is(err, ErrInvalidExpirySettings))
+}
+
+func TestExpiryThresholds(t *testing.T) {
+	thresholds := []int{30, 60, 90}
+	assert.Equal(t, 90, alertThreshold(thresholds, 75))
+	assert.Equal(t, 30, alertThreshold(thresholds, 12))
+	assert.Equal(t, 0, alertThreshold(thresholds, 120))
+
+	rules := []ExpiryMarkdownRule{{30, 25}, {60, 10}}
+	assert.Equal(t, 25.0, markdownPercent(rules, 30))
+	assert.Equal(t, 10.0, markdownPercent(rules, 45))
+	assert.Equal(t, 0.0, markdownPercent(rules, 61))
+	assert.Equal(t, 0.0, markdownPercent(rules, -1))
+
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, 29, daysUntil(now.Add(29*24*time.Hour+time.Hour), now))
+	assert.Equal(t, -1, daysUntil(now.Add(-time.Hour), now))
+}
+
+func TestPlanMarkdown(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+
+	// تطبيق تخفيض على منتج بلا خصم
+	next, changed := planMarkdown(100, markdownState{}, 25)
+	require.True(t, changed)
+	assert.Equal(t, 75.0, *next.DiscountPrice)
+	assert.Equal(t, 25.0, *next.ExpiryMarkdownPercent)
+	assert.Nil(t, next.DiscountBeforeMarkdown)
+
+	// نفس النسبة لا تغير شيئاً
+	_, changed = planMarkdown(100, next, 25)
+	assert.False(t, changed)
+
+	// خصم يدوي أفضل من التخفيض يبقى
+	_, changed = planMarkdown(100, markdownState{DiscountPrice: f(60)}, 25)
+	assert.False(t, changed)
+
+	// خصم يدوي أقل يُحفظ ويُستعاد عند زوال التخفيض
+	next, changed = planMarkdown(100, markdownState{DiscountPrice: f(95)}, 10)
+	require.True(t, changed)
+	assert.Equal(t, 95.0, *next.DiscountBeforeMarkdown)
+	next, changed = planMarkdown(100, next, 25)
+	require.True(t, changed)
+	assert.Equal(t, 75.0, *next.DiscountPrice)
+	assert.Equal(t, 95.0, *next.DiscountBeforeMarkdown)
+	next, changed = planMarkdown(100, next, 0)
+	require.True(t, changed)
+	assert.Equal(t, 95.0, *next.DiscountPrice)
+	assert.Nil(t, next.ExpiryMarkdownPercent)
+
+	// المسؤول غيّر سعر الخصم أثناء التخفيض: يُعتبر يدوياً ولا يُستعاد القديم
+	next, changed = planMarkdown(100, markdownState{DiscountPrice: f(70), ExpiryMarkdownPercent: f(25), DiscountBeforeMarkdown: f(90)}, 0)
+	require.True(t, changed)
+	assert.Equal(t, 70.0, *next.DiscountPrice)
+	assert.Nil(t, next.ExpiryMarkdownPercent)
+	assert.Nil(t, next.DiscountBeforeMarkdown)
+}
+
+func TestSummarizeExpiry(t *testing.T) {
+	rows := []LocationExpiryRow{
+		{DaysLeft: -3, Quantity: 2, Value: 10},
+		{DaysLeft: 10, Quantity: 5, Value: 20},
+		{DaysLeft: 45, Quantity: 1, Value: 7.5},
+	}
+	expired, buckets := summarizeExpiry(rows, []int{30, 60, 90})
+	assert.Equal(t, ExpiryBucket{Batches: 1, Quantity: 2, Value: 10}, expired)
+	assert.Equal(t, []ExpiryBucket{
+		{WithinDays: 30, Batches: 1, Quantity: 5, Value: 20},
+		{WithinDays: 60, Batches: 2, Quantity: 6, Value: 27.5},
+		{WithinDays: 90, Batches: 2, Quantity: 6, Value: 27.5},
+	}, buckets)
+}
+
+func createExpiryTestBatch(t *testing.T, db *gorm.DB, productID uuid.UUID, locationID *uuid.UUID, quantity int, expiry time.Time) models.ProductBatch {
+	batch := models.ProductBatch{
+		ProductID:        productID,
+		BatchNumber:      "EXP-" + uuid.New().String()[:8],
+		ExpiryDate:       &expiry,
+		Quantity:         quantity,
+		ReceivedQuantity: quantity,
+		LocationID:       locationID,
+		ReceivedAt:       time.Now(),
+	}
+	require.NoError(t, db.Create(&batch).Error)
+	t.Cleanup(func() {
+		db.Delete(&models.ProductBatch{}, "id = ?", batch.ID)
+	})
+	return batch
+}
+
+func TestUnpublishExpiredProducts(t *testing.T) {
+	db := setupStockTestDB(t)
+	now := time.Now()
+
+	// منتج تجزئة غير متتبع بالدفعات وغير منشور صراحة وانتهت صلاحيته
+	retail := createStockTestProduct(t, db, 5)
+	require.NoError(t, db.Model(&retail).Update("expiry_date", now.AddDate(0, 0, -1)).Error)
+	// منتج لم يبقَ منه إلا دفعة منتهية
+	tracked := createStockTestProduct(t, db, 0)
+	createExpiryTestBatch(t, db, tracked.ID, nil, 3, now.AddDate(0, 0, -2))
+	// منتج صالح لا يتأثر
+	valid := createStockTestProduct(t, db, 5)
+	require.NoError(t, db.Model(&valid).Update("expiry_date", now.AddDate(0, 6, 0)).Error)
+
+	products, err := UnpublishExpiredProducts(db, now)
+	require.NoError(t, err)
+	ids := map[uuid.UUID]bool{}
+	for _, product := range products {
+		ids[product.ID] = true
+	}
+	assert.True(t, ids[retail.ID])
+	assert.True(t, ids[tracked.ID])
+	assert.False(t, ids[valid.ID])
+
+	for id, active := range map[uuid.UUID]bool{retail.ID: false, tracked.ID: false, valid.ID: true} {
+		var reloaded models.Product
+		require.NoError(t, db.First(&reloaded, "id = ?", id).Error)
+		assert.Equal(t, active, reloaded.IsActive, reloaded.SKU)
+	}
+
+	// المنتج الموقوف لا يمكن طلبه
+	_, err = QuoteOrder(db, &models.User{ID: uuid.New()}, []OrderLineInput{{ProductID: retail.ID, Quantity: 1}}, LoadPricingSettings())
+	assert.True(t, errors.Is(err, ErrProductUnavailable))
+}
+
+func TestSendExpiryAlertsOncePerThreshold(t *testing.T) {
+	db := setupStockTestDB(t)
+	now := time.Now()
+
+	location := models.Location{Code: "EXP-" + uuid.New().String()[:8], Name: "Expiry test", City: "Riyadh"}
+	require.NoError(t, db.Create(&location).Error)
+	t.Cleanup(func() {
+		db.Delete(&models.Location{}, "id = ?", location.ID)
+	})
+	product := createStockTestProduct(t, db, 4)
+	batch := createExpiryTestBatch(t, db, product.ID, &location.ID, 4, now.AddDate(0, 0, 20).Add(time.Hour))
+	t.Cleanup(func() {
+		db.Delete(&models.ExpiryAlert{}, "batch_id = ?", batch.ID)
+	})
+
+	thresholds := []int{30, 60, 90}
+	alerted, err := SendExpiryAlerts(db, now, thresholds)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, alerted, 1)
+
+	var alerts []models.ExpiryAlert
+	require.NoError(t, db.Where("batch_id = ?", batch.ID).Find(&alerts).Error)
+	require.Len(t, alerts, 1)
+	assert.Equal(t, 30, alerts[0].ThresholdDays)
+
+	// التشغيل التالي لا يكرر التنبيه لنفس الدفعة والعتبة
+	alerted, err = SendExpiryAlerts(db, now, thresholds)
+	require.NoError(t, err)
+	assert.Equal(t, 0, alerted)
+	var count int64
+	require.NoError(t, db.Model(&models.ExpiryAlert{}).Where("batch_id = ?", batch.ID).Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+}
@@ -0,0 +1,178 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"pharmacy-backend/models"
+)
+
+// ErrInvalidReportGrouping تجميع غير مدعوم في تقارير التكلفة
+var ErrInvalidReportGrouping = errors.New("group_by must be product, category or supplier")
+
+// ReportGrouping بُعد تجميع تقارير التقييم والهامش
+type ReportGrouping string
+
+const (
+	GroupByProduct  ReportGrouping = "product"
+	GroupByCategory ReportGrouping = "category"
+	GroupBySupplier ReportGrouping = "supplier"
+)
+
+// costReportDimension أعمدة المعرف والاسم والرمز لبُعد التجميع
+// مورد القيد هو مورد دفعته، أو مورد المنتج إذا لم تكن له دفعة.
+func costReportDimension(group ReportGrouping) (id, name, code string, err error) {
+	switch group {
+	case GroupByProduct, "":
+		return "p.id", "p.name", "p.sku", nil
+	case GroupByCategory:
+		return "c.id", "COALESCE(c.name, '')", "''", nil
+	case GroupBySupplier:
+		return "s.id", "COALESCE(s.name, '')", "COALESCE(s.tax_number, '')", nil
+	}
+	return "", "", "", ErrInvalidReportGrouping
+}
+
+// costEntriesWithDimensions قيود التكلفة مع المنتج والتصنيف والمورد
+func costEntriesWithDimensions(db *gorm.DB) *gorm.DB {
+	return db.Table("inventory_cost_entries AS e").
+		Joins("JOIN products p ON p.id = e.product_id").
+		Joins("LEFT JOIN product_batches b ON b.id = e.batch_id").
+		Joins("LEFT JOIN categories c ON c.id = p.category_id").
+		Joins("LEFT JOIN suppliers s ON s.id = COALESCE(b.supplier_id, p.supplier_id)")
+}
+
+// ValuationRow قيمة المخزون لمنتج أو تصنيف أو مورد
+type ValuationRow struct {
+	ID          *uuid.UUID `json:"id"`
+	Name        string     `json:"name"`
+	Code        string     `json:"code,omitempty"`
+	Quantity    int        `json:"quantity"`
+	Value       float64    `json:"value"`
+	AverageCost float64    `json:"average_cost"`
+}
+
+// InventoryValuation تقرير قيمة المخزون
+type InventoryValuation struct {
+	AsOf       time.Time      `json:"as_of"`
+	Method     CostingMethod  `json:"costing_method"`
+	GroupBy    ReportGrouping `json:"group_by"`
+	Rows       []ValuationRow `json:"rows"`
+	Quantity   int            `json:"total_quantity"`
+	TotalValue float64        `json:"total_value"`
+}
+
+// ValueInventory قيمة المخزون في نهاية يوم محدد من مجموع قيود دفتر التكلفة حتى ذلك اليوم
+func ValueInventory(db *gorm.DB, asOf time.Time, group ReportGrouping) (*InventoryValuation, error) {
+	id, name, code, err := costReportDimension(group)
+	if err != nil {
+		return nil, err
+	}
+	if group == "" {
+		group = GroupByProduct
+	}
+
+	report := &InventoryValuation{AsOf: asOf, Method: CurrentCostingMethod(), GroupBy: group}
+	if err := costEntriesWithDimensions(db).
+		Select(id+" AS id, "+name+" AS name, "+code+" AS code, SUM(e.quantity) AS quantity, SUM(e.value) AS value").
+		Where("e.occurred_at < ?", asOf.AddDate(0, 0, 1)).
+		Group(id + ", " + name + ", " + code).
+		Having("SUM(e.quantity) <> 0 OR SUM(e.value) <> 0").
+		Order("value DESC").
+		Scan(&report.Rows).Error; err != nil {
+		return nil, err
+	}
+
+	for i := range report.Rows {
+		row := &report.Rows[i]
+		row.Value = RoundMoney(row.Value)
+		if cost, ok := weightedAverage(row.Quantity, row.Value); ok {
+			row.AverageCost = roundUnitCost(cost)
+		}
+		report.Quantity += row.Quantity
+		report.TotalValue += row.Value
+	}
+	report.TotalValue = RoundMoney(report.TotalValue)
+	return report, nil
+}
+
+// MarginRow إجمالي المبيعات وتكلفتها وهامشها لمنتج أو تصنيف أو مورد
+type MarginRow struct {
+	ID            *uuid.UUID `json:"id"`
+	Name          string     `json:"name"`
+	Code          string     `json:"code,omitempty"`
+	Units         int        `json:"units"`
+	Revenue       float64    `json:"revenue"`
+	COGS          float64    `json:"cogs"`
+	GrossMargin   float64    `json:"gross_margin"`
+	MarginPercent float64    `json:"margin_percent"`
+}
+
+// GrossMarginReport تقرير الهامش الإجمالي لفترة
+type GrossMarginReport struct {
+	From    time.Time      `json:"from"`
+	To      time.Time      `json:"to"`
+	GroupBy ReportGrouping `json:"group_by"`
+	Rows    []MarginRow    `json:"rows"`
+	Totals  MarginRow      `json:"totals"`
+}
+
+// MarginPercent نسبة الهامش من الإيراد
+func MarginPercent(revenue, cogs float64) float64 {
+	if revenue == 0 {
+		return 0
+	}
+	return RoundMoney((revenue - cogs) / revenue * 100)
+}
+
+// finalizeMarginRows تقريب الإيراد والتكلفة وحساب الهامش لكل صف وللإجمالي
+func finalizeMarginRows(rows []MarginRow) MarginRow {
+	totals := MarginRow{Name: "total"}
+	for i := range rows {
+		row := &rows[i]
+		row.Revenue = RoundMoney(row.Revenue)
+		row.COGS = RoundMoney(row.COGS)
+		row.GrossMargin = RoundMoney(row.Revenue - row.COGS)
+		row.MarginPercent = MarginPercent(row.Revenue, row.COGS)
+		totals.Units += row.Units
+		totals.Revenue += row.Revenue
+		totals.COGS += row.COGS
+	}
+	totals.Revenue = RoundMoney(totals.Revenue)
+	totals.COGS = RoundMoney(totals.COGS)
+	totals.GrossMargin = RoundMoney(totals.Revenue - totals.COGS)
+	totals.MarginPercent = MarginPercent(totals.Revenue, totals.COGS)
+	return totals
+}
+
+// BuildGrossMarginReport الهامش الإجمالي للمبيعات المشحونة بين تاريخين شاملين
+// الإيراد سعر العنصر بعد توزيع خصم الكوبون، والمرتجعات المعادة للمخزون تُطرح من الإيراد والتكلفة.
+func BuildGrossMarginReport(db *gorm.DB, from, to time.Time, group ReportGrouping) (*GrossMarginReport, error) {
+	id, name, code, err := costReportDimension(group)
+	if err != nil {
+		return nil, err
+	}
+	if group == "" {
+		group = GroupByProduct
+	}
+
+	report := &GrossMarginReport{From: from, To: to, GroupBy: group}
+	if err := costEntriesWithDimensions(db).
+		Joins("JOIN order_items oi ON oi.id = e.order_item_id").
+		Joins("JOIN orders o ON o.id = oi.order_id").
+		Select(id+" AS id, "+name+" AS name, "+code+" AS code, "+
+			"SUM(-e.quantity) AS units, "+
+			"SUM(-e.quantity * oi.unit_price * (1 - LEAST(COALESCE(o.discount_amount / NULLIF(o.subtotal, 0), 0), 1))) AS revenue, "+
+			"SUM(-e.value) AS cogs").
+		Where("e.source IN ? AND e.occurred_at >= ? AND e.occurred_at < ?",
+			[]models.CostEntrySource{models.CostSourceSale, models.CostSourceCustomerReturn}, from, to.AddDate(0, 0, 1)).
+		Group(id + ", " + name + ", " + code).
+		Order("revenue DESC").
+		Scan(&report.Rows).Error; err != nil {
+		return nil, err
+	}
+	report.Totals = finalizeMarginRows(report.Rows)
+	return report, nil
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/go-pdf/fpdf"
+	qrcode "github.com/skip2/go-qrcode"
+	"pharmacy-backend/models"
+)
+
+// invoicePDFTitle عنوان المستند المطبوع حسب نوعه
+func invoicePDFTitle(inv *models.Invoice) string {
+	switch {
+	case inv.IsCreditNote():
+		return "Credit Note"
+	case inv.InvoiceType == models.InvoiceTypeTax:
+		return "Tax Invoice"
+	default:
+		return "Simplified Tax Invoice"
+	}
+}
+
+// RenderInvoicePDF رسم الفاتورة أو الإشعار الدائن كملف PDF مع رمز QR
+// الخطوط الأساسية في PDF لا تدعم العربية؛ لطباعة الأسماء العربية يُحدد
+// INVOICE_PDF_FONT بمسار خط TTF يدعمها.
+func RenderInvoicePDF(inv *models.Invoice, w io.Writer) error {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	family := "Helvetica"
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	if fontPath := envString("INVOICE_PDF_FONT", ""); fontPath != "" {
+		pdf.AddUTF8Font("InvoiceFont", "", fontPath)
+		pdf.AddUTF8Font("InvoiceFont", "B", fontPath)
+		family = "InvoiceFont"
+		tr = func(s string) string { return s }
+	}
+	pdf.SetMargins(15, 15, 15)
+	pdf.AddPage()
+
+	png, err := qrcode.Encode(inv.QRCode, qrcode.Medium, 256)
+	if err != nil {
+		return fmt.Errorf("encode invoice QR: %w", err)
+	}
+	pdf.RegisterImageOptionsReader("qr", fpdf.ImageOptions{ImageType: "PNG"}, bytes.NewReader(png))
+	pdf.ImageOptions("qr", 160, 12, 35, 35, false, fpdf.ImageOptions{ImageType: "PNG"}, 0, "")
+
+	pdf.SetFont(family, "B", 16)
+	pdf.CellFormat(140, 10, invoicePDFTitle(inv), "", 1, "L", false, 0, "")
+
+	pdf.SetFont(family, "", 10)
+	field := func(label, value string) {
+		if value == "" {
+			return
+		}
+		pdf.SetFont(family, "B", 10)
+		pdf.CellFormat(40, 6, label, "", 0, "L", false, 0, "")
+		pdf.SetFont(family, "", 10)
+		pdf.CellFormat(100, 6, tr(value), "", 1, "L", false, 0, "")
+	}
+	field("Number", inv.InvoiceNumber)
+	field("Issue date", inv.IssuedAt.Format("2006-01-02 15:04:05"))
+	field("Order", inv.OrderNumber)
+	field("Original invoice", inv.OriginalNumber)
+	field("Reason", inv.Reason)
+	pdf.Ln(4)
+
+	field("Seller", inv.SellerName)
+	field("Seller VAT no.", inv.SellerVATNumber)
+	field("Seller address", inv.SellerAddress)
+	pdf.Ln(2)
+	field("Buyer", inv.BuyerName)
+	field("Buyer VAT no.", inv.BuyerVATNumber)
+	field("Buyer address", inv.BuyerAddress)
+	pdf.Ln(6)
+
+	headers := []string{"Description", "Qty", "Unit price", "Net", "VAT %", "VAT", "Total"}
+	widths := []float64{62, 14, 22, 22, 16, 20, 24}
+	pdf.SetFont(family, "B", 9)
+	pdf.SetFillColor(235, 235, 235)
+	for i, h := range headers {
+		pdf.CellFormat(widths[i], 7, h, "1", 0, "C", true, 0, "")
+	}
+	pdf.Ln(-1)
+
+	pdf.SetFont(family, "", 9)
+	for _, line := range inv.Lines {
+		cells := []string{
+			tr(line.Description),
+			fmt.Sprintf("%d", line.Quantity),
+			fmt.Sprintf("%.2f", line.UnitPrice),
+			fmt.Sprintf("%.2f", line.NetAmount),
+			formatPercent(line.TaxRate),
+			fmt.Sprintf("%.2f", line.TaxAmount),
+			fmt.Sprintf("%.2f", line.TotalAmount),
+		}
+		for i, cell := range cells {
+			align := "R"
+			if i == 0 {
+				align = "L"
+			}
+			pdf.CellFormat(widths[i], 7, cell, "1", 0, align, false, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+	pdf.Ln(4)
+
+	total := func(label string, value float64, bold bool) {
+		style := ""
+		if bold {
+			style = "B"
+		}
+		pdf.SetFont(family, style, 10)
+		pdf.CellFormat(136, 7, label, "", 0, "R", false, 0, "")
+		pdf.CellFormat(44, 7, fmt.Sprintf("%.2f %s", value, inv.Currency), "", 1, "R", false, 0, "")
+	}
+	if inv.DiscountAmount > 0 {
+		total("Discount (included in lines)", inv.DiscountAmount, false)
+	}
+	total("Total excluding VAT", inv.NetAmount, false)
+	total("VAT", inv.TaxAmount, false)
+	total("Total including VAT", inv.TotalAmount, true)
+
+	if err := pdf.Error(); err != nil {
+		return err
+	}
+	return pdf.Output(w)
+}
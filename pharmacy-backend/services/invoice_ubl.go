@@ -0,0 +1,237 @@
+package services
+
+import (
+	"encoding/xml"
+	"fmt"
+
+	"pharmacy-backend/models"
+)
+
+// رموز UBL لنوع المستند ونوع الفاتورة وفق مواصفات الفوترة الإلكترونية
+const (
+	ublInvoiceTypeCode    = "388"
+	ublCreditNoteTypeCode = "381"
+	ublTaxInvoiceName     = "0100000"
+	ublSimplifiedName     = "0200000"
+)
+
+type ublAmount struct {
+	CurrencyID string `xml:"currencyID,attr"`
+	Value      string `xml:",chardata"`
+}
+
+type ublCode struct {
+	Name  string `xml:"name,attr,omitempty"`
+	Value string `xml:",chardata"`
+}
+
+type ublQuantity struct {
+	UnitCode string `xml:"unitCode,attr"`
+	Value    int    `xml:",chardata"`
+}
+
+type ublTaxScheme struct {
+	ID string `xml:"cbc:ID"`
+}
+
+type ublTaxCategory struct {
+	ID        string       `xml:"cbc:ID"`
+	Percent   string       `xml:"cbc:Percent"`
+	TaxScheme ublTaxScheme `xml:"cac:TaxScheme"`
+}
+
+type ublPartyTaxScheme struct {
+	CompanyID string       `xml:"cbc:CompanyID"`
+	TaxScheme ublTaxScheme `xml:"cac:TaxScheme"`
+}
+
+type ublBillingReference struct {
+	InvoiceDocumentReference struct {
+		ID string `xml:"cbc:ID"`
+	} `xml:"cac:InvoiceDocumentReference"`
+}
+
+type ublParty struct {
+	PostalAddress struct {
+		StreetName string `xml:"cbc:StreetName,omitempty"`
+		Country    struct {
+			IdentificationCode string `xml:"cbc:IdentificationCode"`
+		} `xml:"cac:Country"`
+	} `xml:"cac:PostalAddress"`
+	PartyTaxScheme   *ublPartyTaxScheme `xml:"cac:PartyTaxScheme,omitempty"`
+	PartyLegalEntity struct {
+		RegistrationName string `xml:"cbc:RegistrationName"`
+	} `xml:"cac:PartyLegalEntity"`
+}
+
+type ublTaxSubtotal struct {
+	TaxableAmount ublAmount      `xml:"cbc:TaxableAmount"`
+	TaxAmount     ublAmount      `xml:"cbc:TaxAmount"`
+	TaxCategory   ublTaxCategory `xml:"cac:TaxCategory"`
+}
+
+type ublInvoiceLine struct {
+	ID                  int         `xml:"cbc:ID"`
+	InvoicedQuantity    ublQuantity `xml:"cbc:InvoicedQuantity"`
+	LineExtensionAmount ublAmount   `xml:"cbc:LineExtensionAmount"`
+	TaxTotal            struct {
+		TaxAmount      ublAmount `xml:"cbc:TaxAmount"`
+		RoundingAmount ublAmount `xml:"cbc:RoundingAmount"`
+	} `xml:"cac:TaxTotal"`
+	Item struct {
+		Name                  string         `xml:"cbc:Name"`
+		ClassifiedTaxCategory ublTaxCategory `xml:"cac:ClassifiedTaxCategory"`
+	} `xml:"cac:Item"`
+	Price struct {
+		PriceAmount ublAmount `xml:"cbc:PriceAmount"`
+	} `xml:"cac:Price"`
+}
+
+type ublInvoice struct {
+	XMLName          xml.Name `xml:"Invoice"`
+	Xmlns            string   `xml:"xmlns,attr"`
+	XmlnsCac         string   `xml:"xmlns:cac,attr"`
+	XmlnsCbc         string   `xml:"xmlns:cbc,attr"`
+	ProfileID        string   `xml:"cbc:ProfileID"`
+	ID               string   `xml:"cbc:ID"`
+	UUID             string   `xml:"cbc:UUID"`
+	IssueDate        string   `xml:"cbc:IssueDate"`
+	IssueTime        string   `xml:"cbc:IssueTime"`
+	InvoiceTypeCode  ublCode  `xml:"cbc:InvoiceTypeCode"`
+	Note             string   `xml:"cbc:Note,omitempty"`
+	DocumentCurrency string   `xml:"cbc:DocumentCurrencyCode"`
+	TaxCurrency      string   `xml:"cbc:TaxCurrencyCode"`
+	OrderReference   struct {
+		ID string `xml:"cbc:ID"`
+	} `xml:"cac:OrderReference"`
+	BillingReference            *ublBillingReference `xml:"cac:BillingReference,omitempty"`
+	AdditionalDocumentReference struct {
+		ID         string `xml:"cbc:ID"`
+		Attachment struct {
+			EmbeddedDocumentBinaryObject struct {
+				MimeCode string `xml:"mimeCode,attr"`
+				Value    string `xml:",chardata"`
+			} `xml:"cbc:EmbeddedDocumentBinaryObject"`
+		} `xml:"cac:Attachment"`
+	} `xml:"cac:AdditionalDocumentReference"`
+	AccountingSupplierParty struct {
+		Party ublParty `xml:"cac:Party"`
+	} `xml:"cac:AccountingSupplierParty"`
+	AccountingCustomerParty struct {
+		Party ublParty `xml:"cac:Party"`
+	} `xml:"cac:AccountingCustomerParty"`
+	TaxTotal struct {
+		TaxAmount    ublAmount        `xml:"cbc:TaxAmount"`
+		TaxSubtotals []ublTaxSubtotal `xml:"cac:TaxSubtotal"`
+	} `xml:"cac:TaxTotal"`
+	LegalMonetaryTotal struct {
+		LineExtensionAmount ublAmount `xml:"cbc:LineExtensionAmount"`
+		TaxExclusiveAmount  ublAmount `xml:"cbc:TaxExclusiveAmount"`
+		TaxInclusiveAmount  ublAmount `xml:"cbc:TaxInclusiveAmount"`
+		PayableAmount       ublAmount `xml:"cbc:PayableAmount"`
+	} `xml:"cac:LegalMonetaryTotal"`
+	InvoiceLines []ublInvoiceLine `xml:"cac:InvoiceLine"`
+}
+
+func newUBLParty(name, vatNumber, address string) ublParty {
+	var party ublParty
+	party.PostalAddress.StreetName = address
+	party.PostalAddress.Country.IdentificationCode = "SA"
+	party.PartyLegalEntity.RegistrationName = name
+	if vatNumber != "" {
+		party.PartyTaxScheme = &ublPartyTaxScheme{CompanyID: vatNumber, TaxScheme: ublTaxScheme{ID: "VAT"}}
+	}
+	return party
+}
+
+// ublTaxCategoryFor فئة الضريبة للسطر: S للنسبة الأساسية و Z للأسطر دون ضريبة
+func ublTaxCategoryFor(rate float64) ublTaxCategory {
+	category := ublTaxCategory{ID: "S", Percent: formatPercent(rate), TaxScheme: ublTaxScheme{ID: "VAT"}}
+	if rate == 0 {
+		category.ID = "Z"
+	}
+	return category
+}
+
+func formatPercent(rate float64) string {
+	return fmt.Sprintf("%.2f", rate*100)
+}
+
+// RenderInvoiceXML إخراج الفاتورة أو الإشعار الدائن بصيغة UBL 2.1
+func RenderInvoiceXML(inv *models.Invoice) ([]byte, error) {
+	amount := func(v float64) ublAmount {
+		return ublAmount{CurrencyID: inv.Currency, Value: fmt.Sprintf("%.2f", v)}
+	}
+
+	doc := ublInvoice{
+		Xmlns:            "urn:oasis:names:specification:ubl:schema:xsd:Invoice-2",
+		XmlnsCac:         "urn:oasis:names:specification:ubl:schema:xsd:CommonAggregateComponents-2",
+		XmlnsCbc:         "urn:oasis:names:specification:ubl:schema:xsd:CommonBasicComponents-2",
+		ProfileID:        "reporting:1.0",
+		ID:               inv.InvoiceNumber,
+		UUID:             inv.ID.String(),
+		IssueDate:        inv.IssuedAt.Format("2006-01-02"),
+		IssueTime:        inv.IssuedAt.Format("15:04:05"),
+		InvoiceTypeCode:  ublCode{Name: ublSimplifiedName, Value: ublInvoiceTypeCode},
+		Note:             inv.Reason,
+		DocumentCurrency: inv.Currency,
+		TaxCurrency:      inv.Currency,
+	}
+	if inv.InvoiceType == models.InvoiceTypeTax {
+		doc.InvoiceTypeCode.Name = ublTaxInvoiceName
+	}
+	if inv.IsCreditNote() {
+		doc.InvoiceTypeCode.Value = ublCreditNoteTypeCode
+		doc.BillingReference = &ublBillingReference{}
+		doc.BillingReference.InvoiceDocumentReference.ID = inv.OriginalNumber
+	}
+	doc.OrderReference.ID = inv.OrderNumber
+	doc.AdditionalDocumentReference.ID = "QR"
+	doc.AdditionalDocumentReference.Attachment.EmbeddedDocumentBinaryObject.MimeCode = "text/plain"
+	doc.AdditionalDocumentReference.Attachment.EmbeddedDocumentBinaryObject.Value = inv.QRCode
+	doc.AccountingSupplierParty.Party = newUBLParty(inv.SellerName, inv.SellerVATNumber, inv.SellerAddress)
+	doc.AccountingCustomerParty.Party = newUBLParty(inv.BuyerName, inv.BuyerVATNumber, inv.BuyerAddress)
+
+	// تجميع الأسطر حسب نسبة الضريبة
+	var rates []float64
+	subtotals := map[float64]*ublTaxSubtotal{}
+	taxable := map[float64]float64{}
+	taxes := map[float64]float64{}
+	for i, line := range inv.Lines {
+		if _, ok := subtotals[line.TaxRate]; !ok {
+			rates = append(rates, line.TaxRate)
+			subtotals[line.TaxRate] = &ublTaxSubtotal{TaxCategory: ublTaxCategoryFor(line.TaxRate)}
+		}
+		taxable[line.TaxRate] += line.NetAmount
+		taxes[line.TaxRate] += line.TaxAmount
+
+		var l ublInvoiceLine
+		l.ID = i + 1
+		l.InvoicedQuantity = ublQuantity{UnitCode: "PCE", Value: line.Quantity}
+		l.LineExtensionAmount = amount(line.NetAmount)
+		l.TaxTotal.TaxAmount = amount(line.TaxAmount)
+		l.TaxTotal.RoundingAmount = amount(line.TotalAmount)
+		l.Item.Name = line.Description
+		l.Item.ClassifiedTaxCategory = ublTaxCategoryFor(line.TaxRate)
+		l.Price.PriceAmount = amount(line.UnitPrice)
+		doc.InvoiceLines = append(doc.InvoiceLines, l)
+	}
+	doc.TaxTotal.TaxAmount = amount(inv.TaxAmount)
+	for _, rate := range rates {
+		subtotal := subtotals[rate]
+		subtotal.TaxableAmount = amount(RoundMoney(taxable[rate]))
+		subtotal.TaxAmount = amount(RoundMoney(taxes[rate]))
+		doc.TaxTotal.TaxSubtotals = append(doc.TaxTotal.TaxSubtotals, *subtotal)
+	}
+
+	doc.LegalMonetaryTotal.LineExtensionAmount = amount(inv.NetAmount)
+	doc.LegalMonetaryTotal.TaxExclusiveAmount = amount(inv.NetAmount)
+	doc.LegalMonetaryTotal.TaxInclusiveAmount = amount(inv.TotalAmount)
+	doc.LegalMonetaryTotal.PayableAmount = amount(inv.TotalAmount)
+
+	out, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
@@ -0,0 +1,428 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"pharmacy-backend/models"
+)
+
+// أخطاء إصدار الفواتير
+var (
+	ErrInvoiceNotEligible = errors.New("order is not paid or delivered yet")
+	ErrSellerVATMissing   = errors.New("seller VAT number is not configured")
+	ErrInvoiceMissing     = errors.New("order has no invoice to credit")
+)
+
+// IsInvoiceError التحقق مما إذا كان الخطأ ناتجاً عن طلب إصدار فاتورة غير صالح
+func IsInvoiceError(err error) bool {
+	return errors.Is(err, ErrInvoiceNotEligible) ||
+		errors.Is(err, ErrSellerVATMissing) ||
+		errors.Is(err, ErrInvoiceMissing)
+}
+
+// SellerSettings بيانات البائع المطبوعة على الفواتير
+// تُقرأ من نفس متغيرات البيئة التي تعرضها إعدادات المتجر في لوحة التحكم.
+type SellerSettings struct {
+	Name               string
+	VATNumber          string
+	CommercialRegister string
+	Address            string
+	Currency           string
+}
+
+// LoadSellerSettings قراءة بيانات البائع الحالية
+func LoadSellerSettings() SellerSettings {
+	return SellerSettings{
+		Name:               envString("STORE_NAME", "صيدلية المعتمد"),
+		VATNumber:          envString("VAT_NUMBER", ""),
+		CommercialRegister: envString("COMMERCIAL_REGISTER", ""),
+		Address:            envString("STORE_ADDRESS", "الرياض، المملكة العربية السعودية"),
+		Currency:           envString("CURRENCY", "SAR"),
+	}
+}
+
+// ValidVATNumber التحقق من صيغة الرقم الضريبي السعودي: 15 رقماً يبدأ وينتهي بالرقم 3
+func ValidVATNumber(vat string) bool {
+	if len(vat) != 15 || vat[0] != '3' || vat[14] != '3' {
+		return false
+	}
+	for _, r := range vat {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func envString(key, defaultValue string) string {
+	if value := strings.TrimSpace(os.Getenv(key)); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// EncodeInvoiceQR ترميز حمولة رمز QR للفاتورة المبسطة وفق مواصفات الفوترة الإلكترونية
+// كل حقل يُكتب كـ Tag (بايت) ثم Length (بايت) ثم القيمة بترميز UTF-8، والناتج بترميز base64:
+// 1 اسم البائع، 2 الرقم الضريبي، 3 وقت الإصدار، 4 الإجمالي شامل الضريبة، 5 قيمة الضريبة.
+func EncodeInvoiceQR(sellerName, vatNumber string, issuedAt time.Time, total, vat float64) string {
+	fields := []string{
+		sellerName,
+		vatNumber,
+		issuedAt.UTC().Format("2006-01-02T15:04:05Z"),
+		fmt.Sprintf("%.2f", total),
+		fmt.Sprintf("%.2f", vat),
+	}
+
+	var buf bytes.Buffer
+	for i, value := range fields {
+		b := []byte(value)
+		if len(b) > 255 {
+			b = []byte(truncateUTF8(value, 255))
+		}
+		buf.WriteByte(byte(i + 1))
+		buf.WriteByte(byte(len(b)))
+		buf.Write(b)
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+// truncateUTF8 قص النص إلى عدد بايتات محدد دون كسر حرف متعدد البايتات
+func truncateUTF8(s string, maxBytes int) string {
+	if len(s) <= maxBytes {
+		return s
+	}
+	cut := 0
+	for i := range s {
+		if i > maxBytes {
+			break
+		}
+		cut = i
+	}
+	return s[:cut]
+}
+
+// allocateAmount توزيع مبلغ على أوزان بالهللة بحيث يساوي المجموع المبلغ تماماً
+// فرق التقريب يُضاف إلى آخر عنصر.
+func allocateAmount(total float64, weights []float64) []float64 {
+	shares := make([]float64, len(weights))
+	if len(weights) == 0 {
+		return shares
+	}
+
+	var sum float64
+	for _, w := range weights {
+		sum += w
+	}
+
+	cents := int64(math.Round(total * 100))
+	var allocated int64
+	for i, w := range weights {
+		if i == len(weights)-1 {
+			break
+		}
+		ratio := 1 / float64(len(weights))
+		if sum != 0 {
+			ratio = w / sum
+		}
+		c := int64(math.Round(float64(cents) * ratio))
+		shares[i] = float64(c) / 100
+		allocated += c
+	}
+	shares[len(shares)-1] = float64(cents-allocated) / 100
+	return shares
+}
+
+// InvoiceEligible التحقق من أن الطلب مدفوع أو مُسلَّم فتصدر له فاتورة
+func InvoiceEligible(order *models.Order) bool {
+	if order.Status == models.OrderStatusDelivered {
+		return true
+	}
+	if order.Status == models.OrderStatusCancelled {
+		return false
+	}
+	switch order.PaymentStatus {
+	case models.PaymentStatusPaid, models.PaymentStatusPartiallyRefunded, models.PaymentStatusRefunded:
+		return true
+	}
+	return false
+}
+
+// invoiceTypeFor نوع الفاتورة حسب المشتري: ضريبية للمنشآت ومبسطة للأفراد
+func invoiceTypeFor(buyer *models.User) models.InvoiceType {
+	if buyer != nil && (buyer.VATNumber != "" || buyer.AccountType == models.WholesaleAccount) {
+		return models.InvoiceTypeTax
+	}
+	return models.InvoiceTypeSimplified
+}
+
+func formatInvoiceAddress(a *models.Address) string {
+	if a == nil {
+		return ""
+	}
+	var parts []string
+	for _, p := range []string{a.AddressLine1, a.District, a.City, a.PostalCode, a.Country} {
+		if p = strings.TrimSpace(p); p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, "، ")
+}
+
+// BuildOrderInvoice تجهيز فاتورة الطلب من بياناته دون حفظها
+// ضريبة الطلب وإجماليه هما المرجع؛ يوزع كل منهما على الأسطر حسب قيمة العنصر
+// فيطابق مجموع الأسطر ما دفعه العميل سواء كانت الأسعار شاملة الضريبة أم لا.
+// الشحن سطر مستقل خاضع للنسبة الأساسية كما في تسعير الطلب، يأخذ حصته من الإجمالي والضريبة
+// بنسبة قيمته إلى وعاء الضريبة.
+func BuildOrderInvoice(order *models.Order, items []models.OrderItem, buyer *models.User, seller SellerSettings, taxRate float64) *models.Invoice {
+	invoice := &models.Invoice{
+		DocumentType:    models.InvoiceDocumentInvoice,
+		InvoiceType:     invoiceTypeFor(buyer),
+		OrderID:         order.ID,
+		OrderNumber:     order.OrderNumber,
+		UserID:          order.UserID,
+		SellerName:      seller.Name,
+		SellerVATNumber: seller.VATNumber,
+		SellerAddress:   seller.Address,
+		Currency:        seller.Currency,
+		TaxRate:         taxRate,
+		DiscountAmount:  RoundMoney(order.DiscountAmount),
+		ShippingAmount:  RoundMoney(order.ShippingCost),
+		TaxAmount:       RoundMoney(order.TaxAmount),
+		TotalAmount:     RoundMoney(order.TotalAmount),
+	}
+
+	address := order.BillingAddress
+	if address == nil {
+		address = &order.ShippingAddress
+	}
+	invoice.BuyerAddress = formatInvoiceAddress(address)
+	if buyer != nil {
+		invoice.BuyerName = buyer.FullName
+		if invoice.InvoiceType == models.InvoiceTypeTax && buyer.CompanyName != "" {
+			invoice.BuyerName = buyer.CompanyName
+		}
+		invoice.BuyerVATNumber = buyer.VATNumber
+	}
+
+	// تقسيم الإجمالي والضريبة بين الأصناف بعد الخصم والشحن، ثم بين الأصناف حسب قيمتها
+	base := []float64{order.Subtotal - order.DiscountAmount, invoice.ShippingAmount}
+	totalSplit := allocateAmount(invoice.TotalAmount, base)
+	taxSplit := allocateAmount(invoice.TaxAmount, base)
+
+	weights := make([]float64, len(items))
+	for i, item := range items {
+		weights[i] = item.TotalPrice
+	}
+	grossShares := allocateAmount(totalSplit[0], weights)
+	taxShares := allocateAmount(taxSplit[0], weights)
+
+	lineRate := taxRate
+	if invoice.TaxAmount == 0 {
+		lineRate = 0
+	}
+	for i, item := range items {
+		itemID, productID := item.ID, item.ProductID
+		invoice.Lines = append(invoice.Lines, newInvoiceLine(&itemID, &productID, item.Name, item.Quantity, grossShares[i], taxShares[i], lineRate, i))
+	}
+	if invoice.ShippingAmount > 0 {
+		invoice.Lines = append(invoice.Lines, newInvoiceLine(nil, nil, "الشحن", 1, totalSplit[1], taxSplit[1], lineRate, len(items)))
+	}
+
+	invoice.NetAmount = RoundMoney(invoice.TotalAmount - invoice.TaxAmount)
+	return invoice
+}
+
+// BuildCreditNote تجهيز إشعار دائن لمبلغ مسترد من طلب إرجاع دون حفظه
+// يُستخرج جزء الضريبة من المبلغ المسترد بنسبة ضريبة العناصر في الفاتورة الأصلية،
+// وما يتجاوز قيمة العناصر المرتجعة يُعد استرداداً للشحن بنسبة ضريبة سطر الشحن فيها.
+func BuildCreditNote(original *models.Invoice, ret *models.ReturnRequest, refund float64) *models.Invoice {
+	note := &models.Invoice{
+		DocumentType:      models.InvoiceDocumentCreditNote,
+		InvoiceType:       original.InvoiceType,
+		OrderID:           original.OrderID,
+		OrderNumber:       original.OrderNumber,
+		UserID:            original.UserID,
+		OriginalInvoiceID: &original.ID,
+		OriginalNumber:    original.InvoiceNumber,
+		ReturnRequestID:   &ret.ID,
+		Reason:            fmt.Sprintf("استرداد طلب الإرجاع %s", ret.ReturnNumber),
+		SellerName:        original.SellerName,
+		SellerVATNumber:   original.SellerVATNumber,
+		SellerAddress:     original.SellerAddress,
+		BuyerName:         original.BuyerName,
+		BuyerVATNumber:    original.BuyerVATNumber,
+		BuyerAddress:      original.BuyerAddress,
+		Currency:          original.Currency,
+		TaxRate:           original.TaxRate,
+		TotalAmount:       RoundMoney(refund),
+	}
+
+	var goodsGross, goodsTax, shippingGross, shippingTax float64
+	for _, line := range original.Lines {
+		if line.OrderItemID != nil {
+			goodsGross += line.TotalAmount
+			goodsTax += line.TaxAmount
+		} else {
+			shippingGross += line.TotalAmount
+			shippingTax += line.TaxAmount
+		}
+	}
+	vatFraction := 0.0
+	if goodsGross > 0 {
+		vatFraction = goodsTax / goodsGross
+	}
+	shippingFraction := vatFraction
+	if shippingGross > 0 {
+		shippingFraction = shippingTax / shippingGross
+	}
+
+	var itemsValue float64
+	weights := make([]float64, len(ret.Items))
+	for i, item := range ret.Items {
+		weights[i] = item.RefundableAmount
+		itemsValue += item.RefundableAmount
+	}
+	goodsRefund := math.Min(note.TotalAmount, RoundMoney(itemsValue))
+	goodsRefundTax := RoundMoney(goodsRefund * vatFraction)
+
+	grossShares := allocateAmount(goodsRefund, weights)
+	taxShares := allocateAmount(goodsRefundTax, weights)
+	lineRate := original.TaxRate
+	if goodsRefundTax == 0 {
+		lineRate = 0
+	}
+	for i, item := range ret.Items {
+		itemID, productID := item.OrderItemID, item.ProductID
+		note.Lines = append(note.Lines, newInvoiceLine(&itemID, &productID, item.Name, item.Quantity, grossShares[i], taxShares[i], lineRate, i))
+	}
+	note.TaxAmount = goodsRefundTax
+	if shipping := RoundMoney(note.TotalAmount - goodsRefund); shipping > 0 {
+		shippingRefundTax := RoundMoney(shipping * shippingFraction)
+		shippingRate := original.TaxRate
+		if shippingRefundTax == 0 {
+			shippingRate = 0
+		}
+		note.ShippingAmount = shipping
+		note.TaxAmount = RoundMoney(note.TaxAmount + shippingRefundTax)
+		note.Lines = append(note.Lines, newInvoiceLine(nil, nil, "الشحن", 1, shipping, shippingRefundTax, shippingRate, len(ret.Items)))
+	}
+
+	note.NetAmount = RoundMoney(note.TotalAmount - note.TaxAmount)
+	return note
+}
+
+func newInvoiceLine(orderItemID, productID *uuid.UUID, description string, quantity int, gross, tax, rate float64, sortOrder int) models.InvoiceLine {
+	net := RoundMoney(gross - tax)
+	unitPrice := net
+	if quantity > 0 {
+		unitPrice = RoundMoney(net / float64(quantity))
+	}
+	return models.InvoiceLine{
+		OrderItemID: orderItemID,
+		ProductID:   productID,
+		Description: description,
+		Quantity:    quantity,
+		UnitPrice:   unitPrice,
+		NetAmount:   net,
+		TaxRate:     rate,
+		TaxAmount:   RoundMoney(tax),
+		TotalAmount: RoundMoney(gross),
+		SortOrder:   sortOrder,
+	}
+}
+
+// issueDocument ترقيم المستند وختمه برمز QR ثم حفظه مع أسطره
+func issueDocument(tx *gorm.DB, doc *models.Invoice) error {
+	doc.IssuedAt = time.Now()
+	document, prefixEnv, defaultPrefix := DocumentInvoice, "INVOICE_PREFIX", "INV"
+	if doc.IsCreditNote() {
+		document, prefixEnv, defaultPrefix = DocumentCreditNote, "CREDIT_NOTE_PREFIX", "CRN"
+	}
+	number, err := nextDocumentNumber(tx, document, prefixEnv, defaultPrefix, doc.IssuedAt)
+	if err != nil {
+		return err
+	}
+	doc.InvoiceNumber = number
+	doc.QRCode = EncodeInvoiceQR(doc.SellerName, doc.SellerVATNumber, doc.IssuedAt, doc.TotalAmount, doc.TaxAmount)
+	return tx.Create(doc).Error
+}
+
+// FindOrderInvoice الحصول على فاتورة الطلب مع أسطرها إن وُجدت
+func FindOrderInvoice(db *gorm.DB, orderID uuid.UUID) (*models.Invoice, error) {
+	var invoice models.Invoice
+	err := db.Preload("Lines", func(db *gorm.DB) *gorm.DB { return db.Order("sort_order ASC") }).
+		Where("order_id = ? AND document_type = ?", orderID, models.InvoiceDocumentInvoice).
+		First(&invoice).Error
+	if err != nil {
+		return nil, err
+	}
+	return &invoice, nil
+}
+
+// IssueOrderInvoice إصدار فاتورة الطلب إذا كان مدفوعاً أو مُسلَّماً
+// تعيد الفاتورة الموجودة إن سبق إصدارها، ويُقفل صف الطلب حتى لا تصدر فاتورتان.
+func IssueOrderInvoice(tx *gorm.DB, order *models.Order) (*models.Invoice, error) {
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(order, "id = ?", order.ID).Error; err != nil {
+		return nil, err
+	}
+
+	existing, err := FindOrderInvoice(tx, order.ID)
+	if err == nil {
+		return existing, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if !InvoiceEligible(order) {
+		return nil, fmt.Errorf("order %s is %s/%s: %w", order.OrderNumber, order.Status, order.PaymentStatus, ErrInvoiceNotEligible)
+	}
+	seller := LoadSellerSettings()
+	if seller.VATNumber == "" {
+		return nil, ErrSellerVATMissing
+	}
+
+	var items []models.OrderItem
+	if err := tx.Where("order_id = ?", order.ID).Order("created_at ASC").Find(&items).Error; err != nil {
+		return nil, err
+	}
+	var buyer models.User
+	if err := tx.First(&buyer, "id = ?", order.UserID).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	invoice := BuildOrderInvoice(order, items, &buyer, seller, LoadPricingSettings().TaxRate)
+	if err := issueDocument(tx, invoice); err != nil {
+		return nil, err
+	}
+	return invoice, nil
+}
+
+// IssueCreditNote إصدار إشعار دائن لمبلغ مسترد من طلب إرجاع
+// تُصدر فاتورة الطلب أولاً إن لم تكن قد صدرت (طلبات سُلمت قبل تفعيل الفوترة).
+func IssueCreditNote(tx *gorm.DB, ret *models.ReturnRequest, refund float64) (*models.Invoice, error) {
+	order := models.Order{ID: ret.OrderID}
+	original, err := IssueOrderInvoice(tx, &order)
+	if err != nil {
+		if errors.Is(err, ErrInvoiceNotEligible) {
+			return nil, fmt.Errorf("%s: %w", order.OrderNumber, ErrInvoiceMissing)
+		}
+		return nil, err
+	}
+
+	note := BuildCreditNote(original, ret, refund)
+	if err := issueDocument(tx, note); err != nil {
+		return nil, err
+	}
+	return note, nil
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"pharmacy-backend/models"
+)
+
+func TestEncodeInvoiceQR(t *testing.T) {
+	issuedAt := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	payload := EncodeInvoiceQR("صيدلية", "300000000000003", issuedAt, 115, 15)
+
+	raw, err := base64.StdEncoding.DecodeString(payload)
+	require.NoError(t, err)
+
+	var values []string
+	for i := 0; i < len(raw); {
+		tag, length := raw[i], int(raw[i+1])
+		assert.Equal(t, byte(len(values)+1), tag)
+		values = append(values, string(raw[i+2:i+2+length]))
+		i += 2 + length
+	}
+	assert.Equal(t, []string{"صيدلية", "300000000000003", "2024-03-01T12:30:00Z", "115.00", "15.00"}, values)
+}
+
+func TestBuildOrderInvoiceReconcilesWithOrder(t *testing.T) {
+	// أسعار شاملة الضريبة مع خصم كوبون وشحن
+	order := &models.Order{
+		ID:             uuid.New(),
+		OrderNumber:    "ORD-1",
+		Subtotal:       100,
+		DiscountAmount: 10,
+		ShippingCost:   15,
+		TaxAmount:      13.70,
+		TotalAmount:    105,
+	}
+	items := []models.OrderItem{
+		{ID: uuid.New(), Name: "A", Quantity: 3, UnitPrice: 10, TotalPrice: 30},
+		{ID: uuid.New(), Name: "B", Quantity: 1, UnitPrice: 70, TotalPrice: 70},
+	}
+	buyer := &models.User{FullName: "Customer"}
+
+	inv := BuildOrderInvoice(order, items, buyer, SellerSettings{Name: "Pharmacy", VATNumber: "300000000000003", Currency: "SAR"}, 0.15)
+
+	assert.Equal(t, models.InvoiceTypeSimplified, inv.InvoiceType)
+	require.Len(t, inv.Lines, 3)
+	var net, tax, total float64
+	for _, line := range inv.Lines {
+		assert.InDelta(t, line.TotalAmount, line.NetAmount+line.TaxAmount, 0.001)
+		net += line.NetAmount
+		tax += line.TaxAmount
+		total += line.TotalAmount
+	}
+	assert.InDelta(t, 13.70, tax, 0.001)
+	assert.InDelta(t, 105, total, 0.001)
+	assert.InDelta(t, inv.NetAmount, net, 0.001)
+
+	// الشحن توريد خاضع للنسبة الأساسية وليس صفرياً
+	shipping := inv.Lines[2]
+	assert.Nil(t, shipping.OrderItemID)
+	assert.InDelta(t, 15, shipping.TotalAmount, 0.001)
+	assert.InDelta(t, 1.96, shipping.TaxAmount, 0.001)
+	assert.Equal(t, 0.15, shipping.TaxRate)
+
+	body, err := RenderInvoiceXML(inv)
+	require.NoError(t, err)
+	assert.NotContains(t, string(body), "<cbc:ID>Z</cbc:ID>")
+
+	buyer.AccountType = models.WholesaleAccount
+	buyer.CompanyName = "Clinic LLC"
+	inv = BuildOrderInvoice(order, items, buyer, SellerSettings{}, 0.15)
+	assert.Equal(t, models.InvoiceTypeTax, inv.InvoiceType)
+	assert.Equal(t, "Clinic LLC", inv.BuyerName)
+}
+
+func TestBuildCreditNoteSplitsVATAndShipping(t *testing.T) {
+	itemID := uuid.New()
+	original := &models.Invoice{
+		ID:            uuid.New(),
+		InvoiceNumber: "INV-2024-000001",
+		TaxRate:       0.15,
+		Lines: []models.InvoiceLine{
+			{OrderItemID: &itemID, NetAmount: 100, TaxAmount: 15, TotalAmount: 115},
+			{NetAmount: 13.04, TaxAmount: 1.96, TotalAmount: 15},
+		},
+	}
+	ret := &models.ReturnRequest{
+		ID:           uuid.New(),
+		ReturnNumber: "RMA-1",
+		Items:        []models.ReturnItem{{OrderItemID: itemID, Name: "A", Quantity: 1, RefundableAmount: 115}},
+	}
+
+	note := BuildCreditNote(original, ret, 130)
+
+	assert.Equal(t, models.InvoiceDocumentCreditNote, note.DocumentType)
+	assert.Equal(t, "INV-2024-000001", note.OriginalNumber)
+	assert.InDelta(t, 16.96, note.TaxAmount, 0.001)
+	assert.InDelta(t, 15, note.ShippingAmount, 0.001)
+	assert.InDelta(t, 113.04, note.NetAmount, 0.001)
+	require.Len(t, note.Lines, 2)
+	assert.InDelta(t, 1.96, note.Lines[1].TaxAmount, 0.001)
+	assert.Equal(t, 0.15, note.Lines[1].TaxRate)
+
+	body, err := RenderInvoiceXML(note)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `<cbc:InvoiceTypeCode name="0200000">381</cbc:InvoiceTypeCode>`)
+	assert.Contains(t, string(body), "<cbc:ID>INV-2024-000001</cbc:ID>")
+	assert.NotContains(t, string(body), "<cbc:ID>Z</cbc:ID>")
+}
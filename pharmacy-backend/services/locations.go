@@ -0,0 +1,349 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"pharmacy-backend/models"
+)
+
+// أخطاء الفروع
+var (
+	ErrLocationNotFound  = errors.New("location not found")
+	ErrLocationInactive  = errors.New("location is inactive")
+	ErrInvalidLocation   = errors.New("location requires a code, name and city")
+	ErrNoDefaultLocation = errors.New("no active default location is configured")
+	ErrLocationInUse     = errors.New("location still holds stock or documents; deactivate it instead")
+)
+
+// IsLocationError التحقق مما إذا كان الخطأ من أخطاء الفروع
+func IsLocationError(err error) bool {
+	return errors.Is(err, ErrLocationNotFound) ||
+		errors.Is(err, ErrLocationInactive) ||
+		errors.Is(err, ErrInvalidLocation) ||
+		errors.Is(err, ErrNoDefaultLocation) ||
+		errors.Is(err, ErrLocationInUse)
+}
+
+// ValidateLocation التحقق من بيانات الفرع وتنظيفها
+func ValidateLocation(location *models.Location) error {
+	location.Code = strings.ToUpper(strings.TrimSpace(location.Code))
+	location.Name = strings.TrimSpace(location.Name)
+	location.City = strings.TrimSpace(location.City)
+	if location.Code == "" || location.Name == "" || location.City == "" {
+		return ErrInvalidLocation
+	}
+	var cities []string
+	for _, city := range strings.Split(location.ServedCities, ",") {
+		if city = strings.TrimSpace(city); city != "" {
+			cities = append(cities, city)
+		}
+	}
+	location.ServedCities = strings.Join(cities, ", ")
+	return nil
+}
+
+// SaveLocation حفظ الفرع؛ جعله افتراضياً يلغي الافتراضي السابق
+func SaveLocation(tx *gorm.DB, location *models.Location) error {
+	if err := ValidateLocation(location); err != nil {
+		return err
+	}
+	if location.IsDefault {
+		if !location.IsActive {
+			return fmt.Errorf("%w: the default location must be active", ErrInvalidLocation)
+		}
+		if err := tx.Model(&models.Location{}).Where("is_default = ? AND id <> ?", true, location.ID).
+			Update("is_default", false).Error; err != nil {
+			return err
+		}
+	}
+	if location.ID == uuid.Nil {
+		return tx.Create(location).Error
+	}
+	location.UpdatedAt = time.Now()
+	return tx.Model(location).
+		Select("code", "name", "city", "address", "phone", "served_cities", "priority", "is_default", "is_active", "updated_at").
+		Updates(location).Error
+}
+
+// DeleteLocation حذف فرع لم تُسجل عليه دفعات أو تحويلات أو طلبات
+func DeleteLocation(tx *gorm.DB, id uuid.UUID) error {
+	var location models.Location
+	if err := tx.First(&location, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrLocationNotFound
+		}
+		return err
+	}
+	for _, check := range []struct {
+		model interface{}
+		where string
+	}{
+		{&models.ProductBatch{}, "location_id = ?"},
+		{&models.StockTransfer{}, "from_location_id = ? OR to_location_id = ?"},
+		{&models.Order{}, "location_id = ?"},
+	} {
+		var count int64
+		args := []interface{}{id}
+		if strings.Count(check.where, "?") == 2 {
+			args = append(args, id)
+		}
+		if err := tx.Model(check.model).Where(check.where, args...).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrLocationInUse
+		}
+	}
+	if location.IsDefault {
+		return ErrLocationInUse
+	}
+	return tx.Delete(&location).Error
+}
+
+// DefaultLocationID الفرع الذي يستقبل المخزون غير المحدد فرعه
+func DefaultLocationID(tx *gorm.DB) (uuid.UUID, error) {
+	var location models.Location
+	err := tx.Select("id").Where("is_active = ?", true).
+		Order("is_default DESC, priority ASC, created_at ASC").
+		First(&location).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return uuid.Nil, ErrNoDefaultLocation
+	}
+	return location.ID, err
+}
+
+// resolveLocationID الفرع المحدد بعد التحقق من نشاطه، أو الفرع الافتراضي
+func resolveLocationID(tx *gorm.DB, id *uuid.UUID) (uuid.UUID, error) {
+	if id == nil {
+		return DefaultLocationID(tx)
+	}
+	var location models.Location
+	if err := tx.Select("id", "is_active").First(&location, "id = ?", *id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return uuid.Nil, ErrLocationNotFound
+		}
+		return uuid.Nil, err
+	}
+	if !location.IsActive {
+		return uuid.Nil, ErrLocationInactive
+	}
+	return location.ID, nil
+}
+
+// rankLocations ترتيب الفروع حسب قربها من مدينة العميل
+// فرع في المدينة نفسها أولاً، ثم فرع يخدمها، ثم البقية بالأولوية ثم الافتراضي ثم الاسم.
+func rankLocations(locations []models.Location, city string) []models.Location {
+	city = models.NormalizeCity(city)
+	distance := func(l *models.Location) int {
+		switch {
+		case city != "" && models.NormalizeCity(l.City) == city:
+			return 0
+		case l.Serves(city):
+			return 1
+		}
+		return 2
+	}
+	ranked := append([]models.Location(nil), locations...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		a, b := &ranked[i], &ranked[j]
+		if da, db := distance(a), distance(b); da != db {
+			return da < db
+		}
+		if a.Priority != b.Priority {
+			return a.Priority < b.Priority
+		}
+		if a.IsDefault != b.IsDefault {
+			return a.IsDefault
+		}
+		return a.Name < b.Name
+	})
+	return ranked
+}
+
+// fulfilmentPreference معرفات الفروع النشطة مرتبة حسب قربها من المدينة
+func fulfilmentPreference(tx *gorm.DB, city string) ([]uuid.UUID, error) {
+	var locations []models.Location
+	if err := tx.Where("is_active = ?", true).Find(&locations).Error; err != nil {
+		return nil, err
+	}
+	ranked := rankLocations(locations, city)
+	ids := make([]uuid.UUID, 0, len(ranked))
+	for _, location := range ranked {
+		ids = append(ids, location.ID)
+	}
+	return ids, nil
+}
+
+// orderLocationPreference ترتيب فروع صرف زيادة في طلب قائم: فرع الطلب أولاً ثم الأقرب لمدينته
+func orderLocationPreference(tx *gorm.DB, order *models.Order) ([]uuid.UUID, error) {
+	preference, err := fulfilmentPreference(tx, order.ShippingAddress.City)
+	if err != nil || order.LocationID == nil {
+		return preference, err
+	}
+	return preferLocation(preference, *order.LocationID), nil
+}
+
+// preferLocation نقل فرع إلى بداية الترتيب
+// الفرع غير الموجود في الترتيب (أوقف بعد الطلب) لا يُضاف.
+func preferLocation(preference []uuid.UUID, id uuid.UUID) []uuid.UUID {
+	ordered := []uuid.UUID{id}
+	found := false
+	for _, other := range preference {
+		if other != id {
+			ordered = append(ordered, other)
+		} else {
+			found = true
+		}
+	}
+	if !found {
+		return preference
+	}
+	return ordered
+}
+
+// locationAvailability الكمية القابلة للبيع من كل منتج في كل فرع
+// المنتج الذي لا دفعات له لا يظهر لأن مخزونه غير موزع على الفروع.
+func locationAvailability(tx *gorm.DB, productIDs []uuid.UUID, at time.Time) (map[uuid.UUID]map[uuid.UUID]int, map[uuid.UUID]bool, error) {
+	var rows []struct {
+		LocationID *uuid.UUID
+		ProductID  uuid.UUID
+		Quantity   int
+	}
+	if err := tx.Model(&models.ProductBatch{}).
+		Select("location_id, product_id, COALESCE(SUM(quantity) FILTER (WHERE quantity > 0 AND (expiry_date IS NULL OR expiry_date > ?)), 0) AS quantity", at).
+		Where("product_id IN ?", productIDs).
+		Group("location_id, product_id").
+		Scan(&rows).Error; err != nil {
+		return nil, nil, err
+	}
+	available := map[uuid.UUID]map[uuid.UUID]int{}
+	tracked := map[uuid.UUID]bool{}
+	for _, row := range rows {
+		tracked[row.ProductID] = true
+		if row.LocationID == nil {
+			continue
+		}
+		if available[*row.LocationID] == nil {
+			available[*row.LocationID] = map[uuid.UUID]int{}
+		}
+		available[*row.LocationID][row.ProductID] += row.Quantity
+	}
+	return available, tracked, nil
+}
+
+// chooseFulfilmentLocation أول فرع بالترتيب يكفي مخزونه الطلب كاملاً
+// إذا لم يكفِ أي فرع وحده يُعاد فارغ ويوزع الطلب على الفروع بالترتيب.
+func chooseFulfilmentLocation(ranked []uuid.UUID, available map[uuid.UUID]map[uuid.UUID]int, needs map[uuid.UUID]int) *uuid.UUID {
+	for _, location := range ranked {
+		enough := true
+		for product, quantity := range needs {
+			if available[location][product] < quantity {
+				enough = false
+				break
+			}
+		}
+		if enough {
+			id := location
+			return &id
+		}
+	}
+	return nil
+}
+
+// LocationStockRow مخزون منتج في فرع
+type LocationStockRow struct {
+	LocationID    uuid.UUID  `json:"location_id"`
+	LocationCode  string     `json:"location_code"`
+	LocationName  string     `json:"location_name"`
+	ProductID     uuid.UUID  `json:"product_id"`
+	SKU           string     `json:"sku"`
+	Name          string     `json:"name"`
+	Quantity      int        `json:"quantity"` // القابل للبيع
+	ExpiredQty    int        `json:"expired_quantity"`
+	MinStockLevel int        `json:"min_stock_level"`
+	NextExpiry    *time.Time `json:"next_expiry,omitempty"`
+}
+
+// locationStockQuery مخزون الفروع مجمعاً لكل منتج في كل فرع
+func locationStockQuery(db *gorm.DB, at time.Time) *gorm.DB {
+	sellable := "b.quantity > 0 AND (b.expiry_date IS NULL OR b.expiry_date > @at)"
+	return db.Table("product_batches AS b").
+		Select("l.id AS location_id, l.code AS location_code, l.name AS location_name, "+
+			"p.id AS product_id, p.sku, p.name, p.min_stock_level, "+
+			"COALESCE(SUM(b.quantity) FILTER (WHERE "+sellable+"), 0) AS quantity, "+
+			"COALESCE(SUM(b.quantity) FILTER (WHERE b.quantity > 0 AND b.expiry_date <= @at), 0) AS expired_qty, "+
+			"MIN(b.expiry_date) FILTER (WHERE "+sellable+") AS next_expiry", map[string]interface{}{"at": at}).
+		Joins("JOIN locations l ON l.id = b.location_id").
+		Joins("JOIN products p ON p.id = b.product_id").
+		Group("l.id, l.code, l.name, p.id, p.sku, p.name, p.min_stock_level")
+}
+
+// LocationStock مخزون كل منتج في فرع واحد
+func LocationStock(db *gorm.DB, locationID uuid.UUID, at time.Time) ([]LocationStockRow, error) {
+	rows := []LocationStockRow{}
+	err := locationStockQuery(db, at).
+		Where("b.location_id = ?", locationID).
+		Having("SUM(b.quantity) > 0").
+		Order("p.name ASC").
+		Scan(&rows).Error
+	return rows, err
+}
+
+// LocationLowStock المنتجات النشطة التي بلغ مخزونها في الفرع حدها الأدنى
+// الحد الأدنى للمنتج يُطبق على كل فرع، والمنتج الذي نفد من فرع سبق أن استلمه يظهر بكمية صفر.
+func LocationLowStock(db *gorm.DB, locationID *uuid.UUID, at time.Time) ([]LocationStockRow, error) {
+	query := locationStockQuery(db, at).
+		Where("p.is_active = ?", true).
+		Having("COALESCE(SUM(b.quantity) FILTER (WHERE b.quantity > 0 AND (b.expiry_date IS NULL OR b.expiry_date > ?)), 0) <= p.min_stock_level", at)
+	if locationID != nil {
+		query = query.Where("b.location_id = ?", *locationID)
+	}
+	rows := []LocationStockRow{}
+	err := query.Order("l.name ASC, quantity ASC").Scan(&rows).Error
+	return rows, err
+}
+
+// LocationExpiryRow دفعة منتهية أو قريبة الانتهاء في فرع
+type LocationExpiryRow struct {
+	LocationID   uuid.UUID `json:"location_id"`
+	LocationCode string    `json:"location_code"`
+	LocationName string    `json:"location_name"`
+	ProductID    uuid.UUID `json:"product_id"`
+	SKU          string    `json:"sku"`
+	Name         string    `json:"name"`
+	BatchID      uuid.UUID `json:"batch_id"`
+	BatchNumber  string    `json:"batch_number"`
+	ExpiryDate   time.Time `json:"expiry_date"`
+	Quantity     int       `json:"quantity"`
+	UnitCost     float64   `json:"unit_cost"`
+	Value        float64   `json:"value"`
+	DaysLeft     int       `json:"days_left"` // سالب للمنتهية
+}
+
+// LocationExpiry الدفعات التي فيها كمية وتنتهي خلال عدد أيام، بما فيها المنتهية
+func LocationExpiry(db *gorm.DB, locationID *uuid.UUID, days int, at time.Time) ([]LocationExpiryRow, error) {
+	query := db.Table("product_batches AS b").
+		Select("l.id AS location_id, l.code AS location_code, l.name AS location_name, p.id AS product_id, p.sku, p.name, "+
+			"b.id AS batch_id, b.batch_number, b.expiry_date, b.quantity, b.unit_cost").
+		Joins("JOIN locations l ON l.id = b.location_id").
+		Joins("JOIN products p ON p.id = b.product_id").
+		Where("b.quantity > 0 AND b.expiry_date IS NOT NULL AND b.expiry_date <= ?", at.AddDate(0, 0, days))
+	if locationID != nil {
+		query = query.Where("b.location_id = ?", *locationID)
+	}
+	rows := []LocationExpiryRow{}
+	if err := query.Order("b.expiry_date ASC, l.name ASC").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	for i := range rows {
+		rows[i].Value = RoundMoney(rows[i].UnitCost * float64(rows[i].Quantity))
+		rows[i].DaysLeft = daysUntil(rows[i].ExpiryDate, at)
+	}
+	return rows, nil
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"pharmacy-backend/models"
+)
+
+func TestLocationServes(t *testing.T) {
+	location := models.Location{City: "Riyadh", ServedCities: "Al Kharj,  Diriyah "}
+	assert.True(t, location.Serves("riyadh"))
+	assert.True(t, location.Serves(" diriyah"))
+	assert.True(t, location.Serves("AL  KHARJ"))
+	assert.False(t, location.Serves("Jeddah"))
+	assert.False(t, location.Serves(""))
+}
+
+func TestRankLocations(t *testing.T) {
+	main := models.Location{ID: uuid.New(), Name: "Main", City: "Riyadh", Priority: 100, IsDefault: true}
+	jeddah := models.Location{ID: uuid.New(), Name: "Jeddah", City: "Jeddah", Priority: 50, ServedCities: "Makkah"}
+	dammam := models.Location{ID: uuid.New(), Name: "Dammam", City: "Dammam", Priority: 10}
+	all := []models.Location{main, jeddah, dammam}
+
+	names := func(ranked []models.Location) []string {
+		var out []string
+		for _, l := range ranked {
+			out = append(out, l.Name)
+		}
+		return out
+	}
+	// فرع المدينة أولاً ثم البقية بالأولوية
+	assert.Equal(t, []string{"Main", "Dammam", "Jeddah"}, names(rankLocations(all, "riyadh")))
+	// الفرع الذي يخدم المدينة يسبق الأعلى أولوية
+	assert.Equal(t, []string{"Jeddah", "Dammam", "Main"}, names(rankLocations(all, "Makkah")))
+	// مدينة لا يخدمها أحد: الأولوية وحدها
+	assert.Equal(t, []string{"Dammam", "Jeddah", "Main"}, names(rankLocations(all, "Abha")))
+	assert.Equal(t, "Main", all[0].Name, "input must not be reordered")
+}
+
+func TestChooseFulfilmentLocation(t *testing.T) {
+	near, far := uuid.New(), uuid.New()
+	a, b := uuid.New(), uuid.New()
+	available := map[uuid.UUID]map[uuid.UUID]int{
+		near: {a: 5, b: 1},
+		far:  {a: 10, b: 10},
+	}
+
+	// الفرع الأقرب لا يكفي b فيُختار الأبعد الذي يكفي الطلب كاملاً
+	chosen := chooseFulfilmentLocation([]uuid.UUID{near, far}, available, map[uuid.UUID]int{a: 2, b: 2})
+	require.NotNil(t, chosen)
+	assert.Equal(t, far, *chosen)
+
+	chosen = chooseFulfilmentLocation([]uuid.UUID{near, far}, available, map[uuid.UUID]int{a: 5, b: 1})
+	require.NotNil(t, chosen)
+	assert.Equal(t, near, *chosen)
+
+	// لا فرع يكفي وحده: يوزع الطلب
+	assert.Nil(t, chooseFulfilmentLocation([]uuid.UUID{near, far}, available, map[uuid.UUID]int{a: 12}))
+	assert.Equal(t, []uuid.UUID{far, near}, preferLocation([]uuid.UUID{near, far}, far))
+	// فرع الطلب الموقوف لا يعود إلى الترتيب
+	assert.Equal(t, []uuid.UUID{near, far}, preferLocation([]uuid.UUID{near, far}, uuid.New()))
+}
+
+func TestPlanLocationFEFO(t *testing.T) {
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	soon, late := now.AddDate(0, 2, 0), now.AddDate(1, 0, 0)
+	near, far := uuid.New(), uuid.New()
+	batches := []models.ProductBatch{
+		{ID: uuid.New(), BatchNumber: "FAR-SOON", ExpiryDate: &soon, Quantity: 10, LocationID: &far},
+		{ID: uuid.New(), BatchNumber: "NEAR-LATE", ExpiryDate: &late, Quantity: 3, LocationID: &near},
+	}
+
+	// الفرع المفضل يُستنفد أولاً ولو كانت دفعته أبعد انتهاءً
+	plan := PlanLocationFEFO(batches, 5, now, []uuid.UUID{near, far})
+	require.Len(t, plan, 2)
+	assert.Equal(t, "NEAR-LATE", plan[0].BatchNumber)
+	assert.Equal(t, 3, plan[0].Quantity)
+	assert.Equal(t, near, *plan[0].LocationID)
+	assert.Equal(t, "FAR-SOON", plan[1].BatchNumber)
+	assert.Equal(t, 2, plan[1].Quantity)
+
+	// بدون ترتيب تُعامل الفروع كمخزون واحد
+	plan = PlanLocationFEFO(batches, 5, now, nil)
+	require.Len(t, plan, 1)
+	assert.Equal(t, "FAR-SOON", plan[0].BatchNumber)
+
+	// فرع خارج الترتيب (موقوف) لا يُصرف منه ولو لم يكفِ غيره
+	plan = PlanLocationFEFO(batches, 5, now, []uuid.UUID{near})
+	require.Len(t, plan, 1)
+	assert.Equal(t, "NEAR-LATE", plan[0].BatchNumber)
+	assert.Equal(t, 3, plan[0].Quantity)
+	assert.Empty(t, PlanLocationFEFO(batches, 5, now, []uuid.UUID{uuid.New()}))
+}
+
+func TestDistributeReceived(t *testing.T) {
+	assert.Equal(t, []int{4, 6}, distributeReceived([]int{4, 6}, 10))
+	// النقص يُحسب على الدفعات الأخيرة
+	assert.Equal(t, []int{4, 3}, distributeReceived([]int{4, 6}, 7))
+	assert.Equal(t, []int{2, 0}, distributeReceived([]int{4, 6}, 2))
+	assert.Equal(t, []int{0, 0}, distributeReceived([]int{4, 6}, 0))
+}
+
+func TestMergeTransferLines(t *testing.T) {
+	a, b := uuid.New(), uuid.New()
+	merged, err := mergeTransferLines([]StockTransferLineInput{{a, 1}, {b, 2}, {a, 4}})
+	assert.NoError(t, err)
+	assert.Equal(t, []StockTransferLineInput{{a, 5}, {b, 2}}, merged)
+
+	_, err = mergeTransferLines([]StockTransferLineInput{{uuid.Nil, 1}})
+	assert.True(t, errors.Is(err, ErrInvalidStockTransfer))
+}
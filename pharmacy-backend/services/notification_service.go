@@ -64,6 +64,9 @@ func (ns *NotificationService) GetUserNotifications(userID uuid.UUID, limit int,
 	query = query.Where("type NOT IN (?)", []string{
 		string(models.NotificationTypeAdminWholesaleOrder),
 		string(models.NotificationTypeAdminWholesaleSubmitted),
+		string(models.NotificationTypeAdminReturnRequested),
+		string(models.NotificationTypeAdminExpiryAlert),
+		string(models.NotificationTypeAdminExpiryDigest),
 	})
 
 	if onlyUnread {
@@ -101,6 +104,9 @@ func (ns *NotificationService) GetUnreadCount(userID uuid.UUID) (int64, error) {
 		Where("type NOT IN (?)", []string{
 			string(models.NotificationTypeAdminWholesaleOrder),
 			string(models.NotificationTypeAdminWholesaleSubmitted),
+			string(models.NotificationTypeAdminReturnRequested),
+			string(models.NotificationTypeAdminExpiryAlert),
+			string(models.NotificationTypeAdminExpiryDigest),
 		}).
 		Count(&count).Error
 
@@ -181,12 +187,7 @@ func (ns *NotificationService) GetAdminNotifications(page, limit int, unreadOnly
 	var total int64
 
 	// Build query for admin notifications
-	query := ns.db.Model(&models.Notification{}).Where("type IN ?", []models.NotificationType{
-		models.NotificationTypeAdminWholesaleSubmitted,
-		models.NotificationTypeAdminOrderCreated,
-		models.NotificationTypeAdminOrderUpdated,
-		models.NotificationTypeAdminWholesaleOrder,
-	})
+	query := ns.db.Model(&models.Notification{}).Where("type IN ?", models.AdminNotificationTypes)
 
 	if unreadOnly {
 		query = query.Where("is_read = ?", false)
@@ -215,12 +216,7 @@ func (ns *NotificationService) GetAdminNotificationsWithFilter(page, limit int,
 	var total int64
 
 	// Build query for admin notifications
-	query := ns.db.Model(&models.Notification{}).Where("type IN ?", []models.NotificationType{
-		models.NotificationTypeAdminWholesaleSubmitted,
-		models.NotificationTypeAdminOrderCreated,
-		models.NotificationTypeAdminOrderUpdated,
-		models.NotificationTypeAdminWholesaleOrder,
-	})
+	query := ns.db.Model(&models.Notification{}).Where("type IN ?", models.AdminNotificationTypes)
 
 	// Add type filter if specified
 	if notificationType != "" && notificationType != "all" {
@@ -255,12 +251,7 @@ func (ns *NotificationService) GetAdminNotificationsWithFilterForUser(userID uui
 
 	// Build query for admin notifications for specific user
 	fmt.Printf("🔍 [DEBUG] Searching notifications for user_id: %s\n", userID)
-	query := ns.db.Model(&models.Notification{}).Where("user_id = ? AND type IN ?", userID, []models.NotificationType{
-		models.NotificationTypeAdminWholesaleSubmitted,
-		models.NotificationTypeAdminOrderCreated,
-		models.NotificationTypeAdminOrderUpdated,
-		models.NotificationTypeAdminWholesaleOrder,
-	})
+	query := ns.db.Model(&models.Notification{}).Where("user_id = ? AND type IN ?", userID, models.AdminNotificationTypes)
 	
 	// Debug: Check total notifications for this user
 	var debugCount int64
@@ -295,12 +286,7 @@ func (ns *NotificationService) GetAdminNotificationsWithFilterForUser(userID uui
 // GetAdminUnreadCount returns the count of unread admin notifications for a user
 func (ns *NotificationService) GetAdminUnreadCount(userID uuid.UUID) (int64, error) {
 	var count int64
-	err := ns.db.Model(&models.Notification{}).Where("user_id = ? AND is_read = ? AND type IN (?)", userID, false, []string{
-		string(models.NotificationTypeAdminOrderCreated),
-		string(models.NotificationTypeAdminOrderUpdated),
-		string(models.NotificationTypeAdminWholesaleOrder),
-		string(models.NotificationTypeAdminWholesaleSubmitted),
-	}).Count(&count).Error
+	err := ns.db.Model(&models.Notification{}).Where("user_id = ? AND is_read = ? AND type IN ?", userID, false, models.AdminNotificationTypes).Count(&count).Error
 	return count, err
 }
 
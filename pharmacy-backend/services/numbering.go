@@ -0,0 +1,148 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"pharmacy-backend/config"
+	"pharmacy-backend/models"
+)
+
+// NumberedDocument نوع المستند الذي يُصدر له رقم متسلسل
+type NumberedDocument string
+
+const (
+	DocumentOrder         NumberedDocument = "order"
+	DocumentInvoice       NumberedDocument = "invoice"
+	DocumentCreditNote    NumberedDocument = "credit_note"
+	DocumentPurchase      NumberedDocument = "purchase_invoice"
+	DocumentStocktake     NumberedDocument = "stocktake"
+	DocumentPurchaseOrder NumberedDocument = "purchase_order"
+	DocumentStockTransfer NumberedDocument = "stock_transfer"
+)
+
+// ErrInvalidNumberFormat قالب ترقيم لا ينتج أرقاماً فريدة
+var ErrInvalidNumberFormat = errors.New("number format must contain {PREFIX}, {SEQ} and {YYYY} or {YY}")
+
+// رموز قالب الترقيم
+const (
+	numberTokenPrefix = "{PREFIX}"
+	numberTokenYear   = "{YYYY}"
+	numberTokenYear2  = "{YY}"
+	numberTokenSeq    = "{SEQ}"
+)
+
+// numberPrefix متغير البيئة الذي يحمل البادئة وقيمتها الافتراضية
+type numberPrefix struct {
+	env      string
+	fallback string
+}
+
+// orderPrefixes بادئة طلبات كل قناة بيع
+var orderPrefixes = map[models.SalesChannel]numberPrefix{
+	models.SalesChannelRetail:    {"ORDER_PREFIX_RETAIL", "ORD"},
+	models.SalesChannelWholesale: {"ORDER_PREFIX_WHOLESALE", "WHS"},
+	models.SalesChannelPOS:       {"ORDER_PREFIX_POS", "POS"},
+}
+
+// NumberingSettings إعدادات ترقيم الطلبات والمستندات
+// تُقرأ من نفس متغيرات البيئة التي تعرضها إعدادات المتجر في لوحة التحكم،
+// أما بادئة كل مستند فتُقرأ عند حجز رقمه.
+type NumberingSettings struct {
+	Format        string
+	Digits        int
+	GaplessOrders bool // ترقيم الطلبات داخل معاملة الطلب (يُسلسل إنشاء الطلبات لكل قناة)
+}
+
+// LoadNumberingSettings قراءة إعدادات الترقيم الحالية
+func LoadNumberingSettings() NumberingSettings {
+	digits := int(envFloat("NUMBER_SEQUENCE_DIGITS", 6))
+	if digits < 1 || digits > 12 {
+		digits = 6
+	}
+	format := envString("NUMBER_FORMAT", "{PREFIX}-{YYYY}-{SEQ}")
+	if ValidateNumberFormat(format) != nil {
+		format = "{PREFIX}-{YYYY}-{SEQ}"
+	}
+	return NumberingSettings{
+		Format:        format,
+		Digits:        digits,
+		GaplessOrders: envBool("ORDER_NUMBERS_GAPLESS", false),
+	}
+}
+
+// ValidateNumberFormat التحقق من أن القالب يتضمن البادئة والتسلسل والسنة
+// العداد يبدأ من جديد كل سنة، فبدون السنة تتكرر الأرقام. ولكل قناة بيع عداد مستقل،
+// فبدون البادئة يحمل أول طلب تجزئة وأول طلب جملة الرقم نفسه.
+func ValidateNumberFormat(format string) error {
+	if !strings.Contains(format, numberTokenPrefix) ||
+		!strings.Contains(format, numberTokenSeq) ||
+		!(strings.Contains(format, numberTokenYear) || strings.Contains(format, numberTokenYear2)) {
+		return ErrInvalidNumberFormat
+	}
+	return nil
+}
+
+// FormatDocumentNumber تكوين الرقم المقروء من القالب والبادئة والسنة والتسلسل
+func FormatDocumentNumber(format, prefix string, year int, seq int64, digits int) string {
+	return strings.NewReplacer(
+		numberTokenPrefix, prefix,
+		numberTokenYear, fmt.Sprintf("%04d", year),
+		numberTokenYear2, fmt.Sprintf("%02d", year%100),
+		numberTokenSeq, fmt.Sprintf("%0*d", digits, seq),
+	).Replace(format)
+}
+
+// numberSeries مفتاح سلسلة الترقيم: المستند ثم القناة (إن وجدت) ثم السنة
+func numberSeries(document NumberedDocument, channel models.SalesChannel, year int) string {
+	if channel == "" {
+		return fmt.Sprintf("%s:%d", document, year)
+	}
+	return fmt.Sprintf("%s:%s:%d", document, channel, year)
+}
+
+// allocateSequence حجز القيمة التالية في السلسلة
+// الصف يبقى مقفلاً حتى نهاية المعاملة، فالتراجع عنها يعيد الرقم ولا يترك فجوة.
+func allocateSequence(db *gorm.DB, series string) (int64, error) {
+	var next int64
+	err := db.Raw(`INSERT INTO number_sequences (series, last_value, updated_at) VALUES (?, 1, NOW())
+		ON CONFLICT (series) DO UPDATE SET last_value = number_sequences.last_value + 1, updated_at = NOW()
+		RETURNING last_value`, series).Scan(&next).Error
+	return next, err
+}
+
+// allocateDocumentNumber حجز التسلسل التالي في السلسلة وتكوين الرقم المقروء منه
+func allocateDocumentNumber(db *gorm.DB, settings NumberingSettings, series, prefix string, at time.Time) (string, error) {
+	seq, err := allocateSequence(db, series)
+	if err != nil {
+		return "", fmt.Errorf("allocate %s number: %w", series, err)
+	}
+	return FormatDocumentNumber(settings.Format, prefix, at.Year(), seq, settings.Digits), nil
+}
+
+// nextDocumentNumber حجز رقم المستند التالي داخل معاملة إنشائه
+// الترقيم متصل لأن التراجع عن المعاملة يعيد الرقم، وهو ما تتطلبه الفواتير الضريبية.
+// البادئة من متغير البيئة prefixEnv وإلا defaultPrefix.
+func nextDocumentNumber(tx *gorm.DB, document NumberedDocument, prefixEnv, defaultPrefix string, at time.Time) (string, error) {
+	return allocateDocumentNumber(tx, LoadNumberingSettings(), numberSeries(document, "", at.Year()), envString(prefixEnv, defaultPrefix), at)
+}
+
+// NextOrderNumber حجز رقم الطلب التالي لقناة البيع
+// أرقام الطلبات تُحجز افتراضياً خارج معاملة الطلب: الطلب الملغى يترك فجوة لكن الطلبات
+// المتزامنة لا تنتظر بعضها. ORDER_NUMBERS_GAPLESS يجعلها داخل المعاملة كالفواتير.
+func NextOrderNumber(tx *gorm.DB, channel models.SalesChannel, at time.Time) (string, error) {
+	prefix, ok := orderPrefixes[channel]
+	if !ok {
+		return "", fmt.Errorf("unknown sales channel %q", channel)
+	}
+
+	settings := LoadNumberingSettings()
+	db := tx
+	if !settings.GaplessOrders && config.DB != nil {
+		db = config.DB
+	}
+	return allocateDocumentNumber(db, settings, numberSeries(DocumentOrder, channel, at.Year()), envString(prefix.env, prefix.fallback), at)
+}
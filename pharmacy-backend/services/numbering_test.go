@@ -0,0 +1,31 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"pharmacy-backend/models"
+)
+
+func TestFormatDocumentNumber(t *testing.T) {
+	assert.Equal(t, "INV-2024-000042", FormatDocumentNumber("{PREFIX}-{YYYY}-{SEQ}", "INV", 2024, 42, 6))
+	assert.Equal(t, "WHS/24/0007", FormatDocumentNumber("{PREFIX}/{YY}/{SEQ}", "WHS", 2024, 7, 4))
+	// التسلسل لا يُقتطع إذا تجاوز عدد الخانات
+	assert.Equal(t, "POS-2025-1234567", FormatDocumentNumber("{PREFIX}-{YYYY}-{SEQ}", "POS", 2025, 1234567, 6))
+}
+
+func TestValidateNumberFormat(t *testing.T) {
+	assert.NoError(t, ValidateNumberFormat("{PREFIX}-{YYYY}-{SEQ}"))
+	assert.NoError(t, ValidateNumberFormat("{PREFIX}{YY}{SEQ}"))
+	// القنوات تتشارك القالب بعدادات مستقلة، فبدون البادئة تتكرر أرقام الطلبات
+	assert.ErrorIs(t, ValidateNumberFormat("{YY}{SEQ}"), ErrInvalidNumberFormat)
+	assert.ErrorIs(t, ValidateNumberFormat("{YYYY}-{SEQ}"), ErrInvalidNumberFormat)
+	assert.ErrorIs(t, ValidateNumberFormat("{PREFIX}-{SEQ}"), ErrInvalidNumberFormat)
+	assert.ErrorIs(t, ValidateNumberFormat("{PREFIX}-{YYYY}"), ErrInvalidNumberFormat)
+}
+
+func TestNumberSeriesIsIndependentOfPrefix(t *testing.T) {
+	assert.Equal(t, "order:wholesale:2024", numberSeries(DocumentOrder, models.SalesChannelWholesale, 2024))
+	assert.Equal(t, "credit_note:2024", numberSeries(DocumentCreditNote, "", 2024))
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"pharmacy-backend/models"
+)
+
+// ErrOrderStatusChanged تغيرت حالة الطلب من طلب متزامن آخر قبل تطبيق التعديل
+var ErrOrderStatusChanged = errors.New("order status was changed by another request")
+
+// CancelOrder إلغاء الطلب وإعادة مخزونه والكوبون المستخدم فيه
+// يجب استدعاؤها داخل معاملة؛ تحديث الحالة مشروط بالحالة المقروءة سابقاً
+// حتى لا يُعاد المخزون مرتين عند إلغاءين متزامنين.
+func CancelOrder(tx *gorm.DB, order *models.Order, actorID *uuid.UUID) error {
+	if order.Status == models.OrderStatusCancelled {
+		return ErrOrderStatusChanged
+	}
+
+	result := tx.Model(&models.Order{}).
+		Where("id = ? AND status = ?", order.ID, order.Status).
+		Updates(map[string]interface{}{
+			"status":     models.OrderStatusCancelled,
+			"updated_at": time.Now(),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrOrderStatusChanged
+	}
+	order.Status = models.OrderStatusCancelled
+
+	if err := ReleaseCouponRedemption(tx, order.ID); err != nil {
+		return err
+	}
+	if err := ReleaseOrderPrescriptions(tx, order.ID); err != nil {
+		return err
+	}
+	return RestockOrder(tx, order, actorID)
+}
+
+// RestockOrder إعادة كميات عناصر الطلب إلى المخزون وتسجيلها كحركات إلغاء
+func RestockOrder(tx *gorm.DB, order *models.Order, actorID *uuid.UUID) error {
+	var items []models.OrderItem
+	if err := tx.Where("order_id = ?", order.ID).Find(&items).Error; err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		if item.Quantity <= 0 {
+			continue
+		}
+
+		if _, err := RestockOrderItem(tx, item.ID, item.ProductID, item.Quantity); err != nil {
+			return fmt.Errorf("restock product %s: %w", item.ProductID, err)
+		}
+
+		if _, err := RecordStockMovement(tx, StockMovement{
+			ProductID:       item.ProductID,
+			Type:            models.TransactionTypeCancel,
+			Quantity:        item.Quantity,
+			UnitPrice:       item.UnitPrice,
+			ReferenceNumber: order.OrderNumber,
+			Notes:           fmt.Sprintf("إرجاع مخزون الطلب الملغى %s", order.OrderNumber),
+			ActorID:         actorID,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
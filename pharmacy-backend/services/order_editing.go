@@ -0,0 +1,378 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"pharmacy-backend/models"
+)
+
+// أخطاء تعديل الطلب من لوحة الإدارة
+var (
+	ErrOrderNotEditable      = errors.New("only unpaid orders that are awaiting review, pending or confirmed can be edited")
+	ErrOrderItemNotFound     = errors.New("order item not found in this order")
+	ErrOrderEditLastItem     = errors.New("cannot remove the last item; cancel the order instead")
+	ErrOrderEditNoChanges    = errors.New("order edit has no changes")
+	ErrOrderEditPrescription = errors.New("prescription-only quantities cannot be added by editing; the customer must order them with a prescription")
+)
+
+// IsOrderEditError التحقق مما إذا كان الخطأ ناتجاً عن تعديل طلب غير صالح
+func IsOrderEditError(err error) bool {
+	return errors.Is(err, ErrOrderNotEditable) ||
+		errors.Is(err, ErrOrderItemNotFound) ||
+		errors.Is(err, ErrOrderEditLastItem) ||
+		errors.Is(err, ErrOrderEditNoChanges) ||
+		errors.Is(err, ErrOrderEditPrescription)
+}
+
+// OrderEditAction نوع التعديل على عنصر الطلب
+type OrderEditAction string
+
+const (
+	OrderEditAdd    OrderEditAction = "add"
+	OrderEditUpdate OrderEditAction = "update"
+	OrderEditRemove OrderEditAction = "remove"
+)
+
+// OrderEdit تعديل واحد على عناصر الطلب
+// الإضافة تحتاج ProductID، والتغيير والحذف يحتاجان OrderItemID؛ الكمية 0 في التغيير تعني الحذف.
+type OrderEdit struct {
+	Action      OrderEditAction
+	OrderItemID uuid.UUID
+	ProductID   uuid.UUID
+	Quantity    int
+	Note        string
+	ActorID     *uuid.UUID
+}
+
+// OrderItemChange أثر التعديل على عنصر واحد
+type OrderItemChange struct {
+	Action      OrderEditAction `json:"action"`
+	OrderItemID uuid.UUID       `json:"order_item_id"`
+	ProductID   uuid.UUID       `json:"product_id"`
+	Name        string          `json:"name"`
+	OldQuantity int             `json:"old_quantity"`
+	NewQuantity int             `json:"new_quantity"`
+	UnitPrice   float64         `json:"unit_price"`
+}
+
+// OrderEditResult نتيجة التعديل مع الإجمالي قبل وبعد
+type OrderEditResult struct {
+	Order         *models.Order         `json:"order"`
+	Change        OrderItemChange       `json:"change"`
+	PreviousTotal float64               `json:"previous_total"`
+	Tracking      *models.OrderTracking `json:"tracking"`
+}
+
+// OrderEditable التحقق من إمكانية تعديل عناصر الطلب قبل تجهيزه
+// الطلب المدفوع لا يُعدل لأن تغيير إجماليه يتطلب استرداداً أو دفعة إضافية.
+func OrderEditable(order *models.Order) bool {
+	switch order.Status {
+	case models.OrderStatusPending, models.OrderStatusConfirmed, models.OrderStatusAwaitingPrescriptionReview:
+	default:
+		return false
+	}
+	return order.PaymentStatus == "" || order.PaymentStatus == models.PaymentStatusPending
+}
+
+// EditOrderItems تطبيق تعديل على عناصر الطلب داخل معاملة
+// يُعدل المخزون بفرق الكمية فقط، ويُعاد حساب المجموع والخصم والشحن والضريبة
+// بنفس قواعد التسعير، ويُسجل التعديل في سجل تتبع الطلب.
+// زيادة الكمية أو إضافة منتج تُرفض بخطأ QuantityLimitError إذا تجاوزت حدود العميل.
+func EditOrderItems(tx *gorm.DB, orderID uuid.UUID, edit OrderEdit) (*OrderEditResult, error) {
+	var order models.Order
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, "id = ?", orderID).Error; err != nil {
+		return nil, err
+	}
+	if !OrderEditable(&order) {
+		return nil, fmt.Errorf("%s (%s): %w", order.OrderNumber, order.Status, ErrOrderNotEditable)
+	}
+
+	var items []models.OrderItem
+	if err := tx.Where("order_id = ?", order.ID).Order("created_at ASC").Find(&items).Error; err != nil {
+		return nil, err
+	}
+
+	change, err := applyOrderEdit(tx, &order, items, edit)
+	if err != nil {
+		return nil, err
+	}
+	// الزيادة تخضع لحدود الكمية كالطلب الجديد، والتخفيض مسموح دائماً
+	if change.NewQuantity > change.OldQuantity {
+		if err := CheckOrderEditQuantityLimits(tx, &order, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Where("order_id = ?", order.ID).Order("created_at ASC").Find(&order.OrderItems).Error; err != nil {
+		return nil, err
+	}
+	previousTotal := order.TotalAmount
+	if err := repriceOrder(tx, &order); err != nil {
+		return nil, err
+	}
+
+	tracking := models.OrderTracking{
+		OrderID:     order.ID,
+		Status:      models.TrackingOrderEdited,
+		FromStatus:  string(order.Status),
+		ActorID:     edit.ActorID,
+		Description: describeOrderEdit(change, previousTotal, order.TotalAmount, edit.Note),
+		Timestamp:   time.Now(),
+	}
+	if err := tx.Create(&tracking).Error; err != nil {
+		return nil, err
+	}
+
+	return &OrderEditResult{Order: &order, Change: *change, PreviousTotal: previousTotal, Tracking: &tracking}, nil
+}
+
+// applyOrderEdit تعديل العنصر وحجز أو إعادة فرق المخزون
+func applyOrderEdit(tx *gorm.DB, order *models.Order, items []models.OrderItem, edit OrderEdit) (*OrderItemChange, error) {
+	if edit.Action == OrderEditAdd {
+		return addOrderItem(tx, order, items, edit)
+	}
+
+	var item *models.OrderItem
+	for i := range items {
+		if items[i].ID == edit.OrderItemID {
+			item = &items[i]
+			break
+		}
+	}
+	if item == nil {
+		return nil, ErrOrderItemNotFound
+	}
+
+	newQuantity := edit.Quantity
+	if edit.Action == OrderEditRemove {
+		newQuantity = 0
+	}
+	if newQuantity < 0 {
+		return nil, ErrInvalidQuantity
+	}
+	if newQuantity == item.Quantity {
+		return nil, ErrOrderEditNoChanges
+	}
+	if newQuantity == 0 && len(items) == 1 {
+		return nil, ErrOrderEditLastItem
+	}
+	// الصيدلي راجع الكمية المطلوبة على الوصفة، فلا تُزاد دون طلب جديد
+	if item.PrescriptionID != nil && newQuantity > item.Quantity {
+		return nil, ErrOrderEditPrescription
+	}
+
+	change := &OrderItemChange{
+		Action:      OrderEditUpdate,
+		OrderItemID: item.ID,
+		ProductID:   item.ProductID,
+		Name:        item.Name,
+		OldQuantity: item.Quantity,
+		NewQuantity: newQuantity,
+		UnitPrice:   item.UnitPrice,
+	}
+	if err := adjustOrderItemStock(tx, order, item, newQuantity-item.Quantity, edit.ActorID); err != nil {
+		return nil, err
+	}
+
+	if newQuantity == 0 {
+		change.Action = OrderEditRemove
+		if err := tx.Delete(item).Error; err != nil {
+			return nil, err
+		}
+		if item.PrescriptionID != nil {
+			return change, releasePrescriptionFill(tx, *item.PrescriptionID, order.ID)
+		}
+		return change, nil
+	}
+	// الكمية الجديدة بنفس سعر الوحدة الذي اعتمده العميل عند الطلب
+	return change, tx.Model(item).Updates(map[string]interface{}{
+		"quantity":    newQuantity,
+		"total_price": RoundMoney(item.UnitPrice * float64(newQuantity)),
+	}).Error
+}
+
+// addOrderItem إضافة منتج للطلب بسعره الحالي، أو زيادة كميته إذا كان موجوداً
+func addOrderItem(tx *gorm.DB, order *models.Order, items []models.OrderItem, edit OrderEdit) (*OrderItemChange, error) {
+	if edit.Quantity < 1 {
+		return nil, ErrInvalidQuantity
+	}
+	for i := range items {
+		if items[i].ProductID == edit.ProductID {
+			return applyOrderEdit(tx, order, items, OrderEdit{
+				Action:      OrderEditUpdate,
+				OrderItemID: items[i].ID,
+				Quantity:    items[i].Quantity + edit.Quantity,
+				ActorID:     edit.ActorID,
+				Note:        edit.Note,
+			})
+		}
+	}
+
+	var user models.User
+	if err := tx.First(&user, "id = ?", order.UserID).Error; err != nil {
+		return nil, err
+	}
+	quote, err := QuoteOrder(tx, &user, []OrderLineInput{{ProductID: edit.ProductID, Quantity: edit.Quantity}}, LoadPricingSettings())
+	if err != nil {
+		return nil, err
+	}
+	if quote.Lines[0].Product.RequiresPrescription {
+		return nil, ErrOrderEditPrescription
+	}
+	// العنصر الجديد يُصرف من فرع الطلب أولاً كزيادة الكمية في adjustOrderItemStock
+	line := quote.Lines[0]
+	preference, err := orderLocationPreference(tx, order)
+	if err != nil {
+		return nil, err
+	}
+	allocations, err := AllocateStockFrom(tx, line.ProductID, line.Quantity, preference)
+	if err != nil {
+		return nil, err
+	}
+
+	item := models.OrderItem{
+		OrderID:    order.ID,
+		ProductID:  line.Product.ID,
+		Name:       line.Product.Name,
+		ImageURL:   line.Product.ImageURL,
+		Quantity:   line.Quantity,
+		UnitPrice:  line.UnitPrice,
+		TotalPrice: line.TotalPrice,
+	}
+	if err := tx.Create(&item).Error; err != nil {
+		return nil, fmt.Errorf("create order item: %w", err)
+	}
+	if err := RecordOrderItemBatches(tx, item.ID, allocations); err != nil {
+		return nil, err
+	}
+	if err := recordOrderSale(tx, order, &item, item.Quantity, edit.ActorID); err != nil {
+		return nil, err
+	}
+	return &OrderItemChange{
+		Action:      OrderEditAdd,
+		OrderItemID: item.ID,
+		ProductID:   item.ProductID,
+		Name:        item.Name,
+		NewQuantity: item.Quantity,
+		UnitPrice:   item.UnitPrice,
+	}, nil
+}
+
+// adjustOrderItemStock حجز الزيادة من المخزون أو إعادة النقص إليه
+func adjustOrderItemStock(tx *gorm.DB, order *models.Order, item *models.OrderItem, delta int, actorID *uuid.UUID) error {
+	if delta > 0 {
+		preference, err := orderLocationPreference(tx, order)
+		if err != nil {
+			return err
+		}
+		allocations, err := AllocateStockFrom(tx, item.ProductID, delta, preference)
+		if err != nil {
+			return err
+		}
+		if err := recordOrderSale(tx, order, item, delta, actorID); err != nil {
+			return err
+		}
+		return RecordOrderItemBatches(tx, item.ID, allocations)
+	}
+	if _, err := RestockOrderItem(tx, item.ID, item.ProductID, -delta); err != nil {
+		return fmt.Errorf("restock product %s: %w", item.ProductID, err)
+	}
+	_, err := RecordStockMovement(tx, StockMovement{
+		ProductID:       item.ProductID,
+		Type:            models.TransactionTypeCancel,
+		Quantity:        -delta,
+		UnitPrice:       item.UnitPrice,
+		ReferenceNumber: order.OrderNumber,
+		Notes:           fmt.Sprintf("إرجاع مخزون بعد تعديل الطلب %s", order.OrderNumber),
+		ActorID:         actorID,
+	})
+	return err
+}
+
+// repriceOrder إعادة حساب قيم الطلب من عناصره الحالية
+// خصم الكوبون يُعاد حسابه على المجموع الجديد (النسبة تتغير مع المجموع والمبلغ الثابت لا يتجاوزه).
+func repriceOrder(tx *gorm.DB, order *models.Order) error {
+	quote := &OrderQuote{Settings: LoadPricingSettings(), DiscountAmount: order.DiscountAmount}
+	for _, item := range order.OrderItems {
+		quote.Lines = append(quote.Lines, QuotedLine{ProductID: item.ProductID, Quantity: item.Quantity, UnitPrice: item.UnitPrice, TotalPrice: item.TotalPrice})
+	}
+	quote.Recalculate()
+
+	couponReleased := false
+	if order.CouponID != nil {
+		var coupon models.Coupon
+		if err := tx.First(&coupon, "id = ?", *order.CouponID).Error; err != nil {
+			return err
+		}
+		// الطلب الذي نزل عن الحد الأدنى للكوبون يفقد خصمه ويُعاد استخدام الكوبون كما عند الإلغاء
+		if CheckCouponMinimum(&coupon, quote.Subtotal) != nil {
+			if err := ReleaseCouponRedemption(tx, order.ID); err != nil {
+				return err
+			}
+			couponReleased = true
+			quote.DiscountAmount = 0
+		} else {
+			quote.DiscountAmount = coupon.DiscountFor(quote.Subtotal)
+		}
+		quote.Recalculate()
+		if !couponReleased {
+			if err := tx.Model(&models.CouponRedemption{}).
+				Where("order_id = ? AND status = ?", order.ID, models.CouponRedemptionActive).
+				Update("discount_amount", quote.DiscountAmount).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{
+		"subtotal":        quote.Subtotal,
+		"discount_amount": quote.DiscountAmount,
+		"shipping_cost":   quote.ShippingCost,
+		"tax_amount":      quote.TaxAmount,
+		"total_amount":    quote.TotalAmount,
+		"updated_at":      now,
+	}
+	if couponReleased {
+		updates["coupon_id"] = nil
+		updates["coupon_code"] = ""
+	}
+	if err := tx.Model(&models.Order{}).Where("id = ?", order.ID).Updates(updates).Error; err != nil {
+		return err
+	}
+	if couponReleased {
+		order.CouponID = nil
+		order.CouponCode = ""
+	}
+	order.Subtotal = quote.Subtotal
+	order.DiscountAmount = quote.DiscountAmount
+	order.ShippingCost = quote.ShippingCost
+	order.TaxAmount = quote.TaxAmount
+	order.TotalAmount = quote.TotalAmount
+	order.UpdatedAt = now
+	return nil
+}
+
+// describeOrderEdit وصف التعديل كما يظهر في سجل تتبع الطلب
+func describeOrderEdit(change *OrderItemChange, previousTotal, newTotal float64, note string) string {
+	var b strings.Builder
+	switch change.Action {
+	case OrderEditAdd:
+		fmt.Fprintf(&b, "تمت إضافة %s (الكمية %d)", change.Name, change.NewQuantity)
+	case OrderEditRemove:
+		fmt.Fprintf(&b, "تم حذف %s (الكمية %d)", change.Name, change.OldQuantity)
+	default:
+		fmt.Fprintf(&b, "تم تغيير كمية %s من %d إلى %d", change.Name, change.OldQuantity, change.NewQuantity)
+	}
+	fmt.Fprintf(&b, "، وتغير الإجمالي من %.2f إلى %.2f", previousTotal, newTotal)
+	if note = strings.TrimSpace(note); note != "" {
+		b.WriteString(" - " + note)
+	}
+	return b.String()
+}
@@ -0,0 +1,392 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"pharmacy-backend/models"
+)
+
+func TestOrderEditable(t *testing.T) {
+	order := &models.Order{Status: models.OrderStatusPending, PaymentStatus: models.PaymentStatusPending}
+	assert.True(t, OrderEditable(order))
+
+	order.Status = models.OrderStatusConfirmed
+	assert.True(t, OrderEditable(order))
+
+	order.PaymentStatus = models.PaymentStatusPaid
+	assert.False(t, OrderEditable(order), "paid orders need a refund flow, not an edit")
+
+	order.PaymentStatus = models.PaymentStatusPending
+	order.Status = models.OrderStatusProcessing
+	assert.False(t, OrderEditable(order))
+}
+
+func TestDescribeOrderEdit(t *testing.T) {
+	change := &OrderItemChange{Action: OrderEditUpdate, Name: "Panadol", OldQuantity: 1, NewQuantity: 3}
+	assert.Equal(t, "تم تغيير كمية Panadol من 1 إلى 3، وتغير الإجمالي من 20.00 إلى 40.00 - طلب العميل هاتفياً",
+		describeOrderEdit(change, 20, 40, " طلب العميل هاتفياً "))
+}
+
+func createEditTestUser(t *testing.T, db *gorm.DB) models.User {
+	user := models.User{
+		Email:        "edit-test-" + uuid.New().String()[:8] + "@example.com",
+		PasswordHash: "x",
+		FullName:     "Edit Test",
+		Phone:        "0500000000",
+		IsActive:     true,
+	}
+	require.NoError(t, db.Create(&user).Error)
+	t.Cleanup(func() {
+		db.Delete(&models.User{}, "id = ?", user.ID)
+	})
+	return user
+}
+
+// createEditTestOrder طلب معلق بعناصره، كمياته محجوزة مسبقاً من مخزون المنتجات
+func createEditTestOrder(t *testing.T, db *gorm.DB, userID uuid.UUID, items ...models.OrderItem) (models.Order, []models.OrderItem) {
+	order := models.Order{
+		UserID:        userID,
+		Status:        models.OrderStatusPending,
+		PaymentStatus: models.PaymentStatusPending,
+		PaymentMethod: "cash_on_delivery",
+	}
+	for i := range items {
+		if items[i].TotalPrice == 0 {
+			items[i].TotalPrice = items[i].UnitPrice * float64(items[i].Quantity)
+		}
+		order.Subtotal += items[i].TotalPrice
+	}
+	order.TotalAmount = order.Subtotal
+	require.NoError(t, db.Create(&order).Error)
+	t.Cleanup(func() {
+		db.Delete(&models.OrderTracking{}, "order_id = ?", order.ID)
+		db.Where("order_item_id IN (?)", db.Model(&models.OrderItem{}).Select("id").Where("order_id = ?", order.ID)).Delete(&models.OrderItemBatch{})
+		db.Delete(&models.OrderItem{}, "order_id = ?", order.ID)
+		db.Delete(&models.Order{}, "id = ?", order.ID)
+	})
+	for i := range items {
+		items[i].OrderID = order.ID
+		require.NoError(t, db.Create(&items[i]).Error)
+	}
+	return order, items
+}
+
+func TestEditOrderItemsEnforcesQuantityLimits(t *testing.T) {
+	db := setupStockTestDB(t)
+	user := createEditTestUser(t, db)
+	product := createStockTestProduct(t, db, 10)
+	t.Cleanup(func() {
+		db.Delete(&models.InventoryTransaction{}, "product_id = ?", product.ID)
+	})
+	rule := models.QuantityLimitRule{ProductID: &product.ID, MaxQuantity: 3, PeriodDays: 30, IsActive: true}
+	require.NoError(t, db.Create(&rule).Error)
+	t.Cleanup(func() {
+		db.Delete(&models.QuantityLimitViolation{}, "rule_id = ?", rule.ID)
+		db.Delete(&models.QuantityLimitRule{}, "id = ?", rule.ID)
+	})
+
+	// طلب سابق خلال الفترة بكميتين، والطلب المعدل فيه عبوة واحدة
+	createEditTestOrder(t, db, user.ID, models.OrderItem{ProductID: product.ID, Name: product.Name, Quantity: 2, UnitPrice: 10})
+	order, items := createEditTestOrder(t, db, user.ID, models.OrderItem{ProductID: product.ID, Name: product.Name, Quantity: 1, UnitPrice: 10})
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		_, err := EditOrderItems(tx, order.ID, OrderEdit{Action: OrderEditUpdate, OrderItemID: items[0].ID, Quantity: 2})
+		return err
+	})
+	var limitErr *QuantityLimitError
+	require.True(t, errors.As(err, &limitErr), "got %v", err)
+	// كميات الطلب نفسه مطلوبة لا مشتراة
+	assert.Equal(t, 2, limitErr.Purchased)
+	assert.Equal(t, 2, limitErr.Requested)
+
+	RecordQuantityLimitViolation(db, order.UserID, QuantityLimitSourceOrderEdit, err)
+	var violation models.QuantityLimitViolation
+	require.NoError(t, db.First(&violation, "rule_id = ? AND user_id = ?", rule.ID, user.ID).Error)
+	assert.Equal(t, QuantityLimitSourceOrderEdit, violation.Source)
+	assert.Equal(t, 2, violation.RequestedQuantity)
+
+	// التعديل المرفوض لم يغير الطلب ولا المخزون
+	var reloaded models.OrderItem
+	require.NoError(t, db.First(&reloaded, "id = ?", items[0].ID).Error)
+	assert.Equal(t, 1, reloaded.Quantity)
+	var stock models.Product
+	require.NoError(t, db.First(&stock, "id = ?", product.ID).Error)
+	assert.Equal(t, 10, stock.StockQuantity)
+
+	// ضمن الحد يمر التعديل
+	require.NoError(t, db.Model(&rule).Update("max_quantity", 4).Error)
+	require.NoError(t, db.Transaction(func(tx *gorm.DB) error {
+		_, err := EditOrderItems(tx, order.ID, OrderEdit{Action: OrderEditUpdate, OrderItemID: items[0].ID, Quantity: 2})
+		return err
+	}))
+}
+
+// editTestOrder تنفيذ تعديل في معاملة مستقلة كما يفعل المعالج
+func editTestOrder(db *gorm.DB, orderID uuid.UUID, edit OrderEdit) (*OrderEditResult, error) {
+	var result *OrderEditResult
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		result, err = EditOrderItems(tx, orderID, edit)
+		return err
+	})
+	return result, err
+}
+
+func setEditTestPricing(t *testing.T) {
+	t.Setenv("TAX_RATE", "0.15")
+	t.Setenv("TAX_INCLUSIVE", "true")
+	t.Setenv("SHIPPING_COST", "15")
+	t.Setenv("FREE_SHIPPING_MIN", "200")
+}
+
+func productStock(t *testing.T, db *gorm.DB, productID uuid.UUID) int {
+	var product models.Product
+	require.NoError(t, db.Select("id", "stock_quantity").First(&product, "id = ?", productID).Error)
+	return product.StockQuantity
+}
+
+func TestEditOrderItemsResizeMovesStock(t *testing.T) {
+	db := setupStockTestDB(t)
+	setEditTestPricing(t)
+	user := createEditTestUser(t, db)
+	product := createStockTestProduct(t, db, 10)
+	t.Cleanup(func() {
+		db.Delete(&models.InventoryTransaction{}, "product_id = ?", product.ID)
+	})
+	order, items := createEditTestOrder(t, db, user.ID, models.OrderItem{ProductID: product.ID, Name: product.Name, Quantity: 2, UnitPrice: 10})
+
+	// الزيادة تُحجز من المخزون بفرقها فقط وتُسجل كبيع
+	result, err := editTestOrder(db, order.ID, OrderEdit{Action: OrderEditUpdate, OrderItemID: items[0].ID, Quantity: 5})
+	require.NoError(t, err)
+	assert.Equal(t, 7, productStock(t, db, product.ID))
+	assert.Equal(t, 20.0, result.PreviousTotal)
+	assert.Equal(t, 50.0, result.Order.Subtotal)
+	assert.Equal(t, 15.0, result.Order.ShippingCost)
+	assert.Equal(t, 8.48, result.Order.TaxAmount)
+	assert.Equal(t, 65.0, result.Order.TotalAmount)
+	var item models.OrderItem
+	require.NoError(t, db.First(&item, "id = ?", items[0].ID).Error)
+	assert.Equal(t, 5, item.Quantity)
+	assert.Equal(t, 50.0, item.TotalPrice)
+
+	// التخفيض يعيد الفرق إلى المخزون كإلغاء
+	_, err = editTestOrder(db, order.ID, OrderEdit{Action: OrderEditUpdate, OrderItemID: items[0].ID, Quantity: 1})
+	require.NoError(t, err)
+	assert.Equal(t, 11, productStock(t, db, product.ID))
+
+	var movements []models.InventoryTransaction
+	require.NoError(t, db.Where("product_id = ?", product.ID).Order("created_at ASC").Find(&movements).Error)
+	require.Len(t, movements, 2)
+	assert.Equal(t, models.TransactionTypeSale, movements[0].TransactionType)
+	assert.Equal(t, -3, movements[0].Quantity)
+	assert.Equal(t, models.TransactionTypeCancel, movements[1].TransactionType)
+	assert.Equal(t, 4, movements[1].Quantity)
+
+	// زيادة تتجاوز المخزون تُرفض دون أثر
+	_, err = editTestOrder(db, order.ID, OrderEdit{Action: OrderEditUpdate, OrderItemID: items[0].ID, Quantity: 20})
+	var stockErr *InsufficientStockError
+	assert.True(t, errors.As(err, &stockErr), "got %v", err)
+	assert.Equal(t, 11, productStock(t, db, product.ID))
+}
+
+func TestEditOrderItemsMergesAddIntoExistingLine(t *testing.T) {
+	db := setupStockTestDB(t)
+	setEditTestPricing(t)
+	user := createEditTestUser(t, db)
+	product := createStockTestProduct(t, db, 10)
+	t.Cleanup(func() {
+		db.Delete(&models.InventoryTransaction{}, "product_id = ?", product.ID)
+	})
+	order, items := createEditTestOrder(t, db, user.ID, models.OrderItem{ProductID: product.ID, Name: product.Name, Quantity: 1, UnitPrice: 10})
+
+	result, err := editTestOrder(db, order.ID, OrderEdit{Action: OrderEditAdd, ProductID: product.ID, Quantity: 2, Note: "طلب العميل عبوتين إضافيتين"})
+	require.NoError(t, err)
+	assert.Equal(t, OrderEditUpdate, result.Change.Action)
+	assert.Contains(t, result.Tracking.Description, "طلب العميل عبوتين إضافيتين")
+	assert.Equal(t, items[0].ID, result.Change.OrderItemID)
+	assert.Equal(t, 1, result.Change.OldQuantity)
+	assert.Equal(t, 3, result.Change.NewQuantity)
+	require.Len(t, result.Order.OrderItems, 1)
+	assert.Equal(t, 3, result.Order.OrderItems[0].Quantity)
+	assert.Equal(t, 8, productStock(t, db, product.ID))
+}
+
+func TestEditOrderItemsRepricesCouponAndShipping(t *testing.T) {
+	db := setupStockTestDB(t)
+	setEditTestPricing(t)
+	user := createEditTestUser(t, db)
+	product := createStockTestProduct(t, db, 10)
+	t.Cleanup(func() {
+		db.Delete(&models.InventoryTransaction{}, "product_id = ?", product.ID)
+	})
+	coupon := models.Coupon{
+		Code:       "EDIT-" + uuid.New().String()[:8],
+		Type:       models.CouponTypePercentage,
+		Value:      10,
+		IsActive:   true,
+		ValidFrom:  time.Now().AddDate(0, 0, -1),
+		ValidUntil: time.Now().AddDate(0, 0, 1),
+	}
+	require.NoError(t, db.Create(&coupon).Error)
+	t.Cleanup(func() {
+		db.Delete(&models.CouponRedemption{}, "coupon_id = ?", coupon.ID)
+		db.Delete(&models.Coupon{}, "id = ?", coupon.ID)
+	})
+
+	order, items := createEditTestOrder(t, db, user.ID, models.OrderItem{ProductID: product.ID, Name: product.Name, Quantity: 3, UnitPrice: 50})
+	require.NoError(t, db.Model(&order).Updates(map[string]interface{}{"coupon_id": coupon.ID, "coupon_code": coupon.Code, "discount_amount": 15}).Error)
+	redemption := models.CouponRedemption{CouponID: coupon.ID, UserID: user.ID, OrderID: order.ID, DiscountAmount: 15, Status: models.CouponRedemptionActive}
+	require.NoError(t, db.Create(&redemption).Error)
+
+	// النسبة تُحسب على المجموع الجديد، وتجاوز حد الشحن المجاني يلغي الشحن
+	result, err := editTestOrder(db, order.ID, OrderEdit{Action: OrderEditUpdate, OrderItemID: items[0].ID, Quantity: 5})
+	require.NoError(t, err)
+	assert.Equal(t, 250.0, result.Order.Subtotal)
+	assert.Equal(t, 25.0, result.Order.DiscountAmount)
+	assert.Equal(t, 0.0, result.Order.ShippingCost)
+	assert.Equal(t, 225.0, result.Order.TotalAmount)
+	require.NoError(t, db.First(&redemption, "id = ?", redemption.ID).Error)
+	assert.Equal(t, 25.0, redemption.DiscountAmount)
+
+	// النزول تحت الحد يعيد رسوم الشحن
+	result, err = editTestOrder(db, order.ID, OrderEdit{Action: OrderEditUpdate, OrderItemID: items[0].ID, Quantity: 2})
+	require.NoError(t, err)
+	assert.Equal(t, 10.0, result.Order.DiscountAmount)
+	assert.Equal(t, 15.0, result.Order.ShippingCost)
+	assert.Equal(t, 105.0, result.Order.TotalAmount)
+}
+
+func TestEditOrderItemsReleasesCouponBelowMinimum(t *testing.T) {
+	db := setupStockTestDB(t)
+	setEditTestPricing(t)
+	user := createEditTestUser(t, db)
+	product := createStockTestProduct(t, db, 10)
+	t.Cleanup(func() {
+		db.Delete(&models.InventoryTransaction{}, "product_id = ?", product.ID)
+	})
+	coupon := models.Coupon{
+		Code:           "EDIT-" + uuid.New().String()[:8],
+		Type:           models.CouponTypePercentage,
+		Value:          10,
+		MinOrderAmount: 100,
+		UsedCount:      1,
+		IsActive:       true,
+		ValidFrom:      time.Now().AddDate(0, 0, -1),
+		ValidUntil:     time.Now().AddDate(0, 0, 1),
+	}
+	require.NoError(t, db.Create(&coupon).Error)
+	t.Cleanup(func() {
+		db.Delete(&models.CouponRedemption{}, "coupon_id = ?", coupon.ID)
+		db.Delete(&models.Coupon{}, "id = ?", coupon.ID)
+	})
+
+	order, items := createEditTestOrder(t, db, user.ID, models.OrderItem{ProductID: product.ID, Name: product.Name, Quantity: 3, UnitPrice: 50})
+	require.NoError(t, db.Model(&order).Updates(map[string]interface{}{"coupon_id": coupon.ID, "coupon_code": coupon.Code, "discount_amount": 15}).Error)
+	redemption := models.CouponRedemption{CouponID: coupon.ID, UserID: user.ID, OrderID: order.ID, DiscountAmount: 15, Status: models.CouponRedemptionActive}
+	require.NoError(t, db.Create(&redemption).Error)
+
+	// التخفيض تحت الحد الأدنى يلغي الخصم ويعيد استخدام الكوبون
+	result, err := editTestOrder(db, order.ID, OrderEdit{Action: OrderEditUpdate, OrderItemID: items[0].ID, Quantity: 1})
+	require.NoError(t, err)
+	assert.Equal(t, 50.0, result.Order.Subtotal)
+	assert.Equal(t, 0.0, result.Order.DiscountAmount)
+	assert.Equal(t, 65.0, result.Order.TotalAmount)
+	assert.Nil(t, result.Order.CouponID)
+
+	var reloaded models.Order
+	require.NoError(t, db.First(&reloaded, "id = ?", order.ID).Error)
+	assert.Nil(t, reloaded.CouponID)
+	assert.Equal(t, 0.0, reloaded.DiscountAmount)
+	require.NoError(t, db.First(&redemption, "id = ?", redemption.ID).Error)
+	assert.Equal(t, models.CouponRedemptionReleased, redemption.Status)
+	require.NoError(t, db.First(&coupon, "id = ?", coupon.ID).Error)
+	assert.Equal(t, 0, coupon.UsedCount)
+
+	// العودة فوق الحد لا تعيد الخصم لأن الكوبون لم يعد مستخدماً في الطلب
+	result, err = editTestOrder(db, order.ID, OrderEdit{Action: OrderEditUpdate, OrderItemID: items[0].ID, Quantity: 3})
+	require.NoError(t, err)
+	assert.Equal(t, 0.0, result.Order.DiscountAmount)
+}
+
+func TestEditOrderItemsGuards(t *testing.T) {
+	db := setupStockTestDB(t)
+	setEditTestPricing(t)
+	user := createEditTestUser(t, db)
+	product := createStockTestProduct(t, db, 10)
+	rx := createStockTestProduct(t, db, 10)
+	require.NoError(t, db.Model(&rx).Update("requires_prescription", true).Error)
+	t.Cleanup(func() {
+		db.Delete(&models.InventoryTransaction{}, "product_id IN ?", []uuid.UUID{product.ID, rx.ID})
+	})
+
+	// حذف العنصر الأخير يتطلب إلغاء الطلب
+	order, items := createEditTestOrder(t, db, user.ID, models.OrderItem{ProductID: product.ID, Name: product.Name, Quantity: 1, UnitPrice: 10})
+	_, err := editTestOrder(db, order.ID, OrderEdit{Action: OrderEditRemove, OrderItemID: items[0].ID})
+	assert.True(t, errors.Is(err, ErrOrderEditLastItem), "got %v", err)
+
+	// منتج الوصفة لا يُضاف بالتعديل
+	_, err = editTestOrder(db, order.ID, OrderEdit{Action: OrderEditAdd, ProductID: rx.ID, Quantity: 1})
+	assert.True(t, errors.Is(err, ErrOrderEditPrescription), "got %v", err)
+
+	// ولا تُزاد كمية راجعها الصيدلي على وصفة
+	prescriptionID := uuid.New()
+	rxOrder, rxItems := createEditTestOrder(t, db, user.ID, models.OrderItem{ProductID: rx.ID, Name: rx.Name, Quantity: 1, UnitPrice: 10, PrescriptionID: &prescriptionID})
+	_, err = editTestOrder(db, rxOrder.ID, OrderEdit{Action: OrderEditUpdate, OrderItemID: rxItems[0].ID, Quantity: 2})
+	assert.True(t, errors.Is(err, ErrOrderEditPrescription), "got %v", err)
+
+	// الطلب الذي بدأ تجهيزه لا يُعدل
+	require.NoError(t, db.Model(&order).Update("status", models.OrderStatusProcessing).Error)
+	_, err = editTestOrder(db, order.ID, OrderEdit{Action: OrderEditUpdate, OrderItemID: items[0].ID, Quantity: 2})
+	assert.True(t, errors.Is(err, ErrOrderNotEditable), "got %v", err)
+
+	assert.Equal(t, 10, productStock(t, db, product.ID))
+	assert.Equal(t, 10, productStock(t, db, rx.ID))
+}
+
+func TestEditOrderItemsAddAllocatesFromOrderLocation(t *testing.T) {
+	db := setupStockTestDB(t)
+	setEditTestPricing(t)
+	user := createEditTestUser(t, db)
+
+	// فرع في مدينة الشحن وفرع آخر يُجهز منه الطلب
+	var locations []models.Location
+	for _, city := range []string{"Riyadh", "Jeddah"} {
+		location := models.Location{Code: "EDIT-" + uuid.New().String()[:8], Name: "Edit test " + city, City: city, Priority: 1}
+		require.NoError(t, db.Create(&location).Error)
+		t.Cleanup(func() {
+			db.Delete(&models.Location{}, "id = ?", location.ID)
+		})
+		locations = append(locations, location)
+	}
+	nearest, orderLocation := locations[0], locations[1]
+
+	existing := createStockTestProduct(t, db, 10)
+	added := createStockTestProduct(t, db, 10)
+	expiry := time.Now().AddDate(1, 0, 0)
+	createExpiryTestBatch(t, db, added.ID, &nearest.ID, 5, expiry)
+	fromOrderLocation := createExpiryTestBatch(t, db, added.ID, &orderLocation.ID, 5, expiry)
+	t.Cleanup(func() {
+		db.Delete(&models.InventoryTransaction{}, "product_id = ?", added.ID)
+	})
+
+	order, _ := createEditTestOrder(t, db, user.ID, models.OrderItem{ProductID: existing.ID, Name: existing.Name, Quantity: 1, UnitPrice: 10})
+	order.ShippingAddress.City = "Riyadh"
+	order.LocationID = &orderLocation.ID
+	require.NoError(t, db.Model(&order).Select("shipping_address", "location_id").Updates(&order).Error)
+
+	result, err := editTestOrder(db, order.ID, OrderEdit{Action: OrderEditAdd, ProductID: added.ID, Quantity: 2})
+	require.NoError(t, err)
+
+	var shipped []models.OrderItemBatch
+	require.NoError(t, db.Find(&shipped, "order_item_id = ?", result.Change.OrderItemID).Error)
+	require.Len(t, shipped, 1)
+	assert.Equal(t, fromOrderLocation.ID, shipped[0].BatchID)
+	assert.Equal(t, 2, shipped[0].Quantity)
+}
@@ -0,0 +1,203 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"pharmacy-backend/models"
+)
+
+// PlaceOrderInput بيانات إنشاء طلب بعد تطبيعها من الواجهة أو من الاشتراكات
+type PlaceOrderInput struct {
+	Lines           []OrderLineInput
+	CouponCode      string
+	PaymentMethod   string
+	ShippingAddress models.Address
+	BillingAddress  *models.Address
+	Notes           string
+	Channel         models.SalesChannel // قناة البيع لسلسلة الترقيم؛ الافتراضي حسب نوع التسعير
+	ClearCart       bool                // مسح سلة المستخدم بعد إنشاء الطلب
+
+	// القيم التي عرضتها الواجهة للعميل (0 يعني عدم المقارنة)
+	ClientSubtotal float64
+	ClientShipping float64
+	ClientTotal    float64
+}
+
+// PriceChangedError اختلاف أسعار العميل عن تسعير الخادم الحالي
+type PriceChangedError struct {
+	Quote      *OrderQuote
+	Mismatches []PriceMismatch
+}
+
+func (e *PriceChangedError) Error() string {
+	return fmt.Sprintf("order prices changed: %d mismatches", len(e.Mismatches))
+}
+
+// PlaceOrder إنشاء طلب كامل داخل معاملة: التسعير، الكوبون، حجز المخزون، العناصر
+// هذا هو المسار الوحيد لإنشاء الطلبات سواء من العميل أو من الاشتراكات الدورية.
+func PlaceOrder(tx *gorm.DB, user *models.User, input PlaceOrderInput) (*models.Order, *OrderQuote, error) {
+	quote, err := QuoteOrder(tx, user, input.Lines, LoadPricingSettings())
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	if err := CheckOrderQuantityLimits(tx, user, quote.Lines, now); err != nil {
+		return nil, quote, err
+	}
+
+	// تطبيق الكوبون على التسعير قبل المقارنة مع قيم العميل
+	var coupon *models.Coupon
+	if strings.TrimSpace(input.CouponCode) != "" {
+		coupon, err = ApplyCoupon(tx, quote, user.ID, input.CouponCode)
+		if err != nil {
+			return nil, quote, err
+		}
+	}
+
+	if mismatches := quote.CompareClientTotals(input.ClientSubtotal, input.ClientShipping, input.ClientTotal); len(mismatches) > 0 {
+		return nil, quote, &PriceChangedError{Quote: quote, Mismatches: mismatches}
+	}
+
+	channel := input.Channel
+	if channel == "" {
+		channel = models.SalesChannelRetail
+		if quote.IsWholesale {
+			channel = models.SalesChannelWholesale
+		}
+	}
+	// منتجات الوصفة تتطلب وصفة صالحة للعميل، والطلب ينتظر مراجعة الصيدلي قبل تجهيزه
+	needsReview, err := ReserveOrderPrescriptions(tx, user.ID, quote.Lines, now)
+	if err != nil {
+		return nil, quote, err
+	}
+	status := models.OrderStatusPending
+	if needsReview {
+		status = models.OrderStatusAwaitingPrescriptionReview
+	}
+
+	orderNumber, err := NextOrderNumber(tx, channel, now)
+	if err != nil {
+		return nil, quote, err
+	}
+
+	order := &models.Order{
+		OrderNumber:     orderNumber,
+		UserID:          user.ID,
+		Status:          status,
+		Subtotal:        quote.Subtotal,
+		ShippingCost:    quote.ShippingCost,
+		TaxAmount:       quote.TaxAmount,
+		DiscountAmount:  quote.DiscountAmount,
+		TotalAmount:     quote.TotalAmount,
+		PaymentMethod:   input.PaymentMethod,
+		PaymentStatus:   models.PaymentStatusPending,
+		ShippingAddress: input.ShippingAddress,
+		BillingAddress:  input.BillingAddress,
+		Notes:           input.Notes,
+	}
+	if coupon != nil {
+		order.CouponID = &coupon.ID
+		order.CouponCode = coupon.Code
+	}
+	if err := tx.Create(order).Error; err != nil {
+		return nil, quote, err
+	}
+
+	if coupon != nil {
+		if err := RedeemCoupon(tx, coupon, user.ID, order.ID, order.DiscountAmount); err != nil {
+			return nil, quote, err
+		}
+	}
+
+	// حجز المخزون بخصم مشروط يمنع البيع بأكثر من الكمية المتاحة عند الطلبات المتزامنة
+	// الكمية تُصرف من الدفعات الأقرب انتهاءً وتُسجل دفعات كل عنصر
+	allocations, locationID, err := AllocateOrderStock(tx, quote.Lines, input.ShippingAddress.City)
+	if err != nil {
+		return nil, quote, err
+	}
+	if locationID != nil {
+		order.LocationID = locationID
+		if err := tx.Model(order).Update("location_id", *locationID).Error; err != nil {
+			return nil, quote, err
+		}
+	}
+
+	for _, line := range quote.Lines {
+		item := models.OrderItem{
+			OrderID:    order.ID,
+			ProductID:  line.Product.ID,
+			Name:       line.Product.Name,
+			ImageURL:   line.Product.ImageURL,
+			Quantity:   line.Quantity,
+			UnitPrice:  line.UnitPrice,
+			TotalPrice: line.TotalPrice,
+		}
+		if line.Product.RequiresPrescription {
+			item.PrescriptionID = line.PrescriptionID
+			item.PrescriptionStatus = models.OrderItemPrescriptionPending
+		}
+		if err := tx.Create(&item).Error; err != nil {
+			return nil, quote, fmt.Errorf("create order item: %w", err)
+		}
+		if err := RecordOrderItemBatches(tx, item.ID, allocations.Take(line.ProductID, line.Quantity)); err != nil {
+			return nil, quote, err
+		}
+		if err := recordOrderSale(tx, order, &item, item.Quantity, &user.ID); err != nil {
+			return nil, quote, err
+		}
+		order.OrderItems = append(order.OrderItems, item)
+	}
+
+	if input.ClearCart {
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.CartItem{}).Error; err != nil {
+			return nil, quote, fmt.Errorf("clear cart: %w", err)
+		}
+	}
+	return order, quote, nil
+}
+
+// NotifyOrderCreated إشعارات الطلب الجديد المخزنة للإدارة ولصاحب الطلب
+// تُستدعى بعد تأكيد المعاملة؛ فشل الإشعار لا يؤثر على الطلب.
+func NotifyOrderCreated(order *models.Order, isWholesale bool) {
+	notificationService := NewNotificationService()
+
+	notificationType := models.NotificationTypeAdminOrderCreated
+	title := "طلب تجزئة جديد تم إنشاؤه"
+	message := fmt.Sprintf("تم إنشاء طلب تجزئة جديد برقم %s بقيمة %.2f ريال", order.OrderNumber, order.TotalAmount)
+	if isWholesale {
+		notificationType = models.NotificationTypeAdminWholesaleOrder
+		title = "طلب جملة جديد تم إنشاؤه"
+		message = fmt.Sprintf("تم إنشاء طلب جملة جديد برقم %s بقيمة %.2f ريال", order.OrderNumber, order.TotalAmount)
+	}
+
+	adminMetadata := map[string]interface{}{
+		"order_id":     order.ID.String(),
+		"user_id":      order.UserID.String(),
+		"total_amount": order.TotalAmount,
+		"status":       order.Status,
+		"order_type":   map[bool]string{true: "wholesale", false: "retail"}[isWholesale],
+		"created_at":   order.CreatedAt,
+	}
+	if err := notificationService.CreateAdminNotification(notificationType, title, message, adminMetadata, &order.ID); err != nil {
+		log.Printf("⚠️ فشل في إنشاء إشعار الإدارة: %v", err)
+	}
+
+	userMetadata := map[string]interface{}{
+		"order_id": order.ID.String(),
+		"status":   order.Status,
+	}
+	if _, err := notificationService.CreateNotification(
+		order.UserID,
+		models.NotificationTypeOrderCreated,
+		"تم استلام طلبك بنجاح",
+		fmt.Sprintf("تم استلام طلبك رقم %s بنجاح وسيتم معالجته قريبًا.", order.OrderNumber),
+		userMetadata,
+		&order.ID,
+	); err != nil {
+		log.Printf("⚠️ فشل في إنشاء إشعار المستخدم: %v", err)
+	}
+}
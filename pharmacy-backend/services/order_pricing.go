@@ -0,0 +1,239 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"pharmacy-backend/models"
+)
+
+// أخطاء التسعير التي تُعاد للعميل كطلب غير صالح
+var (
+	ErrEmptyOrder          = errors.New("order has no items")
+	ErrInvalidQuantity     = errors.New("invalid item quantity")
+	ErrProductNotFound     = errors.New("product not found")
+	ErrProductUnavailable  = errors.New("product is not available")
+	ErrWholesaleNotAllowed = errors.New("wholesale products require a wholesale account")
+)
+
+// priceTolerance الفرق المسموح به بين أسعار العميل وأسعار الخادم (تقريب الهللات)
+const priceTolerance = 0.01
+
+// PricingSettings إعدادات الضريبة والشحن المطبقة على الطلبات
+// تُقرأ من نفس متغيرات البيئة التي تعرضها إعدادات المتجر في لوحة التحكم.
+type PricingSettings struct {
+	TaxRate         float64 `json:"tax_rate"`
+	TaxInclusive    bool    `json:"tax_inclusive"`
+	ShippingCost    float64 `json:"shipping_cost"`
+	FreeShippingMin float64 `json:"free_shipping_min"`
+}
+
+// LoadPricingSettings قراءة إعدادات التسعير الحالية للمتجر
+func LoadPricingSettings() PricingSettings {
+	return PricingSettings{
+		TaxRate:         envFloat("TAX_RATE", 0.15),
+		TaxInclusive:    envBool("TAX_INCLUSIVE", true),
+		ShippingCost:    envFloat("SHIPPING_COST", 15.0),
+		FreeShippingMin: envFloat("FREE_SHIPPING_MIN", 200.0),
+	}
+}
+
+// OrderLineInput عنصر طلب كما أرسله العميل
+type OrderLineInput struct {
+	ProductID      uuid.UUID
+	Quantity       int
+	ClientPrice    float64    // السعر الذي عرضته الواجهة (0 إذا لم يُرسل)
+	PrescriptionID *uuid.UUID // وصفة العميل المرفقة إذا كان المنتج يتطلب وصفة
+}
+
+// QuotedLine عنصر طلب بعد تسعيره من بيانات المنتج
+type QuotedLine struct {
+	Product        models.Product `json:"-"`
+	ProductID      uuid.UUID      `json:"product_id"`
+	Name           string         `json:"name"`
+	Quantity       int            `json:"quantity"`
+	UnitPrice      float64        `json:"unit_price"`
+	TotalPrice     float64        `json:"total_price"`
+	ClientPrice    float64        `json:"client_price,omitempty"`
+	PrescriptionID *uuid.UUID     `json:"prescription_id,omitempty"`
+}
+
+// OrderQuote تسعير كامل للطلب محسوب على الخادم
+type OrderQuote struct {
+	Lines          []QuotedLine    `json:"items"`
+	Subtotal       float64         `json:"subtotal"`
+	DiscountAmount float64         `json:"discount_amount"`
+	ShippingCost   float64         `json:"shipping_cost"`
+	TaxAmount      float64         `json:"tax_amount"`
+	TotalAmount    float64         `json:"total_amount"`
+	IsWholesale    bool            `json:"is_wholesale"`
+	Settings       PricingSettings `json:"settings"`
+}
+
+// PriceMismatch فرق بين قيمة أرسلها العميل وقيمة الخادم
+type PriceMismatch struct {
+	Field     string     `json:"field"`
+	ProductID *uuid.UUID `json:"product_id,omitempty"`
+	Client    float64    `json:"client"`
+	Server    float64    `json:"server"`
+}
+
+// CanBuyWholesale التحقق من أن المستخدم يملك صلاحية شراء منتجات الجملة
+func CanBuyWholesale(user *models.User) bool {
+	if user == nil {
+		return false
+	}
+	return user.WholesaleAccess || user.AccountType == models.WholesaleAccount || user.Role == models.RoleWholesale
+}
+
+// QuoteOrder تسعير عناصر الطلب من جدول المنتجات وفق نوع حساب المستخدم
+// تُحسب كل القيم على الخادم ولا يُعتمد على أي سعر قادم من العميل.
+func QuoteOrder(db *gorm.DB, user *models.User, lines []OrderLineInput, settings PricingSettings) (*OrderQuote, error) {
+	if len(lines) == 0 {
+		return nil, ErrEmptyOrder
+	}
+
+	quote := &OrderQuote{Settings: settings}
+	for idx, line := range lines {
+		if line.Quantity < 1 {
+			return nil, fmt.Errorf("item %d: %w", idx, ErrInvalidQuantity)
+		}
+
+		var product models.Product
+		if err := db.First(&product, "id = ?", line.ProductID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, fmt.Errorf("item %d (%s): %w", idx, line.ProductID, ErrProductNotFound)
+			}
+			return nil, err
+		}
+
+		if !product.IsActive {
+			return nil, fmt.Errorf("item %d (%s): %w", idx, product.Name, ErrProductUnavailable)
+		}
+		if product.Type == models.ProductTypeWholesale {
+			if !CanBuyWholesale(user) {
+				return nil, fmt.Errorf("item %d (%s): %w", idx, product.Name, ErrWholesaleNotAllowed)
+			}
+			if !product.PublishedWholesale {
+				return nil, fmt.Errorf("item %d (%s): %w", idx, product.Name, ErrProductUnavailable)
+			}
+			quote.IsWholesale = true
+		}
+
+		unitPrice := RoundMoney(product.GetDiscountedPrice())
+		quote.Lines = append(quote.Lines, QuotedLine{
+			Product:        product,
+			ProductID:      product.ID,
+			Name:           product.Name,
+			Quantity:       line.Quantity,
+			UnitPrice:      unitPrice,
+			TotalPrice:     RoundMoney(unitPrice * float64(line.Quantity)),
+			ClientPrice:    line.ClientPrice,
+			PrescriptionID: line.PrescriptionID,
+		})
+	}
+
+	quote.Recalculate()
+	return quote, nil
+}
+
+// Recalculate إعادة حساب المجموع والشحن والضريبة والإجمالي
+// يُستدعى بعد أي تعديل على العناصر أو الخصم.
+func (q *OrderQuote) Recalculate() {
+	var subtotal float64
+	for _, line := range q.Lines {
+		subtotal += line.TotalPrice
+	}
+	q.Subtotal = RoundMoney(subtotal)
+
+	if q.DiscountAmount > q.Subtotal {
+		q.DiscountAmount = q.Subtotal
+	}
+	q.DiscountAmount = RoundMoney(q.DiscountAmount)
+	taxable := q.Subtotal - q.DiscountAmount
+
+	// الشحن مجاني عند تجاوز الحد الأدنى أو عندما لا توجد قيمة للطلب
+	q.ShippingCost = 0
+	if q.Subtotal > 0 && (q.Settings.FreeShippingMin <= 0 || taxable < q.Settings.FreeShippingMin) {
+		q.ShippingCost = RoundMoney(q.Settings.ShippingCost)
+	}
+
+	// التوصيل توريد خاضع للنسبة الأساسية، فيدخل الشحن وعاء الضريبة كالأصناف
+	taxBase := taxable + q.ShippingCost
+	if q.Settings.TaxInclusive {
+		// الأسعار شاملة الضريبة: نستخرج قيمة الضريبة من المبلغ دون إضافتها
+		q.TaxAmount = RoundMoney(taxBase - taxBase/(1+q.Settings.TaxRate))
+		q.TotalAmount = RoundMoney(taxBase)
+	} else {
+		q.TaxAmount = RoundMoney(taxBase * q.Settings.TaxRate)
+		q.TotalAmount = RoundMoney(taxBase + q.TaxAmount)
+	}
+}
+
+// CompareClientTotals مقارنة القيم التي أرسلها العميل بتسعير الخادم
+// القيم الصفرية تعني أن العميل لم يرسل الحقل فلا تُقارن.
+func (q *OrderQuote) CompareClientTotals(subtotal, shipping, total float64) []PriceMismatch {
+	var mismatches []PriceMismatch
+	for i := range q.Lines {
+		line := q.Lines[i]
+		if line.ClientPrice > 0 && !moneyEqual(line.ClientPrice, line.UnitPrice) {
+			mismatches = append(mismatches, PriceMismatch{Field: "price", ProductID: &q.Lines[i].ProductID, Client: line.ClientPrice, Server: line.UnitPrice})
+		}
+	}
+	if subtotal > 0 && !moneyEqual(subtotal, q.Subtotal) {
+		mismatches = append(mismatches, PriceMismatch{Field: "subtotal", Client: subtotal, Server: q.Subtotal})
+	}
+	if (subtotal > 0 || total > 0) && !moneyEqual(shipping, q.ShippingCost) {
+		mismatches = append(mismatches, PriceMismatch{Field: "shipping", Client: shipping, Server: q.ShippingCost})
+	}
+	if total > 0 && !moneyEqual(total, q.TotalAmount) {
+		mismatches = append(mismatches, PriceMismatch{Field: "total", Client: total, Server: q.TotalAmount})
+	}
+	return mismatches
+}
+
+// RoundMoney تقريب المبلغ إلى هللتين
+func RoundMoney(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+func moneyEqual(a, b float64) bool {
+	return math.Abs(a-b) < priceTolerance+1e-9
+}
+
+func envFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+	return defaultValue
+}
+
+func envBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return defaultValue
+}
+
+// QuoteCart تسعير عناصر سلة المستخدم الحالية
+func QuoteCart(db *gorm.DB, user *models.User, settings PricingSettings) (*OrderQuote, error) {
+	var cartItems []models.CartItem
+	if err := db.Where("user_id = ?", user.ID).Order("created_at ASC").Find(&cartItems).Error; err != nil {
+		return nil, err
+	}
+
+	lines := make([]OrderLineInput, 0, len(cartItems))
+	for _, item := range cartItems {
+		lines = append(lines, OrderLineInput{ProductID: item.ProductID, Quantity: item.Quantity})
+	}
+	return QuoteOrder(db, user, lines, settings)
+}
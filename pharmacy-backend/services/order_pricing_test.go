@@ -0,0 +1,37 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecalculateTaxesShipping(t *testing.T) {
+	tests := []struct {
+		name      string
+		inclusive bool
+		subtotal  float64
+		discount  float64
+		shipping  float64
+		tax       float64
+		total     float64
+	}{
+		{name: "inclusive with shipping", inclusive: true, subtotal: 50, shipping: 15, tax: 8.48, total: 65},
+		{name: "exclusive with shipping", subtotal: 50, shipping: 15, tax: 9.75, total: 74.75},
+		{name: "exclusive with discount", subtotal: 100, discount: 20, shipping: 15, tax: 14.25, total: 109.25},
+		{name: "exclusive over free shipping", subtotal: 250, tax: 37.5, total: 287.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &OrderQuote{
+				Lines:          []QuotedLine{{Quantity: 1, UnitPrice: tt.subtotal, TotalPrice: tt.subtotal}},
+				DiscountAmount: tt.discount,
+				Settings:       PricingSettings{TaxRate: 0.15, TaxInclusive: tt.inclusive, ShippingCost: 15, FreeShippingMin: 200},
+			}
+			q.Recalculate()
+			assert.Equal(t, tt.shipping, q.ShippingCost)
+			assert.Equal(t, tt.tax, q.TaxAmount)
+			assert.Equal(t, tt.total, q.TotalAmount)
+		})
+	}
+}
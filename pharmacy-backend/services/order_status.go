@@ -0,0 +1,151 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"pharmacy-backend/models"
+)
+
+// أخطاء انتقال حالة الطلب
+var (
+	ErrIllegalTransition       = errors.New("order status transition is not allowed")
+	ErrTransitionForbidden     = errors.New("role is not allowed to make this status transition")
+	ErrTrackingNumberRequired  = errors.New("tracking number is required to ship an order")
+	ErrPrescriptionsUnreviewed = errors.New("all prescription items must be reviewed before the order can proceed")
+)
+
+// StatusChange طلب تغيير حالة الطلب مع بيانات المنفذ والحقول الإضافية
+type StatusChange struct {
+	To             models.OrderStatus
+	ActorID        *uuid.UUID
+	ActorRole      models.UserRole
+	TrackingNumber string
+	Carrier        string
+	Description    string
+	Location       *string
+}
+
+// ValidateTransition التحقق من أن الانتقال مسموح في مخطط الحالات لدور المنفذ
+func ValidateTransition(from models.OrderStatus, change StatusChange) error {
+	t, ok := models.FindOrderTransition(from, change.To)
+	if !ok {
+		return fmt.Errorf("%s -> %s: %w", from, change.To, ErrIllegalTransition)
+	}
+	if !t.AllowsRole(change.ActorRole) {
+		return fmt.Errorf("%s -> %s (%s): %w", from, change.To, change.ActorRole, ErrTransitionForbidden)
+	}
+	if t.RequiresTrackingNumber && strings.TrimSpace(change.TrackingNumber) == "" {
+		return fmt.Errorf("%s -> %s: %w", from, change.To, ErrTrackingNumberRequired)
+	}
+	return nil
+}
+
+// TransitionOrderStatus نقل الطلب إلى حالة جديدة وفق مخطط الحالات
+// يجب استدعاؤها داخل معاملة. الإلغاء يعيد المخزون والكوبون، ويُسجل
+// كل انتقال في order_tracking مع الحالة السابقة والمنفذ.
+func TransitionOrderStatus(tx *gorm.DB, order *models.Order, change StatusChange) (*models.OrderTracking, error) {
+	from := order.Status
+	if err := ValidateTransition(from, change); err != nil {
+		return nil, err
+	}
+
+	// الطلب لا يخرج من انتظار المراجعة إلا بعد قرار الصيدلي على كل عنصر
+	if from == models.OrderStatusAwaitingPrescriptionReview && change.To != models.OrderStatusCancelled {
+		var pending int64
+		if err := tx.Model(&models.OrderItem{}).
+			Where("order_id = ? AND prescription_status = ?", order.ID, models.OrderItemPrescriptionPending).
+			Count(&pending).Error; err != nil {
+			return nil, err
+		}
+		if pending > 0 {
+			return nil, fmt.Errorf("%d pending: %w", pending, ErrPrescriptionsUnreviewed)
+		}
+	}
+
+	if change.To == models.OrderStatusCancelled {
+		if err := CancelOrder(tx, order, change.ActorID); err != nil {
+			return nil, err
+		}
+	} else {
+		now := time.Now()
+		updates := map[string]interface{}{
+			"status":     change.To,
+			"updated_at": now,
+		}
+		switch change.To {
+		case models.OrderStatusShipped, models.OrderStatusPartiallyShipped:
+			updates["tracking_number"] = strings.TrimSpace(change.TrackingNumber)
+			updates["shipping_carrier"] = strings.TrimSpace(change.Carrier)
+		case models.OrderStatusDelivered:
+			updates["actual_delivery"] = now
+		}
+
+		// التحديث مشروط بالحالة المقروءة حتى لا يتجاوز طلب متزامن مخطط الحالات
+		result := tx.Model(&models.Order{}).Where("id = ? AND status = ?", order.ID, from).Updates(updates)
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil, ErrOrderStatusChanged
+		}
+
+		order.Status = change.To
+		order.UpdatedAt = now
+		switch change.To {
+		case models.OrderStatusShipped, models.OrderStatusPartiallyShipped:
+			order.TrackingNumber = updates["tracking_number"].(string)
+			order.ShippingCarrier = updates["shipping_carrier"].(string)
+		case models.OrderStatusDelivered:
+			order.ActualDelivery = &now
+		}
+
+		// الطلب الذي يُشحن دون شحنات مسجلة تُكلف عناصره هنا؛ المشحون بشحنات كُلف عند خروجها
+		if change.To == models.OrderStatusShipped || change.To == models.OrderStatusDelivered {
+			if err := CostUncostedOrderItems(tx, order, now); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	description := change.Description
+	if description == "" {
+		description = fmt.Sprintf("تم تحديث حالة الطلب من %s إلى %s", from, change.To)
+	}
+	tracking := models.OrderTracking{
+		OrderID:     order.ID,
+		Status:      string(change.To),
+		FromStatus:  string(from),
+		ActorID:     change.ActorID,
+		Description: description,
+		Location:    change.Location,
+		Timestamp:   time.Now(),
+	}
+	if err := tx.Create(&tracking).Error; err != nil {
+		return nil, err
+	}
+
+	// الطلب المُسلَّم تصدر له فاتورته؛ غياب الرقم الضريبي لا يمنع التسليم
+	if change.To == models.OrderStatusDelivered {
+		if _, err := IssueOrderInvoice(tx, order); err != nil {
+			if !errors.Is(err, ErrSellerVATMissing) {
+				return nil, err
+			}
+			log.Printf("⚠️ لم تصدر فاتورة للطلب %s: %v", order.OrderNumber, err)
+		}
+	}
+	return &tracking, nil
+}
+
+// IsTransitionError التحقق مما إذا كان الخطأ ناتجاً عن انتقال حالة غير صالح
+func IsTransitionError(err error) bool {
+	return errors.Is(err, ErrIllegalTransition) ||
+		errors.Is(err, ErrTransitionForbidden) ||
+		errors.Is(err, ErrTrackingNumberRequired) ||
+		errors.Is(err, ErrPrescriptionsUnreviewed)
+}
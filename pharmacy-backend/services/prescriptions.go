@@ -0,0 +1,284 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"pharmacy-backend/models"
+)
+
+// أخطاء الوصفات الطبية التي تُعاد للعميل أو الصيدلي كطلب غير صالح
+var (
+	ErrPrescriptionRequired    = errors.New("a valid prescription is required for prescription-only products")
+	ErrPrescriptionNotFound    = errors.New("prescription not found")
+	ErrPrescriptionExpired     = errors.New("prescription has expired")
+	ErrPrescriptionRejected    = errors.New("prescription was rejected by the pharmacist")
+	ErrPrescriptionNoFills     = errors.New("prescription has no remaining refills")
+	ErrPrescriptionValidity    = errors.New("prescription validity date must be in the future")
+	ErrPrescriptionReviewState = errors.New("order item is not awaiting prescription review")
+)
+
+// IsPrescriptionError التحقق مما إذا كان الخطأ ناتجاً عن وصفة مفقودة أو غير صالحة
+func IsPrescriptionError(err error) bool {
+	return errors.Is(err, ErrPrescriptionRequired) ||
+		errors.Is(err, ErrPrescriptionNotFound) ||
+		errors.Is(err, ErrPrescriptionExpired) ||
+		errors.Is(err, ErrPrescriptionRejected) ||
+		errors.Is(err, ErrPrescriptionNoFills) ||
+		errors.Is(err, ErrPrescriptionValidity) ||
+		errors.Is(err, ErrPrescriptionReviewState)
+}
+
+// CheckPrescriptionUsable التحقق من إمكانية صرف الوصفة عند وقت معين
+func CheckPrescriptionUsable(p *models.Prescription, at time.Time) error {
+	switch {
+	case p.Status == models.PrescriptionStatusRejected:
+		return ErrPrescriptionRejected
+	case p.IsExpired(at):
+		return ErrPrescriptionExpired
+	case p.RemainingFills() <= 0:
+		return ErrPrescriptionNoFills
+	}
+	return nil
+}
+
+// lockUserPrescription قراءة وصفة المستخدم مع قفل صفها حتى نهاية المعاملة
+func lockUserPrescription(tx *gorm.DB, userID, prescriptionID uuid.UUID) (*models.Prescription, error) {
+	var p models.Prescription
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ? AND user_id = ?", prescriptionID, userID).
+		First(&p).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrPrescriptionNotFound
+	}
+	return &p, err
+}
+
+// ReserveOrderPrescriptions التحقق من وصفات عناصر الطلب واستهلاك صرفة من كل وصفة
+// كل وصفة تُستهلك مرة واحدة للطلب مهما تعدد عدد عناصرها. يُعيد true إذا احتوى
+// الطلب على منتجات تتطلب وصفة وبالتالي يحتاج مراجعة الصيدلي.
+func ReserveOrderPrescriptions(tx *gorm.DB, userID uuid.UUID, lines []QuotedLine, at time.Time) (bool, error) {
+	reserved := map[uuid.UUID]bool{}
+	for _, line := range lines {
+		if !line.Product.RequiresPrescription {
+			continue
+		}
+		if line.PrescriptionID == nil {
+			return false, fmt.Errorf("%s: %w", line.Name, ErrPrescriptionRequired)
+		}
+		if reserved[*line.PrescriptionID] {
+			continue
+		}
+
+		p, err := lockUserPrescription(tx, userID, *line.PrescriptionID)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", line.Name, err)
+		}
+		if err := CheckPrescriptionUsable(p, at); err != nil {
+			return false, fmt.Errorf("%s: %w", line.Name, err)
+		}
+		if err := tx.Model(p).Update("fills_used", gorm.Expr("fills_used + 1")).Error; err != nil {
+			return false, err
+		}
+		reserved[p.ID] = true
+	}
+	return len(reserved) > 0, nil
+}
+
+// releasePrescriptionFill إعادة صرفة الوصفة إذا لم يعد أي عنصر في الطلب يستخدمها
+func releasePrescriptionFill(tx *gorm.DB, prescriptionID, orderID uuid.UUID) error {
+	var inUse int64
+	if err := tx.Model(&models.OrderItem{}).
+		Where("order_id = ? AND prescription_id = ?", orderID, prescriptionID).
+		Count(&inUse).Error; err != nil {
+		return err
+	}
+	if inUse > 0 {
+		return nil
+	}
+	return tx.Model(&models.Prescription{}).
+		Where("id = ?", prescriptionID).
+		Update("fills_used", gorm.Expr("GREATEST(fills_used - 1, 0)")).Error
+}
+
+// ReleaseOrderPrescriptions إعادة صرفات الوصفات المستخدمة في طلب ملغى
+func ReleaseOrderPrescriptions(tx *gorm.DB, orderID uuid.UUID) error {
+	var ids []uuid.UUID
+	if err := tx.Model(&models.OrderItem{}).
+		Where("order_id = ? AND prescription_id IS NOT NULL", orderID).
+		Distinct().
+		Pluck("prescription_id", &ids).Error; err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return tx.Model(&models.Prescription{}).
+		Where("id IN ?", ids).
+		Update("fills_used", gorm.Expr("GREATEST(fills_used - 1, 0)")).Error
+}
+
+// PrescriptionReviewInput قرار الصيدلي على عنصر واحد مع تصحيح بيانات الوصفة عند الحاجة
+type PrescriptionReviewInput struct {
+	Decision               models.PrescriptionDecision
+	Notes                  string
+	ReviewerID             *uuid.UUID
+	ReviewerRole           models.UserRole
+	ValidUntil             *time.Time // تصحيح تاريخ الصلاحية من الوصفة الأصلية
+	RefillsAllowed         *int
+	InvalidatePrescription bool // رفض مستند الوصفة نفسه فلا يُقبل في طلبات لاحقة
+}
+
+// PrescriptionReviewResult نتيجة مراجعة العنصر وحالة الطلب بعدها
+type PrescriptionReviewResult struct {
+	Order     *models.Order             `json:"order"`
+	Review    models.PrescriptionReview `json:"review"`
+	Completed bool                      `json:"completed"` // لم يعد في الطلب عناصر بانتظار المراجعة
+	Cancelled bool                      `json:"cancelled"` // أُلغي الطلب لأن كل عناصره رُفضت
+}
+
+// ReviewPrescriptionItem اعتماد أو رفض عنصر طلب يتطلب وصفة
+// العنصر المرفوض يُحذف من الطلب مع إعادة مخزونه وتسعير الطلب، وإذا كان آخر عنصر
+// يُلغى الطلب. عند انتهاء مراجعة كل العناصر ينتقل الطلب إلى pending ليكمل مساره المعتاد.
+func ReviewPrescriptionItem(tx *gorm.DB, orderID, itemID uuid.UUID, input PrescriptionReviewInput) (*PrescriptionReviewResult, error) {
+	var order models.Order
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, "id = ?", orderID).Error; err != nil {
+		return nil, err
+	}
+	if order.Status != models.OrderStatusAwaitingPrescriptionReview {
+		return nil, fmt.Errorf("%s (%s): %w", order.OrderNumber, order.Status, ErrPrescriptionReviewState)
+	}
+
+	var item models.OrderItem
+	if err := tx.Where("id = ? AND order_id = ?", itemID, order.ID).First(&item).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrOrderItemNotFound
+		}
+		return nil, err
+	}
+	if item.PrescriptionID == nil || item.PrescriptionStatus != models.OrderItemPrescriptionPending {
+		return nil, ErrPrescriptionReviewState
+	}
+
+	prescription, err := lockUserPrescription(tx, order.UserID, *item.PrescriptionID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if input.ValidUntil != nil && !input.ValidUntil.After(now) && input.Decision == models.PrescriptionDecisionApprove {
+		return nil, ErrPrescriptionValidity
+	}
+	review := models.PrescriptionReview{
+		PrescriptionID: prescription.ID,
+		OrderID:        order.ID,
+		OrderItemID:    item.ID,
+		ProductID:      item.ProductID,
+		ProductName:    item.Name,
+		Quantity:       item.Quantity,
+		Decision:       input.Decision,
+		Notes:          strings.TrimSpace(input.Notes),
+		ReviewerID:     input.ReviewerID,
+	}
+	if err := tx.Create(&review).Error; err != nil {
+		return nil, err
+	}
+
+	prescriptionUpdates := map[string]interface{}{
+		"reviewed_by": input.ReviewerID,
+		"reviewed_at": now,
+		"updated_at":  now,
+	}
+	if input.ValidUntil != nil {
+		prescriptionUpdates["valid_until"] = *input.ValidUntil
+	}
+	if input.RefillsAllowed != nil {
+		prescriptionUpdates["refills_allowed"] = *input.RefillsAllowed
+	}
+
+	result := &PrescriptionReviewResult{Order: &order, Review: review}
+	switch input.Decision {
+	case models.PrescriptionDecisionApprove:
+		if prescription.Status == models.PrescriptionStatusRejected {
+			return nil, ErrPrescriptionRejected
+		}
+		prescriptionUpdates["status"] = models.PrescriptionStatusApproved
+		if review.Notes != "" {
+			prescriptionUpdates["review_notes"] = review.Notes
+		}
+		if err := tx.Model(prescription).Updates(prescriptionUpdates).Error; err != nil {
+			return nil, err
+		}
+		if err := tx.Model(&item).Update("prescription_status", models.OrderItemPrescriptionApproved).Error; err != nil {
+			return nil, err
+		}
+
+	case models.PrescriptionDecisionReject:
+		if input.InvalidatePrescription {
+			prescriptionUpdates["status"] = models.PrescriptionStatusRejected
+			prescriptionUpdates["review_notes"] = review.Notes
+		}
+		if err := tx.Model(prescription).Updates(prescriptionUpdates).Error; err != nil {
+			return nil, err
+		}
+
+		description := fmt.Sprintf("رفض الصيدلي صرف %s", item.Name)
+		if review.Notes != "" {
+			description += ": " + review.Notes
+		}
+		var itemCount int64
+		if err := tx.Model(&models.OrderItem{}).Where("order_id = ?", order.ID).Count(&itemCount).Error; err != nil {
+			return nil, err
+		}
+		if itemCount <= 1 {
+			if _, err := TransitionOrderStatus(tx, &order, StatusChange{
+				To:          models.OrderStatusCancelled,
+				ActorID:     input.ReviewerID,
+				ActorRole:   input.ReviewerRole,
+				Description: description,
+			}); err != nil {
+				return nil, err
+			}
+			result.Cancelled = true
+			return result, nil
+		}
+
+		edit, err := EditOrderItems(tx, order.ID, OrderEdit{
+			Action:      OrderEditRemove,
+			OrderItemID: item.ID,
+			Note:        description,
+			ActorID:     input.ReviewerID,
+		})
+		if err != nil {
+			return nil, err
+		}
+		result.Order = edit.Order
+
+	default:
+		return nil, fmt.Errorf("unknown decision %q: %w", input.Decision, ErrPrescriptionReviewState)
+	}
+
+	var pending int64
+	if err := tx.Model(&models.OrderItem{}).
+		Where("order_id = ? AND prescription_status = ?", order.ID, models.OrderItemPrescriptionPending).
+		Count(&pending).Error; err != nil {
+		return nil, err
+	}
+	if pending == 0 {
+		if _, err := TransitionOrderStatus(tx, result.Order, StatusChange{
+			To:          models.OrderStatusPending,
+			ActorID:     input.ReviewerID,
+			ActorRole:   input.ReviewerRole,
+			Description: "اكتملت مراجعة الوصفات الطبية للطلب",
+		}); err != nil {
+			return nil, err
+		}
+		result.Completed = true
+	}
+	return result, nil
+}
@@ -0,0 +1,36 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"pharmacy-backend/models"
+)
+
+func TestCheckPrescriptionUsable(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	valid := models.Prescription{
+		Status:         models.PrescriptionStatusApproved,
+		ValidUntil:     now.AddDate(0, 3, 0),
+		RefillsAllowed: 2,
+		FillsUsed:      1,
+	}
+	assert.NoError(t, CheckPrescriptionUsable(&valid, now))
+	assert.Equal(t, 2, valid.RemainingFills())
+
+	expired := valid
+	expired.ValidUntil = now
+	assert.ErrorIs(t, CheckPrescriptionUsable(&expired, now), ErrPrescriptionExpired)
+
+	// الصرفة الأولى + عدد مرات إعادة الصرف
+	usedUp := valid
+	usedUp.FillsUsed = 3
+	assert.ErrorIs(t, CheckPrescriptionUsable(&usedUp, now), ErrPrescriptionNoFills)
+
+	rejected := valid
+	rejected.Status = models.PrescriptionStatusRejected
+	assert.ErrorIs(t, CheckPrescriptionUsable(&rejected, now), ErrPrescriptionRejected)
+	assert.True(t, IsPrescriptionError(CheckPrescriptionUsable(&rejected, now)))
+}
@@ -0,0 +1,471 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"pharmacy-backend/models"
+)
+
+// أخطاء دفعات المخزون
+var (
+	ErrInvalidBatch  = errors.New("batch requires a batch number and a positive quantity")
+	ErrBatchExpired  = errors.New("cannot receive a batch that has already expired")
+	ErrBatchNotFound = errors.New("batch not found")
+)
+
+// IsBatchError التحقق مما إذا كان الخطأ من أخطاء التحقق في الدفعات
+func IsBatchError(err error) bool {
+	return errors.Is(err, ErrInvalidBatch) || errors.Is(err, ErrBatchExpired) || errors.Is(err, ErrBatchNotFound)
+}
+
+// BatchReceipt بيانات استلام دفعة جديدة لمنتج
+type BatchReceipt struct {
+	ProductID       uuid.UUID
+	BatchNumber     string
+	ExpiryDate      *time.Time
+	Quantity        int
+	UnitCost        float64
+	SupplierID      *uuid.UUID
+	LocationID      *uuid.UUID // الفرع المستلم؛ الفرع الافتراضي إذا كان فارغاً
+	ReferenceNumber string
+	Notes           string
+	ActorID         *uuid.UUID
+}
+
+// productHasBatches التحقق مما إذا كان مخزون المنتج متتبعاً بالدفعات
+func productHasBatches(tx *gorm.DB, productID uuid.UUID) (bool, error) {
+	var count int64
+	err := tx.Model(&models.ProductBatch{}).Where("product_id = ?", productID).Limit(1).Count(&count).Error
+	return count > 0, err
+}
+
+// lockProduct قفل صف المنتج قبل دفعاته بنفس ترتيب الحجز لتجنب الجمود
+func lockProduct(tx *gorm.DB, productID uuid.UUID) (*models.Product, error) {
+	var product models.Product
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, "id = ?", productID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%s: %w", productID, ErrProductNotFound)
+		}
+		return nil, err
+	}
+	return &product, nil
+}
+
+// SyncProductStock جعل مخزون المنتج مجموع دفعاته القابلة للبيع
+// رقم الدفعة وتاريخ الانتهاء في المنتج يعرضان الدفعة التالية في الصرف.
+// المنتج الذي لا دفعات له لا يتغير.
+func SyncProductStock(tx *gorm.DB, productID uuid.UUID) error {
+	if _, err := lockProduct(tx, productID); err != nil {
+		return err
+	}
+	var batches []models.ProductBatch
+	if err := tx.Where("product_id = ? AND quantity > 0", productID).Find(&batches).Error; err != nil {
+		return err
+	}
+	if len(batches) == 0 {
+		if tracked, err := productHasBatches(tx, productID); err != nil || !tracked {
+			return err
+		}
+	}
+
+	now := time.Now()
+	sellable := PlanFEFO(batches, math.MaxInt, now)
+	stock := 0
+	for _, allocation := range sellable {
+		stock += allocation.Quantity
+	}
+	updates := map[string]interface{}{"stock_quantity": stock, "updated_at": now}
+	if len(sellable) > 0 {
+		updates["batch_number"] = sellable[0].BatchNumber
+		updates["expiry_date"] = sellable[0].ExpiryDate
+	}
+	return tx.Model(&models.Product{}).Where("id = ?", productID).Updates(updates).Error
+}
+
+// trackOpeningBatch حفظ مخزون المنتج غير المتتبع كدفعة افتتاحية في الفرع الافتراضي
+// حتى لا تمحوه المزامنة عند أول دفعة أو تحويل.
+func trackOpeningBatch(tx *gorm.DB, product *models.Product, actorID *uuid.UUID) error {
+	if product.StockQuantity <= 0 {
+		return nil
+	}
+	tracked, err := productHasBatches(tx, product.ID)
+	if err != nil || tracked {
+		return err
+	}
+	locationID, err := DefaultLocationID(tx)
+	if err != nil {
+		return err
+	}
+	opening := models.ProductBatch{
+		ProductID:        product.ID,
+		BatchNumber:      "OPENING",
+		ExpiryDate:       product.ExpiryDate,
+		Quantity:         product.StockQuantity,
+		ReceivedQuantity: product.StockQuantity,
+		SupplierID:       product.SupplierID,
+		LocationID:       &locationID,
+		Notes:            "مخزون افتتاحي",
+		CreatedBy:        actorID,
+	}
+	if product.BatchNumber != nil && *product.BatchNumber != "" {
+		opening.BatchNumber = *product.BatchNumber
+	}
+	if opening.UnitCost, err = adoptUntrackedCost(tx, product); err != nil {
+		return err
+	}
+	if err := tx.Create(&opening).Error; err != nil {
+		return fmt.Errorf("create opening batch: %w", err)
+	}
+	return assignUntrackedCost(tx, product.ID, opening.ID)
+}
+
+// ReceiveBatch استلام دفعة جديدة في فرع وتسجيلها كحركة شراء
+// إذا كان للمنتج مخزون قبل تتبع الدفعات يُحفظ أولاً كدفعة افتتاحية حتى لا تمحوه المزامنة.
+func ReceiveBatch(tx *gorm.DB, receipt BatchReceipt) (*models.ProductBatch, error) {
+	receipt.BatchNumber = strings.TrimSpace(receipt.BatchNumber)
+	if receipt.BatchNumber == "" || receipt.Quantity < 1 || receipt.UnitCost < 0 {
+		return nil, ErrInvalidBatch
+	}
+	now := time.Now()
+	if receipt.ExpiryDate != nil && !receipt.ExpiryDate.After(now) {
+		return nil, ErrBatchExpired
+	}
+
+	product, err := lockProduct(tx, receipt.ProductID)
+	if err != nil {
+		return nil, err
+	}
+	locationID, err := resolveLocationID(tx, receipt.LocationID)
+	if err != nil {
+		return nil, err
+	}
+	if err := trackOpeningBatch(tx, product, receipt.ActorID); err != nil {
+		return nil, err
+	}
+
+	batch := models.ProductBatch{
+		ProductID:        product.ID,
+		BatchNumber:      receipt.BatchNumber,
+		ExpiryDate:       receipt.ExpiryDate,
+		Quantity:         receipt.Quantity,
+		ReceivedQuantity: receipt.Quantity,
+		UnitCost:         RoundMoney(receipt.UnitCost),
+		SupplierID:       receipt.SupplierID,
+		LocationID:       &locationID,
+		ReceivedAt:       now,
+		Notes:            strings.TrimSpace(receipt.Notes),
+		CreatedBy:        receipt.ActorID,
+	}
+	if err := tx.Create(&batch).Error; err != nil {
+		return nil, fmt.Errorf("create batch: %w", err)
+	}
+
+	reference := receipt.ReferenceNumber
+	if reference == "" {
+		reference = batch.BatchNumber
+	}
+	if _, err := RecordStockMovement(tx, StockMovement{
+		ProductID:       product.ID,
+		Type:            models.TransactionTypePurchase,
+		Quantity:        batch.Quantity,
+		UnitPrice:       batch.UnitCost,
+		SupplierID:      receipt.SupplierID,
+		ReferenceNumber: reference,
+		Notes:           fmt.Sprintf("استلام الدفعة %s", batch.BatchNumber),
+		ActorID:         receipt.ActorID,
+	}); err != nil {
+		return nil, fmt.Errorf("batch %s: %w", batch.BatchNumber, err)
+	}
+	if err := recordCostEntry(tx, &models.InventoryCostEntry{
+		ProductID:       product.ID,
+		BatchID:         &batch.ID,
+		Source:          models.CostSourcePurchase,
+		Quantity:        batch.Quantity,
+		UnitCost:        batch.UnitCost,
+		ReferenceNumber: reference,
+		OccurredAt:      now,
+	}); err != nil {
+		return nil, err
+	}
+	return &batch, SyncProductStock(tx, product.ID)
+}
+
+// AdjustBatchQuantity تصحيح الكمية المتبقية في دفعة وتسجيل الفرق كحركة تسوية بسببها
+func AdjustBatchQuantity(tx *gorm.DB, batchID uuid.UUID, quantity int, reasonCode models.AdjustmentReason, reason string, actorID *uuid.UUID) (*models.ProductBatch, error) {
+	if quantity < 0 {
+		return nil, ErrInvalidBatch
+	}
+	if reasonCode == "" {
+		reasonCode = models.AdjustmentReasonCountCorrection
+	}
+	var batch models.ProductBatch
+	if err := tx.First(&batch, "id = ?", batchID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrBatchNotFound
+		}
+		return nil, err
+	}
+	if _, err := lockProduct(tx, batch.ProductID); err != nil {
+		return nil, err
+	}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&batch, "id = ?", batchID).Error; err != nil {
+		return nil, err
+	}
+
+	delta := quantity - batch.Quantity
+	if delta == 0 {
+		return &batch, nil
+	}
+	notes := fmt.Sprintf("تسوية كمية الدفعة %s", batch.BatchNumber)
+	if reason = strings.TrimSpace(reason); reason != "" {
+		notes += ": " + reason
+	}
+	if _, err := AdjustStock(tx, StockAdjustment{
+		ProductID: batch.ProductID,
+		BatchID:   &batch.ID,
+		Quantity:  delta,
+		Reason:    reasonCode,
+		Notes:     notes,
+		ActorID:   actorID,
+	}); err != nil {
+		return nil, err
+	}
+	batch.Quantity = quantity
+	return &batch, nil
+}
+
+// RecordOrderItemBatches تسجيل الدفعات المصروفة لعنصر طلب
+// زيادة كمية العنصر لاحقاً تُضاف إلى سجل الدفعة نفسها إن وُجد.
+func RecordOrderItemBatches(tx *gorm.DB, orderItemID uuid.UUID, allocations []BatchAllocation) error {
+	for _, allocation := range allocations {
+		result := tx.Model(&models.OrderItemBatch{}).
+			Where("order_item_id = ? AND batch_id = ?", orderItemID, allocation.BatchID).
+			Update("quantity", gorm.Expr("quantity + ?", allocation.Quantity))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			continue
+		}
+		record := models.OrderItemBatch{
+			OrderItemID: orderItemID,
+			BatchID:     allocation.BatchID,
+			BatchNumber: allocation.BatchNumber,
+			ExpiryDate:  allocation.ExpiryDate,
+			Quantity:    allocation.Quantity,
+		}
+		if err := tx.Create(&record).Error; err != nil {
+			return fmt.Errorf("record batch %s for order item: %w", allocation.BatchNumber, err)
+		}
+	}
+	return nil
+}
+
+// RestockOrderItem إعادة كمية من عنصر طلب لم يُشحن إلى الدفعات التي صُرفت منها
+// تُستخدم عند الإلغاء وتخفيض الكمية، فتُنقص سجلات صرف الدفعات بما أُعيد.
+// المنتج المحذوف لا مخزون له فلا يُعاد شيء. تُعاد الكميات المعادة لكل دفعة.
+func RestockOrderItem(tx *gorm.DB, orderItemID, productID uuid.UUID, quantity int) ([]BatchAllocation, error) {
+	if quantity <= 0 {
+		return nil, nil
+	}
+	records, err := lockOrderItemBatches(tx, orderItemID, productID)
+	if err != nil || records == nil {
+		return nil, err
+	}
+
+	restored, err := restockBatches(tx, productID, records, quantity)
+	if err != nil {
+		return nil, err
+	}
+	left := make(map[uuid.UUID]int, len(restored))
+	for _, allocation := range restored {
+		left[allocation.BatchID] += allocation.Quantity
+	}
+	for i := range records {
+		record := &records[i]
+		take := left[record.BatchID]
+		if take > record.Quantity {
+			take = record.Quantity
+		}
+		if take == 0 {
+			continue
+		}
+		left[record.BatchID] -= take
+		if take == record.Quantity {
+			err = tx.Delete(record).Error
+		} else {
+			err = tx.Model(record).Update("quantity", record.Quantity-take).Error
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return restored, nil
+}
+
+// RestockReturnItem إعادة صنف مرتجع من عميل إلى الدفعات التي شُحن منها
+// سجلات صرف الدفعات لا تتغير لأنها ما استلمه العميل فعلاً ويُعتمد عليها في الاستدعاءات،
+// ويُسجل ما عاد إلى كل دفعة في ReturnItemBatch فلا يُعاد من الدفعة أكثر مما شُحن منها.
+func RestockReturnItem(tx *gorm.DB, item *models.ReturnItem) ([]BatchAllocation, error) {
+	if item.Quantity <= 0 {
+		return nil, nil
+	}
+	records, err := lockOrderItemBatches(tx, item.OrderItemID, item.ProductID)
+	if err != nil || records == nil {
+		return nil, err
+	}
+
+	var returned []struct {
+		BatchID  uuid.UUID
+		Quantity int
+	}
+	if err := tx.Model(&models.ReturnItemBatch{}).
+		Select("batch_id, SUM(quantity) AS quantity").
+		Where("order_item_id = ?", item.OrderItemID).
+		Group("batch_id").
+		Scan(&returned).Error; err != nil {
+		return nil, err
+	}
+	previously := make(map[uuid.UUID]int, len(returned))
+	for _, r := range returned {
+		previously[r.BatchID] = r.Quantity
+	}
+	for i := range records {
+		records[i].Quantity -= previously[records[i].BatchID]
+	}
+
+	restored, err := restockBatches(tx, item.ProductID, records, item.Quantity)
+	if err != nil {
+		return nil, err
+	}
+	for _, allocation := range restored {
+		record := models.ReturnItemBatch{
+			ReturnItemID: item.ID,
+			OrderItemID:  item.OrderItemID,
+			BatchID:      allocation.BatchID,
+			BatchNumber:  allocation.BatchNumber,
+			ExpiryDate:   allocation.ExpiryDate,
+			Quantity:     allocation.Quantity,
+		}
+		if err := tx.Create(&record).Error; err != nil {
+			return nil, fmt.Errorf("record returned batch %s: %w", allocation.BatchNumber, err)
+		}
+	}
+	return restored, nil
+}
+
+// lockOrderItemBatches قفل المنتج ثم قراءة دفعات عنصر الطلب بالأبعد انتهاءً أولاً
+// يُعاد nil دون خطأ إذا حُذف المنتج.
+func lockOrderItemBatches(tx *gorm.DB, orderItemID, productID uuid.UUID) ([]models.OrderItemBatch, error) {
+	if _, err := lockProduct(tx, productID); err != nil {
+		if errors.Is(err, ErrProductNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	records := []models.OrderItemBatch{}
+	err := tx.Where("order_item_id = ?", orderItemID).
+		Order("expiry_date DESC NULLS FIRST, created_at DESC").
+		Find(&records).Error
+	return records, err
+}
+
+// restockBatches إعادة كمية إلى الدفعات المعطاة بترتيبها حتى كمية كل منها
+// الكمية التي لا دفعة لها (عناصر سابقة لتتبع الدفعات) تعود إلى أحدث دفعة مستلمة،
+// والمنتج غير المتتبع يعود إلى عداده.
+func restockBatches(tx *gorm.DB, productID uuid.UUID, sources []models.OrderItemBatch, quantity int) ([]BatchAllocation, error) {
+	var restored []BatchAllocation
+	remaining := quantity
+	for _, source := range sources {
+		if remaining == 0 {
+			break
+		}
+		take := source.Quantity
+		if take > remaining {
+			take = remaining
+		}
+		if take <= 0 {
+			continue
+		}
+		if err := tx.Model(&models.ProductBatch{}).Where("id = ?", source.BatchID).
+			Update("quantity", gorm.Expr("quantity + ?", take)).Error; err != nil {
+			return nil, fmt.Errorf("restock batch %s: %w", source.BatchNumber, err)
+		}
+		restored = append(restored, BatchAllocation{BatchID: source.BatchID, BatchNumber: source.BatchNumber, ExpiryDate: source.ExpiryDate, Quantity: take})
+		remaining -= take
+	}
+
+	if remaining > 0 {
+		var latest models.ProductBatch
+		err := tx.Where("product_id = ?", productID).Order("received_at DESC").First(&latest).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return restored, tx.Model(&models.Product{}).Where("id = ?", productID).
+				Update("stock_quantity", gorm.Expr("stock_quantity + ?", remaining)).Error
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := tx.Model(&latest).Update("quantity", gorm.Expr("quantity + ?", remaining)).Error; err != nil {
+			return nil, fmt.Errorf("restock batch %s: %w", latest.BatchNumber, err)
+		}
+		restored = append(restored, BatchAllocation{BatchID: latest.ID, BatchNumber: latest.BatchNumber, ExpiryDate: latest.ExpiryDate, LocationID: latest.LocationID, Quantity: remaining})
+	}
+	return restored, SyncProductStock(tx, productID)
+}
+
+// SyncExpiredBatchStock مزامنة المنتجات التي يختلف مخزونها عن مجموع دفعاتها القابلة للبيع
+// أي التي انتهت إحدى دفعاتها منذ آخر مزامنة؛ كل منتج في معاملة مستقلة.
+func SyncExpiredBatchStock(db *gorm.DB, now time.Time) (int, error) {
+	var productIDs []uuid.UUID
+	if err := db.Table("products").
+		Select("products.id").
+		Joins("JOIN product_batches ON product_batches.product_id = products.id").
+		Group("products.id, products.stock_quantity").
+		Having("products.stock_quantity <> COALESCE(SUM(product_batches.quantity) FILTER (WHERE product_batches.quantity > 0 AND (product_batches.expiry_date IS NULL OR product_batches.expiry_date > ?)), 0)", now).
+		Pluck("products.id", &productIDs).Error; err != nil {
+		return 0, err
+	}
+
+	synced := 0
+	for _, productID := range productIDs {
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			return SyncProductStock(tx, productID)
+		}); err != nil {
+			log.Printf("⚠️ فشل في مزامنة مخزون المنتج %s: %v", productID, err)
+			continue
+		}
+		synced++
+	}
+	return synced, nil
+}
+
+// StartStockSyncScheduler استبعاد الدفعات المنتهية من المخزون دورياً
+// يعمل كل STOCK_SYNC_INTERVAL_MINUTES دقيقة (60 افتراضياً).
+func StartStockSyncScheduler(db *gorm.DB) {
+	interval := time.Duration(envFloat("STOCK_SYNC_INTERVAL_MINUTES", 60)) * time.Minute
+	if interval <= 0 {
+		interval = time.Hour
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if n, err := SyncExpiredBatchStock(db, time.Now()); err != nil {
+				log.Printf("❌ فشل مزامنة مخزون الدفعات المنتهية: %v", err)
+			} else if n > 0 {
+				log.Printf("📦 تم تحديث مخزون %d منتج بعد انتهاء دفعات", n)
+			}
+			<-ticker.C
+		}
+	}()
+	log.Printf("✅ تم تشغيل مزامنة مخزون الدفعات كل %s", interval)
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"pharmacy-backend/models"
+)
+
+func TestPlanFEFO(t *testing.T) {
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	at := func(months int) *time.Time {
+		d := now.AddDate(0, months, 0)
+		return &d
+	}
+	expired := models.ProductBatch{ID: uuid.New(), BatchNumber: "OLD", ExpiryDate: at(-1), Quantity: 10}
+	noExpiry := models.ProductBatch{ID: uuid.New(), BatchNumber: "NOEXP", Quantity: 10}
+	late := models.ProductBatch{ID: uuid.New(), BatchNumber: "LATE", ExpiryDate: at(12), Quantity: 10}
+	soon := models.ProductBatch{ID: uuid.New(), BatchNumber: "SOON", ExpiryDate: at(2), Quantity: 3}
+
+	plan := PlanFEFO([]models.ProductBatch{noExpiry, late, expired, soon}, 5, now)
+	require.Len(t, plan, 2)
+	assert.Equal(t, "SOON", plan[0].BatchNumber)
+	assert.Equal(t, 3, plan[0].Quantity)
+	assert.Equal(t, "LATE", plan[1].BatchNumber)
+	assert.Equal(t, 2, plan[1].Quantity)
+
+	// الدفعة المنتهية لا تُصرف حتى لو لم تكفِ الباقية
+	plan = PlanFEFO([]models.ProductBatch{noExpiry, late, expired, soon}, 50, now)
+	total := 0
+	for _, allocation := range plan {
+		assert.NotEqual(t, "OLD", allocation.BatchNumber)
+		total += allocation.Quantity
+	}
+	assert.Equal(t, 23, total)
+	assert.Equal(t, "NOEXP", plan[len(plan)-1].BatchNumber)
+}
+
+func TestStockAllocationsTake(t *testing.T) {
+	productID := uuid.New()
+	first, second := uuid.New(), uuid.New()
+	allocations := StockAllocations{productID: {
+		{BatchID: first, Quantity: 3},
+		{BatchID: second, Quantity: 4},
+	}}
+
+	// سطران لنفس المنتج يتقاسمان الدفعات بالترتيب
+	taken := allocations.Take(productID, 5)
+	require.Len(t, taken, 2)
+	assert.Equal(t, BatchAllocation{BatchID: first, Quantity: 3}, taken[0])
+	assert.Equal(t, BatchAllocation{BatchID: second, Quantity: 2}, taken[1])
+
+	taken = allocations.Take(productID, 2)
+	assert.Equal(t, []BatchAllocation{{BatchID: second, Quantity: 2}}, taken)
+	assert.Empty(t, allocations.Take(productID, 1))
+	// منتج غير متتبع بالدفعات
+	assert.Empty(t, allocations.Take(uuid.New(), 1))
+}
@@ -0,0 +1,387 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"pharmacy-backend/models"
+)
+
+// أخطاء فواتير الشراء
+var (
+	ErrPurchaseInvoiceNotFound  = errors.New("purchase invoice not found")
+	ErrInvalidPurchaseInvoice   = errors.New("purchase invoice needs a supplier invoice number and at least one line with batch, positive quantity and cost")
+	ErrPurchaseInvoiceState     = errors.New("purchase invoice is not in a state that allows this action")
+	ErrPurchaseInvoiceDuplicate = errors.New("this supplier invoice number is already recorded for the supplier")
+	ErrPurchaseStockConsumed    = errors.New("stock from this invoice has already been sold or adjusted")
+	ErrSupplierNotFound         = errors.New("supplier not found")
+)
+
+// IsPurchaseInvoiceError التحقق مما إذا كان الخطأ من أخطاء فواتير الشراء
+func IsPurchaseInvoiceError(err error) bool {
+	return errors.Is(err, ErrPurchaseInvoiceNotFound) ||
+		errors.Is(err, ErrInvalidPurchaseInvoice) ||
+		errors.Is(err, ErrPurchaseInvoiceState) ||
+		errors.Is(err, ErrPurchaseInvoiceDuplicate) ||
+		errors.Is(err, ErrPurchaseStockConsumed) ||
+		errors.Is(err, ErrSupplierNotFound)
+}
+
+// PurchaseLineInput سطر فاتورة شراء كما يُدخله الموظف
+type PurchaseLineInput struct {
+	ProductID   uuid.UUID
+	BatchNumber string
+	ExpiryDate  *time.Time
+	Quantity    int
+	UnitCost    float64
+}
+
+// PurchaseInvoiceInput بيانات إنشاء أو تعديل مسودة فاتورة شراء
+type PurchaseInvoiceInput struct {
+	SupplierID            uuid.UUID
+	SupplierInvoiceNumber string
+	InvoiceDate           time.Time
+	DueDate               *time.Time
+	LocationID            *uuid.UUID
+	TaxAmount             float64
+	Notes                 string
+	Lines                 []PurchaseLineInput
+}
+
+// buildPurchaseInvoice التحقق من المدخلات وحساب الأسطر والمجاميع
+// دفعة منتهية الصلاحية لا تُقبل في فاتورة شراء.
+func buildPurchaseInvoice(input PurchaseInvoiceInput, now time.Time) (*models.PurchaseInvoice, error) {
+	input.SupplierInvoiceNumber = strings.TrimSpace(input.SupplierInvoiceNumber)
+	if input.SupplierInvoiceNumber == "" || len(input.Lines) == 0 || input.TaxAmount < 0 || input.InvoiceDate.IsZero() {
+		return nil, ErrInvalidPurchaseInvoice
+	}
+	if input.DueDate != nil && input.DueDate.Before(input.InvoiceDate) {
+		return nil, fmt.Errorf("%w: due date is before invoice date", ErrInvalidPurchaseInvoice)
+	}
+
+	invoice := &models.PurchaseInvoice{
+		SupplierID:            input.SupplierID,
+		SupplierInvoiceNumber: input.SupplierInvoiceNumber,
+		InvoiceDate:           input.InvoiceDate,
+		DueDate:               input.DueDate,
+		LocationID:            input.LocationID,
+		Status:                models.PurchaseInvoiceDraft,
+		TaxAmount:             RoundMoney(input.TaxAmount),
+		Notes:                 strings.TrimSpace(input.Notes),
+	}
+	for i, line := range input.Lines {
+		batchNumber := strings.TrimSpace(line.BatchNumber)
+		if line.ProductID == uuid.Nil || batchNumber == "" || line.Quantity < 1 || line.UnitCost < 0 {
+			return nil, fmt.Errorf("%w: line %d", ErrInvalidPurchaseInvoice, i+1)
+		}
+		if line.ExpiryDate != nil && !line.ExpiryDate.After(now) {
+			return nil, fmt.Errorf("line %d: %w", i+1, ErrBatchExpired)
+		}
+		// تكلفة الوحدة المقربة هي تكلفة الدفعة، فيُحسب الإجمالي منها
+		unitCost := RoundMoney(line.UnitCost)
+		total := RoundMoney(unitCost * float64(line.Quantity))
+		invoice.Lines = append(invoice.Lines, models.PurchaseInvoiceLine{
+			ProductID:   line.ProductID,
+			BatchNumber: batchNumber,
+			ExpiryDate:  line.ExpiryDate,
+			Quantity:    line.Quantity,
+			UnitCost:    unitCost,
+			LineTotal:   total,
+			SortOrder:   i,
+		})
+		invoice.Subtotal += total
+	}
+	invoice.Subtotal = RoundMoney(invoice.Subtotal)
+	invoice.TotalAmount = RoundMoney(invoice.Subtotal + invoice.TaxAmount)
+	return invoice, nil
+}
+
+// checkPurchaseReferences التحقق من وجود المورد والمنتجات وعدم تكرار رقم فاتورة المورد
+func checkPurchaseReferences(tx *gorm.DB, invoice *models.PurchaseInvoice) error {
+	var supplierCount int64
+	if err := tx.Model(&models.Supplier{}).Where("id = ?", invoice.SupplierID).Count(&supplierCount).Error; err != nil {
+		return err
+	}
+	if supplierCount == 0 {
+		return ErrSupplierNotFound
+	}
+	if invoice.LocationID != nil {
+		if _, err := resolveLocationID(tx, invoice.LocationID); err != nil {
+			return err
+		}
+	}
+
+	productIDs := make([]uuid.UUID, 0, len(invoice.Lines))
+	seen := make(map[uuid.UUID]bool, len(invoice.Lines))
+	for _, line := range invoice.Lines {
+		if !seen[line.ProductID] {
+			seen[line.ProductID] = true
+			productIDs = append(productIDs, line.ProductID)
+		}
+	}
+	var productCount int64
+	if err := tx.Model(&models.Product{}).Where("id IN ?", productIDs).Count(&productCount).Error; err != nil {
+		return err
+	}
+	if int(productCount) != len(productIDs) {
+		return ErrProductNotFound
+	}
+
+	var duplicates int64
+	query := tx.Model(&models.PurchaseInvoice{}).
+		Where("supplier_id = ? AND supplier_invoice_number = ? AND status <> ?", invoice.SupplierID, invoice.SupplierInvoiceNumber, models.PurchaseInvoiceVoided)
+	if invoice.ID != uuid.Nil {
+		query = query.Where("id <> ?", invoice.ID)
+	}
+	if err := query.Count(&duplicates).Error; err != nil {
+		return err
+	}
+	if duplicates > 0 {
+		return ErrPurchaseInvoiceDuplicate
+	}
+	return nil
+}
+
+// CreatePurchaseInvoice إنشاء فاتورة شراء كمسودة لا تؤثر على المخزون
+func CreatePurchaseInvoice(tx *gorm.DB, input PurchaseInvoiceInput, actorID *uuid.UUID) (*models.PurchaseInvoice, error) {
+	now := time.Now()
+	invoice, err := buildPurchaseInvoice(input, now)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPurchaseReferences(tx, invoice); err != nil {
+		return nil, err
+	}
+
+	number, err := nextDocumentNumber(tx, DocumentPurchase, "PURCHASE_INVOICE_PREFIX", "PUR", now)
+	if err != nil {
+		return nil, err
+	}
+	invoice.InvoiceNumber = number
+	invoice.CreatedBy = actorID
+	if err := tx.Create(invoice).Error; err != nil {
+		return nil, fmt.Errorf("create purchase invoice: %w", err)
+	}
+	return invoice, nil
+}
+
+// lockPurchaseInvoice قفل الفاتورة والتحقق من حالتها المطلوبة
+func lockPurchaseInvoice(tx *gorm.DB, id uuid.UUID, status models.PurchaseInvoiceStatus) (*models.PurchaseInvoice, error) {
+	var invoice models.PurchaseInvoice
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&invoice, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPurchaseInvoiceNotFound
+		}
+		return nil, err
+	}
+	if invoice.Status != status {
+		return nil, fmt.Errorf("%w: invoice is %s", ErrPurchaseInvoiceState, invoice.Status)
+	}
+	if err := tx.Order("sort_order ASC").Find(&invoice.Lines, "purchase_invoice_id = ?", invoice.ID).Error; err != nil {
+		return nil, err
+	}
+	return &invoice, nil
+}
+
+// UpdatePurchaseInvoice استبدال بيانات وأسطر مسودة فاتورة شراء
+func UpdatePurchaseInvoice(tx *gorm.DB, id uuid.UUID, input PurchaseInvoiceInput) (*models.PurchaseInvoice, error) {
+	existing, err := lockPurchaseInvoice(tx, id, models.PurchaseInvoiceDraft)
+	if err != nil {
+		return nil, err
+	}
+	invoice, err := buildPurchaseInvoice(input, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	invoice.ID = existing.ID
+	if err := checkPurchaseReferences(tx, invoice); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Where("purchase_invoice_id = ?", existing.ID).Delete(&models.PurchaseInvoiceLine{}).Error; err != nil {
+		return nil, err
+	}
+	for i := range invoice.Lines {
+		invoice.Lines[i].PurchaseInvoiceID = existing.ID
+	}
+	if err := tx.Create(&invoice.Lines).Error; err != nil {
+		return nil, fmt.Errorf("create purchase invoice lines: %w", err)
+	}
+	if err := tx.Model(existing).Updates(map[string]interface{}{
+		"supplier_id":             invoice.SupplierID,
+		"supplier_invoice_number": invoice.SupplierInvoiceNumber,
+		"invoice_date":            invoice.InvoiceDate,
+		"due_date":                invoice.DueDate,
+		"location_id":             invoice.LocationID,
+		"subtotal":                invoice.Subtotal,
+		"tax_amount":              invoice.TaxAmount,
+		"total_amount":            invoice.TotalAmount,
+		"notes":                   invoice.Notes,
+		"updated_at":              time.Now(),
+	}).Error; err != nil {
+		return nil, err
+	}
+
+	invoice.InvoiceNumber = existing.InvoiceNumber
+	invoice.CreatedBy = existing.CreatedBy
+	invoice.CreatedAt = existing.CreatedAt
+	return invoice, nil
+}
+
+// DeletePurchaseInvoice حذف مسودة فاتورة شراء؛ الفاتورة المرحّلة تُلغى ولا تُحذف
+func DeletePurchaseInvoice(tx *gorm.DB, id uuid.UUID) error {
+	invoice, err := lockPurchaseInvoice(tx, id, models.PurchaseInvoiceDraft)
+	if err != nil {
+		return err
+	}
+	if err := tx.Where("purchase_invoice_id = ?", invoice.ID).Delete(&models.PurchaseInvoiceLine{}).Error; err != nil {
+		return err
+	}
+	return tx.Delete(invoice).Error
+}
+
+// PostPurchaseInvoice ترحيل الفاتورة: كل سطر يصبح دفعة في المخزون مع حركة شراء
+// ويُقيد إجمالي الفاتورة في دفتر المورد فيزيد المستحق له.
+func PostPurchaseInvoice(tx *gorm.DB, id uuid.UUID, actorID *uuid.UUID) (*models.PurchaseInvoice, error) {
+	invoice, err := lockPurchaseInvoice(tx, id, models.PurchaseInvoiceDraft)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range invoice.Lines {
+		line := &invoice.Lines[i]
+		batch, err := ReceiveBatch(tx, BatchReceipt{
+			ProductID:       line.ProductID,
+			BatchNumber:     line.BatchNumber,
+			ExpiryDate:      line.ExpiryDate,
+			Quantity:        line.Quantity,
+			UnitCost:        line.UnitCost,
+			SupplierID:      &invoice.SupplierID,
+			LocationID:      invoice.LocationID,
+			ReferenceNumber: invoice.InvoiceNumber,
+			ActorID:         actorID,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		line.BatchID = &batch.ID
+		if err := tx.Model(line).Update("batch_id", batch.ID).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	if err := RecordSupplierEntry(tx, &models.SupplierLedgerEntry{
+		SupplierID:        invoice.SupplierID,
+		EntryType:         models.SupplierEntryInvoice,
+		EntryDate:         invoice.InvoiceDate,
+		DueDate:           invoice.DueDate,
+		Amount:            invoice.TotalAmount,
+		ReferenceNumber:   invoice.SupplierInvoiceNumber,
+		Description:       fmt.Sprintf("فاتورة شراء %s", invoice.InvoiceNumber),
+		PurchaseInvoiceID: &invoice.ID,
+		CreatedBy:         actorID,
+	}); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	invoice.Status = models.PurchaseInvoicePosted
+	invoice.PostedBy = actorID
+	invoice.PostedAt = &now
+	return invoice, tx.Model(invoice).Updates(map[string]interface{}{
+		"status":     invoice.Status,
+		"posted_by":  actorID,
+		"posted_at":  now,
+		"updated_at": now,
+	}).Error
+}
+
+// VoidPurchaseInvoice إلغاء فاتورة مرحّلة بعكس آثارها على المخزون ورصيد المورد
+// لا يمكن الإلغاء إذا صُرف أو سُوّي جزء من دفعاتها؛ يُعالج ذلك بمرتجع للمورد.
+func VoidPurchaseInvoice(tx *gorm.DB, id uuid.UUID, reason string, actorID *uuid.UUID) (*models.PurchaseInvoice, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, fmt.Errorf("%w: a void reason is required", ErrInvalidPurchaseInvoice)
+	}
+	invoice, err := lockPurchaseInvoice(tx, id, models.PurchaseInvoicePosted)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range invoice.Lines {
+		line := &invoice.Lines[i]
+		if line.BatchID == nil {
+			continue
+		}
+		if _, err := lockProduct(tx, line.ProductID); err != nil {
+			return nil, err
+		}
+		result := tx.Model(&models.ProductBatch{}).
+			Where("id = ? AND quantity = received_quantity", *line.BatchID).
+			Update("quantity", gorm.Expr("quantity - ?", line.Quantity))
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil, fmt.Errorf("line %d (batch %s): %w", i+1, line.BatchNumber, ErrPurchaseStockConsumed)
+		}
+
+		if _, err := RecordStockMovement(tx, StockMovement{
+			ProductID:       line.ProductID,
+			Type:            models.TransactionTypePurchase,
+			Quantity:        -line.Quantity,
+			UnitPrice:       line.UnitCost,
+			SupplierID:      &invoice.SupplierID,
+			ReferenceNumber: invoice.InvoiceNumber,
+			Notes:           fmt.Sprintf("إلغاء فاتورة الشراء %s: %s", invoice.InvoiceNumber, reason),
+			ActorID:         actorID,
+		}); err != nil {
+			return nil, fmt.Errorf("void of batch %s: %w", line.BatchNumber, err)
+		}
+		// الإلغاء يعكس قيمة الاستلام نفسها لأن الدفعة لم يُصرف منها شيء
+		if err := recordCostEntry(tx, &models.InventoryCostEntry{
+			ProductID:       line.ProductID,
+			BatchID:         line.BatchID,
+			Source:          models.CostSourcePurchaseVoid,
+			Quantity:        -line.Quantity,
+			UnitCost:        line.UnitCost,
+			ReferenceNumber: invoice.InvoiceNumber,
+		}); err != nil {
+			return nil, err
+		}
+		if err := SyncProductStock(tx, line.ProductID); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	if err := RecordSupplierEntry(tx, &models.SupplierLedgerEntry{
+		SupplierID:        invoice.SupplierID,
+		EntryType:         models.SupplierEntryInvoiceVoid,
+		EntryDate:         now,
+		Amount:            -invoice.TotalAmount,
+		ReferenceNumber:   invoice.SupplierInvoiceNumber,
+		Description:       fmt.Sprintf("إلغاء فاتورة الشراء %s: %s", invoice.InvoiceNumber, reason),
+		PurchaseInvoiceID: &invoice.ID,
+		CreatedBy:         actorID,
+	}); err != nil {
+		return nil, err
+	}
+
+	invoice.Status = models.PurchaseInvoiceVoided
+	invoice.VoidedBy = actorID
+	invoice.VoidedAt = &now
+	invoice.VoidReason = reason
+	return invoice, tx.Model(invoice).Updates(map[string]interface{}{
+		"status":      invoice.Status,
+		"voided_by":   actorID,
+		"voided_at":   now,
+		"void_reason": reason,
+		"updated_at":  now,
+	}).Error
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildPurchaseInvoice(t *testing.T) {
+	now := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	expiry := now.AddDate(1, 0, 0)
+	input := PurchaseInvoiceInput{
+		SupplierID:            uuid.New(),
+		SupplierInvoiceNumber: " SUP-1001 ",
+		InvoiceDate:           now,
+		TaxAmount:             7.5,
+		Lines: []PurchaseLineInput{
+			{ProductID: uuid.New(), BatchNumber: "B1", ExpiryDate: &expiry, Quantity: 10, UnitCost: 2.5},
+			{ProductID: uuid.New(), BatchNumber: "B2", Quantity: 3, UnitCost: 8.333},
+		},
+	}
+
+	invoice, err := buildPurchaseInvoice(input, now)
+	require.NoError(t, err)
+	assert.Equal(t, "SUP-1001", invoice.SupplierInvoiceNumber)
+	require.Len(t, invoice.Lines, 2)
+	assert.Equal(t, 25.0, invoice.Lines[0].LineTotal)
+	assert.Equal(t, 24.99, invoice.Lines[1].LineTotal)
+	assert.Equal(t, 49.99, invoice.Subtotal)
+	assert.Equal(t, 57.49, invoice.TotalAmount)
+
+	expired := now.AddDate(0, 0, -1)
+	input.Lines[0].ExpiryDate = &expired
+	_, err = buildPurchaseInvoice(input, now)
+	assert.ErrorIs(t, err, ErrBatchExpired)
+
+	input.Lines = nil
+	_, err = buildPurchaseInvoice(input, now)
+	assert.ErrorIs(t, err, ErrInvalidPurchaseInvoice)
+	assert.True(t, IsPurchaseInvoiceError(err))
+}
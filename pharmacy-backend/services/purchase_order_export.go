@@ -0,0 +1,128 @@
+package services
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/go-pdf/fpdf"
+	"pharmacy-backend/models"
+)
+
+// purchaseOrderDate تاريخ الوصول المتوقع أو فارغ
+func purchaseOrderDate(order *models.PurchaseOrder) string {
+	if order.ExpectedDate == nil {
+		return ""
+	}
+	return order.ExpectedDate.Format("2006-01-02")
+}
+
+// RenderPurchaseOrderCSV كتابة أسطر أمر الشراء بصيغة CSV لإرسالها للمورد
+// الأسعار تقديرية بآخر سعر شراء؛ المورد يؤكدها في فاتورته.
+func RenderPurchaseOrderCSV(order *models.PurchaseOrder, w io.Writer) error {
+	writer := csv.NewWriter(w)
+	rows := [][]string{{"order_number", "line", "sku", "product", "quantity", "unit_cost", "line_total"}}
+	quantity := 0
+	for i, line := range order.Lines {
+		rows = append(rows, []string{
+			order.OrderNumber,
+			strconv.Itoa(i + 1),
+			line.SKU,
+			line.Name,
+			strconv.Itoa(line.Quantity),
+			statementMoney(line.UnitCost, false),
+			statementMoney(line.LineTotal, false),
+		})
+		quantity += line.Quantity
+	}
+	rows = append(rows, []string{order.OrderNumber, "", "", "total", strconv.Itoa(quantity), "", statementMoney(order.Subtotal, false)})
+	if err := writer.WriteAll(rows); err != nil {
+		return err
+	}
+	return writer.Error()
+}
+
+// RenderPurchaseOrderPDF رسم أمر الشراء كملف PDF باسم المتجر والمورد
+// يستخدم نفس الخط الذي تحدده INVOICE_PDF_FONT لطباعة الأسماء العربية.
+func RenderPurchaseOrderPDF(order *models.PurchaseOrder, w io.Writer) error {
+	seller := LoadSellerSettings()
+	pdf := fpdf.New("P", "mm", "A4", "")
+	family := "Helvetica"
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	if fontPath := envString("INVOICE_PDF_FONT", ""); fontPath != "" {
+		pdf.AddUTF8Font("InvoiceFont", "", fontPath)
+		pdf.AddUTF8Font("InvoiceFont", "B", fontPath)
+		family = "InvoiceFont"
+		tr = func(s string) string { return s }
+	}
+	pdf.SetMargins(15, 15, 15)
+	pdf.AddPage()
+
+	pdf.SetFont(family, "B", 16)
+	pdf.CellFormat(180, 10, "Purchase Order", "", 1, "L", false, 0, "")
+
+	field := func(label, value string) {
+		if value == "" {
+			return
+		}
+		pdf.SetFont(family, "B", 10)
+		pdf.CellFormat(40, 6, label, "", 0, "L", false, 0, "")
+		pdf.SetFont(family, "", 10)
+		pdf.CellFormat(140, 6, tr(value), "", 1, "L", false, 0, "")
+	}
+	field("PO number", order.OrderNumber)
+	field("Date", order.CreatedAt.Format("2006-01-02"))
+	field("Expected", purchaseOrderDate(order))
+	field("Buyer", seller.Name)
+	field("Buyer VAT no.", seller.VATNumber)
+	field("Deliver to", seller.Address)
+	if order.Supplier != nil {
+		field("Supplier", order.Supplier.Name)
+		field("Supplier VAT no.", order.Supplier.TaxNumber)
+		field("Contact", order.Supplier.ContactPerson)
+	}
+	field("Notes", order.Notes)
+	pdf.Ln(4)
+
+	headers := []string{"#", "SKU", "Product", "Qty", "Unit cost", "Total"}
+	widths := []float64{10, 30, 80, 16, 22, 22}
+	pdf.SetFont(family, "B", 9)
+	pdf.SetFillColor(235, 235, 235)
+	for i, h := range headers {
+		pdf.CellFormat(widths[i], 7, h, "1", 0, "C", true, 0, "")
+	}
+	pdf.Ln(-1)
+
+	pdf.SetFont(family, "", 8)
+	quantity := 0
+	for i, line := range order.Lines {
+		cells := []string{
+			strconv.Itoa(i + 1),
+			tr(line.SKU),
+			tr(line.Name),
+			strconv.Itoa(line.Quantity),
+			statementMoney(line.UnitCost, false),
+			statementMoney(line.LineTotal, false),
+		}
+		for j, cell := range cells {
+			align := "L"
+			if j >= 3 {
+				align = "R"
+			}
+			pdf.CellFormat(widths[j], 7, cell, "1", 0, align, false, 0, "")
+		}
+		pdf.Ln(-1)
+		quantity += line.Quantity
+	}
+	pdf.SetFont(family, "B", 9)
+	pdf.CellFormat(120, 7, "Total", "1", 0, "R", false, 0, "")
+	pdf.CellFormat(16, 7, strconv.Itoa(quantity), "1", 0, "R", false, 0, "")
+	pdf.CellFormat(22, 7, "", "1", 0, "R", false, 0, "")
+	pdf.CellFormat(22, 7, fmt.Sprintf("%.2f %s", order.Subtotal, seller.Currency), "1", 1, "R", false, 0, "")
+
+	if err := pdf.Error(); err != nil {
+		return err
+	}
+	return pdf.Output(w)
+}
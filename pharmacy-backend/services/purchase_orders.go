@@ -0,0 +1,340 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"pharmacy-backend/models"
+)
+
+// أخطاء أوامر الشراء
+var (
+	ErrPurchaseOrderNotFound  = errors.New("purchase order not found")
+	ErrInvalidPurchaseOrder   = errors.New("purchase order needs at least one line with a product and positive quantity")
+	ErrPurchaseOrderState     = errors.New("purchase order is not in a state that allows this action")
+	ErrProductWithoutSupplier = errors.New("product has no supplier assigned")
+)
+
+// IsPurchaseOrderError التحقق مما إذا كان الخطأ من أخطاء أوامر الشراء
+func IsPurchaseOrderError(err error) bool {
+	return errors.Is(err, ErrPurchaseOrderNotFound) ||
+		errors.Is(err, ErrInvalidPurchaseOrder) ||
+		errors.Is(err, ErrPurchaseOrderState) ||
+		errors.Is(err, ErrProductWithoutSupplier) ||
+		errors.Is(err, ErrSupplierNotFound)
+}
+
+// PurchaseOrderLineInput منتج وكمية في أمر شراء
+type PurchaseOrderLineInput struct {
+	ProductID uuid.UUID
+	Quantity  int
+}
+
+// mergePurchaseOrderLines التحقق من الأسطر ودمج المنتج المكرر في سطر واحد بترتيب أول ظهور
+func mergePurchaseOrderLines(lines []PurchaseOrderLineInput) ([]PurchaseOrderLineInput, error) {
+	if len(lines) == 0 {
+		return nil, ErrInvalidPurchaseOrder
+	}
+	merged := make([]PurchaseOrderLineInput, 0, len(lines))
+	index := make(map[uuid.UUID]int, len(lines))
+	for i, line := range lines {
+		if line.ProductID == uuid.Nil || line.Quantity < 1 {
+			return nil, fmt.Errorf("%w: line %d", ErrInvalidPurchaseOrder, i+1)
+		}
+		if at, ok := index[line.ProductID]; ok {
+			merged[at].Quantity += line.Quantity
+			continue
+		}
+		index[line.ProductID] = len(merged)
+		merged = append(merged, line)
+	}
+	return merged, nil
+}
+
+// groupLinesBySupplier توزيع الأسطر على موردي منتجاتها مع حفظ ترتيبها داخل كل مورد
+func groupLinesBySupplier(lines []PurchaseOrderLineInput, products map[uuid.UUID]models.Product) (map[uuid.UUID][]PurchaseOrderLineInput, error) {
+	groups := make(map[uuid.UUID][]PurchaseOrderLineInput)
+	for _, line := range lines {
+		product := products[line.ProductID]
+		if product.SupplierID == nil {
+			return nil, fmt.Errorf("%w: %s", ErrProductWithoutSupplier, product.SKU)
+		}
+		groups[*product.SupplierID] = append(groups[*product.SupplierID], line)
+	}
+	return groups, nil
+}
+
+// loadOrderProducts تحميل منتجات الأسطر والتأكد من وجودها جميعاً
+func loadOrderProducts(tx *gorm.DB, lines []PurchaseOrderLineInput) (map[uuid.UUID]models.Product, error) {
+	ids := make([]uuid.UUID, 0, len(lines))
+	for _, line := range lines {
+		ids = append(ids, line.ProductID)
+	}
+	var products []models.Product
+	if err := tx.Where("id IN ?", ids).Find(&products).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uuid.UUID]models.Product, len(products))
+	for _, product := range products {
+		byID[product.ID] = product
+	}
+	for i, line := range lines {
+		if _, ok := byID[line.ProductID]; !ok {
+			return nil, fmt.Errorf("%w: product on line %d not found", ErrInvalidPurchaseOrder, i+1)
+		}
+	}
+	return byID, nil
+}
+
+// fillPurchaseOrderLines بناء أسطر الأمر بآخر سعر شراء وحساب إجماليه
+func fillPurchaseOrderLines(tx *gorm.DB, order *models.PurchaseOrder, lines []PurchaseOrderLineInput, products map[uuid.UUID]models.Product) error {
+	order.Lines = make([]models.PurchaseOrderLine, 0, len(lines))
+	order.Subtotal = 0
+	for i, line := range lines {
+		cost, err := lastPurchaseCost(tx, line.ProductID)
+		if err != nil {
+			return err
+		}
+		product := products[line.ProductID]
+		unitCost := RoundMoney(cost)
+		total := RoundMoney(unitCost * float64(line.Quantity))
+		order.Lines = append(order.Lines, models.PurchaseOrderLine{
+			PurchaseOrderID: order.ID,
+			ProductID:       line.ProductID,
+			SKU:             product.SKU,
+			Name:            product.Name,
+			Quantity:        line.Quantity,
+			UnitCost:        unitCost,
+			LineTotal:       total,
+			SortOrder:       i,
+		})
+		order.Subtotal += total
+	}
+	order.Subtotal = RoundMoney(order.Subtotal)
+	return nil
+}
+
+// expectedDelivery تاريخ الوصول المتوقع بعد أيام توريد المورد
+func expectedDelivery(from time.Time, supplier *models.Supplier) time.Time {
+	days := supplier.LeadTimeDays
+	if days <= 0 {
+		days = LoadReplenishmentSettings().DefaultLeadTimeDays
+	}
+	return from.AddDate(0, 0, days)
+}
+
+// CreateDraftPurchaseOrders إنشاء مسودة أمر شراء لكل مورد من المنتجات المطلوبة
+// يُجمع كل منتج تحت مورده المسجل، والمنتج الذي لا مورد له يرفض الطلب كاملاً.
+func CreateDraftPurchaseOrders(tx *gorm.DB, lines []PurchaseOrderLineInput, notes string, actorID *uuid.UUID) ([]models.PurchaseOrder, error) {
+	merged, err := mergePurchaseOrderLines(lines)
+	if err != nil {
+		return nil, err
+	}
+	products, err := loadOrderProducts(tx, merged)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := groupLinesBySupplier(merged, products)
+	if err != nil {
+		return nil, err
+	}
+
+	supplierIDs := make([]uuid.UUID, 0, len(groups))
+	for id := range groups {
+		supplierIDs = append(supplierIDs, id)
+	}
+	var suppliers []models.Supplier
+	if err := tx.Where("id IN ?", supplierIDs).Find(&suppliers).Error; err != nil {
+		return nil, err
+	}
+	if len(suppliers) != len(supplierIDs) {
+		return nil, ErrSupplierNotFound
+	}
+	sort.Slice(suppliers, func(i, j int) bool { return suppliers[i].Name < suppliers[j].Name })
+
+	now := time.Now()
+	orders := make([]models.PurchaseOrder, 0, len(suppliers))
+	for i := range suppliers {
+		supplier := &suppliers[i]
+		if !supplier.IsActive {
+			return nil, fmt.Errorf("%w: supplier %s is inactive", ErrInvalidPurchaseOrder, supplier.Name)
+		}
+		number, err := nextDocumentNumber(tx, DocumentPurchaseOrder, "PURCHASE_ORDER_PREFIX", "PO", now)
+		if err != nil {
+			return nil, err
+		}
+		expected := expectedDelivery(now, supplier)
+		order := models.PurchaseOrder{
+			OrderNumber:  number,
+			SupplierID:   supplier.ID,
+			Status:       models.PurchaseOrderDraft,
+			ExpectedDate: &expected,
+			Notes:        strings.TrimSpace(notes),
+			CreatedBy:    actorID,
+		}
+		if err := fillPurchaseOrderLines(tx, &order, groups[supplier.ID], products); err != nil {
+			return nil, err
+		}
+		if err := tx.Create(&order).Error; err != nil {
+			return nil, err
+		}
+		order.Supplier = supplier
+		orders = append(orders, order)
+	}
+	return orders, nil
+}
+
+// lockPurchaseOrder قفل أمر الشراء مع أسطره والتحقق من أن حالته من الحالات المسموحة
+func lockPurchaseOrder(tx *gorm.DB, id uuid.UUID, statuses ...models.PurchaseOrderStatus) (*models.PurchaseOrder, error) {
+	var order models.PurchaseOrder
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPurchaseOrderNotFound
+		}
+		return nil, err
+	}
+	allowed := false
+	for _, status := range statuses {
+		allowed = allowed || order.Status == status
+	}
+	if !allowed {
+		return nil, fmt.Errorf("%w: purchase order is %s", ErrPurchaseOrderState, order.Status)
+	}
+	if err := tx.Order("sort_order ASC").Find(&order.Lines, "purchase_order_id = ?", order.ID).Error; err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// LoadPurchaseOrder تحميل أمر الشراء مع المورد والأسطر بترتيبها
+func LoadPurchaseOrder(db *gorm.DB, id uuid.UUID) (*models.PurchaseOrder, error) {
+	var order models.PurchaseOrder
+	err := db.Preload("Supplier").
+		Preload("Lines", func(q *gorm.DB) *gorm.DB { return q.Order("sort_order ASC") }).
+		First(&order, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrPurchaseOrderNotFound
+	}
+	return &order, err
+}
+
+// UpdatePurchaseOrder استبدال أسطر وملاحظات مسودة أمر الشراء
+// يمكن إضافة منتج من مورد آخر إذا كان المورد يوفره، فلا يُشترط مورد المنتج هنا.
+func UpdatePurchaseOrder(tx *gorm.DB, id uuid.UUID, lines []PurchaseOrderLineInput, notes string) (*models.PurchaseOrder, error) {
+	order, err := lockPurchaseOrder(tx, id, models.PurchaseOrderDraft)
+	if err != nil {
+		return nil, err
+	}
+	merged, err := mergePurchaseOrderLines(lines)
+	if err != nil {
+		return nil, err
+	}
+	products, err := loadOrderProducts(tx, merged)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Where("purchase_order_id = ?", order.ID).Delete(&models.PurchaseOrderLine{}).Error; err != nil {
+		return nil, err
+	}
+	if err := fillPurchaseOrderLines(tx, order, merged, products); err != nil {
+		return nil, err
+	}
+	if err := tx.Create(&order.Lines).Error; err != nil {
+		return nil, err
+	}
+	order.Notes = strings.TrimSpace(notes)
+	order.UpdatedAt = time.Now()
+	return order, tx.Model(order).Updates(map[string]interface{}{
+		"subtotal":   order.Subtotal,
+		"notes":      order.Notes,
+		"updated_at": order.UpdatedAt,
+	}).Error
+}
+
+// SendPurchaseOrder اعتماد المسودة كمرسلة للمورد وإعادة حساب تاريخ الوصول من يوم الإرسال
+func SendPurchaseOrder(tx *gorm.DB, id uuid.UUID, actorID *uuid.UUID) (*models.PurchaseOrder, error) {
+	order, err := lockPurchaseOrder(tx, id, models.PurchaseOrderDraft)
+	if err != nil {
+		return nil, err
+	}
+	var supplier models.Supplier
+	if err := tx.First(&supplier, "id = ?", order.SupplierID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSupplierNotFound
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	expected := expectedDelivery(now, &supplier)
+	order.Status = models.PurchaseOrderSent
+	order.SentBy = actorID
+	order.SentAt = &now
+	order.ExpectedDate = &expected
+	order.Supplier = &supplier
+	return order, tx.Model(order).Updates(map[string]interface{}{
+		"status":        order.Status,
+		"sent_by":       actorID,
+		"sent_at":       now,
+		"expected_date": expected,
+		"updated_at":    now,
+	}).Error
+}
+
+// ReceivePurchaseOrder إغلاق الأمر المرسل بعد وصول البضاعة، مع ربطه بفاتورة الشراء المرحلة إن وُجدت
+// البضاعة نفسها تدخل المخزون بترحيل فاتورة الشراء وليس من هنا.
+func ReceivePurchaseOrder(tx *gorm.DB, id uuid.UUID, purchaseInvoiceID *uuid.UUID) (*models.PurchaseOrder, error) {
+	order, err := lockPurchaseOrder(tx, id, models.PurchaseOrderSent)
+	if err != nil {
+		return nil, err
+	}
+	if purchaseInvoiceID != nil {
+		var invoice models.PurchaseInvoice
+		if err := tx.First(&invoice, "id = ?", *purchaseInvoiceID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrPurchaseInvoiceNotFound
+			}
+			return nil, err
+		}
+		if invoice.SupplierID != order.SupplierID || invoice.Status != models.PurchaseInvoicePosted {
+			return nil, fmt.Errorf("%w: invoice must be posted and from the same supplier", ErrInvalidPurchaseOrder)
+		}
+	}
+
+	now := time.Now()
+	order.Status = models.PurchaseOrderReceived
+	order.ReceivedAt = &now
+	order.PurchaseInvoiceID = purchaseInvoiceID
+	return order, tx.Model(order).Updates(map[string]interface{}{
+		"status":              order.Status,
+		"received_at":         now,
+		"purchase_invoice_id": purchaseInvoiceID,
+		"updated_at":          now,
+	}).Error
+}
+
+// CancelPurchaseOrder إلغاء مسودة أو أمر مرسل لم يصل، فتخرج كمياته من "قيد الطلب"
+func CancelPurchaseOrder(tx *gorm.DB, id uuid.UUID, reason string) (*models.PurchaseOrder, error) {
+	order, err := lockPurchaseOrder(tx, id, models.PurchaseOrderDraft, models.PurchaseOrderSent)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	order.Status = models.PurchaseOrderCancelled
+	order.CancelledAt = &now
+	order.CancelReason = strings.TrimSpace(reason)
+	return order, tx.Model(order).Updates(map[string]interface{}{
+		"status":        order.Status,
+		"cancelled_at":  now,
+		"cancel_reason": order.CancelReason,
+		"updated_at":    now,
+	}).Error
+}
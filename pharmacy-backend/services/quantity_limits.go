@@ -0,0 +1,237 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"pharmacy-backend/models"
+)
+
+// أخطاء حدود الكمية
+var (
+	ErrQuantityLimitExceeded = errors.New("quantity limit exceeded for this period")
+	ErrRetailOnlyProduct     = errors.New("product can only be sold to retail customers")
+	ErrInvalidQuantityRule   = errors.New("quantity limit rule must target one product or category with a positive quantity and period")
+)
+
+// مصادر محاولات الشراء في تقرير المحاولات المرفوضة
+const (
+	QuantityLimitSourceCart         = "cart"
+	QuantityLimitSourceOrder        = "order"
+	QuantityLimitSourceSubscription = "subscription"
+	QuantityLimitSourceOrderEdit    = "order_edit"
+)
+
+// QuantityLimitError تفاصيل تجاوز حد الكمية لعرضها للعميل وتسجيلها
+type QuantityLimitError struct {
+	Rule        models.QuantityLimitRule
+	Type        models.QuantityLimitViolationType
+	ProductID   uuid.UUID
+	ProductName string
+	Requested   int
+	Purchased   int
+}
+
+func (e *QuantityLimitError) Error() string {
+	if e.Type == models.QuantityLimitRetailOnly {
+		return fmt.Sprintf("%s: %v", e.ProductName, ErrRetailOnlyProduct)
+	}
+	msg := fmt.Sprintf("%s: maximum %d per %d days (purchased %d, requested %d, remaining %d)",
+		e.ProductName, e.Rule.MaxQuantity, e.Rule.PeriodDays, e.Purchased, e.Requested, e.Remaining())
+	if e.Rule.Reason != "" {
+		msg += " - " + e.Rule.Reason
+	}
+	return msg
+}
+
+func (e *QuantityLimitError) Unwrap() error {
+	if e.Type == models.QuantityLimitRetailOnly {
+		return ErrRetailOnlyProduct
+	}
+	return ErrQuantityLimitExceeded
+}
+
+// Remaining الكمية التي ما زال يمكن شراؤها خلال الفترة
+func (e *QuantityLimitError) Remaining() int {
+	if remaining := e.Rule.MaxQuantity - e.Purchased; remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// IsQuantityLimitError التحقق مما إذا كان الخطأ ناتجاً عن حد كمية
+func IsQuantityLimitError(err error) bool {
+	return errors.Is(err, ErrQuantityLimitExceeded) || errors.Is(err, ErrRetailOnlyProduct)
+}
+
+// ValidateQuantityLimitRule التحقق من أن القاعدة تستهدف منتجاً أو تصنيفاً واحداً بقيم موجبة
+func ValidateQuantityLimitRule(rule *models.QuantityLimitRule) error {
+	if (rule.ProductID == nil) == (rule.CategoryID == nil) || rule.MaxQuantity < 1 || rule.PeriodDays < 1 {
+		return ErrInvalidQuantityRule
+	}
+	return nil
+}
+
+// LimitedLine منتج وكمية في سلة أو طلب يُفحص مقابل القواعد
+type LimitedLine struct {
+	Product  models.Product
+	Quantity int
+}
+
+// matchingQuantityRules القواعد الفعالة التي تخص منتجات السطور أو تصنيفاتها
+func matchingQuantityRules(db *gorm.DB, lines []LimitedLine) ([]models.QuantityLimitRule, error) {
+	if len(lines) == 0 {
+		return nil, nil
+	}
+	productIDs := make([]uuid.UUID, 0, len(lines))
+	categoryIDs := make([]uuid.UUID, 0, len(lines))
+	for _, line := range lines {
+		productIDs = append(productIDs, line.Product.ID)
+		categoryIDs = append(categoryIDs, line.Product.CategoryID)
+	}
+	var rules []models.QuantityLimitRule
+	err := db.Where("is_active = ? AND (product_id IN ? OR category_id IN ?)", true, productIDs, categoryIDs).
+		Order("created_at ASC").
+		Find(&rules).Error
+	return rules, err
+}
+
+// purchasedQuantity الكمية التي اشتراها العميل ضمن القاعدة منذ since في طلبات غير ملغاة
+// الطلب المستثنى هو الطلب الجاري تعديله، فسطوره تُحتسب كمطلوبة لا كمشتراة.
+func purchasedQuantity(db *gorm.DB, userID uuid.UUID, rule *models.QuantityLimitRule, since time.Time, excludeOrderID *uuid.UUID) (int, error) {
+	query := db.Table("order_items").
+		Joins("JOIN orders ON orders.id = order_items.order_id").
+		Where("orders.user_id = ? AND orders.status <> ? AND orders.created_at >= ?", userID, models.OrderStatusCancelled, since)
+	if excludeOrderID != nil {
+		query = query.Where("orders.id <> ?", *excludeOrderID)
+	}
+	if rule.ProductID != nil {
+		query = query.Where("order_items.product_id = ?", *rule.ProductID)
+	} else {
+		query = query.Joins("JOIN products ON products.id = order_items.product_id").
+			Where("products.category_id = ?", *rule.CategoryID)
+	}
+	var total int
+	err := query.Select("COALESCE(SUM(order_items.quantity), 0)").Scan(&total).Error
+	return total, err
+}
+
+// checkQuantityLimits فحص السطور مقابل القواعد وسجل مشتريات العميل
+// lockUser يسلسل طلبات العميل نفسه حتى نهاية المعاملة فلا يتجاوز طلبان متزامنان الحد معاً.
+func checkQuantityLimits(db *gorm.DB, user *models.User, lines []LimitedLine, now time.Time, lockUser bool, excludeOrderID *uuid.UUID) error {
+	rules, err := matchingQuantityRules(db, lines)
+	if err != nil || len(rules) == 0 {
+		return err
+	}
+	if lockUser {
+		if err := db.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "quantity_limit:"+user.ID.String()).Error; err != nil {
+			return err
+		}
+	}
+
+	for i := range rules {
+		rule := &rules[i]
+		requested := 0
+		var first *models.Product
+		for j := range lines {
+			if rule.AppliesTo(&lines[j].Product) {
+				requested += lines[j].Quantity
+				if first == nil {
+					first = &lines[j].Product
+				}
+			}
+		}
+		if first == nil {
+			continue
+		}
+
+		limitErr := &QuantityLimitError{Rule: *rule, ProductID: first.ID, ProductName: first.Name, Requested: requested}
+		if rule.RetailOnly && CanBuyWholesale(user) {
+			limitErr.Type = models.QuantityLimitRetailOnly
+			return limitErr
+		}
+		purchased, err := purchasedQuantity(db, user.ID, rule, now.AddDate(0, 0, -rule.PeriodDays), excludeOrderID)
+		if err != nil {
+			return err
+		}
+		if purchased+requested > rule.MaxQuantity {
+			limitErr.Type = models.QuantityLimitExceeded
+			limitErr.Purchased = purchased
+			return limitErr
+		}
+	}
+	return nil
+}
+
+// CheckOrderQuantityLimits فحص حدود الكمية لطلب مسعّر داخل معاملة إنشائه
+func CheckOrderQuantityLimits(tx *gorm.DB, user *models.User, lines []QuotedLine, now time.Time) error {
+	limited := make([]LimitedLine, 0, len(lines))
+	for _, line := range lines {
+		limited = append(limited, LimitedLine{Product: line.Product, Quantity: line.Quantity})
+	}
+	return checkQuantityLimits(tx, user, limited, now, true, nil)
+}
+
+// CheckOrderEditQuantityLimits فحص عناصر الطلب بعد تعديله مقابل حدود صاحبه
+// كميات الطلب نفسه بعد التعديل هي المطلوبة، والمشتريات تُحتسب من طلباته الأخرى.
+func CheckOrderEditQuantityLimits(tx *gorm.DB, order *models.Order, now time.Time) error {
+	var user models.User
+	if err := tx.First(&user, "id = ?", order.UserID).Error; err != nil {
+		return err
+	}
+	var items []models.OrderItem
+	if err := tx.Preload("Product").Where("order_id = ?", order.ID).Find(&items).Error; err != nil {
+		return err
+	}
+	lines := make([]LimitedLine, 0, len(items))
+	for _, item := range items {
+		if item.Product.ID != uuid.Nil {
+			lines = append(lines, LimitedLine{Product: item.Product, Quantity: item.Quantity})
+		}
+	}
+	return checkQuantityLimits(tx, &user, lines, now, true, &order.ID)
+}
+
+// CheckCartQuantityLimits فحص السلة بعد جعل كمية المنتج quantity (إضافة أو تعديل)
+// قواعد التصنيف تحتسب باقي منتجات التصنيف الموجودة في السلة.
+func CheckCartQuantityLimits(db *gorm.DB, user *models.User, product *models.Product, quantity int) error {
+	var cartItems []models.CartItem
+	if err := db.Preload("Product").Where("user_id = ?", user.ID).Find(&cartItems).Error; err != nil {
+		return err
+	}
+	lines := []LimitedLine{{Product: *product, Quantity: quantity}}
+	for _, item := range cartItems {
+		if item.ProductID != product.ID {
+			lines = append(lines, LimitedLine{Product: item.Product, Quantity: item.Quantity})
+		}
+	}
+	return checkQuantityLimits(db, user, lines, time.Now(), false, nil)
+}
+
+// RecordQuantityLimitViolation تسجيل محاولة الشراء المرفوضة لتقرير الإدارة
+// لا يفعل شيئاً إذا لم يكن الخطأ خطأ حد كمية؛ فشل التسجيل لا يغير استجابة العميل.
+func RecordQuantityLimitViolation(db *gorm.DB, userID uuid.UUID, source string, err error) {
+	var limitErr *QuantityLimitError
+	if !errors.As(err, &limitErr) {
+		return
+	}
+	violation := models.QuantityLimitViolation{
+		RuleID:            limitErr.Rule.ID,
+		UserID:            userID,
+		ProductID:         limitErr.ProductID,
+		ProductName:       limitErr.ProductName,
+		Type:              limitErr.Type,
+		Source:            source,
+		RequestedQuantity: limitErr.Requested,
+		PurchasedQuantity: limitErr.Purchased,
+		MaxQuantity:       limitErr.Rule.MaxQuantity,
+		PeriodDays:        limitErr.Rule.PeriodDays,
+	}
+	if err := db.Create(&violation).Error; err != nil {
+		log.Printf("⚠️ فشل في تسجيل محاولة تجاوز حد الكمية: %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"pharmacy-backend/models"
+)
+
+func TestValidateQuantityLimitRule(t *testing.T) {
+	productID, categoryID := uuid.New(), uuid.New()
+
+	assert.NoError(t, ValidateQuantityLimitRule(&models.QuantityLimitRule{ProductID: &productID, MaxQuantity: 2, PeriodDays: 30}))
+	assert.NoError(t, ValidateQuantityLimitRule(&models.QuantityLimitRule{CategoryID: &categoryID, MaxQuantity: 2, PeriodDays: 30}))
+	// القاعدة تستهدف منتجاً أو تصنيفاً واحداً فقط
+	assert.ErrorIs(t, ValidateQuantityLimitRule(&models.QuantityLimitRule{ProductID: &productID, CategoryID: &categoryID, MaxQuantity: 2, PeriodDays: 30}), ErrInvalidQuantityRule)
+	assert.ErrorIs(t, ValidateQuantityLimitRule(&models.QuantityLimitRule{MaxQuantity: 2, PeriodDays: 30}), ErrInvalidQuantityRule)
+	assert.ErrorIs(t, ValidateQuantityLimitRule(&models.QuantityLimitRule{ProductID: &productID, MaxQuantity: 0, PeriodDays: 30}), ErrInvalidQuantityRule)
+}
+
+func TestQuantityLimitRuleAppliesTo(t *testing.T) {
+	product := models.Product{ID: uuid.New(), CategoryID: uuid.New()}
+	other := uuid.New()
+
+	assert.True(t, (&models.QuantityLimitRule{ProductID: &product.ID}).AppliesTo(&product))
+	assert.True(t, (&models.QuantityLimitRule{CategoryID: &product.CategoryID}).AppliesTo(&product))
+	assert.False(t, (&models.QuantityLimitRule{ProductID: &other}).AppliesTo(&product))
+}
+
+func TestQuantityLimitError(t *testing.T) {
+	err := &QuantityLimitError{
+		Rule:        models.QuantityLimitRule{MaxQuantity: 2, PeriodDays: 30, Reason: "Controlled substance"},
+		Type:        models.QuantityLimitExceeded,
+		ProductName: "Codeine syrup",
+		Requested:   2,
+		Purchased:   1,
+	}
+	assert.Equal(t, 1, err.Remaining())
+	assert.ErrorIs(t, err, ErrQuantityLimitExceeded)
+	assert.True(t, IsQuantityLimitError(err))
+	assert.Contains(t, err.Error(), "maximum 2 per 30 days")
+
+	err.Purchased = 5
+	assert.Equal(t, 0, err.Remaining())
+
+	err.Type = models.QuantityLimitRetailOnly
+	assert.ErrorIs(t, err, ErrRetailOnlyProduct)
+}
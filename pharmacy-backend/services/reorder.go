@@ -0,0 +1,149 @@
+package services
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"pharmacy-backend/models"
+)
+
+type ReorderReason string
+
+// أسباب تعديل أو استبعاد عنصر عند إعادة الطلب
+const (
+	ReorderReasonLimitedStock     ReorderReason = "limited_stock"
+	ReorderReasonOutOfStock       ReorderReason = "out_of_stock"
+	ReorderReasonInactive         ReorderReason = "inactive"
+	ReorderReasonUnpublished      ReorderReason = "unpublished"
+	ReorderReasonWholesaleOnly    ReorderReason = "wholesale_only"
+	ReorderReasonProductNotFound  ReorderReason = "product_not_found"
+	ReorderReasonCartAtStockLimit ReorderReason = "cart_at_stock_limit"
+)
+
+// ReorderLine نتيجة نسخ عنصر واحد من الطلب السابق إلى السلة
+type ReorderLine struct {
+	ProductID         uuid.UUID     `json:"product_id"`
+	Name              string        `json:"name"`
+	RequestedQuantity int           `json:"requested_quantity"`
+	Quantity          int           `json:"quantity"` // الكمية المضافة فعلياً للسلة
+	PreviousUnitPrice float64       `json:"previous_unit_price"`
+	UnitPrice         float64       `json:"unit_price,omitempty"` // السعر الحالي
+	PriceChanged      bool          `json:"price_changed"`
+	Reason            ReorderReason `json:"reason,omitempty"`
+}
+
+// ReorderReport تقرير إعادة الطلب: ما أضيف كما هو، وما عُدلت كميته، وما استُبعد
+type ReorderReport struct {
+	OrderID  uuid.UUID     `json:"order_id"`
+	Added    []ReorderLine `json:"added"`
+	Adjusted []ReorderLine `json:"adjusted"`
+	Dropped  []ReorderLine `json:"dropped"`
+}
+
+// ReorderToCart نسخ عناصر طلب سابق إلى سلة المستخدم بالأسعار الحالية
+// تُطبق قواعد الإتاحة نفسها التي يطبقها إنشاء الطلب، وتُحدد الكمية بالمخزون
+// المتبقي بعد ما هو موجود في السلة مسبقاً.
+func ReorderToCart(tx *gorm.DB, user *models.User, order *models.Order) (*ReorderReport, error) {
+	var items []models.OrderItem
+	if err := tx.Where("order_id = ?", order.ID).Order("created_at ASC").Find(&items).Error; err != nil {
+		return nil, err
+	}
+
+	// المنتج المكرر في الطلب يُجمع في سطر واحد
+	var lines []ReorderLine
+	index := map[uuid.UUID]int{}
+	for _, item := range items {
+		if i, ok := index[item.ProductID]; ok {
+			lines[i].RequestedQuantity += item.Quantity
+			continue
+		}
+		index[item.ProductID] = len(lines)
+		lines = append(lines, ReorderLine{
+			ProductID:         item.ProductID,
+			Name:              item.Name,
+			RequestedQuantity: item.Quantity,
+			PreviousUnitPrice: item.UnitPrice,
+		})
+	}
+
+	report := &ReorderReport{OrderID: order.ID, Added: []ReorderLine{}, Adjusted: []ReorderLine{}, Dropped: []ReorderLine{}}
+	for _, line := range lines {
+		var product models.Product
+		if err := tx.First(&product, "id = ?", line.ProductID).Error; err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, err
+			}
+			line.Reason = ReorderReasonProductNotFound
+			report.Dropped = append(report.Dropped, line)
+			continue
+		}
+		line.Name = product.Name
+		line.UnitPrice = RoundMoney(product.GetDiscountedPrice())
+		line.PriceChanged = !moneyEqual(line.UnitPrice, line.PreviousUnitPrice)
+
+		if reason := reorderUnavailableReason(user, &product); reason != "" {
+			line.Reason = reason
+			report.Dropped = append(report.Dropped, line)
+			continue
+		}
+
+		var cartItem models.CartItem
+		err := tx.Where("user_id = ? AND product_id = ?", user.ID, product.ID).First(&cartItem).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		inCart := 0
+		if err == nil {
+			inCart = cartItem.Quantity
+		}
+
+		available := product.StockQuantity - inCart
+		if available <= 0 {
+			line.Reason = ReorderReasonOutOfStock
+			if product.StockQuantity > 0 {
+				line.Reason = ReorderReasonCartAtStockLimit
+			}
+			report.Dropped = append(report.Dropped, line)
+			continue
+		}
+
+		line.Quantity = line.RequestedQuantity
+		if line.Quantity > available {
+			line.Quantity = available
+			line.Reason = ReorderReasonLimitedStock
+		}
+
+		if inCart > 0 {
+			err = tx.Model(&cartItem).Update("quantity", inCart+line.Quantity).Error
+		} else {
+			err = tx.Create(&models.CartItem{UserID: user.ID, ProductID: product.ID, Quantity: line.Quantity}).Error
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if line.Reason != "" {
+			report.Adjusted = append(report.Adjusted, line)
+		} else {
+			report.Added = append(report.Added, line)
+		}
+	}
+	return report, nil
+}
+
+// reorderUnavailableReason سبب عدم إمكانية شراء المنتج الآن، أو فارغ إذا كان متاحاً
+func reorderUnavailableReason(user *models.User, product *models.Product) ReorderReason {
+	if !product.IsActive {
+		return ReorderReasonInactive
+	}
+	if product.Type == models.ProductTypeWholesale {
+		if !CanBuyWholesale(user) {
+			return ReorderReasonWholesaleOnly
+		}
+		if !product.PublishedWholesale {
+			return ReorderReasonUnpublished
+		}
+	}
+	return ""
+}
@@ -0,0 +1,274 @@
+package services
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"pharmacy-backend/models"
+)
+
+// ReplenishmentSettings إعدادات اقتراح إعادة التزويد بالأيام
+// تُقرأ من نفس متغيرات البيئة التي تعرضها إعدادات المتجر في لوحة التحكم.
+type ReplenishmentSettings struct {
+	SalesWindowDays     int // فترة المبيعات التي تُحسب منها سرعة البيع
+	SafetyStockDays     int // أيام بيع إضافية تغطي تذبذب الطلب والتأخير
+	CoverDays           int // الأيام التي يغطيها أمر الشراء بعد وصوله
+	DefaultLeadTimeDays int // للمنتج الذي لا مورد له
+}
+
+// LoadReplenishmentSettings قراءة إعدادات إعادة التزويد الحالية
+func LoadReplenishmentSettings() ReplenishmentSettings {
+	settings := ReplenishmentSettings{
+		SalesWindowDays:     int(envFloat("REPLENISHMENT_SALES_WINDOW_DAYS", 90)),
+		SafetyStockDays:     int(envFloat("SAFETY_STOCK_DAYS", 7)),
+		CoverDays:           int(envFloat("REORDER_COVER_DAYS", 30)),
+		DefaultLeadTimeDays: int(envFloat("DEFAULT_LEAD_TIME_DAYS", 7)),
+	}
+	if settings.SalesWindowDays < 1 {
+		settings.SalesWindowDays = 90
+	}
+	if settings.SafetyStockDays < 0 {
+		settings.SafetyStockDays = 0
+	}
+	if settings.CoverDays < 0 {
+		settings.CoverDays = 0
+	}
+	if settings.DefaultLeadTimeDays < 0 {
+		settings.DefaultLeadTimeDays = 0
+	}
+	return settings
+}
+
+// replenishmentInput بيانات منتج واحد اللازمة لحساب الاقتراح
+type replenishmentInput struct {
+	UnitsSold     int
+	WindowDays    int
+	LeadTimeDays  int
+	SafetyDays    int
+	CoverDays     int
+	MinStockLevel int
+	OnHand        int
+	OnOrder       int
+}
+
+// replenishmentPlan نتيجة الحساب لمنتج واحد
+type replenishmentPlan struct {
+	DailyVelocity     float64
+	SafetyStock       int
+	ReorderPoint      int
+	TargetStock       int
+	SuggestedQuantity int
+}
+
+// ceilUnits تقريب الكمية المتوقعة لأعلى مع تجاهل أخطاء الفاصلة العائمة
+func ceilUnits(v float64) int {
+	return int(math.Ceil(v - 1e-9))
+}
+
+// planReplenishment حساب نقطة إعادة الطلب والكمية المقترحة
+// مخزون الأمان مبيعات أيام الأمان ولا يقل عن الحد الأدنى للمنتج، ونقطة إعادة الطلب
+// مبيعات مدة التوريد مضافاً إليها مخزون الأمان. إذا بلغ المخزون المتاح مع ما هو قيد الطلب
+// نقطة إعادة الطلب يُقترح ما يرفعه إلى مبيعات مدة التوريد وأيام التغطية فوق مخزون الأمان.
+func planReplenishment(in replenishmentInput) replenishmentPlan {
+	var plan replenishmentPlan
+	if in.WindowDays > 0 && in.UnitsSold > 0 {
+		plan.DailyVelocity = float64(in.UnitsSold) / float64(in.WindowDays)
+	}
+	plan.SafetyStock = ceilUnits(plan.DailyVelocity * float64(in.SafetyDays))
+	if plan.SafetyStock < in.MinStockLevel {
+		plan.SafetyStock = in.MinStockLevel
+	}
+	plan.ReorderPoint = ceilUnits(plan.DailyVelocity*float64(in.LeadTimeDays)) + plan.SafetyStock
+	plan.TargetStock = ceilUnits(plan.DailyVelocity*float64(in.LeadTimeDays+in.CoverDays)) + plan.SafetyStock
+
+	position := in.OnHand + in.OnOrder
+	// المنتج الراكد عند حده الأدنى تماماً لا يحتاج طلباً
+	if position < plan.ReorderPoint || (position == plan.ReorderPoint && plan.DailyVelocity > 0) {
+		if quantity := plan.TargetStock - position; quantity > 0 {
+			plan.SuggestedQuantity = quantity
+		}
+	}
+	return plan
+}
+
+// ReplenishmentFilter تضييق اقتراحات إعادة التزويد
+type ReplenishmentFilter struct {
+	SupplierID *uuid.UUID
+	CategoryID *uuid.UUID
+	IncludeAll bool // إظهار المنتجات التي لا تحتاج طلباً أيضاً
+}
+
+// ReplenishmentSuggestion اقتراح إعادة تزويد لمنتج
+type ReplenishmentSuggestion struct {
+	ProductID         uuid.UUID  `json:"product_id"`
+	SKU               string     `json:"sku"`
+	Name              string     `json:"name"`
+	SupplierID        *uuid.UUID `json:"supplier_id,omitempty"`
+	SupplierName      string     `json:"supplier_name,omitempty"`
+	LeadTimeDays      int        `json:"lead_time_days"`
+	UnitsSold         int        `json:"units_sold"`
+	DailyVelocity     float64    `json:"daily_velocity"`
+	StockQuantity     int        `json:"stock_quantity"`
+	OnOrder           int        `json:"on_order"`
+	MinStockLevel     int        `json:"min_stock_level"`
+	SafetyStock       int        `json:"safety_stock"`
+	ReorderPoint      int        `json:"reorder_point"`
+	TargetStock       int        `json:"target_stock"`
+	DaysOfCover       *float64   `json:"days_of_cover,omitempty"` // فارغ إذا لم تكن هناك مبيعات
+	SuggestedQuantity int        `json:"suggested_quantity"`
+	UnitCost          float64    `json:"unit_cost"`
+	EstimatedCost     float64    `json:"estimated_cost"`
+}
+
+// ReplenishmentReport اقتراحات إعادة التزويد مع الإعدادات المستخدمة في حسابها
+type ReplenishmentReport struct {
+	GeneratedAt   time.Time                 `json:"generated_at"`
+	Settings      ReplenishmentSettings     `json:"settings"`
+	Suggestions   []ReplenishmentSuggestion `json:"suggestions"`
+	TotalQuantity int                       `json:"total_quantity"`
+	TotalCost     float64                   `json:"total_cost"`
+}
+
+// productQuantities مجموع كمية لكل منتج من استعلام مجمع
+type productQuantities struct {
+	ProductID uuid.UUID
+	Quantity  int
+}
+
+// sumByProduct تحويل نتيجة استعلام مجمع إلى خريطة
+func sumByProduct(query *gorm.DB) (map[uuid.UUID]int, error) {
+	var rows []productQuantities
+	if err := query.Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	sums := make(map[uuid.UUID]int, len(rows))
+	for _, row := range rows {
+		sums[row.ProductID] = row.Quantity
+	}
+	return sums, nil
+}
+
+// BuildReplenishmentReport اقتراح كميات إعادة الطلب للمنتجات النشطة
+// سرعة البيع من عناصر الطلبات غير الملغاة خلال فترة المبيعات، ومدة التوريد من المورد،
+// وما في أوامر الشراء المفتوحة يُحسب ضمن المخزون حتى لا يتكرر الطلب.
+func BuildReplenishmentReport(db *gorm.DB, now time.Time, filter ReplenishmentFilter) (*ReplenishmentReport, error) {
+	settings := LoadReplenishmentSettings()
+
+	type productRow struct {
+		ID            uuid.UUID
+		SKU           string
+		Name          string
+		StockQuantity int
+		MinStockLevel int
+		SupplierID    *uuid.UUID
+		SupplierName  *string
+		LeadTimeDays  *int
+	}
+	query := db.Table("products AS p").
+		Select("p.id, p.sku, p.name, p.stock_quantity, p.min_stock_level, p.supplier_id, s.name AS supplier_name, s.lead_time_days").
+		Joins("LEFT JOIN suppliers s ON s.id = p.supplier_id").
+		Where("p.is_active = ?", true)
+	if filter.SupplierID != nil {
+		query = query.Where("p.supplier_id = ?", *filter.SupplierID)
+	}
+	if filter.CategoryID != nil {
+		query = query.Where("p.category_id = ?", *filter.CategoryID)
+	}
+	var products []productRow
+	if err := query.Scan(&products).Error; err != nil {
+		return nil, err
+	}
+
+	sold, err := sumByProduct(db.Table("order_items AS oi").
+		Select("oi.product_id, SUM(oi.quantity) AS quantity").
+		Joins("JOIN orders o ON o.id = oi.order_id").
+		Where("o.status <> ? AND o.created_at >= ?", models.OrderStatusCancelled, now.AddDate(0, 0, -settings.SalesWindowDays)).
+		Group("oi.product_id"))
+	if err != nil {
+		return nil, err
+	}
+	onOrder, err := sumByProduct(db.Table("purchase_order_lines AS l").
+		Select("l.product_id, SUM(l.quantity) AS quantity").
+		Joins("JOIN purchase_orders po ON po.id = l.purchase_order_id").
+		Where("po.status IN ?", []models.PurchaseOrderStatus{models.PurchaseOrderDraft, models.PurchaseOrderSent}).
+		Group("l.product_id"))
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ReplenishmentReport{GeneratedAt: now, Settings: settings, Suggestions: []ReplenishmentSuggestion{}}
+	for _, product := range products {
+		leadTime := settings.DefaultLeadTimeDays
+		if product.LeadTimeDays != nil && *product.LeadTimeDays > 0 {
+			leadTime = *product.LeadTimeDays
+		}
+		plan := planReplenishment(replenishmentInput{
+			UnitsSold:     sold[product.ID],
+			WindowDays:    settings.SalesWindowDays,
+			LeadTimeDays:  leadTime,
+			SafetyDays:    settings.SafetyStockDays,
+			CoverDays:     settings.CoverDays,
+			MinStockLevel: product.MinStockLevel,
+			OnHand:        product.StockQuantity,
+			OnOrder:       onOrder[product.ID],
+		})
+		if plan.SuggestedQuantity == 0 && !filter.IncludeAll {
+			continue
+		}
+
+		suggestion := ReplenishmentSuggestion{
+			ProductID:         product.ID,
+			SKU:               product.SKU,
+			Name:              product.Name,
+			SupplierID:        product.SupplierID,
+			LeadTimeDays:      leadTime,
+			UnitsSold:         sold[product.ID],
+			DailyVelocity:     math.Round(plan.DailyVelocity*1000) / 1000,
+			StockQuantity:     product.StockQuantity,
+			OnOrder:           onOrder[product.ID],
+			MinStockLevel:     product.MinStockLevel,
+			SafetyStock:       plan.SafetyStock,
+			ReorderPoint:      plan.ReorderPoint,
+			TargetStock:       plan.TargetStock,
+			SuggestedQuantity: plan.SuggestedQuantity,
+		}
+		if product.SupplierName != nil {
+			suggestion.SupplierName = *product.SupplierName
+		}
+		if plan.DailyVelocity > 0 {
+			days := RoundMoney(float64(product.StockQuantity) / plan.DailyVelocity)
+			suggestion.DaysOfCover = &days
+		}
+		if plan.SuggestedQuantity > 0 {
+			cost, err := lastPurchaseCost(db, product.ID)
+			if err != nil {
+				return nil, err
+			}
+			suggestion.UnitCost = RoundMoney(cost)
+			suggestion.EstimatedCost = RoundMoney(suggestion.UnitCost * float64(plan.SuggestedQuantity))
+		}
+		report.Suggestions = append(report.Suggestions, suggestion)
+		report.TotalQuantity += suggestion.SuggestedQuantity
+		report.TotalCost += suggestion.EstimatedCost
+	}
+	report.TotalCost = RoundMoney(report.TotalCost)
+
+	// ترتيب المورد ثم الأقل أيام تغطية حتى يظهر الأكثر إلحاحاً أولاً
+	sort.SliceStable(report.Suggestions, func(i, j int) bool {
+		a, b := report.Suggestions[i], report.Suggestions[j]
+		if a.SupplierName != b.SupplierName {
+			return a.SupplierName < b.SupplierName
+		}
+		if (a.DaysOfCover == nil) != (b.DaysOfCover == nil) {
+			return a.DaysOfCover != nil
+		}
+		if a.DaysOfCover != nil && *a.DaysOfCover != *b.DaysOfCover {
+			return *a.DaysOfCover < *b.DaysOfCover
+		}
+		return a.Name < b.Name
+	})
+	return report, nil
+}
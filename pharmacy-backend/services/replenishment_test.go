@@ -0,0 +1,96 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"pharmacy-backend/models"
+)
+
+func TestPlanReplenishment(t *testing.T) {
+	// 90 وحدة في 30 يوماً = 3 يومياً؛ أمان 7 أيام = 21، توريد 5 أيام = 15
+	plan := planReplenishment(replenishmentInput{
+		UnitsSold: 90, WindowDays: 30, LeadTimeDays: 5, SafetyDays: 7, CoverDays: 30,
+		MinStockLevel: 5, OnHand: 30, OnOrder: 0,
+	})
+	assert.Equal(t, 3.0, plan.DailyVelocity)
+	assert.Equal(t, 21, plan.SafetyStock)
+	assert.Equal(t, 36, plan.ReorderPoint)
+	assert.Equal(t, 126, plan.TargetStock)
+	assert.Equal(t, 96, plan.SuggestedQuantity)
+
+	// ما هو قيد الطلب يرفع المخزون فوق نقطة إعادة الطلب
+	plan = planReplenishment(replenishmentInput{
+		UnitsSold: 90, WindowDays: 30, LeadTimeDays: 5, SafetyDays: 7, CoverDays: 30,
+		MinStockLevel: 5, OnHand: 30, OnOrder: 10,
+	})
+	assert.Equal(t, 0, plan.SuggestedQuantity)
+}
+
+func TestPlanReplenishmentWithoutSales(t *testing.T) {
+	// منتج راكد: الحد الأدنى هو مخزون الأمان
+	plan := planReplenishment(replenishmentInput{WindowDays: 90, LeadTimeDays: 7, SafetyDays: 7, CoverDays: 30, MinStockLevel: 5, OnHand: 2})
+	assert.Equal(t, 5, plan.SafetyStock)
+	assert.Equal(t, 3, plan.SuggestedQuantity)
+
+	plan = planReplenishment(replenishmentInput{WindowDays: 90, LeadTimeDays: 7, SafetyDays: 7, CoverDays: 30, MinStockLevel: 5, OnHand: 5})
+	assert.Equal(t, 0, plan.SuggestedQuantity)
+	assert.Equal(t, 1, ceilUnits(0.1))
+	assert.Equal(t, 3, ceilUnits(3.0000000001))
+}
+
+func TestMergePurchaseOrderLines(t *testing.T) {
+	a, b := uuid.New(), uuid.New()
+	merged, err := mergePurchaseOrderLines([]PurchaseOrderLineInput{{a, 2}, {b, 1}, {a, 3}})
+	assert.NoError(t, err)
+	assert.Equal(t, []PurchaseOrderLineInput{{a, 5}, {b, 1}}, merged)
+
+	_, err = mergePurchaseOrderLines([]PurchaseOrderLineInput{{a, 0}})
+	assert.True(t, errors.Is(err, ErrInvalidPurchaseOrder))
+	_, err = mergePurchaseOrderLines(nil)
+	assert.True(t, errors.Is(err, ErrInvalidPurchaseOrder))
+}
+
+func TestGroupLinesBySupplier(t *testing.T) {
+	supplierA, supplierB := uuid.New(), uuid.New()
+	p1, p2, p3 := uuid.New(), uuid.New(), uuid.New()
+	products := map[uuid.UUID]models.Product{
+		p1: {ID: p1, SKU: "P1", SupplierID: &supplierA},
+		p2: {ID: p2, SKU: "P2", SupplierID: &supplierB},
+		p3: {ID: p3, SKU: "P3", SupplierID: &supplierA},
+	}
+
+	groups, err := groupLinesBySupplier([]PurchaseOrderLineInput{{p1, 1}, {p2, 2}, {p3, 3}}, products)
+	assert.NoError(t, err)
+	assert.Equal(t, []PurchaseOrderLineInput{{p1, 1}, {p3, 3}}, groups[supplierA])
+	assert.Equal(t, []PurchaseOrderLineInput{{p2, 2}}, groups[supplierB])
+
+	orphan := uuid.New()
+	products[orphan] = models.Product{ID: orphan, SKU: "ORPHAN"}
+	_, err = groupLinesBySupplier([]PurchaseOrderLineInput{{orphan, 1}}, products)
+	assert.True(t, errors.Is(err, ErrProductWithoutSupplier))
+	assert.Contains(t, err.Error(), "ORPHAN")
+}
+
+func TestRenderPurchaseOrderCSV(t *testing.T) {
+	order := &models.PurchaseOrder{
+		OrderNumber: "PO-2026-000001",
+		Subtotal:    35,
+		Lines: []models.PurchaseOrderLine{
+			{SKU: "A1", Name: "Paracetamol", Quantity: 10, UnitCost: 2, LineTotal: 20},
+			{SKU: "B2", Name: "Vitamin C", Quantity: 5, UnitCost: 3, LineTotal: 15},
+		},
+	}
+	var buf bytes.Buffer
+	assert.NoError(t, RenderPurchaseOrderCSV(order, &buf))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if assert.Len(t, lines, 4) {
+		assert.Equal(t, "PO-2026-000001,1,A1,Paracetamol,10,2.00,20.00", lines[1])
+		assert.Equal(t, "PO-2026-000001,,,total,15,,35.00", lines[3])
+	}
+}
@@ -0,0 +1,426 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"pharmacy-backend/models"
+)
+
+// أخطاء الإرجاع التي تُعاد للعميل كطلب غير صالح
+var (
+	ErrReturnNotAllowed    = errors.New("order is not eligible for return")
+	ErrReturnWindowClosed  = errors.New("return window has closed for this order")
+	ErrReturnEmpty         = errors.New("return request has no items")
+	ErrReturnItemNotFound  = errors.New("order item not found in this order")
+	ErrReturnQuantity      = errors.New("return quantity exceeds the quantity still returnable")
+	ErrReturnInvalidState  = errors.New("return request is not in a valid state for this action")
+	ErrRefundAmountInvalid = errors.New("refund amount is invalid")
+)
+
+// IsReturnError التحقق مما إذا كان الخطأ ناتجاً عن طلب إرجاع غير صالح
+func IsReturnError(err error) bool {
+	return errors.Is(err, ErrReturnNotAllowed) ||
+		errors.Is(err, ErrReturnWindowClosed) ||
+		errors.Is(err, ErrReturnEmpty) ||
+		errors.Is(err, ErrReturnItemNotFound) ||
+		errors.Is(err, ErrReturnQuantity) ||
+		errors.Is(err, ErrReturnInvalidState) ||
+		errors.Is(err, ErrRefundAmountInvalid)
+}
+
+// ReturnLineInput عنصر مرتجع كما أرسله العميل
+type ReturnLineInput struct {
+	OrderItemID uuid.UUID `json:"order_item_id"`
+	Quantity    int       `json:"quantity"`
+	Reason      string    `json:"reason"`
+}
+
+// ReturnWindowDays عدد الأيام المسموح فيها بالإرجاع بعد التسليم
+func ReturnWindowDays() int {
+	return int(envFloat("RETURN_WINDOW_DAYS", 14))
+}
+
+// ProratedLineValue قيمة كمية من عنصر بعد توزيع خصم الكوبون على عناصر الطلب
+// القيمة بأسعار الطلب نفسها، فلا تشمل الضريبة إذا كانت الأسعار غير شاملة لها.
+func ProratedLineValue(order *models.Order, unitPrice float64, quantity int) float64 {
+	gross := unitPrice * float64(quantity)
+	if order.Subtotal <= 0 || order.DiscountAmount <= 0 {
+		return RoundMoney(gross)
+	}
+	ratio := order.DiscountAmount / order.Subtotal
+	if ratio > 1 {
+		ratio = 1
+	}
+	return RoundMoney(gross * (1 - ratio))
+}
+
+// ProratedRefund قيمة الاسترداد لكمية من عنصر: قيمتها بعد الخصم مع حصتها من الضريبة
+// إذا أُضيفت الضريبة فوق الأسعار.
+func ProratedRefund(order *models.Order, unitPrice float64, quantity int) float64 {
+	return RoundMoney(ProratedLineValue(order, unitPrice, quantity) * (1 + exclusiveTaxRatio(order)))
+}
+
+// exclusiveTaxRatio نسبة الضريبة التي أُضيفت فوق أسعار الطلب، أو صفر إذا كانت شاملة لها
+// الطلب لا يحفظ إعداد شمول الضريبة، فيُعرف من إجماليه: في الأسعار غير الشاملة
+// يساوي الإجمالي وعاء الضريبة (العناصر بعد الخصم والشحن) مضافاً إليه الضريبة.
+func exclusiveTaxRatio(order *models.Order) float64 {
+	base := order.Subtotal - order.DiscountAmount + order.ShippingCost
+	if order.TaxAmount <= 0 || base <= 0 || !moneyEqual(order.TotalAmount, base+order.TaxAmount) {
+		return 0
+	}
+	return order.TaxAmount / base
+}
+
+// returnedQuantities الكميات المرتجعة لكل عنصر طلب في طلبات الإرجاع غير المرفوضة
+func returnedQuantities(tx *gorm.DB, orderID uuid.UUID) (map[uuid.UUID]int, error) {
+	var rows []struct {
+		OrderItemID uuid.UUID
+		Quantity    int
+	}
+	err := tx.Table("return_items").
+		Select("return_items.order_item_id, SUM(return_items.quantity) AS quantity").
+		Joins("JOIN return_requests ON return_requests.id = return_items.return_request_id").
+		Where("return_requests.order_id = ? AND return_requests.status <> ?", orderID, models.ReturnStatusRejected).
+		Group("return_items.order_item_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	returned := make(map[uuid.UUID]int, len(rows))
+	for _, row := range rows {
+		returned[row.OrderItemID] = row.Quantity
+	}
+	return returned, nil
+}
+
+// CreateReturnRequest إنشاء طلب إرجاع لعناصر من طلب مُسلَّم
+// يُقفل صف الطلب حتى لا تتجاوز طلبات الإرجاع المتزامنة الكمية المشتراة.
+func CreateReturnRequest(tx *gorm.DB, order *models.Order, reason, notes string, photos []string, lines []ReturnLineInput) (*models.ReturnRequest, error) {
+	if len(lines) == 0 {
+		return nil, ErrReturnEmpty
+	}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(order, "id = ?", order.ID).Error; err != nil {
+		return nil, err
+	}
+	if order.Status != models.OrderStatusDelivered {
+		return nil, fmt.Errorf("order %s is %s: %w", order.OrderNumber, order.Status, ErrReturnNotAllowed)
+	}
+
+	deliveredAt := order.UpdatedAt
+	if order.ActualDelivery != nil {
+		deliveredAt = *order.ActualDelivery
+	}
+	if days := ReturnWindowDays(); time.Since(deliveredAt) > time.Duration(days)*24*time.Hour {
+		return nil, fmt.Errorf("%d days after delivery: %w", days, ErrReturnWindowClosed)
+	}
+
+	var orderItems []models.OrderItem
+	if err := tx.Where("order_id = ?", order.ID).Find(&orderItems).Error; err != nil {
+		return nil, err
+	}
+	itemsByID := make(map[uuid.UUID]models.OrderItem, len(orderItems))
+	for _, item := range orderItems {
+		itemsByID[item.ID] = item
+	}
+
+	returned, err := returnedQuantities(tx, order.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	request := &models.ReturnRequest{
+		OrderID: order.ID,
+		UserID:  order.UserID,
+		Status:  models.ReturnStatusRequested,
+		Reason:  strings.TrimSpace(reason),
+		Notes:   strings.TrimSpace(notes),
+		Photos:  photos,
+	}
+	for idx, line := range lines {
+		item, ok := itemsByID[line.OrderItemID]
+		if !ok {
+			return nil, fmt.Errorf("item %d (%s): %w", idx, line.OrderItemID, ErrReturnItemNotFound)
+		}
+		if line.Quantity < 1 || returned[item.ID]+line.Quantity > item.Quantity {
+			return nil, fmt.Errorf("item %d (%s): requested %d, returnable %d: %w", idx, item.Name, line.Quantity, item.Quantity-returned[item.ID], ErrReturnQuantity)
+		}
+		returned[item.ID] += line.Quantity
+
+		lineReason := strings.TrimSpace(line.Reason)
+		if lineReason == "" {
+			lineReason = request.Reason
+		}
+		request.Items = append(request.Items, models.ReturnItem{
+			OrderItemID:      item.ID,
+			ProductID:        item.ProductID,
+			Name:             item.Name,
+			Quantity:         line.Quantity,
+			UnitPrice:        item.UnitPrice,
+			RefundableAmount: ProratedRefund(order, item.UnitPrice, line.Quantity),
+			Reason:           lineReason,
+		})
+	}
+
+	if err := tx.Create(request).Error; err != nil {
+		return nil, err
+	}
+
+	if err := addReturnTracking(tx, order.ID, models.TrackingReturnRequested, &order.UserID,
+		fmt.Sprintf("تم تقديم طلب الإرجاع %s", request.ReturnNumber)); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// transitionReturn تغيير حالة طلب الإرجاع بشكل مشروط بالحالة الحالية
+func transitionReturn(tx *gorm.DB, ret *models.ReturnRequest, from, to models.ReturnStatus, updates map[string]interface{}) error {
+	if ret.Status != from {
+		return fmt.Errorf("%s is %s: %w", ret.ReturnNumber, ret.Status, ErrReturnInvalidState)
+	}
+	updates["status"] = to
+	updates["updated_at"] = time.Now()
+	result := tx.Model(&models.ReturnRequest{}).Where("id = ? AND status = ?", ret.ID, from).Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%s: %w", ret.ReturnNumber, ErrReturnInvalidState)
+	}
+	ret.Status = to
+	return nil
+}
+
+// ApproveReturn اعتماد طلب الإرجاع وإعادة الأصناف القابلة للإرجاع إلى المخزون
+// الأصناف غير القابلة للإرجاع تُسجل كإتلاف دون زيادة المخزون.
+func ApproveReturn(tx *gorm.DB, ret *models.ReturnRequest, adminID *uuid.UUID, adminNotes string) error {
+	now := time.Now()
+	if err := transitionReturn(tx, ret, models.ReturnStatusRequested, models.ReturnStatusApproved, map[string]interface{}{
+		"reviewed_by": adminID,
+		"reviewed_at": now,
+		"admin_notes": adminNotes,
+	}); err != nil {
+		return err
+	}
+	ret.ReviewedBy = adminID
+	ret.ReviewedAt = &now
+	ret.AdminNotes = adminNotes
+
+	for i := range ret.Items {
+		item := &ret.Items[i]
+
+		var product models.Product
+		if err := tx.Select("id", "name", "non_returnable").First(&product, "id = ?", item.ProductID).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		// المنتج المحذوف لا يمكن إعادته للمخزون فيُعامل كإتلاف
+		movement := StockMovement{
+			ProductID:       item.ProductID,
+			Quantity:        item.Quantity,
+			UnitPrice:       item.UnitPrice,
+			ReferenceNumber: ret.ReturnNumber,
+			ActorID:         adminID,
+		}
+		movements := []StockMovement{movement}
+		if product.ID != uuid.Nil && product.IsReturnable() {
+			item.Disposition = models.ReturnDispositionRestock
+			restored, err := RestockReturnItem(tx, item)
+			if err != nil {
+				return fmt.Errorf("restock product %s: %w", item.ProductID, err)
+			}
+			if err := recordCustomerReturnCost(tx, item.OrderItemID, item.ProductID, restored, item.Quantity, ret.ReturnNumber); err != nil {
+				return err
+			}
+			movements = returnMovements(movement, ret.ReturnNumber, restored)
+		} else {
+			item.Disposition = models.ReturnDispositionWriteOff
+			movements[0].Type = models.TransactionTypeWriteOff
+			movements[0].Notes = fmt.Sprintf("إتلاف مرتجع %s (صنف غير قابل للإرجاع)", ret.ReturnNumber)
+		}
+
+		if err := tx.Model(&models.ReturnItem{}).Where("id = ?", item.ID).Update("disposition", item.Disposition).Error; err != nil {
+			return err
+		}
+		if product.ID == uuid.Nil {
+			continue
+		}
+		for _, m := range movements {
+			if _, err := RecordStockMovement(tx, m); err != nil {
+				return err
+			}
+		}
+	}
+
+	return addReturnTracking(tx, ret.OrderID, models.TrackingReturnApproved, adminID,
+		fmt.Sprintf("تم اعتماد طلب الإرجاع %s", ret.ReturnNumber))
+}
+
+// returnMovements حركة إرجاع لكل دفعة أُعيد إليها الصنف، وحركة لما عاد إلى عداد منتج غير متتبع
+func returnMovements(base StockMovement, returnNumber string, restored []BatchAllocation) []StockMovement {
+	var movements []StockMovement
+	remaining := base.Quantity
+	for _, allocation := range restored {
+		m := base
+		m.Type = models.TransactionTypeReturn
+		m.Quantity = allocation.Quantity
+		m.Notes = fmt.Sprintf("إعادة مرتجع %s إلى المخزون (الدفعة %s)", returnNumber, allocation.BatchNumber)
+		movements = append(movements, m)
+		remaining -= allocation.Quantity
+	}
+	if remaining > 0 {
+		m := base
+		m.Type = models.TransactionTypeReturn
+		m.Quantity = remaining
+		m.Notes = fmt.Sprintf("إعادة مرتجع %s إلى المخزون", returnNumber)
+		movements = append(movements, m)
+	}
+	return movements
+}
+
+// RejectReturn رفض طلب الإرجاع؛ تعود الكميات قابلة للإرجاع مرة أخرى
+func RejectReturn(tx *gorm.DB, ret *models.ReturnRequest, adminID *uuid.UUID, adminNotes string) error {
+	now := time.Now()
+	if err := transitionReturn(tx, ret, models.ReturnStatusRequested, models.ReturnStatusRejected, map[string]interface{}{
+		"reviewed_by": adminID,
+		"reviewed_at": now,
+		"admin_notes": adminNotes,
+	}); err != nil {
+		return err
+	}
+	ret.ReviewedBy = adminID
+	ret.ReviewedAt = &now
+	ret.AdminNotes = adminNotes
+
+	description := fmt.Sprintf("تم رفض طلب الإرجاع %s", ret.ReturnNumber)
+	if adminNotes != "" {
+		description += ": " + adminNotes
+	}
+	return addReturnTracking(tx, ret.OrderID, models.TrackingReturnRejected, adminID, description)
+}
+
+// MaxRefund أقصى مبلغ يمكن استرداده لطلب الإرجاع
+// قيمة العناصر بعد توزيع الكوبون، ويضاف الشحن إذا اكتمل إرجاع كل عناصر الطلب،
+// ولا يتجاوز ما تبقى من إجمالي الطلب بعد الاستردادات السابقة.
+func MaxRefund(tx *gorm.DB, ret *models.ReturnRequest, order *models.Order) (float64, error) {
+	var amount float64
+	for _, item := range ret.Items {
+		amount += item.RefundableAmount
+	}
+
+	var orderItems []models.OrderItem
+	if err := tx.Where("order_id = ?", order.ID).Find(&orderItems).Error; err != nil {
+		return 0, err
+	}
+	returned, err := returnedQuantities(tx, order.ID)
+	if err != nil {
+		return 0, err
+	}
+	return capRefund(amount, order, orderFullyReturned(orderItems, returned)), nil
+}
+
+// orderFullyReturned التحقق من أن كل كمية عناصر الطلب مشمولة بطلبات إرجاع
+func orderFullyReturned(items []models.OrderItem, returned map[uuid.UUID]int) bool {
+	for _, item := range items {
+		if returned[item.ID] < item.Quantity {
+			return false
+		}
+	}
+	return len(items) > 0
+}
+
+// capRefund إضافة الشحن (بضريبته إن أُضيفت فوقه) عند اكتمال الإرجاع ثم حصر المبلغ فيما تبقى من إجمالي الطلب
+func capRefund(amount float64, order *models.Order, fullyReturned bool) float64 {
+	if fullyReturned {
+		amount += order.ShippingCost * (1 + exclusiveTaxRatio(order))
+	}
+	if remaining := order.TotalAmount - order.RefundedAmount; amount > remaining {
+		amount = remaining
+	}
+	if amount < 0 {
+		amount = 0
+	}
+	return RoundMoney(amount)
+}
+
+// RefundReturn تسجيل استرداد كامل أو جزئي لطلب إرجاع معتمد
+// amount = nil يعني استرداد الحد الأقصى المسموح.
+func RefundReturn(tx *gorm.DB, ret *models.ReturnRequest, adminID *uuid.UUID, amount *float64) (float64, error) {
+	if ret.Status != models.ReturnStatusApproved {
+		return 0, fmt.Errorf("%s is %s: %w", ret.ReturnNumber, ret.Status, ErrReturnInvalidState)
+	}
+
+	var order models.Order
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, "id = ?", ret.OrderID).Error; err != nil {
+		return 0, err
+	}
+
+	maxRefund, err := MaxRefund(tx, ret, &order)
+	if err != nil {
+		return 0, err
+	}
+	refund := maxRefund
+	if amount != nil {
+		refund = RoundMoney(*amount)
+		if refund <= 0 || refund > maxRefund+priceTolerance {
+			return 0, fmt.Errorf("%.2f (maximum %.2f): %w", refund, maxRefund, ErrRefundAmountInvalid)
+		}
+	}
+
+	now := time.Now()
+	if err := transitionReturn(tx, ret, models.ReturnStatusApproved, models.ReturnStatusRefunded, map[string]interface{}{
+		"refund_amount": refund,
+		"refunded_by":   adminID,
+		"refunded_at":   now,
+	}); err != nil {
+		return 0, err
+	}
+	ret.RefundAmount = refund
+	ret.RefundedBy = adminID
+	ret.RefundedAt = &now
+
+	refunded := RoundMoney(order.RefundedAmount + refund)
+	paymentStatus := models.PaymentStatusPartiallyRefunded
+	if refunded >= order.TotalAmount-priceTolerance {
+		paymentStatus = models.PaymentStatusRefunded
+	}
+	if err := tx.Model(&models.Order{}).Where("id = ?", order.ID).Updates(map[string]interface{}{
+		"refunded_amount": refunded,
+		"payment_status":  paymentStatus,
+		"updated_at":      now,
+	}).Error; err != nil {
+		return 0, err
+	}
+
+	if err := addReturnTracking(tx, ret.OrderID, models.TrackingRefunded, adminID,
+		fmt.Sprintf("تم استرداد %.2f ر.س لطلب الإرجاع %s", refund, ret.ReturnNumber)); err != nil {
+		return 0, err
+	}
+
+	// كل استرداد يقابله إشعار دائن على فاتورة الطلب
+	if _, err := IssueCreditNote(tx, ret, refund); err != nil {
+		if !errors.Is(err, ErrSellerVATMissing) {
+			return 0, err
+		}
+		log.Printf("⚠️ لم يصدر إشعار دائن لطلب الإرجاع %s: %v", ret.ReturnNumber, err)
+	}
+	return refund, nil
+}
+
+func addReturnTracking(tx *gorm.DB, orderID uuid.UUID, event string, actorID *uuid.UUID, description string) error {
+	tracking := models.OrderTracking{
+		OrderID:     orderID,
+		Status:      event,
+		ActorID:     actorID,
+		Description: description,
+		Timestamp:   time.Now(),
+	}
+	return tx.Create(&tracking).Error
+}
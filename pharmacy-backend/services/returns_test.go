@@ -0,0 +1,209 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"pharmacy-backend/models"
+)
+
+func TestProratedRefund(t *testing.T) {
+	tests := []struct {
+		name      string
+		order     models.Order
+		unitPrice float64
+		quantity  int
+		want      float64
+	}{
+		{"full line without coupon", models.Order{Subtotal: 100}, 50, 2, 100},
+		{"partial line without coupon", models.Order{Subtotal: 100}, 12.5, 3, 37.5},
+		{"coupon spread over the order", models.Order{Subtotal: 150, DiscountAmount: 15}, 50, 1, 45},
+		{"rounded to halalas", models.Order{Subtotal: 90, DiscountAmount: 10}, 33.33, 1, 29.63},
+		{"discount larger than subtotal", models.Order{Subtotal: 50, DiscountAmount: 80}, 25, 2, 0},
+		{"order without subtotal", models.Order{DiscountAmount: 5}, 10, 1, 10},
+		{"tax-inclusive prices", models.Order{Subtotal: 100, ShippingCost: 15, TaxAmount: 15, TotalAmount: 115}, 50, 1, 50},
+		{"tax-exclusive prices add the line VAT", models.Order{Subtotal: 100, ShippingCost: 15, TaxAmount: 17.25, TotalAmount: 132.25}, 50, 1, 57.5},
+		{"tax-exclusive with coupon", models.Order{Subtotal: 100, DiscountAmount: 10, ShippingCost: 15, TaxAmount: 15.75, TotalAmount: 120.75}, 50, 1, 51.75},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, ProratedRefund(&tt.order, tt.unitPrice, tt.quantity))
+		})
+	}
+}
+
+func TestOrderFullyReturned(t *testing.T) {
+	a, b := models.OrderItem{ID: uuid.New(), Quantity: 2}, models.OrderItem{ID: uuid.New(), Quantity: 1}
+	items := []models.OrderItem{a, b}
+
+	assert.False(t, orderFullyReturned(items, map[uuid.UUID]int{a.ID: 2}))
+	assert.False(t, orderFullyReturned(items, map[uuid.UUID]int{a.ID: 1, b.ID: 1}))
+	assert.True(t, orderFullyReturned(items, map[uuid.UUID]int{a.ID: 2, b.ID: 1}))
+	assert.False(t, orderFullyReturned(nil, nil))
+}
+
+func TestCapRefund(t *testing.T) {
+	// مجموع 150 بخصم 15 وشحن 15 بأسعار شاملة الضريبة: الإجمالي 150
+	inclusive := models.Order{Subtotal: 150, DiscountAmount: 15, ShippingCost: 15, TaxAmount: 19.57, TotalAmount: 150}
+	// مجموع 100 وشحن 15 والضريبة فوقهما: الإجمالي 132.25
+	exclusive := models.Order{Subtotal: 100, ShippingCost: 15, TaxAmount: 17.25, TotalAmount: 132.25}
+
+	tests := []struct {
+		name          string
+		order         models.Order
+		refunded      float64
+		amount        float64
+		fullyReturned bool
+		want          float64
+	}{
+		{"partial return without shipping", inclusive, 0, 45, false, 45},
+		{"completing the return adds shipping", inclusive, 45, 90, true, 105},
+		{"capped at what is left of the total", inclusive, 120, 90, true, 30},
+		{"nothing left to refund", inclusive, 160, 90, true, 0},
+		{"tax-exclusive shipping refunded with its VAT", exclusive, 0, 115, true, 132.25},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order := tt.order
+			order.RefundedAmount = tt.refunded
+			assert.Equal(t, tt.want, capRefund(tt.amount, &order, tt.fullyReturned))
+		})
+	}
+}
+
+func createTestReturn(t *testing.T, db *gorm.DB, order *models.Order, status models.ReturnStatus, lines map[*models.OrderItem]int) *models.ReturnRequest {
+	ret := &models.ReturnRequest{OrderID: order.ID, UserID: order.UserID, Status: status, Reason: "damaged"}
+	for item, quantity := range lines {
+		ret.Items = append(ret.Items, models.ReturnItem{
+			OrderItemID:      item.ID,
+			ProductID:        item.ProductID,
+			Name:             item.Name,
+			Quantity:         quantity,
+			UnitPrice:        item.UnitPrice,
+			RefundableAmount: ProratedRefund(order, item.UnitPrice, quantity),
+		})
+	}
+	require.NoError(t, db.Create(ret).Error)
+	t.Cleanup(func() {
+		db.Delete(&models.ReturnItem{}, "return_request_id = ?", ret.ID)
+		db.Delete(&models.ReturnRequest{}, "id = ?", ret.ID)
+	})
+	return ret
+}
+
+func TestMaxRefund(t *testing.T) {
+	db := setupStockTestDB(t)
+	user := createEditTestUser(t, db)
+	productA := createStockTestProduct(t, db, 0)
+	productB := createStockTestProduct(t, db, 0)
+
+	// مجموع 150 بخصم 10% وشحن 15: الإجمالي 150
+	order, items := createEditTestOrder(t, db, user.ID,
+		models.OrderItem{ProductID: productA.ID, Name: productA.Name, Quantity: 2, UnitPrice: 50},
+		models.OrderItem{ProductID: productB.ID, Name: productB.Name, Quantity: 1, UnitPrice: 50},
+	)
+	order.Status = models.OrderStatusDelivered
+	order.DiscountAmount = 15
+	order.ShippingCost = 15
+	order.TotalAmount = 150
+	require.NoError(t, db.Save(&order).Error)
+	a, b := &items[0], &items[1]
+
+	// إرجاع جزئي: قيمة العنصر بعد الخصم دون الشحن
+	first := createTestReturn(t, db, &order, models.ReturnStatusRequested, map[*models.OrderItem]int{a: 1})
+	amount, err := MaxRefund(db, first, &order)
+	require.NoError(t, err)
+	assert.Equal(t, 45.0, amount)
+
+	// الطلب المرفوض لا يُحتسب في اكتمال الإرجاع
+	createTestReturn(t, db, &order, models.ReturnStatusRejected, map[*models.OrderItem]int{b: 1})
+	amount, err = MaxRefund(db, first, &order)
+	require.NoError(t, err)
+	assert.Equal(t, 45.0, amount)
+
+	// إرجاع ما تبقى يكمل الطلب فيُضاف الشحن
+	order.RefundedAmount = 45
+	second := createTestReturn(t, db, &order, models.ReturnStatusRequested, map[*models.OrderItem]int{a: 1, b: 1})
+	amount, err = MaxRefund(db, second, &order)
+	require.NoError(t, err)
+	assert.Equal(t, 105.0, amount)
+
+	// لا يتجاوز ما تبقى من إجمالي الطلب
+	order.RefundedAmount = 120
+	amount, err = MaxRefund(db, second, &order)
+	require.NoError(t, err)
+	assert.Equal(t, 30.0, amount)
+
+	order.RefundedAmount = 160
+	amount, err = MaxRefund(db, second, &order)
+	require.NoError(t, err)
+	assert.Equal(t, 0.0, amount)
+}
+
+func TestReturnMovements(t *testing.T) {
+	base := StockMovement{ProductID: uuid.New(), Quantity: 5, UnitPrice: 10, ReferenceNumber: "RMA-1"}
+	movements := returnMovements(base, "RMA-1", []BatchAllocation{
+		{BatchNumber: "B-1", Quantity: 2},
+		{BatchNumber: "B-2", Quantity: 1},
+	})
+	require.Len(t, movements, 3)
+	for _, m := range movements {
+		assert.Equal(t, models.TransactionTypeReturn, m.Type)
+		assert.Equal(t, "RMA-1", m.ReferenceNumber)
+	}
+	assert.Equal(t, []int{2, 1, 2}, []int{movements[0].Quantity, movements[1].Quantity, movements[2].Quantity})
+	assert.Contains(t, movements[0].Notes, "B-1")
+	assert.Contains(t, movements[1].Notes, "B-2")
+}
+
+func TestApproveReturnKeepsShippedBatches(t *testing.T) {
+	db := setupStockTestDB(t)
+	user := createEditTestUser(t, db)
+	product := createStockTestProduct(t, db, 0)
+	expiry := time.Now().AddDate(1, 0, 0)
+	batch := createExpiryTestBatch(t, db, product.ID, nil, 0, expiry)
+	t.Cleanup(func() {
+		db.Delete(&models.InventoryTransaction{}, "product_id = ?", product.ID)
+		db.Delete(&models.InventoryCostEntry{}, "product_id = ?", product.ID)
+	})
+
+	order, items := createEditTestOrder(t, db, user.ID, models.OrderItem{ProductID: product.ID, Name: product.Name, Quantity: 3, UnitPrice: 10})
+	item := &items[0]
+	require.NoError(t, RecordOrderItemBatches(db, item.ID, []BatchAllocation{{BatchID: batch.ID, BatchNumber: batch.BatchNumber, ExpiryDate: batch.ExpiryDate, Quantity: 3}}))
+	t.Cleanup(func() {
+		db.Delete(&models.ReturnItemBatch{}, "order_item_id = ?", item.ID)
+	})
+
+	approve := func(quantity int) {
+		ret := createTestReturn(t, db, &order, models.ReturnStatusRequested, map[*models.OrderItem]int{item: quantity})
+		require.NoError(t, db.Transaction(func(tx *gorm.DB) error {
+			return ApproveReturn(tx, ret, nil, "")
+		}))
+	}
+	approve(2)
+	approve(1)
+
+	// العميل استلم ثلاث وحدات من الدفعة وما زال السجل يدل على ذلك
+	var shipped []models.OrderItemBatch
+	require.NoError(t, db.Find(&shipped, "order_item_id = ?", item.ID).Error)
+	require.Len(t, shipped, 1)
+	assert.Equal(t, 3, shipped[0].Quantity)
+
+	var reloaded models.ProductBatch
+	require.NoError(t, db.First(&reloaded, "id = ?", batch.ID).Error)
+	assert.Equal(t, 3, reloaded.Quantity)
+
+	var returned int
+	require.NoError(t, db.Model(&models.ReturnItemBatch{}).Where("order_item_id = ? AND batch_id = ?", item.ID, batch.ID).
+		Select("COALESCE(SUM(quantity), 0)").Scan(&returned).Error)
+	assert.Equal(t, 3, returned)
+
+	var movements []models.InventoryTransaction
+	require.NoError(t, db.Find(&movements, "product_id = ? AND transaction_type = ?", product.ID, models.TransactionTypeReturn).Error)
+	assert.Len(t, movements, 2)
+}
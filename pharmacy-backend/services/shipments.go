@@ -0,0 +1,329 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"pharmacy-backend/models"
+)
+
+// أخطاء الشحنات التي تُعاد كطلب غير صالح
+var (
+	ErrShipmentNotAllowed   = errors.New("order cannot be shipped in its current status")
+	ErrShipmentEmpty        = errors.New("shipment has no items")
+	ErrShipmentItemNotFound = errors.New("order item not found in this order")
+	ErrShipmentQuantity     = errors.New("shipment quantity exceeds the quantity left to ship")
+	ErrShipmentCarrier      = errors.New("carrier and tracking number are required")
+	ErrShipmentStatus       = errors.New("shipment status can only move forward")
+)
+
+// IsShipmentError التحقق مما إذا كان الخطأ ناتجاً عن بيانات شحنة غير صالحة
+func IsShipmentError(err error) bool {
+	return errors.Is(err, ErrShipmentNotAllowed) ||
+		errors.Is(err, ErrShipmentEmpty) ||
+		errors.Is(err, ErrShipmentItemNotFound) ||
+		errors.Is(err, ErrShipmentQuantity) ||
+		errors.Is(err, ErrShipmentCarrier) ||
+		errors.Is(err, ErrShipmentStatus)
+}
+
+// ShipmentLineInput كمية عنصر طلب داخل الشحنة
+type ShipmentLineInput struct {
+	OrderItemID uuid.UUID `json:"order_item_id" binding:"required"`
+	Quantity    int       `json:"quantity" binding:"required,gt=0"`
+}
+
+// ShipmentInput بيانات إنشاء شحنة جديدة
+type ShipmentInput struct {
+	Carrier        string
+	TrackingNumber string
+	WeightKg       *float64
+	Lines          []ShipmentLineInput
+}
+
+// shippableStatuses حالات الطلب التي يمكن إنشاء شحنات لها
+var shippableStatuses = map[models.OrderStatus]bool{
+	models.OrderStatusConfirmed:        true,
+	models.OrderStatusProcessing:       true,
+	models.OrderStatusPartiallyShipped: true,
+}
+
+// orderFulfilmentPath تسلسل حالات الطلب أثناء التجهيز والشحن
+var orderFulfilmentPath = []models.OrderStatus{
+	models.OrderStatusConfirmed,
+	models.OrderStatusProcessing,
+	models.OrderStatusPartiallyShipped,
+	models.OrderStatusShipped,
+	models.OrderStatusDelivered,
+}
+
+func fulfilmentRank(status models.OrderStatus) int {
+	for i, s := range orderFulfilmentPath {
+		if s == status {
+			return i
+		}
+	}
+	return -1
+}
+
+// shippedQuantities الكميات الموزعة على الشحنات لكل عنصر طلب
+func shippedQuantities(tx *gorm.DB, orderID uuid.UUID) (map[uuid.UUID]int, error) {
+	var rows []struct {
+		OrderItemID uuid.UUID
+		Quantity    int
+	}
+	err := tx.Table("shipment_items").
+		Select("shipment_items.order_item_id, SUM(shipment_items.quantity) AS quantity").
+		Joins("JOIN shipments ON shipments.id = shipment_items.shipment_id").
+		Where("shipments.order_id = ?", orderID).
+		Group("shipment_items.order_item_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	shipped := make(map[uuid.UUID]int, len(rows))
+	for _, row := range rows {
+		shipped[row.OrderItemID] = row.Quantity
+	}
+	return shipped, nil
+}
+
+// CreateShipment إنشاء طرد جديد لجزء من عناصر الطلب أو كلها
+// يُقفل صف الطلب حتى لا تتجاوز الشحنات المتزامنة الكميات المطلوبة.
+func CreateShipment(tx *gorm.DB, order *models.Order, input ShipmentInput, actorID *uuid.UUID) (*models.Shipment, error) {
+	if len(input.Lines) == 0 {
+		return nil, ErrShipmentEmpty
+	}
+	input.Carrier = strings.TrimSpace(input.Carrier)
+	input.TrackingNumber = strings.TrimSpace(input.TrackingNumber)
+	if input.Carrier == "" || input.TrackingNumber == "" {
+		return nil, ErrShipmentCarrier
+	}
+
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(order, "id = ?", order.ID).Error; err != nil {
+		return nil, err
+	}
+	if !shippableStatuses[order.Status] {
+		return nil, fmt.Errorf("order %s is %s: %w", order.OrderNumber, order.Status, ErrShipmentNotAllowed)
+	}
+
+	var orderItems []models.OrderItem
+	if err := tx.Where("order_id = ?", order.ID).Find(&orderItems).Error; err != nil {
+		return nil, err
+	}
+	itemsByID := make(map[uuid.UUID]models.OrderItem, len(orderItems))
+	for _, item := range orderItems {
+		itemsByID[item.ID] = item
+	}
+
+	shipped, err := shippedQuantities(tx, order.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	shipment := &models.Shipment{
+		OrderID:        order.ID,
+		Carrier:        input.Carrier,
+		TrackingNumber: input.TrackingNumber,
+		WeightKg:       input.WeightKg,
+		Status:         models.ShipmentStatusPending,
+		CreatedBy:      actorID,
+	}
+	for idx, line := range input.Lines {
+		item, ok := itemsByID[line.OrderItemID]
+		if !ok {
+			return nil, fmt.Errorf("item %d (%s): %w", idx, line.OrderItemID, ErrShipmentItemNotFound)
+		}
+		if line.Quantity < 1 || shipped[item.ID]+line.Quantity > item.Quantity {
+			return nil, fmt.Errorf("item %d (%s): requested %d, left %d: %w", idx, item.Name, line.Quantity, item.Quantity-shipped[item.ID], ErrShipmentQuantity)
+		}
+		shipped[item.ID] += line.Quantity
+		shipment.Items = append(shipment.Items, models.ShipmentItem{
+			OrderItemID: item.ID,
+			ProductID:   item.ProductID,
+			Name:        item.Name,
+			Quantity:    line.Quantity,
+		})
+	}
+
+	if err := tx.Create(shipment).Error; err != nil {
+		return nil, err
+	}
+
+	// بدء تجهيز الطلب عند إنشاء أول شحنة لطلب مؤكد
+	if order.Status == models.OrderStatusConfirmed {
+		if _, err := TransitionOrderStatus(tx, order, StatusChange{
+			To:          models.OrderStatusProcessing,
+			ActorID:     actorID,
+			ActorRole:   models.RoleAdmin,
+			Description: "بدأ تجهيز الطلب للشحن",
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := addShipmentTracking(tx, shipment, string(models.ShipmentStatusPending), actorID,
+		fmt.Sprintf("تم تجهيز الشحنة %s مع %s (%s)", shipment.ShipmentNumber, shipment.Carrier, shipment.TrackingNumber), nil); err != nil {
+		return nil, err
+	}
+	return shipment, nil
+}
+
+// UpdateShipmentStatus نقل الشحنة إلى حالة تالية وتحديث حالة الطلب المشتقة من شحناته
+func UpdateShipmentStatus(tx *gorm.DB, shipment *models.Shipment, status models.ShipmentStatus, actorID *uuid.UUID, description string, location *string) error {
+	if !shipment.CanMoveTo(status) {
+		return fmt.Errorf("%s -> %s: %w", shipment.Status, status, ErrShipmentStatus)
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{
+		"status":     status,
+		"updated_at": now,
+	}
+	if shipment.ShippedAt == nil && status != models.ShipmentStatusPending {
+		updates["shipped_at"] = now
+		shipment.ShippedAt = &now
+	}
+	if status == models.ShipmentStatusDelivered {
+		updates["delivered_at"] = now
+		shipment.DeliveredAt = &now
+	}
+
+	result := tx.Model(&models.Shipment{}).Where("id = ? AND status = ?", shipment.ID, shipment.Status).Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%s: %w", shipment.ShipmentNumber, ErrShipmentStatus)
+	}
+	shipment.Status = status
+
+	// تكلفة البضاعة المباعة تُسجل عند مغادرة الشحنة المستودع
+	if updates["shipped_at"] != nil {
+		if err := CostShipment(tx, shipment); err != nil {
+			return fmt.Errorf("cost shipment %s: %w", shipment.ShipmentNumber, err)
+		}
+	}
+
+	if description == "" {
+		description = fmt.Sprintf("تم تحديث حالة الشحنة %s إلى %s", shipment.ShipmentNumber, status)
+	}
+	if err := addShipmentTracking(tx, shipment, string(status), actorID, description, location); err != nil {
+		return err
+	}
+
+	var order models.Order
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, "id = ?", shipment.OrderID).Error; err != nil {
+		return err
+	}
+	return SyncOrderStatusFromShipments(tx, &order, actorID)
+}
+
+// DeriveOrderStatus اشتقاق حالة الطلب من كميات شحناته
+// يعيد false إذا لم تغادر أي شحنة المستودع بعد.
+func DeriveOrderStatus(items []models.OrderItem, shipments []models.Shipment) (models.OrderStatus, bool) {
+	var ordered, shipped, delivered int
+	for _, item := range items {
+		ordered += item.Quantity
+	}
+	for _, shipment := range shipments {
+		var qty int
+		for _, item := range shipment.Items {
+			qty += item.Quantity
+		}
+		if shipment.HasLeftWarehouse() {
+			shipped += qty
+		}
+		if shipment.Status == models.ShipmentStatusDelivered {
+			delivered += qty
+		}
+	}
+
+	switch {
+	case shipped == 0:
+		return "", false
+	case delivered >= ordered:
+		return models.OrderStatusDelivered, true
+	case shipped >= ordered:
+		return models.OrderStatusShipped, true
+	default:
+		return models.OrderStatusPartiallyShipped, true
+	}
+}
+
+// SyncOrderStatusFromShipments نقل الطلب خطوة بخطوة عبر مخطط الحالات حتى الحالة المشتقة من الشحنات
+// لا يعيد الطلب إلى الخلف أبداً.
+func SyncOrderStatusFromShipments(tx *gorm.DB, order *models.Order, actorID *uuid.UUID) error {
+	var items []models.OrderItem
+	if err := tx.Where("order_id = ?", order.ID).Find(&items).Error; err != nil {
+		return err
+	}
+	var shipments []models.Shipment
+	if err := tx.Preload("Items").Where("order_id = ?", order.ID).Order("created_at ASC").Find(&shipments).Error; err != nil {
+		return err
+	}
+
+	target, ok := DeriveOrderStatus(items, shipments)
+	if !ok {
+		return nil
+	}
+
+	// رقم التتبع المسجل على الطلب هو رقم أول شحنة غادرت المستودع
+	var carrier, trackingNumber string
+	for _, s := range shipments {
+		if s.HasLeftWarehouse() {
+			carrier, trackingNumber = s.Carrier, s.TrackingNumber
+			break
+		}
+	}
+
+	targetRank := fulfilmentRank(target)
+	for {
+		current := fulfilmentRank(order.Status)
+		if current < 0 || current >= targetRank {
+			return nil
+		}
+
+		// أبعد حالة يمكن الوصول إليها مباشرة دون تجاوز الهدف
+		var next models.OrderStatus
+		for r := targetRank; r > current; r-- {
+			if _, allowed := models.FindOrderTransition(order.Status, orderFulfilmentPath[r]); allowed {
+				next = orderFulfilmentPath[r]
+				break
+			}
+		}
+		if next == "" {
+			return nil
+		}
+
+		if _, err := TransitionOrderStatus(tx, order, StatusChange{
+			To:             next,
+			ActorID:        actorID,
+			ActorRole:      models.RoleAdmin,
+			TrackingNumber: trackingNumber,
+			Carrier:        carrier,
+			Description:    fmt.Sprintf("تم تحديث حالة الطلب من الشحنات إلى %s", next),
+		}); err != nil {
+			return err
+		}
+	}
+}
+
+func addShipmentTracking(tx *gorm.DB, shipment *models.Shipment, event string, actorID *uuid.UUID, description string, location *string) error {
+	tracking := models.OrderTracking{
+		OrderID:     shipment.OrderID,
+		ShipmentID:  &shipment.ID,
+		Status:      event,
+		ActorID:     actorID,
+		Description: description,
+		Location:    location,
+		Timestamp:   time.Now(),
+	}
+	return tx.Create(&tracking).Error
+}
@@ -0,0 +1,39 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"pharmacy-backend/models"
+)
+
+func TestDeriveOrderStatus(t *testing.T) {
+	items := []models.OrderItem{{Quantity: 3}, {Quantity: 2}}
+	parcel := func(status models.ShipmentStatus, qty int) models.Shipment {
+		return models.Shipment{Status: status, Items: []models.ShipmentItem{{Quantity: qty}}}
+	}
+
+	tests := []struct {
+		name      string
+		shipments []models.Shipment
+		want      models.OrderStatus
+		derived   bool
+	}{
+		{"no shipments", nil, "", false},
+		{"only packed", []models.Shipment{parcel(models.ShipmentStatusPending, 5)}, "", false},
+		{"one of two parcels shipped", []models.Shipment{parcel(models.ShipmentStatusShipped, 3), parcel(models.ShipmentStatusPending, 2)}, models.OrderStatusPartiallyShipped, true},
+		{"partial quantity shipped", []models.Shipment{parcel(models.ShipmentStatusInTransit, 4)}, models.OrderStatusPartiallyShipped, true},
+		{"all parcels shipped", []models.Shipment{parcel(models.ShipmentStatusShipped, 3), parcel(models.ShipmentStatusInTransit, 2)}, models.OrderStatusShipped, true},
+		{"one delivered, one in transit", []models.Shipment{parcel(models.ShipmentStatusDelivered, 3), parcel(models.ShipmentStatusInTransit, 2)}, models.OrderStatusShipped, true},
+		{"all delivered", []models.Shipment{parcel(models.ShipmentStatusDelivered, 3), parcel(models.ShipmentStatusDelivered, 2)}, models.OrderStatusDelivered, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := DeriveOrderStatus(items, tt.shipments)
+			assert.Equal(t, tt.derived, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
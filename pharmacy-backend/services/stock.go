@@ -0,0 +1,269 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"pharmacy-backend/models"
+)
+
+// ErrInsufficientStock الكمية المطلوبة أكبر من المخزون المتاح
+var ErrInsufficientStock = errors.New("insufficient stock")
+
+// InsufficientStockError تفاصيل نقص المخزون لمنتج محدد
+type InsufficientStockError struct {
+	ProductID uuid.UUID
+	Name      string
+	Requested int
+	Available int
+}
+
+func (e *InsufficientStockError) Error() string {
+	return fmt.Sprintf("%s: requested %d, available %d", e.Name, e.Requested, e.Available)
+}
+
+// Unwrap يسمح باستخدام errors.Is مع ErrInsufficientStock
+func (e *InsufficientStockError) Unwrap() error {
+	return ErrInsufficientStock
+}
+
+// BatchAllocation كمية محجوزة من دفعة محددة
+type BatchAllocation struct {
+	BatchID     uuid.UUID
+	BatchNumber string
+	ExpiryDate  *time.Time
+	LocationID  *uuid.UUID
+	Quantity    int
+}
+
+// AllocateStock خصم كمية من مخزون المنتج بشكل آمن مع الطلبات المتزامنة
+// الخصم مشروط بتوفر الكمية في نفس جملة UPDATE، فإذا لم يتأثر أي صف
+// فالمخزون غير كافٍ ولا يصبح سالباً أبداً.
+// إذا كان للمنتج دفعات تُحجز الكمية منها بترتيب الأقرب انتهاءً (FEFO) وتُعاد الدفعات المحجوزة.
+func AllocateStock(tx *gorm.DB, productID uuid.UUID, quantity int) ([]BatchAllocation, error) {
+	return AllocateStockFrom(tx, productID, quantity, nil)
+}
+
+// AllocateStockFrom مثل AllocateStock مع تفضيل دفعات الفروع بالترتيب المعطى
+// يُستنفد الفرع الأول قبل الانتقال للتالي، وبدون ترتيب تُعامل الفروع كمخزون واحد.
+func AllocateStockFrom(tx *gorm.DB, productID uuid.UUID, quantity int, preference []uuid.UUID) ([]BatchAllocation, error) {
+	result := tx.Model(&models.Product{}).
+		Where("id = ? AND stock_quantity >= ?", productID, quantity).
+		Update("stock_quantity", gorm.Expr("stock_quantity - ?", quantity))
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return allocateBatches(tx, productID, quantity, preference)
+	}
+
+	var product models.Product
+	if err := tx.Select("id", "stock_quantity").First(&product, "id = ?", productID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%s: %w", productID, ErrProductNotFound)
+		}
+		return nil, err
+	}
+	return nil, insufficientStock(tx, productID, quantity, product.StockQuantity)
+}
+
+// allocateBatches حجز الكمية من دفعات المنتج غير المنتهية ثم مزامنة مخزونه
+// صف المنتج مقفل مسبقاً بجملة الخصم، فالدفعات تُقفل دائماً بعده بنفس الترتيب.
+// المنتج الذي لا دفعات له إطلاقاً يبقى على عداد المخزون وحده.
+func allocateBatches(tx *gorm.DB, productID uuid.UUID, quantity int, preference []uuid.UUID) ([]BatchAllocation, error) {
+	var batches []models.ProductBatch
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("product_id = ? AND quantity > 0", productID).
+		Find(&batches).Error; err != nil {
+		return nil, err
+	}
+	if len(batches) == 0 {
+		tracked, err := productHasBatches(tx, productID)
+		if err != nil || !tracked {
+			return nil, err
+		}
+	}
+
+	allocations := PlanLocationFEFO(batches, quantity, time.Now(), preference)
+	allocated := 0
+	for _, allocation := range allocations {
+		allocated += allocation.Quantity
+	}
+	// العداد قد يتضمن دفعات انتهت منذ آخر مزامنة أو دفعات فروع موقوفة
+	if allocated < quantity {
+		return nil, insufficientStock(tx, productID, quantity, allocated)
+	}
+
+	for _, allocation := range allocations {
+		if err := tx.Model(&models.ProductBatch{}).
+			Where("id = ?", allocation.BatchID).
+			Update("quantity", gorm.Expr("quantity - ?", allocation.Quantity)).Error; err != nil {
+			return nil, fmt.Errorf("allocate batch %s: %w", allocation.BatchNumber, err)
+		}
+	}
+	return allocations, SyncProductStock(tx, productID)
+}
+
+// PlanFEFO اختيار الكميات من الدفعات القابلة للبيع بترتيب الأقرب انتهاءً ثم الأقدم استلاماً
+// الدفعات بلا تاريخ انتهاء تُصرف أخيراً؛ قد يكون المجموع أقل من المطلوب إذا لم تكفِ الدفعات.
+func PlanFEFO(batches []models.ProductBatch, quantity int, at time.Time) []BatchAllocation {
+	sellable := make([]models.ProductBatch, 0, len(batches))
+	for _, batch := range batches {
+		if batch.IsSellable(at) {
+			sellable = append(sellable, batch)
+		}
+	}
+	sort.SliceStable(sellable, func(i, j int) bool {
+		a, b := sellable[i].ExpiryDate, sellable[j].ExpiryDate
+		switch {
+		case a != nil && b != nil && !a.Equal(*b):
+			return a.Before(*b)
+		case (a == nil) != (b == nil):
+			return a != nil
+		}
+		return sellable[i].ReceivedAt.Before(sellable[j].ReceivedAt)
+	})
+
+	var allocations []BatchAllocation
+	for _, batch := range sellable {
+		if quantity <= 0 {
+			break
+		}
+		take := batch.Quantity
+		if take > quantity {
+			take = quantity
+		}
+		allocations = append(allocations, BatchAllocation{
+			BatchID:     batch.ID,
+			BatchNumber: batch.BatchNumber,
+			ExpiryDate:  batch.ExpiryDate,
+			LocationID:  batch.LocationID,
+			Quantity:    take,
+		})
+		quantity -= take
+	}
+	return allocations
+}
+
+// PlanLocationFEFO تطبيق FEFO داخل كل فرع بترتيب التفضيل
+// دفعات الفروع غير المذكورة (كالفروع الموقوفة) لا تُصرف.
+func PlanLocationFEFO(batches []models.ProductBatch, quantity int, at time.Time, preference []uuid.UUID) []BatchAllocation {
+	if len(preference) == 0 {
+		return PlanFEFO(batches, quantity, at)
+	}
+	rank := make(map[uuid.UUID]int, len(preference))
+	for i, id := range preference {
+		if _, seen := rank[id]; !seen {
+			rank[id] = i
+		}
+	}
+	groups := make([][]models.ProductBatch, len(preference))
+	for _, batch := range batches {
+		if batch.LocationID == nil {
+			continue
+		}
+		if r, ok := rank[*batch.LocationID]; ok {
+			groups[r] = append(groups[r], batch)
+		}
+	}
+
+	var allocations []BatchAllocation
+	for _, group := range groups {
+		if quantity <= 0 {
+			break
+		}
+		for _, allocation := range PlanFEFO(group, quantity, at) {
+			allocations = append(allocations, allocation)
+			quantity -= allocation.Quantity
+		}
+	}
+	return allocations
+}
+
+// insufficientStock بناء خطأ نقص المخزون مع اسم المنتج
+func insufficientStock(tx *gorm.DB, productID uuid.UUID, requested, available int) error {
+	var product models.Product
+	if err := tx.Select("id", "name").First(&product, "id = ?", productID).Error; err != nil {
+		return err
+	}
+	return &InsufficientStockError{
+		ProductID: productID,
+		Name:      product.Name,
+		Requested: requested,
+		Available: available,
+	}
+}
+
+// StockAllocations الدفعات المحجوزة لكل منتج في الطلب
+type StockAllocations map[uuid.UUID][]BatchAllocation
+
+// Take سحب كمية عنصر من دفعات منتجه بالترتيب
+// يوزع حجز المنتج المكرر في أكثر من سطر على عناصره.
+func (a StockAllocations) Take(productID uuid.UUID, quantity int) []BatchAllocation {
+	var taken []BatchAllocation
+	pool := a[productID]
+	for len(pool) > 0 && quantity > 0 {
+		part := pool[0]
+		if part.Quantity > quantity {
+			part.Quantity = quantity
+			pool[0].Quantity -= quantity
+		} else {
+			pool = pool[1:]
+		}
+		taken = append(taken, part)
+		quantity -= part.Quantity
+	}
+	a[productID] = pool
+	return taken
+}
+
+// AllocateOrderStock حجز مخزون جميع عناصر الطلب داخل معاملته
+// تُجمع كميات المنتج المكرر وتُخصم بترتيب ثابت للمعرفات لتجنب الجمود
+// بين طلبين متزامنين يحتويان نفس المنتجات بترتيب مختلف.
+// يُجهز الطلب من أقرب فرع لمدينة الشحن يكفي مخزونه الطلب كاملاً ويُعاد معرفه،
+// وإذا لم يكفِ فرع وحده تُصرف الكميات من الفروع بترتيب قربها ويُعاد فارغ.
+func AllocateOrderStock(tx *gorm.DB, lines []QuotedLine, city string) (StockAllocations, *uuid.UUID, error) {
+	quantities := make(map[uuid.UUID]int, len(lines))
+	ids := make([]uuid.UUID, 0, len(lines))
+	for _, line := range lines {
+		if _, seen := quantities[line.ProductID]; !seen {
+			ids = append(ids, line.ProductID)
+		}
+		quantities[line.ProductID] += line.Quantity
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+
+	preference, err := fulfilmentPreference(tx, city)
+	if err != nil {
+		return nil, nil, err
+	}
+	available, tracked, err := locationAvailability(tx, ids, time.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+	needs := make(map[uuid.UUID]int, len(ids))
+	for _, id := range ids {
+		if tracked[id] {
+			needs[id] = quantities[id]
+		}
+	}
+	location := chooseFulfilmentLocation(preference, available, needs)
+	if location != nil {
+		preference = preferLocation(preference, *location)
+	}
+
+	allocations := make(StockAllocations, len(ids))
+	for _, id := range ids {
+		batches, err := AllocateStockFrom(tx, id, quantities[id], preference)
+		if err != nil {
+			return nil, nil, err
+		}
+		allocations[id] = batches
+	}
+	return allocations, location, nil
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"pharmacy-backend/models"
+)
+
+// أخطاء تسوية المخزون
+var (
+	ErrInvalidAdjustment = errors.New("stock adjustment needs a non-zero quantity and a reason code (damage, theft, expiry_write_off, count_correction)")
+	ErrBatchRequired     = errors.New("stock of this product is tracked by batch; choose the batch to adjust")
+)
+
+// IsAdjustmentError التحقق مما إذا كان الخطأ من أخطاء التحقق في التسوية
+func IsAdjustmentError(err error) bool {
+	return errors.Is(err, ErrInvalidAdjustment) || errors.Is(err, ErrBatchRequired)
+}
+
+// StockAdjustment تعديل كمية منتج أو إحدى دفعاته بسبب محدد
+// الكمية فرق موجب أو سالب وليست الكمية الجديدة.
+type StockAdjustment struct {
+	ProductID       uuid.UUID
+	BatchID         *uuid.UUID
+	Quantity        int
+	Reason          models.AdjustmentReason
+	ReferenceNumber string
+	Notes           string
+	ActorID         *uuid.UUID
+}
+
+// AdjustStock تطبيق تسوية على دفعة أو على عداد المنتج غير المتتبع بالدفعات
+// وتسجيلها كحركة تسوية بسببها؛ لا تنزل الكمية تحت الصفر.
+func AdjustStock(tx *gorm.DB, adj StockAdjustment) (*models.InventoryTransaction, error) {
+	if adj.Quantity == 0 || !adj.Reason.IsValid() {
+		return nil, ErrInvalidAdjustment
+	}
+	product, err := lockProduct(tx, adj.ProductID)
+	if err != nil {
+		return nil, err
+	}
+
+	movement := StockMovement{
+		ProductID:       product.ID,
+		Type:            models.TransactionTypeAdjustment,
+		Quantity:        adj.Quantity,
+		Reason:          adj.Reason,
+		ReferenceNumber: adj.ReferenceNumber,
+		Notes:           strings.TrimSpace(adj.Notes),
+		ActorID:         adj.ActorID,
+	}
+
+	if adj.BatchID != nil {
+		var batch models.ProductBatch
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&batch, "id = ? AND product_id = ?", *adj.BatchID, product.ID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrBatchNotFound
+			}
+			return nil, err
+		}
+		if batch.Quantity+adj.Quantity < 0 {
+			return nil, &InsufficientStockError{ProductID: product.ID, Name: product.Name, Requested: -adj.Quantity, Available: batch.Quantity}
+		}
+		if err := tx.Model(&batch).Update("quantity", gorm.Expr("quantity + ?", adj.Quantity)).Error; err != nil {
+			return nil, err
+		}
+		movement.SupplierID = batch.SupplierID
+		movement.UnitPrice = batch.UnitCost
+		if movement.ReferenceNumber == "" {
+			movement.ReferenceNumber = batch.BatchNumber
+		}
+		if movement.Notes == "" {
+			movement.Notes = fmt.Sprintf("تسوية كمية الدفعة %s", batch.BatchNumber)
+		}
+	} else {
+		tracked, err := productHasBatches(tx, product.ID)
+		if err != nil {
+			return nil, err
+		}
+		if tracked {
+			return nil, ErrBatchRequired
+		}
+		if product.StockQuantity+adj.Quantity < 0 {
+			return nil, &InsufficientStockError{ProductID: product.ID, Name: product.Name, Requested: -adj.Quantity, Available: product.StockQuantity}
+		}
+		if err := tx.Model(&models.Product{}).Where("id = ?", product.ID).
+			Update("stock_quantity", gorm.Expr("stock_quantity + ?", adj.Quantity)).Error; err != nil {
+			return nil, err
+		}
+		movement.SupplierID = product.SupplierID
+		if movement.UnitPrice, err = lastPurchaseCost(tx, product.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	recorded, err := RecordStockMovement(tx, movement)
+	if err != nil {
+		return nil, err
+	}
+	if err := recordAdjustmentCost(tx, product.ID, adj.BatchID, adj.Quantity, movement.ReferenceNumber); err != nil {
+		return nil, err
+	}
+	if adj.BatchID != nil {
+		return recorded, SyncProductStock(tx, product.ID)
+	}
+	return recorded, nil
+}
+
+// recordAdjustmentCost تسجيل أثر التسوية على دفتر التكلفة
+// النقص يُصرف بطريقة التكلفة، والزيادة تدخل بالتكلفة الحالية للدفعة أو المنتج.
+func recordAdjustmentCost(tx *gorm.DB, productID uuid.UUID, batchID *uuid.UUID, quantity int, reference string) error {
+	entry := models.InventoryCostEntry{
+		ProductID:       productID,
+		BatchID:         batchID,
+		Source:          models.CostSourceAdjustment,
+		ReferenceNumber: reference,
+	}
+	if quantity < 0 {
+		_, err := recordCostIssue(tx, entry, -quantity)
+		return err
+	}
+	unitCost, err := currentUnitCost(tx, productID, batchID)
+	if err != nil {
+		return err
+	}
+	entry.Quantity = quantity
+	entry.UnitCost = unitCost
+	return recordCostEntry(tx, &entry)
+}
+
+// lastPurchaseCost آخر تكلفة شراء مسجلة للمنتج، أو صفر إن لم توجد
+func lastPurchaseCost(tx *gorm.DB, productID uuid.UUID) (float64, error) {
+	var costs []float64
+	err := tx.Model(&models.InventoryTransaction{}).
+		Where("product_id = ? AND transaction_type = ? AND quantity > 0", productID, models.TransactionTypePurchase).
+		Order("created_at DESC").
+		Limit(1).
+		Pluck("unit_price", &costs).Error
+	if err != nil || len(costs) == 0 {
+		return 0, err
+	}
+	return costs[0], nil
+}
@@ -0,0 +1,212 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"pharmacy-backend/models"
+)
+
+// ErrInvalidStockMovement حركة مخزون لا تطابق قواعد نوعها
+var ErrInvalidStockMovement = errors.New("stock movement needs a product, a known type and a non-zero quantity in the direction of its type (sale out, cancel and write_off in, adjustment with a reason code)")
+
+// IsStockMovementError التحقق مما إذا كان الخطأ من أخطاء التحقق في حركة المخزون
+func IsStockMovementError(err error) bool {
+	return errors.Is(err, ErrInvalidStockMovement)
+}
+
+// ReconcileReference المرجع الذي تُسجل به حركات تصحيح الدفتر من أمر المطابقة
+const ReconcileReference = "RECONCILE"
+
+// StockMovement حركة مخزون واحدة في الدفتر
+// الكمية موقعة: موجبة لما يدخل المخزون وسالبة لما يخرج منه.
+type StockMovement struct {
+	ProductID       uuid.UUID
+	Type            models.TransactionType
+	Quantity        int
+	UnitPrice       float64
+	SupplierID      *uuid.UUID
+	Reason          models.AdjustmentReason
+	ReferenceNumber string
+	Notes           string
+	ActorID         *uuid.UUID
+}
+
+// validateStockMovement التحقق من النوع واتجاه الكمية
+// البيع يخرج دائماً، والإلغاء والإتلاف يدخلان، وبقية الأنواع في الاتجاهين.
+func validateStockMovement(m StockMovement) error {
+	if m.ProductID == uuid.Nil || m.Quantity == 0 || !m.Type.IsValid() {
+		return ErrInvalidStockMovement
+	}
+	switch m.Type {
+	case models.TransactionTypeSale:
+		if m.Quantity > 0 {
+			return ErrInvalidStockMovement
+		}
+	case models.TransactionTypeCancel, models.TransactionTypeWriteOff:
+		if m.Quantity < 0 {
+			return ErrInvalidStockMovement
+		}
+	case models.TransactionTypeAdjustment:
+		if !m.Reason.IsValid() {
+			return ErrInvalidStockMovement
+		}
+	}
+	return nil
+}
+
+// RecordStockMovement تسجيل حركة في دفتر المخزون داخل معاملة التغيير نفسه
+// كل تغيير في كمية منتج أو دفعاته يُسجل من هنا حتى يبقى مجموع الدفتر مساوياً للرصيد.
+func RecordStockMovement(tx *gorm.DB, m StockMovement) (*models.InventoryTransaction, error) {
+	if err := validateStockMovement(m); err != nil {
+		return nil, fmt.Errorf("%s of %d: %w", m.Type, m.Quantity, err)
+	}
+	movement := models.InventoryTransaction{
+		ProductID:       m.ProductID,
+		SupplierID:      m.SupplierID,
+		Quantity:        m.Quantity,
+		UnitPrice:       RoundMoney(m.UnitPrice),
+		TransactionType: m.Type,
+		ReasonCode:      m.Reason,
+		ReferenceNumber: m.ReferenceNumber,
+		Notes:           strings.TrimSpace(m.Notes),
+		CreatedBy:       m.ActorID,
+	}
+	if err := tx.Create(&movement).Error; err != nil {
+		return nil, fmt.Errorf("record %s for product %s: %w", m.Type, m.ProductID, err)
+	}
+	return &movement, nil
+}
+
+// recordOrderSale تسجيل صرف كمية عنصر طلب كحركة بيع
+func recordOrderSale(tx *gorm.DB, order *models.Order, item *models.OrderItem, quantity int, actorID *uuid.UUID) error {
+	_, err := RecordStockMovement(tx, StockMovement{
+		ProductID:       item.ProductID,
+		Type:            models.TransactionTypeSale,
+		Quantity:        -quantity,
+		UnitPrice:       item.UnitPrice,
+		ReferenceNumber: order.OrderNumber,
+		Notes:           fmt.Sprintf("بيع ضمن الطلب %s", order.OrderNumber),
+		ActorID:         actorID,
+	})
+	return err
+}
+
+// StockDrift فرق منتج بين مجموع دفتر الحركات ورصيده
+// الدفتر يشمل الدفعات المنتهية التي ما زالت في المخزن بينما يستبعدها الرصيد.
+type StockDrift struct {
+	ProductID       uuid.UUID `json:"product_id"`
+	SKU             string    `json:"sku"`
+	Name            string    `json:"name"`
+	LedgerQuantity  int       `json:"ledger_quantity"`
+	StockQuantity   int       `json:"stock_quantity"`
+	ExpiredQuantity int       `json:"expired_quantity"`
+	Drift           int       `json:"drift"`
+	Fixed           bool      `json:"fixed"`
+}
+
+// StockReconciliation نتيجة مطابقة الدفتر مع أرصدة المنتجات
+type StockReconciliation struct {
+	Checked int          `json:"checked"`
+	Drifted []StockDrift `json:"drifted"`
+	Fixed   int          `json:"fixed"`
+}
+
+// stockDrift الفرق الذي يجب تصحيحه في الدفتر: موجب إذا زاد الدفتر عن المخزن
+func stockDrift(ledger, stock, expired int) int {
+	return ledger - stock - expired
+}
+
+// loadStockDrifts أرصدة الدفتر والمنتجات، لكل المنتجات أو لمنتج واحد
+func loadStockDrifts(db *gorm.DB, now time.Time, productID *uuid.UUID) ([]StockDrift, error) {
+	query := db.Table("products p").
+		Select(`p.id AS product_id, p.sku, p.name, p.stock_quantity,
+			COALESCE((SELECT SUM(t.quantity) FROM inventory_transactions t
+				WHERE t.product_id = p.id AND t.transaction_type <> ?), 0) AS ledger_quantity,
+			COALESCE((SELECT SUM(b.quantity) FROM product_batches b
+				WHERE b.product_id = p.id AND b.quantity > 0 AND b.expiry_date IS NOT NULL AND b.expiry_date <= ?), 0) AS expired_quantity`,
+			models.TransactionTypeWriteOff, now).
+		Order("p.sku")
+	if productID != nil {
+		query = query.Where("p.id = ?", *productID)
+	}
+	var rows []StockDrift
+	if err := query.Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	for i := range rows {
+		rows[i].Drift = stockDrift(rows[i].LedgerQuantity, rows[i].StockQuantity, rows[i].ExpiredQuantity)
+	}
+	return rows, nil
+}
+
+// ReconcileStock مقارنة مجموع حركات كل منتج برصيده والإبلاغ عن الفروق
+// تُستبعد الدفعات المنتهية من الأرصدة أولاً كما يفعل المجدول حتى لا تظهر فروقاً وهمية.
+// مع fix يُسجل كل فرق كحركة تسوية (تصحيح عد) بالمرجع RECONCILE، فالرصيد هو المرجع
+// لأنه مجموع الدفعات، والمخزون السابق للدفتر سُجل برصيد افتتاحي عند الترحيل. كل منتج في معاملة مستقلة.
+func ReconcileStock(db *gorm.DB, now time.Time, fix bool, actorID *uuid.UUID) (*StockReconciliation, error) {
+	if _, err := SyncExpiredBatchStock(db, now); err != nil {
+		return nil, err
+	}
+	rows, err := loadStockDrifts(db, now, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &StockReconciliation{Checked: len(rows), Drifted: []StockDrift{}}
+	for _, row := range rows {
+		if row.Drift == 0 {
+			continue
+		}
+		if fix {
+			err := db.Transaction(func(tx *gorm.DB) error {
+				fixed, err := fixStockDrift(tx, row.ProductID, now, actorID)
+				if err != nil {
+					return err
+				}
+				row.Fixed = fixed
+				return nil
+			})
+			if err != nil {
+				log.Printf("⚠️ فشل في تصحيح دفتر المنتج %s: %v", row.SKU, err)
+			} else if row.Fixed {
+				result.Fixed++
+			}
+		}
+		result.Drifted = append(result.Drifted, row)
+	}
+	return result, nil
+}
+
+// fixStockDrift إعادة حساب فرق المنتج بعد قفله وتسجيل حركة تسوية تلغيه
+func fixStockDrift(tx *gorm.DB, productID uuid.UUID, now time.Time, actorID *uuid.UUID) (bool, error) {
+	product, err := lockProduct(tx, productID)
+	if err != nil {
+		return false, err
+	}
+	rows, err := loadStockDrifts(tx, now, &productID)
+	if err != nil || len(rows) == 0 || rows[0].Drift == 0 {
+		return false, err
+	}
+	unitPrice, err := lastPurchaseCost(tx, productID)
+	if err != nil {
+		return false, err
+	}
+	_, err = RecordStockMovement(tx, StockMovement{
+		ProductID:       productID,
+		Type:            models.TransactionTypeAdjustment,
+		Quantity:        -rows[0].Drift,
+		UnitPrice:       unitPrice,
+		SupplierID:      product.SupplierID,
+		Reason:          models.AdjustmentReasonCountCorrection,
+		ReferenceNumber: ReconcileReference,
+		Notes:           "مطابقة دفتر الحركات مع رصيد المنتج",
+		ActorID:         actorID,
+	})
+	return err == nil, err
+}
@@ -0,0 +1,48 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"pharmacy-backend/models"
+)
+
+func TestValidateStockMovement(t *testing.T) {
+	productID := uuid.New()
+	valid := []StockMovement{
+		{ProductID: productID, Type: models.TransactionTypeSale, Quantity: -2},
+		{ProductID: productID, Type: models.TransactionTypeCancel, Quantity: 2},
+		{ProductID: productID, Type: models.TransactionTypePurchase, Quantity: -5},
+		{ProductID: productID, Type: models.TransactionTypeTransfer, Quantity: 3},
+		{ProductID: productID, Type: models.TransactionTypeOpening, Quantity: -4},
+		{ProductID: productID, Type: models.TransactionTypeAdjustment, Quantity: -1, Reason: models.AdjustmentReasonDamage},
+	}
+	for _, m := range valid {
+		assert.NoError(t, validateStockMovement(m), m.Type)
+	}
+
+	invalid := []StockMovement{
+		{ProductID: productID, Type: models.TransactionTypeSale, Quantity: 2},
+		{ProductID: productID, Type: models.TransactionTypeCancel, Quantity: -2},
+		{ProductID: productID, Type: models.TransactionTypeWriteOff, Quantity: -1},
+		{ProductID: productID, Type: models.TransactionTypeAdjustment, Quantity: 1},
+		{ProductID: productID, Type: models.TransactionTypeReturn, Quantity: 0},
+		{ProductID: productID, Type: "gift", Quantity: 1},
+		{Type: models.TransactionTypePurchase, Quantity: 1},
+	}
+	for _, m := range invalid {
+		assert.True(t, errors.Is(validateStockMovement(m), ErrInvalidStockMovement), m.Type)
+	}
+}
+
+func TestStockDrift(t *testing.T) {
+	// الدفعات المنتهية في المخزن ليست فرقاً
+	assert.Equal(t, 0, stockDrift(12, 10, 2))
+	// بيع لم يُسجل في الدفتر
+	assert.Equal(t, 3, stockDrift(13, 10, 0))
+	// رصيد أُضيف دون حركة
+	assert.Equal(t, -10, stockDrift(0, 10, 0))
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"pharmacy-backend/config"
+	"pharmacy-backend/models"
+)
+
+// setupStockTestDB الاتصال بقاعدة بيانات اختبار حقيقية
+// يتطلب TEST_DATABASE_URL لأن الاختبار يعتمد على أقفال صفوف Postgres.
+func setupStockTestDB(t *testing.T) *gorm.DB {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set; skipping concurrent stock tests")
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+		Logger:                                   logger.Default.LogMode(logger.Silent),
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+	require.NoError(t, err)
+
+	config.DB = db
+	require.NoError(t, config.AutoMigrate())
+	return db
+}
+
+func createStockTestProduct(t *testing.T, db *gorm.DB, stock int) models.Product {
+	product := models.Product{
+		Name:          "Stock test product",
+		Price:         10,
+		SKU:           "STOCK-TEST-" + uuid.New().String()[:8],
+		CategoryID:    uuid.New(),
+		StockQuantity: stock,
+		IsActive:      true,
+	}
+	require.NoError(t, db.Create(&product).Error)
+	t.Cleanup(func() {
+		db.Unscoped().Delete(&models.Product{}, "id = ?", product.ID)
+	})
+	return product
+}
+
+func TestAllocateOrderStockConcurrentNoOversell(t *testing.T) {
+	db := setupStockTestDB(t)
+
+	const stock = 5
+	const buyers = 40
+	product := createStockTestProduct(t, db, stock)
+
+	var (
+		wg           sync.WaitGroup
+		mu           sync.Mutex
+		succeeded    int
+		insufficient int
+		unexpected   []error
+	)
+	start := make(chan struct{})
+	for i := 0; i < buyers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+
+			err := db.Transaction(func(tx *gorm.DB) error {
+				_, _, err := AllocateOrderStock(tx, []QuotedLine{{ProductID: product.ID, Quantity: 1}}, "")
+				return err
+			})
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				succeeded++
+			case errors.Is(err, ErrInsufficientStock):
+				insufficient++
+			default:
+				unexpected = append(unexpected, err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	assert.Empty(t, unexpected)
+	assert.Equal(t, stock, succeeded)
+	assert.Equal(t, buyers-stock, insufficient)
+
+	var reloaded models.Product
+	require.NoError(t, db.First(&reloaded, "id = ?", product.ID).Error)
+	assert.Equal(t, 0, reloaded.StockQuantity)
+}
+
+func TestAllocateOrderStockMergesDuplicateLines(t *testing.T) {
+	db := setupStockTestDB(t)
+	product := createStockTestProduct(t, db, 3)
+
+	// سطران لنفس المنتج مجموعهما أكبر من المخزون يجب أن يُرفضا معاً
+	err := db.Transaction(func(tx *gorm.DB) error {
+		_, _, err := AllocateOrderStock(tx, []QuotedLine{
+			{ProductID: product.ID, Quantity: 2},
+			{ProductID: product.ID, Quantity: 2},
+		}, "")
+		return err
+	})
+
+	var stockErr *InsufficientStockError
+	require.True(t, errors.As(err, &stockErr))
+	assert.Equal(t, 4, stockErr.Requested)
+	assert.Equal(t, 3, stockErr.Available)
+}
+
+func TestStockCheckConstraintRejectsNegative(t *testing.T) {
+	db := setupStockTestDB(t)
+	product := createStockTestProduct(t, db, 1)
+
+	err := db.Model(&models.Product{}).
+		Where("id = ?", product.ID).
+		Update("stock_quantity", gorm.Expr("stock_quantity - ?", 2)).Error
+	assert.Error(t, err)
+}
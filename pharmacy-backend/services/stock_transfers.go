@@ -0,0 +1,441 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"pharmacy-backend/models"
+)
+
+// أخطاء تحويلات المخزون
+var (
+	ErrStockTransferNotFound = errors.New("stock transfer not found")
+	ErrInvalidStockTransfer  = errors.New("stock transfer needs two different locations and at least one line with a product and positive quantity")
+	ErrStockTransferState    = errors.New("stock transfer is not in a state that allows this action")
+)
+
+// IsStockTransferError التحقق مما إذا كان الخطأ من أخطاء تحويلات المخزون
+func IsStockTransferError(err error) bool {
+	return errors.Is(err, ErrStockTransferNotFound) ||
+		errors.Is(err, ErrInvalidStockTransfer) ||
+		errors.Is(err, ErrStockTransferState)
+}
+
+// StockTransferLineInput منتج وكمية مطلوبة في تحويل
+type StockTransferLineInput struct {
+	ProductID uuid.UUID
+	Quantity  int
+}
+
+// StockTransferInput بيانات طلب تحويل مخزون
+type StockTransferInput struct {
+	FromLocationID uuid.UUID
+	ToLocationID   uuid.UUID
+	Notes          string
+	Lines          []StockTransferLineInput
+}
+
+// TransferReceipt الكمية المستلمة فعلاً لسطر في التحويل
+type TransferReceipt struct {
+	LineID   uuid.UUID
+	Quantity int
+}
+
+// mergeTransferLines التحقق من الأسطر ودمج المنتج المكرر بترتيب أول ظهور
+func mergeTransferLines(lines []StockTransferLineInput) ([]StockTransferLineInput, error) {
+	if len(lines) == 0 {
+		return nil, ErrInvalidStockTransfer
+	}
+	merged := make([]StockTransferLineInput, 0, len(lines))
+	index := make(map[uuid.UUID]int, len(lines))
+	for i, line := range lines {
+		if line.ProductID == uuid.Nil || line.Quantity < 1 {
+			return nil, fmt.Errorf("%w: line %d", ErrInvalidStockTransfer, i+1)
+		}
+		if at, ok := index[line.ProductID]; ok {
+			merged[at].Quantity += line.Quantity
+			continue
+		}
+		index[line.ProductID] = len(merged)
+		merged = append(merged, line)
+	}
+	return merged, nil
+}
+
+// distributeReceived توزيع الكمية المستلمة على دفعات السطر بترتيب إرسالها
+// النقص يُحسب على الدفعات الأخيرة لأنها آخر ما حُمّل.
+func distributeReceived(sent []int, received int) []int {
+	portions := make([]int, len(sent))
+	for i, quantity := range sent {
+		if received <= 0 {
+			break
+		}
+		if quantity > received {
+			quantity = received
+		}
+		portions[i] = quantity
+		received -= quantity
+	}
+	return portions
+}
+
+// CreateStockTransfer إنشاء طلب تحويل بين فرعين نشطين
+func CreateStockTransfer(tx *gorm.DB, input StockTransferInput, actorID *uuid.UUID) (*models.StockTransfer, error) {
+	if input.FromLocationID == input.ToLocationID {
+		return nil, fmt.Errorf("%w: source and destination are the same location", ErrInvalidStockTransfer)
+	}
+	for _, id := range []uuid.UUID{input.FromLocationID, input.ToLocationID} {
+		id := id
+		if _, err := resolveLocationID(tx, &id); err != nil {
+			return nil, err
+		}
+	}
+	lines, err := mergeTransferLines(input.Lines)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uuid.UUID, 0, len(lines))
+	for _, line := range lines {
+		ids = append(ids, line.ProductID)
+	}
+	var found int64
+	if err := tx.Model(&models.Product{}).Where("id IN ?", ids).Count(&found).Error; err != nil {
+		return nil, err
+	}
+	if int(found) != len(ids) {
+		return nil, ErrProductNotFound
+	}
+
+	now := time.Now()
+	number, err := nextDocumentNumber(tx, DocumentStockTransfer, "STOCK_TRANSFER_PREFIX", "TRF", now)
+	if err != nil {
+		return nil, err
+	}
+	transfer := models.StockTransfer{
+		TransferNumber: number,
+		FromLocationID: input.FromLocationID,
+		ToLocationID:   input.ToLocationID,
+		Status:         models.StockTransferRequested,
+		Notes:          strings.TrimSpace(input.Notes),
+		RequestedBy:    actorID,
+	}
+	for i, line := range lines {
+		transfer.Lines = append(transfer.Lines, models.StockTransferLine{
+			ProductID:         line.ProductID,
+			RequestedQuantity: line.Quantity,
+			SortOrder:         i,
+		})
+	}
+	if err := tx.Create(&transfer).Error; err != nil {
+		return nil, fmt.Errorf("create stock transfer: %w", err)
+	}
+	return &transfer, nil
+}
+
+// lockStockTransfer قفل التحويل والتحقق من حالته وتحميل أسطره ودفعاتها
+func lockStockTransfer(tx *gorm.DB, id uuid.UUID, statuses ...models.StockTransferStatus) (*models.StockTransfer, error) {
+	var transfer models.StockTransfer
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&transfer, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrStockTransferNotFound
+		}
+		return nil, err
+	}
+	allowed := false
+	for _, status := range statuses {
+		allowed = allowed || transfer.Status == status
+	}
+	if !allowed {
+		return nil, fmt.Errorf("%w: stock transfer is %s", ErrStockTransferState, transfer.Status)
+	}
+	if err := tx.Preload("Batches").Order("sort_order ASC").
+		Find(&transfer.Lines, "stock_transfer_id = ?", transfer.ID).Error; err != nil {
+		return nil, err
+	}
+	return &transfer, nil
+}
+
+// LoadStockTransfer تحميل التحويل مع الفرعين والأسطر بمنتجاتها ودفعاتها
+func LoadStockTransfer(db *gorm.DB, id uuid.UUID) (*models.StockTransfer, error) {
+	var transfer models.StockTransfer
+	err := db.Preload("FromLocation").Preload("ToLocation").
+		Preload("Lines", func(q *gorm.DB) *gorm.DB { return q.Order("sort_order ASC") }).
+		Preload("Lines.Product").
+		Preload("Lines.Batches").
+		First(&transfer, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrStockTransferNotFound
+	}
+	return &transfer, err
+}
+
+// DispatchStockTransfer إخراج الكميات المطلوبة من دفعات الفرع المرسل بترتيب FEFO
+// تخرج الكمية من دفتر الحركات وتكلفتها من دفتر التكلفة حتى الاستلام، فالبضاعة في الطريق لا تظهر في مخزون أو تقييم أي فرع.
+func DispatchStockTransfer(tx *gorm.DB, id uuid.UUID, actorID *uuid.UUID) (*models.StockTransfer, error) {
+	transfer, err := lockStockTransfer(tx, id, models.StockTransferRequested)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := resolveLocationID(tx, &transfer.FromLocationID); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for i := range transfer.Lines {
+		line := &transfer.Lines[i]
+		product, err := lockProduct(tx, line.ProductID)
+		if err != nil {
+			return nil, err
+		}
+		if err := trackOpeningBatch(tx, product, actorID); err != nil {
+			return nil, err
+		}
+		var batches []models.ProductBatch
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("product_id = ? AND location_id = ? AND quantity > 0", line.ProductID, transfer.FromLocationID).
+			Find(&batches).Error; err != nil {
+			return nil, err
+		}
+		allocations := PlanFEFO(batches, line.RequestedQuantity, now)
+		available := 0
+		for _, allocation := range allocations {
+			available += allocation.Quantity
+		}
+		if available < line.RequestedQuantity {
+			return nil, insufficientStock(tx, line.ProductID, line.RequestedQuantity, available)
+		}
+
+		line.Batches = nil
+		dispatchedValue := 0.0
+		for _, allocation := range allocations {
+			if err := tx.Model(&models.ProductBatch{}).
+				Where("id = ?", allocation.BatchID).
+				Update("quantity", gorm.Expr("quantity - ?", allocation.Quantity)).Error; err != nil {
+				return nil, fmt.Errorf("dispatch batch %s: %w", allocation.BatchNumber, err)
+			}
+			batchID := allocation.BatchID
+			value, err := recordCostIssue(tx, models.InventoryCostEntry{
+				ProductID:       line.ProductID,
+				BatchID:         &batchID,
+				Source:          models.CostSourceTransferOut,
+				ReferenceNumber: transfer.TransferNumber,
+				OccurredAt:      now,
+			}, allocation.Quantity)
+			if err != nil {
+				return nil, err
+			}
+			dispatchedValue += value
+			line.Batches = append(line.Batches, models.StockTransferBatch{
+				StockTransferLineID: line.ID,
+				SourceBatchID:       allocation.BatchID,
+				BatchNumber:         allocation.BatchNumber,
+				ExpiryDate:          allocation.ExpiryDate,
+				Quantity:            allocation.Quantity,
+				Value:               value,
+			})
+		}
+		if err := tx.Create(&line.Batches).Error; err != nil {
+			return nil, fmt.Errorf("record transfer batches: %w", err)
+		}
+		line.DispatchedQuantity = available
+		if err := tx.Model(line).Update("dispatched_quantity", available).Error; err != nil {
+			return nil, err
+		}
+		if _, err := RecordStockMovement(tx, StockMovement{
+			ProductID:       line.ProductID,
+			Type:            models.TransactionTypeTransfer,
+			Quantity:        -available,
+			UnitPrice:       dispatchedValue / float64(available),
+			ReferenceNumber: transfer.TransferNumber,
+			Notes:           fmt.Sprintf("إرسال التحويل %s", transfer.TransferNumber),
+			ActorID:         actorID,
+		}); err != nil {
+			return nil, err
+		}
+		if err := SyncProductStock(tx, line.ProductID); err != nil {
+			return nil, err
+		}
+	}
+
+	transfer.Status = models.StockTransferDispatched
+	transfer.DispatchedBy = actorID
+	transfer.DispatchedAt = &now
+	return transfer, tx.Model(transfer).Updates(map[string]interface{}{
+		"status":        transfer.Status,
+		"dispatched_by": actorID,
+		"dispatched_at": now,
+		"updated_at":    now,
+	}).Error
+}
+
+// ReceiveStockTransfer استلام التحويل في الفرع المستلم
+// كل دفعة مرسلة تُضاف إلى دفعة مطابقة في الفرع المستلم أو تُنسخ كدفعة جديدة بنفس التكلفة والانتهاء.
+// الأسطر غير المذكورة تُستلم بكامل الكمية المرسلة، والنقص يبقى مسجلاً على المستند.
+func ReceiveStockTransfer(tx *gorm.DB, id uuid.UUID, receipts []TransferReceipt, actorID *uuid.UUID) (*models.StockTransfer, error) {
+	transfer, err := lockStockTransfer(tx, id, models.StockTransferDispatched)
+	if err != nil {
+		return nil, err
+	}
+	received := make(map[uuid.UUID]int, len(transfer.Lines))
+	for _, line := range transfer.Lines {
+		received[line.ID] = line.DispatchedQuantity
+	}
+	for _, receipt := range receipts {
+		dispatched, ok := received[receipt.LineID]
+		if !ok {
+			return nil, fmt.Errorf("%w: line %s is not part of this transfer", ErrInvalidStockTransfer, receipt.LineID)
+		}
+		if receipt.Quantity < 0 || receipt.Quantity > dispatched {
+			return nil, fmt.Errorf("%w: received quantity must be between 0 and %d", ErrInvalidStockTransfer, dispatched)
+		}
+		received[receipt.LineID] = receipt.Quantity
+	}
+
+	now := time.Now()
+	for i := range transfer.Lines {
+		line := &transfer.Lines[i]
+		if _, err := lockProduct(tx, line.ProductID); err != nil {
+			return nil, err
+		}
+		sent := make([]int, len(line.Batches))
+		for j, batch := range line.Batches {
+			sent[j] = batch.Quantity
+		}
+		receivedValue := 0.0
+		for j, portion := range distributeReceived(sent, received[line.ID]) {
+			if portion == 0 {
+				continue
+			}
+			moved := &line.Batches[j]
+			destination, err := receiveTransferBatch(tx, transfer, line.ProductID, moved, portion, actorID, now)
+			if err != nil {
+				return nil, err
+			}
+			receivedValue += moved.Value * float64(portion) / float64(moved.Quantity)
+			moved.ReceivedQuantity = portion
+			moved.DestinationBatchID = &destination
+			if err := tx.Model(moved).Updates(map[string]interface{}{
+				"received_quantity":    portion,
+				"destination_batch_id": destination,
+			}).Error; err != nil {
+				return nil, err
+			}
+		}
+		line.ReceivedQuantity = received[line.ID]
+		if err := tx.Model(line).Update("received_quantity", line.ReceivedQuantity).Error; err != nil {
+			return nil, err
+		}
+		if line.ReceivedQuantity > 0 {
+			if _, err := RecordStockMovement(tx, StockMovement{
+				ProductID:       line.ProductID,
+				Type:            models.TransactionTypeTransfer,
+				Quantity:        line.ReceivedQuantity,
+				UnitPrice:       receivedValue / float64(line.ReceivedQuantity),
+				ReferenceNumber: transfer.TransferNumber,
+				Notes:           fmt.Sprintf("استلام التحويل %s", transfer.TransferNumber),
+				ActorID:         actorID,
+			}); err != nil {
+				return nil, err
+			}
+		}
+		if err := SyncProductStock(tx, line.ProductID); err != nil {
+			return nil, err
+		}
+	}
+
+	transfer.Status = models.StockTransferReceived
+	transfer.ReceivedBy = actorID
+	transfer.ReceivedAt = &now
+	return transfer, tx.Model(transfer).Updates(map[string]interface{}{
+		"status":      transfer.Status,
+		"received_by": actorID,
+		"received_at": now,
+		"updated_at":  now,
+	}).Error
+}
+
+// receiveTransferBatch إضافة جزء مستلم من دفعة مرسلة إلى الفرع المستلم وقيد تكلفته
+func receiveTransferBatch(tx *gorm.DB, transfer *models.StockTransfer, productID uuid.UUID, moved *models.StockTransferBatch, quantity int, actorID *uuid.UUID, now time.Time) (uuid.UUID, error) {
+	var source models.ProductBatch
+	if err := tx.First(&source, "id = ?", moved.SourceBatchID).Error; err != nil {
+		return uuid.Nil, fmt.Errorf("load source batch %s: %w", moved.BatchNumber, err)
+	}
+
+	var destination models.ProductBatch
+	query := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("product_id = ? AND location_id = ? AND batch_number = ?", productID, transfer.ToLocationID, moved.BatchNumber)
+	if moved.ExpiryDate != nil {
+		query = query.Where("expiry_date = ?", *moved.ExpiryDate)
+	} else {
+		query = query.Where("expiry_date IS NULL")
+	}
+	err := query.First(&destination).Error
+	switch {
+	case err == nil:
+		if err := tx.Model(&destination).Updates(map[string]interface{}{
+			"quantity":          gorm.Expr("quantity + ?", quantity),
+			"received_quantity": gorm.Expr("received_quantity + ?", quantity),
+			"updated_at":        now,
+		}).Error; err != nil {
+			return uuid.Nil, err
+		}
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		toLocation := transfer.ToLocationID
+		destination = models.ProductBatch{
+			ProductID:        productID,
+			BatchNumber:      moved.BatchNumber,
+			ExpiryDate:       moved.ExpiryDate,
+			Quantity:         quantity,
+			ReceivedQuantity: quantity,
+			UnitCost:         source.UnitCost,
+			SupplierID:       source.SupplierID,
+			LocationID:       &toLocation,
+			ReceivedAt:       now,
+			Notes:            fmt.Sprintf("تحويل %s", transfer.TransferNumber),
+			CreatedBy:        actorID,
+		}
+		if err := tx.Create(&destination).Error; err != nil {
+			return uuid.Nil, fmt.Errorf("create transferred batch %s: %w", moved.BatchNumber, err)
+		}
+	default:
+		return uuid.Nil, err
+	}
+
+	unitCost := source.UnitCost
+	if moved.Quantity > 0 {
+		unitCost = moved.Value / float64(moved.Quantity)
+	}
+	if err := recordCostEntry(tx, &models.InventoryCostEntry{
+		ProductID:       productID,
+		BatchID:         &destination.ID,
+		Source:          models.CostSourceTransferIn,
+		Quantity:        quantity,
+		UnitCost:        unitCost,
+		ReferenceNumber: transfer.TransferNumber,
+		OccurredAt:      now,
+	}); err != nil {
+		return uuid.Nil, err
+	}
+	return destination.ID, nil
+}
+
+// CancelStockTransfer إلغاء طلب تحويل لم يخرج من الفرع المرسل
+func CancelStockTransfer(tx *gorm.DB, id uuid.UUID) (*models.StockTransfer, error) {
+	transfer, err := lockStockTransfer(tx, id, models.StockTransferRequested)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	transfer.Status = models.StockTransferCancelled
+	transfer.CancelledAt = &now
+	return transfer, tx.Model(transfer).Updates(map[string]interface{}{
+		"status":       transfer.Status,
+		"cancelled_at": now,
+		"updated_at":   now,
+	}).Error
+}
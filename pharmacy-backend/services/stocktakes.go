@@ -0,0 +1,470 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"pharmacy-backend/models"
+)
+
+// أخطاء جلسات الجرد
+var (
+	ErrStocktakeNotFound     = errors.New("stocktake not found")
+	ErrStocktakeState        = errors.New("stocktake is not in a state that allows this action")
+	ErrStocktakeOverlap      = errors.New("another open stocktake already covers these products")
+	ErrStocktakeEmpty        = errors.New("no products to count in this scope")
+	ErrStocktakeLineNotFound = errors.New("scanned item is not part of this stocktake")
+	ErrStocktakeAmbiguous    = errors.New("product has several batches in this stocktake; send the batch number")
+	ErrInvalidStocktakeCount = errors.New("count needs a line or a barcode and a non-negative quantity")
+)
+
+// IsStocktakeError التحقق مما إذا كان الخطأ من أخطاء الجرد
+func IsStocktakeError(err error) bool {
+	return errors.Is(err, ErrStocktakeNotFound) ||
+		errors.Is(err, ErrStocktakeState) ||
+		errors.Is(err, ErrStocktakeOverlap) ||
+		errors.Is(err, ErrStocktakeEmpty) ||
+		errors.Is(err, ErrStocktakeLineNotFound) ||
+		errors.Is(err, ErrStocktakeAmbiguous) ||
+		errors.Is(err, ErrInvalidStocktakeCount)
+}
+
+// StocktakeCount كمية معدودة لسطر محدد أو لمنتج ممسوح بالباركود
+// المسح بالباركود يُضاف عادة إلى العد السابق (Add) حتى يمكن مسح العبوات واحدة واحدة.
+type StocktakeCount struct {
+	LineID      *uuid.UUID
+	Barcode     string
+	BatchNumber string
+	Quantity    int
+	Add         bool
+	ReasonCode  models.AdjustmentReason
+}
+
+// StocktakeSummary ملخص فروقات الجرد
+type StocktakeSummary struct {
+	Lines          int     `json:"lines"`
+	CountedLines   int     `json:"counted_lines"`
+	VarianceLines  int     `json:"variance_lines"`
+	ShortageUnits  int     `json:"shortage_units"`
+	SurplusUnits   int     `json:"surplus_units"`
+	ShortageValue  float64 `json:"shortage_value"`
+	SurplusValue   float64 `json:"surplus_value"`
+	NetValueChange float64 `json:"net_value_change"`
+}
+
+// SummarizeStocktake حساب ملخص الفروقات من أسطر الجرد المعدودة
+func SummarizeStocktake(lines []models.StocktakeLine) StocktakeSummary {
+	summary := StocktakeSummary{Lines: len(lines)}
+	for _, line := range lines {
+		if line.CountedQuantity == nil {
+			continue
+		}
+		summary.CountedLines++
+		variance := *line.CountedQuantity - line.ExpectedQuantity
+		if variance == 0 {
+			continue
+		}
+		summary.VarianceLines++
+		value := line.UnitCost * float64(variance)
+		if variance < 0 {
+			summary.ShortageUnits -= variance
+			summary.ShortageValue -= value
+		} else {
+			summary.SurplusUnits += variance
+			summary.SurplusValue += value
+		}
+	}
+	summary.ShortageValue = RoundMoney(summary.ShortageValue)
+	summary.SurplusValue = RoundMoney(summary.SurplusValue)
+	summary.NetValueChange = RoundMoney(summary.SurplusValue - summary.ShortageValue)
+	return summary
+}
+
+// CreateStocktake فتح جلسة جرد والتقاط الكميات المتوقعة لكل دفعة في النطاق
+// المنتج غير المتتبع بالدفعات يُجرد بسطر واحد لعداده؛ الدفعات المنتهية تُجرد أيضاً لأنها ما زالت على الرف.
+func CreateStocktake(tx *gorm.DB, categoryID *uuid.UUID, notes string, actorID *uuid.UUID) (*models.Stocktake, error) {
+	overlap := tx.Model(&models.Stocktake{}).Where("status IN ?", []models.StocktakeStatus{models.StocktakeOpen, models.StocktakeSubmitted})
+	if categoryID != nil {
+		overlap = overlap.Where("category_id IS NULL OR category_id = ?", *categoryID)
+	}
+	var open int64
+	if err := overlap.Count(&open).Error; err != nil {
+		return nil, err
+	}
+	if open > 0 {
+		return nil, ErrStocktakeOverlap
+	}
+
+	productQuery := tx.Model(&models.Product{}).Order("name ASC")
+	if categoryID != nil {
+		productQuery = productQuery.Where("category_id = ?", *categoryID)
+	}
+	var products []models.Product
+	if err := productQuery.Find(&products).Error; err != nil {
+		return nil, err
+	}
+	if len(products) == 0 {
+		return nil, ErrStocktakeEmpty
+	}
+
+	productIDs := make([]uuid.UUID, 0, len(products))
+	for _, product := range products {
+		productIDs = append(productIDs, product.ID)
+	}
+	var batches []models.ProductBatch
+	if err := tx.Where("product_id IN ?", productIDs).Order("expiry_date ASC NULLS LAST, received_at ASC").Find(&batches).Error; err != nil {
+		return nil, err
+	}
+	batchesByProduct := map[uuid.UUID][]models.ProductBatch{}
+	for _, batch := range batches {
+		batchesByProduct[batch.ProductID] = append(batchesByProduct[batch.ProductID], batch)
+	}
+
+	now := time.Now()
+	stocktake := &models.Stocktake{
+		CategoryID: categoryID,
+		Status:     models.StocktakeOpen,
+		Notes:      strings.TrimSpace(notes),
+		CreatedBy:  actorID,
+	}
+	for _, product := range products {
+		productBatches, tracked := batchesByProduct[product.ID]
+		if !tracked {
+			cost, err := lastPurchaseCost(tx, product.ID)
+			if err != nil {
+				return nil, err
+			}
+			stocktake.Lines = append(stocktake.Lines, models.StocktakeLine{
+				ProductID:        product.ID,
+				ExpectedQuantity: product.StockQuantity,
+				UnitCost:         cost,
+			})
+			continue
+		}
+		for _, batch := range productBatches {
+			if batch.Quantity == 0 {
+				continue
+			}
+			batchID := batch.ID
+			stocktake.Lines = append(stocktake.Lines, models.StocktakeLine{
+				ProductID:        product.ID,
+				BatchID:          &batchID,
+				BatchNumber:      batch.BatchNumber,
+				ExpiryDate:       batch.ExpiryDate,
+				ExpectedQuantity: batch.Quantity,
+				UnitCost:         batch.UnitCost,
+			})
+		}
+	}
+
+	number, err := nextDocumentNumber(tx, DocumentStocktake, "STOCKTAKE_PREFIX", "STK", now)
+	if err != nil {
+		return nil, err
+	}
+	stocktake.Number = number
+	if err := tx.Create(stocktake).Error; err != nil {
+		return nil, fmt.Errorf("create stocktake: %w", err)
+	}
+	return stocktake, nil
+}
+
+// lockStocktake قفل الجلسة والتحقق من حالتها ثم تحميل أسطرها
+func lockStocktake(tx *gorm.DB, id uuid.UUID, statuses ...models.StocktakeStatus) (*models.Stocktake, error) {
+	var stocktake models.Stocktake
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&stocktake, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrStocktakeNotFound
+		}
+		return nil, err
+	}
+	allowed := false
+	for _, status := range statuses {
+		allowed = allowed || stocktake.Status == status
+	}
+	if !allowed {
+		return nil, fmt.Errorf("%w: stocktake is %s", ErrStocktakeState, stocktake.Status)
+	}
+	if err := tx.Find(&stocktake.Lines, "stocktake_id = ?", stocktake.ID).Error; err != nil {
+		return nil, err
+	}
+	return &stocktake, nil
+}
+
+// findStocktakeLine تحديد سطر العد من معرفه أو من المنتج الممسوح ورقم الدفعة
+// يعيد -1 مع ErrStocktakeLineNotFound إذا كان المنتج في النطاق لكن دفعته ليست في الجلسة.
+func findStocktakeLine(lines []models.StocktakeLine, count StocktakeCount, productID uuid.UUID) (int, error) {
+	if count.LineID != nil {
+		for i := range lines {
+			if lines[i].ID == *count.LineID {
+				return i, nil
+			}
+		}
+		return -1, ErrStocktakeLineNotFound
+	}
+
+	batchNumber := strings.TrimSpace(count.BatchNumber)
+	match := -1
+	for i := range lines {
+		if lines[i].ProductID != productID {
+			continue
+		}
+		if batchNumber != "" {
+			if strings.EqualFold(lines[i].BatchNumber, batchNumber) {
+				return i, nil
+			}
+			continue
+		}
+		if match >= 0 {
+			return -1, ErrStocktakeAmbiguous
+		}
+		match = i
+	}
+	if match < 0 {
+		return -1, ErrStocktakeLineNotFound
+	}
+	return match, nil
+}
+
+// RecordStocktakeCounts تسجيل كميات معدودة في جلسة مفتوحة
+// دفعة ممسوحة ليست في اللقطة (نفدت عند الفتح ثم وُجدت) تُضاف كسطر متوقعه صفر.
+func RecordStocktakeCounts(tx *gorm.DB, id uuid.UUID, counts []StocktakeCount, actorID *uuid.UUID) (*models.Stocktake, error) {
+	stocktake, err := lockStocktake(tx, id, models.StocktakeOpen)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	changed := map[int]bool{}
+	for n, count := range counts {
+		if count.Quantity < 0 || (count.LineID == nil && strings.TrimSpace(count.Barcode) == "") ||
+			(count.ReasonCode != "" && !count.ReasonCode.IsValid()) {
+			return nil, fmt.Errorf("%w: count %d", ErrInvalidStocktakeCount, n+1)
+		}
+
+		var productID uuid.UUID
+		if count.LineID == nil {
+			code := strings.TrimSpace(count.Barcode)
+			var product models.Product
+			if err := tx.Select("id").Where("barcode = ? OR sku = ?", code, code).First(&product).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return nil, fmt.Errorf("count %d (%s): %w", n+1, code, ErrStocktakeLineNotFound)
+				}
+				return nil, err
+			}
+			productID = product.ID
+		}
+
+		index, err := findStocktakeLine(stocktake.Lines, count, productID)
+		if errors.Is(err, ErrStocktakeLineNotFound) && count.LineID == nil && count.BatchNumber != "" {
+			index, err = addFoundBatchLine(tx, stocktake, productID, strings.TrimSpace(count.BatchNumber))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("count %d: %w", n+1, err)
+		}
+
+		line := &stocktake.Lines[index]
+		quantity := count.Quantity
+		if count.Add && line.CountedQuantity != nil {
+			quantity += *line.CountedQuantity
+		}
+		line.CountedQuantity = &quantity
+		line.Variance = quantity - line.ExpectedQuantity
+		if count.ReasonCode != "" {
+			line.ReasonCode = count.ReasonCode
+		}
+		line.CountedBy = actorID
+		line.CountedAt = &now
+		changed[index] = true
+	}
+
+	for index := range changed {
+		line := &stocktake.Lines[index]
+		if err := tx.Model(line).Updates(map[string]interface{}{
+			"counted_quantity": line.CountedQuantity,
+			"variance":         line.Variance,
+			"reason_code":      line.ReasonCode,
+			"counted_by":       line.CountedBy,
+			"counted_at":       line.CountedAt,
+		}).Error; err != nil {
+			return nil, err
+		}
+	}
+	return stocktake, tx.Model(stocktake).Update("updated_at", now).Error
+}
+
+// addFoundBatchLine إضافة سطر لدفعة معروفة من منتج في نطاق الجلسة لم تكن في اللقطة
+func addFoundBatchLine(tx *gorm.DB, stocktake *models.Stocktake, productID uuid.UUID, batchNumber string) (int, error) {
+	inScope := false
+	for _, line := range stocktake.Lines {
+		inScope = inScope || line.ProductID == productID
+	}
+	if !inScope {
+		return -1, ErrStocktakeLineNotFound
+	}
+	var batch models.ProductBatch
+	if err := tx.Where("product_id = ? AND batch_number ILIKE ?", productID, batchNumber).
+		Order("received_at DESC").First(&batch).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return -1, ErrStocktakeLineNotFound
+		}
+		return -1, err
+	}
+	line := models.StocktakeLine{
+		StocktakeID:      stocktake.ID,
+		ProductID:        productID,
+		BatchID:          &batch.ID,
+		BatchNumber:      batch.BatchNumber,
+		ExpiryDate:       batch.ExpiryDate,
+		ExpectedQuantity: batch.Quantity,
+		UnitCost:         batch.UnitCost,
+	}
+	if err := tx.Create(&line).Error; err != nil {
+		return -1, err
+	}
+	stocktake.Lines = append(stocktake.Lines, line)
+	return len(stocktake.Lines) - 1, nil
+}
+
+// SubmitStocktake إنهاء العد وإرسال الفروقات للاعتماد
+// الأسطر غير المعدودة تُهمل إلا إذا طُلب اعتبارها صفراً (عد كامل للرفوف).
+func SubmitStocktake(tx *gorm.DB, id uuid.UUID, zeroUncounted bool, actorID *uuid.UUID) (*models.Stocktake, error) {
+	stocktake, err := lockStocktake(tx, id, models.StocktakeOpen)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if zeroUncounted {
+		zero := 0
+		for i := range stocktake.Lines {
+			line := &stocktake.Lines[i]
+			if line.CountedQuantity != nil {
+				continue
+			}
+			line.CountedQuantity = &zero
+			line.Variance = -line.ExpectedQuantity
+			line.CountedBy = actorID
+			line.CountedAt = &now
+			if err := tx.Model(line).Updates(map[string]interface{}{
+				"counted_quantity": 0,
+				"variance":         line.Variance,
+				"counted_by":       actorID,
+				"counted_at":       now,
+			}).Error; err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	stocktake.Status = models.StocktakeSubmitted
+	stocktake.SubmittedBy = actorID
+	stocktake.SubmittedAt = &now
+	return stocktake, tx.Model(stocktake).Updates(map[string]interface{}{
+		"status":       stocktake.Status,
+		"submitted_by": actorID,
+		"submitted_at": now,
+		"updated_at":   now,
+	}).Error
+}
+
+// ReopenStocktake إعادة جلسة مرسلة للعد لتصحيح أسطر قبل الاعتماد
+func ReopenStocktake(tx *gorm.DB, id uuid.UUID) (*models.Stocktake, error) {
+	stocktake, err := lockStocktake(tx, id, models.StocktakeSubmitted)
+	if err != nil {
+		return nil, err
+	}
+	stocktake.Status = models.StocktakeOpen
+	return stocktake, tx.Model(stocktake).Updates(map[string]interface{}{
+		"status":       stocktake.Status,
+		"submitted_by": nil,
+		"submitted_at": nil,
+		"updated_at":   time.Now(),
+	}).Error
+}
+
+// CancelStocktake إلغاء جلسة لم تُعتمد دون أي أثر على المخزون
+func CancelStocktake(tx *gorm.DB, id uuid.UUID) (*models.Stocktake, error) {
+	stocktake, err := lockStocktake(tx, id, models.StocktakeOpen, models.StocktakeSubmitted)
+	if err != nil {
+		return nil, err
+	}
+	stocktake.Status = models.StocktakeCancelled
+	return stocktake, tx.Model(stocktake).Updates(map[string]interface{}{
+		"status":     stocktake.Status,
+		"updated_at": time.Now(),
+	}).Error
+}
+
+// ApproveStocktake اعتماد الفروقات وتسجيل كل منها كحركة تسوية بسببها
+// الفرق يُطبق على الكمية الحالية، والنقص الذي يتجاوزها (بيعت أثناء العد) يُقص عند الصفر.
+func ApproveStocktake(tx *gorm.DB, id uuid.UUID, actorID *uuid.UUID) (*models.Stocktake, error) {
+	stocktake, err := lockStocktake(tx, id, models.StocktakeSubmitted)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range stocktake.Lines {
+		line := &stocktake.Lines[i]
+		if line.CountedQuantity == nil || line.Variance == 0 {
+			continue
+		}
+		product, err := lockProduct(tx, line.ProductID)
+		if err != nil {
+			if errors.Is(err, ErrProductNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		current := product.StockQuantity
+		if line.BatchID != nil {
+			var batch models.ProductBatch
+			if err := tx.Select("id", "quantity").First(&batch, "id = ?", *line.BatchID).Error; err != nil {
+				return nil, err
+			}
+			current = batch.Quantity
+		}
+		delta := line.Variance
+		if current+delta < 0 {
+			delta = -current
+		}
+		if delta == 0 {
+			continue
+		}
+
+		reason := line.ReasonCode
+		if reason == "" {
+			reason = models.AdjustmentReasonCountCorrection
+		}
+		if _, err := AdjustStock(tx, StockAdjustment{
+			ProductID:       line.ProductID,
+			BatchID:         line.BatchID,
+			Quantity:        delta,
+			Reason:          reason,
+			ReferenceNumber: stocktake.Number,
+			Notes:           fmt.Sprintf("جرد %s: المتوقع %d والمعدود %d", stocktake.Number, line.ExpectedQuantity, *line.CountedQuantity),
+			ActorID:         actorID,
+		}); err != nil {
+			return nil, fmt.Errorf("apply stocktake line %s: %w", line.ID, err)
+		}
+		line.AdjustedQuantity = delta
+		if err := tx.Model(line).Update("adjusted_quantity", delta).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	stocktake.Status = models.StocktakeApproved
+	stocktake.ApprovedBy = actorID
+	stocktake.ApprovedAt = &now
+	return stocktake, tx.Model(stocktake).Updates(map[string]interface{}{
+		"status":      stocktake.Status,
+		"approved_by": actorID,
+		"approved_at": now,
+		"updated_at":  now,
+	}).Error
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"pharmacy-backend/models"
+)
+
+func TestSummarizeStocktake(t *testing.T) {
+	counted := func(n int) *int { return &n }
+	lines := []models.StocktakeLine{
+		{ExpectedQuantity: 10, CountedQuantity: counted(7), UnitCost: 2.5},
+		{ExpectedQuantity: 4, CountedQuantity: counted(6), UnitCost: 1.25},
+		{ExpectedQuantity: 5, CountedQuantity: counted(5), UnitCost: 3},
+		{ExpectedQuantity: 8, UnitCost: 9},
+	}
+
+	summary := SummarizeStocktake(lines)
+	assert.Equal(t, 4, summary.Lines)
+	assert.Equal(t, 3, summary.CountedLines)
+	assert.Equal(t, 2, summary.VarianceLines)
+	assert.Equal(t, 3, summary.ShortageUnits)
+	assert.Equal(t, 2, summary.SurplusUnits)
+	assert.Equal(t, 7.5, summary.ShortageValue)
+	assert.Equal(t, 2.5, summary.SurplusValue)
+	assert.Equal(t, -5.0, summary.NetValueChange)
+}
+
+func TestFindStocktakeLine(t *testing.T) {
+	single, multi, other := uuid.New(), uuid.New(), uuid.New()
+	lines := []models.StocktakeLine{
+		{ID: uuid.New(), ProductID: single},
+		{ID: uuid.New(), ProductID: multi, BatchNumber: "A-1"},
+		{ID: uuid.New(), ProductID: multi, BatchNumber: "B-2"},
+	}
+
+	index, err := findStocktakeLine(lines, StocktakeCount{LineID: &lines[2].ID}, uuid.Nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, index)
+
+	// منتج بسطر واحد يكفيه الباركود
+	index, err = findStocktakeLine(lines, StocktakeCount{Barcode: "x"}, single)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, index)
+
+	// منتج بعدة دفعات يحتاج رقم الدفعة، والمطابقة لا تتأثر بحالة الأحرف
+	_, err = findStocktakeLine(lines, StocktakeCount{Barcode: "x"}, multi)
+	assert.ErrorIs(t, err, ErrStocktakeAmbiguous)
+	index, err = findStocktakeLine(lines, StocktakeCount{Barcode: "x", BatchNumber: " b-2 "}, multi)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, index)
+
+	_, err = findStocktakeLine(lines, StocktakeCount{Barcode: "x", BatchNumber: "C-3"}, multi)
+	assert.ErrorIs(t, err, ErrStocktakeLineNotFound)
+	_, err = findStocktakeLine(lines, StocktakeCount{Barcode: "x"}, other)
+	assert.ErrorIs(t, err, ErrStocktakeLineNotFound)
+	missing := uuid.New()
+	_, err = findStocktakeLine(lines, StocktakeCount{LineID: &missing}, uuid.Nil)
+	assert.ErrorIs(t, err, ErrStocktakeLineNotFound)
+}
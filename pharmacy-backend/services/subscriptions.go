@@ -0,0 +1,656 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"pharmacy-backend/models"
+)
+
+// أخطاء الاشتراكات التي تُعاد للعميل كطلب غير صالح
+var (
+	ErrSubscriptionEmpty        = errors.New("subscription has no items")
+	ErrSubscriptionInterval     = errors.New("subscription interval is out of the allowed range")
+	ErrSubscriptionNotifyDays   = errors.New("reminder days must be between 0 and the subscription interval")
+	ErrSubscriptionNextRun      = errors.New("next run date must be in the future")
+	ErrSubscriptionInvalidState = errors.New("subscription is not in a valid state for this action")
+	ErrSubscriptionAddress      = errors.New("subscription requires a shipping address")
+)
+
+// IsSubscriptionError التحقق مما إذا كان الخطأ ناتجاً عن اشتراك غير صالح
+func IsSubscriptionError(err error) bool {
+	return errors.Is(err, ErrSubscriptionEmpty) ||
+		errors.Is(err, ErrSubscriptionInterval) ||
+		errors.Is(err, ErrSubscriptionNotifyDays) ||
+		errors.Is(err, ErrSubscriptionNextRun) ||
+		errors.Is(err, ErrSubscriptionInvalidState) ||
+		errors.Is(err, ErrSubscriptionAddress) ||
+		errors.Is(err, ErrPrescriptionRequired)
+}
+
+// أسباب إضافية لمشاكل الاشتراك قبل موعده (إلى جانب أسباب إعادة الطلب)
+const (
+	SubscriptionIssuePrescriptionMissing  ReorderReason = "prescription_missing"
+	SubscriptionIssuePrescriptionExpired  ReorderReason = "prescription_expired"
+	SubscriptionIssuePrescriptionRejected ReorderReason = "prescription_rejected"
+	SubscriptionIssuePrescriptionNoFills  ReorderReason = "prescription_no_refills"
+)
+
+// الحد الأقصى لأيام التذكير قبل موعد الاشتراك
+const maxSubscriptionNotifyDays = 14
+
+// SubscriptionLineInput منتج وكمية كما أرسلهما العميل
+type SubscriptionLineInput struct {
+	ProductID uuid.UUID `json:"product_id" binding:"required"`
+	Quantity  int       `json:"quantity" binding:"required,min=1"`
+}
+
+// SubscriptionInput حقول إنشاء الاشتراك أو تعديله (nil يعني عدم التغيير)
+type SubscriptionInput struct {
+	Items            []SubscriptionLineInput `json:"items"`
+	IntervalDays     *int                    `json:"interval_days"`
+	NextRunAt        *time.Time              `json:"next_run_at"`
+	NotifyDaysBefore *int                    `json:"notify_days_before"`
+	PaymentMethod    *string                 `json:"payment_method"`
+	ShippingAddress  *models.Address         `json:"shipping_address"`
+	Notes            *string                 `json:"notes"`
+	PrescriptionID   *uuid.UUID              `json:"prescription_id"`
+}
+
+// SubscriptionIssue مشكلة تمنع تنفيذ الدورة القادمة كما هي
+type SubscriptionIssue struct {
+	ProductID *uuid.UUID    `json:"product_id,omitempty"`
+	Name      string        `json:"name,omitempty"`
+	Reason    ReorderReason `json:"reason"`
+	Requested int           `json:"requested,omitempty"`
+	Available int           `json:"available,omitempty"`
+}
+
+// SubscriptionIntervalBounds الحد الأدنى والأقصى لعدد أيام دورة الاشتراك
+func SubscriptionIntervalBounds() (int, int) {
+	return int(envFloat("SUBSCRIPTION_MIN_INTERVAL_DAYS", 7)), int(envFloat("SUBSCRIPTION_MAX_INTERVAL_DAYS", 180))
+}
+
+// NextRunAfter موعد الدورة التالية بعد from، مع تخطي الدورات التي فات موعدها
+func NextRunAfter(from time.Time, intervalDays int, now time.Time) time.Time {
+	next := from.AddDate(0, 0, intervalDays)
+	for !next.After(now) {
+		next = next.AddDate(0, 0, intervalDays)
+	}
+	return next
+}
+
+// ReminderDue التحقق مما إذا حان وقت تذكير العميل بالدورة القادمة ولم يُرسل بعد
+func ReminderDue(sub *models.Subscription, now time.Time) bool {
+	if !sub.IsActive() || sub.NotifyDaysBefore <= 0 || !sub.NextRunAt.After(now) {
+		return false
+	}
+	if sub.NextRunAt.After(now.AddDate(0, 0, sub.NotifyDaysBefore)) {
+		return false
+	}
+	return sub.ReminderSentFor == nil || !sub.ReminderSentFor.Equal(sub.NextRunAt)
+}
+
+// subscriptionPrescriptionIssue مشكلة الوصفة الطبية للاشتراك عند الموعد at، أو فارغ إذا كانت صالحة
+func subscriptionPrescriptionIssue(db *gorm.DB, sub *models.Subscription, at time.Time) (ReorderReason, error) {
+	if sub.PrescriptionID == nil {
+		return SubscriptionIssuePrescriptionMissing, nil
+	}
+	var prescription models.Prescription
+	err := db.Where("id = ? AND user_id = ?", *sub.PrescriptionID, sub.UserID).First(&prescription).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return SubscriptionIssuePrescriptionMissing, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	switch err := CheckPrescriptionUsable(&prescription, at); {
+	case errors.Is(err, ErrPrescriptionRejected):
+		return SubscriptionIssuePrescriptionRejected, nil
+	case errors.Is(err, ErrPrescriptionExpired):
+		return SubscriptionIssuePrescriptionExpired, nil
+	case errors.Is(err, ErrPrescriptionNoFills):
+		return SubscriptionIssuePrescriptionNoFills, nil
+	}
+	return "", nil
+}
+
+// checkSubscriptionPrescription إعادة ErrPrescriptionRequired مع السبب إذا لم تكن الوصفة صالحة للموعد at
+func checkSubscriptionPrescription(db *gorm.DB, sub *models.Subscription, at time.Time) error {
+	issue, err := subscriptionPrescriptionIssue(db, sub, at)
+	if err != nil {
+		return err
+	}
+	if issue != "" {
+		return fmt.Errorf("%w: %s", ErrPrescriptionRequired, issue)
+	}
+	return nil
+}
+
+// CreateSubscription إنشاء اشتراك جديد بعد التحقق من المنتجات والوصفة
+func CreateSubscription(tx *gorm.DB, user *models.User, input SubscriptionInput, now time.Time) (*models.Subscription, error) {
+	if input.IntervalDays == nil {
+		return nil, ErrSubscriptionInterval
+	}
+	sub := &models.Subscription{
+		UserID:           user.ID,
+		Status:           models.SubscriptionStatusActive,
+		IntervalDays:     *input.IntervalDays,
+		NotifyDaysBefore: int(envFloat("SUBSCRIPTION_NOTIFY_DAYS", 3)),
+		NextRunAt:        now.AddDate(0, 0, *input.IntervalDays),
+		PaymentMethod:    "cash_on_delivery",
+	}
+	if sub.NotifyDaysBefore >= sub.IntervalDays {
+		sub.NotifyDaysBefore = sub.IntervalDays - 1
+	}
+	if err := applySubscriptionInput(tx, user, sub, input, now); err != nil {
+		return nil, err
+	}
+	if len(sub.Items) == 0 {
+		return nil, ErrSubscriptionEmpty
+	}
+
+	items := sub.Items
+	sub.Items = nil
+	if err := tx.Omit(clause.Associations).Create(sub).Error; err != nil {
+		return nil, err
+	}
+	for i := range items {
+		items[i].SubscriptionID = sub.ID
+	}
+	if err := tx.Omit(clause.Associations).Create(&items).Error; err != nil {
+		return nil, fmt.Errorf("create subscription items: %w", err)
+	}
+	sub.Items = items
+	return sub, nil
+}
+
+// UpdateSubscription تعديل اشتراك قائم؛ استبدال المنتجات إذا أُرسلت
+func UpdateSubscription(tx *gorm.DB, user *models.User, sub *models.Subscription, input SubscriptionInput, now time.Time) error {
+	if sub.Status == models.SubscriptionStatusCancelled {
+		return ErrSubscriptionInvalidState
+	}
+	replaceItems := input.Items != nil
+	if err := applySubscriptionInput(tx, user, sub, input, now); err != nil {
+		return err
+	}
+
+	if replaceItems {
+		if len(sub.Items) == 0 {
+			return ErrSubscriptionEmpty
+		}
+		if err := tx.Where("subscription_id = ?", sub.ID).Delete(&models.SubscriptionItem{}).Error; err != nil {
+			return err
+		}
+		for i := range sub.Items {
+			sub.Items[i].SubscriptionID = sub.ID
+		}
+		if err := tx.Omit(clause.Associations).Create(&sub.Items).Error; err != nil {
+			return fmt.Errorf("create subscription items: %w", err)
+		}
+	}
+
+	items := sub.Items
+	sub.Items = nil
+	err := tx.Omit(clause.Associations).Save(sub).Error
+	sub.Items = items
+	return err
+}
+
+// applySubscriptionInput تطبيق الحقول المرسلة على الاشتراك والتحقق منها
+func applySubscriptionInput(tx *gorm.DB, user *models.User, sub *models.Subscription, input SubscriptionInput, now time.Time) error {
+	if input.IntervalDays != nil {
+		minDays, maxDays := SubscriptionIntervalBounds()
+		if *input.IntervalDays < minDays || *input.IntervalDays > maxDays {
+			return fmt.Errorf("%w: %d-%d days", ErrSubscriptionInterval, minDays, maxDays)
+		}
+		sub.IntervalDays = *input.IntervalDays
+	}
+	if input.NextRunAt != nil {
+		if !input.NextRunAt.After(now) {
+			return ErrSubscriptionNextRun
+		}
+		sub.NextRunAt = *input.NextRunAt
+	}
+	if input.NotifyDaysBefore != nil {
+		sub.NotifyDaysBefore = *input.NotifyDaysBefore
+	}
+	if sub.NotifyDaysBefore < 0 || sub.NotifyDaysBefore > maxSubscriptionNotifyDays || sub.NotifyDaysBefore >= sub.IntervalDays {
+		return ErrSubscriptionNotifyDays
+	}
+	if input.PaymentMethod != nil && strings.TrimSpace(*input.PaymentMethod) != "" {
+		sub.PaymentMethod = strings.TrimSpace(*input.PaymentMethod)
+	}
+	if input.ShippingAddress != nil {
+		sub.ShippingAddress = *input.ShippingAddress
+	}
+	addr := sub.ShippingAddress
+	if strings.TrimSpace(addr.AddressLine1+addr.Address+addr.Street+addr.StreetName) == "" {
+		return ErrSubscriptionAddress
+	}
+	if input.Notes != nil {
+		sub.Notes = strings.TrimSpace(*input.Notes)
+	}
+	if input.PrescriptionID != nil {
+		sub.PrescriptionID = input.PrescriptionID
+	}
+
+	if input.Items != nil {
+		items, err := buildSubscriptionItems(tx, user, input.Items)
+		if err != nil {
+			return err
+		}
+		sub.Items = items
+	} else if sub.Items == nil {
+		if err := tx.Preload("Product").Where("subscription_id = ?", sub.ID).Find(&sub.Items).Error; err != nil {
+			return err
+		}
+	}
+
+	// منتجات الوصفة تتطلب وصفة صالحة حتى موعد الدورة القادمة
+	if subscriptionRequiresPrescription(sub.Items) {
+		return checkSubscriptionPrescription(tx, sub, sub.NextRunAt)
+	}
+	return nil
+}
+
+// buildSubscriptionItems التحقق من المنتجات بنفس قواعد إنشاء الطلب وتجميع المكرر منها
+func buildSubscriptionItems(db *gorm.DB, user *models.User, lines []SubscriptionLineInput) ([]models.SubscriptionItem, error) {
+	orderLines := make([]OrderLineInput, 0, len(lines))
+	for _, line := range lines {
+		orderLines = append(orderLines, OrderLineInput{ProductID: line.ProductID, Quantity: line.Quantity})
+	}
+	quote, err := QuoteOrder(db, user, orderLines, LoadPricingSettings())
+	if err != nil {
+		return nil, err
+	}
+
+	items := []models.SubscriptionItem{}
+	index := map[uuid.UUID]int{}
+	for _, line := range quote.Lines {
+		if i, ok := index[line.ProductID]; ok {
+			items[i].Quantity += line.Quantity
+			continue
+		}
+		index[line.ProductID] = len(items)
+		items = append(items, models.SubscriptionItem{ProductID: line.ProductID, Quantity: line.Quantity, Product: line.Product})
+	}
+	return items, nil
+}
+
+func subscriptionRequiresPrescription(items []models.SubscriptionItem) bool {
+	for _, item := range items {
+		if item.Product.RequiresPrescription {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckSubscription فحص إتاحة المنتجات والمخزون والوصفة للدورة القادمة دون حجز شيء
+func CheckSubscription(db *gorm.DB, user *models.User, sub *models.Subscription) ([]SubscriptionIssue, error) {
+	var items []models.SubscriptionItem
+	if err := db.Where("subscription_id = ?", sub.ID).Find(&items).Error; err != nil {
+		return nil, err
+	}
+
+	issues := []SubscriptionIssue{}
+	requiresPrescription := false
+	for _, item := range items {
+		productID := item.ProductID
+		var product models.Product
+		if err := db.First(&product, "id = ?", item.ProductID).Error; err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, err
+			}
+			issues = append(issues, SubscriptionIssue{ProductID: &productID, Reason: ReorderReasonProductNotFound, Requested: item.Quantity})
+			continue
+		}
+		requiresPrescription = requiresPrescription || product.RequiresPrescription
+
+		issue := SubscriptionIssue{ProductID: &productID, Name: product.Name, Requested: item.Quantity, Available: product.StockQuantity}
+		if reason := reorderUnavailableReason(user, &product); reason != "" {
+			issue.Reason = reason
+			issues = append(issues, issue)
+		} else if product.StockQuantity < item.Quantity {
+			issue.Reason = ReorderReasonLimitedStock
+			if product.StockQuantity <= 0 {
+				issue.Reason = ReorderReasonOutOfStock
+			}
+			issues = append(issues, issue)
+		}
+	}
+
+	if requiresPrescription {
+		reason, err := subscriptionPrescriptionIssue(db, sub, sub.NextRunAt)
+		if err != nil {
+			return nil, err
+		}
+		if reason != "" {
+			issues = append(issues, SubscriptionIssue{Reason: reason})
+		}
+	}
+	return issues, nil
+}
+
+// SkipSubscriptionRun تخطي الدورة القادمة وتسجيلها كمتخطاة
+func SkipSubscriptionRun(tx *gorm.DB, sub *models.Subscription, now time.Time) error {
+	if !sub.IsActive() {
+		return ErrSubscriptionInvalidState
+	}
+	run := models.SubscriptionRun{
+		SubscriptionID: sub.ID,
+		ScheduledFor:   sub.NextRunAt,
+		Status:         models.SubscriptionRunSkipped,
+		Message:        "skipped by customer",
+	}
+	if err := tx.Create(&run).Error; err != nil {
+		return err
+	}
+	sub.NextRunAt = NextRunAfter(sub.NextRunAt, sub.IntervalDays, now)
+	return tx.Model(sub).Update("next_run_at", sub.NextRunAt).Error
+}
+
+// SetSubscriptionStatus إيقاف الاشتراك مؤقتاً أو استئنافه أو إلغاؤه
+// عند الاستئناف تُرحَّل الدورات التي فات موعدها أثناء الإيقاف مع الحفاظ على الإيقاع.
+func SetSubscriptionStatus(tx *gorm.DB, sub *models.Subscription, status models.SubscriptionStatus, now time.Time) error {
+	switch {
+	case sub.Status == models.SubscriptionStatusCancelled,
+		sub.Status == status,
+		status == models.SubscriptionStatusActive && sub.Status != models.SubscriptionStatusPaused:
+		return ErrSubscriptionInvalidState
+	}
+
+	updates := map[string]interface{}{"status": status}
+	if status == models.SubscriptionStatusActive && !sub.NextRunAt.After(now) {
+		sub.NextRunAt = NextRunAfter(sub.NextRunAt, sub.IntervalDays, now)
+		updates["next_run_at"] = sub.NextRunAt
+	}
+	result := tx.Model(&models.Subscription{}).
+		Where("id = ? AND status = ?", sub.ID, sub.Status).
+		Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrSubscriptionInvalidState
+	}
+	sub.Status = status
+	return nil
+}
+
+// SendSubscriptionReminders تذكير العملاء بالدورات القادمة قبل موعدها بعدد الأيام المحدد
+// يُرسل التذكير مرة واحدة لكل دورة، مع قائمة المشاكل المتوقعة (مخزون، وصفة) ليعدلوا أو يتخطوا.
+func SendSubscriptionReminders(db *gorm.DB, now time.Time) (int, error) {
+	var subs []models.Subscription
+	if err := db.Where("status = ? AND next_run_at > ? AND next_run_at <= ?",
+		models.SubscriptionStatusActive, now, now.AddDate(0, 0, maxSubscriptionNotifyDays)).
+		Find(&subs).Error; err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for i := range subs {
+		sub := &subs[i]
+		if !ReminderDue(sub, now) {
+			continue
+		}
+
+		// التحديث المشروط يمنع تكرار التذكير عند تشغيل أكثر من نسخة من الخادم
+		result := db.Model(&models.Subscription{}).
+			Where("id = ? AND next_run_at = ? AND (reminder_sent_for IS NULL OR reminder_sent_for <> next_run_at)", sub.ID, sub.NextRunAt).
+			Update("reminder_sent_for", sub.NextRunAt)
+		if result.Error != nil {
+			return sent, result.Error
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+
+		var user models.User
+		if err := db.First(&user, "id = ?", sub.UserID).Error; err != nil {
+			log.Printf("⚠️ تعذر تحميل مستخدم الاشتراك %s: %v", sub.ID, err)
+			continue
+		}
+		issues, err := CheckSubscription(db, &user, sub)
+		if err != nil {
+			log.Printf("⚠️ تعذر فحص الاشتراك %s: %v", sub.ID, err)
+		}
+
+		message := fmt.Sprintf("سيتم إنشاء طلب اشتراكك تلقائياً بتاريخ %s. يمكنك تعديل الاشتراك أو تخطي هذه الدورة قبل الموعد.", sub.NextRunAt.Format("2006-01-02"))
+		if len(issues) > 0 {
+			message += " يوجد في الاشتراك منتجات غير متوفرة أو وصفة تحتاج إلى تحديث، يرجى مراجعته."
+		}
+		notifySubscription(sub, models.NotificationTypeSubscriptionReminder, "تذكير بموعد اشتراكك", message, nil, map[string]interface{}{
+			"issues": issues,
+		})
+		sent++
+	}
+	return sent, nil
+}
+
+// subscriptionRunResult نتيجة تنفيذ دورة واحدة، تُستخدم للإشعارات بعد تأكيد المعاملة
+type subscriptionRunResult struct {
+	Subscription models.Subscription
+	Run          models.SubscriptionRun
+	Order        *models.Order
+	Quote        *OrderQuote
+}
+
+// ProcessDueSubscriptions إنشاء طلبات الاشتراكات التي حان موعدها عبر مسار إنشاء الطلب نفسه
+// كل اشتراك يُعالج في معاملة مستقلة ويُقفل بـ SKIP LOCKED حتى لا تتعارض نسخ الخادم المتعددة.
+func ProcessDueSubscriptions(db *gorm.DB, now time.Time) (int, error) {
+	var ids []uuid.UUID
+	if err := db.Model(&models.Subscription{}).
+		Where("status = ? AND next_run_at <= ?", models.SubscriptionStatusActive, now).
+		Order("next_run_at ASC").
+		Limit(100).
+		Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+
+	processed := 0
+	for _, id := range ids {
+		result, err := runSubscription(db, id, now)
+		if err != nil {
+			log.Printf("❌ فشل تنفيذ الاشتراك %s: %v", id, err)
+			continue
+		}
+		if result == nil {
+			continue
+		}
+		processed++
+
+		sub := &result.Subscription
+		if result.Order != nil {
+			NotifyOrderCreated(result.Order, result.Quote.IsWholesale)
+			notifySubscription(sub, models.NotificationTypeSubscriptionOrdered, "تم إنشاء طلب اشتراكك",
+				fmt.Sprintf("تم إنشاء الطلب %s من اشتراكك الدوري. موعد الدورة التالية %s.", result.Order.OrderNumber, sub.NextRunAt.Format("2006-01-02")),
+				&result.Order.ID, nil)
+		} else {
+			notifySubscription(sub, models.NotificationTypeSubscriptionFailed, "تعذر إنشاء طلب اشتراكك",
+				"تعذر إنشاء طلب اشتراكك الدوري لهذه الدورة، يرجى مراجعة الاشتراك أو الطلب يدوياً.",
+				nil, map[string]interface{}{"reason": result.Run.Message})
+		}
+	}
+	return processed, nil
+}
+
+// runSubscription تنفيذ الدورة المستحقة لاشتراك واحد داخل معاملة
+// يُعيد nil إذا لم يعد الاشتراك مستحقاً أو كان مقفلاً من عامل آخر.
+func runSubscription(db *gorm.DB, id uuid.UUID, now time.Time) (*subscriptionRunResult, error) {
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var sub models.Subscription
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("id = ? AND status = ? AND next_run_at <= ?", id, models.SubscriptionStatusActive, now).
+		First(&sub).Error
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	result := &subscriptionRunResult{
+		Run: models.SubscriptionRun{SubscriptionID: sub.ID, ScheduledFor: sub.NextRunAt},
+	}
+
+	// دورة سبق تنفيذها (مثلاً بعد إعادة تشغيل) لا تُنشئ طلباً ثانياً
+	var existing int64
+	if err := tx.Model(&models.SubscriptionRun{}).
+		Where("subscription_id = ? AND scheduled_for = ?", sub.ID, sub.NextRunAt).
+		Count(&existing).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if existing == 0 {
+		order, quote, runErr := placeSubscriptionOrder(tx, &sub)
+		if runErr != nil {
+			result.Run.Status = models.SubscriptionRunFailed
+			result.Run.Message = runErr.Error()
+			RecordQuantityLimitViolation(tx, sub.UserID, QuantityLimitSourceSubscription, runErr)
+		} else {
+			result.Run.Status = models.SubscriptionRunOrdered
+			result.Run.OrderID = &order.ID
+			result.Order, result.Quote = order, quote
+		}
+		if err := tx.Create(&result.Run).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	sub.NextRunAt = NextRunAfter(sub.NextRunAt, sub.IntervalDays, now)
+	sub.LastRunAt = &now
+	sub.LastError = result.Run.Message
+	if result.Order != nil {
+		sub.LastOrderID = &result.Order.ID
+	}
+	if err := tx.Model(&sub).Updates(map[string]interface{}{
+		"next_run_at":   sub.NextRunAt,
+		"last_run_at":   sub.LastRunAt,
+		"last_error":    sub.LastError,
+		"last_order_id": sub.LastOrderID,
+	}).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	if existing > 0 {
+		return nil, nil
+	}
+	result.Subscription = sub
+	return result, nil
+}
+
+// placeSubscriptionOrder إنشاء طلب الدورة داخل نقطة حفظ حتى يبقى سجل الفشل عند التراجع
+func placeSubscriptionOrder(tx *gorm.DB, sub *models.Subscription) (*models.Order, *OrderQuote, error) {
+	var user models.User
+	if err := tx.First(&user, "id = ?", sub.UserID).Error; err != nil {
+		return nil, nil, err
+	}
+	var items []models.SubscriptionItem
+	if err := tx.Preload("Product").Where("subscription_id = ?", sub.ID).Find(&items).Error; err != nil {
+		return nil, nil, err
+	}
+	if len(items) == 0 {
+		return nil, nil, ErrSubscriptionEmpty
+	}
+	if subscriptionRequiresPrescription(items) {
+		if err := checkSubscriptionPrescription(tx, sub, sub.NextRunAt); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// كل دورة تستهلك صرفة من الوصفة وتنتظر مراجعة الصيدلي كأي طلب آخر
+	lines := make([]OrderLineInput, 0, len(items))
+	for _, item := range items {
+		line := OrderLineInput{ProductID: item.ProductID, Quantity: item.Quantity}
+		if item.Product.RequiresPrescription {
+			line.PrescriptionID = sub.PrescriptionID
+		}
+		lines = append(lines, line)
+	}
+	notes := "طلب اشتراك دوري"
+	if sub.Notes != "" {
+		notes += " - " + sub.Notes
+	}
+
+	var order *models.Order
+	var quote *OrderQuote
+	err := tx.Transaction(func(inner *gorm.DB) error {
+		var err error
+		order, quote, err = PlaceOrder(inner, &user, PlaceOrderInput{
+			Lines:           lines,
+			PaymentMethod:   sub.PaymentMethod,
+			ShippingAddress: sub.ShippingAddress,
+			Notes:           notes,
+		})
+		return err
+	})
+	return order, quote, err
+}
+
+// notifySubscription إشعار مخزن لصاحب الاشتراك
+func notifySubscription(sub *models.Subscription, notificationType models.NotificationType, title, message string, orderID *uuid.UUID, extra map[string]interface{}) {
+	data := map[string]interface{}{
+		"subscription_id": sub.ID.String(),
+		"next_run_at":     sub.NextRunAt,
+	}
+	for k, v := range extra {
+		data[k] = v
+	}
+	if _, err := NewNotificationService().CreateNotification(sub.UserID, notificationType, title, message, data, orderID); err != nil {
+		log.Printf("⚠️ فشل في إنشاء إشعار الاشتراك: %v", err)
+	}
+}
+
+// StartSubscriptionScheduler تشغيل مجدول الاشتراكات في الخلفية
+// يرسل التذكيرات وينشئ الطلبات المستحقة كل SUBSCRIPTION_SCHEDULER_INTERVAL_MINUTES دقيقة.
+func StartSubscriptionScheduler(db *gorm.DB) {
+	if !envBool("SUBSCRIPTION_SCHEDULER_ENABLED", true) {
+		log.Println("⏸️ مجدول الاشتراكات معطل")
+		return
+	}
+	interval := time.Duration(envFloat("SUBSCRIPTION_SCHEDULER_INTERVAL_MINUTES", 15)) * time.Minute
+	if interval <= 0 {
+		interval = 15 * time.Minute
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			now := time.Now()
+			if n, err := SendSubscriptionReminders(db, now); err != nil {
+				log.Printf("❌ فشل إرسال تذكيرات الاشتراكات: %v", err)
+			} else if n > 0 {
+				log.Printf("🔔 تم إرسال %d تذكير اشتراك", n)
+			}
+			if n, err := ProcessDueSubscriptions(db, now); err != nil {
+				log.Printf("❌ فشل تنفيذ الاشتراكات المستحقة: %v", err)
+			} else if n > 0 {
+				log.Printf("🔁 تم تنفيذ %d اشتراك مستحق", n)
+			}
+			<-ticker.C
+		}
+	}()
+	log.Printf("✅ تم تشغيل مجدول الاشتراكات كل %s", interval)
+}
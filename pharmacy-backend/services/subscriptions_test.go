@@ -0,0 +1,41 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"pharmacy-backend/models"
+)
+
+func TestNextRunAfterSkipsMissedCycles(t *testing.T) {
+	from := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, from.AddDate(0, 0, 30), NextRunAfter(from, 30, from))
+	// بعد توقف الخادم أو إيقاف الاشتراك لأكثر من دورة يبقى الإيقاع كما هو
+	now := from.AddDate(0, 0, 75)
+	assert.Equal(t, from.AddDate(0, 0, 90), NextRunAfter(from, 30, now))
+}
+
+func TestReminderDue(t *testing.T) {
+	now := time.Date(2024, 1, 10, 9, 0, 0, 0, time.UTC)
+	sub := &models.Subscription{
+		Status:           models.SubscriptionStatusActive,
+		NotifyDaysBefore: 3,
+		NextRunAt:        now.AddDate(0, 0, 2),
+	}
+	assert.True(t, ReminderDue(sub, now))
+
+	sent := sub.NextRunAt
+	sub.ReminderSentFor = &sent
+	assert.False(t, ReminderDue(sub, now), "reminder is sent once per cycle")
+
+	sub.ReminderSentFor = nil
+	sub.NextRunAt = now.AddDate(0, 0, 5)
+	assert.False(t, ReminderDue(sub, now), "too early")
+
+	sub.NextRunAt = now.AddDate(0, 0, 1)
+	sub.Status = models.SubscriptionStatusPaused
+	assert.False(t, ReminderDue(sub, now))
+}
@@ -0,0 +1,430 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"pharmacy-backend/models"
+)
+
+// أخطاء حساب المورد
+var (
+	ErrSupplierInUse         = errors.New("supplier has invoices, ledger entries or products and cannot be deleted")
+	ErrInvalidSupplierEntry  = errors.New("supplier payment or return needs a positive amount and a date")
+	ErrInvalidSupplierReturn = errors.New("supplier return needs a reference and at least one line from this supplier's batches")
+)
+
+// IsSupplierLedgerError التحقق مما إذا كان الخطأ من أخطاء حساب المورد
+func IsSupplierLedgerError(err error) bool {
+	return errors.Is(err, ErrSupplierInUse) ||
+		errors.Is(err, ErrInvalidSupplierEntry) ||
+		errors.Is(err, ErrInvalidSupplierReturn) ||
+		errors.Is(err, ErrSupplierNotFound)
+}
+
+// RecordSupplierEntry إضافة قيد إلى دفتر المورد وتحديث رصيده بنفس المبلغ
+// الرصيد المخزن في المورد يبقى دائماً مجموع قيود دفتره.
+func RecordSupplierEntry(tx *gorm.DB, entry *models.SupplierLedgerEntry) error {
+	entry.Amount = RoundMoney(entry.Amount)
+	if entry.EntryDate.IsZero() {
+		entry.EntryDate = time.Now()
+	}
+	if err := adjustSupplierBalance(tx, entry.SupplierID, entry.Amount); err != nil {
+		return err
+	}
+	if err := tx.Create(entry).Error; err != nil {
+		return fmt.Errorf("record supplier %s entry: %w", entry.EntryType, err)
+	}
+	return nil
+}
+
+// adjustSupplierBalance تعديل رصيد المورد (المستحق له) بمقدار amount
+func adjustSupplierBalance(tx *gorm.DB, supplierID uuid.UUID, amount float64) error {
+	result := tx.Model(&models.Supplier{}).Where("id = ?", supplierID).
+		Updates(map[string]interface{}{
+			"balance":    gorm.Expr("balance + ?", RoundMoney(amount)),
+			"updated_at": time.Now(),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrSupplierNotFound
+	}
+	return nil
+}
+
+// DeleteSupplier حذف مورد لا سجل له؛ المورد الذي له تعاملات يُعطّل بدلاً من ذلك
+func DeleteSupplier(tx *gorm.DB, id uuid.UUID) error {
+	var supplier models.Supplier
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&supplier, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrSupplierNotFound
+		}
+		return err
+	}
+	for _, model := range []interface{}{&models.SupplierLedgerEntry{}, &models.PurchaseInvoice{}, &models.Product{}, &models.ProductBatch{}} {
+		var count int64
+		if err := tx.Model(model).Where("supplier_id = ?", id).Limit(1).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrSupplierInUse
+		}
+	}
+	return tx.Delete(&supplier).Error
+}
+
+// SupplierPaymentInput بيانات دفعة للمورد
+type SupplierPaymentInput struct {
+	SupplierID      uuid.UUID
+	Amount          float64
+	PaymentDate     time.Time
+	Method          string
+	ReferenceNumber string
+	Notes           string
+}
+
+// RecordSupplierPayment تسجيل دفعة تُنقص المستحق للمورد
+// الدفعة الزائدة تترك رصيداً سالباً (دفعة مقدمة) يُخصم من الفواتير التالية.
+func RecordSupplierPayment(tx *gorm.DB, input SupplierPaymentInput, actorID *uuid.UUID) (*models.SupplierLedgerEntry, error) {
+	if input.Amount <= 0 || input.PaymentDate.IsZero() {
+		return nil, ErrInvalidSupplierEntry
+	}
+	entry := &models.SupplierLedgerEntry{
+		SupplierID:      input.SupplierID,
+		EntryType:       models.SupplierEntryPayment,
+		EntryDate:       input.PaymentDate,
+		Amount:          -input.Amount,
+		ReferenceNumber: strings.TrimSpace(input.ReferenceNumber),
+		Description:     strings.TrimSpace(input.Notes),
+		PaymentMethod:   strings.TrimSpace(input.Method),
+		CreatedBy:       actorID,
+	}
+	if err := RecordSupplierEntry(tx, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// SupplierReturnLine كمية مرتجعة من دفعة استُلمت من المورد
+type SupplierReturnLine struct {
+	BatchID  uuid.UUID
+	Quantity int
+}
+
+// SupplierReturnInput بيانات مرتجع بضاعة للمورد
+// المرجع عادة رقم الإشعار الدائن الصادر من المورد.
+type SupplierReturnInput struct {
+	SupplierID      uuid.UUID
+	ReturnDate      time.Time
+	ReferenceNumber string
+	TaxAmount       float64
+	Reason          string
+	Lines           []SupplierReturnLine
+}
+
+// ReturnToSupplier إخراج كميات من دفعات المورد وإنقاص المستحق له بتكلفتها
+// كل سطر يُسجل حركة مرتجع سالبة بتكلفة الدفعة، والقيد بمجموعها مع الضريبة.
+func ReturnToSupplier(tx *gorm.DB, input SupplierReturnInput, actorID *uuid.UUID) (*models.SupplierLedgerEntry, error) {
+	reference := strings.TrimSpace(input.ReferenceNumber)
+	if reference == "" || len(input.Lines) == 0 || input.TaxAmount < 0 || input.ReturnDate.IsZero() {
+		return nil, ErrInvalidSupplierReturn
+	}
+
+	total := 0.0
+	reason := strings.TrimSpace(input.Reason)
+	for i, line := range input.Lines {
+		if line.Quantity < 1 {
+			return nil, fmt.Errorf("%w: line %d", ErrInvalidSupplierReturn, i+1)
+		}
+		var batch models.ProductBatch
+		if err := tx.First(&batch, "id = ?", line.BatchID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, fmt.Errorf("line %d: %w", i+1, ErrBatchNotFound)
+			}
+			return nil, err
+		}
+		if batch.SupplierID == nil || *batch.SupplierID != input.SupplierID {
+			return nil, fmt.Errorf("%w: batch %s was not received from this supplier", ErrInvalidSupplierReturn, batch.BatchNumber)
+		}
+		if _, err := lockProduct(tx, batch.ProductID); err != nil {
+			return nil, err
+		}
+		result := tx.Model(&models.ProductBatch{}).
+			Where("id = ? AND quantity >= ?", batch.ID, line.Quantity).
+			Update("quantity", gorm.Expr("quantity - ?", line.Quantity))
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil, fmt.Errorf("batch %s: %w", batch.BatchNumber, ErrInsufficientStock)
+		}
+
+		notes := fmt.Sprintf("مرتجع للمورد من الدفعة %s", batch.BatchNumber)
+		if reason != "" {
+			notes += ": " + reason
+		}
+		if _, err := RecordStockMovement(tx, StockMovement{
+			ProductID:       batch.ProductID,
+			Type:            models.TransactionTypeReturn,
+			Quantity:        -line.Quantity,
+			UnitPrice:       batch.UnitCost,
+			SupplierID:      &input.SupplierID,
+			ReferenceNumber: reference,
+			Notes:           notes,
+			ActorID:         actorID,
+		}); err != nil {
+			return nil, fmt.Errorf("supplier return for batch %s: %w", batch.BatchNumber, err)
+		}
+		if _, err := recordCostIssue(tx, models.InventoryCostEntry{
+			ProductID:       batch.ProductID,
+			BatchID:         &batch.ID,
+			Source:          models.CostSourceSupplierReturn,
+			ReferenceNumber: reference,
+		}, line.Quantity); err != nil {
+			return nil, err
+		}
+		if err := SyncProductStock(tx, batch.ProductID); err != nil {
+			return nil, err
+		}
+		total += RoundMoney(batch.UnitCost * float64(line.Quantity))
+	}
+
+	entry := &models.SupplierLedgerEntry{
+		SupplierID:      input.SupplierID,
+		EntryType:       models.SupplierEntryReturn,
+		EntryDate:       input.ReturnDate,
+		Amount:          -(total + input.TaxAmount),
+		ReferenceNumber: reference,
+		Description:     reason,
+		CreatedBy:       actorID,
+	}
+	if err := RecordSupplierEntry(tx, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// AgingBuckets أعمار المبالغ المستحقة للمورد بالأيام منذ تاريخ القيد
+type AgingBuckets struct {
+	Days0To30  float64 `json:"0_30"`
+	Days31To60 float64 `json:"31_60"`
+	Days61To90 float64 `json:"61_90"`
+	Over90     float64 `json:"90_plus"`
+	Total      float64 `json:"total"`
+}
+
+// add إضافة مبلغ إلى الفئة المناسبة لعمره
+func (b *AgingBuckets) add(amount float64, days int) {
+	switch {
+	case days <= 30:
+		b.Days0To30 += amount
+	case days <= 60:
+		b.Days31To60 += amount
+	case days <= 90:
+		b.Days61To90 += amount
+	default:
+		b.Over90 += amount
+	}
+	b.Total += amount
+}
+
+// round تقريب جميع الفئات
+func (b *AgingBuckets) round() {
+	b.Days0To30 = RoundMoney(b.Days0To30)
+	b.Days31To60 = RoundMoney(b.Days31To60)
+	b.Days61To90 = RoundMoney(b.Days61To90)
+	b.Over90 = RoundMoney(b.Over90)
+	b.Total = RoundMoney(b.Total)
+}
+
+// AgeSupplierEntries توزيع رصيد المورد في تاريخ معين على فئات العمر
+// إلغاء الفاتورة يُسقط فاتورته نفسها، وباقي الدفعات والمرتجعات تُسدد الأقدم أولاً.
+// ما يزيد من الدفعات على المستحق يظهر سالباً في الفئة الأحدث.
+func AgeSupplierEntries(entries []models.SupplierLedgerEntry, asOf time.Time) AgingBuckets {
+	type openItem struct {
+		date   time.Time
+		amount float64
+	}
+	var items []*openItem
+	byInvoice := map[uuid.UUID]*openItem{}
+	credit := 0.0
+	for _, entry := range entries {
+		if entry.EntryDate.After(asOf) {
+			continue
+		}
+		if entry.Amount > 0 {
+			item := &openItem{date: entry.EntryDate, amount: entry.Amount}
+			items = append(items, item)
+			if entry.PurchaseInvoiceID != nil {
+				byInvoice[*entry.PurchaseInvoiceID] = item
+			}
+			continue
+		}
+		credit -= entry.Amount
+	}
+	for _, entry := range entries {
+		if entry.EntryType != models.SupplierEntryInvoiceVoid || entry.PurchaseInvoiceID == nil || entry.EntryDate.After(asOf) {
+			continue
+		}
+		if item, ok := byInvoice[*entry.PurchaseInvoiceID]; ok {
+			cancelled := -entry.Amount
+			if cancelled > item.amount {
+				cancelled = item.amount
+			}
+			item.amount -= cancelled
+			credit -= cancelled
+		}
+	}
+
+	sort.SliceStable(items, func(i, j int) bool { return items[i].date.Before(items[j].date) })
+	var buckets AgingBuckets
+	for _, item := range items {
+		applied := item.amount
+		if applied > credit {
+			applied = credit
+		}
+		credit -= applied
+		if remaining := item.amount - applied; remaining > 0.004 {
+			buckets.add(remaining, int(asOf.Sub(item.date).Hours()/24))
+		}
+	}
+	if credit > 0.004 {
+		buckets.add(-credit, 0)
+	}
+	buckets.round()
+	return buckets
+}
+
+// SupplierAging أعمار رصيد مورد واحد في تقرير الأعمار
+type SupplierAging struct {
+	SupplierID   uuid.UUID `json:"supplier_id"`
+	SupplierName string    `json:"supplier_name"`
+	AgingBuckets
+}
+
+// SupplierAgingReport تقرير أعمار أرصدة الموردين في تاريخ معين
+// الموردون الذين لا رصيد لهم في ذلك التاريخ لا يظهرون.
+func SupplierAgingReport(db *gorm.DB, asOf time.Time) ([]SupplierAging, AgingBuckets, error) {
+	var entries []models.SupplierLedgerEntry
+	if err := db.Where("entry_date <= ?", asOf).Order("entry_date ASC, created_at ASC").Find(&entries).Error; err != nil {
+		return nil, AgingBuckets{}, err
+	}
+	grouped := map[uuid.UUID][]models.SupplierLedgerEntry{}
+	for _, entry := range entries {
+		grouped[entry.SupplierID] = append(grouped[entry.SupplierID], entry)
+	}
+
+	var suppliers []models.Supplier
+	if err := db.Select("id", "name").Order("name ASC").Find(&suppliers).Error; err != nil {
+		return nil, AgingBuckets{}, err
+	}
+	var rows []SupplierAging
+	var totals AgingBuckets
+	for _, supplier := range suppliers {
+		buckets := AgeSupplierEntries(grouped[supplier.ID], asOf)
+		if buckets == (AgingBuckets{}) {
+			continue
+		}
+		rows = append(rows, SupplierAging{SupplierID: supplier.ID, SupplierName: supplier.Name, AgingBuckets: buckets})
+		totals.Days0To30 += buckets.Days0To30
+		totals.Days31To60 += buckets.Days31To60
+		totals.Days61To90 += buckets.Days61To90
+		totals.Over90 += buckets.Over90
+		totals.Total += buckets.Total
+	}
+	totals.round()
+	return rows, totals, nil
+}
+
+// StatementLine قيد في كشف الحساب مع الرصيد بعده
+type StatementLine struct {
+	models.SupplierLedgerEntry
+	Debit   float64 `json:"debit"`  // يزيد المستحق للمورد
+	Credit  float64 `json:"credit"` // ينقص المستحق للمورد
+	Balance float64 `json:"balance"`
+}
+
+// SupplierStatement كشف حساب مورد لفترة
+type SupplierStatement struct {
+	Supplier       models.Supplier `json:"supplier"`
+	From           time.Time       `json:"from"`
+	To             time.Time       `json:"to"`
+	OpeningBalance float64         `json:"opening_balance"`
+	Lines          []StatementLine `json:"lines"`
+	TotalDebit     float64         `json:"total_debit"`
+	TotalCredit    float64         `json:"total_credit"`
+	ClosingBalance float64         `json:"closing_balance"`
+	Aging          AgingBuckets    `json:"aging"`
+}
+
+// BuildSupplierStatement بناء كشف الحساب من الرصيد الافتتاحي وقيود الفترة مرتبة
+func BuildSupplierStatement(supplier models.Supplier, opening float64, entries []models.SupplierLedgerEntry, from, to time.Time) *SupplierStatement {
+	statement := &SupplierStatement{
+		Supplier:       supplier,
+		From:           from,
+		To:             to,
+		OpeningBalance: RoundMoney(opening),
+		Lines:          make([]StatementLine, 0, len(entries)),
+	}
+	balance := statement.OpeningBalance
+	for _, entry := range entries {
+		line := StatementLine{SupplierLedgerEntry: entry}
+		if entry.Amount >= 0 {
+			line.Debit = entry.Amount
+			statement.TotalDebit += entry.Amount
+		} else {
+			line.Credit = -entry.Amount
+			statement.TotalCredit -= entry.Amount
+		}
+		balance = RoundMoney(balance + entry.Amount)
+		line.Balance = balance
+		statement.Lines = append(statement.Lines, line)
+	}
+	statement.TotalDebit = RoundMoney(statement.TotalDebit)
+	statement.TotalCredit = RoundMoney(statement.TotalCredit)
+	statement.ClosingBalance = balance
+	return statement
+}
+
+// LoadSupplierStatement تحميل كشف حساب مورد بين تاريخين شاملين مع أعمار رصيده في نهاية الفترة
+func LoadSupplierStatement(db *gorm.DB, supplierID uuid.UUID, from, to time.Time) (*SupplierStatement, error) {
+	var supplier models.Supplier
+	if err := db.First(&supplier, "id = ?", supplierID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSupplierNotFound
+		}
+		return nil, err
+	}
+
+	var opening float64
+	if err := db.Model(&models.SupplierLedgerEntry{}).
+		Where("supplier_id = ? AND entry_date < ?", supplierID, from).
+		Select("COALESCE(SUM(amount), 0)").
+		Scan(&opening).Error; err != nil {
+		return nil, err
+	}
+	var history []models.SupplierLedgerEntry
+	if err := db.Where("supplier_id = ? AND entry_date <= ?", supplierID, to).
+		Order("entry_date ASC, created_at ASC").
+		Find(&history).Error; err != nil {
+		return nil, err
+	}
+
+	var period []models.SupplierLedgerEntry
+	for _, entry := range history {
+		if !entry.EntryDate.Before(from) {
+			period = append(period, entry)
+		}
+	}
+	statement := BuildSupplierStatement(supplier, opening, period, from, to)
+	statement.Aging = AgeSupplierEntries(history, to)
+	return statement, nil
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"pharmacy-backend/models"
+)
+
+func TestAgeSupplierEntries(t *testing.T) {
+	asOf := time.Date(2025, 6, 30, 0, 0, 0, 0, time.UTC)
+	daysAgo := func(days int) time.Time { return asOf.AddDate(0, 0, -days) }
+	voidedID := uuid.New()
+	entries := []models.SupplierLedgerEntry{
+		{EntryType: models.SupplierEntryInvoice, EntryDate: daysAgo(120), Amount: 100},
+		{EntryType: models.SupplierEntryInvoice, EntryDate: daysAgo(75), Amount: 200},
+		{EntryType: models.SupplierEntryInvoice, EntryDate: daysAgo(45), Amount: 300, PurchaseInvoiceID: &voidedID},
+		{EntryType: models.SupplierEntryInvoice, EntryDate: daysAgo(10), Amount: 400},
+		{EntryType: models.SupplierEntryPayment, EntryDate: daysAgo(5), Amount: -150},
+		{EntryType: models.SupplierEntryInvoiceVoid, EntryDate: daysAgo(2), Amount: -300, PurchaseInvoiceID: &voidedID},
+		{EntryType: models.SupplierEntryInvoice, EntryDate: asOf.AddDate(0, 0, 1), Amount: 999},
+	}
+
+	// الإلغاء يُسقط فاتورته فقط، والدفعة تسدد الأقدم أولاً، والقيود اللاحقة لا تدخل
+	buckets := AgeSupplierEntries(entries, asOf)
+	assert.Equal(t, 0.0, buckets.Over90)
+	assert.Equal(t, 150.0, buckets.Days61To90)
+	assert.Equal(t, 0.0, buckets.Days31To60)
+	assert.Equal(t, 400.0, buckets.Days0To30)
+	assert.Equal(t, 550.0, buckets.Total)
+
+	// الدفعة الزائدة تظهر رصيداً سالباً في الفئة الأحدث
+	overpaid := AgeSupplierEntries([]models.SupplierLedgerEntry{
+		{EntryType: models.SupplierEntryInvoice, EntryDate: daysAgo(100), Amount: 50},
+		{EntryType: models.SupplierEntryPayment, EntryDate: daysAgo(1), Amount: -80},
+	}, asOf)
+	assert.Equal(t, AgingBuckets{Days0To30: -30, Total: -30}, overpaid)
+}
+
+func TestBuildSupplierStatement(t *testing.T) {
+	from := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2025, 6, 30, 0, 0, 0, 0, time.UTC)
+	statement := BuildSupplierStatement(models.Supplier{Name: "مورد"}, 250, []models.SupplierLedgerEntry{
+		{EntryType: models.SupplierEntryInvoice, EntryDate: from.AddDate(0, 0, 3), Amount: 115},
+		{EntryType: models.SupplierEntryPayment, EntryDate: from.AddDate(0, 0, 10), Amount: -200},
+		{EntryType: models.SupplierEntryReturn, EntryDate: from.AddDate(0, 0, 12), Amount: -15.5},
+	}, from, to)
+
+	require.Len(t, statement.Lines, 3)
+	assert.Equal(t, 115.0, statement.Lines[0].Debit)
+	assert.Equal(t, 365.0, statement.Lines[0].Balance)
+	assert.Equal(t, 200.0, statement.Lines[1].Credit)
+	assert.Equal(t, 149.5, statement.Lines[2].Balance)
+	assert.Equal(t, 115.0, statement.TotalDebit)
+	assert.Equal(t, 215.5, statement.TotalCredit)
+	assert.Equal(t, 149.5, statement.ClosingBalance)
+}
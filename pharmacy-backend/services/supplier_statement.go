@@ -0,0 +1,139 @@
+package services
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+
+	"github.com/go-pdf/fpdf"
+)
+
+// statementMoney تنسيق مبلغ في الكشف؛ الخانة الفارغة أوضح من الصفر في عمودي المدين والدائن
+func statementMoney(v float64, blankZero bool) string {
+	if blankZero && v == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%.2f", v)
+}
+
+// RenderSupplierStatementCSV كتابة كشف حساب المورد بصيغة CSV
+// يبدأ برصيد أول المدة وينتهي برصيد آخرها حتى يطابق الكشف الورقي.
+func RenderSupplierStatementCSV(statement *SupplierStatement, w io.Writer) error {
+	writer := csv.NewWriter(w)
+	rows := [][]string{
+		{"date", "type", "reference", "description", "debit", "credit", "balance"},
+		{statement.From.Format("2006-01-02"), "opening_balance", "", "", "", "", statementMoney(statement.OpeningBalance, false)},
+	}
+	for _, line := range statement.Lines {
+		rows = append(rows, []string{
+			line.EntryDate.Format("2006-01-02"),
+			string(line.EntryType),
+			line.ReferenceNumber,
+			line.Description,
+			statementMoney(line.Debit, true),
+			statementMoney(line.Credit, true),
+			statementMoney(line.Balance, false),
+		})
+	}
+	rows = append(rows, []string{
+		statement.To.Format("2006-01-02"), "closing_balance", "", "",
+		statementMoney(statement.TotalDebit, false),
+		statementMoney(statement.TotalCredit, false),
+		statementMoney(statement.ClosingBalance, false),
+	})
+	if err := writer.WriteAll(rows); err != nil {
+		return err
+	}
+	return writer.Error()
+}
+
+// RenderSupplierStatementPDF رسم كشف حساب المورد كملف PDF مع أعمار الرصيد
+// يستخدم نفس الخط الذي تحدده INVOICE_PDF_FONT لطباعة الأسماء العربية.
+func RenderSupplierStatementPDF(statement *SupplierStatement, w io.Writer) error {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	family := "Helvetica"
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	if fontPath := envString("INVOICE_PDF_FONT", ""); fontPath != "" {
+		pdf.AddUTF8Font("InvoiceFont", "", fontPath)
+		pdf.AddUTF8Font("InvoiceFont", "B", fontPath)
+		family = "InvoiceFont"
+		tr = func(s string) string { return s }
+	}
+	pdf.SetMargins(15, 15, 15)
+	pdf.AddPage()
+
+	pdf.SetFont(family, "B", 16)
+	pdf.CellFormat(180, 10, "Supplier Statement", "", 1, "L", false, 0, "")
+
+	field := func(label, value string) {
+		if value == "" {
+			return
+		}
+		pdf.SetFont(family, "B", 10)
+		pdf.CellFormat(40, 6, label, "", 0, "L", false, 0, "")
+		pdf.SetFont(family, "", 10)
+		pdf.CellFormat(140, 6, tr(value), "", 1, "L", false, 0, "")
+	}
+	field("Supplier", statement.Supplier.Name)
+	field("VAT no.", statement.Supplier.TaxNumber)
+	field("Period", fmt.Sprintf("%s - %s", statement.From.Format("2006-01-02"), statement.To.Format("2006-01-02")))
+	field("Opening balance", statementMoney(statement.OpeningBalance, false))
+	pdf.Ln(4)
+
+	headers := []string{"Date", "Type", "Reference", "Description", "Debit", "Credit", "Balance"}
+	widths := []float64{20, 22, 28, 50, 20, 20, 20}
+	pdf.SetFont(family, "B", 9)
+	pdf.SetFillColor(235, 235, 235)
+	for i, h := range headers {
+		pdf.CellFormat(widths[i], 7, h, "1", 0, "C", true, 0, "")
+	}
+	pdf.Ln(-1)
+
+	pdf.SetFont(family, "", 8)
+	for _, line := range statement.Lines {
+		cells := []string{
+			line.EntryDate.Format("2006-01-02"),
+			string(line.EntryType),
+			tr(line.ReferenceNumber),
+			tr(line.Description),
+			statementMoney(line.Debit, true),
+			statementMoney(line.Credit, true),
+			statementMoney(line.Balance, false),
+		}
+		for i, cell := range cells {
+			align := "L"
+			if i >= 4 {
+				align = "R"
+			}
+			pdf.CellFormat(widths[i], 7, cell, "1", 0, align, false, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+	pdf.SetFont(family, "B", 9)
+	pdf.CellFormat(120, 7, "Totals", "1", 0, "R", false, 0, "")
+	pdf.CellFormat(20, 7, statementMoney(statement.TotalDebit, false), "1", 0, "R", false, 0, "")
+	pdf.CellFormat(20, 7, statementMoney(statement.TotalCredit, false), "1", 0, "R", false, 0, "")
+	pdf.CellFormat(20, 7, statementMoney(statement.ClosingBalance, false), "1", 1, "R", false, 0, "")
+	pdf.Ln(6)
+
+	pdf.SetFont(family, "B", 10)
+	pdf.CellFormat(180, 7, "Aging", "", 1, "L", false, 0, "")
+	aging := statement.Aging
+	agingHeaders := []string{"0-30", "31-60", "61-90", "90+", "Total"}
+	agingValues := []float64{aging.Days0To30, aging.Days31To60, aging.Days61To90, aging.Over90, aging.Total}
+	pdf.SetFont(family, "B", 9)
+	for _, h := range agingHeaders {
+		pdf.CellFormat(36, 7, h, "1", 0, "C", true, 0, "")
+	}
+	pdf.Ln(-1)
+	pdf.SetFont(family, "", 9)
+	for _, v := range agingValues {
+		pdf.CellFormat(36, 7, statementMoney(v, false), "1", 0, "R", false, 0, "")
+	}
+	pdf.Ln(-1)
+
+	if err := pdf.Error(); err != nil {
+		return err
+	}
+	return pdf.Output(w)
+}